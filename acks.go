@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/processor"
+)
+
+// pipelineAcks, set with pipeline.acks.enabled, lets processors acknowledge
+// users after Process returns, once downstream has durably received them.
+// Checkpoints, the ledger and watermarks only advance past a user once it
+// is acknowledged. Without it, processors that hand users off must wait for
+// downstream before returning.
+var pipelineAcks bool
+
+// pipelineAckTimeout is how long a deferred acknowledgment is waited for
+// before the user counts as failed.
+var pipelineAckTimeout = 5 * time.Minute
+
+// ackGroup collects the deferred acknowledgments of the users handed off
+// under one mailbox, or one watch poll, so that its progress is only saved
+// once they are all in.
+type ackGroup struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	failed int
+	first  error
+}
+
+type ackGroupKey struct{}
+
+// withAckGroup returns a context collecting the deferred acknowledgments of
+// the users processed under it, and the group to wait on; the group is nil
+// unless pipelineAcks is set.
+func withAckGroup(ctx context.Context) (context.Context, *ackGroup) {
+	if !pipelineAcks {
+		return ctx, nil
+	}
+	g := &ackGroup{}
+	return context.WithValue(ctx, ackGroupKey{}, g), g
+}
+
+// newAck returns a context for processing one user and the Ack the
+// processor may defer, or ctx and nil unless pipelineAcks is set.
+func newAck(ctx context.Context) (context.Context, *processor.Ack) {
+	if !pipelineAcks {
+		return ctx, nil
+	}
+	ack := processor.NewAck()
+	return processor.WithAck(ctx, ack), ack
+}
+
+// deferAck waits for the acknowledgment the processor deferred for user: in
+// the background if ctx collects acknowledgments, and otherwise before
+// returning. It reports the user as processed unless the wait failed.
+func deferAck(ctx context.Context, user db.User, prev db.Retry, ack *processor.Ack) (bool, error) {
+	g, _ := ctx.Value(ackGroupKey{}).(*ackGroup)
+	if g == nil {
+		if err := awaitAck(ctx, user, prev, ack); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := awaitAck(ctx, user, prev, ack); err != nil {
+			g.mu.Lock()
+			g.failed++
+			if g.first == nil {
+				g.first = err
+			}
+			g.mu.Unlock()
+		}
+	}()
+	return true, nil
+}
+
+// awaitAck waits up to pipelineAckTimeout for user's acknowledgment, even if
+// the run is cancelled meanwhile, and then records the user as processed.
+// A user that is not acknowledged is scheduled for another attempt.
+func awaitAck(ctx context.Context, user db.User, prev db.Retry, ack *processor.Ack) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pipelineAckTimeout)
+	defer cancel()
+	if err := ack.Wait(ctx); err != nil {
+		slog.Error("User not acknowledged", "user_id", user.ID, "mailbox_id", user.MailboxID, "attempt", prev.Attempts+1, "error", err)
+		scheduleRetry(ctx, user, prev, err)
+		runFailures.user(user, err)
+		return fmt.Errorf("user %d: not acknowledged: %w", user.ID, err)
+	}
+	recordProcessed(ctx, user)
+	return nil
+}
+
+// wait blocks until every acknowledgment in the group is in, and returns
+// how many users were not acknowledged and the first of their errors. A
+// nil *ackGroup has none.
+func (g *ackGroup) wait() (int, error) {
+	if g == nil {
+		return 0, nil
+	}
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.failed, g.first
+}
@@ -0,0 +1,75 @@
+// Package aimd sizes batches from how the sink receiving them copes: each
+// batch delivered within the target latency grows the next one by a fixed
+// step, and each slow or failed batch shrinks it by a factor, as TCP does
+// with its congestion window. The size settles just below what the sink can
+// take, without hand-tuning per environment.
+package aimd
+
+import (
+	"sync"
+	"time"
+)
+
+// Config bounds a Sizer. Only Max and Target are required.
+type Config struct {
+	// Min and Max bound the batch size. Min defaults to 1.
+	Min, Max int
+	// Initial is the first batch size, clamped to the bounds. It defaults
+	// to Min.
+	Initial int
+	// Target is the delivery latency above which a batch counts as slow.
+	Target time.Duration
+	// Step is added to the size after a batch within Target. It defaults
+	// to a hundredth of the range, and at least 1.
+	Step int
+	// Backoff multiplies the size after a slow or failed batch. It
+	// defaults to 0.5.
+	Backoff float64
+}
+
+// Sizer tracks the batch size. It is safe for concurrent use.
+type Sizer struct {
+	cfg Config
+
+	mu   sync.Mutex
+	size int
+}
+
+// New returns a Sizer for cfg, filling in defaults.
+func New(cfg Config) *Sizer {
+	cfg.Min = max(cfg.Min, 1)
+	cfg.Max = max(cfg.Max, cfg.Min)
+	if cfg.Step <= 0 {
+		cfg.Step = max((cfg.Max-cfg.Min)/100, 1)
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.5
+	}
+	return &Sizer{cfg: cfg, size: min(max(cfg.Initial, cfg.Min), cfg.Max)}
+}
+
+// Fixed returns a Sizer that always returns n.
+func Fixed(n int) *Sizer {
+	return New(Config{Min: n, Max: n})
+}
+
+// Size returns the size of the next batch.
+func (s *Sizer) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Observe records how delivering a batch went and returns the size of the
+// next one. A Sizer without a Target never counts a batch as slow.
+func (s *Sizer) Observe(latency time.Duration, err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil || (s.cfg.Target > 0 && latency > s.cfg.Target) {
+		s.size = max(int(float64(s.size)*s.cfg.Backoff), s.cfg.Min)
+	} else {
+		s.size = min(s.size+s.cfg.Step, s.cfg.Max)
+	}
+	return s.size
+}
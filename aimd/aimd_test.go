@@ -0,0 +1,58 @@
+package aimd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSizer(t *testing.T) {
+	s := New(Config{Min: 10, Max: 100, Initial: 50, Target: time.Second, Step: 20})
+
+	steps := []struct {
+		name     string
+		latency  time.Duration
+		err      error
+		expected int
+	}{
+		{name: "Fast batch grows", latency: 100 * time.Millisecond, expected: 70},
+		{name: "Grows again", latency: 100 * time.Millisecond, expected: 90},
+		{name: "Clamped to Max", latency: 100 * time.Millisecond, expected: 100},
+		{name: "Slow batch halves", latency: 2 * time.Second, expected: 50},
+		{name: "Failed batch halves", err: errors.New("sink unavailable"), expected: 25},
+		{name: "Fails again", err: errors.New("sink unavailable"), expected: 12},
+		{name: "Clamped to Min", latency: 2 * time.Second, expected: 10},
+		{name: "Recovers additively", latency: 100 * time.Millisecond, expected: 30},
+	}
+
+	for _, step := range steps {
+		if got := s.Observe(step.latency, step.err); got != step.expected {
+			t.Fatalf("%s: expected size %d, got %d", step.name, step.expected, got)
+		}
+		if s.Size() != step.expected {
+			t.Fatalf("%s: expected Size %d, got %d", step.name, step.expected, s.Size())
+		}
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	s := New(Config{Max: 1001, Target: time.Second})
+	if s.Size() != 1 {
+		t.Errorf("Expected to start at the default Min of 1, got %d", s.Size())
+	}
+	if got := s.Observe(0, nil); got != 11 {
+		t.Errorf("Expected a default step of a hundredth of the range, got size %d", got)
+	}
+	if got := s.Observe(0, errors.New("failed")); got != 5 {
+		t.Errorf("Expected the default backoff to halve the size, got %d", got)
+	}
+}
+
+func TestFixed(t *testing.T) {
+	s := Fixed(500)
+	s.Observe(time.Hour, nil)
+	s.Observe(0, errors.New("failed"))
+	if s.Size() != 500 {
+		t.Errorf("Expected a fixed size of 500, got %d", s.Size())
+	}
+}
@@ -0,0 +1,65 @@
+// Package annotations lets processors and pipeline scripts attach key-value
+// annotations to the run they are part of, such as the campaign or template
+// version a run sent. The run records them with its reports.
+package annotations
+
+import (
+	"context"
+	"sync"
+)
+
+// Set holds a run's annotations. It is safe for concurrent use, and a nil
+// *Set records nothing, so callers can hold one unconditionally.
+type Set struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// Add sets the annotation key to value, replacing any earlier value. Empty
+// keys are ignored.
+func (s *Set) Add(key, value string) {
+	if s == nil || key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	s.values[key] = value
+}
+
+// Map returns a copy of the annotations, or nil if there are none.
+func (s *Set) Map() map[string]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		m[k] = v
+	}
+	return m
+}
+
+type setKey struct{}
+
+// NewContext returns ctx carrying s, for the work done on behalf of its run.
+func NewContext(ctx context.Context, s *Set) context.Context {
+	return context.WithValue(ctx, setKey{}, s)
+}
+
+// FromContext returns the Set ctx carries, or nil.
+func FromContext(ctx context.Context) *Set {
+	s, _ := ctx.Value(setKey{}).(*Set)
+	return s
+}
+
+// Annotate sets key to value on the run ctx belongs to, if any.
+func Annotate(ctx context.Context, key, value string) {
+	FromContext(ctx).Add(key, value)
+}
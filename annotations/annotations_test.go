@@ -0,0 +1,46 @@
+package annotations
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	s := &Set{}
+	ctx := NewContext(context.Background(), s)
+
+	var wg sync.WaitGroup
+	for _, version := range []string{"v1", "v2", "v3"} {
+		wg.Add(1)
+		go func(version string) {
+			defer wg.Done()
+			Annotate(ctx, "template_version", version)
+		}(version)
+	}
+	wg.Wait()
+	Annotate(ctx, "template_version", "v4")
+	Annotate(ctx, "campaign_id", "spring")
+	Annotate(ctx, "", "ignored")
+
+	expected := map[string]string{"campaign_id": "spring", "template_version": "v4"}
+	got := s.Map()
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	got["campaign_id"] = "changed"
+	if s.Map()["campaign_id"] != "spring" {
+		t.Error("Expected Map to return a copy")
+	}
+}
+
+func TestAnnotate_NoSet(t *testing.T) {
+	Annotate(context.Background(), "campaign_id", "spring")
+	if m := FromContext(context.Background()).Map(); m != nil {
+		t.Errorf("Expected no annotations, got %v", m)
+	}
+	if m := (&Set{}).Map(); m != nil {
+		t.Errorf("Expected nil for an empty set, got %v", m)
+	}
+}
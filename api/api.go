@@ -0,0 +1,733 @@
+// Package api serves mailbox and user data over HTTP as JSON, so other
+// services can read it without direct database access.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailboxes/capacity"
+	"mailboxes/cursor"
+	"mailboxes/db"
+	"mailboxes/processor"
+	"mailboxes/publicid"
+	"mailboxes/scope"
+)
+
+// Mailbox is the public representation of a mailbox. Tokens are never
+// exposed.
+type Mailbox struct {
+	ID        string `json:"id"`
+	MPIID     string `json:"mpi_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+type User struct {
+	ID           string `json:"id"`
+	MailboxID    string `json:"mailbox_id"`
+	UserName     string `json:"user_name"`
+	EmailAddress string `json:"email_address"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// Error is the body of every error response, inside an "error" envelope:
+// {"error": {"code": "not_found", "message": "mailbox not found"}}. Code is
+// derived from the HTTP status, except for quota_exceeded.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Page sizes for the list routes, set with ?limit=. maxPageSize also caps
+// the first argument of paginated GraphQL fields.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+// Server answers API requests from a Store. IDs in paths and responses are
+// encoded with ids, so raw database IDs never leave the server.
+type Server struct {
+	store db.Store
+	ids   *publicid.Codec
+	// jobs runs bulk jobs; the /jobs routes are disabled when it is nil.
+	jobs *Jobs
+	// statsSnapshot is where the stats command saves its last report.
+	statsSnapshot string
+	// cache, if set, serves mailboxes' users in place of store.
+	cache Cache
+	// cursors issues and checks the ?after= cursors of list routes.
+	cursors *cursor.Codec
+	// processors switches between processor configurations; the /processor
+	// routes are disabled when it is nil.
+	processors *processor.Switch
+}
+
+// MailboxSize is the public representation of a mailbox's user count.
+type MailboxSize struct {
+	MailboxID string `json:"mailbox_id"`
+	Users     int64  `json:"users"`
+}
+
+// MailboxHealth is the public representation of a mailbox's health summary.
+type MailboxHealth struct {
+	MailboxID       string   `json:"mailbox_id"`
+	HealthScore     int      `json:"health_score"`
+	TokenValid      bool     `json:"token_valid"`
+	Verification    string   `json:"verification"`
+	FailureRate     float64  `json:"failure_rate"`
+	LastProcessedAt string   `json:"last_processed_at,omitempty"`
+	Reasons         []string `json:"reasons"`
+	ComputedAt      string   `json:"computed_at"`
+}
+
+// Stats is the public representation of a capacity report.
+type Stats struct {
+	TakenAt          time.Time         `json:"taken_at"`
+	DatabaseBytes    int64             `json:"database_bytes"`
+	Tables           []db.TableStats   `json:"tables"`
+	LargestMailboxes []MailboxSize     `json:"largest_mailboxes"`
+	Since            *time.Time        `json:"since,omitempty"`
+	Growth           []capacity.Growth `json:"growth,omitempty"`
+}
+
+func NewServer(store db.Store, ids *publicid.Codec, jobs *Jobs) *Server {
+	return &Server{store: store, ids: ids, jobs: jobs, cursors: cursor.New("")}
+}
+
+// SetCursors has list routes sign their cursors with c, in place of the
+// default unkeyed codec.
+func (s *Server) SetCursors(c *cursor.Codec) {
+	s.cursors = c
+}
+
+// SetStatsSnapshot has GET /stats report growth since the snapshot the stats
+// command saved at path.
+func (s *Server) SetStatsSnapshot(path string) {
+	s.statsSnapshot = path
+}
+
+// Cache is a cache in front of the store that the API reads mailboxes'
+// users through and drops entries from as it writes.
+type Cache interface {
+	db.Store
+	Invalidate(mailboxID int)
+	InvalidateMailboxes()
+}
+
+// SetCache has GET /mailboxes/{id}/users read through c.
+func (s *Server) SetCache(c Cache) {
+	s.cache = c
+}
+
+// SetProcessors has the /processor routes report and change the active
+// configuration of sw, for requests made with an admin key.
+func (s *Server) SetProcessors(sw *processor.Switch) {
+	s.processors = sw
+}
+
+// formatTime formats t as RFC 3339 in UTC, or the empty string if it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (s *Server) mailbox(mb db.Mailbox) Mailbox {
+	return Mailbox{ID: s.ids.Encode(mb.ID), MPIID: mb.MPIID, CreatedAt: formatTime(mb.CreatedAt)}
+}
+
+func (s *Server) user(u db.User) User {
+	return User{
+		ID:           s.ids.Encode(u.ID),
+		MailboxID:    s.ids.Encode(u.MailboxID),
+		UserName:     u.UserName,
+		EmailAddress: u.EmailAddress,
+		CreatedAt:    formatTime(u.CreatedAt),
+	}
+}
+
+// ServeHTTP routes:
+//
+//	GET /mailboxes
+//	POST /mailboxes
+//	GET /mailboxes/{id}/users
+//	GET /mailboxes/{id}/health
+//	GET /health/mailboxes
+//	DELETE /users/{id}
+//	POST /users/{id}/enqueue
+//	POST /jobs
+//	GET /jobs/{id}
+//	GET /stats
+//	GET /processor
+//	PUT /processor
+//	POST /processor/rollback
+//
+// The list routes return pages of ?limit= items (default 100, at most 500)
+// in ID order. When there are more, a Link header gives the URL of the next
+// page, whose ?after= cursor is the ID of the last item returned.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "mailboxes":
+		s.dispatch(w, r, methods{http.MethodGet: s.listMailboxes, http.MethodPost: s.createMailbox})
+	case len(parts) == 3 && parts[0] == "mailboxes" && parts[2] == "users":
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			s.listUsers(w, r, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "mailboxes" && parts[2] == "health":
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			s.getMailboxHealth(w, r, parts[1])
+		})
+	case len(parts) == 2 && parts[0] == "health" && parts[1] == "mailboxes":
+		s.allow(w, r, http.MethodGet, s.listMailboxHealth)
+	case len(parts) == 2 && parts[0] == "users":
+		s.allow(w, r, http.MethodDelete, func(w http.ResponseWriter, r *http.Request) {
+			s.deleteUser(w, r, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "enqueue":
+		s.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			s.enqueueUser(w, r, parts[1])
+		})
+	case len(parts) == 1 && parts[0] == "jobs" && s.jobs != nil:
+		s.allow(w, r, http.MethodPost, s.submitJob)
+	case len(parts) == 2 && parts[0] == "jobs" && s.jobs != nil:
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			s.getJob(w, r, parts[1])
+		})
+	case len(parts) == 1 && parts[0] == "stats":
+		s.allow(w, r, http.MethodGet, s.getStats)
+	case len(parts) == 1 && parts[0] == "processor" && s.processors != nil:
+		s.dispatch(w, r, methods{http.MethodGet: adminOnly(s.getProcessor), http.MethodPut: adminOnly(s.putProcessor)})
+	case len(parts) == 2 && parts[0] == "processor" && parts[1] == "rollback" && s.processors != nil:
+		s.allow(w, r, http.MethodPost, adminOnly(s.rollbackProcessor))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// methods maps the HTTP methods a route accepts to their handlers.
+type methods map[string]http.HandlerFunc
+
+func (s *Server) dispatch(w http.ResponseWriter, r *http.Request, m methods) {
+	h, ok := m[r.Method]
+	if !ok {
+		allowed := make([]string, 0, len(m))
+		for method := range m {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	h(w, r)
+}
+
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, method string, h http.HandlerFunc) {
+	s.dispatch(w, r, methods{method: h})
+}
+
+// page reads the ?limit= and ?after= parameters of a request for a page of
+// listing. It writes a 400 response and returns false if either is invalid,
+// with the code cursor_expired for a cursor issued before paging changed.
+func (s *Server) page(w http.ResponseWriter, r *http.Request, listing string) (afterID, limit int, ok bool) {
+	limit = defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("after"); v != "" {
+		id, err := decodeCursor(s.cursors, s.ids, listing, v)
+		if errors.Is(err, cursor.ErrExpired) {
+			writeErrorCode(w, http.StatusBadRequest, "cursor_expired", "cursor expired; fetch the first page again")
+			return 0, 0, false
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return 0, 0, false
+		}
+		afterID = id
+	}
+	return afterID, limit, true
+}
+
+// decodeCursor returns the ID the page of listing that token names starts
+// after. Cursors used to be bare public IDs; those are reported as expired.
+func decodeCursor(cursors *cursor.Codec, ids *publicid.Codec, listing, token string) (int, error) {
+	id, err := cursors.Decode(listing, token)
+	if errors.Is(err, cursor.ErrInvalid) {
+		if _, idErr := ids.Decode(token); idErr == nil {
+			return 0, cursor.ErrExpired
+		}
+	}
+	return id, err
+}
+
+// Listings that cursors are issued for.
+const mailboxesListing = "mailboxes"
+
+func usersListing(mailboxID int) string {
+	return "users:" + strconv.Itoa(mailboxID)
+}
+
+// setNextPage links to the page of listing after lastID.
+func (s *Server) setNextPage(w http.ResponseWriter, r *http.Request, listing string, lastID, limit int) {
+	next := url.URL{Path: r.URL.Path, RawQuery: url.Values{
+		"after": {s.cursors.Encode(listing, lastID)},
+		"limit": {strconv.Itoa(limit)},
+	}.Encode()}
+	w.Header().Set("Link", "<"+next.String()+`>; rel="next"`)
+}
+
+func (s *Server) listMailboxes(w http.ResponseWriter, r *http.Request) {
+	afterID, limit, ok := s.page(w, r, mailboxesListing)
+	if !ok {
+		return
+	}
+
+	page, err := mailboxesAfter(r.Context(), s.store, afterID, limit+1, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error retrieving mailboxes")
+		return
+	}
+	more := len(page) > limit
+	if more {
+		page = page[:limit]
+	}
+
+	mailboxes := []Mailbox{}
+	for _, mb := range page {
+		mailboxes = append(mailboxes, s.mailbox(mb))
+	}
+	if more {
+		s.setNextPage(w, r, mailboxesListing, page[len(page)-1].ID, limit)
+	}
+	writeJSON(w, http.StatusOK, mailboxes)
+}
+
+// createMailbox creates a mailbox from {"mpi_id": ..., "token": ...} and
+// answers 201 Created with the new mailbox. A mailbox created in a request
+// scoped to a tenant belongs to that tenant, and a tenant at its mailbox
+// quota gets 403 Forbidden with the code quota_exceeded.
+func (s *Server) createMailbox(w http.ResponseWriter, r *http.Request) {
+	ms, ok := s.store.(db.MailboxStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "creating mailboxes is not supported by this store")
+		return
+	}
+
+	var req struct {
+		MPIID string `json:"mpi_id"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid mailbox")
+		return
+	}
+	if req.MPIID == "" {
+		writeError(w, http.StatusBadRequest, "mpi_id is required")
+		return
+	}
+
+	if _, ok := s.store.(db.QuotaStore); !ok && scope.Tenant(r.Context()) != "" {
+		writeError(w, http.StatusNotImplemented, "tenants are not supported by this store")
+		return
+	}
+
+	mb, err := ms.CreateMailbox(r.Context(), db.Mailbox{MPIID: req.MPIID, Token: req.Token})
+	if errors.Is(err, db.ErrQuotaExceeded) {
+		writeErrorCode(w, http.StatusForbidden, "quota_exceeded", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error creating mailbox")
+		return
+	}
+
+	if s.cache != nil {
+		s.cache.InvalidateMailboxes()
+	}
+	created := s.mailbox(mb)
+	w.Header().Set("Location", "/mailboxes/"+created.ID+"/users")
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request, publicID string) {
+	mailboxID, err := s.ids.Decode(publicID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "mailbox not found")
+		return
+	}
+	afterID, limit, ok := s.page(w, r, usersListing(mailboxID))
+	if !ok {
+		return
+	}
+
+	var source db.Store = s.store
+	if s.cache != nil {
+		source = s.cache
+	}
+	page, err := usersAfter(r.Context(), source, mailboxID, afterID, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error retrieving users")
+		return
+	}
+	more := len(page) > limit
+	if more {
+		page = page[:limit]
+	}
+
+	users := []User{}
+	for _, u := range page {
+		users = append(users, s.user(u))
+	}
+	if more {
+		s.setNextPage(w, r, usersListing(mailboxID), page[len(page)-1].ID, limit)
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// deleteUser deletes a user and answers 204 No Content.
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request, publicID string) {
+	us, ok := s.store.(db.UserStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "deleting users is not supported by this store")
+		return
+	}
+	id, err := s.ids.Decode(publicID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	user, err := us.GetUserByID(r.Context(), id)
+	if err == nil {
+		err = us.DeleteUser(r.Context(), id)
+	}
+	if errors.Is(err, db.ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error deleting user")
+		return
+	}
+
+	if s.cache != nil {
+		s.cache.Invalidate(user.MailboxID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enqueueUser puts a user on the work queue, for a running watcher to
+// process ahead of its regular poll.
+func (s *Server) enqueueUser(w http.ResponseWriter, r *http.Request, publicID string) {
+	queue, ok := s.store.(db.QueueStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "the work queue is not supported by this store")
+		return
+	}
+	id, err := s.ids.Decode(publicID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if us, ok := s.store.(db.UserStore); ok {
+		if _, err := us.GetUserByID(r.Context(), id); errors.Is(err, db.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "error looking up user")
+			return
+		}
+	}
+	if err := queue.EnqueueUser(r.Context(), id); err != nil {
+		writeError(w, http.StatusInternalServerError, "error enqueueing user")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// mailboxesAfter returns up to limit mailboxes with IDs above afterID, in ID
+// order, optionally only those with the given MPI ID. Stores that page read
+// just the rows needed; others are read in full and sorted.
+func mailboxesAfter(ctx context.Context, store db.Store, afterID, limit int, mpiID *string) ([]db.Mailbox, error) {
+	if ps, ok := store.(db.PageStore); ok && mpiID == nil {
+		return ps.MailboxesAfter(ctx, afterID, limit)
+	}
+
+	mailboxChan, err := store.AllMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mailboxes []db.Mailbox
+	for mb := range mailboxChan {
+		if mb.ID > afterID && (mpiID == nil || mb.MPIID == *mpiID) {
+			mailboxes = append(mailboxes, mb)
+		}
+	}
+	sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].ID < mailboxes[j].ID })
+	if len(mailboxes) > limit {
+		mailboxes = mailboxes[:limit]
+	}
+	return mailboxes, nil
+}
+
+// usersAfter is mailboxesAfter for a mailbox's users.
+func usersAfter(ctx context.Context, store db.Store, mailboxID, afterID, limit int) ([]db.User, error) {
+	if ps, ok := store.(db.PageStore); ok {
+		return ps.UsersForMailboxAfter(ctx, mailboxID, afterID, limit)
+	}
+
+	userChan, err := store.UsersForMailbox(ctx, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []db.User
+	for u := range userChan {
+		if u.ID > afterID {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *Server) mailboxHealth(sum db.MailboxSummary) MailboxHealth {
+	reasons := sum.Reasons
+	if reasons == nil {
+		reasons = []string{}
+	}
+	return MailboxHealth{
+		MailboxID:       s.ids.Encode(sum.MailboxID),
+		HealthScore:     sum.HealthScore,
+		TokenValid:      sum.TokenValid,
+		Verification:    sum.Verification,
+		FailureRate:     sum.FailureRate,
+		LastProcessedAt: formatTime(sum.LastProcessedAt),
+		Reasons:         reasons,
+		ComputedAt:      formatTime(sum.ComputedAt),
+	}
+}
+
+// getMailboxHealth returns the health summary last computed for a mailbox
+// by the health command.
+func (s *Server) getMailboxHealth(w http.ResponseWriter, r *http.Request, publicID string) {
+	hs, ok := s.store.(db.HealthStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "mailbox health is not supported by this store")
+		return
+	}
+	mailboxID, err := s.ids.Decode(publicID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "mailbox not found")
+		return
+	}
+
+	sum, err := hs.MailboxSummary(r.Context(), mailboxID)
+	if errors.Is(err, db.ErrNoSummary) {
+		writeError(w, http.StatusNotFound, "no health summary for mailbox")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error retrieving mailbox health")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.mailboxHealth(sum))
+}
+
+// listMailboxHealth returns up to ?limit= health summaries, least healthy
+// first, so the mailboxes most in need of remediation come first.
+func (s *Server) listMailboxHealth(w http.ResponseWriter, r *http.Request) {
+	hs, ok := s.store.(db.HealthStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "mailbox health is not supported by this store")
+		return
+	}
+	_, limit, ok := s.page(w, r, "health")
+	if !ok {
+		return
+	}
+
+	summaries, err := hs.MailboxSummaries(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error retrieving mailbox health")
+		return
+	}
+	resp := []MailboxHealth{}
+	for _, sum := range summaries {
+		resp = append(resp, s.mailboxHealth(sum))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// defaultStatsTop is how many of the largest mailboxes GET /stats lists
+// unless ?top= says otherwise.
+const defaultStatsTop = 10
+
+// getStats reports table sizes and the largest mailboxes, with growth since
+// the last snapshot saved by the stats command. It never saves a snapshot.
+// Requests scoped to a tenant get the tenant's counts without growth, as the
+// snapshot covers every tenant.
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	ss, ok := s.store.(db.StatsStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "statistics are not supported by this store")
+		return
+	}
+
+	top := defaultStatsTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 1000 {
+			writeError(w, http.StatusBadRequest, "top must be between 0 and 1000")
+			return
+		}
+		top = n
+	}
+
+	stats, err := ss.Stats(r.Context(), top)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error retrieving statistics")
+		return
+	}
+
+	var prev *capacity.Report
+	if s.statsSnapshot != "" && scope.Tenant(r.Context()) == "" {
+		if prev, err = capacity.Load(s.statsSnapshot); err != nil {
+			slog.Error("Error loading stats snapshot", "path", s.statsSnapshot, "error", err)
+		}
+	}
+	report := capacity.New(stats, time.Now(), prev)
+
+	resp := Stats{
+		TakenAt:          report.TakenAt,
+		DatabaseBytes:    report.DatabaseBytes,
+		Tables:           report.Tables,
+		LargestMailboxes: []MailboxSize{},
+		Since:            report.Since,
+		Growth:           report.Growth,
+	}
+	for _, m := range report.LargestMailboxes {
+		resp.LargestMailboxes = append(resp.LargestMailboxes, MailboxSize{MailboxID: s.ids.Encode(m.MailboxID), Users: m.Users})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// submitJob queues a bulk job described by {"kind": ..., "params": {...}}
+// and answers 202 Accepted with the job to poll.
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind   string          `json:"kind"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid job request")
+		return
+	}
+
+	job, err := s.jobs.Submit(req.Kind, req.Params)
+	if errors.Is(err, ErrUnknownJobKind) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request, id string) {
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// ProcessorState is the public representation of the processor switch.
+type ProcessorState struct {
+	Active         string   `json:"active"`
+	Configurations []string `json:"configurations"`
+}
+
+func (s *Server) processorState() ProcessorState {
+	return ProcessorState{Active: s.processors.Active(), Configurations: s.processors.Names()}
+}
+
+func (s *Server) getProcessor(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.processorState())
+}
+
+// putProcessor makes the configuration named by {"active": ...} active for
+// every user processed from then on, in every process sharing the store.
+func (s *Server) putProcessor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Active string `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid processor state")
+		return
+	}
+	if err := s.processors.Use(r.Context(), req.Active); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.Info("Switched processor configuration", "active", req.Active)
+	writeJSON(w, http.StatusOK, s.processorState())
+}
+
+// rollbackProcessor makes the previously active configuration active again.
+func (s *Server) rollbackProcessor(w http.ResponseWriter, r *http.Request) {
+	name, err := s.processors.Rollback(r.Context())
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	slog.Info("Rolled processor configuration back", "active", name)
+	writeJSON(w, http.StatusOK, s.processorState())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeErrorCode(w, status, strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"), msg)
+}
+
+// writeErrorCode writes an error whose code is more specific than its status.
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]Error{"error": {Code: code, Message: msg}})
+}
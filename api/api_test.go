@@ -0,0 +1,585 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailboxes/cache"
+	"mailboxes/capacity"
+	"mailboxes/cursor"
+	"mailboxes/db"
+	"mailboxes/processor"
+	"mailboxes/publicid"
+	"mailboxes/scope"
+)
+
+type fakeStore struct {
+	mailboxes []db.Mailbox
+	users     []db.User
+}
+
+func (f *fakeStore) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	ch := make(chan db.Mailbox, len(f.mailboxes))
+	for _, mb := range f.mailboxes {
+		ch <- mb
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (f *fakeStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	ch := make(chan db.User, len(f.users))
+	for _, u := range f.users {
+		if u.MailboxID == mailboxID {
+			ch <- u
+		}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func testStore() *fakeStore {
+	return &fakeStore{
+		mailboxes: []db.Mailbox{
+			{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)},
+		},
+		users: []db.User{
+			{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)},
+			{ID: 201, MailboxID: 2, UserName: "user3", EmailAddress: "user3@example.com", CreatedAt: time.Date(2024, 7, 23, 13, 15, 0, 0, time.UTC)},
+		},
+	}
+}
+
+func get(t *testing.T, h http.Handler, path string, v any) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("Error decoding %s response: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestServer_ObfuscatedIDs(t *testing.T) {
+	ids := publicid.New("secret")
+	srv := NewServer(testStore(), ids, nil)
+
+	var mailboxes []Mailbox
+	if code := get(t, srv, "/mailboxes", &mailboxes); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	expectedMailboxes := []Mailbox{{ID: ids.Encode(1), MPIID: "mpi123", CreatedAt: "2024-07-23T12:00:00Z"}}
+	if !reflect.DeepEqual(mailboxes, expectedMailboxes) {
+		t.Errorf("Expected mailboxes %v, got %v", expectedMailboxes, mailboxes)
+	}
+
+	var users []User
+	if code := get(t, srv, "/mailboxes/"+mailboxes[0].ID+"/users", &users); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	expectedUsers := []User{{ID: ids.Encode(101), MailboxID: ids.Encode(1), UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: "2024-07-23T12:30:00Z"}}
+	if !reflect.DeepEqual(users, expectedUsers) {
+		t.Errorf("Expected users %v, got %v", expectedUsers, users)
+	}
+
+	if code := get(t, srv, "/mailboxes/1/users", nil); code != http.StatusNotFound {
+		t.Errorf("Expected raw ID to be rejected with 404, got %d", code)
+	}
+}
+
+func TestServer_PlainIDs(t *testing.T) {
+	srv := NewServer(testStore(), nil, nil)
+
+	var users []User
+	if code := get(t, srv, "/mailboxes/2/users", &users); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(users) != 1 || users[0].ID != "201" {
+		t.Errorf("Expected user 201, got %v", users)
+	}
+}
+
+type statsStore struct {
+	*fakeStore
+	top int
+}
+
+func (s *statsStore) Stats(ctx context.Context, top int) (db.Stats, error) {
+	s.top = top
+	return db.Stats{
+		DatabaseBytes:    1 << 20,
+		Tables:           []db.TableStats{{Name: "users", Rows: 150}},
+		LargestMailboxes: []db.MailboxSize{{MailboxID: 1, Users: 120}},
+	}, nil
+}
+
+func TestServer_Stats(t *testing.T) {
+	ids := publicid.New("secret")
+	store := &statsStore{fakeStore: testStore()}
+	srv := NewServer(store, ids, nil)
+
+	snapshot := filepath.Join(t.TempDir(), "stats.json")
+	then := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	if err := capacity.Save(snapshot, capacity.Report{TakenAt: then, Stats: db.Stats{Tables: []db.TableStats{{Name: "users", Rows: 100}}}}); err != nil {
+		t.Fatalf("Error saving snapshot: %v", err)
+	}
+	srv.SetStatsSnapshot(snapshot)
+
+	var stats Stats
+	if code := get(t, srv, "/stats?top=5", &stats); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if store.top != 5 {
+		t.Errorf("Expected top 5 to be requested, got %d", store.top)
+	}
+	if !reflect.DeepEqual(stats.LargestMailboxes, []MailboxSize{{MailboxID: ids.Encode(1), Users: 120}}) {
+		t.Errorf("Unexpected largest mailboxes %v", stats.LargestMailboxes)
+	}
+	if stats.Since == nil || !stats.Since.Equal(then) || !reflect.DeepEqual(stats.Growth, []capacity.Growth{{Table: "users", Rows: 50}}) {
+		t.Errorf("Unexpected growth %v since %v", stats.Growth, stats.Since)
+	}
+
+	if code := get(t, srv, "/stats?top=-1", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative top, got %d", code)
+	}
+	if code := get(t, NewServer(testStore(), ids, nil), "/stats", nil); code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 without statistics support, got %d", code)
+	}
+}
+
+func TestServer_Pagination(t *testing.T) {
+	store := db.NewMemStore()
+	for id := 1; id <= 5; id++ {
+		store.SeedMailboxes(db.Mailbox{ID: id, MPIID: "mpi"})
+		store.SeedUsers(db.User{ID: 100 + id, MailboxID: 1})
+	}
+	srv := NewServer(store, nil, nil)
+
+	for _, path := range []string{"/mailboxes", "/mailboxes/1/users"} {
+		var seen []string
+		next := path + "?limit=2"
+		for pages := 0; next != ""; pages++ {
+			if pages == 3 {
+				t.Fatalf("%s: expected 3 pages, got more", path)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, next, nil))
+			var items []struct{ ID string }
+			if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+				t.Fatalf("%s: error decoding page: %v", next, err)
+			}
+			for _, item := range items {
+				seen = append(seen, item.ID)
+			}
+			next = ""
+			if link := rec.Header().Get("Link"); link != "" {
+				next = strings.TrimPrefix(strings.TrimSuffix(link, `>; rel="next"`), "<")
+			}
+		}
+		if len(seen) != 5 {
+			t.Errorf("%s: expected 5 items across the pages, got %v", path, seen)
+		}
+	}
+
+	if code := get(t, srv, "/mailboxes?limit=501", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a limit above the maximum, got %d", code)
+	}
+}
+
+func TestServer_Cursors(t *testing.T) {
+	store := db.NewMemStore()
+	for id := 1; id <= 3; id++ {
+		store.SeedMailboxes(db.Mailbox{ID: id, MPIID: "mpi"})
+	}
+	srv := NewServer(store, nil, nil)
+	srv.SetCursors(cursor.New("secret"))
+
+	tests := []struct {
+		name  string
+		after string
+		code  string
+	}{
+		{"Bare ID from before cursors were signed", "1", "cursor_expired"},
+		{"Signed with a replaced secret", cursor.New("old").Encode("mailboxes", 1), "cursor_expired"},
+		{"From another listing", cursor.New("secret").Encode("users:1", 1), "bad_request"},
+		{"Garbage", "not-a-cursor", "bad_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mailboxes?after="+tt.after, nil))
+			var body map[string]Error
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if rec.Code != http.StatusBadRequest || body["error"].Code != tt.code {
+				t.Errorf("Expected 400 with code %s, got %d: %s", tt.code, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	var page []Mailbox
+	if code := get(t, srv, "/mailboxes?after="+cursor.New("secret").Encode("mailboxes", 2), &page); code != http.StatusOK || len(page) != 1 || page[0].ID != "3" {
+		t.Errorf("Expected mailbox 3 after a valid cursor, got %d: %v", code, page)
+	}
+}
+
+func TestServer_CreateMailboxAndDeleteUser(t *testing.T) {
+	store := db.NewMemStore()
+	store.SeedUsers(db.User{ID: 101, MailboxID: 1})
+	ids := publicid.New("secret")
+	srv := NewServer(store, ids, nil)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{"mpi_id": "mpi789", "token": "token789"}`)))
+	var created Mailbox
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("Expected status 201 with a mailbox, got %d: %s", rec.Code, rec.Body)
+	}
+	if created.MPIID != "mpi789" || rec.Header().Get("Location") != "/mailboxes/"+created.ID+"/users" {
+		t.Errorf("Unexpected mailbox %+v at %s", created, rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without mpi_id, got %d", rec.Code)
+	}
+
+	for _, expected := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rec = httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/"+ids.Encode(101), nil))
+		if rec.Code != expected {
+			t.Errorf("Expected status %d deleting user 101, got %d", expected, rec.Code)
+		}
+	}
+}
+
+func TestServer_EnqueueUser(t *testing.T) {
+	store := db.NewMemStore()
+	store.SeedUsers(db.User{ID: 101, MailboxID: 1})
+	ids := publicid.New("secret")
+	srv := NewServer(store, ids, nil)
+
+	for _, tt := range []struct {
+		id       int
+		expected int
+	}{
+		{101, http.StatusAccepted},
+		{102, http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users/"+ids.Encode(tt.id)+"/enqueue", nil))
+		if rec.Code != tt.expected {
+			t.Errorf("Expected status %d enqueueing user %d, got %d", tt.expected, tt.id, rec.Code)
+		}
+	}
+
+	users, err := store.ClaimQueuedUsers(context.Background(), 10)
+	if err != nil || len(users) != 1 || users[0].ID != 101 {
+		t.Errorf("Expected user 101 queued, got %v (%v)", users, err)
+	}
+}
+
+func TestServer_Processor(t *testing.T) {
+	log := processor.Log{}
+	sw, err := processor.NewSwitch(map[string]processor.Processor{"blue": log, "green": log}, "blue")
+	if err != nil {
+		t.Fatalf("Error creating switch: %v", err)
+	}
+	server := NewServer(testStore(), nil, nil)
+	srv := Scope(NewKeys([]Key{{Key: "admin-key", Admin: true}, {Key: "key"}}), server)
+	if code := get(t, srv, "/processor", nil); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a switch, got %d", code)
+	}
+	server.SetProcessors(sw)
+
+	steps := []struct {
+		method, path, body, apiKey string
+		status                     int
+		active                     string
+	}{
+		{http.MethodPut, "/processor", `{"active": "green"}`, "", http.StatusUnauthorized, "blue"},
+		{http.MethodPut, "/processor", `{"active": "green"}`, "key", http.StatusForbidden, "blue"},
+		{http.MethodGet, "/processor", "", "", http.StatusUnauthorized, "blue"},
+		{http.MethodPut, "/processor", `{"active": "green"}`, "admin-key", http.StatusOK, "green"},
+		{http.MethodPut, "/processor", `{"active": "red"}`, "admin-key", http.StatusBadRequest, "green"},
+		{http.MethodPost, "/processor/rollback", "", "key", http.StatusForbidden, "green"},
+		{http.MethodPost, "/processor/rollback", "", "admin-key", http.StatusOK, "blue"},
+		{http.MethodGet, "/processor", "", "admin-key", http.StatusOK, "blue"},
+	}
+	for _, step := range steps {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		req.Header.Set("X-API-Key", step.apiKey)
+		srv.ServeHTTP(rec, req)
+		if rec.Code != step.status {
+			t.Errorf("%s %s %s with %q: expected status %d, got %d", step.method, step.path, step.body, step.apiKey, step.status, rec.Code)
+		}
+		if sw.Active() != step.active {
+			t.Errorf("%s %s %s with %q: expected %s to be active, got %s", step.method, step.path, step.body, step.apiKey, step.active, sw.Active())
+		}
+	}
+
+	var state ProcessorState
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/processor", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	srv.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("Error decoding processor state: %v", err)
+	}
+	if !reflect.DeepEqual(state, ProcessorState{Active: "blue", Configurations: []string{"blue", "green"}}) {
+		t.Errorf("Unexpected processor state %+v", state)
+	}
+}
+
+func TestServer_ErrorEnvelope(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewServer(testStore(), nil, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/mailboxes", nil))
+
+	var body struct{ Error Error }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
+	}
+	if rec.Code != http.StatusMethodNotAllowed || body.Error != (Error{Code: "method_not_allowed", Message: "method not allowed"}) {
+		t.Errorf("Unexpected error response %d %s", rec.Code, rec.Body)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Expected Allow: GET, POST, got %q", allow)
+	}
+}
+
+// quotaStore is a MemStore whose tenants may each create one mailbox.
+type quotaStore struct {
+	*db.MemStore
+	tenants map[string]int
+}
+
+func (s *quotaStore) SetQuotas(q db.Quotas) {}
+
+func (s *quotaStore) CreateMailbox(ctx context.Context, mb db.Mailbox) (db.Mailbox, error) {
+	tenant := scope.Tenant(ctx)
+	if s.tenants[tenant] >= 1 {
+		return db.Mailbox{}, &db.QuotaError{Tenant: tenant, Quota: "max_mailboxes", Limit: 1}
+	}
+	s.tenants[tenant]++
+	return s.MemStore.CreateMailbox(ctx, mb)
+}
+
+func (s *quotaStore) MailboxTenant(ctx context.Context, mailboxID int) (string, error) {
+	return "", nil
+}
+
+func (s *quotaStore) MailboxTenants(ctx context.Context) (map[int]string, error) {
+	return nil, nil
+}
+
+func TestServer_CreateMailboxQuota(t *testing.T) {
+	srv := Scope(testKeys, NewServer(&quotaStore{MemStore: db.NewMemStore(), tenants: map[string]int{}}, nil, nil))
+
+	for _, expected := range []int{http.StatusCreated, http.StatusForbidden} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{"mpi_id": "mpi789"}`))
+		req.Header.Set("X-API-Key", "acme-key")
+		srv.ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Fatalf("Expected status %d, got %d: %s", expected, rec.Code, rec.Body)
+		}
+		if expected != http.StatusForbidden {
+			continue
+		}
+
+		var body struct{ Error Error }
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != "quota_exceeded" {
+			t.Errorf("Expected a quota_exceeded error, got %s", rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{"mpi_id": "mpi789"}`))
+	req.Header.Set("X-API-Key", "acme-key")
+	Scope(testKeys, NewServer(db.NewMemStore(), nil, nil)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 from a store without tenants, got %d", rec.Code)
+	}
+}
+
+// healthStore is a fakeStore with a health summary for mailbox 1.
+type healthStore struct {
+	*fakeStore
+}
+
+func (s healthStore) MailboxActivity(ctx context.Context) (map[int]db.MailboxActivity, error) {
+	return nil, nil
+}
+
+func (s healthStore) SaveMailboxSummaries(ctx context.Context, summaries []db.MailboxSummary) error {
+	return nil
+}
+
+func (s healthStore) MailboxSummaries(ctx context.Context, limit int) ([]db.MailboxSummary, error) {
+	sum, _ := s.MailboxSummary(ctx, 1)
+	return []db.MailboxSummary{sum}, nil
+}
+
+func (s healthStore) MailboxSummary(ctx context.Context, mailboxID int) (db.MailboxSummary, error) {
+	if mailboxID != 1 {
+		return db.MailboxSummary{}, db.ErrNoSummary
+	}
+	return db.MailboxSummary{MailboxID: 1, HealthScore: 90, TokenValid: true, Verification: "unknown",
+		Reasons: []string{"never processed"}, ComputedAt: time.Date(2024, 7, 23, 16, 0, 0, 0, time.UTC)}, nil
+}
+
+func TestServer_MailboxHealth(t *testing.T) {
+	ids := publicid.New("secret")
+	srv := NewServer(healthStore{testStore()}, ids, nil)
+
+	expected := MailboxHealth{MailboxID: ids.Encode(1), HealthScore: 90, TokenValid: true, Verification: "unknown",
+		Reasons: []string{"never processed"}, ComputedAt: "2024-07-23T16:00:00Z"}
+
+	var health MailboxHealth
+	if code := get(t, srv, "/mailboxes/"+ids.Encode(1)+"/health", &health); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !reflect.DeepEqual(health, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, health)
+	}
+
+	var list []MailboxHealth
+	if code := get(t, srv, "/health/mailboxes?limit=5", &list); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !reflect.DeepEqual(list, []MailboxHealth{expected}) {
+		t.Errorf("Expected [%+v], got %+v", expected, list)
+	}
+
+	if code := get(t, srv, "/mailboxes/"+ids.Encode(2)+"/health", nil); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a summary, got %d", code)
+	}
+	if code := get(t, NewServer(testStore(), ids, nil), "/health/mailboxes", nil); code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 from a store without health, got %d", code)
+	}
+}
+
+// tenantStore returns a SQLite store in which tenants acme and globex each
+// have one mailbox, mpi-acme and mpi-globex, of one user.
+func tenantStore(t *testing.T) *db.DBStore {
+	t.Helper()
+	opened, err := db.NewSQLiteStore("sqlite3", db.SQLiteConfig{Path: filepath.Join(t.TempDir(), "mailboxes.db")})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	store := opened.(*db.DBStore)
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	for _, tenant := range []string{"acme", "globex"} {
+		ctx := scope.WithTenant(context.Background(), tenant)
+		mb, err := store.CreateMailbox(ctx, db.Mailbox{MPIID: "mpi-" + tenant})
+		if err != nil {
+			t.Fatalf("Error creating mailbox: %v", err)
+		}
+		if _, err := store.CreateUser(ctx, db.User{MailboxID: mb.ID, UserName: tenant, EmailAddress: tenant + "@example.com"}); err != nil {
+			t.Fatalf("Error creating user: %v", err)
+		}
+	}
+	return store
+}
+
+// testKeys binds the keys acme-key and globex-key to acme and globex.
+var testKeys = NewKeys([]Key{{Key: "acme-key", Tenant: "acme"}, {Key: "globex-key", Tenant: "globex"}})
+
+// getAs gets path with tenant's key from testKeys.
+func getAs(h http.Handler, tenant, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-API-Key", tenant+"-key")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_TenantIsolation(t *testing.T) {
+	store := tenantStore(t)
+	ids := publicid.New("secret")
+	// Mailboxes 1 and 2 are acme's and globex's.
+	acme, globex := ids.Encode(1), ids.Encode(2)
+
+	t.Run("Cache", func(t *testing.T) {
+		srv := NewServer(store, ids, nil)
+		srv.SetCache(cache.New(store, time.Minute, 0))
+		h := Scope(testKeys, srv)
+
+		// globex's read of its own users is cached first.
+		for _, tt := range []struct {
+			tenant, mailbox string
+			expected        int
+		}{
+			{"globex", globex, 1},
+			{"acme", globex, 0},
+			{"acme", acme, 1},
+			{"globex", acme, 0},
+		} {
+			rec := getAs(h, tt.tenant, "/mailboxes/"+tt.mailbox+"/users")
+			var users []User
+			if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+				t.Fatalf("Error decoding users: %v", err)
+			}
+			if len(users) != tt.expected {
+				t.Errorf("%s read %d users of mailbox %s, want %d", tt.tenant, len(users), tt.mailbox, tt.expected)
+			}
+		}
+	})
+
+	t.Run("GraphQL", func(t *testing.T) {
+		gql, err := NewGraphQL(store, nil, cursor.New(""))
+		if err != nil {
+			t.Fatalf("Error creating GraphQL handler: %v", err)
+		}
+		h := Scope(testKeys, gql)
+
+		var data struct {
+			Mailboxes struct {
+				Nodes []struct {
+					MpiID string `json:"mpiId"`
+					Users []struct {
+						UserName string `json:"userName"`
+					} `json:"users"`
+				} `json:"nodes"`
+			} `json:"mailboxes"`
+		}
+		queryTenant(t, h, "acme", `{ mailboxes(mpiId: "mpi-globex") { nodes { mpiId } } }`, &data)
+		if len(data.Mailboxes.Nodes) != 0 {
+			t.Errorf("Expected no mailbox of another tenant by mpiId, got %+v", data.Mailboxes.Nodes)
+		}
+
+		queryTenant(t, h, "acme", `{ mailboxes { nodes { mpiId users { userName } } } }`, &data)
+		nodes := data.Mailboxes.Nodes
+		if len(nodes) != 1 || nodes[0].MpiID != "mpi-acme" || len(nodes[0].Users) != 1 || nodes[0].Users[0].UserName != "acme" {
+			t.Errorf("Expected only acme's mailbox and user, got %+v", nodes)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		rec := getAs(Scope(testKeys, NewServer(store, ids, nil)), "acme", "/stats")
+		var stats Stats
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("Error decoding stats: %v", err)
+		}
+		expected := []db.TableStats{{Name: "mailboxes", Rows: 1}, {Name: "users", Rows: 1}}
+		if !reflect.DeepEqual(stats.Tables, expected) || stats.DatabaseBytes != 0 {
+			t.Errorf("Expected only acme's rows, got %+v and %d bytes", stats.Tables, stats.DatabaseBytes)
+		}
+		if len(stats.LargestMailboxes) != 1 || stats.LargestMailboxes[0].MailboxID != acme {
+			t.Errorf("Expected only acme's mailbox, got %+v", stats.LargestMailboxes)
+		}
+	})
+}
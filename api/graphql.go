@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"mailboxes/cursor"
+	"mailboxes/db"
+	"mailboxes/publicid"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	# Mailboxes in ID order, optionally only the one with mpiId. Pass the
+	# previous page's endCursor as after to fetch the next page.
+	mailboxes(first: Int = 50, after: ID, mpiId: String): MailboxConnection!
+	mailbox(id: ID!): Mailbox
+}
+
+type MailboxConnection {
+	nodes: [Mailbox!]!
+	endCursor: ID
+	hasNextPage: Boolean!
+}
+
+type Mailbox {
+	id: ID!
+	mpiId: String!
+	createdAt: String!
+	# Users of the mailbox, optionally only those whose email address is in
+	# emailDomain.
+	users(emailDomain: String): [User!]!
+}
+
+type User {
+	id: ID!
+	mailboxId: ID!
+	userName: String!
+	emailAddress: String!
+	createdAt: String!
+}
+`
+
+// GraphQL answers GraphQL queries over mailboxes and their users. Users are
+// fetched through a per-request loader, so listing a page of mailboxes with
+// their users costs one users query rather than one per mailbox when the
+// store is a db.BatchUserStore.
+type GraphQL struct {
+	schema *graphql.Schema
+}
+
+// NewGraphQL returns a GraphQL endpoint for store. Page cursors are signed
+// with cursors.
+func NewGraphQL(store db.Store, ids *publicid.Codec, cursors *cursor.Codec) (*GraphQL, error) {
+	schema, err := graphql.ParseSchema(graphqlSchema, &queryResolver{store: store, ids: ids, cursors: cursors})
+	if err != nil {
+		return nil, err
+	}
+	return &GraphQL{schema: schema}, nil
+}
+
+// ServeHTTP executes a POSTed {"query", "operationName", "variables"}
+// request. Query errors are reported in the response's errors list, as
+// GraphQL clients expect, with a 200 status.
+func (g *GraphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid GraphQL request")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userLoaderKey{}, &userLoader{loaded: make(map[int][]db.User)})
+	writeJSON(w, http.StatusOK, g.schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+type queryResolver struct {
+	store   db.Store
+	ids     *publicid.Codec
+	cursors *cursor.Codec
+}
+
+type mailboxesArgs struct {
+	First int32
+	After *graphql.ID
+	MpiID *string
+}
+
+// Mailboxes pages through AllMailboxes by ID. The store has no paginated
+// read and no ordering guarantee, so each page scans every mailbox.
+func (q *queryResolver) Mailboxes(ctx context.Context, args mailboxesArgs) (*mailboxConnection, error) {
+	first := int(args.First)
+	if first < 0 || first > maxPageSize {
+		return nil, fmt.Errorf("first must be between 0 and %d", maxPageSize)
+	}
+
+	afterID := 0
+	if args.After != nil {
+		id, err := decodeCursor(q.cursors, q.ids, mailboxesListing, string(*args.After))
+		if errors.Is(err, cursor.ErrExpired) {
+			return nil, errors.New("cursor expired; fetch the first page again")
+		}
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		afterID = id
+	}
+
+	mailboxes, err := mailboxesAfter(ctx, q.store, afterID, first+1, args.MpiID)
+	if err != nil {
+		return nil, errors.New("error retrieving mailboxes")
+	}
+
+	conn := &mailboxConnection{cursors: q.cursors, hasNextPage: len(mailboxes) > first}
+	if conn.hasNextPage {
+		mailboxes = mailboxes[:first]
+	}
+	for _, mb := range mailboxes {
+		conn.nodes = append(conn.nodes, &mailboxResolver{mb: mb, store: q.store, ids: q.ids})
+	}
+
+	// Queue the whole page so the first users field loads them all at once.
+	loader := loaderFrom(ctx)
+	for _, node := range conn.nodes {
+		loader.prime(node.mb.ID)
+	}
+
+	return conn, nil
+}
+
+func (q *queryResolver) Mailbox(ctx context.Context, args struct{ ID graphql.ID }) (*mailboxResolver, error) {
+	ms, ok := q.store.(db.MailboxStore)
+	if !ok {
+		return nil, errors.New("mailbox lookup is not supported by this store")
+	}
+
+	id, err := q.ids.Decode(string(args.ID))
+	if err != nil {
+		return nil, nil
+	}
+
+	mb, err := ms.GetMailboxByID(ctx, id)
+	if errors.Is(err, db.ErrMailboxNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("error retrieving mailbox")
+	}
+	return &mailboxResolver{mb: mb, store: q.store, ids: q.ids}, nil
+}
+
+type mailboxConnection struct {
+	cursors     *cursor.Codec
+	nodes       []*mailboxResolver
+	hasNextPage bool
+}
+
+func (c *mailboxConnection) Nodes() []*mailboxResolver {
+	return c.nodes
+}
+
+func (c *mailboxConnection) EndCursor() *graphql.ID {
+	if len(c.nodes) == 0 {
+		return nil
+	}
+	end := graphql.ID(c.cursors.Encode(mailboxesListing, c.nodes[len(c.nodes)-1].mb.ID))
+	return &end
+}
+
+func (c *mailboxConnection) HasNextPage() bool {
+	return c.hasNextPage
+}
+
+type mailboxResolver struct {
+	mb    db.Mailbox
+	store db.Store
+	ids   *publicid.Codec
+}
+
+func (m *mailboxResolver) ID() graphql.ID {
+	return graphql.ID(m.ids.Encode(m.mb.ID))
+}
+
+func (m *mailboxResolver) MpiID() string {
+	return m.mb.MPIID
+}
+
+func (m *mailboxResolver) CreatedAt() string {
+	return formatTime(m.mb.CreatedAt)
+}
+
+func (m *mailboxResolver) Users(ctx context.Context, args struct{ EmailDomain *string }) ([]*userResolver, error) {
+	users, err := loaderFrom(ctx).load(ctx, m.store, m.mb.ID)
+	if err != nil {
+		return nil, errors.New("error retrieving users")
+	}
+
+	resolvers := []*userResolver{}
+	for _, u := range users {
+		if args.EmailDomain != nil && !strings.HasSuffix(strings.ToLower(u.EmailAddress), "@"+strings.ToLower(*args.EmailDomain)) {
+			continue
+		}
+		resolvers = append(resolvers, &userResolver{u: u, ids: m.ids})
+	}
+	return resolvers, nil
+}
+
+type userResolver struct {
+	u   db.User
+	ids *publicid.Codec
+}
+
+func (u *userResolver) ID() graphql.ID {
+	return graphql.ID(u.ids.Encode(u.u.ID))
+}
+
+func (u *userResolver) MailboxID() graphql.ID {
+	return graphql.ID(u.ids.Encode(u.u.MailboxID))
+}
+
+func (u *userResolver) UserName() string {
+	return u.u.UserName
+}
+
+func (u *userResolver) EmailAddress() string {
+	return u.u.EmailAddress
+}
+
+func (u *userResolver) CreatedAt() string {
+	return formatTime(u.u.CreatedAt)
+}
+
+type userLoaderKey struct{}
+
+// userLoader caches users by mailbox for one request. Mailbox IDs primed
+// before the first load are fetched together with it.
+type userLoader struct {
+	mu      sync.Mutex
+	pending []int
+	loaded  map[int][]db.User
+}
+
+func loaderFrom(ctx context.Context) *userLoader {
+	if l, ok := ctx.Value(userLoaderKey{}).(*userLoader); ok {
+		return l
+	}
+	return &userLoader{loaded: make(map[int][]db.User)}
+}
+
+func (l *userLoader) prime(mailboxID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, mailboxID)
+}
+
+func (l *userLoader) load(ctx context.Context, store db.Store, mailboxID int) ([]db.User, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if users, ok := l.loaded[mailboxID]; ok {
+		return users, nil
+	}
+
+	var batch []int
+	seen := map[int]bool{}
+	for _, id := range append(l.pending, mailboxID) {
+		if _, ok := l.loaded[id]; !ok && !seen[id] {
+			seen[id] = true
+			batch = append(batch, id)
+		}
+	}
+	l.pending = nil
+
+	users := make(map[int][]db.User, len(batch))
+	if bs, ok := store.(db.BatchUserStore); ok {
+		userChan, err := bs.UsersForMailboxes(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for u := range userChan {
+			users[u.MailboxID] = append(users[u.MailboxID], u)
+		}
+	} else {
+		for _, id := range batch {
+			userChan, err := store.UsersForMailbox(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			for u := range userChan {
+				users[id] = append(users[id], u)
+			}
+		}
+	}
+
+	for _, id := range batch {
+		l.loaded[id] = users[id]
+	}
+	return l.loaded[mailboxID], nil
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailboxes/cursor"
+	"mailboxes/db"
+)
+
+// batchStore counts user queries so tests can check for N+1 fetching.
+type batchStore struct {
+	*fakeStore
+	batches int
+}
+
+func (b *batchStore) UsersForMailboxes(ctx context.Context, mailboxIDs []int) (<-chan db.User, error) {
+	b.batches++
+
+	wanted := map[int]bool{}
+	for _, id := range mailboxIDs {
+		wanted[id] = true
+	}
+	ch := make(chan db.User, len(b.users))
+	for _, u := range b.users {
+		if wanted[u.MailboxID] {
+			ch <- u
+		}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func query(t *testing.T, h http.Handler, q string, v any) {
+	t.Helper()
+	queryTenant(t, h, "", q, v)
+}
+
+// queryTenant runs q as tenant, with its key from testKeys if not empty.
+func queryTenant(t *testing.T, h http.Handler, tenant, q string, v any) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"query": q})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	if tenant != "" {
+		req.Header.Set("X-API-Key", tenant+"-key")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		t.Fatalf("Error decoding data: %v", err)
+	}
+}
+
+func TestGraphQL_MailboxesWithUsers(t *testing.T) {
+	store := &batchStore{fakeStore: testStore()}
+	store.mailboxes = append(store.mailboxes,
+		db.Mailbox{ID: 3, MPIID: "mpi789", CreatedAt: time.Date(2024, 7, 23, 14, 0, 0, 0, time.UTC)},
+		db.Mailbox{ID: 2, MPIID: "mpi456", CreatedAt: time.Date(2024, 7, 23, 13, 0, 0, 0, time.UTC)},
+	)
+
+	gql, err := NewGraphQL(store, nil, cursor.New(""))
+	if err != nil {
+		t.Fatalf("Error creating GraphQL handler: %v", err)
+	}
+
+	type page struct {
+		Mailboxes struct {
+			Nodes []struct {
+				ID    string `json:"id"`
+				MpiID string `json:"mpiId"`
+				Users []struct {
+					ID string `json:"id"`
+				} `json:"users"`
+			} `json:"nodes"`
+			EndCursor   string `json:"endCursor"`
+			HasNextPage bool   `json:"hasNextPage"`
+		} `json:"mailboxes"`
+	}
+
+	var first page
+	query(t, gql, `{ mailboxes(first: 2) { nodes { id mpiId users { id } } endCursor hasNextPage } }`, &first)
+
+	if store.batches != 1 {
+		t.Errorf("Expected users to be fetched in 1 batch, got %d", store.batches)
+	}
+	got := first.Mailboxes
+	if len(got.Nodes) != 2 || got.Nodes[0].ID != "1" || got.Nodes[1].ID != "2" {
+		t.Fatalf("Expected mailboxes 1 and 2, got %+v", got.Nodes)
+	}
+	if !reflect.DeepEqual(got.Nodes[1].Users, []struct {
+		ID string `json:"id"`
+	}{{ID: "201"}}) {
+		t.Errorf("Expected mailbox 2 to have user 201, got %+v", got.Nodes[1].Users)
+	}
+	if !got.HasNextPage || got.EndCursor == "" {
+		t.Errorf("Expected a next page after a cursor, got %v after %q", got.HasNextPage, got.EndCursor)
+	}
+
+	var second page
+	query(t, gql, `{ mailboxes(first: 2, after: "`+got.EndCursor+`") { nodes { id mpiId } hasNextPage } }`, &second)
+	if len(second.Mailboxes.Nodes) != 1 || second.Mailboxes.Nodes[0].MpiID != "mpi789" || second.Mailboxes.HasNextPage {
+		t.Errorf("Expected only mailbox mpi789 on the last page, got %+v", second.Mailboxes)
+	}
+}
+
+func TestGraphQL_UserFilter(t *testing.T) {
+	store := testStore()
+	store.users = append(store.users, db.User{ID: 102, MailboxID: 1, UserName: "user2", EmailAddress: "user2@other.org"})
+
+	gql, err := NewGraphQL(store, nil, cursor.New(""))
+	if err != nil {
+		t.Fatalf("Error creating GraphQL handler: %v", err)
+	}
+
+	var data struct {
+		Mailboxes struct {
+			Nodes []struct {
+				Users []struct {
+					UserName string `json:"userName"`
+				} `json:"users"`
+			} `json:"nodes"`
+		} `json:"mailboxes"`
+	}
+	query(t, gql, `{ mailboxes(mpiId: "mpi123") { nodes { users(emailDomain: "Other.org") { userName } } } }`, &data)
+
+	nodes := data.Mailboxes.Nodes
+	if len(nodes) != 1 || len(nodes[0].Users) != 1 || nodes[0].Users[0].UserName != "user2" {
+		t.Errorf("Expected only user2, got %+v", nodes)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/scope"
+)
+
+// maxIdempotentBody caps the request bodies read for fingerprinting.
+const maxIdempotentBody = 10 << 20
+
+// Idempotency makes POST requests carrying an Idempotency-Key header safe to
+// retry: the first response is stored and replayed for later requests with
+// the same key from the same client and tenant.
+type Idempotency struct {
+	store   db.IdempotencyStore
+	ttl     time.Duration
+	lease   time.Duration
+	limiter *Limiter
+}
+
+// NewIdempotency returns an Idempotency keeping responses for ttl. A request
+// still in progress after lease is taken to have been lost with its server,
+// and a retry runs again. Callers are told apart by the API keys Scope
+// knows or limiter has limits for, and otherwise by address.
+func NewIdempotency(store db.IdempotencyStore, ttl, lease time.Duration, limiter *Limiter) *Idempotency {
+	return &Idempotency{store: store, ttl: ttl, lease: lease, limiter: limiter}
+}
+
+// client returns whose idempotency keys r's is among: its tenant and the
+// client it was made by. An X-API-Key that is not configured proves
+// nothing, so such callers count by address.
+func (i *Idempotency) client(r *http.Request) string {
+	client := "addr:" + remoteHost(r)
+	if key := keyFrom(r.Context()); key.Key != "" {
+		client = key.Key
+	} else if i.limiter != nil {
+		client = i.limiter.Client(r.Header.Get("X-API-Key"), remoteHost(r))
+	}
+	return scope.Tenant(r.Context()) + " " + client
+}
+
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "error reading request body")
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+		key := i.client(r) + " " + header
+
+		rec, reserved, err := i.store.ReserveIdempotencyKey(r.Context(), key, fingerprint, i.ttl, i.lease)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "error checking idempotency key")
+			return
+		}
+
+		if !reserved {
+			switch {
+			case rec.Fingerprint != fingerprint:
+				writeError(w, http.StatusUnprocessableEntity, "idempotency key was used for a different request")
+			case rec.Status == 0:
+				writeError(w, http.StatusConflict, "a request with this idempotency key is in progress")
+			default:
+				for name, values := range rec.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(rec.Status)
+				w.Write(rec.Body)
+			}
+			return
+		}
+
+		// The outcome is kept even if the client has gone away meanwhile.
+		ctx := context.WithoutCancel(r.Context())
+		release := func() {
+			if err := i.store.ReleaseIdempotencyKey(ctx, key); err != nil {
+				slog.Error("Error releasing idempotency key", "error", err)
+			}
+		}
+
+		// A handler that panics leaves no outcome, so its claim is dropped
+		// for the client's retry to run again.
+		defer func() {
+			if v := recover(); v != nil {
+				release()
+				panic(v)
+			}
+		}()
+
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		// Server errors are not stored, so the client's retry runs again.
+		if rw.status >= 500 {
+			release()
+			return
+		}
+		if err := i.store.CompleteIdempotencyKey(ctx, key, rw.status, w.Header().Clone(), rw.body.Bytes()); err != nil {
+			slog.Error("Error storing idempotent response", "error", err)
+		}
+	})
+}
+
+// recordingWriter passes a response through while keeping a copy.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
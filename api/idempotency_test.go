@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+type memIdempotencyStore struct {
+	mu       sync.Mutex
+	records  map[string]db.IdempotencyRecord
+	reserved map[string]time.Time
+}
+
+func newMemIdempotencyStore() *memIdempotencyStore {
+	return &memIdempotencyStore{records: map[string]db.IdempotencyRecord{}, reserved: map[string]time.Time{}}
+}
+
+func (m *memIdempotencyStore) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (db.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.records[key]; ok && (rec.Status != 0 || time.Since(m.reserved[key]) < lease) {
+		return rec, false, nil
+	}
+	m.records[key] = db.IdempotencyRecord{Fingerprint: fingerprint}
+	m.reserved[key] = time.Now()
+	return m.records[key], true, nil
+}
+
+func (m *memIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := m.records[key]
+	rec.Status, rec.Header, rec.Body = status, header, body
+	m.records[key] = rec
+	return nil
+}
+
+func (m *memIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+func TestIdempotency_Middleware(t *testing.T) {
+	created := 0
+	status := http.StatusCreated
+	h := NewIdempotency(newMemIdempotencyStore(), time.Hour, time.Hour, nil).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			created++
+			w.Header().Set("Location", fmt.Sprintf("/mailboxes/%d/users", created))
+			writeJSON(w, status, map[string]string{"id": fmt.Sprint(created)})
+		}))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("key1", `{"mpi_id":"mpi789"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", first.Code)
+	}
+
+	retry := post("key1", `{"mpi_id":"mpi789"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected retry to replay %d %q, got %d %q", first.Code, first.Body, retry.Code, retry.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected replayed response to be marked")
+	}
+	for _, name := range []string{"Content-Type", "Location"} {
+		if got, expected := retry.Header().Get(name), first.Header().Get(name); got != expected {
+			t.Errorf("Expected replayed %s %q, got %q", name, expected, got)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected handler to run once, ran %d times", created)
+	}
+
+	if rec := post("key1", `{"mpi_id":"other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected reused key with a different body to get 422, got %d", rec.Code)
+	}
+
+	post("", `{"mpi_id":"mpi789"}`)
+	if created != 2 {
+		t.Errorf("Expected requests without a key to run, ran %d times", created)
+	}
+
+	status = http.StatusInternalServerError
+	post("key2", `{}`)
+	post("key2", `{}`)
+	if created != 4 {
+		t.Errorf("Expected failed requests to be retried, ran %d times", created)
+	}
+}
+
+func TestIdempotency_MiddlewarePanic(t *testing.T) {
+	panics := true
+	h := NewIdempotency(newMemIdempotencyStore(), time.Hour, time.Hour, nil).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if panics {
+				panic("handler failed")
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	post := func() (rec *httptest.ResponseRecorder, recovered any) {
+		defer func() { recovered = recover() }()
+		req := httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "key1")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec, nil
+	}
+
+	if _, recovered := post(); recovered != "handler failed" {
+		t.Fatalf("Expected the panic passed on, got %v", recovered)
+	}
+
+	panics = false
+	if rec, _ := post(); rec.Code != http.StatusCreated {
+		t.Errorf("Expected the retry after a panic to run, got %d", rec.Code)
+	}
+}
+
+func TestIdempotency_MiddlewareLease(t *testing.T) {
+	store := newMemIdempotencyStore()
+	i := NewIdempotency(store, time.Hour, 50*time.Millisecond, nil)
+	ran := 0
+	h := i.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ran++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "key1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A server that died mid-request leaves its claim behind.
+	req := httptest.NewRequest(http.MethodPost, "/mailboxes", nil)
+	sum := sha256.Sum256([]byte("POST /mailboxes\n{}"))
+	store.ReserveIdempotencyKey(context.Background(), i.client(req)+" key1", hex.EncodeToString(sum[:]), time.Hour, time.Hour)
+
+	if rec := post(); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the claim is leased, got %d", rec.Code)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if rec := post(); rec.Code != http.StatusCreated || ran != 1 {
+		t.Errorf("Expected the retry to run once the lease ran out, got %d after %d runs", rec.Code, ran)
+	}
+}
+
+func TestIdempotency_MiddlewareClients(t *testing.T) {
+	ran := 0
+	h := Scope(testKeys, NewIdempotency(newMemIdempotencyStore(), time.Hour, time.Hour, nil).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ran++
+			w.WriteHeader(http.StatusCreated)
+		})))
+
+	post := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mailboxes", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "key1")
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	post("acme-key")
+	if rec := post("globex-key"); rec.Header().Get("Idempotent-Replayed") != "" || ran != 2 {
+		t.Errorf("Expected another tenant's request with the same key to run, ran %d times", ran)
+	}
+	if rec := post("acme-key"); rec.Header().Get("Idempotent-Replayed") != "true" || ran != 2 {
+		t.Errorf("Expected the tenant's retry to be replayed, ran %d times", ran)
+	}
+
+	// Without configured keys, made-up ones do not tell callers apart.
+	h = NewIdempotency(newMemIdempotencyStore(), time.Hour, time.Hour, nil).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+	post("made-up-1")
+	if rec := post("made-up-2"); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected callers at one address to share keys whatever X-API-Key they send")
+	}
+}
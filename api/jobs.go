@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JobFunc runs one kind of bulk job. It reports progress as it goes and
+// returns when the job is finished or ctx is cancelled.
+type JobFunc func(ctx context.Context, params json.RawMessage, progress *Progress) error
+
+// Progress counts the items a job has handled.
+type Progress struct {
+	total, done, failed atomic.Int64
+}
+
+func (p *Progress) SetTotal(n int) { p.total.Store(int64(n)) }
+func (p *Progress) Done()          { p.done.Add(1) }
+func (p *Progress) Fail()          { p.failed.Add(1) }
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is the pollable state of a bulk job.
+type Job struct {
+	ID         string `json:"id"`
+	Kind       string `json:"kind"`
+	Status     string `json:"status"`
+	Total      int64  `json:"total"`
+	Done       int64  `json:"done"`
+	Failed     int64  `json:"failed"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+var (
+	ErrUnknownJobKind = errors.New("unknown job kind")
+	ErrJobQueueFull   = errors.New("job queue is full")
+)
+
+// jobRetention is how long finished jobs stay pollable.
+const jobRetention = 24 * time.Hour
+
+type job struct {
+	Job
+	params   json.RawMessage
+	progress Progress
+	finished time.Time
+}
+
+// Jobs runs bulk jobs in the background on a fixed number of workers. Job
+// state is held in memory, so jobs do not survive a restart.
+type Jobs struct {
+	workers int
+	queue   chan *job
+
+	mu    sync.Mutex
+	kinds map[string]JobFunc
+	jobs  map[string]*job
+}
+
+// NewJobs returns a Jobs that runs up to workers jobs at once after Run is
+// called.
+func NewJobs(workers int) *Jobs {
+	return &Jobs{
+		workers: max(workers, 1),
+		queue:   make(chan *job, 1024),
+		kinds:   map[string]JobFunc{},
+		jobs:    map[string]*job{},
+	}
+}
+
+// Register makes a kind of job available to Submit.
+func (j *Jobs) Register(kind string, fn JobFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.kinds[kind] = fn
+}
+
+// Submit queues a job and returns its initial state.
+func (j *Jobs) Submit(kind string, params json.RawMessage) (Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.kinds[kind]; !ok {
+		return Job{}, fmt.Errorf("%w %q", ErrUnknownJobKind, kind)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+
+	now := time.Now()
+	for key, old := range j.jobs {
+		if !old.finished.IsZero() && now.Sub(old.finished) > jobRetention {
+			delete(j.jobs, key)
+		}
+	}
+
+	jb := &job{
+		Job:    Job{ID: hex.EncodeToString(id), Kind: kind, Status: JobQueued, CreatedAt: now.UTC().Format(time.RFC3339)},
+		params: params,
+	}
+
+	select {
+	case j.queue <- jb:
+	default:
+		return Job{}, ErrJobQueueFull
+	}
+	j.jobs[jb.ID] = jb
+
+	return jb.snapshot(), nil
+}
+
+// Get returns the current state of the job with id.
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jb, ok := j.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return jb.snapshot(), true
+}
+
+// snapshot must be called with Jobs.mu held.
+func (jb *job) snapshot() Job {
+	s := jb.Job
+	s.Total = jb.progress.total.Load()
+	s.Done = jb.progress.done.Load()
+	s.Failed = jb.progress.failed.Load()
+	return s
+}
+
+// Run processes queued jobs until ctx is cancelled, then waits for running
+// jobs to stop.
+func (j *Jobs) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < j.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case jb := <-j.queue:
+					j.run(ctx, jb)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (j *Jobs) run(ctx context.Context, jb *job) {
+	j.mu.Lock()
+	fn := j.kinds[jb.Kind]
+	jb.Status = JobRunning
+	jb.StartedAt = time.Now().UTC().Format(time.RFC3339)
+	j.mu.Unlock()
+
+	slog.Info("Running job", "kind", jb.Kind, "job_id", jb.ID)
+	err := fn(ctx, jb.params, &jb.progress)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jb.finished = time.Now()
+	jb.FinishedAt = jb.finished.UTC().Format(time.RFC3339)
+	jb.Status = JobSucceeded
+	if err != nil {
+		jb.Status = JobFailed
+		jb.Error = err.Error()
+		slog.Error("Error running job", "kind", jb.Kind, "job_id", jb.ID, "error", err)
+		return
+	}
+	slog.Info("Job finished", "kind", jb.Kind, "job_id", jb.ID, "done", jb.progress.done.Load(), "failed", jb.progress.failed.Load())
+}
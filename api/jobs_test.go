@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Jobs(t *testing.T) {
+	jobs := NewJobs(1)
+	jobs.Register("count", func(ctx context.Context, params json.RawMessage, progress *Progress) error {
+		var p struct {
+			N int `json:"n"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return err
+		}
+		progress.SetTotal(p.N)
+		for i := 0; i < p.N; i++ {
+			progress.Done()
+		}
+		return nil
+	})
+	jobs.Register("broken", func(ctx context.Context, params json.RawMessage, progress *Progress) error {
+		return errors.New("boom")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.Run(ctx)
+
+	srv := NewServer(testStore(), nil, jobs)
+
+	submit := func(body string) (int, Job) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+		var job Job
+		json.Unmarshal(rec.Body.Bytes(), &job)
+		return rec.Code, job
+	}
+
+	wait := func(id string) Job {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			var job Job
+			if code := get(t, srv, "/jobs/"+id, &job); code != http.StatusOK {
+				t.Fatalf("Expected status 200 polling job, got %d", code)
+			}
+			if job.Status == JobSucceeded || job.Status == JobFailed {
+				return job
+			}
+		}
+		t.Fatalf("Job %s did not finish", id)
+		return Job{}
+	}
+
+	code, job := submit(`{"kind":"count","params":{"n":3}}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", code)
+	}
+	if job = wait(job.ID); job.Status != JobSucceeded || job.Total != 3 || job.Done != 3 {
+		t.Errorf("Expected succeeded job with 3 of 3 done, got %+v", job)
+	}
+
+	_, job = submit(`{"kind":"broken"}`)
+	if job = wait(job.ID); job.Status != JobFailed || job.Error != "boom" {
+		t.Errorf("Expected failed job with error boom, got %+v", job)
+	}
+
+	if code, _ := submit(`{"kind":"unknown"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown kind, got %d", code)
+	}
+	if code := get(t, srv, "/jobs/missing", nil); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown job, got %d", code)
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Key is an API key callers send in X-API-Key (x-api-key metadata over
+// gRPC). A key bound to a tenant scopes every request made with it to that
+// tenant.
+type Key struct {
+	Key    string `mapstructure:"key"`
+	Tenant string `mapstructure:"tenant"`
+	Admin  bool   `mapstructure:"admin"`
+}
+
+var (
+	// ErrUnknownKey is returned for requests without a key bound to a
+	// tenant when tenants are configured.
+	ErrUnknownKey = errors.New("an API key bound to a tenant is required")
+	// ErrWrongTenant is returned for requests naming a tenant their key is
+	// not bound to.
+	ErrWrongTenant = errors.New("the API key is not bound to this tenant")
+)
+
+// Keys resolves the tenant of each request from its API key.
+type Keys struct {
+	keys     map[string]Key
+	tenanted bool
+}
+
+// NewKeys returns Keys for keys. Tenants are configured once any key is
+// bound to one; until then, every request is unscoped.
+func NewKeys(keys []Key) *Keys {
+	k := &Keys{keys: make(map[string]Key, len(keys))}
+	for _, key := range keys {
+		if key.Key == "" {
+			continue
+		}
+		k.keys[key.Key] = key
+		if key.Tenant != "" {
+			k.tenanted = true
+		}
+	}
+	return k
+}
+
+// Tenanted reports whether any key is bound to a tenant.
+func (k *Keys) Tenanted() bool {
+	return k != nil && k.tenanted
+}
+
+// Lookup returns the key apiKey names, if it is configured.
+func (k *Keys) Lookup(apiKey string) (Key, bool) {
+	if k == nil || apiKey == "" {
+		return Key{}, false
+	}
+	key, ok := k.keys[apiKey]
+	return key, ok
+}
+
+// Tenant returns the tenant a request made with apiKey is scoped to. named
+// is the tenant the request names, if any, which must be the key's own.
+// When tenants are configured, requests need a key bound to a tenant, save
+// admin keys bound to none, which read across tenants; otherwise no request
+// may name a tenant.
+func (k *Keys) Tenant(apiKey, named string) (string, error) {
+	key, ok := k.Lookup(apiKey)
+	if !k.Tenanted() {
+		if named != "" {
+			return "", ErrWrongTenant
+		}
+		return "", nil
+	}
+	if !ok || (key.Tenant == "" && !key.Admin) {
+		return "", ErrUnknownKey
+	}
+	if named != "" && named != key.Tenant {
+		return "", ErrWrongTenant
+	}
+	return key.Tenant, nil
+}
+
+type keyContextKey struct{}
+
+// withKey returns ctx carrying the key a request was made with.
+func withKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// keyFrom returns the key ctx's request was made with, or the zero Key.
+func keyFrom(ctx context.Context) Key {
+	key, _ := ctx.Value(keyContextKey{}).(Key)
+	return key
+}
+
+// adminOnly runs h only for requests made with an admin key. Requests
+// without a known key get 401 Unauthorized, and those with another key 403
+// Forbidden.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := keyFrom(r.Context())
+		switch {
+		case key.Key == "":
+			writeError(w, http.StatusUnauthorized, "an admin API key is required")
+		case !key.Admin:
+			writeError(w, http.StatusForbidden, "the API key is not an admin key")
+		default:
+			h(w, r)
+		}
+	}
+}
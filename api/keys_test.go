@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mailboxes/scope"
+)
+
+func TestScope(t *testing.T) {
+	admin := NewKeys([]Key{{Key: "acme-key", Tenant: "acme"}, {Key: "admin-key", Admin: true}})
+
+	tests := []struct {
+		name           string
+		keys           *Keys
+		apiKey, tenant string
+		expected       int
+		expectedTenant string
+	}{
+		{name: "Key bound to a tenant", keys: testKeys, apiKey: "acme-key", expected: http.StatusOK, expectedTenant: "acme"},
+		{name: "Key naming its own tenant", keys: testKeys, apiKey: "acme-key", tenant: "acme", expected: http.StatusOK, expectedTenant: "acme"},
+		{name: "Key naming another tenant", keys: testKeys, apiKey: "acme-key", tenant: "globex", expected: http.StatusForbidden},
+		{name: "No key", keys: testKeys, expected: http.StatusUnauthorized},
+		{name: "No key naming a tenant", keys: testKeys, tenant: "acme", expected: http.StatusUnauthorized},
+		{name: "Unknown key", keys: testKeys, apiKey: "made-up", tenant: "acme", expected: http.StatusUnauthorized},
+		{name: "Admin key", keys: admin, apiKey: "admin-key", expected: http.StatusOK},
+		{name: "Admin key naming a tenant", keys: admin, apiKey: "admin-key", tenant: "acme", expected: http.StatusForbidden},
+		{name: "No tenants configured", keys: NewKeys(nil), expected: http.StatusOK},
+		{name: "Naming a tenant without tenants configured", keys: NewKeys(nil), tenant: "acme", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenant string
+			h := Scope(tt.keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant = scope.Tenant(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/mailboxes", nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			if tt.tenant != "" {
+				req.Header.Set("X-Tenant", tt.tenant)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expected, rec.Code, rec.Body)
+			}
+			if tenant != tt.expectedTenant {
+				t.Errorf("Expected tenant %q, got %q", tt.expectedTenant, tenant)
+			}
+		})
+	}
+}
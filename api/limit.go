@@ -0,0 +1,149 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits caps how fast and how much one client may call the API. Zero values
+// disable the corresponding limit.
+type Limits struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+	// DailyQuota is the number of requests allowed per UTC day.
+	DailyQuota int `mapstructure:"daily_quota"`
+}
+
+// Limiter enforces Limits per client. Clients with an entry in clients are
+// identified by their API key; every other caller, whatever key it sends, by
+// its remote address. Quota usage is kept in memory and resets when the
+// server restarts.
+type Limiter struct {
+	defaults Limits
+	clients  map[string]Limits
+	now      func() time.Time
+
+	mu         sync.Mutex
+	state      map[string]*clientState
+	lastPruned time.Time
+}
+
+type clientState struct {
+	limits   Limits
+	limiter  *rate.Limiter
+	day      string
+	used     int
+	lastSeen time.Time
+}
+
+// pruneInterval is how often Allow drops the state of idle clients.
+const pruneInterval = time.Minute
+
+// NewLimiter returns a Limiter applying defaults to every client except those
+// with their own entry in clients.
+func NewLimiter(defaults Limits, clients map[string]Limits) *Limiter {
+	return &Limiter{defaults: defaults, clients: clients, now: time.Now, state: map[string]*clientState{}}
+}
+
+// Allow records a request from client and reports whether it may proceed. If
+// not, it returns how long the client should wait and why.
+func (l *Limiter) Allow(client string) (bool, time.Duration, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits, ok := l.clients[client]
+	if !ok {
+		limits = l.defaults
+	}
+
+	now := l.now().UTC()
+	if now.Sub(l.lastPruned) >= pruneInterval {
+		l.prune(now)
+	}
+
+	st, ok := l.state[client]
+	if !ok {
+		st = &clientState{limits: limits, limiter: rate.NewLimiter(rate.Inf, 0)}
+		if limits.RequestsPerSecond > 0 {
+			st.limiter = rate.NewLimiter(rate.Limit(limits.RequestsPerSecond), max(limits.Burst, 1))
+		}
+		l.state[client] = st
+	}
+	st.lastSeen = now
+
+	if day := now.Format(time.DateOnly); st.day != day {
+		st.day, st.used = day, 0
+	}
+	if limits.DailyQuota > 0 && st.used >= limits.DailyQuota {
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return false, midnight.Sub(now), "daily quota exceeded"
+	}
+
+	r := st.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay, "rate limit exceeded"
+	}
+
+	st.used++
+	return true, 0, ""
+}
+
+// prune drops the state of clients that a fresh state would treat the same:
+// their rate limiter has refilled since their last request, and they have
+// used none of today's quota, if they have one. The caller holds l.mu.
+func (l *Limiter) prune(now time.Time) {
+	l.lastPruned = now
+	today := now.Format(time.DateOnly)
+	for client, st := range l.state {
+		if st.limits.DailyQuota > 0 && st.day == today && st.used > 0 {
+			continue
+		}
+		if st.limits.RequestsPerSecond > 0 {
+			refill := time.Duration(float64(max(st.limits.Burst, 1)) / st.limits.RequestsPerSecond * float64(time.Second))
+			if now.Sub(st.lastSeen) < refill {
+				continue
+			}
+		}
+		delete(l.state, client)
+	}
+}
+
+// Client returns the client a caller counts as: apiKey if it has limits of
+// its own, and otherwise its address, so sending made-up keys does not get
+// a caller fresh limits.
+func (l *Limiter) Client(apiKey, addr string) string {
+	if _, ok := l.clients[apiKey]; ok && apiKey != "" {
+		return apiKey
+	}
+	return "addr:" + addr
+}
+
+// Middleware rejects requests over their client's limits with 429 Too Many
+// Requests and a Retry-After header.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait, reason := l.Allow(l.Client(r.Header.Get("X-API-Key"), remoteHost(r)))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, reason)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteHost is the host part of the request's remote address.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Date(2024, 7, 23, 23, 59, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		limits         Limits
+		requests       int
+		expectedOK     int
+		expectedReason string
+	}{
+		{name: "Unlimited", requests: 100, expectedOK: 100},
+		{name: "Burst then rate limited", limits: Limits{RequestsPerSecond: 1, Burst: 3}, requests: 5, expectedOK: 3, expectedReason: "rate limit exceeded"},
+		{name: "Daily quota", limits: Limits{DailyQuota: 2}, requests: 5, expectedOK: 2, expectedReason: "daily quota exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.limits, nil)
+			l.now = func() time.Time { return now }
+
+			allowed, reason := 0, ""
+			for i := 0; i < tt.requests; i++ {
+				ok, _, why := l.Allow("client")
+				if ok {
+					allowed++
+				} else {
+					reason = why
+				}
+			}
+
+			if allowed != tt.expectedOK {
+				t.Errorf("Expected %d requests allowed, got %d", tt.expectedOK, allowed)
+			}
+			if reason != tt.expectedReason {
+				t.Errorf("Expected reason %q, got %q", tt.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestLimiter_QuotaResetsDaily(t *testing.T) {
+	now := time.Date(2024, 7, 23, 23, 59, 0, 0, time.UTC)
+
+	l := NewLimiter(Limits{}, map[string]Limits{"key": {DailyQuota: 1}})
+	l.now = func() time.Time { return now }
+
+	if ok, _, _ := l.Allow("key"); !ok {
+		t.Fatalf("Expected first request to be allowed")
+	}
+	ok, wait, _ := l.Allow("key")
+	if ok {
+		t.Fatalf("Expected second request to exceed the quota")
+	}
+	if wait != time.Minute {
+		t.Errorf("Expected to wait until midnight, got %s", wait)
+	}
+	if ok, _, _ := l.Allow("other"); !ok {
+		t.Errorf("Expected other clients to be unaffected")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _, _ := l.Allow("key"); !ok {
+		t.Errorf("Expected quota to reset at midnight")
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	l := NewLimiter(Limits{RequestsPerSecond: 1, Burst: 1}, nil)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/mailboxes", nil)
+	req.Header.Set("X-API-Key", "key")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+}
+
+func TestLimiter_UnknownKeysShareAddress(t *testing.T) {
+	l := NewLimiter(Limits{DailyQuota: 2}, map[string]Limits{"known": {DailyQuota: 1}})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	call := func(key, addr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/mailboxes", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := call(fmt.Sprintf("made-up-%d", i), "192.0.2.1:1234"); code != expected {
+			t.Errorf("Expected status %d for made-up key %d, got %d", expected, i, code)
+		}
+	}
+	if code := call("known", "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected a configured key to have its own quota, got %d", code)
+	}
+	if code := call("made-up", "192.0.2.2:1234"); code != http.StatusOK {
+		t.Errorf("Expected other addresses to be unaffected, got %d", code)
+	}
+}
+
+func TestLimiter_PrunesIdleClients(t *testing.T) {
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+
+	l := NewLimiter(Limits{RequestsPerSecond: 1, Burst: 10}, map[string]Limits{"quota": {DailyQuota: 5}})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		l.Allow(fmt.Sprintf("addr:192.0.2.%d", i))
+	}
+	l.Allow("quota")
+
+	// Still refilling: nothing is dropped.
+	now = now.Add(5 * time.Second)
+	l.Allow("addr:192.0.2.200")
+	if len(l.state) != 102 {
+		t.Fatalf("Expected 102 clients tracked, got %d", len(l.state))
+	}
+
+	now = now.Add(time.Minute)
+	l.Allow("addr:192.0.2.200")
+	if len(l.state) != 2 {
+		t.Errorf("Expected the idle clients dropped, leaving the quota user and the caller, got %d", len(l.state))
+	}
+
+	now = now.Add(24 * time.Hour)
+	l.Allow("addr:192.0.2.200")
+	if _, ok := l.state["quota"]; ok {
+		t.Errorf("Expected yesterday's quota usage dropped")
+	}
+}
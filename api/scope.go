@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"mailboxes/scope"
+)
+
+// defaultActor is the actor of requests that do not name one.
+const defaultActor = "api"
+
+// apiSource is the provenance recorded for users created through the API.
+const apiSource = "api"
+
+// Scope scopes each request's context to the tenant its X-API-Key is bound
+// to, and records the actor in its X-Actor header, or "api", so the store
+// and the mailbox event log see them without handlers passing them along.
+// An X-Tenant header must name the key's own tenant, and the key is kept
+// for routes that need an admin key. Requests keys rejects
+// get 401 Unauthorized, or 403 Forbidden for another tenant. Users created
+// through the API are recorded with the source "api".
+func Scope(keys *Keys, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := r.Header.Get("X-API-Key")
+		tenant, err := keys.Tenant(apiKey, r.Header.Get("X-Tenant"))
+		if errors.Is(err, ErrWrongTenant) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		actor := r.Header.Get("X-Actor")
+		if actor == "" {
+			actor = defaultActor
+		}
+		ctx := scope.WithActor(scope.WithTenant(r.Context(), tenant), actor)
+		ctx = scope.WithSource(ctx, apiSource)
+		if key, ok := keys.Lookup(apiKey); ok {
+			ctx = withKey(ctx, key)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mailboxes/db"
+)
+
+const auditUsage = "Usage: audit list [--since 24h|2006-01-02|RFC 3339 time] [--limit 0] [--json]"
+
+// auditCommand reads the audit_log: audit list prints the writes recorded
+// since a time, oldest first, for compliance review.
+func auditCommand(store db.Store, args []string) {
+	as, ok := store.(db.AuditStore)
+	if !ok {
+		fatal("Store does not keep an audit log")
+	}
+	if len(args) == 0 || args[0] != "list" {
+		fatal(auditUsage)
+	}
+
+	fs := flag.NewFlagSet("audit list", flag.ExitOnError)
+	since := fs.String("since", "24h", "list entries from this time on, or from this long ago")
+	limit := fs.Int("limit", 0, "list at most this many entries (0 for all)")
+	asJSON := fs.Bool("json", false, "print entries as JSON lines")
+	fs.Parse(args[1:])
+
+	from, err := parseSince(*since, time.Now())
+	if err != nil {
+		fatal("Invalid --since", "since", *since, "error", err)
+	}
+	entries, err := as.AuditLog(context.Background(), from, *limit)
+	if err != nil {
+		fatal("Error reading the audit log", "error", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				fatal("Error writing audit entry", "error", err)
+			}
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOCCURRED\tACTOR\tACTION\tENTITY\tBEFORE\tAFTER")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s %d\t%s\t%s\n", e.ID, e.OccurredAt.UTC().Format(time.RFC3339), e.Actor, e.Action,
+			e.Entity, e.EntityID, auditData(e.Before), auditData(e.After))
+	}
+	w.Flush()
+	slog.Info("Audit entries listed", "entries", len(entries), "since", from.UTC().Format(time.RFC3339))
+}
+
+// auditData prints an entity's JSON, or - where there is none.
+func auditData(data json.RawMessage) string {
+	if len(data) == 0 {
+		return "-"
+	}
+	return string(data)
+}
+
+// parseSince reads a point in time given as an RFC 3339 time, a date, or a
+// duration before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
@@ -0,0 +1,161 @@
+// Package bloom implements a Bloom filter that can be saved to and loaded
+// from disk. A filter answers "definitely not added" or "maybe added", so
+// it can stand in front of a slower exact lookup and skip it for most keys
+// that were never added.
+package bloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Filter is a Bloom filter. It is not safe for concurrent use.
+type Filter struct {
+	bits  []uint64
+	m     uint64
+	k     uint32
+	count uint64
+	built time.Time
+}
+
+// New returns an empty filter sized for n keys with a false positive rate
+// of about p once they are all added.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: k, built: time.Now()}
+}
+
+// Add adds key to the filter.
+func (f *Filter) Add(key []byte) {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// Test reports whether key may have been added. False means it certainly
+// was not.
+func (f *Filter) Test(key []byte) bool {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns how many keys have been added.
+func (f *Filter) Count() int {
+	return int(f.count)
+}
+
+// Built returns when the filter was created.
+func (f *Filter) Built() time.Time {
+	return f.built
+}
+
+// hashes derives the two hashes combined for each of the k bit positions.
+func hashes(key []byte) (uint64, uint64) {
+	a := fnv.New64a()
+	a.Write(key)
+	b := fnv.New64()
+	b.Write(key)
+	// An even second hash would visit only half the bits when m is even.
+	return a.Sum64(), b.Sum64() | 1
+}
+
+var magic = [4]byte{'M', 'B', 'B', 'F'}
+
+// header precedes the bits in a saved filter.
+type header struct {
+	Magic [4]byte
+	M     uint64
+	K     uint32
+	Count uint64
+	Built int64
+}
+
+// WriteTo writes f in the format read by ReadFrom.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	h := header{Magic: magic, M: f.m, K: f.k, Count: f.count, Built: f.built.Unix()}
+	if err := binary.Write(bw, binary.LittleEndian, h); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, f.bits); err != nil {
+		return 0, err
+	}
+	return int64(binary.Size(h) + 8*len(f.bits)), bw.Flush()
+}
+
+// ReadFrom reads a filter written by WriteTo.
+func ReadFrom(r io.Reader) (*Filter, error) {
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if h.Magic != magic || h.M == 0 || h.K == 0 || h.M > 1<<40 {
+		return nil, errors.New("not a bloom filter")
+	}
+
+	f := &Filter{bits: make([]uint64, (h.M+63)/64), m: h.M, k: h.K, count: h.Count, built: time.Unix(h.Built, 0)}
+	if err := binary.Read(r, binary.LittleEndian, f.bits); err != nil {
+		return nil, fmt.Errorf("reading bits: %w", err)
+	}
+	return f, nil
+}
+
+// Load reads the filter saved at path.
+func Load(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFrom(bufio.NewReader(file))
+}
+
+// Save writes f to path, replacing it atomically so a crash never leaves a
+// partial filter behind.
+func (f *Filter) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := f.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
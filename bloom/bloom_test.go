@@ -0,0 +1,70 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+func key(i int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(i))
+}
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(key(i))
+	}
+
+	for i := 0; i < n; i++ {
+		if !f.Test(key(i)) {
+			t.Fatalf("Expected key %d to be present", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if f.Test(key(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("Expected a false positive rate near 1%%, got %.2f%%", rate*100)
+	}
+	if f.Count() != n {
+		t.Errorf("Expected count %d, got %d", n, f.Count())
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	f := New(100, 0.01)
+	for i := 0; i < 100; i++ {
+		f.Add(key(i))
+	}
+
+	path := filepath.Join(t.TempDir(), "ledger.bloom")
+	if err := f.Save(path); err != nil {
+		t.Fatalf("Error saving filter: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading filter: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if !loaded.Test(key(i)) {
+			t.Fatalf("Expected key %d to survive a round trip", i)
+		}
+	}
+	if loaded.Count() != 100 || loaded.Built().Unix() != f.Built().Unix() {
+		t.Errorf("Expected count and build time to survive a round trip")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.bloom")
+	if _, err := Load(path); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,141 @@
+// Package breaker pauses processing while too many users are failing, so a
+// broken downstream isn't hammered with requests that will fail anyway and
+// users aren't pushed through their retries for nothing.
+package breaker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Config describes when a Breaker trips and for how long.
+type Config struct {
+	// Threshold is the share of failures, between 0 and 1, at which the
+	// breaker trips.
+	Threshold float64
+	// Window is how many of the latest outcomes the failure rate is taken
+	// over.
+	Window int
+	// MinSamples is how many outcomes must be recorded before the breaker
+	// can trip.
+	MinSamples int
+	// Pause is how long the breaker stays open once tripped.
+	Pause time.Duration
+}
+
+// Breaker tracks the failure rate over a sliding window of outcomes. When
+// it reaches the threshold the breaker opens and Wait blocks for the pause.
+// It then closes with an empty window, so the next outcomes decide whether
+// it trips again. A nil *Breaker never trips.
+type Breaker struct {
+	cfg Config
+
+	mu       sync.Mutex
+	outcomes []bool // ring of the latest outcomes, true for failures
+	next     int
+	failures int
+	until    time.Time
+	trips    int
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// New returns a Breaker for cfg, or nil if cfg.Threshold is not positive.
+// A zero Window defaults to 100, a zero MinSamples to a fifth of the
+// window and a zero Pause to a minute.
+func New(cfg Config) *Breaker {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 100
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = max(cfg.Window/5, 1)
+	}
+	cfg.MinSamples = min(cfg.MinSamples, cfg.Window)
+	if cfg.Pause <= 0 {
+		cfg.Pause = time.Minute
+	}
+	return &Breaker{cfg: cfg, outcomes: make([]bool, 0, cfg.Window), now: time.Now}
+}
+
+// Record adds an outcome to the window and trips the breaker if the failure
+// rate has reached the threshold. Outcomes recorded while it is open are
+// ignored.
+func (b *Breaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Before(b.until) {
+		return
+	}
+
+	if len(b.outcomes) < b.cfg.Window {
+		b.outcomes = append(b.outcomes, failed)
+	} else {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % b.cfg.Window
+	}
+	if failed {
+		b.failures++
+	}
+
+	if len(b.outcomes) < b.cfg.MinSamples {
+		return
+	}
+	rate := float64(b.failures) / float64(len(b.outcomes))
+	if rate < b.cfg.Threshold {
+		return
+	}
+
+	b.trips++
+	b.until = now.Add(b.cfg.Pause)
+	b.outcomes, b.next, b.failures = b.outcomes[:0], 0, 0
+	slog.Warn("Circuit breaker open; pausing the pipeline", "failure_rate", rate, "threshold", b.cfg.Threshold,
+		"pause", b.cfg.Pause, "trips", b.trips)
+}
+
+// Wait blocks while the breaker is open, and returns ctx's error if ctx is
+// done first.
+func (b *Breaker) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		wait := b.until.Sub(b.now())
+		b.mu.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Trips returns how many times the breaker has opened.
+func (b *Breaker) Trips() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.trips
+}
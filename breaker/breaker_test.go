@@ -0,0 +1,61 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+	b := New(Config{Threshold: 0.5, Window: 10, MinSamples: 4, Pause: time.Minute})
+	b.now = func() time.Time { return now }
+
+	// Too few samples to trip.
+	for i := 0; i < 3; i++ {
+		b.Record(true)
+	}
+	if b.Trips() != 0 {
+		t.Fatalf("Expected the breaker to stay closed below MinSamples")
+	}
+	b.Record(true)
+	if b.Trips() != 1 {
+		t.Fatalf("Expected the breaker to trip at 4 failures of 4")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Wait to block while open, got %v", err)
+	}
+
+	// Outcomes while open are ignored; after the pause the window is empty.
+	b.Record(true)
+	now = now.Add(time.Minute)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Errorf("Expected Wait to return once the pause is over, got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		b.Record(i == 4 || i == 6 || i >= 8)
+	}
+	if b.Trips() != 1 {
+		t.Errorf("Expected the breaker to stay closed at 4 failures of 10, got %d trips", b.Trips())
+	}
+	// The oldest outcome, a success, leaves the window.
+	b.Record(true)
+	if b.Trips() != 2 {
+		t.Errorf("Expected the breaker to trip again at 5 failures of 10, got %d trips", b.Trips())
+	}
+}
+
+func TestBreaker_Nil(t *testing.T) {
+	b := New(Config{})
+	if b != nil {
+		t.Fatalf("Expected no breaker without a threshold")
+	}
+	b.Record(true)
+	if err := b.Wait(context.Background()); err != nil || b.Trips() != 0 {
+		t.Errorf("Expected a nil breaker to do nothing")
+	}
+}
@@ -0,0 +1,98 @@
+// Package budget aborts a run once its users fail too often: more than a
+// set share of those handled, or too many in a row. Unlike the breaker,
+// which pauses and tries again, an exceeded budget ends the run, so a
+// misconfigured sink fails fast instead of sending a night's users to the
+// retry queue.
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrExceeded is why a run was aborted by its failure budget.
+var ErrExceeded = errors.New("failure budget exceeded")
+
+// Config describes when a Budget is exceeded. Zero disables a threshold.
+type Config struct {
+	// MaxRate is the share of handled users, between 0 and 1, that may
+	// fail.
+	MaxRate float64
+	// MinUsers is how many users must be handled before MaxRate applies.
+	MinUsers int
+	// MaxConsecutive is how many users may fail in a row.
+	MaxConsecutive int
+}
+
+// Budget counts the users of a run that failed. Once a threshold is
+// exceeded it calls abort, once, with an error wrapping ErrExceeded. It is
+// safe for concurrent use, and a nil *Budget is never exceeded.
+type Budget struct {
+	cfg   Config
+	abort func(error)
+
+	mu          sync.Mutex
+	handled     int
+	failed      int
+	consecutive int
+	exceeded    bool
+}
+
+// New returns a Budget for cfg that calls abort when exceeded, or nil if
+// cfg sets no threshold. A zero MinUsers defaults to 100.
+func New(cfg Config, abort func(error)) *Budget {
+	if cfg.MaxRate <= 0 && cfg.MaxConsecutive <= 0 {
+		return nil
+	}
+	if cfg.MinUsers <= 0 {
+		cfg.MinUsers = 100
+	}
+	return &Budget{cfg: cfg, abort: abort}
+}
+
+// Record adds the outcome of one handled user.
+func (b *Budget) Record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.handled++
+	if failed {
+		b.failed++
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+	var err error
+	switch {
+	case b.exceeded:
+	case b.cfg.MaxConsecutive > 0 && b.consecutive >= b.cfg.MaxConsecutive:
+		err = fmt.Errorf("%w: %d users failed in a row", ErrExceeded, b.consecutive)
+	case b.cfg.MaxRate > 0 && b.handled >= b.cfg.MinUsers && float64(b.failed)/float64(b.handled) > b.cfg.MaxRate:
+		err = fmt.Errorf("%w: %d of %d users failed, more than %g", ErrExceeded, b.failed, b.handled, b.cfg.MaxRate)
+	}
+	if err != nil {
+		b.exceeded = true
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		b.abort(err)
+	}
+}
+
+type budgetKey struct{}
+
+// NewContext returns ctx carrying b, for the users handled on its run.
+func NewContext(ctx context.Context, b *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// FromContext returns the Budget ctx carries, or nil.
+func FromContext(ctx context.Context) *Budget {
+	b, _ := ctx.Value(budgetKey{}).(*Budget)
+	return b
+}
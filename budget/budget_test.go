@@ -0,0 +1,55 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBudget_MaxConsecutive(t *testing.T) {
+	var aborted []error
+	b := New(Config{MaxConsecutive: 3}, func(err error) { aborted = append(aborted, err) })
+
+	for _, failed := range []bool{true, true, false, true, true} {
+		b.Record(failed)
+	}
+	if len(aborted) != 0 {
+		t.Fatalf("Expected no abort before 3 failures in a row, got %v", aborted)
+	}
+	b.Record(true)
+	b.Record(true)
+	if len(aborted) != 1 || !errors.Is(aborted[0], ErrExceeded) {
+		t.Fatalf("Expected one abort wrapping ErrExceeded, got %v", aborted)
+	}
+}
+
+func TestBudget_MaxRate(t *testing.T) {
+	var aborted error
+	b := New(Config{MaxRate: 0.2, MinUsers: 10}, func(err error) { aborted = err })
+
+	// Every other user fails, but the rate only counts from the tenth.
+	for i := 0; i < 9; i++ {
+		b.Record(i%2 == 0)
+	}
+	if aborted != nil {
+		t.Fatalf("Expected no abort before MinUsers, got %v", aborted)
+	}
+	b.Record(false)
+	if !errors.Is(aborted, ErrExceeded) {
+		t.Fatalf("Expected an abort at 5 of 10 users failed, got %v", aborted)
+	}
+}
+
+func TestBudget_Disabled(t *testing.T) {
+	if b := New(Config{MinUsers: 5}, nil); b != nil {
+		t.Fatalf("Expected no budget without a threshold")
+	}
+	var b *Budget
+	b.Record(true)
+	if FromContext(NewContext(context.Background(), b)) != nil {
+		t.Errorf("Expected a nil budget from the context")
+	}
+	if FromContext(context.Background()) != nil {
+		t.Errorf("Expected no budget in a bare context")
+	}
+}
@@ -0,0 +1,176 @@
+// Package cache keeps recently read mailboxes' users in memory in front of
+// a db.Store, and counts how often each mailbox is read so the busiest ones
+// can be loaded ahead of demand after a restart. Redis instead keeps
+// mailboxes and their users in Redis, shared between instances.
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/scope"
+)
+
+// Store is a db.Store whose UsersForMailbox results are cached for ttl, for
+// up to maxEntries mailboxes. Each is kept per tenant, as reads scoped to a
+// tenant see only its rows. Other methods go straight to the wrapped store.
+// It is safe for concurrent use.
+type Store struct {
+	db.Store
+
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[entryKey]entry
+	hits    map[int]int
+}
+
+// entryKey names the users of a mailbox as read by a tenant.
+type entryKey struct {
+	tenant    string
+	mailboxID int
+}
+
+type entry struct {
+	users    []db.User
+	loadedAt time.Time
+}
+
+// New returns a cache in front of store. A zero maxEntries defaults to
+// 1000 mailboxes.
+func New(store db.Store, ttl time.Duration, maxEntries int) *Store {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &Store{
+		Store:      store,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[entryKey]entry{},
+		hits:       map[int]int{},
+	}
+}
+
+// UsersForMailbox serves the mailbox's users from the cache if they were
+// loaded within ttl for ctx's tenant, and otherwise loads and caches them.
+// Every call counts as an access to the mailbox.
+func (c *Store) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	c.mu.Lock()
+	c.hits[mailboxID]++
+	e, ok := c.entries[entryKey{scope.Tenant(ctx), mailboxID}]
+	fresh := ok && c.now().Sub(e.loadedAt) < c.ttl
+	c.mu.Unlock()
+
+	if !fresh {
+		users, err := c.load(ctx, mailboxID)
+		if err != nil {
+			return nil, err
+		}
+		e = entry{users: users}
+	}
+	return stream(ctx, e.users), nil
+}
+
+// load reads a mailbox's users from the wrapped store and caches them for
+// ctx's tenant.
+func (c *Store) load(ctx context.Context, mailboxID int) ([]db.User, error) {
+	userChan, err := c.Store.UsersForMailbox(ctx, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+	users := []db.User{}
+	for user := range userChan {
+		users = append(users, user)
+	}
+	// A cancelled read may have stopped early; don't cache a partial list.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key := entryKey{scope.Tenant(ctx), mailboxID}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = entry{users: users, loadedAt: c.now()}
+	return users, nil
+}
+
+// evictOldest drops the entry loaded longest ago. The caller holds c.mu.
+func (c *Store) evictOldest() {
+	var oldest entryKey
+	found := false
+	for key, e := range c.entries {
+		if !found || e.loadedAt.Before(c.entries[oldest].loadedAt) {
+			oldest, found = key, true
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// Invalidate drops a mailbox's cached users for every tenant.
+func (c *Store) Invalidate(mailboxID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.mailboxID == mailboxID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// InvalidateMailboxes does nothing: the Store does not cache mailboxes.
+func (c *Store) InvalidateMailboxes() {}
+
+// Warm loads the given mailboxes into the cache for ctx's tenant, stopping
+// at the first error, and returns how many were loaded. Warming does not count as
+// access.
+func (c *Store) Warm(ctx context.Context, mailboxIDs []int) (int, error) {
+	for i, id := range mailboxIDs {
+		if _, err := c.load(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(mailboxIDs), nil
+}
+
+// Flush adds the accesses counted since the last flush to store. If that
+// fails they are kept for the next attempt.
+func (c *Store) Flush(ctx context.Context, store db.AccessStore) error {
+	c.mu.Lock()
+	hits := c.hits
+	c.hits = map[int]int{}
+	c.mu.Unlock()
+
+	if err := store.RecordMailboxAccess(ctx, hits); err != nil {
+		c.mu.Lock()
+		for id, n := range hits {
+			c.hits[id] += n
+		}
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// stream sends items on a channel until ctx is done.
+func stream[T any](ctx context.Context, items []T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, item := range items {
+			select {
+			case ch <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
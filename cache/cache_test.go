@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/scope"
+)
+
+// countingStore counts reads of each mailbox's users.
+type countingStore struct {
+	*db.MemStore
+	reads map[int]int
+}
+
+func (s *countingStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	s.reads[mailboxID]++
+	return s.MemStore.UsersForMailbox(ctx, mailboxID)
+}
+
+type accessStore struct {
+	hits map[int]int
+	err  error
+}
+
+func (s *accessStore) RecordMailboxAccess(ctx context.Context, hits map[int]int) error {
+	if s.err != nil {
+		return s.err
+	}
+	for id, n := range hits {
+		s.hits[id] += n
+	}
+	return nil
+}
+
+func (s *accessStore) HotMailboxes(ctx context.Context, n int) ([]int, error) {
+	return nil, nil
+}
+
+func newCountingStore() *countingStore {
+	store := db.NewMemStore()
+	store.SeedUsers(
+		db.User{ID: 101, MailboxID: 1}, db.User{ID: 102, MailboxID: 1},
+		db.User{ID: 201, MailboxID: 2},
+		db.User{ID: 301, MailboxID: 3},
+	)
+	return &countingStore{MemStore: store, reads: map[int]int{}}
+}
+
+func readUsers(t *testing.T, c *Store, mailboxID int) []db.User {
+	t.Helper()
+	ch, err := c.UsersForMailbox(context.Background(), mailboxID)
+	if err != nil {
+		t.Fatalf("Error reading users: %v", err)
+	}
+	var users []db.User
+	for user := range ch {
+		users = append(users, user)
+	}
+	return users
+}
+
+func TestStore_UsersForMailbox(t *testing.T) {
+	backing := newCountingStore()
+	c := New(backing, time.Minute, 2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		expected := []db.User{{ID: 101, MailboxID: 1}, {ID: 102, MailboxID: 1}}
+		if users := readUsers(t, c, 1); !reflect.DeepEqual(users, expected) {
+			t.Fatalf("Expected %v, got %v", expected, users)
+		}
+	}
+	if backing.reads[1] != 1 {
+		t.Errorf("Expected one read of mailbox 1, got %d", backing.reads[1])
+	}
+
+	// Expired entries are loaded again.
+	now = now.Add(2 * time.Minute)
+	readUsers(t, c, 1)
+	if backing.reads[1] != 2 {
+		t.Errorf("Expected mailbox 1 to be read again after expiry, got %d reads", backing.reads[1])
+	}
+
+	// A third mailbox evicts the oldest of the two cached.
+	now = now.Add(time.Second)
+	readUsers(t, c, 2)
+	now = now.Add(time.Second)
+	readUsers(t, c, 3)
+	readUsers(t, c, 1)
+	if backing.reads[1] != 3 || backing.reads[3] != 1 {
+		t.Errorf("Expected mailbox 1 to have been evicted, got reads %v", backing.reads)
+	}
+}
+
+func TestStore_Tenants(t *testing.T) {
+	backing := newCountingStore()
+	c := New(backing, time.Minute, 0)
+	readAcme := func() {
+		t.Helper()
+		ch, err := c.UsersForMailbox(scope.WithTenant(context.Background(), "acme"), 1)
+		if err != nil {
+			t.Fatalf("Error reading users: %v", err)
+		}
+		for range ch {
+		}
+	}
+
+	readUsers(t, c, 1)
+	readAcme()
+	if backing.reads[1] != 2 {
+		t.Errorf("Expected a tenant's read to miss the unscoped entry, got %d reads", backing.reads[1])
+	}
+
+	c.Invalidate(1)
+	readUsers(t, c, 1)
+	readAcme()
+	if backing.reads[1] != 4 {
+		t.Errorf("Expected invalidating to drop every tenant's entry, got %d reads", backing.reads[1])
+	}
+}
+
+func TestStore_WarmAndFlush(t *testing.T) {
+	backing := newCountingStore()
+	c := New(backing, time.Minute, 0)
+
+	n, err := c.Warm(context.Background(), []int{1, 2})
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 mailboxes warmed, got %d, %v", n, err)
+	}
+	readUsers(t, c, 1)
+	readUsers(t, c, 1)
+	readUsers(t, c, 2)
+	if backing.reads[1] != 1 || backing.reads[2] != 1 {
+		t.Errorf("Expected warmed mailboxes to be served from the cache, got reads %v", backing.reads)
+	}
+
+	failing := &accessStore{hits: map[int]int{}, err: errors.New("down")}
+	if err := c.Flush(context.Background(), failing); err == nil {
+		t.Fatalf("Expected the flush to fail")
+	}
+
+	as := &accessStore{hits: map[int]int{}}
+	if err := c.Flush(context.Background(), as); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if expected := map[int]int{1: 2, 2: 1}; !reflect.DeepEqual(as.hits, expected) {
+		t.Errorf("Expected hits %v kept across the failed flush, got %v", expected, as.hits)
+	}
+}
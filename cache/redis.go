@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/scope"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidateTimeout bounds the Redis calls of Invalidate and
+// InvalidateMailboxes, which have no context of their own.
+const invalidateTimeout = 5 * time.Second
+
+// Redis is a db.Store whose AllMailboxes and UsersForMailbox results are
+// cached in Redis for ttl, so that every instance and every run shares them.
+// Each is kept per tenant, as reads scoped to a tenant see only its rows.
+// Other methods go straight to the wrapped store. A cache that cannot be
+// reached is logged and read around.
+type Redis struct {
+	db.Store
+
+	client *redis.Client
+	ttl    time.Duration
+	prefix string
+}
+
+// NewRedis returns a cache in Redis in front of store, with its keys under
+// prefix.
+func NewRedis(store db.Store, client *redis.Client, ttl time.Duration, prefix string) *Redis {
+	return &Redis{Store: store, client: client, ttl: ttl, prefix: prefix}
+}
+
+func (c *Redis) mailboxesKey() string {
+	return c.prefix + "mailboxes"
+}
+
+func (c *Redis) usersKey(mailboxID int) string {
+	return c.prefix + "users:" + strconv.Itoa(mailboxID)
+}
+
+// AllMailboxes serves the mailboxes from the cache if they were loaded within
+// ttl, and otherwise loads and caches them.
+func (c *Redis) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	var mailboxes []db.Mailbox
+	if c.get(ctx, c.mailboxesKey(), &mailboxes) {
+		return stream(ctx, mailboxes), nil
+	}
+	mbChan, err := c.Store.AllMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mailboxes = []db.Mailbox{}
+	for mb := range mbChan {
+		mailboxes = append(mailboxes, mb)
+	}
+	// A cancelled read may have stopped early; don't cache a partial list.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.set(ctx, c.mailboxesKey(), mailboxes)
+	return stream(ctx, mailboxes), nil
+}
+
+// UsersForMailbox serves the mailbox's users from the cache if they were
+// loaded within ttl, and otherwise loads and caches them.
+func (c *Redis) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	var users []db.User
+	if c.get(ctx, c.usersKey(mailboxID), &users) {
+		return stream(ctx, users), nil
+	}
+	userChan, err := c.Store.UsersForMailbox(ctx, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+	users = []db.User{}
+	for user := range userChan {
+		users = append(users, user)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.set(ctx, c.usersKey(mailboxID), users)
+	return stream(ctx, users), nil
+}
+
+// get decodes the cached value of key for ctx's tenant into v, reporting
+// false on a miss.
+func (c *Redis) get(ctx context.Context, key string, v any) bool {
+	data, err := c.client.HGet(ctx, key, scope.Tenant(ctx)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false
+	}
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		slog.Error("Error reading from the cache", "key", key, "error", err)
+		return false
+	}
+	return true
+}
+
+// set caches v as the value of key for ctx's tenant. The values of every
+// tenant expire together, ttl after the first of them was cached.
+func (c *Redis) set(ctx context.Context, key string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error encoding for the cache", "key", key, "error", err)
+		return
+	}
+	pipe := c.client.TxPipeline()
+	pipe.HSet(ctx, key, scope.Tenant(ctx), data)
+	pipe.ExpireNX(ctx, key, c.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Error writing to the cache", "key", key, "error", err)
+	}
+}
+
+// Invalidate drops a mailbox's cached users.
+func (c *Redis) Invalidate(mailboxID int) {
+	c.del(c.usersKey(mailboxID))
+}
+
+// InvalidateMailboxes drops the cached mailboxes.
+func (c *Redis) InvalidateMailboxes() {
+	c.del(c.mailboxesKey())
+}
+
+// Clear drops everything cached, for writes that touch mailboxes it cannot
+// name.
+func (c *Redis) Clear() {
+	ctx, cancel := context.WithTimeout(context.Background(), invalidateTimeout)
+	defer cancel()
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", 1000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	err := iter.Err()
+	if err == nil && len(keys) > 0 {
+		err = c.client.Del(ctx, keys...).Err()
+	}
+	if err != nil {
+		slog.Error("Error clearing the cache", "error", err)
+	}
+}
+
+func (c *Redis) del(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), invalidateTimeout)
+	defer cancel()
+	if err := c.client.Del(ctx, key).Err(); err != nil {
+		slog.Error("Error dropping from the cache", "key", key, "error", err)
+	}
+}
+
+// Ping reports whether Redis can be reached.
+func (c *Redis) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/scope"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// countingMailboxes counts reads of the mailboxes and each mailbox's users.
+type countingMailboxes struct {
+	*countingStore
+	mailboxReads int
+}
+
+func (s *countingMailboxes) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	s.mailboxReads++
+	return s.MemStore.AllMailboxes(ctx)
+}
+
+func newRedisCache(t *testing.T) (*Redis, *countingMailboxes, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store := &countingMailboxes{countingStore: newCountingStore()}
+	store.SeedMailboxes(db.Mailbox{ID: 1, MPIID: "mpi1"}, db.Mailbox{ID: 2, MPIID: "mpi2"})
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(store, client, time.Minute, "test:"), store, mr
+}
+
+func cachedUsers(t *testing.T, ctx context.Context, c *Redis, mailboxID int) []db.User {
+	t.Helper()
+	ch, err := c.UsersForMailbox(ctx, mailboxID)
+	if err != nil {
+		t.Fatalf("Error reading users: %v", err)
+	}
+	var users []db.User
+	for user := range ch {
+		users = append(users, user)
+	}
+	return users
+}
+
+func cachedMailboxes(t *testing.T, c *Redis) []db.Mailbox {
+	t.Helper()
+	ch, err := c.AllMailboxes(context.Background())
+	if err != nil {
+		t.Fatalf("Error reading mailboxes: %v", err)
+	}
+	var mailboxes []db.Mailbox
+	for mb := range ch {
+		mailboxes = append(mailboxes, mb)
+	}
+	return mailboxes
+}
+
+func TestRedis_UsersForMailbox(t *testing.T) {
+	c, store, mr := newRedisCache(t)
+	ctx := context.Background()
+
+	first := cachedUsers(t, ctx, c, 1)
+	second := cachedUsers(t, ctx, c, 1)
+	if len(first) != 2 || !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected the same two users twice, got %v and %v", first, second)
+	}
+	if store.reads[1] != 1 {
+		t.Errorf("Expected one read of mailbox 1, got %d", store.reads[1])
+	}
+
+	// Tenants are cached apart.
+	cachedUsers(t, scope.WithTenant(ctx, "acme"), c, 1)
+	if store.reads[1] != 2 {
+		t.Errorf("Expected a tenant's read to miss, got %d reads", store.reads[1])
+	}
+
+	c.Invalidate(1)
+	cachedUsers(t, ctx, c, 1)
+	if store.reads[1] != 3 {
+		t.Errorf("Expected a read after invalidating, got %d reads", store.reads[1])
+	}
+
+	mr.FastForward(2 * time.Minute)
+	cachedUsers(t, ctx, c, 1)
+	if store.reads[1] != 4 {
+		t.Errorf("Expected a read after the TTL, got %d reads", store.reads[1])
+	}
+}
+
+func TestRedis_AllMailboxes(t *testing.T) {
+	c, store, _ := newRedisCache(t)
+
+	first := cachedMailboxes(t, c)
+	second := cachedMailboxes(t, c)
+	if len(first) == 0 || !reflect.DeepEqual(first, second) || store.mailboxReads != 1 {
+		t.Errorf("Expected one read of the same mailboxes, got %d reads of %v and %v", store.mailboxReads, first, second)
+	}
+	c.InvalidateMailboxes()
+	cachedMailboxes(t, c)
+	if store.mailboxReads != 2 {
+		t.Errorf("Expected a read after invalidating, got %d reads", store.mailboxReads)
+	}
+}
+
+func TestRedis_Clear(t *testing.T) {
+	c, store, mr := newRedisCache(t)
+	ctx := context.Background()
+	mr.Set("other:key", "kept")
+
+	cachedUsers(t, ctx, c, 1)
+	cachedMailboxes(t, c)
+	c.Clear()
+	cachedUsers(t, ctx, c, 1)
+	cachedMailboxes(t, c)
+	if store.reads[1] != 2 || store.mailboxReads != 2 {
+		t.Errorf("Expected reads after clearing, got %d and %d", store.reads[1], store.mailboxReads)
+	}
+	if !mr.Exists("other:key") {
+		t.Errorf("Expected keys outside the prefix to be kept")
+	}
+}
+
+func TestRedis_Unreachable(t *testing.T) {
+	c, store, mr := newRedisCache(t)
+	mr.Close()
+
+	users := cachedUsers(t, context.Background(), c, 2)
+	if len(users) != 1 || store.reads[2] != 1 {
+		t.Errorf("Expected to read around an unreachable cache, got %v", users)
+	}
+}
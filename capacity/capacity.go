@@ -0,0 +1,92 @@
+// Package capacity turns database statistics into reports for capacity
+// reviews. Each report can be saved as a snapshot, so the next one shows how
+// much every table grew in between.
+package capacity
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Growth is how much a table changed since the previous snapshot. Tables
+// that did not exist then grow from zero.
+type Growth struct {
+	Table      string `json:"table"`
+	Rows       int64  `json:"rows"`
+	TableBytes int64  `json:"table_bytes"`
+	IndexBytes int64  `json:"index_bytes"`
+}
+
+// Report is a set of statistics taken at one time. Since and Growth are only
+// set when there was a previous snapshot to compare with.
+type Report struct {
+	TakenAt time.Time `json:"taken_at"`
+	db.Stats
+	Since  *time.Time `json:"since,omitempty"`
+	Growth []Growth   `json:"growth,omitempty"`
+}
+
+// New builds a report from stats taken at now, comparing it with prev if
+// there is one.
+func New(stats db.Stats, now time.Time, prev *Report) Report {
+	r := Report{TakenAt: now.UTC(), Stats: stats}
+	if prev == nil {
+		return r
+	}
+
+	before := make(map[string]db.TableStats, len(prev.Tables))
+	for _, t := range prev.Tables {
+		before[t.Name] = t
+	}
+
+	since := prev.TakenAt
+	r.Since = &since
+	for _, t := range stats.Tables {
+		b := before[t.Name]
+		r.Growth = append(r.Growth, Growth{
+			Table:      t.Name,
+			Rows:       t.Rows - b.Rows,
+			TableBytes: t.TableBytes - b.TableBytes,
+			IndexBytes: t.IndexBytes - b.IndexBytes,
+		})
+	}
+	return r
+}
+
+// Load reads the snapshot at path, or returns nil if none has been saved.
+func Load(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Save writes r to path as the snapshot for the next report. Growth is not
+// saved, only the statistics it is computed from.
+func Save(path string, r Report) error {
+	r.Since, r.Growth = nil, nil
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,69 @@
+package capacity
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func TestNew(t *testing.T) {
+	then := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	prev := &Report{TakenAt: then, Stats: db.Stats{Tables: []db.TableStats{
+		{Name: "users", Rows: 100, TableBytes: 8192, IndexBytes: 4096},
+	}}}
+	stats := db.Stats{Tables: []db.TableStats{
+		{Name: "retry_queue", Rows: 3},
+		{Name: "users", Rows: 150, TableBytes: 16384, IndexBytes: 4096},
+	}}
+
+	r := New(stats, now, prev)
+	if r.Since == nil || !r.Since.Equal(then) {
+		t.Errorf("Expected growth since %s, got %v", then, r.Since)
+	}
+	expected := []Growth{
+		{Table: "retry_queue", Rows: 3},
+		{Table: "users", Rows: 50, TableBytes: 8192},
+	}
+	if !reflect.DeepEqual(r.Growth, expected) {
+		t.Errorf("Expected growth %+v, got %+v", expected, r.Growth)
+	}
+
+	if first := New(stats, now, nil); first.Since != nil || first.Growth != nil {
+		t.Errorf("Expected no growth without a previous snapshot, got %+v", first)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	if r, err := Load(path); r != nil || err != nil {
+		t.Fatalf("Expected no snapshot yet, got %v, %v", r, err)
+	}
+
+	r := Report{
+		TakenAt: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+		Stats: db.Stats{
+			DatabaseBytes:    1 << 20,
+			Tables:           []db.TableStats{{Name: "users", Rows: 150}},
+			LargestMailboxes: []db.MailboxSize{{MailboxID: 1, Users: 120}},
+		},
+		Growth: []Growth{{Table: "users", Rows: 50}},
+	}
+	if err := Save(path, r); err != nil {
+		t.Fatalf("Error saving snapshot: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error loading snapshot: %v", err)
+	}
+	r.Growth = nil
+	if !reflect.DeepEqual(*loaded, r) {
+		t.Errorf("Expected %+v, got %+v", r, *loaded)
+	}
+}
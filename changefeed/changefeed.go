@@ -0,0 +1,40 @@
+// Package changefeed delivers field-level user changes from a store's outbox
+// to sinks, so downstream caches can update incrementally instead of
+// re-syncing.
+package changefeed
+
+import (
+	"context"
+	"time"
+
+	"mailboxes/aimd"
+	"mailboxes/db"
+	"mailboxes/sink"
+)
+
+// Relay sends every change after afterID to s as []db.UserChange batches
+// sized by batches, oldest first, and returns the ID of the last change
+// delivered. Each delivery's latency and error are fed back to batches. It
+// stops at the first failed batch, so the caller can resume from the
+// returned ID.
+func Relay(ctx context.Context, store db.ChangeFeedStore, s sink.Sink, afterID int, batches *aimd.Sizer) (int, error) {
+	for {
+		batchSize := batches.Size()
+		changes, err := store.UserChangesAfter(ctx, afterID, batchSize)
+		if err != nil || len(changes) == 0 {
+			return afterID, err
+		}
+
+		start := time.Now()
+		err = s.Send(ctx, changes)
+		batches.Observe(time.Since(start), err)
+		if err != nil {
+			return afterID, err
+		}
+		afterID = changes[len(changes)-1].ID
+
+		if len(changes) < batchSize {
+			return afterID, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package changefeed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"mailboxes/aimd"
+	"mailboxes/db"
+)
+
+type fakeStore struct {
+	changes []db.UserChange
+}
+
+func (f *fakeStore) UserChangesAfter(ctx context.Context, afterID, limit int) ([]db.UserChange, error) {
+	var changes []db.UserChange
+	for _, c := range f.changes {
+		if c.ID > afterID && len(changes) < limit {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+type fakeSink struct {
+	batches [][]db.UserChange
+	failAt  int
+}
+
+func (f *fakeSink) Send(ctx context.Context, payload any) error {
+	if f.failAt > 0 && len(f.batches)+1 == f.failAt {
+		return errors.New("sink unavailable")
+	}
+	f.batches = append(f.batches, payload.([]db.UserChange))
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	store := &fakeStore{changes: []db.UserChange{
+		{ID: 1, UserID: 101, Op: db.ChangeUpdate, Field: "user_name"},
+		{ID: 2, UserID: 101, Op: db.ChangeUpdate, Field: "email_address"},
+		{ID: 3, UserID: 102, Op: db.ChangeDelete, Field: "user_name"},
+	}}
+
+	tests := []struct {
+		name            string
+		afterID         int
+		failAt          int
+		expectedLast    int
+		expectedBatches int
+		expectErr       bool
+	}{
+		{name: "Delivers all in batches", expectedLast: 3, expectedBatches: 2},
+		{name: "Resumes after cursor", afterID: 2, expectedLast: 3, expectedBatches: 1},
+		{name: "Stops at failed batch", failAt: 2, expectedLast: 2, expectedBatches: 1, expectErr: true},
+		{name: "Nothing new", afterID: 3, expectedLast: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{failAt: tt.failAt}
+
+			last, err := Relay(context.Background(), store, sink, tt.afterID, aimd.Fixed(2))
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectErr, err)
+			}
+			if last != tt.expectedLast {
+				t.Errorf("Expected last change %d, got %d", tt.expectedLast, last)
+			}
+			if len(sink.batches) != tt.expectedBatches {
+				t.Errorf("Expected %d batches, got %d", tt.expectedBatches, len(sink.batches))
+			}
+		})
+	}
+}
+
+func TestRelay_AdaptsBatchSize(t *testing.T) {
+	store := &fakeStore{}
+	for i := 1; i <= 10; i++ {
+		store.changes = append(store.changes, db.UserChange{ID: i, UserID: 100 + i, Op: db.ChangeUpdate, Field: "user_name"})
+	}
+	batches := aimd.New(aimd.Config{Min: 1, Max: 8, Initial: 2, Step: 2, Target: time.Minute})
+
+	sink := &fakeSink{failAt: 3}
+	last, err := Relay(context.Background(), store, sink, 0, batches)
+	if err == nil || last != 6 {
+		t.Fatalf("Expected to stop after change 6, got %d, %v", last, err)
+	}
+	if sizes := []int{len(sink.batches[0]), len(sink.batches[1])}; sizes[0] != 2 || sizes[1] != 4 {
+		t.Errorf("Expected batches to grow from 2 to 4, got %v", sizes)
+	}
+	if batches.Size() != 3 {
+		t.Errorf("Expected the failed batch of 6 to halve the size, got %d", batches.Size())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mailboxes/aimd"
+	"mailboxes/changefeed"
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// changesCommand delivers recorded user changes to the sink configured under
+// sinks.<name>. Progress is kept in the watermark "changes.<name>", whose
+// user ID holds the last delivered change ID. With
+// sinks.<name>.batch.target_latency set, batches start at --batch and adapt
+// to the sink's latency within sinks.<name>.batch.min and .max.
+func changesCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("changes", flag.ExitOnError)
+	name := fs.String("sink", "changes", "sink to deliver to, configured under sinks.<name>")
+	follow := fs.Bool("follow", false, "keep polling for new changes until interrupted")
+	interval := fs.Duration("interval", 5*time.Second, "how often to poll with --follow")
+	batchSize := fs.Int("batch", 500, "changes per delivered batch, or the first batch size when adapting")
+	fs.Parse(args)
+
+	cs, ok := store.(db.ChangeFeedStore)
+	if !ok {
+		fatal("Store does not record user changes")
+	}
+	ws, ok := store.(db.WatermarkStore)
+	if !ok {
+		fatal("Store does not support watermarks")
+	}
+
+	sink, err := openSink(*name)
+	if err != nil {
+		fatal("Error configuring sink", "sink", *name, "error", err)
+	}
+
+	batches := batchSizer("sinks."+*name+".batch", *batchSize)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cursor := "changes." + *name
+	wm, err := ws.Watermark(ctx, cursor)
+	if err != nil {
+		fatal("Error reading cursor", "cursor", cursor, "error", err)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		last, err := changefeed.Relay(ctx, cs, sink, wm.UserID, batches)
+		if last != wm.UserID {
+			slog.Info("Delivered changes", "from_seq", wm.UserID+1, "to_seq", last, "sink", *name, "next_batch", batches.Size())
+			wm.UserID = last
+			// Delivered changes are recorded even when interrupted.
+			if err := ws.SaveWatermark(context.WithoutCancel(ctx), cursor, wm); err != nil {
+				fatal("Error saving cursor", "cursor", cursor, "error", err)
+			}
+		}
+		if err != nil {
+			if !*follow {
+				fatal("Error delivering changes to sink", "sink", *name, "error", err)
+			}
+			slog.Error("Error delivering changes to sink", "sink", *name, "error", err)
+		}
+
+		if !*follow {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// batchSizer returns a Sizer adapting batch sizes to the latency configured
+// under key.target_latency, between key.min and key.max and starting at
+// size, or one fixed at size if no target is set.
+func batchSizer(key string, size int) *aimd.Sizer {
+	target := viper.GetDuration(key + ".target_latency")
+	if target <= 0 {
+		return aimd.Fixed(size)
+	}
+	viper.SetDefault(key+".min", 1)
+	viper.SetDefault(key+".max", 10000)
+	return aimd.New(aimd.Config{
+		Min:     viper.GetInt(key + ".min"),
+		Max:     viper.GetInt(key + ".max"),
+		Initial: size,
+		Target:  target,
+		Step:    viper.GetInt(key + ".step"),
+		Backoff: viper.GetFloat64(key + ".backoff"),
+	})
+}
@@ -0,0 +1,108 @@
+// Package chaos injects faults into the pipeline so retry, checkpoint and
+// dead-letter handling can be exercised before they are needed for real.
+// It must only ever be enabled explicitly through the chaos config section.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+)
+
+// ErrInjected is returned by store queries that chaos mode made fail.
+var ErrInjected = errors.New("chaos: injected failure")
+
+type Config struct {
+	// ErrorRate is the probability, between 0 and 1, that a query fails.
+	ErrorRate float64
+	// LatencyRate is the probability that a query is delayed by up to
+	// MaxLatency.
+	LatencyRate float64
+	MaxLatency  time.Duration
+	// CrashRate is the probability that a worker panics per processed user.
+	CrashRate float64
+	Seed      int64
+}
+
+// Monkey decides when faults happen. A nil *Monkey never injects anything,
+// so callers can hold one unconditionally.
+type Monkey struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func New(cfg Config) *Monkey {
+	return &Monkey{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+}
+
+func (m *Monkey) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rng.Float64() < rate
+}
+
+func (m *Monkey) latency() time.Duration {
+	if m.cfg.MaxLatency <= 0 {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Duration(m.rng.Int63n(int64(m.cfg.MaxLatency)))
+}
+
+// Fault possibly sleeps and possibly returns ErrInjected.
+func (m *Monkey) Fault() error {
+	if m == nil {
+		return nil
+	}
+	if m.roll(m.cfg.LatencyRate) {
+		time.Sleep(m.latency())
+	}
+	if m.roll(m.cfg.ErrorRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Crash possibly panics, simulating a worker dying mid-run.
+func (m *Monkey) Crash() {
+	if m == nil {
+		return
+	}
+	if m.roll(m.cfg.CrashRate) {
+		panic("chaos: injected worker crash")
+	}
+}
+
+// Store wraps a db.Store and consults the Monkey before every query.
+type Store struct {
+	db.Store
+	monkey *Monkey
+}
+
+func NewStore(store db.Store, monkey *Monkey) *Store {
+	return &Store{Store: store, monkey: monkey}
+}
+
+func (s *Store) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	if err := s.monkey.Fault(); err != nil {
+		return nil, err
+	}
+	return s.Store.AllMailboxes(ctx)
+}
+
+func (s *Store) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	if err := s.monkey.Fault(); err != nil {
+		return nil, err
+	}
+	return s.Store.UsersForMailbox(ctx, mailboxID)
+}
@@ -0,0 +1,79 @@
+package chaos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+type fakeStore struct{}
+
+func (fakeStore) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	ch := make(chan db.Mailbox)
+	close(ch)
+	return ch, nil
+}
+
+func (fakeStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	ch := make(chan db.User)
+	close(ch)
+	return ch, nil
+}
+
+func TestStore_Faults(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           Config
+		expectedError error
+	}{
+		{name: "Never fails", cfg: Config{ErrorRate: 0}, expectedError: nil},
+		{name: "Always fails", cfg: Config{ErrorRate: 1}, expectedError: ErrInjected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(fakeStore{}, New(tt.cfg))
+
+			if _, err := store.AllMailboxes(context.Background()); err != tt.expectedError {
+				t.Errorf("AllMailboxes: expected error %v, got %v", tt.expectedError, err)
+			}
+			if _, err := store.UsersForMailbox(context.Background(), 1); err != tt.expectedError {
+				t.Errorf("UsersForMailbox: expected error %v, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestMonkey_Latency(t *testing.T) {
+	m := New(Config{LatencyRate: 1, MaxLatency: 20 * time.Millisecond})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := m.Fault(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected at most 100ms of injected latency, got %s", elapsed)
+	}
+}
+
+func TestMonkey_Crash(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected an injected panic")
+		}
+	}()
+
+	New(Config{CrashRate: 1}).Crash()
+}
+
+func TestMonkey_Nil(t *testing.T) {
+	var m *Monkey
+	if err := m.Fault(); err != nil {
+		t.Errorf("Expected nil Monkey to inject nothing, got %v", err)
+	}
+	m.Crash()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	"mailboxes/db"
+)
+
+// checkpoint saves the progress of run as it goes, so an interrupted run can
+// be resumed with run --resume; nil when the store cannot save it.
+var checkpoint *runCheckpoint
+
+// runCheckpoint records the mailboxes and users a run completes, and on
+// resume skips those an interrupted run already completed.
+type runCheckpoint struct {
+	store db.CheckpointStore
+	done  db.Checkpoint
+
+	resumedMailboxes atomic.Int64
+	resumedUsers     atomic.Int64
+}
+
+// setupCheckpoint enables checkpoints for store. With resume the progress
+// saved by the last unfinished run is loaded; otherwise it is discarded.
+func setupCheckpoint(ctx context.Context, store db.Store, resume bool) error {
+	cs, ok := store.(db.CheckpointStore)
+	if !ok {
+		if resume {
+			slog.Warn("Store does not save run progress; --resume ignored")
+		}
+		return nil
+	}
+
+	c := &runCheckpoint{store: cs}
+	if resume {
+		done, err := cs.LoadCheckpoint(ctx)
+		if err != nil {
+			return err
+		}
+		c.done = done
+		slog.Info("Resuming run", "mailboxes_done", len(done.Mailboxes), "users_done", len(done.Users))
+	} else if err := cs.ClearCheckpoint(ctx); err != nil {
+		return err
+	}
+
+	checkpoint = c
+	return nil
+}
+
+// mailboxDone reports whether an interrupted run already completed the
+// mailbox.
+func (c *runCheckpoint) mailboxDone(mailboxID int) bool {
+	if c == nil || !c.done.MailboxDone(mailboxID) {
+		return false
+	}
+	c.resumedMailboxes.Add(1)
+	return true
+}
+
+// userDone reports whether an interrupted run already processed user.
+func (c *runCheckpoint) userDone(user db.User) bool {
+	if c == nil || !c.done.UserDone(db.ProcessedUser{MailboxID: user.MailboxID, UserID: user.ID}) {
+		return false
+	}
+	c.resumedUsers.Add(1)
+	return true
+}
+
+// user records that user was processed. A failure to save progress is
+// logged but does not fail the user; at worst a resumed run processes it
+// again.
+func (c *runCheckpoint) user(ctx context.Context, user db.User) {
+	if c == nil {
+		return
+	}
+	if err := c.store.CheckpointUser(ctx, db.ProcessedUser{MailboxID: user.MailboxID, UserID: user.ID}); err != nil {
+		slog.Error("Error saving checkpoint", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+	}
+}
+
+// mailbox records that every user of the mailbox is done.
+func (c *runCheckpoint) mailbox(ctx context.Context, mailboxID int) {
+	if c == nil {
+		return
+	}
+	if err := c.store.CheckpointMailbox(ctx, mailboxID); err != nil {
+		slog.Error("Error saving checkpoint", "mailbox_id", mailboxID, "error", err)
+	}
+}
+
+// finish discards the saved progress once the run has completed every
+// mailbox, and otherwise keeps it for run --resume.
+func (c *runCheckpoint) finish(completed bool) {
+	if c == nil {
+		return
+	}
+	if n, u := c.resumedMailboxes.Load(), c.resumedUsers.Load(); n > 0 || u > 0 {
+		slog.Info("Skipped work done before the run was interrupted", "mailboxes", n, "users", u)
+	}
+	if !completed {
+		slog.Info("Run progress saved; continue it with run --resume")
+		return
+	}
+	// The run's own context may already be cancelled.
+	if err := c.store.ClearCheckpoint(context.Background()); err != nil {
+		slog.Error("Error clearing checkpoint", "error", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package codec abstracts how payloads are encoded before they are handed to
+// a sink. Sinks look up their codec by the name configured under
+// sinks.<sink>.codec, so a consumer's wire format can change without code
+// changes.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Codec encodes and decodes sink payloads.
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = map[string]Codec{}
+)
+
+func init() {
+	Register(JSON{})
+	Register(MsgPack{})
+	Register(Protobuf{})
+}
+
+// Register makes a codec available by name, replacing any codec previously
+// registered under the same name.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Lookup returns the codec registered under name. An empty name selects JSON.
+func Lookup(name string) (Codec, error) {
+	if name == "" {
+		name = JSON{}.Name()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// Names returns the registered codec names in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type JSON struct{}
+
+func (JSON) Name() string                       { return "json" }
+func (JSON) ContentType() string                { return "application/json" }
+func (JSON) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSON) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// MsgPack encodes payloads as JSON does, with the same field names and
+// values, such as times as RFC 3339 strings, in MessagePack. Payloads thus
+// have one schema whether or not a redaction policy has already turned
+// them into plain maps.
+type MsgPack struct{}
+
+func (MsgPack) Name() string        { return "msgpack" }
+func (MsgPack) ContentType() string { return "application/msgpack" }
+
+func (MsgPack) Marshal(v any) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(value)
+}
+
+func (MsgPack) Unmarshal(data []byte, v any) error {
+	var value any
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return fromJSONValue(value, v)
+}
+
+// Protobuf encodes payloads as JSON does, as a google.protobuf.Value, so
+// consumers can decode them without a schema of their own. Protobuf has
+// only one number type, so integers beyond 2^53 lose precision.
+type Protobuf struct{}
+
+func (Protobuf) Name() string        { return "protobuf" }
+func (Protobuf) ContentType() string { return "application/x-protobuf" }
+
+func (Protobuf) Marshal(v any) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	pv, err := structpb.NewValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(pv)
+}
+
+func (Protobuf) Unmarshal(data []byte, v any) error {
+	var pv structpb.Value
+	if err := proto.Unmarshal(data, &pv); err != nil {
+		return err
+	}
+	return fromJSONValue(pv.AsInterface(), v)
+}
+
+// jsonValue returns v as JSON sees it: maps, slices, strings, bools, nil,
+// and numbers as int64 where they are whole and float64 otherwise.
+func jsonValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return numbers(value), nil
+}
+
+// numbers replaces the json.Numbers in value with int64s or float64s.
+func numbers(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]any:
+		for k, elem := range value {
+			value[k] = numbers(elem)
+		}
+	case []any:
+		for i, elem := range value {
+			value[i] = numbers(elem)
+		}
+	}
+	return value
+}
+
+// fromJSONValue decodes value, as returned by jsonValue, into v as JSON
+// would.
+func fromJSONValue(value, v any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
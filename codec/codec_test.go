@@ -0,0 +1,74 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func TestRoundTrip(t *testing.T) {
+	user := db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)}
+
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			c, err := Lookup(name)
+			if err != nil {
+				t.Fatalf("Error looking up codec: %v", err)
+			}
+
+			data, err := c.Marshal(user)
+			if err != nil {
+				t.Fatalf("Error marshaling user: %v", err)
+			}
+
+			var decoded db.User
+			if err := c.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Error unmarshaling user: %v", err)
+			}
+
+			// Codecs may decode times in the local zone; only the instant
+			// has to survive.
+			if !decoded.CreatedAt.Equal(user.CreatedAt) || !decoded.UpdatedAt.Equal(user.UpdatedAt) {
+				t.Errorf("Expected times %v and %v, got %v and %v", user.CreatedAt, user.UpdatedAt, decoded.CreatedAt, decoded.UpdatedAt)
+			}
+			decoded.CreatedAt, decoded.UpdatedAt = user.CreatedAt, user.UpdatedAt
+			if !reflect.DeepEqual(decoded, user) {
+				t.Errorf("Expected user %v, got %v", user, decoded)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name          string
+		codec         string
+		expectedName  string
+		expectedError bool
+	}{
+		{name: "Default", codec: "", expectedName: "json"},
+		{name: "MsgPack", codec: "msgpack", expectedName: "msgpack"},
+		{name: "Protobuf", codec: "protobuf", expectedName: "protobuf"},
+		{name: "Unknown", codec: "xml", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Lookup(tt.codec)
+			if tt.expectedError {
+				if err == nil {
+					t.Fatalf("Expected an error for codec %q", tt.codec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error looking up codec: %v", err)
+			}
+			if c.Name() != tt.expectedName {
+				t.Errorf("Expected codec %s, got %s", tt.expectedName, c.Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// envPrefix prefixes the environment variables that override config keys:
+// database.driver is read from MAILBOXES_DATABASE_DRIVER.
+const envPrefix = "MAILBOXES"
+
+// loadConfig reads the config file and layers overrides on top of it, with
+// flags taking precedence over the environment and the environment over the
+// file. Leading --key=value or --key value arguments set any config key,
+// --config names the file (default config/database.yaml, or
+// MAILBOXES_CONFIG), and the remaining arguments are returned. The default
+// file may be missing if everything is configured another way.
+func loadConfig(args []string) ([]string, error) {
+	path, explicit := filepath.Join(".", "config/database.yaml"), false
+	if p := os.Getenv(envPrefix + "_CONFIG"); p != "" {
+		path, explicit = p, true
+	}
+
+	overrides := map[string]string{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		arg := strings.TrimPrefix(args[0], "--")
+		args = args[1:]
+		if arg == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("flag --%s needs a value", key)
+			}
+			value, args = args[0], args[1:]
+		}
+
+		if key == "config" {
+			path, explicit = value, true
+			continue
+		}
+		overrides[strings.ToLower(key)] = value
+	}
+
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil && (explicit || !errors.Is(err, fs.ErrNotExist)) {
+		return nil, err
+	}
+
+	for key, value := range overrides {
+		viper.Set(key, value)
+	}
+	return args, nil
+}
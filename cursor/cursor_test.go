@@ -0,0 +1,47 @@
+package cursor
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	c := New("secret")
+	for _, id := range []int{0, 1, 300, 1 << 40} {
+		token := c.Encode("users:7", id)
+		got, err := c.Decode("users:7", token)
+		if err != nil || got != id {
+			t.Errorf("Decode(Encode(%d)) = %d, %v", id, got, err)
+		}
+	}
+}
+
+func TestCodec_Rejects(t *testing.T) {
+	c := New("secret")
+	token := c.Encode("mailboxes", 42)
+
+	tampered := []byte(token)
+	tampered[3] ^= 1
+
+	tests := []struct {
+		name    string
+		codec   *Codec
+		listing string
+		token   string
+		want    error
+	}{
+		{"Old version", c, "mailboxes", c.encode(Version-1, "mailboxes", 42), ErrExpired},
+		{"Secret replaced", New("other"), "mailboxes", token, ErrExpired},
+		{"Tampered", c, "mailboxes", string(tampered), ErrExpired},
+		{"Other listing", c, "users:7", token, ErrInvalid},
+		{"Plain ID", c, "mailboxes", "42", ErrInvalid},
+		{"Not base64", c, "mailboxes", "not a cursor!", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.codec.Decode(tt.listing, tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
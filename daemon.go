@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mailboxes/annotations"
+	"mailboxes/chaos"
+	"mailboxes/db"
+	"mailboxes/scheduler"
+
+	"github.com/spf13/viper"
+)
+
+const daemonUsage = `Usage: daemon --schedule "*/15 * * * *" [--timezone UTC] [--overlap skip|queue] [--jitter 0] [--max-runtime 0] [--queue-interval 1s] [--retry-interval 10s]`
+
+// daemonSpec is the name of the run spec the daemon schedules the pipeline
+// under, and the trigger annotation its runs are recorded with.
+const daemonSpec = "schedule"
+
+// daemonCommand runs the pipeline on a cron schedule until interrupted, so
+// it needs no external cron. A run that comes due while the previous one is
+// still going is skipped or queued; one that comes due while another
+// instance holds the run lock is skipped. Each run starts from a clean
+// checkpoint and is recorded in the run history. Between and during runs,
+// queued users are processed and failed users retried as under watch. On
+// SIGINT or SIGTERM no more runs, queued users or retries are started and
+// those in progress are given daemon.shutdown_timeout to finish before they
+// are cancelled.
+func daemonCommand(store db.Store, args []string) {
+	viper.SetDefault("daemon.overlap", scheduler.OverlapSkip)
+	viper.SetDefault("daemon.shutdown_timeout", 30*time.Second)
+	viper.SetDefault("daemon.queue_interval", time.Second)
+	viper.SetDefault("daemon.retry_interval", 10*time.Second)
+
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	schedule := fs.String("schedule", viper.GetString("daemon.schedule"), "cron expression the pipeline runs on")
+	timezone := fs.String("timezone", viper.GetString("daemon.timezone"), "IANA timezone the schedule is read in (default UTC)")
+	overlap := fs.String("overlap", viper.GetString("daemon.overlap"), "skip or queue a run that comes due while the previous one is running")
+	jitter := fs.Duration("jitter", viper.GetDuration("daemon.jitter"), "delay each run by a random duration up to this")
+	maxRuntime := fs.Duration("max-runtime", viper.GetDuration("daemon.max_runtime"), "cancel a run that takes longer than this")
+	queueInterval := fs.Duration("queue-interval", viper.GetDuration("daemon.queue_interval"), "how often to check the work queue")
+	retryInterval := fs.Duration("retry-interval", viper.GetDuration("daemon.retry_interval"), "how often to attempt due retries")
+	fs.Parse(args)
+
+	if *schedule == "" {
+		fatal(daemonUsage)
+	}
+	spec := scheduler.Spec{
+		Name:       daemonSpec,
+		Cron:       *schedule,
+		Timezone:   *timezone,
+		Jitter:     *jitter,
+		MaxRuntime: *maxRuntime,
+		Overlap:    *overlap,
+	}
+
+	if err := setupLedger(context.Background(), store); err != nil {
+		fatal("Error loading ledger", "error", err)
+	}
+	if _, ok := store.(db.RunStore); !ok {
+		slog.Warn("Store does not keep a run history; scheduled runs will not be recorded")
+	}
+	queue, _ := store.(db.QueueStore)
+	if monkey != nil {
+		store = chaos.NewStore(store, monkey)
+	}
+
+	// Runs get their own context, so that stopping the daemon lets the run in
+	// progress finish.
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
+	s := scheduler.New(runCtx, func(ctx context.Context, sp scheduler.Spec) error {
+		ctx, unlock, err := acquireRunLock(ctx, store)
+		if errors.Is(err, errRunLocked) {
+			slog.Info("Skipping scheduled run", "spec", sp.Name, "reason", err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("taking run lock: %w", err)
+		}
+		defer unlock()
+
+		if err := setupCheckpoint(ctx, store, false); err != nil {
+			return fmt.Errorf("clearing checkpoint: %w", err)
+		}
+		standby.startPushing()
+		defer standby.stopPushing()
+		notes := &annotations.Set{}
+		notes.Add("trigger", sp.Name)
+		return runPipeline(ctx, store, notes)
+	})
+	if err := s.Reconcile([]scheduler.Spec{spec}); err != nil {
+		fatal("Invalid schedule", "error", err)
+	}
+
+	stopDraining := make(chan struct{})
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		drainEvery(runCtx, queue, retries, *queueInterval, *retryInterval, stopDraining)
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	// A second signal stops the process at once.
+	stop()
+	close(stopDraining)
+
+	timeout := viper.GetDuration("daemon.shutdown_timeout")
+	slog.Info("Stopping daemon", "shutdown_timeout", timeout)
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		<-drained
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		slog.Warn("Run, queued users or retries still in progress after the shutdown timeout; cancelling them")
+		cancelRuns()
+		<-stopped
+	}
+	closeEmitter()
+	slog.Info("Daemon stopped")
+}
+
+// drainEvery processes queued users every queueInterval and attempts the
+// retries that are due every retryInterval, as watch does, until stop is
+// closed. A nil queue or retries is not drained.
+func drainEvery(ctx context.Context, queue db.QueueStore, retries db.RetryStore, queueInterval, retryInterval time.Duration, stop <-chan struct{}) {
+	queueTicker := time.NewTicker(queueInterval)
+	defer queueTicker.Stop()
+	retryTicker := time.NewTicker(retryInterval)
+	defer retryTicker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-queueTicker.C:
+			if queue == nil {
+				continue
+			}
+			if err := drainQueue(ctx, queue); err != nil {
+				slog.Error("Error processing work queue", "error", err)
+			}
+		case <-retryTicker.C:
+			if retries == nil {
+				continue
+			}
+			if err := drainRetries(ctx, retries); err != nil {
+				slog.Error("Error processing retries", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// RecordMailboxAccess adds hits to each mailbox's count in mailbox_access,
+// in one transaction.
+func (s *DBStore) RecordMailboxAccess(ctx context.Context, hits map[int]int) error {
+	if len(hits) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO mailbox_access (mailbox_id, hits, last_accessed_at) VALUES (?, ?, ?) " +
+		"ON CONFLICT (mailbox_id) DO UPDATE SET hits = mailbox_access.hits + excluded.hits, " +
+		"last_accessed_at = excluded.last_accessed_at"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting mailbox access transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, 0, len(hits))
+	for id := range hits {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	now := time.Now().UTC().Format(TimestampLayout)
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, s.rebind(query), id, hits[id], now); err != nil {
+			slog.Error("Error recording access to mailbox", "mailbox_id", id, "error", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing mailbox access", "error", err)
+		return err
+	}
+	return nil
+}
+
+// HotMailboxes returns the n mailboxes with the most recorded accesses.
+func (s *DBStore) HotMailboxes(ctx context.Context, n int) ([]int, error) {
+	query := "SELECT mailbox_id FROM mailbox_access ORDER BY hits DESC, mailbox_id LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), n)
+	if err != nil {
+		slog.Error("Error querying hot mailboxes", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			slog.Error("Error scanning hot mailbox row", "error", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_RecordMailboxAccess(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	query := regexp.QuoteMeta("INSERT INTO mailbox_access (mailbox_id, hits, last_accessed_at) VALUES (?, ?, ?) " +
+		"ON CONFLICT (mailbox_id) DO UPDATE SET hits = mailbox_access.hits + excluded.hits, " +
+		"last_accessed_at = excluded.last_accessed_at")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(1, 3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(2, 1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	if err := store.RecordMailboxAccess(context.Background(), map[int]int{2: 1, 1: 3}); err != nil {
+		t.Errorf("Error recording access: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_HotMailboxes(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id FROM mailbox_access ORDER BY hits DESC, mailbox_id LIMIT ?")).
+		WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"mailbox_id"}).AddRow(7).AddRow(3))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ids, err := store.HotMailboxes(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Error reading hot mailboxes: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int{7, 3}) {
+		t.Errorf("Expected [7 3], got %v", ids)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"mailboxes/scope"
+)
+
+// Entities recorded in the audit_log. Actions are ChangeCreate,
+// ChangeUpdate and ChangeDelete.
+const (
+	AuditMailbox = "mailbox"
+	AuditUser    = "user"
+)
+
+// auditRedactedToken stands in for mailbox tokens in the audit_log, which
+// must not become a copy of the credentials it audits.
+const auditRedactedToken = "[redacted]"
+
+// SetAuditLog turns on the audit_log: every create, update and delete of a
+// mailbox or user made through the store is recorded, in the same
+// transaction as the write, with the actor ctx carries, or defaultActor if
+// it carries none, and the entity as it was before and after.
+func (s *DBStore) SetAuditLog(enabled bool, defaultActor string) {
+	s.auditLog = enabled
+	s.auditActor = defaultActor
+}
+
+// logAudit records action on the mailbox or user id through q if the
+// audit_log is on. before is nil for a create and after is nil for a
+// delete; both are a *Mailbox or a *User.
+func (s *DBStore) logAudit(ctx context.Context, q execQueryer, action, entity string, id int, before, after any) error {
+	if !s.auditLog {
+		return nil
+	}
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO audit_log (actor, action, entity, entity_id, before_json, after_json, occurred_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err = q.ExecContext(ctx, s.rebind(query), cmp.Or(scope.Actor(ctx), s.auditActor), action, entity, id, beforeJSON, afterJSON, FormatTimestamp(now()))
+	if err != nil {
+		slog.Error("Error recording audit entry", "action", action, "entity", entity, "entity_id", id, "error", err)
+		return err
+	}
+	return nil
+}
+
+// auditJSON encodes a *Mailbox, with its token redacted, or a *User for the
+// audit_log, or NULL for a nil one.
+func auditJSON(v any) (sql.NullString, error) {
+	switch e := v.(type) {
+	case *Mailbox:
+		if e == nil {
+			return sql.NullString{}, nil
+		}
+		mb := *e
+		if mb.Token != "" {
+			mb.Token = auditRedactedToken
+		}
+		v = mb
+	case *User:
+		if e == nil {
+			return sql.NullString{}, nil
+		}
+	case nil:
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// AuditLog returns the entries of the audit_log recorded at or after since,
+// oldest first, at most limit of them unless limit is 0.
+func (s *DBStore) AuditLog(ctx context.Context, since time.Time, limit int) ([]AuditEntry, error) {
+	query := "SELECT id, actor, action, entity, entity_id, before_json, after_json, CAST(occurred_at AS TEXT) FROM audit_log WHERE occurred_at >= ? ORDER BY id"
+	args := []any{FormatTimestamp(since)}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying the audit log", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &before, &after, scanTime(&e.OccurredAt)); err != nil {
+			slog.Error("Error scanning audit log row", "error", err)
+			return nil, err
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
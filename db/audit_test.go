@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"mailboxes/scope"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var auditInsert = regexp.QuoteMeta("INSERT INTO audit_log (actor, action, entity, entity_id, before_json, after_json, occurred_at) VALUES (?, ?, ?, ?, ?, ?, ?)")
+
+// jsonWith matches a JSON argument containing every one of its strings.
+type jsonWith []string
+
+func (j jsonWith) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if !ok || !json.Valid([]byte(s)) {
+		return false
+	}
+	for _, want := range j {
+		if !strings.Contains(s, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDBStore_DeleteUser_Audit(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockedUserQuery).WithArgs(101).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE user_id = ?")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM retry_queue WHERE user_id = ?")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = ?")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectChanges(mock,
+		UserChange{UserID: 101, Op: ChangeDelete, Field: "mailbox_id", Old: "1"},
+		UserChange{UserID: 101, Op: ChangeDelete, Field: "user_name", Old: "user1"},
+		UserChange{UserID: 101, Op: ChangeDelete, Field: "email_address", Old: "user1@example.com"})
+	mock.ExpectExec(auditInsert).
+		WithArgs("api:alice", ChangeDelete, AuditUser, 101, jsonWith{`"id":101`, `"email_address":"user1@example.com"`}, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db}
+	store.SetAuditLog(true, "cli")
+
+	ctx := scope.WithActor(context.Background(), "api:alice")
+	if err := store.DeleteUser(ctx, 101); err != nil {
+		t.Fatalf("Error calling DeleteUser: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_UpdateMailbox_Audit(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes WHERE id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}).
+			AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00", "2024-07-23 12:00:00"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mailboxes SET token = ?, updated_at = ? WHERE id = ?")).
+		WithArgs("newtoken", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(auditInsert).
+		WithArgs("cli", ChangeUpdate, AuditMailbox, 1, jsonWith{`"mpi_id":"mpi123"`, `"token":"[redacted]"`}, jsonWith{`"token":"[redacted]"`}, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db}
+	store.SetAuditLog(true, "cli")
+
+	if err := store.UpdateMailbox(context.Background(), Mailbox{ID: 1, Token: "newtoken"}, FieldToken); err != nil {
+		t.Fatalf("Error calling UpdateMailbox: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_AuditLog(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, actor, action, entity, entity_id, before_json, after_json, CAST(occurred_at AS TEXT) FROM audit_log WHERE occurred_at >= ? ORDER BY id LIMIT ?")).
+		WithArgs("2024-07-23 00:00:00", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "action", "entity", "entity_id", "before_json", "after_json", "occurred_at"}).
+			AddRow(1, "cli:ops", ChangeCreate, AuditUser, 101, nil, `{"id":101}`, "2024-07-23 12:30:00").
+			AddRow(2, "api", ChangeDelete, AuditUser, 101, `{"id":101}`, nil, "2024-07-24 09:00:00"))
+
+	store := &DBStore{db: db}
+	entries, err := store.AuditLog(context.Background(), ts("2024-07-23 00:00:00"), 10)
+	if err != nil {
+		t.Fatalf("Error reading the audit log: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Actor != "cli:ops" || entries[0].Before != nil || string(entries[0].After) != `{"id":101}` ||
+		entries[1].Action != ChangeDelete || entries[1].After != nil || !entries[1].OccurredAt.Equal(ts("2024-07-24 09:00:00")) {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+// setupBenchDB returns an in-memory SQLite database holding one mailbox with
+// userCount users.
+func setupBenchDB(b testing.TB, userCount int) *sql.DB {
+	b.Helper()
+
+	db, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		b.Fatalf("Error opening database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	statements := []string{
+		"CREATE TABLE mailboxes (id INTEGER PRIMARY KEY, mpi_id VARCHAR(200), token VARCHAR(200), created_at TIMESTAMP, updated_at TIMESTAMP)",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, mailbox_id INTEGER, user_name VARCHAR(200), email_address VARCHAR(200), created_at TIMESTAMP, updated_at TIMESTAMP)",
+		"INSERT INTO mailboxes VALUES (1, 'mpi123', 'token123', '2024-07-23 12:00:00', '2024-07-23 12:00:00')",
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			b.Fatalf("Error setting up database: %v", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatalf("Error starting transaction: %v", err)
+	}
+	for i := 0; i < userCount; i++ {
+		name := fmt.Sprintf("user%d", i)
+		_, err := tx.Exec("INSERT INTO users VALUES (?, 1, ?, ?, '2024-07-23 12:30:00', '2024-07-23 12:30:00')", i, name, name+"@example.com")
+		if err != nil {
+			b.Fatalf("Error inserting user: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("Error committing users: %v", err)
+	}
+
+	return db
+}
+
+func BenchmarkDBStore_UsersForMailbox(b *testing.B) {
+	db := setupBenchDB(b, 10000)
+	defer db.Close()
+
+	benchmarks := []struct {
+		name      string
+		batchSize int
+	}{
+		{name: "Rows", batchSize: 0},
+		{name: "Batched", batchSize: sqliteBatchSize},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			store := &DBStore{db: db, driver: "sqlite3", batchSize: bm.batchSize}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				userChan, err := store.UsersForMailbox(context.Background(), 1)
+				if err != nil {
+					b.Fatalf("Error calling UsersForMailbox: %v", err)
+				}
+				for range userChan {
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDBStore_PreparedStatements reads a small mailbox per iteration,
+// as a run does for each of many mailboxes, where compiling the query on
+// every call weighs most.
+func BenchmarkDBStore_PreparedStatements(b *testing.B) {
+	db := setupBenchDB(b, 10)
+	defer db.Close()
+
+	for _, batchSize := range []int{0, sqliteBatchSize} {
+		for _, prepared := range []bool{false, true} {
+			name := "Rows"
+			if batchSize > 0 {
+				name = "Batched"
+			}
+			if prepared {
+				name += "/Prepared"
+			} else {
+				name += "/Unprepared"
+			}
+			b.Run(name, func(b *testing.B) {
+				store := &DBStore{db: db, driver: "sqlite3", batchSize: batchSize}
+				store.SetPreparedStatements(prepared)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					userChan, err := store.UsersForMailbox(context.Background(), 1)
+					if err != nil {
+						b.Fatalf("Error calling UsersForMailbox: %v", err)
+					}
+					for range userChan {
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// Operations recorded in user_changes.
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// userChangeFields are the user fields tracked in user_changes.
+var userChangeFields = []struct {
+	name  string
+	value func(User) string
+}{
+	{"mailbox_id", func(u User) string { return strconv.Itoa(u.MailboxID) }},
+	{"user_name", func(u User) string { return u.UserName }},
+	{"email_address", func(u User) string { return u.EmailAddress }},
+}
+
+// diffUser lists the fields that differ between before and after. A nil
+// before is a create and a nil after a delete; both record every field.
+func diffUser(before, after *User) []UserChange {
+	op, userID := ChangeUpdate, 0
+	switch {
+	case before == nil:
+		op, userID = ChangeCreate, after.ID
+	case after == nil:
+		op, userID = ChangeDelete, before.ID
+	default:
+		userID = after.ID
+	}
+
+	var changes []UserChange
+	for _, f := range userChangeFields {
+		change := UserChange{UserID: userID, Op: op, Field: f.name}
+		if before != nil {
+			change.Old = f.value(*before)
+		}
+		if after != nil {
+			change.New = f.value(*after)
+		}
+		if op == ChangeUpdate && change.Old == change.New {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// recordChanges appends changes to the user_changes outbox inside tx, so
+// they are published if and only if the write commits.
+func (s *DBStore) recordChanges(ctx context.Context, tx *sql.Tx, changes []UserChange) error {
+	query := "INSERT INTO user_changes (user_id, op, field, old_value, new_value) VALUES (?, ?, ?, ?, ?)"
+
+	for _, c := range changes {
+		if _, err := tx.ExecContext(ctx, s.rebind(query), c.UserID, c.Op, c.Field, c.Old, c.New); err != nil {
+			slog.Error("Error recording user change", "field", c.Field, "user_id", c.UserID, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// lockedUser reads user id inside tx, before it is changed.
+func (s *DBStore) lockedUser(ctx context.Context, tx *sql.Tx, id int) (User, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT " + userColumns + " FROM users WHERE id = ?" + cond
+
+	var user User
+	err := tx.QueryRowContext(ctx, s.rebind(query), append([]any{id}, args...)...).Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt))
+	if err == sql.ErrNoRows {
+		return User{}, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	}
+	if err != nil {
+		slog.Error("Error querying user", "user_id", id, "error", err)
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// UserChangesAfter returns up to limit changes recorded after the change
+// with ID afterID, oldest first.
+func (s *DBStore) UserChangesAfter(ctx context.Context, afterID, limit int) ([]UserChange, error) {
+	query := "SELECT id, user_id, op, field, old_value, new_value, CAST(changed_at AS TEXT) FROM user_changes " +
+		"WHERE id > ? ORDER BY id LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), afterID, limit)
+	if err != nil {
+		slog.Error("Error querying user changes", "after_id", afterID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []UserChange
+	for rows.Next() {
+		var c UserChange
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Op, &c.Field, &c.Old, &c.New, &c.ChangedAt); err != nil {
+			slog.Error("Error scanning user change row", "error", err)
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over user change rows", "error", err)
+		return nil, err
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var lockedUserQuery = regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE id = ?")
+
+// expectChanges expects changes to be appended to user_changes, in order.
+func expectChanges(mock sqlmock.Sqlmock, changes ...UserChange) {
+	query := regexp.QuoteMeta("INSERT INTO user_changes (user_id, op, field, old_value, new_value) VALUES (?, ?, ?, ?, ?)")
+	for _, c := range changes {
+		mock.ExpectExec(query).WithArgs(c.UserID, c.Op, c.Field, c.Old, c.New).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// expectBatchChanges expects changes to be recorded with one multi-row
+// INSERT, as when users are created in a batch.
+func expectBatchChanges(mock sqlmock.Sqlmock, changes ...UserChange) {
+	columns := []string{"user_id", "op", "field", "old_value", "new_value"}
+	var args []driver.Value
+	for _, c := range changes {
+		args = append(args, c.UserID, c.Op, c.Field, c.Old, c.New)
+	}
+	mock.ExpectExec(regexp.QuoteMeta(valuesQuery("user_changes", columns, len(changes)))).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, int64(len(changes))))
+}
+
+func TestDiffUser(t *testing.T) {
+	user := User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com"}
+	renamed := user
+	renamed.UserName = "renamed"
+
+	tests := []struct {
+		name            string
+		before, after   *User
+		expectedChanges []UserChange
+	}{
+		{
+			name:  "Create",
+			after: &user,
+			expectedChanges: []UserChange{
+				{UserID: 101, Op: ChangeCreate, Field: "mailbox_id", New: "1"},
+				{UserID: 101, Op: ChangeCreate, Field: "user_name", New: "user1"},
+				{UserID: 101, Op: ChangeCreate, Field: "email_address", New: "user1@example.com"},
+			},
+		},
+		{
+			name:   "Update",
+			before: &user,
+			after:  &renamed,
+			expectedChanges: []UserChange{
+				{UserID: 101, Op: ChangeUpdate, Field: "user_name", Old: "user1", New: "renamed"},
+			},
+		},
+		{
+			name:   "Unchanged",
+			before: &user,
+			after:  &user,
+		},
+		{
+			name:   "Delete",
+			before: &user,
+			expectedChanges: []UserChange{
+				{UserID: 101, Op: ChangeDelete, Field: "mailbox_id", Old: "1"},
+				{UserID: 101, Op: ChangeDelete, Field: "user_name", Old: "user1"},
+				{UserID: 101, Op: ChangeDelete, Field: "email_address", Old: "user1@example.com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if changes := diffUser(tt.before, tt.after); !reflect.DeepEqual(changes, tt.expectedChanges) {
+				t.Errorf("Expected changes %v, got %v", tt.expectedChanges, changes)
+			}
+		})
+	}
+}
+
+func TestDBStore_UserChangesAfter(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, op, field, old_value, new_value, CAST(changed_at AS TEXT) FROM user_changes WHERE id > ? ORDER BY id LIMIT ?")).
+		WithArgs(7, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "op", "field", "old_value", "new_value", "changed_at"}).
+			AddRow(8, 101, "update", "email_address", "user1@example.com", "renamed@example.com", "2024-07-24 09:00:00"))
+
+	store := &DBStore{db: db}
+
+	changes, err := store.UserChangesAfter(context.Background(), 7, 100)
+	if err != nil {
+		t.Fatalf("Error calling UserChangesAfter: %v", err)
+	}
+
+	expected := []UserChange{{ID: 8, UserID: 101, Op: ChangeUpdate, Field: "email_address", Old: "user1@example.com", New: "renamed@example.com", ChangedAt: "2024-07-24 09:00:00"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+)
+
+// checkpointMailboxDone is the user_id of the checkpoints row marking a
+// whole mailbox done. User IDs start at 1.
+const checkpointMailboxDone = 0
+
+// CheckpointUser records p in the checkpoints table. Recording a user twice
+// is not an error.
+func (s *DBStore) CheckpointUser(ctx context.Context, p ProcessedUser) error {
+	query := "INSERT INTO checkpoints (mailbox_id, user_id) VALUES (?, ?) ON CONFLICT (mailbox_id, user_id) DO NOTHING"
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), p.MailboxID, p.UserID); err != nil {
+		slog.Error("Error checkpointing user", "user_id", p.UserID, "mailbox_id", p.MailboxID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// CheckpointMailbox replaces the checkpoint entries of mailboxID's users
+// with one marking the mailbox done.
+func (s *DBStore) CheckpointMailbox(ctx context.Context, mailboxID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM checkpoints WHERE mailbox_id = ?"), mailboxID); err != nil {
+		slog.Error("Error checkpointing mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	query := "INSERT INTO checkpoints (mailbox_id, user_id) VALUES (?, ?)"
+	if _, err := tx.ExecContext(ctx, s.rebind(query), mailboxID, checkpointMailboxDone); err != nil {
+		slog.Error("Error checkpointing mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadCheckpoint reads the progress saved in the checkpoints table.
+func (s *DBStore) LoadCheckpoint(ctx context.Context) (Checkpoint, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT mailbox_id, user_id FROM checkpoints")
+	if err != nil {
+		slog.Error("Error querying checkpoints", "error", err)
+		return Checkpoint{}, err
+	}
+	defer rows.Close()
+
+	c := Checkpoint{Mailboxes: map[int]bool{}, Users: map[ProcessedUser]bool{}}
+	for rows.Next() {
+		var p ProcessedUser
+		if err := rows.Scan(&p.MailboxID, &p.UserID); err != nil {
+			slog.Error("Error scanning checkpoint row", "error", err)
+			return Checkpoint{}, err
+		}
+		if p.UserID == checkpointMailboxDone {
+			c.Mailboxes[p.MailboxID] = true
+		} else {
+			c.Users[p] = true
+		}
+	}
+	return c, rows.Err()
+}
+
+// ClearCheckpoint empties the checkpoints table.
+func (s *DBStore) ClearCheckpoint(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM checkpoints"); err != nil {
+		slog.Error("Error clearing checkpoints", "error", err)
+		return err
+	}
+	return nil
+}
+
+// ReplaceCheckpoint replaces the contents of the checkpoints table with c in
+// one transaction.
+func (s *DBStore) ReplaceCheckpoint(ctx context.Context, c Checkpoint) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM checkpoints"); err != nil {
+		slog.Error("Error replacing checkpoints", "error", err)
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, s.rebind("INSERT INTO checkpoints (mailbox_id, user_id) VALUES (?, ?)"))
+	if err != nil {
+		slog.Error("Error replacing checkpoints", "error", err)
+		return err
+	}
+	defer stmt.Close()
+	for id := range c.Mailboxes {
+		if _, err := stmt.ExecContext(ctx, id, checkpointMailboxDone); err != nil {
+			slog.Error("Error checkpointing mailbox", "mailbox_id", id, "error", err)
+			return err
+		}
+	}
+	for p := range c.Users {
+		if _, err := stmt.ExecContext(ctx, p.MailboxID, p.UserID); err != nil {
+			slog.Error("Error checkpointing user", "user_id", p.UserID, "mailbox_id", p.MailboxID, "error", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_CheckpointMailbox(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM checkpoints WHERE mailbox_id = ?")).
+		WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO checkpoints (mailbox_id, user_id) VALUES (?, ?)")).
+		WithArgs(1, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	if err := store.CheckpointMailbox(context.Background(), 1); err != nil {
+		t.Fatalf("Error checkpointing mailbox: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_LoadCheckpoint(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, user_id FROM checkpoints")).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "user_id"}).
+			AddRow(1, 0).
+			AddRow(2, 201).
+			AddRow(2, 202))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	c, err := store.LoadCheckpoint(context.Background())
+	if err != nil {
+		t.Fatalf("Error loading checkpoint: %v", err)
+	}
+
+	expected := Checkpoint{
+		Mailboxes: map[int]bool{1: true},
+		Users:     map[ProcessedUser]bool{{MailboxID: 2, UserID: 201}: true, {MailboxID: 2, UserID: 202}: true},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, c)
+	}
+	if !c.MailboxDone(1) || c.MailboxDone(2) || !c.UserDone(ProcessedUser{MailboxID: 2, UserID: 202}) {
+		t.Errorf("Unexpected progress in %+v", c)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ReplaceCheckpoint(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM checkpoints")).WillReturnResult(sqlmock.NewResult(0, 5))
+	insert := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO checkpoints (mailbox_id, user_id) VALUES (?, ?)"))
+	insert.ExpectExec().WithArgs(1, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	insert.ExpectExec().WithArgs(2, 201).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	c := Checkpoint{
+		Mailboxes: map[int]bool{1: true},
+		Users:     map[ProcessedUser]bool{{MailboxID: 2, UserID: 201}: true},
+	}
+	if err := store.ReplaceCheckpoint(context.Background(), c); err != nil {
+		t.Fatalf("Error replacing checkpoint: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
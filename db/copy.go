@@ -0,0 +1,270 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// AllUsers streams every user, or in a context scoped to a tenant every user
+// of the tenant's mailboxes. On PostgreSQL the table is read with COPY,
+// which avoids per-row protocol overhead and is several times faster for
+// full-table reads; SQLite uses batched reads and other drivers, and scoped
+// reads on PostgreSQL, fall back to a plain query.
+func (s *DBStore) AllUsers(ctx context.Context) (<-chan User, error) {
+	where, args := s.allUsersFilter(ctx)
+	if s.isPostgres() && where == "" {
+		return s.copyUsers(ctx), nil
+	}
+	if s.batchSize > 0 {
+		return s.batchUsers(ctx, batchFilter(where), args...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(allUsersQuery(where)), args...)
+	if err != nil {
+		slog.Error("Error querying users", "error", err)
+		return nil, err
+	}
+
+	return streamUsers(ctx, rows), nil
+}
+
+// AllUserSeq iterates over the users AllUsers streams, in the same way.
+// Unlike AllUsers, a COPY or query that fails partway through is reported
+// as the last element, so a full export cannot end short without an error.
+func (s *DBStore) AllUserSeq(ctx context.Context) iter.Seq2[User, error] {
+	where, args := s.allUsersFilter(ctx)
+	if s.isPostgres() && where == "" {
+		return s.copyUserSeq(ctx)
+	}
+	if s.batchSize > 0 {
+		return s.batchUserSeq(ctx, batchFilter(where), args...)
+	}
+
+	query := s.rebind(allUsersQuery(where))
+	return func(yield func(User, error) bool) {
+		var user User
+		dest := []any{&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)}
+		yieldRows(ctx, s, query, args, dest, func() bool { return yield(user, nil) }, func(err error) { yield(User{}, err) })
+	}
+}
+
+// allUsersFilter returns the WHERE condition, without the keyword, that
+// limits a full read to ctx's tenant, if it is scoped to one.
+func (s *DBStore) allUsersFilter(ctx context.Context) (string, []any) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	return strings.TrimPrefix(cond, " AND "), args
+}
+
+func allUsersQuery(where string) string {
+	query := "SELECT " + userColumns + " FROM users"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query + " ORDER BY id"
+}
+
+// batchFilter turns where into the filter batchUsers expects, which is
+// joined to the id condition with AND.
+func batchFilter(where string) string {
+	if where == "" {
+		return ""
+	}
+	return where + " AND"
+}
+
+// copyUsers feeds copyUserSeq to a channel, logging the error that ends it.
+func (s *DBStore) copyUsers(ctx context.Context) <-chan User {
+	userChannel := make(chan User)
+
+	go func() {
+		defer close(userChannel)
+
+		for user, err := range s.copyUserSeq(ctx) {
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Error copying users", "error", err)
+				}
+				return
+			}
+			select {
+			case userChannel <- user:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return userChannel
+}
+
+// copyUserSeq iterates over every user read with COPY. Breaking out of the
+// loop closes the pipe, which stops the COPY and releases the connection.
+func (s *DBStore) copyUserSeq(ctx context.Context) iter.Seq2[User, error] {
+	query := "COPY (SELECT " + userColumns + " FROM users ORDER BY id) TO STDOUT"
+
+	return func(yield func(User, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			slog.Error("Error acquiring connection for COPY", "error", err)
+			yield(User{}, err)
+			return
+		}
+
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			err := conn.Raw(func(driverConn any) error {
+				c, ok := driverConn.(*stdlib.Conn)
+				if !ok {
+					return fmt.Errorf("COPY needs a pgx connection, got %T", driverConn)
+				}
+				_, err := c.Conn().PgConn().CopyTo(ctx, pw, query)
+				return err
+			})
+			conn.Close()
+			pw.CloseWithError(err)
+		}()
+
+		readCopyUsers(pr, yield)
+	}
+}
+
+// readCopyUsers decodes the COPY text output in r, one user per line, until
+// yield returns false. A line that does not decode, or an error reading r,
+// is yielded last.
+func readCopyUsers(r io.Reader, yield func(User, error) bool) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		user, err := decodeCopyUser(scanner.Text())
+		if err != nil {
+			yield(User{}, fmt.Errorf("decoding user row: %w", err))
+			return
+		}
+		if !yield(user, nil) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		yield(User{}, err)
+	}
+}
+
+// decodeCopyUser parses one line of COPY text output selected with
+// userColumns.
+func decodeCopyUser(line string) (User, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return User{}, fmt.Errorf("expected 6 columns, got %d", len(fields))
+	}
+
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		v, err := unescapeCopyText(field)
+		if err != nil {
+			return User{}, err
+		}
+		values[i] = v
+	}
+
+	var user User
+	var err error
+	if user.ID, err = strconv.Atoi(values[0]); err != nil {
+		return User{}, fmt.Errorf("id: %w", err)
+	}
+	if values[1] != "" {
+		if user.MailboxID, err = strconv.Atoi(values[1]); err != nil {
+			return User{}, fmt.Errorf("mailbox_id: %w", err)
+		}
+	}
+	user.UserName = values[2]
+	user.EmailAddress = values[3]
+	if user.CreatedAt, err = ParseTimestamp(values[4]); err != nil {
+		return User{}, fmt.Errorf("created_at: %w", err)
+	}
+	if user.UpdatedAt, err = ParseTimestamp(values[5]); err != nil {
+		return User{}, fmt.Errorf("updated_at: %w", err)
+	}
+
+	return user, nil
+}
+
+var errBadEscape = errors.New("invalid escape in COPY text")
+
+// unescapeCopyText reverses the escaping of COPY's text format. NULL (\N)
+// becomes the empty string, matching how the structs represent it.
+func unescapeCopyText(s string) (string, error) {
+	if s == `\N` {
+		return "", nil
+	}
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if i == len(s) {
+			return "", errBadEscape
+		}
+		switch c := s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && isHex(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return "", errBadEscape
+			}
+			v, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			b.WriteByte(byte(v))
+			i = j - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(s[i:j], 8, 8)
+			b.WriteByte(byte(v))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_AllUsers(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users ORDER BY id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(201, 2, "user3", "user3@example.com", "2024-07-23 13:15:00", nil))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+
+	userChan, err := store.AllUsers(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling AllUsers: %v", err)
+	}
+
+	var receivedUsers []User
+	for user := range userChan {
+		receivedUsers = append(receivedUsers, user)
+	}
+
+	expectedUsers := []User{
+		{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+		{ID: 201, MailboxID: 2, UserName: "user3", EmailAddress: "user3@example.com", CreatedAt: ts("2024-07-23 13:15:00")},
+	}
+	if !reflect.DeepEqual(receivedUsers, expectedUsers) {
+		t.Errorf("Expected users %v, got %v", expectedUsers, receivedUsers)
+	}
+}
+
+func TestDBStore_AllUserSeq_RowError(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	failed := errors.New("connection reset")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users ORDER BY id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(201, 2, "user3", "user3@example.com", "2024-07-23 13:15:00", nil).
+			RowError(1, failed))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+
+	var ids []int
+	var err error
+	for user, e := range AllUsers(context.Background(), store) {
+		if e != nil {
+			err = e
+			break
+		}
+		ids = append(ids, user.ID)
+	}
+	if !errors.Is(err, failed) {
+		t.Fatalf("Expected the row error, got %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int{101}) {
+		t.Errorf("Expected users [101] before the error, got %v", ids)
+	}
+}
+
+func TestReadCopyUsers(t *testing.T) {
+	line := "101\t1\tuser1\tuser1@example.com\t2024-07-23 12:30:00\t2024-07-24 09:00:00\n"
+
+	t.Run("Error mid-stream", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		failed := errors.New("COPY aborted")
+		go func() {
+			pw.Write([]byte(line))
+			pw.CloseWithError(failed)
+		}()
+
+		var users int
+		var err error
+		readCopyUsers(pr, func(_ User, e error) bool {
+			if e != nil {
+				err = e
+				return false
+			}
+			users++
+			return true
+		})
+		if users != 1 {
+			t.Errorf("Expected 1 user before the error, got %d", users)
+		}
+		if !errors.Is(err, failed) {
+			t.Errorf("Expected the COPY error, got %v", err)
+		}
+	})
+
+	t.Run("Bad row", func(t *testing.T) {
+		var err error
+		readCopyUsers(strings.NewReader(line+"102\tbroken\n"+line), func(_ User, e error) bool {
+			err = e
+			return true
+		})
+		if err == nil {
+			t.Errorf("Expected an error for the bad row")
+		}
+	})
+
+	t.Run("Stop early", func(t *testing.T) {
+		var users int
+		readCopyUsers(strings.NewReader(line+line+line), func(User, error) bool {
+			users++
+			return false
+		})
+		if users != 1 {
+			t.Errorf("Expected reading to stop after 1 user, got %d", users)
+		}
+	})
+}
+
+func TestDecodeCopyUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		line          string
+		expectedUser  User
+		expectedError bool
+	}{
+		{
+			name:         "Plain row",
+			line:         "101\t1\tuser1\tuser1@example.com\t2024-07-23 12:30:00\t2024-07-24 09:00:00",
+			expectedUser: User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00"), UpdatedAt: ts("2024-07-24 09:00:00")},
+		},
+		{
+			name:         "Escapes and NULLs",
+			line:         "102\t\\N\tuser\\\\two\\tx\tuser2@example.com\t\\N\t\\N",
+			expectedUser: User{ID: 102, UserName: "user\\two\tx", EmailAddress: "user2@example.com"},
+		},
+		{
+			name:          "Wrong column count",
+			line:          "103\t1\tuser3",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid id",
+			line:          "abc\t1\tuser\tuser@example.com\t2024-07-23 12:30:00\t2024-07-23 12:30:00",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := decodeCopyUser(tt.line)
+			if tt.expectedError {
+				if err == nil {
+					t.Fatalf("Expected an error decoding %q", tt.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error decoding row: %v", err)
+			}
+			if user != tt.expectedUser {
+				t.Errorf("Expected user %v, got %v", tt.expectedUser, user)
+			}
+		})
+	}
+}
+
+func TestUnescapeCopyText(t *testing.T) {
+	tests := map[string]string{
+		`plain`:       "plain",
+		`a\nb`:        "a\nb",
+		`\x41\102`:    "AB",
+		`back\\slash`: `back\slash`,
+	}
+
+	for input, expected := range tests {
+		got, err := unescapeCopyText(input)
+		if err != nil {
+			t.Errorf("unescapeCopyText(%q) returned error %v", input, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("unescapeCopyText(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+
+	if _, err := unescapeCopyText(`trailing\`); err == nil {
+		t.Errorf("Expected an error for a trailing backslash")
+	}
+}
+
+func TestDBStore_Rebind(t *testing.T) {
+	query := "SELECT id FROM users WHERE created_at > ? OR (created_at = ? AND id > ?)"
+
+	sqlite := &DBStore{driver: "sqlite3"}
+	if got := sqlite.rebind(query); got != query {
+		t.Errorf("Expected SQLite query unchanged, got %q", got)
+	}
+
+	postgres := &DBStore{driver: "pgx"}
+	expected := "SELECT id FROM users WHERE created_at > $1 OR (created_at = $2 AND id > $3)"
+	if got := postgres.rebind(query); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
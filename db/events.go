@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+
+	"mailboxes/scope"
+)
+
+// SetMailboxEvents turns on the mailbox_events log: mailboxes created
+// through the store get a created event, and updates that change a token a
+// token_rotated event, written in the same transaction.
+func (s *DBStore) SetMailboxEvents(enabled bool) {
+	s.mailboxEvents = enabled
+}
+
+// inEventTx runs f in a transaction when the mailbox_events log or the
+// audit_log is on, so a write and its records are committed together, and
+// directly otherwise.
+func (s *DBStore) inEventTx(ctx context.Context, f func(q execQueryer) error) error {
+	return s.inTxIf(ctx, s.mailboxEvents || s.auditLog, f)
+}
+
+// inTxIf runs f in a transaction if useTx is set, and directly otherwise.
+func (s *DBStore) inTxIf(ctx context.Context, useTx bool, f func(q execQueryer) error) error {
+	if !useTx {
+		return f(s.db)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// logMailboxEvent appends an event of kind for mailboxID through q if the
+// mailbox_events log is on.
+func (s *DBStore) logMailboxEvent(ctx context.Context, q execQueryer, mailboxID int, kind string, data map[string]string) error {
+	if !s.mailboxEvents {
+		return nil
+	}
+	_, err := s.appendMailboxEvent(ctx, q, MailboxEvent{MailboxID: mailboxID, Kind: kind, Data: data})
+	return err
+}
+
+// AppendMailboxEvent adds e to the mailbox_events log and returns it with
+// its ID. A zero OccurredAt is set to the current time, and the actor ctx
+// carries, if any, is added to its data.
+func (s *DBStore) AppendMailboxEvent(ctx context.Context, e MailboxEvent) (MailboxEvent, error) {
+	return s.appendMailboxEvent(ctx, s.db, e)
+}
+
+func (s *DBStore) appendMailboxEvent(ctx context.Context, q execQueryer, e MailboxEvent) (MailboxEvent, error) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = now()
+	}
+	if actor := scope.Actor(ctx); actor != "" && e.Data["actor"] == "" {
+		data := map[string]string{"actor": actor}
+		for k, v := range e.Data {
+			data[k] = v
+		}
+		e.Data = data
+	}
+	data, err := eventData(e.Data)
+	if err != nil {
+		return MailboxEvent{}, err
+	}
+
+	query := "INSERT INTO mailbox_events (mailbox_id, kind, data, occurred_at) VALUES (?, ?, ?, ?)"
+	id, err := s.insertID(ctx, q, query, e.MailboxID, e.Kind, data, FormatTimestamp(e.OccurredAt))
+	if err != nil {
+		slog.Error("Error appending mailbox event", "kind", e.Kind, "mailbox_id", e.MailboxID, "error", err)
+		return MailboxEvent{}, err
+	}
+	e.ID = id
+	return e, nil
+}
+
+// eventData encodes an event's data as a JSON object, or NULL if it has none.
+func eventData(data map[string]string) (sql.NullString, error) {
+	if len(data) == 0 {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
+
+const mailboxEventColumns = "id, mailbox_id, kind, data, CAST(occurred_at AS TEXT)"
+
+// MailboxEvents returns the events logged for mailboxID, oldest first. In a
+// context scoped to a tenant, a mailbox of another tenant has none.
+func (s *DBStore) MailboxEvents(ctx context.Context, mailboxID int) ([]MailboxEvent, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT " + mailboxEventColumns + " FROM mailbox_events WHERE mailbox_id = ?" + cond + " ORDER BY id"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append([]any{mailboxID}, args...)...)
+	if err != nil {
+		slog.Error("Error querying events of mailbox", "mailbox_id", mailboxID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []MailboxEvent
+	for rows.Next() {
+		e, err := scanMailboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// AllMailboxEvents streams the whole mailbox_events log, ordered by mailbox,
+// then ID.
+func (s *DBStore) AllMailboxEvents(ctx context.Context) (<-chan MailboxEvent, error) {
+	query := "SELECT " + mailboxEventColumns + " FROM mailbox_events ORDER BY mailbox_id, id"
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		slog.Error("Error querying mailbox events", "error", err)
+		return nil, err
+	}
+
+	ch := make(chan MailboxEvent)
+	go func() {
+		defer close(ch)
+		defer rows.Close()
+
+		for rows.Next() {
+			e, err := scanMailboxEvent(rows)
+			if err != nil {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			slog.Error("Error iterating over mailbox event rows", "error", err)
+		}
+	}()
+
+	return ch, nil
+}
+
+func scanMailboxEvent(rows *sql.Rows) (MailboxEvent, error) {
+	var e MailboxEvent
+	var data sql.NullString
+	if err := rows.Scan(&e.ID, &e.MailboxID, &e.Kind, &data, scanTime(&e.OccurredAt)); err != nil {
+		slog.Error("Error scanning mailbox event row", "error", err)
+		return MailboxEvent{}, err
+	}
+	if data.Valid {
+		if err := json.Unmarshal([]byte(data.String), &e.Data); err != nil {
+			slog.Error("Error decoding data of mailbox event", "event_id", e.ID, "error", err)
+			return MailboxEvent{}, err
+		}
+	}
+	return e, nil
+}
+
+// CompactMailboxEvents deletes the events of mailboxID up to throughID and
+// writes snapshot in their place, in one transaction.
+func (s *DBStore) CompactMailboxEvents(ctx context.Context, mailboxID, throughID int, snapshot MailboxEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting compaction transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM mailbox_events WHERE mailbox_id = ? AND id <= ?"
+	if _, err := tx.ExecContext(ctx, s.rebind(query), mailboxID, throughID); err != nil {
+		slog.Error("Error compacting events of mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+
+	data, err := eventData(snapshot.Data)
+	if err != nil {
+		return err
+	}
+	// The snapshot takes the ID of the last event it replaces, so it still
+	// sorts before the events that were kept.
+	query = "INSERT INTO mailbox_events (id, mailbox_id, kind, data, occurred_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, s.rebind(query), throughID, mailboxID, snapshot.Kind, data, FormatTimestamp(snapshot.OccurredAt)); err != nil {
+		slog.Error("Error writing snapshot event for mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing compaction of mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	return nil
+}
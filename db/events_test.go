@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_MailboxEventsLogged(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	insertEvent := regexp.QuoteMeta("INSERT INTO mailbox_events (mailbox_id, kind, data, occurred_at) VALUES (?, ?, ?, ?) RETURNING id")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id")).
+		WithArgs("mpi789", "token789", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery(insertEvent).
+		WithArgs(3, MailboxCreated, `{"mpi_id":"mpi789"}`, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	// Only an update that changes the token is logged.
+	for _, previous := range []string{"token789", "token000"} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT token FROM mailboxes WHERE id = ?")).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(previous))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE mailboxes SET mpi_id = ?, token = ?, updated_at = ? WHERE id = ?")).
+			WithArgs("mpi789", "token789", sqlmock.AnyArg(), 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if previous != "token789" {
+			mock.ExpectQuery(insertEvent).
+				WithArgs(3, MailboxTokenRotated, nil, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		}
+		mock.ExpectCommit()
+	}
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	store.SetMailboxEvents(true)
+	ctx := context.Background()
+	mb, err := store.CreateMailbox(ctx, Mailbox{MPIID: "mpi789", Token: "token789"})
+	if err != nil {
+		t.Fatalf("Error creating mailbox: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.UpdateMailbox(ctx, mb); err != nil {
+			t.Fatalf("Error updating mailbox: %v", err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_CompactMailboxEvents(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_events WHERE mailbox_id = $1 AND id <= $2")).
+		WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_events (id, mailbox_id, kind, data, occurred_at) VALUES ($1, $2, $3, $4, $5)")).
+		WithArgs(5, 1, MailboxSnapshot, `{"status":"suspended"}`, "2024-07-23 12:00:00").
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "pgx"}
+	snapshot := MailboxEvent{Kind: MailboxSnapshot, Data: map[string]string{"status": "suspended"}, OccurredAt: ts("2024-07-23 12:00:00")}
+	if err := store.CompactMailboxEvents(context.Background(), 1, 5, snapshot); err != nil {
+		t.Fatalf("Error compacting events: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
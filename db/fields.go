@@ -0,0 +1,39 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Fields UpdateUser and UpdateMailbox can be limited to, named as their
+// columns.
+const (
+	FieldUserName     = "user_name"
+	FieldEmailAddress = "email_address"
+	FieldMPIID        = "mpi_id"
+	FieldToken        = "token"
+)
+
+// ErrUnknownField is returned for a field mask naming a field that cannot
+// be updated.
+var ErrUnknownField = errors.New("unknown field")
+
+// fieldMask returns the set of fields an update writes: the ones given, or
+// all of updatable if none are.
+func fieldMask(fields []string, updatable ...string) (map[string]bool, error) {
+	if len(fields) == 0 {
+		fields = updatable
+	}
+	mask := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known := false
+		for _, u := range updatable {
+			known = known || f == u
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownField, f)
+		}
+		mask[f] = true
+	}
+	return mask, nil
+}
@@ -0,0 +1,389 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"sort"
+	"time"
+
+	"mailboxes/tokencrypt"
+)
+
+// A flat store file holds mailboxes and their users as fixed-size records,
+// so that FlatStore can map it into memory and find any of them without
+// reading the rest:
+//
+//	magic       "MBXFLAT1"
+//	users       flatUserSize bytes each, grouped by mailbox in mailbox ID
+//	            order and by ID within a mailbox
+//	mailboxes   flatMailboxSize bytes each, in ID order
+//	strings     the names, email addresses, MPI IDs and tokens the records
+//	            point into
+//	trailer     the offsets and counts of the sections, then magic again
+//
+// Integers are little-endian int64s. Timestamps are Unix nanoseconds, with
+// 0 for the zero time; strings are an offset into the strings section and a
+// length.
+const (
+	flatMagic       = "MBXFLAT1"
+	flatUserSize    = 8 * 8
+	flatMailboxSize = 9 * 8
+	flatTrailerSize = 5*8 + len(flatMagic)
+)
+
+// FlatExt is the file extension flat store files are written with.
+const FlatExt = ".mbx"
+
+// ErrReadOnly is returned by the writes a read-only store cannot make.
+var ErrReadOnly = errors.New("store is read-only")
+
+// FlatWriter writes a flat store file. Mailboxes must be written in
+// increasing ID order, and Close must be called to finish the file. The
+// strings are kept in memory until then.
+type FlatWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	users     int64
+	mailboxes []byte
+	strings   []byte
+	lastID    int
+	record    [flatMailboxSize]byte
+}
+
+// NewFlatWriter starts a flat store file on w.
+func NewFlatWriter(w io.Writer) (*FlatWriter, error) {
+	fw := &FlatWriter{w: bufio.NewWriter(w)}
+	if _, err := fw.w.WriteString(flatMagic); err != nil {
+		return nil, err
+	}
+	fw.offset = int64(len(flatMagic))
+	return fw, nil
+}
+
+// WriteMailbox writes mb and its users, which must be in ID order.
+func (fw *FlatWriter) WriteMailbox(mb Mailbox, users []User) error {
+	if mb.ID <= fw.lastID {
+		return fmt.Errorf("mailbox %d written after mailbox %d", mb.ID, fw.lastID)
+	}
+	fw.lastID = mb.ID
+
+	first := fw.users
+	for i, u := range users {
+		if i > 0 && u.ID <= users[i-1].ID {
+			return fmt.Errorf("mailbox %d: user %d written after user %d", mb.ID, u.ID, users[i-1].ID)
+		}
+		rec := fw.record[:flatUserSize]
+		putInts(rec, int64(u.ID), int64(mb.ID), flatTime(u.CreatedAt), flatTime(u.UpdatedAt))
+		fw.putString(rec[32:], u.UserName)
+		fw.putString(rec[48:], u.EmailAddress)
+		if _, err := fw.w.Write(rec); err != nil {
+			return err
+		}
+		fw.users++
+	}
+	fw.offset += int64(len(users)) * flatUserSize
+
+	rec := fw.record[:flatMailboxSize]
+	putInts(rec, int64(mb.ID), flatTime(mb.CreatedAt), flatTime(mb.UpdatedAt))
+	fw.putString(rec[24:], mb.MPIID)
+	fw.putString(rec[40:], mb.Token)
+	putInts(rec[56:], first, int64(len(users)))
+	fw.mailboxes = append(fw.mailboxes, rec...)
+	return nil
+}
+
+// putString appends s to the strings section and puts its offset and
+// length in b.
+func (fw *FlatWriter) putString(b []byte, s string) {
+	putInts(b, int64(len(fw.strings)), int64(len(s)))
+	fw.strings = append(fw.strings, s...)
+}
+
+// Close writes the mailboxes, the strings and the trailer, and flushes the
+// file. It does not close the underlying writer.
+func (fw *FlatWriter) Close() error {
+	mailboxesAt := fw.offset
+	stringsAt := mailboxesAt + int64(len(fw.mailboxes))
+	trailer := make([]byte, flatTrailerSize)
+	putInts(trailer, int64(len(flatMagic)), fw.users, mailboxesAt, int64(len(fw.mailboxes)/flatMailboxSize), stringsAt)
+	copy(trailer[5*8:], flatMagic)
+
+	for _, b := range [][]byte{fw.mailboxes, fw.strings, trailer} {
+		if _, err := fw.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return fw.w.Flush()
+}
+
+func putInts(b []byte, vs ...int64) {
+	for i, v := range vs {
+		binary.LittleEndian.PutUint64(b[i*8:], uint64(v))
+	}
+}
+
+func flatTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// FlatStore is a read-only Store over a flat store file written by
+// FlatWriter, mapped into memory, for repeated runs that should not read
+// the database. Besides Store it implements FullScanStore, FullScanSeqStore,
+// JoinStore, SeqStore and TokenStore, though it cannot rotate tokens. It is
+// safe for concurrent use.
+type FlatStore struct {
+	data      []byte
+	users     []byte
+	mailboxes []byte
+	strings   []byte
+	// unmap releases data; nil once closed.
+	unmap  func() error
+	tokens *tokencrypt.Keyring
+}
+
+// OpenFlatStore maps the flat store file at path into memory.
+func OpenFlatStore(path string) (*FlatStore, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newFlatStore(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.unmap = unmap
+	return s, nil
+}
+
+// newFlatStore checks the layout of data and returns a store over it.
+func newFlatStore(data []byte) (*FlatStore, error) {
+	size := int64(len(data))
+	if size < int64(len(flatMagic)+flatTrailerSize) || string(data[:len(flatMagic)]) != flatMagic || string(data[size-int64(len(flatMagic)):]) != flatMagic {
+		return nil, errors.New("not a flat store file")
+	}
+	t := data[size-int64(flatTrailerSize):]
+	usersAt, users, mailboxesAt, mailboxes, stringsAt := flatInt(t, 0), flatInt(t, 1), flatInt(t, 2), flatInt(t, 3), flatInt(t, 4)
+	end := size - int64(flatTrailerSize)
+	if usersAt != int64(len(flatMagic)) || users < 0 || mailboxes < 0 ||
+		mailboxesAt != usersAt+users*flatUserSize || stringsAt != mailboxesAt+mailboxes*flatMailboxSize || stringsAt > end {
+		return nil, errors.New("corrupt flat store trailer")
+	}
+	return &FlatStore{
+		data:      data,
+		users:     data[usersAt:mailboxesAt],
+		mailboxes: data[mailboxesAt:stringsAt],
+		strings:   data[stringsAt:end],
+	}, nil
+}
+
+func flatInt(b []byte, i int) int64 {
+	return int64(binary.LittleEndian.Uint64(b[i*8:]))
+}
+
+// Close unmaps the file. The store must not be used afterwards.
+func (s *FlatStore) Close() error {
+	if s.unmap == nil {
+		return nil
+	}
+	err := s.unmap()
+	s.unmap = nil
+	return err
+}
+
+// Counts returns how many mailboxes and users the file holds.
+func (s *FlatStore) Counts() (mailboxes, users int) {
+	return len(s.mailboxes) / flatMailboxSize, len(s.users) / flatUserSize
+}
+
+// SetTokenKeyring has the store open sealed tokens with k. Plaintext tokens
+// are read as they are.
+func (s *FlatStore) SetTokenKeyring(k *tokencrypt.Keyring) {
+	s.tokens = k
+}
+
+// RotateTokens returns ErrReadOnly; a file is re-sealed by writing it again.
+func (s *FlatStore) RotateTokens(ctx context.Context, dryRun bool) (TokenRotation, error) {
+	return TokenRotation{}, ErrReadOnly
+}
+
+func (s *FlatStore) string(rec []byte, i int) (string, error) {
+	off, n := flatInt(rec, i), flatInt(rec, i+1)
+	if off < 0 || n < 0 || off+n > int64(len(s.strings)) {
+		return "", errors.New("corrupt flat store string")
+	}
+	return string(s.strings[off : off+n]), nil
+}
+
+func flatTimeAt(rec []byte, i int) time.Time {
+	ns := flatInt(rec, i)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+// mailbox decodes the i-th mailbox, and the range of its users.
+func (s *FlatStore) mailbox(i int) (Mailbox, int64, int64, error) {
+	rec := s.mailboxes[i*flatMailboxSize : (i+1)*flatMailboxSize]
+	mb := Mailbox{ID: int(flatInt(rec, 0)), CreatedAt: flatTimeAt(rec, 1), UpdatedAt: flatTimeAt(rec, 2)}
+	var err error
+	if mb.MPIID, err = s.string(rec, 3); err != nil {
+		return Mailbox{}, 0, 0, err
+	}
+	stored, err := s.string(rec, 5)
+	if err != nil {
+		return Mailbox{}, 0, 0, err
+	}
+	if mb.Token, err = s.tokens.Open(stored); err != nil {
+		return Mailbox{}, 0, 0, fmt.Errorf("mailbox %d: %w", mb.ID, err)
+	}
+	first, n := flatInt(rec, 7), flatInt(rec, 8)
+	if first < 0 || n < 0 || (first+n)*flatUserSize > int64(len(s.users)) {
+		return Mailbox{}, 0, 0, fmt.Errorf("mailbox %d: corrupt user range", mb.ID)
+	}
+	return mb, first, first + n, nil
+}
+
+// user decodes the i-th user.
+func (s *FlatStore) user(i int64) (User, error) {
+	rec := s.users[i*flatUserSize : (i+1)*flatUserSize]
+	u := User{ID: int(flatInt(rec, 0)), MailboxID: int(flatInt(rec, 1)), CreatedAt: flatTimeAt(rec, 2), UpdatedAt: flatTimeAt(rec, 3)}
+	var err error
+	if u.UserName, err = s.string(rec, 4); err != nil {
+		return User{}, err
+	}
+	if u.EmailAddress, err = s.string(rec, 6); err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// findMailbox returns the index of mailboxID, or false.
+func (s *FlatStore) findMailbox(mailboxID int) (int, bool) {
+	n := len(s.mailboxes) / flatMailboxSize
+	i := sort.Search(n, func(i int) bool {
+		return flatInt(s.mailboxes[i*flatMailboxSize:], 0) >= int64(mailboxID)
+	})
+	return i, i < n && flatInt(s.mailboxes[i*flatMailboxSize:], 0) == int64(mailboxID)
+}
+
+// MailboxSeq yields every mailbox in ID order.
+func (s *FlatStore) MailboxSeq(ctx context.Context) iter.Seq2[Mailbox, error] {
+	return func(yield func(Mailbox, error) bool) {
+		for i := range len(s.mailboxes) / flatMailboxSize {
+			if err := ctx.Err(); err != nil {
+				yield(Mailbox{}, err)
+				return
+			}
+			mb, _, _, err := s.mailbox(i)
+			if !yield(mb, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// UserSeq yields the users of mailboxID in ID order; none if the file does
+// not hold the mailbox.
+func (s *FlatStore) UserSeq(ctx context.Context, mailboxID int) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		i, ok := s.findMailbox(mailboxID)
+		if !ok {
+			return
+		}
+		_, first, end, err := s.mailbox(i)
+		if err != nil {
+			yield(User{}, err)
+			return
+		}
+		s.yieldUsers(ctx, first, end, yield)
+	}
+}
+
+func (s *FlatStore) yieldUsers(ctx context.Context, first, end int64, yield func(User, error) bool) bool {
+	for j := first; j < end; j++ {
+		if err := ctx.Err(); err != nil {
+			yield(User{}, err)
+			return false
+		}
+		u, err := s.user(j)
+		if !yield(u, err) || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// feed streams seq to a channel until it ends, fails or ctx is done,
+// logging any error, as the channel methods of DBStore do.
+func feed[T any](ctx context.Context, seq iter.Seq2[T, error], what string) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for v, err := range seq {
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Error reading from flat store", "what", what, "error", err)
+				}
+				return
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (s *FlatStore) AllMailboxes(ctx context.Context) (<-chan Mailbox, error) {
+	return feed(ctx, s.MailboxSeq(ctx), "mailboxes"), nil
+}
+
+func (s *FlatStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan User, error) {
+	return feed(ctx, s.UserSeq(ctx, mailboxID), "users"), nil
+}
+
+// AllUsers streams every user, ordered by mailbox, then ID.
+func (s *FlatStore) AllUsers(ctx context.Context) (<-chan User, error) {
+	return feed(ctx, s.AllUserSeq(ctx), "users"), nil
+}
+
+// AllUserSeq yields every user, ordered by mailbox, then ID.
+func (s *FlatStore) AllUserSeq(ctx context.Context) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		s.yieldUsers(ctx, 0, int64(len(s.users)/flatUserSize), yield)
+	}
+}
+
+// UsersWithMailboxes streams every user with its mailbox, ordered by
+// mailbox, then user ID.
+func (s *FlatStore) UsersWithMailboxes(ctx context.Context) (<-chan MailboxUser, error) {
+	seq := func(yield func(MailboxUser, error) bool) {
+		for i := range len(s.mailboxes) / flatMailboxSize {
+			mb, first, end, err := s.mailbox(i)
+			if err != nil {
+				yield(MailboxUser{}, err)
+				return
+			}
+			more := s.yieldUsers(ctx, first, end, func(u User, err error) bool {
+				return yield(MailboxUser{Mailbox: mb, User: u}, err)
+			})
+			if !more {
+				return
+			}
+		}
+	}
+	return feed(ctx, seq, "users"), nil
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	flatMailboxes = []Mailbox{
+		{ID: 1, MPIID: "mpi1", Token: "token1", CreatedAt: time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC), UpdatedAt: time.Date(2024, 7, 24, 12, 0, 0, 0, time.UTC)},
+		{ID: 3, MPIID: "mpi3"},
+	}
+	flatUsers = map[int][]User{
+		1: {
+			{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)},
+			{ID: 104, MailboxID: 1, UserName: "user4"},
+		},
+		3: {{ID: 102, MailboxID: 3, UserName: "user2", EmailAddress: "user2@example.com"}},
+	}
+)
+
+// writeFlatStore writes flatMailboxes, with tokens sealed by seal if given,
+// to a file and opens it.
+func writeFlatStore(t *testing.T, seal func(string) string) *FlatStore {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "store"+FlatExt)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewFlatWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mb := range flatMailboxes {
+		if seal != nil {
+			mb.Token = seal(mb.Token)
+		}
+		if err := w.WriteMailbox(mb, flatUsers[mb.ID]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFlatStore(path)
+	if err != nil {
+		t.Fatalf("Error opening flat store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestFlatStore(t *testing.T) {
+	s := writeFlatStore(t, nil)
+	ctx := context.Background()
+
+	if mailboxes, users := s.Counts(); mailboxes != 2 || users != 3 {
+		t.Errorf("Expected 2 mailboxes and 3 users, got %d and %d", mailboxes, users)
+	}
+	if got := drain(s.AllMailboxes(ctx)); !reflect.DeepEqual(got, flatMailboxes) {
+		t.Errorf("Expected mailboxes %+v, got %+v", flatMailboxes, got)
+	}
+	for _, id := range []int{1, 3} {
+		if got := drain(s.UsersForMailbox(ctx, id)); !reflect.DeepEqual(got, flatUsers[id]) {
+			t.Errorf("Expected users %+v of mailbox %d, got %+v", flatUsers[id], id, got)
+		}
+	}
+	if got := drain(s.UsersForMailbox(ctx, 2)); len(got) != 0 {
+		t.Errorf("Expected no users for a mailbox not in the file, got %+v", got)
+	}
+
+	all := append(append([]User{}, flatUsers[1]...), flatUsers[3]...)
+	if got := drain(s.AllUsers(ctx)); !reflect.DeepEqual(got, all) {
+		t.Errorf("Expected every user %+v, got %+v", all, got)
+	}
+	pairs := drain(s.UsersWithMailboxes(ctx))
+	if len(pairs) != 3 || pairs[2].Mailbox.ID != 3 || pairs[2].User.ID != 102 || pairs[0].Mailbox.Token != "token1" {
+		t.Errorf("Unexpected users with mailboxes %+v", pairs)
+	}
+
+	// Iteration stops when the reader does.
+	for u, err := range s.UserSeq(ctx, 1) {
+		if err != nil || u.ID != 101 {
+			t.Errorf("Unexpected first user %+v, %v", u, err)
+		}
+		break
+	}
+	if _, err := s.RotateTokens(ctx, false); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestFlatStore_SealedTokens(t *testing.T) {
+	k := testKeyring(t, "new")
+	s := writeFlatStore(t, func(tok string) string {
+		sealed, err := k.Seal(tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	})
+
+	var err error
+	for _, e := range s.MailboxSeq(context.Background()) {
+		err = e
+		break
+	}
+	if err == nil {
+		t.Errorf("Expected an error opening a sealed token without keys")
+	}
+
+	s.SetTokenKeyring(k)
+	mailboxes := drain(s.AllMailboxes(context.Background()))
+	if len(mailboxes) != 2 || mailboxes[0].Token != "token1" || mailboxes[1].Token != "" {
+		t.Errorf("Unexpected mailboxes %+v", mailboxes)
+	}
+}
+
+func TestFlatWriter_Order(t *testing.T) {
+	w, err := NewFlatWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMailbox(Mailbox{ID: 2}, []User{{ID: 5}, {ID: 4}}); err == nil {
+		t.Errorf("Expected an error for users out of order")
+	}
+	if err := w.WriteMailbox(Mailbox{ID: 3}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMailbox(Mailbox{ID: 3}, nil); err == nil {
+		t.Errorf("Expected an error for a mailbox written twice")
+	}
+}
+
+func TestNewFlatStore_Corrupt(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewFlatWriter(&buf)
+	w.WriteMailbox(Mailbox{ID: 1, MPIID: "mpi1"}, []User{{ID: 1}})
+	w.Close()
+	data := buf.Bytes()
+
+	if _, err := newFlatStore(data); err != nil {
+		t.Fatalf("Error reading a good file: %v", err)
+	}
+	if _, err := newFlatStore(data[:len(data)-1]); err == nil {
+		t.Errorf("Expected an error for a truncated file")
+	}
+	bad := bytes.Clone(data)
+	bad[len(bad)-flatTrailerSize+8]++ // user count
+	if _, err := newFlatStore(bad); err == nil {
+		t.Errorf("Expected an error for a corrupt trailer")
+	}
+}
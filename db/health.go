@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrNoSummary is returned for a mailbox whose health was never computed.
+var ErrNoSummary = errors.New("mailbox has no health summary")
+
+// MailboxActivity counts the users and queued retries of each mailbox and
+// reads when its users were last recorded in the processed_users ledger.
+func (s *DBStore) MailboxActivity(ctx context.Context) (map[int]MailboxActivity, error) {
+	activity := make(map[int]MailboxActivity)
+
+	rows, err := s.db.QueryContext(ctx, "SELECT mailbox_id, COUNT(*) FROM users GROUP BY mailbox_id")
+	if err != nil {
+		slog.Error("Error counting users per mailbox", "error", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var a MailboxActivity
+		if err := rows.Scan(&a.MailboxID, &a.Users); err != nil {
+			rows.Close()
+			slog.Error("Error scanning user count row", "error", err)
+			return nil, err
+		}
+		activity[a.MailboxID] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT u.mailbox_id, COUNT(*) FROM retry_queue r JOIN users u ON u.id = r.user_id GROUP BY u.mailbox_id"
+	rows, err = s.db.QueryContext(ctx, query)
+	if err != nil {
+		slog.Error("Error counting retries per mailbox", "error", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var mailboxID, failing int
+		if err := rows.Scan(&mailboxID, &failing); err != nil {
+			rows.Close()
+			slog.Error("Error scanning retry count row", "error", err)
+			return nil, err
+		}
+		if a, ok := activity[mailboxID]; ok {
+			a.FailingUsers = failing
+			activity[mailboxID] = a
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = "SELECT mailbox_id, CAST(MAX(processed_at) AS TEXT) FROM processed_users GROUP BY mailbox_id"
+	rows, err = s.db.QueryContext(ctx, query)
+	if err != nil {
+		slog.Error("Error querying last processed users", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a MailboxActivity
+		if err := rows.Scan(&a.MailboxID, scanTime(&a.LastProcessedAt)); err != nil {
+			slog.Error("Error scanning last processed row", "error", err)
+			return nil, err
+		}
+		if prev, ok := activity[a.MailboxID]; ok {
+			prev.LastProcessedAt = a.LastProcessedAt
+			activity[a.MailboxID] = prev
+		}
+	}
+	return activity, rows.Err()
+}
+
+// SaveMailboxSummaries upserts summaries into mailbox_summary in one
+// transaction.
+func (s *DBStore) SaveMailboxSummaries(ctx context.Context, summaries []MailboxSummary) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting summary transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := s.rebind("INSERT INTO mailbox_summary (mailbox_id, health_score, token_valid, verification, failure_rate, last_processed_at, reasons, computed_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (mailbox_id) DO UPDATE SET health_score = excluded.health_score, " +
+		"token_valid = excluded.token_valid, verification = excluded.verification, failure_rate = excluded.failure_rate, " +
+		"last_processed_at = excluded.last_processed_at, reasons = excluded.reasons, computed_at = excluded.computed_at")
+	for _, sum := range summaries {
+		var reasons sql.NullString
+		if len(sum.Reasons) > 0 {
+			data, err := json.Marshal(sum.Reasons)
+			if err != nil {
+				return err
+			}
+			reasons = sql.NullString{String: string(data), Valid: true}
+		}
+		var lastProcessed sql.NullString
+		if !sum.LastProcessedAt.IsZero() {
+			lastProcessed = sql.NullString{String: FormatTimestamp(sum.LastProcessedAt), Valid: true}
+		}
+
+		if _, err := tx.ExecContext(ctx, query, sum.MailboxID, sum.HealthScore, sum.TokenValid, sum.Verification,
+			sum.FailureRate, lastProcessed, reasons, FormatTimestamp(sum.ComputedAt)); err != nil {
+			slog.Error("Error saving summary of mailbox", "mailbox_id", sum.MailboxID, "error", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing mailbox summaries", "error", err)
+		return err
+	}
+	return nil
+}
+
+const mailboxSummaryColumns = "mailbox_id, health_score, token_valid, verification, failure_rate, " +
+	"CAST(last_processed_at AS TEXT), reasons, CAST(computed_at AS TEXT)"
+
+// MailboxSummaries returns up to limit summaries ordered by health score,
+// then mailbox ID. In a context scoped to a tenant only the tenant's
+// mailboxes are summarized.
+func (s *DBStore) MailboxSummaries(ctx context.Context, limit int) ([]MailboxSummary, error) {
+	query := "SELECT " + mailboxSummaryColumns + " FROM mailbox_summary"
+	cond, args := tenantScope(ctx, "mailbox_id")
+	if cond != "" {
+		query += " WHERE " + strings.TrimPrefix(cond, " AND ")
+	}
+	query += " ORDER BY health_score, mailbox_id LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append(args, limit)...)
+	if err != nil {
+		slog.Error("Error querying mailbox summaries", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []MailboxSummary
+	for rows.Next() {
+		sum, err := scanMailboxSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, sum)
+	}
+	return summaries, rows.Err()
+}
+
+// MailboxSummary returns the summary of mailboxID. In a context scoped to a
+// tenant, another tenant's mailbox has none.
+func (s *DBStore) MailboxSummary(ctx context.Context, mailboxID int) (MailboxSummary, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT " + mailboxSummaryColumns + " FROM mailbox_summary WHERE mailbox_id = ?" + cond
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append([]any{mailboxID}, args...)...)
+	if err != nil {
+		slog.Error("Error querying summary of mailbox", "mailbox_id", mailboxID, "error", err)
+		return MailboxSummary{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return MailboxSummary{}, err
+		}
+		return MailboxSummary{}, fmt.Errorf("%w: %d", ErrNoSummary, mailboxID)
+	}
+	return scanMailboxSummary(rows)
+}
+
+func scanMailboxSummary(rows *sql.Rows) (MailboxSummary, error) {
+	var sum MailboxSummary
+	var reasons sql.NullString
+	if err := rows.Scan(&sum.MailboxID, &sum.HealthScore, &sum.TokenValid, &sum.Verification, &sum.FailureRate,
+		scanTime(&sum.LastProcessedAt), &reasons, scanTime(&sum.ComputedAt)); err != nil {
+		slog.Error("Error scanning mailbox summary row", "error", err)
+		return MailboxSummary{}, err
+	}
+	if reasons.Valid {
+		if err := json.Unmarshal([]byte(reasons.String), &sum.Reasons); err != nil {
+			slog.Error("Error decoding reasons of mailbox", "mailbox_id", sum.MailboxID, "error", err)
+			return MailboxSummary{}, err
+		}
+	}
+	return sum, nil
+}
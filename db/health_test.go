@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_MailboxActivity(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, COUNT(*) FROM users GROUP BY mailbox_id")).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "count"}).AddRow(1, 2).AddRow(2, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT u.mailbox_id, COUNT(*) FROM retry_queue r JOIN users u ON u.id = r.user_id GROUP BY u.mailbox_id")).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "count"}).AddRow(2, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, CAST(MAX(processed_at) AS TEXT) FROM processed_users GROUP BY mailbox_id")).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "processed_at"}).AddRow(1, "2024-07-23 14:00:00").AddRow(3, "2024-07-23 15:00:00"))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	activity, err := store.MailboxActivity(context.Background())
+	if err != nil {
+		t.Fatalf("Error reading mailbox activity: %v", err)
+	}
+
+	expected := map[int]MailboxActivity{
+		1: {MailboxID: 1, Users: 2, LastProcessedAt: ts("2024-07-23 14:00:00")},
+		2: {MailboxID: 2, Users: 1, FailingUsers: 1},
+	}
+	if !reflect.DeepEqual(activity, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, activity)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_MailboxSummaries(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	sum := MailboxSummary{
+		MailboxID:    2,
+		HealthScore:  60,
+		Verification: "rejected",
+		FailureRate:  0.5,
+		Reasons:      []string{"token rejected by the provider", "1 of 2 users failing"},
+		ComputedAt:   ts("2024-07-23 16:00:00"),
+	}
+	reasons := `["token rejected by the provider","1 of 2 users failing"]`
+	columns := []string{"mailbox_id", "health_score", "token_valid", "verification", "failure_rate", "last_processed_at", "reasons", "computed_at"}
+	selectSummaries := regexp.QuoteMeta("SELECT mailbox_id, health_score, token_valid, verification, failure_rate, CAST(last_processed_at AS TEXT), reasons, CAST(computed_at AS TEXT) FROM mailbox_summary")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_summary")).
+		WithArgs(2, 60, false, "rejected", 0.5, nil, reasons, "2024-07-23 16:00:00").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(selectSummaries + ".*ORDER BY health_score, mailbox_id LIMIT").WithArgs(10).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(2, 60, false, "rejected", 0.5, nil, reasons, "2024-07-23 16:00:00"))
+	mock.ExpectQuery(selectSummaries + ".*WHERE mailbox_id").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ctx := context.Background()
+	if err := store.SaveMailboxSummaries(ctx, []MailboxSummary{sum}); err != nil {
+		t.Fatalf("Error saving summaries: %v", err)
+	}
+
+	summaries, err := store.MailboxSummaries(ctx, 10)
+	if err != nil {
+		t.Fatalf("Error reading summaries: %v", err)
+	}
+	if !reflect.DeepEqual(summaries, []MailboxSummary{sum}) {
+		t.Errorf("Expected %+v, got %+v", sum, summaries)
+	}
+
+	if _, err := store.MailboxSummary(ctx, 3); !errors.Is(err, ErrNoSummary) {
+		t.Errorf("Expected ErrNoSummary, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+func (s *DBStore) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (IdempotencyRecord, bool, error) {
+	now := time.Now().UTC()
+
+	query := "DELETE FROM idempotency_keys WHERE idempotency_key = ? AND (created_at < ? OR (status = 0 AND created_at < ?))"
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), key, now.Add(-ttl).Format(TimestampLayout), now.Add(-lease).Format(TimestampLayout)); err != nil {
+		slog.Error("Error expiring idempotency key", "error", err)
+		return IdempotencyRecord{}, false, err
+	}
+
+	query = "INSERT INTO idempotency_keys (idempotency_key, fingerprint, status, content_type, body, created_at) " +
+		"VALUES (?, ?, 0, '', '', ?) ON CONFLICT (idempotency_key) DO NOTHING"
+	res, err := s.db.ExecContext(ctx, s.rebind(query), key, fingerprint, now.Format(TimestampLayout))
+	if err != nil {
+		slog.Error("Error reserving idempotency key", "error", err)
+		return IdempotencyRecord{}, false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return IdempotencyRecord{Fingerprint: fingerprint}, true, nil
+	}
+
+	query = "SELECT fingerprint, status, content_type, COALESCE(headers, ''), body FROM idempotency_keys WHERE idempotency_key = ?"
+
+	var rec IdempotencyRecord
+	var contentType, headers, body string
+	if err := s.db.QueryRowContext(ctx, s.rebind(query), key).Scan(&rec.Fingerprint, &rec.Status, &contentType, &headers, &body); err != nil {
+		slog.Error("Error querying idempotency key", "error", err)
+		return IdempotencyRecord{}, false, err
+	}
+	rec.Body = []byte(body)
+
+	// Responses stored before headers were kept have only their content type.
+	switch {
+	case headers != "":
+		if err := json.Unmarshal([]byte(headers), &rec.Header); err != nil {
+			slog.Error("Error decoding idempotent response headers", "error", err)
+			return IdempotencyRecord{}, false, err
+		}
+	case contentType != "":
+		rec.Header = map[string][]string{"Content-Type": {contentType}}
+	}
+
+	return rec, false, nil
+}
+
+func (s *DBStore) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	query := "UPDATE idempotency_keys SET status = ?, content_type = ?, headers = ?, body = ? WHERE idempotency_key = ?"
+
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	var contentType string
+	if v := header["Content-Type"]; len(v) > 0 {
+		contentType = v[0]
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), status, contentType, string(headers), string(body), key); err != nil {
+		slog.Error("Error storing idempotent response", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *DBStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	query := "DELETE FROM idempotency_keys WHERE idempotency_key = ?"
+
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), key); err != nil {
+		slog.Error("Error releasing idempotency key", "error", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_ReserveIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	expireQuery := regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE idempotency_key = ? AND (created_at < ? OR (status = 0 AND created_at < ?))")
+	insertQuery := regexp.QuoteMeta("INSERT INTO idempotency_keys (idempotency_key, fingerprint, status, content_type, body, created_at) " +
+		"VALUES (?, ?, 0, '', '', ?) ON CONFLICT (idempotency_key) DO NOTHING")
+	selectQuery := regexp.QuoteMeta("SELECT fingerprint, status, content_type, COALESCE(headers, ''), body FROM idempotency_keys WHERE idempotency_key = ?")
+
+	tests := []struct {
+		name             string
+		mockSetup        func(mock sqlmock.Sqlmock)
+		expectedRecord   IdempotencyRecord
+		expectedReserved bool
+	}{
+		{
+			name: "New key",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(expireQuery).WithArgs("key1", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertQuery).WithArgs("key1", "fp", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedRecord:   IdempotencyRecord{Fingerprint: "fp"},
+			expectedReserved: true,
+		},
+		{
+			name: "Completed key",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(expireQuery).WithArgs("key1", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertQuery).WithArgs("key1", "fp", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(selectQuery).
+					WithArgs("key1").
+					WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "content_type", "headers", "body"}).
+						AddRow("fp", 201, "application/json", `{"Content-Type":["application/json"],"Location":["/mailboxes/3/users"]}`, `{"id":"3"}`))
+			},
+			expectedRecord: IdempotencyRecord{
+				Fingerprint: "fp",
+				Status:      201,
+				Header:      map[string][]string{"Content-Type": {"application/json"}, "Location": {"/mailboxes/3/users"}},
+				Body:        []byte(`{"id":"3"}`),
+			},
+		},
+		{
+			name: "Key completed before headers were stored",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(expireQuery).WithArgs("key1", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertQuery).WithArgs("key1", "fp", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(selectQuery).
+					WithArgs("key1").
+					WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "content_type", "headers", "body"}).
+						AddRow("fp", 201, "application/json", "", `{"id":"3"}`))
+			},
+			expectedRecord: IdempotencyRecord{Fingerprint: "fp", Status: 201, Header: map[string][]string{"Content-Type": {"application/json"}}, Body: []byte(`{"id":"3"}`)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db}
+
+			rec, reserved, err := store.ReserveIdempotencyKey(ctx, "key1", "fp", 24*time.Hour, time.Minute)
+			if err != nil {
+				t.Fatalf("Error calling ReserveIdempotencyKey: %v", err)
+			}
+			if reserved != tt.expectedReserved {
+				t.Errorf("Expected reserved %v, got %v", tt.expectedReserved, reserved)
+			}
+			if !reflect.DeepEqual(rec, tt.expectedRecord) {
+				t.Errorf("Expected record %+v, got %+v", tt.expectedRecord, rec)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
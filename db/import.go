@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// ImportUsers creates each record's mailbox unless one with its MPI ID
+// exists, then the user unless one with its email address exists, all in
+// one transaction. Users are inserted in batches once every record has been
+// checked. A dry run makes the same checks and rolls back. A user that would
+// exceed its mailbox's quota fails the whole import.
+func (s *DBStore) ImportUsers(ctx context.Context, records []ImportRecord, dryRun bool) (ImportResult, error) {
+	var result ImportResult
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting import transaction", "error", err)
+		return result, err
+	}
+	defer tx.Rollback()
+
+	importedAt := now()
+	createdAt := FormatTimestamp(importedAt)
+	mailboxes := map[string]int{}
+	pending := map[string]bool{}
+	perMailbox := map[int]int{}
+	var users []User
+	for _, r := range records {
+		mailboxID, ok := mailboxes[r.MPIID]
+		if !ok {
+			err := tx.QueryRowContext(ctx, s.rebind("SELECT id FROM mailboxes WHERE mpi_id = ?"), r.MPIID).Scan(&mailboxID)
+			if errors.Is(err, sql.ErrNoRows) {
+				query := "INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, '', ?, ?)"
+				if mailboxID, err = s.insertID(ctx, tx, query, r.MPIID, createdAt, createdAt); err != nil {
+					slog.Error("Error creating mailbox", "mpi_id", r.MPIID, "error", err)
+					return result, err
+				}
+				mb := Mailbox{ID: mailboxID, MPIID: r.MPIID, CreatedAt: importedAt, UpdatedAt: importedAt}
+				if err := s.logAudit(ctx, tx, ChangeCreate, AuditMailbox, mailboxID, nil, &mb); err != nil {
+					return result, err
+				}
+				result.Mailboxes = append(result.Mailboxes, r.MPIID)
+			} else if err != nil {
+				slog.Error("Error looking up mailbox", "mpi_id", r.MPIID, "error", err)
+				return result, err
+			}
+			mailboxes[r.MPIID] = mailboxID
+		}
+
+		if pending[r.EmailAddress] {
+			result.Existing = append(result.Existing, r)
+			continue
+		}
+		var existing int
+		err := tx.QueryRowContext(ctx, s.rebind("SELECT id FROM users WHERE email_address = ?"), r.EmailAddress).Scan(&existing)
+		switch {
+		case err == nil:
+			result.Existing = append(result.Existing, r)
+			continue
+		case !errors.Is(err, sql.ErrNoRows):
+			slog.Error("Error looking up user", "email_address", r.EmailAddress, "error", err)
+			return result, err
+		}
+
+		pending[r.EmailAddress] = true
+		perMailbox[mailboxID]++
+		users = append(users, User{MailboxID: mailboxID, UserName: r.UserName, EmailAddress: r.EmailAddress, CreatedAt: importedAt, UpdatedAt: importedAt})
+		result.Users = append(result.Users, r)
+	}
+
+	for mailboxID, n := range perMailbox {
+		if err := s.checkUserQuotaFor(ctx, tx, mailboxID, n); err != nil {
+			return result, err
+		}
+	}
+	if err := s.insertUsers(ctx, tx, users); err != nil {
+		return result, err
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing import", "error", err)
+		return ImportResult{}, err
+	}
+	return result, nil
+}
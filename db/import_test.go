@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_ImportUsers(t *testing.T) {
+	mailboxQuery := regexp.QuoteMeta("SELECT id FROM mailboxes WHERE mpi_id = ?")
+	createMailbox := regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, '', ?, ?) RETURNING id")
+	userQuery := regexp.QuoteMeta("SELECT id FROM users WHERE email_address = ?")
+	createUser := regexp.QuoteMeta("INSERT INTO users (mailbox_id, user_name, email_address, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING id")
+
+	records := []ImportRecord{
+		{MPIID: "mpi123", UserName: "user1", EmailAddress: "user1@example.com"},
+		{MPIID: "mpi900", UserName: "new", EmailAddress: "new@example.com"},
+	}
+
+	expectImport := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(mailboxQuery).WithArgs("mpi123").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(userQuery).WithArgs("user1@example.com").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(101))
+		mock.ExpectQuery(mailboxQuery).WithArgs("mpi900").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(createMailbox).WithArgs("mpi900", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectQuery(userQuery).WithArgs("new@example.com").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(createUser).WithArgs(12, "new", "new@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(300))
+		expectBatchChanges(mock,
+			UserChange{UserID: 300, Op: ChangeCreate, Field: "mailbox_id", New: "12"},
+			UserChange{UserID: 300, Op: ChangeCreate, Field: "user_name", New: "new"},
+			UserChange{UserID: 300, Op: ChangeCreate, Field: "email_address", New: "new@example.com"})
+	}
+
+	tests := []struct {
+		name      string
+		dryRun    bool
+		mockSetup func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "Imported",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectImport(mock)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "Dry run",
+			dryRun: true,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectImport(mock)
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	expected := ImportResult{
+		Mailboxes: []string{"mpi900"},
+		Users:     records[1:],
+		Existing:  records[:1],
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+
+			result, err := store.ImportUsers(context.Background(), records, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Error importing users: %v", err)
+			}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, result)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
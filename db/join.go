@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+)
+
+// UsersWithMailboxes streams every user paired with its mailbox, ordered by
+// mailbox and then user ID, from a single JOIN query. Mailboxes without users
+// are not returned, nor in a context scoped to a tenant are other tenants'.
+// Cancelling ctx aborts the query and closes the channel.
+func (s *DBStore) UsersWithMailboxes(ctx context.Context) (<-chan MailboxUser, error) {
+	cond, args := tenantScope(ctx, "m.id")
+	query := "SELECT m.id, m.mpi_id, m.token, CAST(m.created_at AS TEXT), CAST(m.updated_at AS TEXT), " +
+		"u.id, u.mailbox_id, u.user_name, u.email_address, CAST(u.created_at AS TEXT), CAST(u.updated_at AS TEXT) " +
+		"FROM mailboxes m JOIN users u ON u.mailbox_id = m.id" + cond + " ORDER BY m.id, u.id"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying users with mailboxes", "error", err)
+		return nil, err
+	}
+
+	pairs := make(chan MailboxUser)
+
+	go func() {
+		defer close(pairs)
+		defer rows.Close()
+
+		var p MailboxUser
+		dest := []any{&p.Mailbox.ID, &p.Mailbox.MPIID, s.scanToken(&p.Mailbox.Token), scanTime(&p.Mailbox.CreatedAt), scanTime(&p.Mailbox.UpdatedAt),
+			&p.User.ID, &p.User.MailboxID, &p.User.UserName, &p.User.EmailAddress, scanTime(&p.User.CreatedAt), scanTime(&p.User.UpdatedAt)}
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				slog.Error("Error scanning user with mailbox row", "error", err)
+				continue
+			}
+			select {
+			case pairs <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			slog.Error("Error iterating over users with mailboxes", "error", err)
+			return
+		}
+	}()
+
+	return pairs, nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_UsersWithMailboxes(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT m.id, m.mpi_id, m.token, CAST(m.created_at AS TEXT), CAST(m.updated_at AS TEXT), " +
+		"u.id, u.mailbox_id, u.user_name, u.email_address, CAST(u.created_at AS TEXT), CAST(u.updated_at AS TEXT) " +
+		"FROM mailboxes m JOIN users u ON u.mailbox_id = m.id ORDER BY m.id, u.id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at", "id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00", nil, 101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00", nil, 102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00", nil).
+			AddRow(2, "mpi456", "token456", "2024-07-23 13:00:00", nil, 201, 2, "user3", "user3@example.com", "2024-07-23 13:15:00", nil))
+
+	store := &DBStore{db: db}
+
+	pairs, err := store.UsersWithMailboxes(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling UsersWithMailboxes: %v", err)
+	}
+
+	var got []MailboxUser
+	for p := range pairs {
+		got = append(got, p)
+	}
+
+	mb1 := Mailbox{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: ts("2024-07-23 12:00:00")}
+	mb2 := Mailbox{ID: 2, MPIID: "mpi456", Token: "token456", CreatedAt: ts("2024-07-23 13:00:00")}
+	expected := []MailboxUser{
+		{Mailbox: mb1, User: User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")}},
+		{Mailbox: mb1, User: User{ID: 102, MailboxID: 1, UserName: "user2", EmailAddress: "user2@example.com", CreatedAt: ts("2024-07-23 12:45:00")}},
+		{Mailbox: mb2, User: User{ID: 201, MailboxID: 2, UserName: "user3", EmailAddress: "user3@example.com", CreatedAt: ts("2024-07-23 13:15:00")}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrLeaseLost is returned when renewing a lease that was taken over or
+// released.
+var ErrLeaseLost = errors.New("lease lost")
+
+const leaseColumns = "name, holder, CAST(acquired_at AS TEXT), CAST(expires_at AS TEXT)"
+
+// AcquireLease inserts the lease if no instance has taken it yet, and
+// otherwise takes it over only if holder already has it or it is stale. Each
+// is a single statement, so two instances racing for the lease cannot both
+// get it.
+func (s *DBStore) AcquireLease(ctx context.Context, name, holder string, ttl, takeover time.Duration) (Lease, bool, error) {
+	at := now()
+	lease := Lease{Name: name, Holder: holder, AcquiredAt: at, ExpiresAt: at.Add(ttl)}
+
+	query := "INSERT INTO run_leases (name, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?) ON CONFLICT (name) DO NOTHING"
+	res, err := s.db.ExecContext(ctx, s.rebind(query), name, holder, FormatTimestamp(lease.AcquiredAt), FormatTimestamp(lease.ExpiresAt))
+	if err != nil {
+		slog.Error("Error acquiring lease", "lease", name, "error", err)
+		return Lease{}, false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return lease, true, nil
+	}
+
+	query = "UPDATE run_leases SET holder = ?, acquired_at = ?, expires_at = ? WHERE name = ? AND (holder = ?"
+	args := []any{holder, FormatTimestamp(lease.AcquiredAt), FormatTimestamp(lease.ExpiresAt), name, holder}
+	if takeover >= 0 {
+		query += " OR expires_at < ?"
+		args = append(args, FormatTimestamp(at.Add(-takeover)))
+	}
+	res, err = s.db.ExecContext(ctx, s.rebind(query+")"), args...)
+	if err != nil {
+		slog.Error("Error taking over lease", "lease", name, "error", err)
+		return Lease{}, false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return lease, true, nil
+	}
+
+	current, err := s.lease(ctx, name)
+	return current, false, err
+}
+
+// RenewLease moves the expiry of holder's lease.
+func (s *DBStore) RenewLease(ctx context.Context, name, holder string, ttl time.Duration) (Lease, error) {
+	query := "UPDATE run_leases SET expires_at = ? WHERE name = ? AND holder = ?"
+	res, err := s.db.ExecContext(ctx, s.rebind(query), FormatTimestamp(now().Add(ttl)), name, holder)
+	if err != nil {
+		slog.Error("Error renewing lease", "lease", name, "error", err)
+		return Lease{}, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return Lease{}, fmt.Errorf("%w: %s is no longer held by %s", ErrLeaseLost, name, holder)
+	}
+	return s.lease(ctx, name)
+}
+
+// ReleaseLease deletes the lease, if holder holds it.
+func (s *DBStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	query := "DELETE FROM run_leases WHERE name = ?"
+	args := []any{name}
+	if holder != "" {
+		query += " AND holder = ?"
+		args = append(args, holder)
+	}
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), args...); err != nil {
+		slog.Error("Error releasing lease", "lease", name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Leases returns every lease in the run_leases table.
+func (s *DBStore) Leases(ctx context.Context) ([]Lease, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+leaseColumns+" FROM run_leases ORDER BY name")
+	if err != nil {
+		slog.Error("Error querying leases", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leases []Lease
+	for rows.Next() {
+		var l Lease
+		if err := rows.Scan(&l.Name, &l.Holder, scanTime(&l.AcquiredAt), scanTime(&l.ExpiresAt)); err != nil {
+			slog.Error("Error scanning lease row", "error", err)
+			return nil, err
+		}
+		leases = append(leases, l)
+	}
+	return leases, rows.Err()
+}
+
+// lease reads the lease name. A lease released in the meantime reads as the
+// zero Lease with its name.
+func (s *DBStore) lease(ctx context.Context, name string) (Lease, error) {
+	l := Lease{Name: name}
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT "+leaseColumns+" FROM run_leases WHERE name = ?"), name).
+		Scan(&l.Name, &l.Holder, scanTime(&l.AcquiredAt), scanTime(&l.ExpiresAt))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("Error querying lease", "lease", name, "error", err)
+		return Lease{}, err
+	}
+	return l, nil
+}
+
+// TryAdvisoryLock takes a PostgreSQL session-level advisory lock keyed by
+// the hash of name, on a connection of its own that is kept until unlock.
+// If the process dies, the database frees the lock with the connection.
+func (s *DBStore) TryAdvisoryLock(ctx context.Context, name string) (func() error, bool, error) {
+	if !s.isPostgres() {
+		return nil, false, fmt.Errorf("advisory locks need PostgreSQL, not %s", s.driver)
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		slog.Error("Error opening connection for advisory lock", "lock", name, "error", err)
+		return nil, false, err
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&ok); err != nil {
+		conn.Close()
+		slog.Error("Error taking advisory lock", "lock", name, "error", err)
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock := func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name)
+		return err
+	}
+	return unlock, true, nil
+}
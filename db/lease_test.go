@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_AcquireLease(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	insert := regexp.QuoteMeta("INSERT INTO run_leases (name, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?) ON CONFLICT (name) DO NOTHING")
+	update := regexp.QuoteMeta("UPDATE run_leases SET holder = ?, acquired_at = ?, expires_at = ? WHERE name = ? AND (holder = ? OR expires_at < ?)")
+
+	// Free.
+	mock.ExpectExec(insert).WithArgs("pipeline", "host-a:1", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Held by another instance, but stale.
+	mock.ExpectExec(insert).WithArgs("pipeline", "host-b:2", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(update).WithArgs("host-b:2", sqlmock.AnyArg(), sqlmock.AnyArg(), "pipeline", "host-b:2", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Held by another instance, without takeover.
+	mock.ExpectExec(insert).WithArgs("pipeline", "host-a:1", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE run_leases SET holder = ?, acquired_at = ?, expires_at = ? WHERE name = ? AND (holder = ?)")).
+		WithArgs("host-a:1", sqlmock.AnyArg(), sqlmock.AnyArg(), "pipeline", "host-a:1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, holder, CAST(acquired_at AS TEXT), CAST(expires_at AS TEXT) FROM run_leases WHERE name = ?")).
+		WithArgs("pipeline").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "holder", "acquired_at", "expires_at"}).
+			AddRow("pipeline", "host-b:2", "2024-07-23 12:00:00", "2024-07-23 12:01:00"))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ctx := context.Background()
+
+	lease, ok, err := store.AcquireLease(ctx, "pipeline", "host-a:1", time.Minute, 0)
+	if err != nil || !ok {
+		t.Fatalf("Expected to acquire a free lease, got %v, %v", ok, err)
+	}
+	if lease.Holder != "host-a:1" || lease.ExpiresAt.Sub(lease.AcquiredAt) != time.Minute {
+		t.Errorf("Unexpected lease %+v", lease)
+	}
+
+	if _, ok, err := store.AcquireLease(ctx, "pipeline", "host-b:2", time.Minute, 30*time.Second); err != nil || !ok {
+		t.Errorf("Expected to take over a stale lease, got %v, %v", ok, err)
+	}
+
+	lease, ok, err = store.AcquireLease(ctx, "pipeline", "host-a:1", time.Minute, -1)
+	if err != nil || ok {
+		t.Fatalf("Expected the lease to be held elsewhere, got %v, %v", ok, err)
+	}
+	expected := Lease{Name: "pipeline", Holder: "host-b:2", AcquiredAt: ts("2024-07-23 12:00:00"), ExpiresAt: ts("2024-07-23 12:01:00")}
+	if lease != expected {
+		t.Errorf("Expected %+v, got %+v", expected, lease)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_RenewLease(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	renew := regexp.QuoteMeta("UPDATE run_leases SET expires_at = ? WHERE name = ? AND holder = ?")
+	mock.ExpectExec(renew).WithArgs(sqlmock.AnyArg(), "pipeline", "host-a:1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, holder, CAST(acquired_at AS TEXT), CAST(expires_at AS TEXT) FROM run_leases WHERE name = ?")).
+		WithArgs("pipeline").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "holder", "acquired_at", "expires_at"}).
+			AddRow("pipeline", "host-a:1", "2024-07-23 12:00:00", "2024-07-23 12:02:00"))
+	mock.ExpectExec(renew).WithArgs(sqlmock.AnyArg(), "pipeline", "host-a:1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM run_leases WHERE name = ? AND holder = ?")).
+		WithArgs("pipeline", "host-a:1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM run_leases WHERE name = ?")).
+		WithArgs("pipeline").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ctx := context.Background()
+
+	lease, err := store.RenewLease(ctx, "pipeline", "host-a:1", time.Minute)
+	if err != nil {
+		t.Fatalf("Error renewing lease: %v", err)
+	}
+	if !lease.ExpiresAt.Equal(ts("2024-07-23 12:02:00")) {
+		t.Errorf("Unexpected lease %+v", lease)
+	}
+	if _, err := store.RenewLease(ctx, "pipeline", "host-a:1", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, got %v", err)
+	}
+
+	if err := store.ReleaseLease(ctx, "pipeline", "host-a:1"); err != nil {
+		t.Errorf("Error releasing lease: %v", err)
+	}
+	if err := store.ReleaseLease(ctx, "pipeline", ""); err != nil {
+		t.Errorf("Error forcing lease release: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// RecordProcessed adds p to the processed_users ledger. Recording a user
+// twice is not an error.
+func (s *DBStore) RecordProcessed(ctx context.Context, p ProcessedUser) error {
+	query := "INSERT INTO processed_users (mailbox_id, user_id) VALUES (?, ?) ON CONFLICT (mailbox_id, user_id) DO NOTHING"
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), p.MailboxID, p.UserID); err != nil {
+		slog.Error("Error recording user as processed", "user_id", p.UserID, "mailbox_id", p.MailboxID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// WasProcessed reports whether p is in the processed_users ledger.
+func (s *DBStore) WasProcessed(ctx context.Context, p ProcessedUser) (bool, error) {
+	query := "SELECT 1 FROM processed_users WHERE mailbox_id = ? AND user_id = ?"
+
+	var one int
+	err := s.db.QueryRowContext(ctx, s.rebind(query), p.MailboxID, p.UserID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		slog.Error("Error looking up user in the ledger", "user_id", p.UserID, "mailbox_id", p.MailboxID, "error", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// ProcessedUsers streams every entry of the processed_users ledger.
+func (s *DBStore) ProcessedUsers(ctx context.Context) (<-chan ProcessedUser, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT mailbox_id, user_id FROM processed_users")
+	if err != nil {
+		slog.Error("Error querying processed users", "error", err)
+		return nil, err
+	}
+
+	ch := make(chan ProcessedUser)
+	go func() {
+		defer close(ch)
+		defer rows.Close()
+
+		for rows.Next() {
+			var p ProcessedUser
+			if err := rows.Scan(&p.MailboxID, &p.UserID); err != nil {
+				slog.Error("Error scanning processed user row", "error", err)
+				continue
+			}
+			select {
+			case ch <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			slog.Error("Error iterating over processed user rows", "error", err)
+		}
+	}()
+
+	return ch, nil
+}
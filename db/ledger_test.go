@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_RecordProcessed(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO processed_users (mailbox_id, user_id) VALUES ($1, $2) ON CONFLICT (mailbox_id, user_id) DO NOTHING")).
+		WithArgs(1, 101).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store := &DBStore{db: db, driver: "pgx"}
+	if err := store.RecordProcessed(context.Background(), ProcessedUser{MailboxID: 1, UserID: 101}); err != nil {
+		t.Errorf("Error recording processed user: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_WasProcessed(t *testing.T) {
+	query := regexp.QuoteMeta("SELECT 1 FROM processed_users WHERE mailbox_id = ? AND user_id = ?")
+
+	tests := []struct {
+		name        string
+		mockSetup   func(mock sqlmock.Sqlmock)
+		expected    bool
+		expectedErr error
+	}{
+		{
+			name: "Processed",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WithArgs(1, 101).WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+			},
+			expected: true,
+		},
+		{
+			name: "Not processed",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WithArgs(1, 101).WillReturnRows(sqlmock.NewRows([]string{"1"}))
+			},
+		},
+		{
+			name: "Error",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WithArgs(1, 101).WillReturnError(sql.ErrConnDone)
+			},
+			expectedErr: sql.ErrConnDone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+			processed, err := store.WasProcessed(context.Background(), ProcessedUser{MailboxID: 1, UserID: 101})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if processed != tt.expected {
+				t.Errorf("Expected processed %v, got %v", tt.expected, processed)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_ProcessedUsers(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, user_id FROM processed_users")).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "user_id"}).AddRow(1, 101).AddRow(2, 201))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ch, err := store.ProcessedUsers(context.Background())
+	if err != nil {
+		t.Fatalf("Error streaming processed users: %v", err)
+	}
+
+	var got []ProcessedUser
+	for p := range ch {
+		got = append(got, p)
+	}
+	expected := []ProcessedUser{{MailboxID: 1, UserID: 101}, {MailboxID: 2, UserID: 201}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
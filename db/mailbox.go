@@ -0,0 +1,268 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"mailboxes/scope"
+)
+
+var (
+	// ErrMailboxNotFound is returned when a mailbox does not exist.
+	ErrMailboxNotFound = errors.New("mailbox not found")
+	// ErrMailboxNotEmpty is returned when deleting a mailbox that still has
+	// users.
+	ErrMailboxNotEmpty = errors.New("mailbox has users")
+)
+
+// execQueryer is satisfied by both *sql.DB and *sql.Tx.
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// returningDrivers support INSERT ... RETURNING.
+var returningDrivers = map[string]bool{"sqlite3": true, "sqlite": true, "pgx": true, "postgres": true}
+
+// insertID runs an INSERT and returns the new row's id, using RETURNING where
+// the driver supports it and LastInsertId otherwise.
+func (s *DBStore) insertID(ctx context.Context, q execQueryer, query string, args ...any) (int, error) {
+	if returningDrivers[s.driver] {
+		var id int
+		err := q.QueryRowContext(ctx, s.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+
+	res, err := q.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func (s *DBStore) GetMailboxByID(ctx context.Context, id int) (Mailbox, error) {
+	cond, args := tenantScope(ctx, "id")
+	query := "SELECT " + mailboxColumns + " FROM mailboxes WHERE id = ?" + cond
+
+	var mb Mailbox
+	err := s.db.QueryRowContext(ctx, s.rebind(query), append([]any{id}, args...)...).Scan(&mb.ID, &mb.MPIID, s.scanToken(&mb.Token), scanTime(&mb.CreatedAt), scanTime(&mb.UpdatedAt))
+	if err == sql.ErrNoRows {
+		return Mailbox{}, fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+	}
+	if err != nil {
+		slog.Error("Error querying mailbox", "mailbox_id", id, "error", err)
+		return Mailbox{}, err
+	}
+
+	return mb, nil
+}
+
+// CreateMailbox inserts mb and returns it with its new ID. A zero CreatedAt
+// is set to the current time; UpdatedAt always is. With the mailbox_events
+// log on, a created event is logged, and with the audit_log on, the
+// creation is recorded. In a context scoped to a tenant the
+// mailbox is recorded as the tenant's, failing with a *QuotaError if the
+// tenant already has its maximum of mailboxes.
+func (s *DBStore) CreateMailbox(ctx context.Context, mb Mailbox) (Mailbox, error) {
+	tenant := scope.Tenant(ctx)
+	mb.UpdatedAt = now()
+	if mb.CreatedAt.IsZero() {
+		mb.CreatedAt = mb.UpdatedAt
+	}
+
+	sealed, err := s.tokens.Seal(mb.Token)
+	if err != nil {
+		slog.Error("Error sealing token for mailbox", "mpi_id", mb.MPIID, "error", err)
+		return Mailbox{}, err
+	}
+
+	query := "INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, ?, ?, ?)"
+	err = s.inTxIf(ctx, s.mailboxEvents || s.auditLog || tenant != "", func(q execQueryer) error {
+		if tenant != "" {
+			if err := s.checkMailboxQuota(ctx, q, tenant); err != nil {
+				return err
+			}
+		}
+		id, err := s.insertID(ctx, q, query, mb.MPIID, sealed, FormatTimestamp(mb.CreatedAt), FormatTimestamp(mb.UpdatedAt))
+		if err != nil {
+			slog.Error("Error creating mailbox", "mpi_id", mb.MPIID, "error", err)
+			return err
+		}
+		mb.ID = id
+		if tenant != "" {
+			if err := s.assignTenant(ctx, q, mb.ID, tenant); err != nil {
+				return err
+			}
+		}
+		if err := s.logAudit(ctx, q, ChangeCreate, AuditMailbox, mb.ID, nil, &mb); err != nil {
+			return err
+		}
+		return s.logMailboxEvent(ctx, q, mb.ID, MailboxCreated, map[string]string{"mpi_id": mb.MPIID})
+	})
+	if err != nil {
+		return Mailbox{}, err
+	}
+
+	return mb, nil
+}
+
+// UpdateMailbox overwrites the MPI ID and token of the mailbox with mb.ID, or
+// only the fields given, and sets its updated_at. With the mailbox_events
+// log on, a changed token is logged as token_rotated, and with the
+// audit_log on, the update is recorded.
+func (s *DBStore) UpdateMailbox(ctx context.Context, mb Mailbox, fields ...string) error {
+	mask, err := fieldMask(fields, FieldMPIID, FieldToken)
+	if err != nil {
+		return err
+	}
+	var sets []string
+	var args []any
+	if mask[FieldMPIID] {
+		sets, args = append(sets, "mpi_id = ?"), append(args, mb.MPIID)
+	}
+	if mask[FieldToken] {
+		sealed, err := s.tokens.Seal(mb.Token)
+		if err != nil {
+			slog.Error("Error sealing token for mailbox", "mailbox_id", mb.ID, "error", err)
+			return err
+		}
+		sets, args = append(sets, "token = ?"), append(args, sealed)
+	}
+	updatedAt := now()
+	sets, args = append(sets, "updated_at = ?"), append(args, FormatTimestamp(updatedAt), mb.ID)
+
+	cond, scopeArgs := tenantScope(ctx, "id")
+	query := "UPDATE mailboxes SET " + strings.Join(sets, ", ") + " WHERE id = ?" + cond
+	return s.inEventTx(ctx, func(q execQueryer) error {
+		var before Mailbox
+		if s.auditLog {
+			if before, err = s.lockedMailbox(ctx, q, mb.ID); err != nil {
+				return err
+			}
+		} else if s.mailboxEvents && mask[FieldToken] {
+			err := q.QueryRowContext(ctx, s.rebind("SELECT token FROM mailboxes WHERE id = ?"), mb.ID).Scan(s.scanToken(&before.Token))
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrMailboxNotFound, mb.ID)
+			}
+			if err != nil {
+				slog.Error("Error reading token of mailbox", "mailbox_id", mb.ID, "error", err)
+				return err
+			}
+		}
+
+		res, err := q.ExecContext(ctx, s.rebind(query), append(args, scopeArgs...)...)
+		if err != nil {
+			slog.Error("Error updating mailbox", "mailbox_id", mb.ID, "error", err)
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("%w: %d", ErrMailboxNotFound, mb.ID)
+		}
+
+		after := before
+		if mask[FieldMPIID] {
+			after.MPIID = mb.MPIID
+		}
+		if mask[FieldToken] {
+			after.Token = mb.Token
+		}
+		after.UpdatedAt = updatedAt
+		if err := s.logAudit(ctx, q, ChangeUpdate, AuditMailbox, mb.ID, &before, &after); err != nil {
+			return err
+		}
+
+		if !mask[FieldToken] || before.Token == mb.Token {
+			return nil
+		}
+		return s.logMailboxEvent(ctx, q, mb.ID, MailboxTokenRotated, nil)
+	})
+}
+
+// DeleteMailbox deletes a mailbox, its settings and its tenant. Mailboxes
+// that still have users are left alone; move or delete the users first.
+// With the audit_log on, the deletion is recorded.
+func (s *DBStore) DeleteMailbox(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting delete transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if tenant := scope.Tenant(ctx); tenant != "" {
+		owner, err := s.mailboxTenant(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if owner != tenant {
+			return fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+		}
+	}
+
+	var before Mailbox
+	if s.auditLog {
+		if before, err = s.lockedMailbox(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	var users int
+	if err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM users WHERE mailbox_id = ?"), id).Scan(&users); err != nil {
+		slog.Error("Error counting users for mailbox", "mailbox_id", id, "error", err)
+		return err
+	}
+	if users > 0 {
+		return fmt.Errorf("%w: mailbox %d has %d users", ErrMailboxNotEmpty, id, users)
+	}
+
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM mailbox_settings WHERE mailbox_id = ?"), id); err != nil {
+		slog.Error("Error deleting settings for mailbox", "mailbox_id", id, "error", err)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM mailbox_tenants WHERE mailbox_id = ?"), id); err != nil {
+		slog.Error("Error deleting tenant of mailbox", "mailbox_id", id, "error", err)
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, s.rebind("DELETE FROM mailboxes WHERE id = ?"), id)
+	if err != nil {
+		slog.Error("Error deleting mailbox", "mailbox_id", id, "error", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+	}
+	if err := s.logAudit(ctx, tx, ChangeDelete, AuditMailbox, id, &before, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing delete of mailbox", "mailbox_id", id, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// lockedMailbox reads mailbox id through q, before it is changed.
+func (s *DBStore) lockedMailbox(ctx context.Context, q execQueryer, id int) (Mailbox, error) {
+	cond, args := tenantScope(ctx, "id")
+	query := "SELECT " + mailboxColumns + " FROM mailboxes WHERE id = ?" + cond
+
+	var mb Mailbox
+	err := q.QueryRowContext(ctx, s.rebind(query), append([]any{id}, args...)...).Scan(&mb.ID, &mb.MPIID, s.scanToken(&mb.Token), scanTime(&mb.CreatedAt), scanTime(&mb.UpdatedAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Mailbox{}, fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+	}
+	if err != nil {
+		slog.Error("Error reading mailbox", "mailbox_id", id, "error", err)
+		return Mailbox{}, err
+	}
+	return mb, nil
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_GetMailboxByID(t *testing.T) {
+	query := regexp.QuoteMeta("SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes WHERE id = ?")
+
+	tests := []struct {
+		name            string
+		mockSetup       func(mock sqlmock.Sqlmock)
+		expectedMailbox Mailbox
+		expectedErr     error
+	}{
+		{
+			name: "Found",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}).
+						AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00", "2024-07-24 09:00:00+00"))
+			},
+			expectedMailbox: Mailbox{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: ts("2024-07-23 12:00:00"), UpdatedAt: ts("2024-07-24 09:00:00")},
+		},
+		{
+			name: "Not found",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}))
+			},
+			expectedErr: ErrMailboxNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db}
+
+			mb, err := store.GetMailboxByID(context.Background(), 1)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if !reflect.DeepEqual(mb, tt.expectedMailbox) {
+				t.Errorf("Expected mailbox %v, got %v", tt.expectedMailbox, mb)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_CreateMailbox(t *testing.T) {
+	tests := []struct {
+		name      string
+		driver    string
+		mockSetup func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "RETURNING",
+			driver: "sqlite3",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id")).
+					WithArgs("mpi789", "token789", "2024-07-23 14:00:00", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+			},
+		},
+		{
+			name:   "RETURNING with PostgreSQL placeholders",
+			driver: "pgx",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id")).
+					WithArgs("mpi789", "token789", "2024-07-23 14:00:00", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+			},
+		},
+		{
+			name:   "Last insert ID",
+			driver: "mysql",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, ?, ?, ?)")).
+					WithArgs("mpi789", "token789", "2024-07-23 14:00:00", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(3, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: tt.driver}
+
+			mb, err := store.CreateMailbox(context.Background(), Mailbox{MPIID: "mpi789", Token: "token789", CreatedAt: ts("2024-07-23 14:00:00")})
+			if err != nil {
+				t.Fatalf("Error calling CreateMailbox: %v", err)
+			}
+			if mb.ID != 3 {
+				t.Errorf("Expected ID 3, got %d", mb.ID)
+			}
+			if !mb.CreatedAt.Equal(ts("2024-07-23 14:00:00")) || mb.UpdatedAt.IsZero() {
+				t.Errorf("Expected the given creation time and a fresh update time, got %v and %v", mb.CreatedAt, mb.UpdatedAt)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_UpdateMailbox(t *testing.T) {
+	tests := []struct {
+		name        string
+		rows        int64
+		expectedErr error
+	}{
+		{name: "Updated", rows: 1},
+		{name: "Not found", rows: 0, expectedErr: ErrMailboxNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE mailboxes SET mpi_id = ?, token = ?, updated_at = ? WHERE id = ?")).
+				WithArgs("mpi123", "newtoken", sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, tt.rows))
+
+			store := &DBStore{db: db}
+
+			err := store.UpdateMailbox(context.Background(), Mailbox{ID: 1, MPIID: "mpi123", Token: "newtoken"})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_UpdateMailbox_Fields(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mailboxes SET token = ?, updated_at = ? WHERE id = ?")).
+		WithArgs("newtoken", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store := &DBStore{db: db}
+
+	if err := store.UpdateMailbox(context.Background(), Mailbox{ID: 1, Token: "newtoken"}, FieldToken); err != nil {
+		t.Fatalf("Error updating mailbox: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_DeleteMailbox(t *testing.T) {
+	countQuery := regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE mailbox_id = ?")
+
+	tests := []struct {
+		name        string
+		mockSetup   func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name: "Deleted",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(countQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_settings WHERE mailbox_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_tenants WHERE mailbox_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailboxes WHERE id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Has users",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(countQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrMailboxNotEmpty,
+		},
+		{
+			name: "Not found",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(countQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_settings WHERE mailbox_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_tenants WHERE mailbox_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailboxes WHERE id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrMailboxNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db}
+
+			if err := store.DeleteMailbox(context.Background(), 3); !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
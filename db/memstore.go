@@ -0,0 +1,673 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemStore is a Store kept in memory, for embedding and for tests that would
+// otherwise need a database or a hand-written fake. Besides Store it
+// implements MailboxStore, UserStore, BulkUserStore, FullScanStore, BatchUserStore,
+// JoinStore, PageStore, WatermarkStore, QueueStore, RetryStore, LedgerStore,
+// AccessStore, RunStore, RunFailureStore, CheckpointStore and SeqStore, following the same
+// ordering and error conventions as DBStore. It is safe for concurrent use.
+type MemStore struct {
+	mu         sync.RWMutex
+	nextID     int
+	mailboxes  map[int]Mailbox
+	users      map[int]User
+	watermarks map[string]Watermark
+	queue      []int
+	retries    map[int]Retry
+	processed  map[ProcessedUser]bool
+	access     map[int]int
+	runs       []Run
+	failures   map[int][]RunFailure
+	checkpoint Checkpoint
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		mailboxes:  make(map[int]Mailbox),
+		users:      make(map[int]User),
+		watermarks: make(map[string]Watermark),
+		retries:    make(map[int]Retry),
+		processed:  make(map[ProcessedUser]bool),
+		access:     make(map[int]int),
+		failures:   make(map[int][]RunFailure),
+		checkpoint: Checkpoint{Mailboxes: make(map[int]bool), Users: make(map[ProcessedUser]bool)},
+	}
+}
+
+// SeedMailboxes adds mailboxes as given, replacing any with the same ID.
+// Mailboxes without an ID are assigned the next free one.
+func (s *MemStore) SeedMailboxes(mailboxes ...Mailbox) []Mailbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seeded := make([]Mailbox, len(mailboxes))
+	for i, mb := range mailboxes {
+		mb.ID = s.claimID(mb.ID)
+		s.mailboxes[mb.ID] = mb
+		seeded[i] = mb
+	}
+	return seeded
+}
+
+// SeedUsers adds users as given, replacing any with the same ID. Unlike
+// CreateUser it does not require their mailboxes to exist. Users without an
+// ID are assigned the next free one.
+func (s *MemStore) SeedUsers(users ...User) []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seeded := make([]User, len(users))
+	for i, user := range users {
+		user.ID = s.claimID(user.ID)
+		s.users[user.ID] = user
+		seeded[i] = user
+	}
+	return seeded
+}
+
+// claimID returns id, or the next free ID if id is zero, and keeps later IDs
+// above it. Mailboxes and users share one sequence, which keeps IDs unique
+// without tracking two.
+func (s *MemStore) claimID(id int) int {
+	if id == 0 {
+		s.nextID++
+		return s.nextID
+	}
+	s.nextID = max(s.nextID, id)
+	return id
+}
+
+// sortedMailboxes returns the mailboxes accepted by keep in ID order. The
+// caller holds s.mu.
+func (s *MemStore) sortedMailboxes(keep func(Mailbox) bool) []Mailbox {
+	var mailboxes []Mailbox
+	for _, mb := range s.mailboxes {
+		if keep == nil || keep(mb) {
+			mailboxes = append(mailboxes, mb)
+		}
+	}
+	sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].ID < mailboxes[j].ID })
+	return mailboxes
+}
+
+// sortedUsers returns the users accepted by keep in ID order. The caller
+// holds s.mu.
+func (s *MemStore) sortedUsers(keep func(User) bool) []User {
+	var users []User
+	for _, user := range s.users {
+		if keep == nil || keep(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// stream returns a closed channel holding items. The channel is buffered so
+// nothing is left blocked if the reader stops early.
+func stream[T any](items []T) <-chan T {
+	ch := make(chan T, len(items))
+	for _, item := range items {
+		ch <- item
+	}
+	close(ch)
+	return ch
+}
+
+// page returns up to limit items after skipping offset.
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (s *MemStore) AllMailboxes(ctx context.Context) (<-chan Mailbox, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return stream(s.sortedMailboxes(nil)), nil
+}
+
+func (s *MemStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return stream(s.sortedUsers(func(u User) bool { return u.MailboxID == mailboxID })), nil
+}
+
+func (s *MemStore) AllUsers(ctx context.Context) (<-chan User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return stream(s.sortedUsers(nil)), nil
+}
+
+// UsersForMailboxes streams the users of mailboxIDs ordered by mailbox, then
+// ID, as DBStore does.
+func (s *MemStore) UsersForMailboxes(ctx context.Context, mailboxIDs []int) (<-chan User, error) {
+	want := make(map[int]bool, len(mailboxIDs))
+	for _, id := range mailboxIDs {
+		want[id] = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := s.sortedUsers(func(u User) bool { return want[u.MailboxID] })
+	sort.SliceStable(users, func(i, j int) bool { return users[i].MailboxID < users[j].MailboxID })
+	return stream(users), nil
+}
+
+// UsersWithMailboxes streams every user whose mailbox exists, ordered by
+// mailbox, then user ID.
+func (s *MemStore) UsersWithMailboxes(ctx context.Context) (<-chan MailboxUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pairs []MailboxUser
+	for _, mb := range s.sortedMailboxes(nil) {
+		for _, user := range s.sortedUsers(func(u User) bool { return u.MailboxID == mb.ID }) {
+			pairs = append(pairs, MailboxUser{Mailbox: mb, User: user})
+		}
+	}
+	return stream(pairs), nil
+}
+
+func (s *MemStore) AllMailboxesPage(ctx context.Context, limit, offset int) ([]Mailbox, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return page(s.sortedMailboxes(nil), limit, offset), nil
+}
+
+func (s *MemStore) MailboxesAfter(ctx context.Context, afterID, limit int) ([]Mailbox, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return page(s.sortedMailboxes(func(mb Mailbox) bool { return mb.ID > afterID }), limit, 0), nil
+}
+
+func (s *MemStore) UsersForMailboxPage(ctx context.Context, mailboxID, limit, offset int) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return page(s.sortedUsers(func(u User) bool { return u.MailboxID == mailboxID }), limit, offset), nil
+}
+
+func (s *MemStore) UsersForMailboxAfter(ctx context.Context, mailboxID, afterID, limit int) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return page(s.sortedUsers(func(u User) bool { return u.MailboxID == mailboxID && u.ID > afterID }), limit, 0), nil
+}
+
+func (s *MemStore) GetMailboxByID(ctx context.Context, id int) (Mailbox, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mb, ok := s.mailboxes[id]
+	if !ok {
+		return Mailbox{}, fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+	}
+	return mb, nil
+}
+
+func (s *MemStore) CreateMailbox(ctx context.Context, mb Mailbox) (Mailbox, error) {
+	mb.UpdatedAt = now()
+	if mb.CreatedAt.IsZero() {
+		mb.CreatedAt = mb.UpdatedAt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mb.ID = s.claimID(0)
+	s.mailboxes[mb.ID] = mb
+	return mb, nil
+}
+
+func (s *MemStore) UpdateMailbox(ctx context.Context, mb Mailbox, fields ...string) error {
+	mask, err := fieldMask(fields, FieldMPIID, FieldToken)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.mailboxes[mb.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrMailboxNotFound, mb.ID)
+	}
+	if mask[FieldMPIID] {
+		current.MPIID = mb.MPIID
+	}
+	if mask[FieldToken] {
+		current.Token = mb.Token
+	}
+	current.UpdatedAt = now()
+	s.mailboxes[mb.ID] = current
+	return nil
+}
+
+func (s *MemStore) DeleteMailbox(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := 0
+	for _, user := range s.users {
+		if user.MailboxID == id {
+			users++
+		}
+	}
+	if users > 0 {
+		return fmt.Errorf("%w: mailbox %d has %d users", ErrMailboxNotEmpty, id, users)
+	}
+	if _, ok := s.mailboxes[id]; !ok {
+		return fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+	}
+	delete(s.mailboxes, id)
+	delete(s.access, id)
+	return nil
+}
+
+func (s *MemStore) GetUserByID(ctx context.Context, id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, fmt.Errorf("%w: %v", ErrUserNotFound, id)
+	}
+	return user, nil
+}
+
+func (s *MemStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := s.sortedUsers(func(u User) bool { return u.EmailAddress == email })
+	if len(users) == 0 {
+		return User{}, fmt.Errorf("%w: %v", ErrUserNotFound, email)
+	}
+	return users[0], nil
+}
+
+func (s *MemStore) CreateUser(ctx context.Context, user User) (User, error) {
+	user.UpdatedAt = now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = user.UpdatedAt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mailboxes[user.MailboxID]; !ok {
+		return User{}, fmt.Errorf("%w: %d", ErrMailboxNotFound, user.MailboxID)
+	}
+	user.ID = s.claimID(0)
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *MemStore) CreateUsersBatch(ctx context.Context, users []User) ([]User, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	createdAt := now()
+	users = slices.Clone(users)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range users {
+		if _, ok := s.mailboxes[user.MailboxID]; !ok {
+			return nil, fmt.Errorf("%w: %d", ErrMailboxNotFound, user.MailboxID)
+		}
+	}
+	for i := range users {
+		users[i].UpdatedAt = createdAt
+		if users[i].CreatedAt.IsZero() {
+			users[i].CreatedAt = createdAt
+		}
+		users[i].ID = s.claimID(0)
+		s.users[users[i].ID] = users[i]
+	}
+	return users, nil
+}
+
+func (s *MemStore) UpdateUser(ctx context.Context, user User, fields ...string) error {
+	mask, err := fieldMask(fields, FieldUserName, FieldEmailAddress)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[user.ID]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUserNotFound, user.ID)
+	}
+	if mask[FieldUserName] {
+		current.UserName = user.UserName
+	}
+	if mask[FieldEmailAddress] {
+		current.EmailAddress = user.EmailAddress
+	}
+	current.UpdatedAt = now()
+	s.users[user.ID] = current
+	return nil
+}
+
+func (s *MemStore) DeleteUser(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return fmt.Errorf("%w: %v", ErrUserNotFound, id)
+	}
+	delete(s.users, id)
+	delete(s.retries, id)
+	queue := s.queue[:0]
+	for _, queued := range s.queue {
+		if queued != id {
+			queue = append(queue, queued)
+		}
+	}
+	s.queue = queue
+	return nil
+}
+
+// UsersCreatedSince streams the users after wm, ordered by creation time and
+// then ID.
+func (s *MemStore) UsersCreatedSince(ctx context.Context, wm Watermark) (<-chan User, error) {
+	createdAt, err := ParseTimestamp(sqlTimestamp(wm.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := s.sortedUsers(func(u User) bool {
+		return u.CreatedAt.After(createdAt) || (u.CreatedAt.Equal(createdAt) && u.ID > wm.UserID)
+	})
+	sort.SliceStable(users, func(i, j int) bool { return users[i].CreatedAt.Before(users[j].CreatedAt) })
+	return stream(users), nil
+}
+
+func (s *MemStore) Watermark(ctx context.Context, name string) (Watermark, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.watermarks[name], nil
+}
+
+func (s *MemStore) SaveWatermark(ctx context.Context, name string, wm Watermark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watermarks[name] = wm
+	return nil
+}
+
+func (s *MemStore) Watermarks(ctx context.Context) (map[string]Watermark, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.watermarks), nil
+}
+
+func (s *MemStore) EnqueueUser(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, userID)
+	return nil
+}
+
+// ClaimQueuedUsers removes and returns up to limit queued users in the order
+// they were enqueued. Entries for deleted users are dropped.
+func (s *MemStore) ClaimQueuedUsers(ctx context.Context, limit int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []User
+	claimed := 0
+	for _, id := range s.queue {
+		if len(users) == limit {
+			break
+		}
+		claimed++
+		if user, ok := s.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+	s.queue = s.queue[claimed:]
+	return users, nil
+}
+
+// ScheduleRetry schedules r, replacing any retry already scheduled for the
+// same user.
+func (s *MemStore) ScheduleRetry(ctx context.Context, r Retry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries[r.User.ID] = r
+	return nil
+}
+
+// ClaimDueRetries removes and returns up to limit retries due by now,
+// highest priority first, then earliest due.
+func (s *MemStore) ClaimDueRetries(ctx context.Context, now time.Time, limit int) ([]Retry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Retry
+	for _, r := range s.retries {
+		if user, ok := s.users[r.User.ID]; ok && !r.NextAttemptAt.After(now) {
+			r.User = user
+			due = append(due, r)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i], due[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.NextAttemptAt.Equal(b.NextAttemptAt) {
+			return a.NextAttemptAt.Before(b.NextAttemptAt)
+		}
+		return a.User.ID < b.User.ID
+	})
+	due = page(due, limit, 0)
+
+	for _, r := range due {
+		delete(s.retries, r.User.ID)
+	}
+	return due, nil
+}
+
+func (s *MemStore) RecordProcessed(ctx context.Context, p ProcessedUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed[p] = true
+	return nil
+}
+
+func (s *MemStore) WasProcessed(ctx context.Context, p ProcessedUser) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.processed[p], nil
+}
+
+func (s *MemStore) ProcessedUsers(ctx context.Context) (<-chan ProcessedUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]ProcessedUser, 0, len(s.processed))
+	for p := range s.processed {
+		entries = append(entries, p)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].MailboxID != entries[j].MailboxID {
+			return entries[i].MailboxID < entries[j].MailboxID
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	return stream(entries), nil
+}
+
+func (s *MemStore) RecordMailboxAccess(ctx context.Context, hits map[int]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, n := range hits {
+		s.access[id] += n
+	}
+	return nil
+}
+
+func (s *MemStore) HotMailboxes(ctx context.Context, n int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(s.access))
+	for id := range s.access {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if s.access[ids[i]] != s.access[ids[j]] {
+			return s.access[ids[i]] > s.access[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return page(ids, n, 0), nil
+}
+
+func (s *MemStore) RecordRun(ctx context.Context, run Run) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.ID = len(s.runs) + 1
+	s.runs = append(s.runs, run)
+	return run, nil
+}
+
+func (s *MemStore) RecentRuns(ctx context.Context, n int) ([]Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := make([]Run, 0, min(n, len(s.runs)))
+	for i := len(s.runs) - 1; i >= 0 && len(runs) < n; i-- {
+		runs = append(runs, s.runs[i])
+	}
+	return runs, nil
+}
+
+func (s *MemStore) RecordRunFailures(ctx context.Context, runID int, failures []RunFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[ProcessedUser]bool)
+	for _, f := range s.failures[runID] {
+		seen[ProcessedUser{MailboxID: f.MailboxID, UserID: f.UserID}] = true
+	}
+	for _, f := range failures {
+		key := ProcessedUser{MailboxID: f.MailboxID, UserID: f.UserID}
+		if !seen[key] {
+			seen[key] = true
+			s.failures[runID] = append(s.failures[runID], f)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) RunFailures(ctx context.Context, runID int) ([]RunFailure, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	failures := append([]RunFailure(nil), s.failures[runID]...)
+	sort.Slice(failures, func(i, j int) bool {
+		a, b := failures[i], failures[j]
+		return a.MailboxID < b.MailboxID || (a.MailboxID == b.MailboxID && a.UserID < b.UserID)
+	})
+	return failures, nil
+}
+
+func (s *MemStore) CheckpointUser(ctx context.Context, p ProcessedUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint.Users[p] = true
+	return nil
+}
+
+func (s *MemStore) CheckpointMailbox(ctx context.Context, mailboxID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.checkpoint.Users {
+		if p.MailboxID == mailboxID {
+			delete(s.checkpoint.Users, p)
+		}
+	}
+	s.checkpoint.Mailboxes[mailboxID] = true
+	return nil
+}
+
+// LoadCheckpoint returns a copy of the saved progress.
+func (s *MemStore) LoadCheckpoint(ctx context.Context) (Checkpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := Checkpoint{Mailboxes: make(map[int]bool, len(s.checkpoint.Mailboxes)), Users: make(map[ProcessedUser]bool, len(s.checkpoint.Users))}
+	for id := range s.checkpoint.Mailboxes {
+		c.Mailboxes[id] = true
+	}
+	for p := range s.checkpoint.Users {
+		c.Users[p] = true
+	}
+	return c, nil
+}
+
+func (s *MemStore) ClearCheckpoint(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = Checkpoint{Mailboxes: make(map[int]bool), Users: make(map[ProcessedUser]bool)}
+	return nil
+}
+
+func (s *MemStore) ReplaceCheckpoint(ctx context.Context, c Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = Checkpoint{Mailboxes: maps.Clone(c.Mailboxes), Users: maps.Clone(c.Users)}
+	if s.checkpoint.Mailboxes == nil {
+		s.checkpoint.Mailboxes = make(map[int]bool)
+	}
+	if s.checkpoint.Users == nil {
+		s.checkpoint.Users = make(map[ProcessedUser]bool)
+	}
+	return nil
+}
+
+// MailboxSeq iterates over a snapshot of the mailboxes in ID order.
+func (s *MemStore) MailboxSeq(ctx context.Context) iter.Seq2[Mailbox, error] {
+	s.mu.RLock()
+	mailboxes := s.sortedMailboxes(nil)
+	s.mu.RUnlock()
+	return seqOf(mailboxes)
+}
+
+// UserSeq iterates over a snapshot of mailboxID's users in ID order.
+func (s *MemStore) UserSeq(ctx context.Context, mailboxID int) iter.Seq2[User, error] {
+	s.mu.RLock()
+	users := s.sortedUsers(func(u User) bool { return u.MailboxID == mailboxID })
+	s.mu.RUnlock()
+	return seqOf(users)
+}
+
+func seqOf[T any](items []T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
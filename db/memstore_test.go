@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemStore_Interfaces(t *testing.T) {
+	var store any = NewMemStore()
+	for name, ok := range map[string]bool{
+		"Store":           isA[Store](store),
+		"MailboxStore":    isA[MailboxStore](store),
+		"UserStore":       isA[UserStore](store),
+		"BulkUserStore":   isA[BulkUserStore](store),
+		"FullScanStore":   isA[FullScanStore](store),
+		"BatchUserStore":  isA[BatchUserStore](store),
+		"JoinStore":       isA[JoinStore](store),
+		"PageStore":       isA[PageStore](store),
+		"WatermarkStore":  isA[WatermarkStore](store),
+		"QueueStore":      isA[QueueStore](store),
+		"RetryStore":      isA[RetryStore](store),
+		"LedgerStore":     isA[LedgerStore](store),
+		"AccessStore":     isA[AccessStore](store),
+		"RunStore":        isA[RunStore](store),
+		"CheckpointStore": isA[CheckpointStore](store),
+		"SeqStore":        isA[SeqStore](store),
+	} {
+		if !ok {
+			t.Errorf("MemStore does not implement %s", name)
+		}
+	}
+}
+
+func isA[T any](v any) bool {
+	_, ok := v.(T)
+	return ok
+}
+
+// drain reads every item from ch, or returns nil if opening it failed.
+func drain[T any](ch <-chan T, err error) []T {
+	if err != nil {
+		return nil
+	}
+	var items []T
+	for item := range ch {
+		items = append(items, item)
+	}
+	return items
+}
+
+func seededMemStore() *MemStore {
+	store := NewMemStore()
+	store.SeedMailboxes(Mailbox{ID: 2, MPIID: "mpi2"}, Mailbox{ID: 1, MPIID: "mpi1"})
+	store.SeedUsers(
+		User{ID: 103, MailboxID: 1, UserName: "c", CreatedAt: ts("2024-07-23 12:00:00")},
+		User{ID: 101, MailboxID: 1, UserName: "a", EmailAddress: "a@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+		User{ID: 102, MailboxID: 2, UserName: "b", EmailAddress: "a@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+		User{ID: 104, MailboxID: 9, UserName: "orphan", CreatedAt: ts("2024-07-23 13:00:00")},
+	)
+	return store
+}
+
+func userIDs(users []User) []int {
+	var ids []int
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func TestMemStore_Reads(t *testing.T) {
+	store := seededMemStore()
+	ctx := context.Background()
+
+	mailboxes := drain(store.AllMailboxes(ctx))
+	if len(mailboxes) != 2 || mailboxes[0].ID != 1 || mailboxes[1].ID != 2 {
+		t.Errorf("Expected mailboxes 1 and 2 in order, got %v", mailboxes)
+	}
+
+	tests := []struct {
+		name     string
+		read     func() ([]User, error)
+		expected []int
+	}{
+		{name: "UsersForMailbox", read: func() ([]User, error) {
+			ch, err := store.UsersForMailbox(ctx, 1)
+			return drain(ch, err), err
+		}, expected: []int{101, 103}},
+		{name: "AllUsers", read: func() ([]User, error) {
+			ch, err := store.AllUsers(ctx)
+			return drain(ch, err), err
+		}, expected: []int{101, 102, 103, 104}},
+		{name: "UsersForMailboxes", read: func() ([]User, error) {
+			ch, err := store.UsersForMailboxes(ctx, []int{2, 1})
+			return drain(ch, err), err
+		}, expected: []int{101, 103, 102}},
+		{name: "UsersCreatedSince", read: func() ([]User, error) {
+			ch, err := store.UsersCreatedSince(ctx, Watermark{CreatedAt: "2024-07-23T12:30:00Z", UserID: 101})
+			return drain(ch, err), err
+		}, expected: []int{102, 104}},
+		{name: "UsersForMailboxPage", read: func() ([]User, error) {
+			return store.UsersForMailboxPage(ctx, 1, 1, 1)
+		}, expected: []int{103}},
+		{name: "UsersForMailboxAfter", read: func() ([]User, error) {
+			return store.UsersForMailboxAfter(ctx, 1, 101, 10)
+		}, expected: []int{103}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := tt.read()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ids := userIDs(users); !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("Expected users %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+
+	pairs := drain(store.UsersWithMailboxes(ctx))
+	var joined []int
+	for _, p := range pairs {
+		joined = append(joined, p.User.ID)
+	}
+	if !reflect.DeepEqual(joined, []int{101, 103, 102}) {
+		t.Errorf("Expected joined users [101 103 102] without the orphan, got %v", joined)
+	}
+
+	user, err := store.GetUserByEmail(ctx, "a@example.com")
+	if err != nil || user.ID != 101 {
+		t.Errorf("Expected user 101 by email, got %v, %v", user, err)
+	}
+}
+
+func TestMemStore_Writes(t *testing.T) {
+	store := seededMemStore()
+	ctx := context.Background()
+
+	mb, err := store.CreateMailbox(ctx, Mailbox{MPIID: "mpi3"})
+	if err != nil {
+		t.Fatalf("Error creating mailbox: %v", err)
+	}
+	if mb.ID <= 104 || mb.CreatedAt.IsZero() || !mb.UpdatedAt.Equal(mb.CreatedAt) {
+		t.Errorf("Expected a fresh ID and creation and update times, got %v", mb)
+	}
+
+	if _, err := store.CreateUser(ctx, User{MailboxID: 42}); !errors.Is(err, ErrMailboxNotFound) {
+		t.Errorf("Expected ErrMailboxNotFound creating a user in a missing mailbox, got %v", err)
+	}
+	user, err := store.CreateUser(ctx, User{MailboxID: mb.ID, UserName: "d"})
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	if err := store.UpdateUser(ctx, User{ID: user.ID, MailboxID: 1, UserName: "e"}); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	updated, _ := store.GetUserByID(ctx, user.ID)
+	if updated.UserName != "e" || updated.MailboxID != mb.ID {
+		t.Errorf("Expected only the name to change, got %v", updated)
+	}
+	if err := store.UpdateUser(ctx, User{ID: user.ID, EmailAddress: "e@example.com"}, FieldEmailAddress); err != nil {
+		t.Fatalf("Error updating email address: %v", err)
+	}
+	updated, _ = store.GetUserByID(ctx, user.ID)
+	if updated.UserName != "e" || updated.EmailAddress != "e@example.com" {
+		t.Errorf("Expected only the email address to change, got %v", updated)
+	}
+	if err := store.UpdateUser(ctx, User{ID: user.ID}, "mailbox_id"); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Expected ErrUnknownField, got %v", err)
+	}
+
+	if err := store.DeleteMailbox(ctx, mb.ID); !errors.Is(err, ErrMailboxNotEmpty) {
+		t.Errorf("Expected ErrMailboxNotEmpty, got %v", err)
+	}
+	if err := store.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("Error deleting user: %v", err)
+	}
+	if _, err := store.GetUserByID(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteMailbox(ctx, mb.ID); err != nil {
+		t.Errorf("Error deleting empty mailbox: %v", err)
+	}
+	if err := store.UpdateMailbox(ctx, mb); !errors.Is(err, ErrMailboxNotFound) {
+		t.Errorf("Expected ErrMailboxNotFound updating a deleted mailbox, got %v", err)
+	}
+}
+
+func TestMemStore_Queues(t *testing.T) {
+	ctx := context.Background()
+	store := seededMemStore()
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range []int{103, 101, 102} {
+		store.EnqueueUser(ctx, id)
+	}
+	store.DeleteUser(context.Background(), 101)
+	claimed, _ := store.ClaimQueuedUsers(ctx, 2)
+	if ids := userIDs(claimed); !reflect.DeepEqual(ids, []int{103, 102}) {
+		t.Errorf("Expected queued users [103 102], got %v", ids)
+	}
+	if claimed, _ := store.ClaimQueuedUsers(ctx, 2); len(claimed) != 0 {
+		t.Errorf("Expected an empty queue, got %v", claimed)
+	}
+
+	store.ScheduleRetry(ctx, Retry{User: User{ID: 102}, NextAttemptAt: now.Add(-time.Minute)})
+	store.ScheduleRetry(ctx, Retry{User: User{ID: 103}, Priority: 1, NextAttemptAt: now})
+	store.ScheduleRetry(ctx, Retry{User: User{ID: 104}, NextAttemptAt: now.Add(time.Minute)})
+	due, _ := store.ClaimDueRetries(ctx, now, 10)
+	var dueIDs []int
+	for _, r := range due {
+		dueIDs = append(dueIDs, r.User.ID)
+	}
+	if !reflect.DeepEqual(dueIDs, []int{103, 102}) {
+		t.Errorf("Expected due retries [103 102], got %v", dueIDs)
+	}
+	if due[0].User.UserName != "c" {
+		t.Errorf("Expected the retry to carry the stored user, got %v", due[0].User)
+	}
+	if due, _ := store.ClaimDueRetries(ctx, now.Add(time.Hour), 10); len(due) != 1 || due[0].User.ID != 104 {
+		t.Errorf("Expected only the later retry to remain, got %v", due)
+	}
+}
+
+func TestMemStore_LedgerAndAccess(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+
+	store.RecordProcessed(ctx, ProcessedUser{MailboxID: 2, UserID: 201})
+	store.RecordProcessed(ctx, ProcessedUser{MailboxID: 1, UserID: 101})
+	if ok, _ := store.WasProcessed(ctx, ProcessedUser{MailboxID: 1, UserID: 101}); !ok {
+		t.Error("Expected user 101 to be processed")
+	}
+	entries := drain(store.ProcessedUsers(ctx))
+	if !reflect.DeepEqual(entries, []ProcessedUser{{1, 101}, {2, 201}}) {
+		t.Errorf("Expected ledger in order, got %v", entries)
+	}
+
+	store.RecordMailboxAccess(ctx, map[int]int{1: 2, 2: 5, 3: 2})
+	store.RecordMailboxAccess(ctx, map[int]int{1: 1})
+	if hot, _ := store.HotMailboxes(ctx, 2); !reflect.DeepEqual(hot, []int{2, 1}) {
+		t.Errorf("Expected hot mailboxes [2 1], got %v", hot)
+	}
+}
+
+func TestMemStore_Runs(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+
+	for _, status := range []string{RunSucceeded, RunFailed, RunSucceeded} {
+		store.RecordRun(ctx, Run{Status: status, Annotations: map[string]string{"campaign_id": "spring"}})
+	}
+	runs, _ := store.RecentRuns(ctx, 2)
+	if len(runs) != 2 || runs[0].ID != 3 || runs[1].ID != 2 || runs[1].Status != RunFailed {
+		t.Errorf("Expected runs 3 and 2, newest first, got %+v", runs)
+	}
+
+	store.RecordRunFailures(ctx, 2, []RunFailure{{MailboxID: 2, Error: "gone"}, {MailboxID: 1, UserID: 102, Error: "boom"}})
+	store.RecordRunFailures(ctx, 2, []RunFailure{{MailboxID: 1, UserID: 102, Error: "again"}})
+	failures, _ := store.RunFailures(ctx, 2)
+	expected := []RunFailure{{MailboxID: 1, UserID: 102, Error: "boom"}, {MailboxID: 2, Error: "gone"}}
+	if !reflect.DeepEqual(failures, expected) {
+		t.Errorf("Expected failures %+v, got %+v", expected, failures)
+	}
+	if failures, _ := store.RunFailures(ctx, 3); len(failures) != 0 {
+		t.Errorf("Expected no failures for run 3, got %+v", failures)
+	}
+}
+
+func TestMemStore_Checkpoint(t *testing.T) {
+	store := NewMemStore()
+	ctx := context.Background()
+
+	store.CheckpointUser(ctx, ProcessedUser{MailboxID: 1, UserID: 101})
+	store.CheckpointUser(ctx, ProcessedUser{MailboxID: 2, UserID: 201})
+	store.CheckpointMailbox(ctx, 1)
+	c, _ := store.LoadCheckpoint(ctx)
+	if !c.MailboxDone(1) || c.UserDone(ProcessedUser{MailboxID: 1, UserID: 101}) || !c.UserDone(ProcessedUser{MailboxID: 2, UserID: 201}) {
+		t.Errorf("Expected mailbox 1 and user 201 done, got %+v", c)
+	}
+
+	store.ClearCheckpoint(ctx)
+	if c, _ := store.LoadCheckpoint(ctx); len(c.Mailboxes) != 0 || len(c.Users) != 0 {
+		t.Errorf("Expected an empty checkpoint after clearing, got %+v", c)
+	}
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// MergeUsers merges the users in from into the user into, in one transaction.
+// Each merged row is copied to user_merges before it is deleted, and queued
+// work for it is moved to into; pending retries are dropped. Deletions are
+// recorded in user_changes.
+func (s *DBStore) MergeUsers(ctx context.Context, into int, from []int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting merge transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, s.rebind("SELECT id FROM users WHERE id = ?"), into).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, into)
+	}
+	if err != nil {
+		slog.Error("Error looking up user", "user_id", into, "error", err)
+		return err
+	}
+
+	for _, userID := range from {
+		if userID == into {
+			return fmt.Errorf("cannot merge user %d into itself", userID)
+		}
+
+		before, err := s.lockedUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		key, keyArgs := s.userKey(before)
+		query := "INSERT INTO user_merges (user_id, merged_into, mailbox_id, user_name, email_address, created_at) " +
+			"SELECT id, ?, mailbox_id, user_name, email_address, created_at FROM users WHERE " + key
+		if _, err := tx.ExecContext(ctx, s.rebind(query), append([]any{into}, keyArgs...)...); err != nil {
+			slog.Error("Error recording merge of user", "user_id", userID, "error", err)
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, s.rebind("UPDATE work_queue SET user_id = ? WHERE user_id = ?"), into, userID); err != nil {
+			slog.Error("Error moving queued work for user", "user_id", userID, "error", err)
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM retry_queue WHERE user_id = ?"), userID); err != nil {
+			slog.Error("Error dropping retry of merged user", "user_id", userID, "error", err)
+			return err
+		}
+		if s.provenance {
+			if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM user_provenance WHERE user_id = ?"), userID); err != nil {
+				slog.Error("Error dropping provenance of merged user", "user_id", userID, "error", err)
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM users WHERE "+key), keyArgs...); err != nil {
+			slog.Error("Error deleting merged user", "user_id", userID, "error", err)
+			return err
+		}
+
+		if err := s.recordChanges(ctx, tx, diffUser(&before, nil)); err != nil {
+			return err
+		}
+		if err := s.logAudit(ctx, tx, ChangeDelete, AuditUser, userID, &before, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing merge", "into_user_id", into, "error", err)
+		return err
+	}
+
+	return nil
+}
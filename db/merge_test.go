@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_MergeUsers(t *testing.T) {
+	ctx := context.Background()
+	recordQuery := regexp.QuoteMeta("INSERT INTO user_merges (user_id, merged_into, mailbox_id, user_name, email_address, created_at) " +
+		"SELECT id, ?, mailbox_id, user_name, email_address, created_at FROM users WHERE id = ?")
+	userRows := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+
+	tests := []struct {
+		name        string
+		from        []int
+		mockSetup   func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name: "Merges duplicates",
+			from: []int{102},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE id = ?")).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(101))
+				mock.ExpectQuery(lockedUserQuery).WithArgs(102).
+					WillReturnRows(sqlmock.NewRows(userRows).AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00", nil))
+				mock.ExpectExec(recordQuery).WithArgs(101, 102).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE work_queue SET user_id = ? WHERE user_id = ?")).
+					WithArgs(101, 102).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM retry_queue WHERE user_id = ?")).
+					WithArgs(102).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = ?")).
+					WithArgs(102).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectChanges(mock,
+					UserChange{UserID: 102, Op: ChangeDelete, Field: "mailbox_id", Old: "1"},
+					UserChange{UserID: 102, Op: ChangeDelete, Field: "user_name", Old: "user2"},
+					UserChange{UserID: 102, Op: ChangeDelete, Field: "email_address", Old: "user2@example.com"})
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Missing duplicate",
+			from: []int{999},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE id = ?")).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(101))
+				mock.ExpectQuery(lockedUserQuery).WithArgs(999).WillReturnRows(sqlmock.NewRows(userRows))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrUserNotFound,
+		},
+		{
+			name: "Missing target",
+			from: []int{102},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE id = ?")).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db}
+
+			if err := store.MergeUsers(ctx, 101, tt.from); !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
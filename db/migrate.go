@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations for each dialect, named
+// NNNN_name.up.sql and NNNN_name.down.sql.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationDialects maps drivers to the directory of migrations written for
+// them.
+var migrationDialects = map[string]string{"sqlite3": "sqlite3", "sqlite": "sqlite3", "pgx": "postgres", "postgres": "postgres"}
+
+// loadMigrations returns the migrations for driver in version order.
+func loadMigrations(driver string) ([]Migration, error) {
+	dialect, ok := migrationDialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for driver %s", driver)
+	}
+	dir := path.Join("migrations", dialect)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		version, name, ok2 := strings.Cut(base, "_")
+		n, err := strconv.Atoi(version)
+		if !ok || !ok2 || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("unexpected migration file %s", e.Name())
+		}
+
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[n]
+		if m == nil {
+			m = &Migration{Version: n, Name: name}
+			byVersion[n] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+const createMigrationsTable = "CREATE TABLE IF NOT EXISTS schema_migrations (" +
+	"version INTEGER PRIMARY KEY, name VARCHAR(200), applied_at TIMESTAMP)"
+
+// appliedMigrations returns when each applied migration was applied, by
+// version, creating the schema_migrations table if needed.
+func (s *DBStore) appliedMigrations(ctx context.Context) (map[int]string, error) {
+	if _, err := s.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		slog.Error("Error creating schema_migrations", "error", err)
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT version, CAST(applied_at AS TEXT) FROM schema_migrations")
+	if err != nil {
+		slog.Error("Error querying schema_migrations", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			slog.Error("Error scanning schema_migrations row", "error", err)
+			return nil, err
+		}
+		applied[version] = sqlTimestamp(at)
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every migration and when it was applied.
+func (s *DBStore) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{Migration: m, AppliedAt: applied[m.Version]}
+	}
+	return status, nil
+}
+
+// MigrateUp applies every pending migration up to and including version
+// to, or all of them if to is zero, each in its own transaction. It returns
+// the migrations applied.
+func (s *DBStore) MigrateUp(ctx context.Context, to int) ([]Migration, error) {
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range status {
+		if m.AppliedAt != "" || (to > 0 && m.Version > to) {
+			continue
+		}
+		query := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+		now := time.Now().UTC().Format(TimestampLayout)
+		if err := s.migrate(ctx, m.Migration, m.Up, query, m.Version, m.Name, now); err != nil {
+			return done, err
+		}
+		done = append(done, m.Migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first,
+// and returns them.
+func (s *DBStore) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(status) - 1; i >= 0 && len(done) < steps; i-- {
+		m := status[i]
+		if m.AppliedAt == "" {
+			continue
+		}
+		query := "DELETE FROM schema_migrations WHERE version = ?"
+		if err := s.migrate(ctx, m.Migration, m.Down, query, m.Version); err != nil {
+			return done, err
+		}
+		done = append(done, m.Migration)
+	}
+	return done, nil
+}
+
+// migrate runs script and then the bookkeeping query in one transaction.
+func (s *DBStore) migrate(ctx context.Context, m Migration, script, query string, args ...any) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting migration transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(query), args...); err != nil {
+		slog.Error("Error recording migration", "version", m.Version, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing migration", "version", m.Version, "error", err)
+		return err
+	}
+	return nil
+}
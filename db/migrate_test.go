@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{"sqlite3", "pgx"} {
+		t.Run(driver, func(t *testing.T) {
+			migrations, err := loadMigrations(driver)
+			if err != nil {
+				t.Fatalf("Error loading migrations: %v", err)
+			}
+			if len(migrations) == 0 || migrations[0].Version != 1 || migrations[0].Name != "initial" {
+				t.Fatalf("Expected 0001_initial first, got %+v", migrations)
+			}
+			for i, m := range migrations {
+				if m.Version != i+1 {
+					t.Errorf("Expected version %d, got %d", i+1, m.Version)
+				}
+				if !strings.HasSuffix(strings.TrimSpace(m.Up), ";") || m.Down == "" {
+					t.Errorf("Migration %d has an unexpected body", m.Version)
+				}
+			}
+		})
+	}
+
+	if _, err := loadMigrations("mysql"); err == nil {
+		t.Errorf("Expected an error for a driver without migrations")
+	}
+}
+
+func TestDBStore_MigrateUp(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	migrations, err := loadMigrations("sqlite3")
+	if err != nil {
+		t.Fatalf("Error loading migrations: %v", err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(createMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, CAST(applied_at AS TEXT) FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, "2024-07-23 12:00:00"))
+	for _, m := range migrations[1:] {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(m.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)")).
+			WithArgs(m.Version, m.Name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	applied, err := store.MigrateUp(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if len(applied) != len(migrations)-1 || applied[0].Version != 2 {
+		t.Errorf("Expected every migration after 0001 applied, got %+v", applied)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_MigrateDown(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	migrations, err := loadMigrations("sqlite3")
+	if err != nil {
+		t.Fatalf("Error loading migrations: %v", err)
+	}
+	latest := migrations[len(migrations)-1]
+
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, m := range migrations {
+		rows.AddRow(m.Version, "2024-07-23 12:00:00")
+	}
+	mock.ExpectExec(regexp.QuoteMeta(createMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, CAST(applied_at AS TEXT) FROM schema_migrations")).WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(latest.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = ?")).
+		WithArgs(latest.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	reverted, err := store.MigrateDown(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Error reverting: %v", err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != latest.Version {
+		t.Errorf("Expected migration %d reverted, got %+v", latest.Version, reverted)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
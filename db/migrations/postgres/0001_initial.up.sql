@@ -0,0 +1,96 @@
+-- Tables that predate migrations. IF NOT EXISTS lets databases created
+-- from db/schema.sql adopt migrations without changes.
+
+-- Create mailboxes table
+CREATE TABLE IF NOT EXISTS mailboxes (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		mpi_id VARCHAR(200),
+		token VARCHAR(200),
+		created_at TIMESTAMP
+);
+
+-- Create users table
+CREATE TABLE IF NOT EXISTS users (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		mailbox_id INTEGER,
+		user_name VARCHAR(200),
+		email_address VARCHAR(200),
+		created_at TIMESTAMP,
+		FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id)
+);
+
+-- Create watermarks table
+CREATE TABLE IF NOT EXISTS watermarks (
+		name VARCHAR(200) PRIMARY KEY,
+		created_at TIMESTAMP,
+		user_id INTEGER
+);
+
+-- Create work_queue table
+CREATE TABLE IF NOT EXISTS work_queue (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		user_id INTEGER,
+		enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Create retry_queue table
+CREATE TABLE IF NOT EXISTS retry_queue (
+		user_id INTEGER PRIMARY KEY,
+		priority INTEGER,
+		attempts INTEGER,
+		next_attempt_at TIMESTAMP,
+		last_error VARCHAR(500),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Create mailbox_settings table
+CREATE TABLE IF NOT EXISTS mailbox_settings (
+		mailbox_id INTEGER,
+		name VARCHAR(200),
+		value VARCHAR(200),
+		PRIMARY KEY (mailbox_id, name),
+		FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id)
+);
+
+-- Create user_merges table
+CREATE TABLE IF NOT EXISTS user_merges (
+		user_id INTEGER,
+		merged_into INTEGER,
+		mailbox_id INTEGER,
+		user_name VARCHAR(200),
+		email_address VARCHAR(200),
+		created_at TIMESTAMP,
+		merged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (merged_into) REFERENCES users(id)
+);
+
+-- Create mailbox_moves table
+CREATE TABLE IF NOT EXISTS mailbox_moves (
+		user_id INTEGER,
+		from_mailbox_id INTEGER,
+		to_mailbox_id INTEGER,
+		moved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Create idempotency_keys table
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+		idempotency_key VARCHAR(300) PRIMARY KEY,
+		fingerprint VARCHAR(64),
+		status INTEGER,
+		content_type VARCHAR(200),
+		body TEXT,
+		created_at TIMESTAMP
+);
+
+-- Create user_changes table
+CREATE TABLE IF NOT EXISTS user_changes (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		user_id INTEGER,
+		op VARCHAR(10),
+		field VARCHAR(50),
+		old_value VARCHAR(200),
+		new_value VARCHAR(200),
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE mailboxes DROP COLUMN IF EXISTS updated_at;
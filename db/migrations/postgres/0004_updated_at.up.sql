@@ -0,0 +1,5 @@
+-- Add updated_at to mailboxes and users, starting from created_at
+ALTER TABLE mailboxes ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+UPDATE mailboxes SET updated_at = created_at WHERE updated_at IS NULL;
+UPDATE users SET updated_at = created_at WHERE updated_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE mailboxes ALTER COLUMN token TYPE VARCHAR(200);
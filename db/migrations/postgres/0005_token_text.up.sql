@@ -0,0 +1,3 @@
+-- Widen mailboxes.token for encrypted tokens, which are longer than the
+-- plaintext
+ALTER TABLE mailboxes ALTER COLUMN token TYPE TEXT;
@@ -0,0 +1,8 @@
+-- Create runs table
+CREATE TABLE IF NOT EXISTS runs (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		status VARCHAR(20),
+		annotations TEXT
+);
@@ -0,0 +1,9 @@
+-- Create mailbox_events table
+CREATE TABLE IF NOT EXISTS mailbox_events (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		mailbox_id INTEGER,
+		kind VARCHAR(20),
+		data TEXT,
+		occurred_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_mailbox_events_mailbox_id ON mailbox_events (mailbox_id, id);
@@ -0,0 +1,6 @@
+-- Create mailbox_tenants table
+CREATE TABLE IF NOT EXISTS mailbox_tenants (
+		mailbox_id INTEGER PRIMARY KEY,
+		tenant VARCHAR(100)
+);
+CREATE INDEX IF NOT EXISTS idx_mailbox_tenants_tenant ON mailbox_tenants (tenant);
@@ -0,0 +1,12 @@
+-- Create mailbox_summary table
+CREATE TABLE IF NOT EXISTS mailbox_summary (
+		mailbox_id INTEGER PRIMARY KEY,
+		health_score INTEGER,
+		token_valid BOOLEAN,
+		verification VARCHAR(20),
+		failure_rate DOUBLE PRECISION,
+		last_processed_at TIMESTAMP,
+		reasons TEXT,
+		computed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_mailbox_summary_health_score ON mailbox_summary (health_score, mailbox_id);
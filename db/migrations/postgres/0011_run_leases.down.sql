@@ -0,0 +1 @@
+DROP TABLE run_leases;
@@ -0,0 +1,7 @@
+-- Create run_leases table
+CREATE TABLE IF NOT EXISTS run_leases (
+		name VARCHAR(100) PRIMARY KEY,
+		holder VARCHAR(200),
+		acquired_at TIMESTAMP,
+		expires_at TIMESTAMP
+);
@@ -0,0 +1,8 @@
+-- Create run_failures table. A user_id of 0 marks the whole mailbox failed.
+CREATE TABLE IF NOT EXISTS run_failures (
+		run_id INTEGER,
+		mailbox_id INTEGER,
+		user_id INTEGER,
+		error TEXT,
+		PRIMARY KEY (run_id, mailbox_id, user_id)
+);
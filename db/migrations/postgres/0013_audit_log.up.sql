@@ -0,0 +1,12 @@
+-- Create audit_log table
+CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		actor VARCHAR(200),
+		action VARCHAR(20),
+		entity VARCHAR(20),
+		entity_id INTEGER,
+		before_json TEXT,
+		after_json TEXT,
+		occurred_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_occurred_at ON audit_log (occurred_at);
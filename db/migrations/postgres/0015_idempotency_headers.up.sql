@@ -0,0 +1,3 @@
+-- Add headers to idempotency_keys, holding every header of the stored
+-- response as JSON
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS headers TEXT;
@@ -0,0 +1,26 @@
+-- Turn users back into a single table keyed by id. The foreign keys dropped
+-- when it was partitioned are restored without checking rows written since.
+ALTER TABLE users RENAME TO users_partitioned;
+ALTER TABLE users_partitioned RENAME CONSTRAINT users_pkey TO users_partitioned_pkey;
+
+CREATE TABLE users (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		mailbox_id INTEGER,
+		user_name VARCHAR(200),
+		email_address VARCHAR(200),
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP,
+		FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id)
+);
+
+INSERT INTO users (id, mailbox_id, user_name, email_address, created_at, updated_at)
+SELECT id, mailbox_id, user_name, email_address, created_at, updated_at FROM users_partitioned;
+
+SELECT setval(pg_get_serial_sequence('users', 'id'), COALESCE(max(id), 0) + 1, false) FROM users;
+
+DROP TABLE users_partitioned;
+
+ALTER TABLE work_queue ADD CONSTRAINT work_queue_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) NOT VALID;
+ALTER TABLE retry_queue ADD CONSTRAINT retry_queue_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) NOT VALID;
+ALTER TABLE user_merges ADD CONSTRAINT user_merges_merged_into_fkey FOREIGN KEY (merged_into) REFERENCES users(id) NOT VALID;
+ALTER TABLE mailbox_moves ADD CONSTRAINT mailbox_moves_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) NOT VALID;
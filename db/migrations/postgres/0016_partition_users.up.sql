@@ -0,0 +1,54 @@
+-- Partition users by month of created_at. The primary key of a partitioned
+-- table must include the partition key, so it becomes (id, created_at) and
+-- foreign keys referencing users(id) are dropped. Users without a created_at
+-- take their updated_at, or the time of the migration. Monthly partitions are
+-- created for the users already present; `partitions` adds upcoming ones.
+ALTER TABLE work_queue DROP CONSTRAINT IF EXISTS work_queue_user_id_fkey;
+ALTER TABLE retry_queue DROP CONSTRAINT IF EXISTS retry_queue_user_id_fkey;
+ALTER TABLE user_merges DROP CONSTRAINT IF EXISTS user_merges_merged_into_fkey;
+ALTER TABLE mailbox_moves DROP CONSTRAINT IF EXISTS mailbox_moves_user_id_fkey;
+
+UPDATE users SET created_at = COALESCE(updated_at, CURRENT_TIMESTAMP) WHERE created_at IS NULL;
+
+ALTER TABLE users RENAME TO users_unpartitioned;
+ALTER TABLE users_unpartitioned RENAME CONSTRAINT users_pkey TO users_unpartitioned_pkey;
+
+CREATE TABLE users (
+		id INTEGER GENERATED BY DEFAULT AS IDENTITY,
+		mailbox_id INTEGER,
+		user_name VARCHAR(200),
+		email_address VARCHAR(200),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP,
+		PRIMARY KEY (id, created_at),
+		FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id)
+) PARTITION BY RANGE (created_at);
+
+-- Catches users outside every monthly partition until one is created.
+CREATE TABLE users_default PARTITION OF users DEFAULT;
+
+DO $$
+DECLARE
+		month TIMESTAMP;
+BEGIN
+		FOR month IN
+				SELECT generate_series(date_trunc('month', min(created_at)), date_trunc('month', max(created_at)), INTERVAL '1 month')
+				FROM users_unpartitioned
+		LOOP
+				EXECUTE format('CREATE TABLE %I PARTITION OF users FOR VALUES FROM (%L) TO (%L)',
+						'users_p' || to_char(month, 'YYYY_MM'), month, month + INTERVAL '1 month');
+		END LOOP;
+END $$;
+
+INSERT INTO users (id, mailbox_id, user_name, email_address, created_at, updated_at)
+SELECT id, mailbox_id, user_name, email_address, created_at, updated_at FROM users_unpartitioned;
+
+-- New users continue from the highest ID copied over.
+SELECT setval(pg_get_serial_sequence('users', 'id'), COALESCE(max(id), 0) + 1, false) FROM users;
+
+CREATE INDEX users_id ON users (id);
+CREATE INDEX users_mailbox_id_id ON users (mailbox_id, id);
+CREATE INDEX users_created_at_id ON users (created_at, id);
+CREATE INDEX users_email_address ON users (email_address);
+
+DROP TABLE users_unpartitioned;
@@ -0,0 +1 @@
+ALTER TABLE mailbox_settings ALTER COLUMN value TYPE VARCHAR(200);
@@ -0,0 +1,3 @@
+-- Widen mailbox_settings.value for encrypted settings, which are longer than
+-- the plaintext
+ALTER TABLE mailbox_settings ALTER COLUMN value TYPE TEXT;
@@ -0,0 +1,8 @@
+ALTER TABLE work_queue DROP CONSTRAINT IF EXISTS work_queue_user_id_fkey;
+ALTER TABLE retry_queue DROP CONSTRAINT IF EXISTS retry_queue_user_id_fkey;
+ALTER TABLE user_merges DROP CONSTRAINT IF EXISTS user_merges_merged_into_fkey;
+ALTER TABLE mailbox_moves DROP CONSTRAINT IF EXISTS mailbox_moves_user_id_fkey;
+
+DROP TRIGGER users_track_id ON users;
+DROP FUNCTION users_track_id();
+DROP TABLE user_ids;
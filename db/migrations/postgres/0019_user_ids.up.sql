@@ -0,0 +1,33 @@
+-- Partitioning users (migration 16) keyed it by (id, created_at) and dropped
+-- the foreign keys to users(id), leaving nothing to keep ids unique. user_ids
+-- holds every user's id under a primary key, kept in step by a trigger on
+-- users, so inserting a user with a taken id fails. A user moving between
+-- partitions is deleted and inserted again, which the trigger follows. The
+-- dropped foreign keys reference user_ids instead; they are checked at
+-- commit, so the brief absence of an id while its user moves does not trip
+-- them. Existing duplicate ids fail this migration and must be resolved
+-- first.
+CREATE TABLE user_ids (
+		id INTEGER PRIMARY KEY
+);
+
+INSERT INTO user_ids (id) SELECT id FROM users;
+
+CREATE FUNCTION users_track_id() RETURNS trigger AS $$
+BEGIN
+		IF TG_OP IN ('DELETE', 'UPDATE') THEN
+				DELETE FROM user_ids WHERE id = OLD.id;
+		END IF;
+		IF TG_OP IN ('INSERT', 'UPDATE') THEN
+				INSERT INTO user_ids (id) VALUES (NEW.id);
+		END IF;
+		RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_track_id AFTER INSERT OR DELETE OR UPDATE OF id ON users
+		FOR EACH ROW EXECUTE FUNCTION users_track_id();
+
+ALTER TABLE work_queue ADD CONSTRAINT work_queue_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ids(id) DEFERRABLE INITIALLY DEFERRED NOT VALID;
+ALTER TABLE retry_queue ADD CONSTRAINT retry_queue_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ids(id) DEFERRABLE INITIALLY DEFERRED NOT VALID;
+ALTER TABLE user_merges ADD CONSTRAINT user_merges_merged_into_fkey FOREIGN KEY (merged_into) REFERENCES user_ids(id) DEFERRABLE INITIALLY DEFERRED NOT VALID;
+ALTER TABLE mailbox_moves ADD CONSTRAINT mailbox_moves_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ids(id) DEFERRABLE INITIALLY DEFERRED NOT VALID;
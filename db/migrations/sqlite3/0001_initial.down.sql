@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS user_changes;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS mailbox_moves;
+DROP TABLE IF EXISTS user_merges;
+DROP TABLE IF EXISTS mailbox_settings;
+DROP TABLE IF EXISTS retry_queue;
+DROP TABLE IF EXISTS work_queue;
+DROP TABLE IF EXISTS watermarks;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS mailboxes;
@@ -0,0 +1,7 @@
+-- Create processed_users table
+CREATE TABLE IF NOT EXISTS processed_users (
+		mailbox_id INTEGER,
+		user_id INTEGER,
+		processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (mailbox_id, user_id)
+);
@@ -0,0 +1,6 @@
+-- Create mailbox_access table
+CREATE TABLE IF NOT EXISTS mailbox_access (
+		mailbox_id INTEGER PRIMARY KEY,
+		hits INTEGER,
+		last_accessed_at TIMESTAMP
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN updated_at;
+ALTER TABLE mailboxes DROP COLUMN updated_at;
@@ -0,0 +1,3 @@
+-- SQLite does not enforce VARCHAR lengths, so encrypted tokens already fit
+-- in mailboxes.token. This version keeps the dialects numbered alike.
+SELECT 1;
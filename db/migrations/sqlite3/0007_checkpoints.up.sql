@@ -0,0 +1,6 @@
+-- Create checkpoints table. A user_id of 0 marks the whole mailbox done.
+CREATE TABLE IF NOT EXISTS checkpoints (
+		mailbox_id INTEGER,
+		user_id INTEGER,
+		PRIMARY KEY (mailbox_id, user_id)
+);
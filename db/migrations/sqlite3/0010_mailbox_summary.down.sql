@@ -0,0 +1 @@
+DROP TABLE mailbox_summary;
@@ -0,0 +1,7 @@
+-- Create user_provenance table, recording the ingestion path each user
+-- was created through
+CREATE TABLE IF NOT EXISTS user_provenance (
+		user_id INTEGER PRIMARY KEY,
+		source VARCHAR(200),
+		recorded_at TIMESTAMP
+);
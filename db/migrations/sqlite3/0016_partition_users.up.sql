@@ -0,0 +1,3 @@
+-- SQLite has no table partitioning, so users stays a single table. This
+-- version keeps the dialects numbered alike.
+SELECT 1;
@@ -0,0 +1,3 @@
+-- SQLite does not enforce VARCHAR lengths, so encrypted settings already fit
+-- in mailbox_settings.value. This version keeps the dialects numbered alike.
+SELECT 1;
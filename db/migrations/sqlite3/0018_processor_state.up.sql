@@ -0,0 +1,8 @@
+-- Create processor_state table, holding the active processor configuration
+-- every process switches to
+CREATE TABLE IF NOT EXISTS processor_state (
+		name VARCHAR(100) PRIMARY KEY,
+		active VARCHAR(200),
+		previous VARCHAR(200),
+		updated_at TIMESTAMP
+);
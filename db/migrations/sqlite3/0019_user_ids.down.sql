@@ -0,0 +1,4 @@
+-- SQLite keeps users a single table with id as its primary key, so ids
+-- are unique without user_ids. This version keeps the dialects numbered
+-- alike.
+SELECT 1;
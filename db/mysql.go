@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"mailboxes/scope"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrTenantsUnsupported is returned by MySQLStore for tenant-scoped reads:
+// the MySQL schema does not record which mailboxes are whose.
+var ErrTenantsUnsupported = errors.New("tenants are not supported by the MySQL store")
+
+// MySQLConfig describes a MySQL or MariaDB connection.
+type MySQLConfig struct {
+	// DSN is a go-sql-driver DSN such as user:pass@tcp(host:3306)/mailboxes.
+	DSN string
+	// TLS is "true", "false", "skip-verify" or "preferred", as in the DSN tls
+	// parameter. Setting CAFile, CertFile or KeyFile implies "true".
+	TLS        string
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	Pool       PoolConfig
+}
+
+// MySQLStore is a read-only Store backed by MySQL or MariaDB. It reads an
+// existing schema it neither creates nor migrates: mailboxes (id, mpi_id,
+// token, created_at) and users (id, mailbox_id, user_name, email_address,
+// created_at). DATETIME columns are read as time.Time in UTC, so mailboxes
+// and users look the same as from DBStore. The MySQL schema has no
+// updated_at; UpdatedAt is left zero. Besides Store it implements only
+// StatsStore.
+type MySQLStore struct {
+	db *sql.DB
+}
+
+func NewMySQLStore(cfg MySQLConfig) (*MySQLStore, error) {
+	dsn, err := mysql.ParseDSN(cfg.DSN)
+	if err != nil {
+		slog.Error("Error parsing MySQL DSN", "error", err)
+		return nil, err
+	}
+	dsn.ParseTime = true
+	dsn.Loc = time.UTC
+
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		dsn.TLS, err = mysqlTLSConfig(cfg)
+		if err != nil {
+			slog.Error("Error loading MySQL TLS config", "error", err)
+			return nil, err
+		}
+	} else if cfg.TLS != "" {
+		dsn.TLSConfig = cfg.TLS
+	}
+
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil {
+		slog.Error("Error opening MySQL database", "error", err)
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	cfg.Pool.apply(db)
+	return &MySQLStore{db: db}, nil
+}
+
+// Ping checks that the database can be reached.
+func (s *MySQLStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func mysqlTLSConfig(cfg MySQLConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (s *MySQLStore) AllMailboxes(ctx context.Context) (<-chan Mailbox, error) {
+	if scope.Tenant(ctx) != "" {
+		return nil, ErrTenantsUnsupported
+	}
+	query := "SELECT id, mpi_id, token, created_at FROM mailboxes ORDER BY id"
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		slog.Error("Error querying mailboxes", "error", err)
+		return nil, err
+	}
+
+	mailboxChannel := make(chan Mailbox)
+
+	go func() {
+		defer close(mailboxChannel)
+		defer rows.Close()
+
+		for rows.Next() {
+			var mb Mailbox
+			if err := rows.Scan(&mb.ID, &mb.MPIID, &mb.Token, scanTime(&mb.CreatedAt)); err != nil {
+				slog.Error("Error scanning mailbox row", "error", err)
+				continue
+			}
+
+			select {
+			case mailboxChannel <- mb:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			slog.Error("Error iterating over mailbox rows", "error", err)
+			return
+		}
+	}()
+
+	return mailboxChannel, nil
+}
+
+func (s *MySQLStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan User, error) {
+	if scope.Tenant(ctx) != "" {
+		return nil, ErrTenantsUnsupported
+	}
+	query := "SELECT id, mailbox_id, user_name, email_address, created_at FROM users WHERE mailbox_id = ? ORDER BY id"
+
+	rows, err := s.db.QueryContext(ctx, query, mailboxID)
+	if err != nil {
+		slog.Error("Error querying users for mailbox", "mailbox_id", mailboxID, "error", err)
+		return nil, err
+	}
+
+	userChannel := make(chan User)
+
+	go func() {
+		defer close(userChannel)
+		defer rows.Close()
+
+		for rows.Next() {
+			var user User
+			if err := rows.Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt)); err != nil {
+				slog.Error("Error scanning user row", "error", err)
+				continue
+			}
+
+			select {
+			case userChannel <- user:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			slog.Error("Error iterating over user rows", "error", err)
+			return
+		}
+	}()
+
+	return userChannel, nil
+}
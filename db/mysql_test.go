@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"mailboxes/scope"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMySQLStore_AllMailboxes(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mpi_id, token, created_at FROM mailboxes")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at"}).
+			AddRow(1, "mpi123", "token123", time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)).
+			AddRow(2, "mpi456", "token456", nil))
+
+	store := &MySQLStore{db: db}
+
+	mailboxChan, err := store.AllMailboxes(context.Background())
+	if err != nil {
+		t.Fatalf("Error calling AllMailboxes: %v", err)
+	}
+
+	var mailboxes []Mailbox
+	for mb := range mailboxChan {
+		mailboxes = append(mailboxes, mb)
+	}
+
+	expectedMailboxes := []Mailbox{
+		{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: ts("2024-07-23 12:00:00")},
+		{ID: 2, MPIID: "mpi456", Token: "token456"},
+	}
+	if !reflect.DeepEqual(mailboxes, expectedMailboxes) {
+		t.Errorf("Expected mailboxes %v, got %v", expectedMailboxes, mailboxes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMySQLStore_UsersForMailbox(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, created_at FROM users WHERE mailbox_id = ?")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)))
+
+	store := &MySQLStore{db: db}
+
+	userChan, err := store.UsersForMailbox(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Error calling UsersForMailbox: %v", err)
+	}
+
+	var users []User
+	for user := range userChan {
+		users = append(users, user)
+	}
+
+	expectedUsers := []User{{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")}}
+	if !reflect.DeepEqual(users, expectedUsers) {
+		t.Errorf("Expected users %v, got %v", expectedUsers, users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMySQLStore_Tenants(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	store := &MySQLStore{db: db}
+	ctx := scope.WithTenant(context.Background(), "acme")
+
+	if _, err := store.AllMailboxes(ctx); !errors.Is(err, ErrTenantsUnsupported) {
+		t.Errorf("Expected ErrTenantsUnsupported from AllMailboxes, got %v", err)
+	}
+	if _, err := store.UsersForMailbox(ctx, 1); !errors.Is(err, ErrTenantsUnsupported) {
+		t.Errorf("Expected ErrTenantsUnsupported from UsersForMailbox, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNewMySQLStore_InvalidDSN(t *testing.T) {
+	if _, err := NewMySQLStore(MySQLConfig{DSN: "not a dsn"}); err == nil {
+		t.Errorf("Expected an error for an invalid DSN")
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// ErrMailboxExists is returned when onboarding an MPI ID that already has a
+// mailbox.
+var ErrMailboxExists = errors.New("mailbox already exists")
+
+// OnboardMailbox creates a mailbox for mpiID, stores the token returned by
+// handshake and seeds settings, all in one transaction, along with a created
+// event when the mailbox_events log is on.
+func (s *DBStore) OnboardMailbox(ctx context.Context, mpiID string, settings map[string]string, handshake func(Mailbox) (string, error)) (Mailbox, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting onboarding transaction", "error", err)
+		return Mailbox{}, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRowContext(ctx, s.rebind("SELECT id FROM mailboxes WHERE mpi_id = ?"), mpiID).Scan(&existing)
+	switch {
+	case err == nil:
+		return Mailbox{}, fmt.Errorf("%w: %s is mailbox %d", ErrMailboxExists, mpiID, existing)
+	case !errors.Is(err, sql.ErrNoRows):
+		slog.Error("Error looking up mailbox", "mpi_id", mpiID, "error", err)
+		return Mailbox{}, err
+	}
+
+	createdAt := now()
+	mb := Mailbox{MPIID: mpiID, CreatedAt: createdAt, UpdatedAt: createdAt}
+	query := "INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, '', ?, ?)"
+	if mb.ID, err = s.insertID(ctx, tx, query, mb.MPIID, FormatTimestamp(createdAt), FormatTimestamp(createdAt)); err != nil {
+		slog.Error("Error creating mailbox", "mpi_id", mpiID, "error", err)
+		return Mailbox{}, err
+	}
+
+	if mb.Token, err = handshake(mb); err != nil {
+		return Mailbox{}, err
+	}
+
+	sealed, err := s.tokens.Seal(mb.Token)
+	if err != nil {
+		slog.Error("Error sealing token for mailbox", "mailbox_id", mb.ID, "error", err)
+		return Mailbox{}, err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind("UPDATE mailboxes SET token = ? WHERE id = ?"), sealed, mb.ID); err != nil {
+		slog.Error("Error storing token for mailbox", "mailbox_id", mb.ID, "error", err)
+		return Mailbox{}, err
+	}
+	if err := s.logMailboxEvent(ctx, tx, mb.ID, MailboxCreated, map[string]string{"mpi_id": mpiID, "onboarded": "true"}); err != nil {
+		return Mailbox{}, err
+	}
+	if err := s.logAudit(ctx, tx, ChangeCreate, AuditMailbox, mb.ID, nil, &mb); err != nil {
+		return Mailbox{}, err
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		query := "INSERT INTO mailbox_settings (mailbox_id, name, value) VALUES (?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, s.rebind(query), mb.ID, name, settings[name]); err != nil {
+			slog.Error("Error seeding setting", "setting", name, "mailbox_id", mb.ID, "error", err)
+			return Mailbox{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing onboarding of mailbox", "mailbox_id", mb.ID, "error", err)
+		return Mailbox{}, err
+	}
+
+	return mb, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_OnboardMailbox(t *testing.T) {
+	ctx := context.Background()
+	handshakeErr := errors.New("handshake failed")
+
+	tests := []struct {
+		name          string
+		mockSetup     func(mock sqlmock.Sqlmock)
+		handshakeErr  error
+		expectedErr   error
+		expectedToken string
+	}{
+		{
+			name: "Onboards new mailbox",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE mpi_id = ?")).
+					WithArgs("mpi789").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, '', ?, ?) RETURNING id")).
+					WithArgs("mpi789", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE mailboxes SET token = ? WHERE id = ?")).
+					WithArgs("token789", 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_settings (mailbox_id, name, value) VALUES (?, ?, ?)")).
+					WithArgs(3, "language", "en").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_settings (mailbox_id, name, value) VALUES (?, ?, ?)")).
+					WithArgs(3, "sync", "true").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedToken: "token789",
+		},
+		{
+			name: "Mailbox already exists",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE mpi_id = ?")).
+					WithArgs("mpi789").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrMailboxExists,
+		},
+		{
+			name: "Handshake fails",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE mpi_id = ?")).
+					WithArgs("mpi789").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, '', ?, ?) RETURNING id")).
+					WithArgs("mpi789", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectRollback()
+			},
+			handshakeErr: handshakeErr,
+			expectedErr:  handshakeErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+
+			handshake := func(mb Mailbox) (string, error) {
+				if tt.handshakeErr != nil {
+					return "", tt.handshakeErr
+				}
+				return "token789", nil
+			}
+
+			mb, err := store.OnboardMailbox(ctx, "mpi789", map[string]string{"sync": "true", "language": "en"}, handshake)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if mb.Token != tt.expectedToken {
+				t.Errorf("Expected token %q, got %q", tt.expectedToken, mb.Token)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
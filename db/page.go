@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// AllMailboxesPage returns up to limit mailboxes in ID order, skipping the
+// first offset. Large offsets still read the skipped rows; prefer
+// MailboxesAfter when walking a whole table.
+func (s *DBStore) AllMailboxesPage(ctx context.Context, limit, offset int) ([]Mailbox, error) {
+	query := "SELECT " + mailboxColumns + " FROM mailboxes"
+	cond, args := tenantScope(ctx, "id")
+	if cond != "" {
+		query += " WHERE " + strings.TrimPrefix(cond, " AND ")
+	}
+	query += " ORDER BY id LIMIT ? OFFSET ?"
+	return s.mailboxPage(ctx, query, append(args, limit, offset)...)
+}
+
+// MailboxesAfter returns up to limit mailboxes with IDs above afterID, in ID
+// order. Pass the last ID of one page as afterID to get the next.
+func (s *DBStore) MailboxesAfter(ctx context.Context, afterID, limit int) ([]Mailbox, error) {
+	cond, args := tenantScope(ctx, "id")
+	query := "SELECT " + mailboxColumns + " FROM mailboxes WHERE id > ?" + cond + " ORDER BY id LIMIT ?"
+	return s.mailboxPage(ctx, query, append(append([]any{afterID}, args...), limit)...)
+}
+
+// UsersForMailboxPage returns up to limit of mailboxID's users in ID order,
+// skipping the first offset.
+func (s *DBStore) UsersForMailboxPage(ctx context.Context, mailboxID, limit, offset int) ([]User, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT " + userColumns + " FROM users WHERE mailbox_id = ?" + cond + " ORDER BY id LIMIT ? OFFSET ?"
+	return s.userPage(ctx, query, append(append([]any{mailboxID}, args...), limit, offset)...)
+}
+
+// UsersForMailboxAfter returns up to limit of mailboxID's users with IDs
+// above afterID, in ID order.
+func (s *DBStore) UsersForMailboxAfter(ctx context.Context, mailboxID, afterID, limit int) ([]User, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT " + userColumns + " FROM users WHERE mailbox_id = ? AND id > ?" + cond + " ORDER BY id LIMIT ?"
+	return s.userPage(ctx, query, append(append([]any{mailboxID, afterID}, args...), limit)...)
+}
+
+func (s *DBStore) mailboxPage(ctx context.Context, query string, args ...any) ([]Mailbox, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying mailbox page", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mailboxes []Mailbox
+	for rows.Next() {
+		var mb Mailbox
+		if err := rows.Scan(&mb.ID, &mb.MPIID, s.scanToken(&mb.Token), scanTime(&mb.CreatedAt), scanTime(&mb.UpdatedAt)); err != nil {
+			slog.Error("Error scanning mailbox row", "error", err)
+			return nil, err
+		}
+		mailboxes = append(mailboxes, mb)
+	}
+
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over mailbox rows", "error", err)
+		return nil, err
+	}
+	return mailboxes, nil
+}
+
+func (s *DBStore) userPage(ctx context.Context, query string, args ...any) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying user page", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)); err != nil {
+			slog.Error("Error scanning user row", "error", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over user rows", "error", err)
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_MailboxPages(t *testing.T) {
+	mailboxRows := []string{"id", "mpi_id", "token", "created_at", "updated_at"}
+	mb2 := Mailbox{ID: 2, MPIID: "mpi456", Token: "token456", CreatedAt: ts("2024-07-23 13:00:00")}
+
+	tests := []struct {
+		name     string
+		query    string
+		args     []driver.Value
+		call     func(*DBStore) ([]Mailbox, error)
+		rows     *sqlmock.Rows
+		expected []Mailbox
+	}{
+		{
+			name:  "Offset",
+			query: "SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes ORDER BY id LIMIT $1 OFFSET $2",
+			args:  []driver.Value{1, 1},
+			call: func(s *DBStore) ([]Mailbox, error) {
+				return s.AllMailboxesPage(context.Background(), 1, 1)
+			},
+			rows:     sqlmock.NewRows(mailboxRows).AddRow(2, "mpi456", "token456", "2024-07-23 13:00:00", nil),
+			expected: []Mailbox{mb2},
+		},
+		{
+			name:  "Keyset",
+			query: "SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes WHERE id > $1 ORDER BY id LIMIT $2",
+			args:  []driver.Value{1, 50},
+			call: func(s *DBStore) ([]Mailbox, error) {
+				return s.MailboxesAfter(context.Background(), 1, 50)
+			},
+			rows:     sqlmock.NewRows(mailboxRows).AddRow(2, "mpi456", "token456", "2024-07-23 13:00:00", nil),
+			expected: []Mailbox{mb2},
+		},
+		{
+			name:  "Past the end",
+			query: "SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes WHERE id > $1 ORDER BY id LIMIT $2",
+			args:  []driver.Value{2, 50},
+			call: func(s *DBStore) ([]Mailbox, error) {
+				return s.MailboxesAfter(context.Background(), 2, 50)
+			},
+			rows: sqlmock.NewRows(mailboxRows),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta(tt.query)).WithArgs(tt.args...).WillReturnRows(tt.rows)
+
+			mailboxes, err := tt.call(&DBStore{db: db, driver: "pgx"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mailboxes, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, mailboxes)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_UserPages(t *testing.T) {
+	userRows := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+	user2 := User{ID: 102, MailboxID: 1, UserName: "user2", EmailAddress: "user2@example.com", CreatedAt: ts("2024-07-23 12:45:00")}
+
+	tests := []struct {
+		name     string
+		query    string
+		args     []driver.Value
+		call     func(*DBStore) ([]User, error)
+		expected []User
+	}{
+		{
+			name:  "Offset",
+			query: "SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id = ? ORDER BY id LIMIT ? OFFSET ?",
+			args:  []driver.Value{1, 1, 1},
+			call: func(s *DBStore) ([]User, error) {
+				return s.UsersForMailboxPage(context.Background(), 1, 1, 1)
+			},
+			expected: []User{user2},
+		},
+		{
+			name:  "Keyset",
+			query: "SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id = ? AND id > ? ORDER BY id LIMIT ?",
+			args:  []driver.Value{1, 101, 1},
+			call: func(s *DBStore) ([]User, error) {
+				return s.UsersForMailboxAfter(context.Background(), 1, 101, 1)
+			},
+			expected: []User{user2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta(tt.query)).WithArgs(tt.args...).
+				WillReturnRows(sqlmock.NewRows(userRows).AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00", nil))
+
+			users, err := tt.call(&DBStore{db: db})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(users, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, users)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
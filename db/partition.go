@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrPartitioningUnsupported is returned by EnsureUserPartitions on databases
+// other than PostgreSQL.
+var ErrPartitioningUnsupported = errors.New("users table partitioning requires PostgreSQL")
+
+// userPartition names the partition holding users created in month's month,
+// matching the names created by migration 16 (partition_users).
+func userPartition(month time.Time) string {
+	return "users_p" + month.Format("2006_01")
+}
+
+// SetPartitionedUsers tells the store that users is partitioned by month of
+// created_at, as set up by migration 16 (partition_users). Writes to a
+// single user then name its created_at so PostgreSQL only touches that
+// user's partition.
+func (s *DBStore) SetPartitionedUsers(partitioned bool) {
+	s.partitionedUsers = partitioned
+}
+
+// userKey returns the WHERE clause and arguments identifying user. On a
+// partitioned users table the clause includes created_at so the statement is
+// pruned to one partition instead of probing every partition's index.
+func (s *DBStore) userKey(user User) (string, []any) {
+	if s.partitionedUsers && !user.CreatedAt.IsZero() {
+		return "id = ? AND created_at = ?", []any{user.ID, FormatTimestamp(user.CreatedAt)}
+	}
+	return "id = ?", []any{user.ID}
+}
+
+// EnsureUserPartitions creates any missing monthly users partitions from
+// from's month through through's month, and returns the names of those it
+// created. Rows already in the default partition for a new month are moved
+// into it in the same transaction.
+func (s *DBStore) EnsureUserPartitions(ctx context.Context, from, through time.Time) ([]string, error) {
+	if !s.isPostgres() {
+		return nil, ErrPartitioningUnsupported
+	}
+
+	var created []string
+	month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(through) {
+		next := month.AddDate(0, 1, 0)
+		ok, err := s.createUserPartition(ctx, month, next)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created = append(created, userPartition(month))
+		}
+		month = next
+	}
+
+	return created, nil
+}
+
+// createUserPartition creates and attaches the partition for [start, end)
+// unless it already exists, reporting whether it did.
+func (s *DBStore) createUserPartition(ctx context.Context, start, end time.Time) (bool, error) {
+	name := userPartition(start)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting partition transaction", "error", err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, s.rebind("SELECT to_regclass(?) IS NOT NULL"), name).Scan(&exists); err != nil {
+		slog.Error("Error looking up partition", "partition", name, "error", err)
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	// The bounds are formatted here rather than bound as parameters, which
+	// DDL does not accept.
+	lower, upper := start.Format(TimestampLayout), end.Format(TimestampLayout)
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE users INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", name),
+		fmt.Sprintf("WITH moved AS (DELETE FROM users_default WHERE created_at >= '%s' AND created_at < '%s' RETURNING *) "+
+			"INSERT INTO %s SELECT * FROM moved", lower, upper, name),
+		// Deleting the moved users from users_default dropped their ids from
+		// user_ids; the new table fires no trigger until it is attached.
+		fmt.Sprintf("INSERT INTO user_ids (id) SELECT id FROM %s", name),
+		fmt.Sprintf("ALTER TABLE users ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')", name, lower, upper),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			slog.Error("Error creating partition", "partition", name, "error", err)
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing partition", "partition", name, "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
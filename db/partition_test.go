@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_EnsureUserPartitions(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	existsQuery := regexp.QuoteMeta("SELECT to_regclass($1) IS NOT NULL")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(existsQuery).WithArgs("users_p2024_07").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(existsQuery).WithArgs("users_p2024_08").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE users_p2024_08 (LIKE users INCLUDING DEFAULTS INCLUDING CONSTRAINTS)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("WITH moved AS (DELETE FROM users_default WHERE created_at >= '2024-08-01 00:00:00' AND created_at < '2024-09-01 00:00:00' RETURNING *) " +
+		"INSERT INTO users_p2024_08 SELECT * FROM moved")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_ids (id) SELECT id FROM users_p2024_08")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE users ATTACH PARTITION users_p2024_08 FOR VALUES FROM ('2024-08-01 00:00:00') TO ('2024-09-01 00:00:00')")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "pgx"}
+
+	created, err := store.EnsureUserPartitions(context.Background(),
+		time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC), time.Date(2024, 8, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Error calling EnsureUserPartitions: %v", err)
+	}
+	if !reflect.DeepEqual(created, []string{"users_p2024_08"}) {
+		t.Errorf("Expected users_p2024_08 to be created, got %v", created)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	sqlite := &DBStore{db: db, driver: "sqlite3"}
+	if _, err := sqlite.EnsureUserPartitions(context.Background(), time.Now(), time.Now()); !errors.Is(err, ErrPartitioningUnsupported) {
+		t.Errorf("Expected ErrPartitioningUnsupported, got %v", err)
+	}
+}
+
+func TestDBStore_DeleteUser_Partitioned(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE id = $1")).WithArgs(101).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE user_id = $1")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM retry_queue WHERE user_id = $1")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = $1 AND created_at = $2")).
+		WithArgs(101, "2024-07-23 12:30:00").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_changes")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_changes")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_changes")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "pgx"}
+	store.SetPartitionedUsers(true)
+
+	if err := store.DeleteUser(context.Background(), 101); err != nil {
+		t.Fatalf("Error calling DeleteUser: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
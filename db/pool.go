@@ -0,0 +1,38 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolConfig tunes the connection pool of a store. Zero values leave the
+// database/sql defaults: no limit on open connections, two kept idle, and
+// none closed for age or idleness.
+type PoolConfig struct {
+	// MaxOpenConns caps the connections open at once, in use or idle.
+	MaxOpenConns int
+	// MaxIdleConns is how many idle connections are kept for reuse;
+	// negative keeps none.
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections once they are this old, such as
+	// to move onto new database hosts behind a load balancer.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime closes connections left idle this long.
+	ConnMaxIdleTime time.Duration
+}
+
+// apply sets the limits cfg sets on db.
+func (cfg PoolConfig) apply(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
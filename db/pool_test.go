@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPoolConfig(t *testing.T) {
+	pool := PoolConfig{MaxOpenConns: 4, MaxIdleConns: 2, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: time.Minute}
+	path := filepath.Join(t.TempDir(), "mailboxes.db")
+	opened := map[string]func() (Store, error){
+		"DBStore":     func() (Store, error) { return NewDBStore("sqlite", path, pool) },
+		"SQLiteStore": func() (Store, error) { return NewSQLiteStore("sqlite", SQLiteConfig{Path: path, Pool: pool}) },
+	}
+	for name, open := range opened {
+		t.Run(name, func(t *testing.T) {
+			store, err := open()
+			if err != nil {
+				t.Fatalf("Error opening store: %v", err)
+			}
+			db := store.(*DBStore).db
+			defer db.Close()
+
+			// Holding more connections than the pool allows blocks the
+			// one past the limit.
+			ctx := context.Background()
+			for range pool.MaxOpenConns {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					t.Fatalf("Error connecting: %v", err)
+				}
+				defer conn.Close()
+			}
+			if stats := db.Stats(); stats.MaxOpenConnections != 4 || stats.OpenConnections != 4 {
+				t.Errorf("Expected 4 of at most 4 connections open, got %d of %d", stats.OpenConnections, stats.MaxOpenConnections)
+			}
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			if _, err := db.Conn(ctx); err == nil {
+				t.Errorf("Expected a fifth connection to wait for one of the four")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// writableTables are the tables CheckWritable tries: the ones every run
+// writes to.
+var writableTables = []string{"mailboxes", "users", "runs"}
+
+// CheckWritable updates no rows of each table the pipeline writes to, in a
+// transaction that is rolled back. The update still needs write permission,
+// which the database checks before looking for rows.
+func (s *DBStore) CheckWritable(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range writableTables {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET id = id WHERE 1 = 0"); err != nil {
+			slog.Error("Error checking table is writable", "table", table, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// ServerTime returns CURRENT_TIMESTAMP as the database sees it.
+func (s *DBStore) ServerTime(ctx context.Context) (time.Time, error) {
+	var t time.Time
+	if err := s.db.QueryRowContext(ctx, "SELECT CAST(CURRENT_TIMESTAMP AS TEXT)").Scan(scanTime(&t)); err != nil {
+		slog.Error("Error querying server time", "error", err)
+		return time.Time{}, err
+	}
+	return t, nil
+}
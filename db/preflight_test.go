@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_CheckWritable(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mailboxes SET id = id WHERE 1 = 0")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET id = id WHERE 1 = 0")).WillReturnError(errors.New("permission denied for table users"))
+	mock.ExpectRollback()
+
+	store := &DBStore{db: db}
+	if err := store.CheckWritable(context.Background()); err == nil {
+		t.Errorf("Expected an error for a table that cannot be written")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+}
+
+func TestDBStore_ServerTime(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT CAST(CURRENT_TIMESTAMP AS TEXT)")).
+		WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow("2024-07-23 12:00:01.5+02"))
+
+	store := &DBStore{db: db}
+	got, err := store.ServerTime(context.Background())
+	if err != nil {
+		t.Fatalf("Error reading server time: %v", err)
+	}
+	if want := time.Date(2024, 7, 23, 10, 0, 1, 5e8, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
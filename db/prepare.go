@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+)
+
+// stmtCache holds the statements a DBStore has prepared, by query.
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// SetPreparedStatements turns on or off reusing prepared statements for
+// the mailbox and user reads a run repeats for every mailbox. Stores from
+// NewDBStore start with it on. Turn it off behind a pooler that does not
+// keep prepared statements, such as PgBouncer in transaction mode.
+func (s *DBStore) SetPreparedStatements(enabled bool) {
+	switch {
+	case !enabled:
+		s.stmts = nil
+	case s.stmts == nil:
+		s.stmts = &stmtCache{stmts: map[string]*sql.Stmt{}}
+	}
+}
+
+// prepared returns the statement for query, preparing it the first time.
+// Preparing waits until then rather than when the store is opened, which
+// does not connect and may come before migrate up creates the tables. A
+// statement that fails to prepare is not kept, so it is tried again next
+// time.
+func (c *stmtCache) prepared(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		slog.Error("Error preparing statement", "error", err)
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// queryReads runs query, a read repeated throughout a run, through its
+// prepared statement when they are on.
+func (s *DBStore) queryReads(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if s.stmts == nil {
+		return s.db.QueryContext(ctx, query, args...)
+	}
+	stmt, err := s.stmts.prepared(ctx, s.db, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// queryRowReads is queryReads for a query returning at most one row.
+func (s *DBStore) queryRowReads(ctx context.Context, query string, args ...any) *sql.Row {
+	if s.stmts != nil {
+		if stmt, err := s.stmts.prepared(ctx, s.db, query); err == nil {
+			return stmt.QueryRowContext(ctx, args...)
+		}
+	}
+	return s.db.QueryRowContext(ctx, query, args...)
+}
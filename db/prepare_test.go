@@ -0,0 +1,40 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDBStore_PreparedStatements(t *testing.T) {
+	db := setupBenchDB(t, 5)
+	defer db.Close()
+
+	read := func(store *DBStore) []User {
+		var users []User
+		for user, err := range store.UserSeq(context.Background(), 1) {
+			if err != nil {
+				t.Fatalf("Error reading users: %v", err)
+			}
+			users = append(users, user)
+		}
+		return users
+	}
+
+	unprepared := read(&DBStore{db: db, driver: "sqlite3"})
+	store := &DBStore{db: db, driver: "sqlite3"}
+	store.SetPreparedStatements(true)
+	for i := 0; i < 2; i++ {
+		if users := read(store); !reflect.DeepEqual(users, unprepared) {
+			t.Fatalf("Expected prepared reads to match unprepared ones, got %v and %v", users, unprepared)
+		}
+	}
+	if n := len(store.stmts.stmts); n != 1 {
+		t.Errorf("Expected the query to be prepared once, got %d statements", n)
+	}
+
+	store.SetPreparedStatements(false)
+	if store.stmts != nil {
+		t.Errorf("Expected prepared statements to be off")
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// processorStateName is the processor_state row the processor switch uses.
+const processorStateName = "processor"
+
+// ProcessorState returns the processor configuration last switched to, or
+// the zero state if none was.
+func (s *DBStore) ProcessorState(ctx context.Context) (ProcessorState, error) {
+	var state ProcessorState
+	query := "SELECT active, COALESCE(previous, ''), CAST(updated_at AS TEXT) FROM processor_state WHERE name = ?"
+	err := s.db.QueryRowContext(ctx, s.rebind(query), processorStateName).
+		Scan(&state.Active, &state.Previous, scanTime(&state.UpdatedAt))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("Error querying processor state", "error", err)
+		return ProcessorState{}, err
+	}
+	return state, nil
+}
+
+// UseProcessor makes active the active configuration in one statement, so
+// two switches racing each record the other's as previous. current is the
+// previous configuration when nothing was switched to yet.
+func (s *DBStore) UseProcessor(ctx context.Context, active, current string) (ProcessorState, error) {
+	previous := current
+	if previous == active {
+		previous = ""
+	}
+	query := `INSERT INTO processor_state (name, active, previous, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET previous = processor_state.active, active = excluded.active, updated_at = excluded.updated_at
+		WHERE processor_state.active <> excluded.active`
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), processorStateName, active, previous, FormatTimestamp(now())); err != nil {
+		slog.Error("Error switching processor configuration", "active", active, "error", err)
+		return ProcessorState{}, err
+	}
+	return s.ProcessorState(ctx)
+}
+
+// RollbackProcessor swaps the active and previous configurations in one
+// statement. It reports false if there is no previous configuration.
+func (s *DBStore) RollbackProcessor(ctx context.Context) (ProcessorState, bool, error) {
+	query := "UPDATE processor_state SET active = previous, previous = active, updated_at = ? WHERE name = ? AND previous <> ''"
+	res, err := s.db.ExecContext(ctx, s.rebind(query), FormatTimestamp(now()), processorStateName)
+	if err != nil {
+		slog.Error("Error rolling processor configuration back", "error", err)
+		return ProcessorState{}, false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ProcessorState{}, false, nil
+	}
+	state, err := s.ProcessorState(ctx)
+	return state, err == nil, err
+}
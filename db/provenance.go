@@ -0,0 +1,61 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"time"
+
+	"mailboxes/scope"
+)
+
+// SetProvenance turns on user_provenance: every user created through the
+// store is recorded, in the same transaction as the write, with the source
+// ctx carries, or defaultSource if it carries none.
+func (s *DBStore) SetProvenance(enabled bool, defaultSource string) {
+	s.provenance = enabled
+	s.provenanceSource = defaultSource
+}
+
+// recordProvenance records the source of the new user id through q if
+// user_provenance is on.
+func (s *DBStore) recordProvenance(ctx context.Context, q execQueryer, id int) error {
+	if !s.provenance {
+		return nil
+	}
+	source := cmp.Or(scope.Source(ctx), s.provenanceSource)
+	query := "INSERT INTO user_provenance (user_id, source, recorded_at) VALUES (?, ?, ?)"
+	if _, err := q.ExecContext(ctx, s.rebind(query), id, source, FormatTimestamp(now())); err != nil {
+		slog.Error("Error recording provenance of user", "user_id", id, "error", err)
+		return err
+	}
+	return nil
+}
+
+// UserSources returns the source recorded in user_provenance at or after
+// since for each user, by user ID; a zero since returns every source.
+func (s *DBStore) UserSources(ctx context.Context, since time.Time) (map[int]string, error) {
+	query, args := "SELECT user_id, source FROM user_provenance", []any(nil)
+	if !since.IsZero() {
+		query += " WHERE recorded_at >= ?"
+		args = append(args, FormatTimestamp(since))
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying user provenance", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := map[int]string{}
+	for rows.Next() {
+		var id int
+		var source string
+		if err := rows.Scan(&id, &source); err != nil {
+			slog.Error("Error scanning user provenance row", "error", err)
+			return nil, err
+		}
+		sources[id] = source
+	}
+	return sources, rows.Err()
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"mailboxes/scope"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var provenanceInsert = regexp.QuoteMeta("INSERT INTO user_provenance (user_id, source, recorded_at) VALUES (?, ?, ?)")
+
+func TestDBStore_CreateUser_Provenance(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{
+		{name: "From context", ctx: scope.WithSource(context.Background(), "api"), expected: "api"},
+		{name: "Default", ctx: context.Background(), expected: "cli"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = ?")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (mailbox_id, user_name, email_address, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING id")).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(109))
+			expectChanges(mock,
+				UserChange{UserID: 109, Op: ChangeCreate, Field: "mailbox_id", New: "1"},
+				UserChange{UserID: 109, Op: ChangeCreate, Field: "user_name", New: "user9"},
+				UserChange{UserID: 109, Op: ChangeCreate, Field: "email_address", New: "user9@example.com"})
+			mock.ExpectExec(provenanceInsert).WithArgs(109, tt.expected, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+			store.SetProvenance(true, "cli")
+			if _, err := store.CreateUser(tt.ctx, User{MailboxID: 1, UserName: "user9", EmailAddress: "user9@example.com"}); err != nil {
+				t.Fatalf("Error calling CreateUser: %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_UserSources(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id, source FROM user_provenance")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "source"}).AddRow(101, "api").AddRow(102, "import:users.csv"))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id, source FROM user_provenance WHERE recorded_at >= ?")).WithArgs("2024-07-23 12:00:00").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "source"}).AddRow(102, "import:users.csv"))
+
+	store := &DBStore{db: db}
+	sources, err := store.UserSources(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatalf("Error calling UserSources: %v", err)
+	}
+	expected := map[int]string{101: "api", 102: "import:users.csv"}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Expected %v, got %v", expected, sources)
+	}
+
+	sources, err = store.UserSources(context.Background(), ts("2024-07-23 12:00:00"))
+	if err != nil {
+		t.Fatalf("Error calling UserSources: %v", err)
+	}
+	if expected := map[int]string{102: "import:users.csv"}; !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Expected %v since the cutoff, got %v", expected, sources)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+func (s *DBStore) EnqueueUser(ctx context.Context, userID int) error {
+	query := "INSERT INTO work_queue (user_id) VALUES (?)"
+
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), userID); err != nil {
+		slog.Error("Error enqueueing user", "user_id", userID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// queueEntry is a claimed work_queue row.
+type queueEntry struct {
+	id     int
+	userID int
+}
+
+// ClaimQueuedUsers removes up to limit entries from the front of the work
+// queue and returns their users, oldest first. An entry is returned only
+// by the call whose DELETE removed it, so two watchers never process the
+// same entry. The entries are claimed and their users read in one
+// transaction, so none is lost if either fails. Entries for users deleted
+// since they were queued are removed without being returned.
+func (s *DBStore) ClaimQueuedUsers(ctx context.Context, limit int) ([]User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting work queue claim transaction", "error", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	entries, err := s.claimQueueEntries(ctx, tx, limit)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	userIDs := make([]int, len(entries))
+	for i, e := range entries {
+		userIDs[i] = e.userID
+	}
+	byID, err := s.usersByID(ctx, tx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing work queue claim", "error", err)
+		return nil, err
+	}
+
+	var users []User
+	for _, e := range entries {
+		if user, ok := byID[e.userID]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// claimQueueEntries deletes up to limit entries from the front of the work
+// queue inside tx and returns those it deleted, in queue order. Drivers with RETURNING
+// do so in one statement; PostgreSQL skips entries another watcher has
+// locked rather than waiting for them. Elsewhere each entry is deleted on
+// its own and kept only if this call's DELETE removed it.
+func (s *DBStore) claimQueueEntries(ctx context.Context, tx *sql.Tx, limit int) ([]queueEntry, error) {
+	if returningDrivers[s.driver] {
+		lock := ""
+		if s.isPostgres() {
+			lock = " FOR UPDATE SKIP LOCKED"
+		}
+		query := "DELETE FROM work_queue WHERE id IN (SELECT id FROM work_queue ORDER BY id LIMIT ?" + lock + ") RETURNING id, user_id"
+
+		rows, err := tx.QueryContext(ctx, s.rebind(query), limit)
+		if err != nil {
+			slog.Error("Error claiming work queue entries", "error", err)
+			return nil, err
+		}
+		defer rows.Close()
+
+		var entries []queueEntry
+		for rows.Next() {
+			var e queueEntry
+			if err := rows.Scan(&e.id, &e.userID); err != nil {
+				slog.Error("Error scanning work queue row", "error", err)
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			slog.Error("Error iterating over work queue rows", "error", err)
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+		return entries, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, s.rebind("SELECT id, user_id FROM work_queue ORDER BY id LIMIT ?"), limit)
+	if err != nil {
+		slog.Error("Error querying work queue", "error", err)
+		return nil, err
+	}
+	var candidates []queueEntry
+	for rows.Next() {
+		var e queueEntry
+		if err := rows.Scan(&e.id, &e.userID); err != nil {
+			rows.Close()
+			slog.Error("Error scanning work queue row", "error", err)
+			return nil, err
+		}
+		candidates = append(candidates, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over work queue rows", "error", err)
+		return nil, err
+	}
+
+	var entries []queueEntry
+	for _, e := range candidates {
+		res, err := tx.ExecContext(ctx, s.rebind("DELETE FROM work_queue WHERE id = ?"), e.id)
+		if err != nil {
+			slog.Error("Error claiming work queue entry", "entry_id", e.id, "error", err)
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// usersByID reads the users with the given IDs that still exist, through q.
+func (s *DBStore) usersByID(ctx context.Context, q execQueryer, ids []int) (map[int]User, error) {
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	query := "SELECT " + userColumns + " FROM users WHERE id IN (" + strings.Join(placeholders, ", ") + ")"
+
+	rows, err := q.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying users by ID", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]User, len(ids))
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)); err != nil {
+			slog.Error("Error scanning user row", "error", err)
+			continue
+		}
+		byID[user.ID] = user
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over user rows", "error", err)
+		return nil, err
+	}
+	return byID, nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_EnqueueUser(t *testing.T) {
+	ctx := context.Background()
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO work_queue (user_id) VALUES (?)")).
+		WithArgs(101).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	store := &DBStore{db: db}
+
+	if err := store.EnqueueUser(ctx, 101); err != nil {
+		t.Fatalf("Error calling EnqueueUser: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ClaimQueuedUsers(t *testing.T) {
+	expectedUsers := []User{
+		{ID: 201, MailboxID: 2, UserName: "user3", EmailAddress: "user3@example.com", CreatedAt: ts("2024-07-23 13:15:00")},
+		{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+	}
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(201, 2, "user3", "user3@example.com", "2024-07-23 13:15:00", nil)
+	}
+
+	tests := []struct {
+		name   string
+		driver string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "DeleteReturning",
+			driver: "pgx",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM work_queue WHERE id IN (SELECT id FROM work_queue ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED) RETURNING id, user_id")).
+					WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(3, 404).AddRow(2, 101).AddRow(1, 201))
+				mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id IN ($1, $2, $3)")).
+					WithArgs(201, 101, 404).
+					WillReturnRows(userRows())
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "DeletePerEntry",
+			driver: "mysql",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id FROM work_queue ORDER BY id LIMIT ?")).
+					WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(1, 201).AddRow(2, 101).AddRow(3, 102).AddRow(4, 404))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE id = ?")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE id = ?")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+				// Claimed by another watcher meanwhile.
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id IN (?, ?, ?)")).
+					WithArgs(201, 101, 404).
+					WillReturnRows(userRows())
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+			tt.expect(mock)
+
+			store := &DBStore{db: db, driver: tt.driver}
+
+			users, err := store.ClaimQueuedUsers(context.Background(), 10)
+			if err != nil {
+				t.Fatalf("Error calling ClaimQueuedUsers: %v", err)
+			}
+			if !reflect.DeepEqual(users, expectedUsers) {
+				t.Errorf("Expected users %v, got %v", expectedUsers, users)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_ClaimQueuedUsers_LookupError(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM work_queue")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(1, 101))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id IN ($1)")).WillReturnError(errors.New("connection reset"))
+	// The claim is rolled back, so the entry stays queued.
+	mock.ExpectRollback()
+
+	store := &DBStore{db: db, driver: "pgx"}
+
+	if _, err := store.ClaimQueuedUsers(context.Background(), 10); err == nil {
+		t.Errorf("Expected an error when the users cannot be read")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ClaimQueuedUsersConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := migratedStore(t)
+	created, err := store.CreateUsersBatch(ctx, batchOfUsers(50, 0))
+	if err != nil {
+		t.Fatalf("Error creating users: %v", err)
+	}
+	for _, user := range created {
+		if err := store.EnqueueUser(ctx, user.ID); err != nil {
+			t.Fatalf("Error enqueueing user %d: %v", user.ID, err)
+		}
+	}
+	// An entry for a user deleted since it was queued.
+	if err := store.EnqueueUser(ctx, 9999); err != nil {
+		t.Fatalf("Error enqueueing user 9999: %v", err)
+	}
+
+	var mu sync.Mutex
+	claimed := map[int]int{}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				users, err := store.ClaimQueuedUsers(ctx, 7)
+				if err != nil {
+					t.Errorf("Error claiming queued users: %v", err)
+					return
+				}
+				if len(users) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, user := range users {
+					claimed[user.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != len(created) {
+		t.Errorf("Expected %d users claimed, got %d", len(created), len(claimed))
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("Expected user %d claimed once, got %d", id, n)
+		}
+	}
+	var left int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM work_queue").Scan(&left); err != nil || left != 0 {
+		t.Errorf("Expected an empty work queue, got %d entries (%v)", left, err)
+	}
+}
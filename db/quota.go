@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrQuotaExceeded matches every *QuotaError with errors.Is.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError is returned by writes that would take a tenant past one of its
+// quotas. MailboxID is set for the max_users_per_mailbox quota.
+type QuotaError struct {
+	Tenant    string
+	Quota     string
+	Limit     int
+	MailboxID int
+}
+
+func (e *QuotaError) Error() string {
+	tenant := e.Tenant
+	if tenant == "" {
+		tenant = "(none)"
+	}
+	if e.MailboxID != 0 {
+		return fmt.Sprintf("%v: mailbox %d of tenant %s already has %d users (%s)", ErrQuotaExceeded, e.MailboxID, tenant, e.Limit, e.Quota)
+	}
+	return fmt.Sprintf("%v: tenant %s already has %d mailboxes (%s)", ErrQuotaExceeded, tenant, e.Limit, e.Quota)
+}
+
+func (e *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// SetQuotas sets the quotas that mailbox and user creation are held to.
+func (s *DBStore) SetQuotas(q Quotas) {
+	s.quotas = q
+}
+
+// checkMailboxQuota fails with a *QuotaError if tenant already has its
+// maximum of mailboxes.
+func (s *DBStore) checkMailboxQuota(ctx context.Context, q execQueryer, tenant string) error {
+	limit := s.quotas.For(tenant).MaxMailboxes
+	if limit <= 0 {
+		return nil
+	}
+
+	var n int
+	if err := q.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM mailbox_tenants WHERE tenant = ?"), tenant).Scan(&n); err != nil {
+		slog.Error("Error counting mailboxes of tenant", "tenant", tenant, "error", err)
+		return err
+	}
+	if n >= limit {
+		return &QuotaError{Tenant: tenant, Quota: "max_mailboxes", Limit: limit}
+	}
+	return nil
+}
+
+// checkUserQuota fails with a *QuotaError if mailboxID already has the
+// maximum of users its tenant allows. Without quotas it does not query.
+func (s *DBStore) checkUserQuota(ctx context.Context, q execQueryer, mailboxID int) error {
+	return s.checkUserQuotaFor(ctx, q, mailboxID, 1)
+}
+
+// checkUserQuotaFor fails with a *QuotaError if adding more users to
+// mailboxID would take it past the maximum its tenant allows.
+func (s *DBStore) checkUserQuotaFor(ctx context.Context, q execQueryer, mailboxID, adding int) error {
+	if !s.quotas.enforced() {
+		return nil
+	}
+
+	tenant, err := s.mailboxTenant(ctx, q, mailboxID)
+	if err != nil {
+		return err
+	}
+	limit := s.quotas.For(tenant).MaxUsersPerMailbox
+	if limit <= 0 {
+		return nil
+	}
+
+	var n int
+	if err := q.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM users WHERE mailbox_id = ?"), mailboxID).Scan(&n); err != nil {
+		slog.Error("Error counting users for mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	if n+adding > limit {
+		return &QuotaError{Tenant: tenant, Quota: "max_users_per_mailbox", Limit: limit, MailboxID: mailboxID}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"mailboxes/scope"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_CreateMailboxQuota(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	countMailboxes := regexp.QuoteMeta("SELECT COUNT(*) FROM mailbox_tenants WHERE tenant = ?")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(countMailboxes).WithArgs("acme").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id")).
+		WithArgs("mpi789", "token789", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_tenants (mailbox_id, tenant) VALUES (?, ?)")).
+		WithArgs(3, "acme").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(countMailboxes).WithArgs("acme").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	store.SetQuotas(Quotas{Default: Quota{MaxMailboxes: 2}})
+	ctx := scope.WithTenant(context.Background(), "acme")
+
+	mb, err := store.CreateMailbox(ctx, Mailbox{MPIID: "mpi789", Token: "token789"})
+	if err != nil {
+		t.Fatalf("Error creating mailbox: %v", err)
+	}
+	if mb.ID != 3 {
+		t.Errorf("Expected mailbox 3, got %d", mb.ID)
+	}
+
+	_, err = store.CreateMailbox(ctx, Mailbox{MPIID: "mpi790"})
+	var qe *QuotaError
+	if !errors.As(err, &qe) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected a quota error, got %v", err)
+	}
+	if qe.Tenant != "acme" || qe.Quota != "max_mailboxes" || qe.Limit != 2 {
+		t.Errorf("Unexpected quota error %+v", qe)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_CreateUserQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenant   string
+		users    int
+		expected error
+	}{
+		{name: "Under tenant quota", tenant: "acme", users: 4},
+		{name: "At tenant quota", tenant: "acme", users: 5, expected: ErrQuotaExceeded},
+		{name: "At default quota", users: 1, expected: ErrQuotaExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tenants := sqlmock.NewRows([]string{"tenant"})
+			if tt.tenant != "" {
+				tenants.AddRow(tt.tenant)
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = ?")).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT tenant FROM mailbox_tenants WHERE mailbox_id = ?")).WithArgs(1).
+				WillReturnRows(tenants)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE mailbox_id = ?")).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.users))
+			if tt.expected == nil {
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (mailbox_id, user_name, email_address, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING id")).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(103))
+				expectChanges(mock, diffUser(nil, &User{ID: 103, MailboxID: 1, UserName: "user4", EmailAddress: "user4@example.com"})...)
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+			store.SetQuotas(Quotas{
+				Default: Quota{MaxUsersPerMailbox: 1},
+				Tenants: map[string]Quota{"acme": {MaxUsersPerMailbox: 5}},
+			})
+			_, err := store.CreateUser(context.Background(), User{MailboxID: 1, UserName: "user4", EmailAddress: "user4@example.com"})
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Expected error %v, got %v", tt.expected, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// reassignBatchSize is how many users each ReassignUsers transaction moves.
+const reassignBatchSize = 500
+
+// ReassignUsers moves users between mailboxes in batches of
+// reassignBatchSize, one transaction per batch, so a large move never holds
+// locks for long. A failed batch is rolled back; earlier batches stay moved.
+// Each move is recorded in mailbox_moves and user_changes.
+func (s *DBStore) ReassignUsers(ctx context.Context, fromMailbox, toMailbox int, filter func(User) bool) (int, error) {
+	if fromMailbox == toMailbox {
+		return 0, fmt.Errorf("cannot move users from mailbox %d to itself", fromMailbox)
+	}
+	for _, id := range []int{fromMailbox, toMailbox} {
+		var found int
+		err := s.db.QueryRowContext(ctx, s.rebind("SELECT id FROM mailboxes WHERE id = ?"), id).Scan(&found)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: %d", ErrMailboxNotFound, id)
+		}
+		if err != nil {
+			slog.Error("Error looking up mailbox", "mailbox_id", id, "error", err)
+			return 0, err
+		}
+	}
+
+	moved, afterID := 0, 0
+	for {
+		n, lastID, err := s.reassignBatch(ctx, fromMailbox, toMailbox, afterID, filter)
+		moved += n
+		if err != nil || lastID == 0 {
+			return moved, err
+		}
+		afterID = lastID
+	}
+}
+
+// reassignBatch moves the accepted users among the next batch of
+// fromMailbox's users after afterID. It returns the last ID examined, or 0
+// once there are no users left.
+func (s *DBStore) reassignBatch(ctx context.Context, fromMailbox, toMailbox, afterID int, filter func(User) bool) (int, int, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE mailbox_id = ? AND id > ? ORDER BY id LIMIT ?"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting reassign transaction", "error", err)
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.rebind(query), fromMailbox, afterID, reassignBatchSize)
+	if err != nil {
+		slog.Error("Error querying users for mailbox", "mailbox_id", fromMailbox, "error", err)
+		return 0, 0, err
+	}
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)); err != nil {
+			slog.Error("Error scanning user row", "error", err)
+			continue
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over user rows", "error", err)
+		return 0, 0, err
+	}
+	if len(users) == 0 {
+		return 0, 0, nil
+	}
+
+	moved, movedAt := 0, now()
+	updatedAt := FormatTimestamp(movedAt)
+	for _, user := range users {
+		if filter != nil && !filter(user) {
+			continue
+		}
+
+		// The mailbox_id guard skips users moved elsewhere since the select.
+		key, keyArgs := s.userKey(user)
+		query := "UPDATE users SET mailbox_id = ?, updated_at = ? WHERE " + key + " AND mailbox_id = ?"
+		res, err := tx.ExecContext(ctx, s.rebind(query), append(append([]any{toMailbox, updatedAt}, keyArgs...), fromMailbox)...)
+		if err != nil {
+			slog.Error("Error moving user", "user_id", user.ID, "error", err)
+			return 0, 0, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			continue
+		}
+
+		query = "INSERT INTO mailbox_moves (user_id, from_mailbox_id, to_mailbox_id) VALUES (?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, s.rebind(query), user.ID, fromMailbox, toMailbox); err != nil {
+			slog.Error("Error recording move of user", "user_id", user.ID, "error", err)
+			return 0, 0, err
+		}
+
+		after := user
+		after.MailboxID, after.UpdatedAt = toMailbox, movedAt
+		if err := s.recordChanges(ctx, tx, diffUser(&user, &after)); err != nil {
+			return 0, 0, err
+		}
+		if err := s.logAudit(ctx, tx, ChangeUpdate, AuditUser, user.ID, &user, &after); err != nil {
+			return 0, 0, err
+		}
+		moved++
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing reassign batch", "error", err)
+		return 0, 0, err
+	}
+
+	return moved, users[len(users)-1].ID, nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_ReassignUsers(t *testing.T) {
+	mailboxQuery := regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = ?")
+	usersQuery := regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id = ? AND id > ? ORDER BY id LIMIT ?")
+	updateQuery := regexp.QuoteMeta("UPDATE users SET mailbox_id = ?, updated_at = ? WHERE id = ? AND mailbox_id = ?")
+	auditQuery := regexp.QuoteMeta("INSERT INTO mailbox_moves (user_id, from_mailbox_id, to_mailbox_id) VALUES (?, ?, ?)")
+	userRows := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+
+	tests := []struct {
+		name          string
+		filter        func(User) bool
+		mockSetup     func(mock sqlmock.Sqlmock)
+		expectedMoved int
+		expectedErr   error
+	}{
+		{
+			name:   "Moves filtered users",
+			filter: func(u User) bool { return strings.HasSuffix(u.EmailAddress, "@example.com") },
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(mailboxQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery(mailboxQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectBegin()
+				mock.ExpectQuery(usersQuery).
+					WithArgs(1, 0, reassignBatchSize).
+					WillReturnRows(sqlmock.NewRows(userRows).
+						AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+						AddRow(102, 1, "user2", "user2@example.org", "2024-07-23 12:45:00", nil))
+				mock.ExpectExec(updateQuery).WithArgs(2, sqlmock.AnyArg(), 101, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(auditQuery).WithArgs(101, 1, 2).WillReturnResult(sqlmock.NewResult(1, 1))
+				expectChanges(mock, UserChange{UserID: 101, Op: ChangeUpdate, Field: "mailbox_id", Old: "1", New: "2"})
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				mock.ExpectQuery(usersQuery).
+					WithArgs(1, 102, reassignBatchSize).
+					WillReturnRows(sqlmock.NewRows(userRows))
+				mock.ExpectRollback()
+			},
+			expectedMoved: 1,
+		},
+		{
+			name: "Missing destination mailbox",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(mailboxQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery(mailboxQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			expectedErr: ErrMailboxNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db}
+
+			moved, err := store.ReassignUsers(context.Background(), 1, 2, tt.filter)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if moved != tt.expectedMoved {
+				t.Errorf("Expected %d users moved, got %d", tt.expectedMoved, moved)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// ScheduleRetry queues r.User for another attempt at r.NextAttemptAt,
+// replacing any retry already scheduled for the user.
+func (s *DBStore) ScheduleRetry(ctx context.Context, r Retry) error {
+	query := "INSERT INTO retry_queue (user_id, priority, attempts, next_attempt_at, last_error) VALUES (?, ?, ?, ?, ?) " +
+		"ON CONFLICT (user_id) DO UPDATE SET priority = excluded.priority, attempts = excluded.attempts, " +
+		"next_attempt_at = excluded.next_attempt_at, last_error = excluded.last_error"
+
+	next := r.NextAttemptAt.UTC().Format(TimestampLayout)
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), r.User.ID, r.Priority, r.Attempts, next, r.LastError); err != nil {
+		slog.Error("Error scheduling retry of user", "user_id", r.User.ID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// ClaimDueRetries removes up to limit retries due at or before now from the
+// retry queue and returns them, highest priority first and then in order of
+// their due time. A retry is returned only by the call that removed it, so
+// two watchers never attempt the same retry. The retries are claimed and
+// their users read in one transaction, so none is lost if either fails.
+// Retries of users deleted since they failed are removed without being
+// returned.
+func (s *DBStore) ClaimDueRetries(ctx context.Context, now time.Time, limit int) ([]Retry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting retry claim transaction", "error", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	claimed, err := s.claimRetries(ctx, tx, now, limit)
+	if err != nil || len(claimed) == 0 {
+		return nil, err
+	}
+
+	userIDs := make([]int, len(claimed))
+	for i, r := range claimed {
+		userIDs[i] = r.User.ID
+	}
+	byID, err := s.usersByID(ctx, tx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing retry claim", "error", err)
+		return nil, err
+	}
+
+	var retries []Retry
+	for _, r := range claimed {
+		if user, ok := byID[r.User.ID]; ok {
+			r.User = user
+			retries = append(retries, r)
+		}
+	}
+	return retries, nil
+}
+
+// retryColumns are the retry_queue columns scanned by scanRetry.
+const retryColumns = "user_id, priority, attempts, CAST(next_attempt_at AS TEXT), last_error"
+
+// claimRetries deletes up to limit due retries inside tx and returns those
+// it deleted, in claim order. Drivers with RETURNING do so in one statement;
+// PostgreSQL skips retries another watcher has locked rather than waiting
+// for them. Elsewhere each retry is deleted on its own, only if it is still
+// as it was read, so one rescheduled meanwhile stays queued.
+func (s *DBStore) claimRetries(ctx context.Context, tx *sql.Tx, now time.Time, limit int) ([]Retry, error) {
+	order := " ORDER BY priority DESC, next_attempt_at, user_id LIMIT ?"
+	if returningDrivers[s.driver] {
+		lock := ""
+		if s.isPostgres() {
+			lock = " FOR UPDATE SKIP LOCKED"
+		}
+		query := "DELETE FROM retry_queue WHERE user_id IN (SELECT user_id FROM retry_queue WHERE next_attempt_at <= ?" + order + lock + ") " +
+			"RETURNING " + retryColumns
+
+		rows, err := tx.QueryContext(ctx, s.rebind(query), now.UTC().Format(TimestampLayout), limit)
+		if err != nil {
+			slog.Error("Error claiming retries", "error", err)
+			return nil, err
+		}
+		due, _, err := scanRetries(rows)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(due, func(i, j int) bool {
+			a, b := due[i], due[j]
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			if !a.NextAttemptAt.Equal(b.NextAttemptAt) {
+				return a.NextAttemptAt.Before(b.NextAttemptAt)
+			}
+			return a.User.ID < b.User.ID
+		})
+		return due, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, s.rebind("SELECT "+retryColumns+" FROM retry_queue WHERE next_attempt_at <= ?"+order), now.UTC().Format(TimestampLayout), limit)
+	if err != nil {
+		slog.Error("Error querying retry queue", "error", err)
+		return nil, err
+	}
+	due, nexts, err := scanRetries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []Retry
+	for i, r := range due {
+		query := "DELETE FROM retry_queue WHERE user_id = ? AND attempts = ? AND CAST(next_attempt_at AS TEXT) = ?"
+		res, err := tx.ExecContext(ctx, s.rebind(query), r.User.ID, r.Attempts, nexts[i])
+		if err != nil {
+			slog.Error("Error claiming retry of user", "user_id", r.User.ID, "error", err)
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			claimed = append(claimed, r)
+		}
+	}
+	return claimed, nil
+}
+
+// scanRetries reads and closes rows of retryColumns, returning each retry
+// with its next_attempt_at as read.
+func scanRetries(rows *sql.Rows) ([]Retry, []string, error) {
+	defer rows.Close()
+
+	var due []Retry
+	var nexts []string
+	for rows.Next() {
+		var r Retry
+		var next string
+		if err := rows.Scan(&r.User.ID, &r.Priority, &r.Attempts, &next, &r.LastError); err != nil {
+			slog.Error("Error scanning retry queue row", "error", err)
+			return nil, nil, err
+		}
+		r.NextAttemptAt, _ = time.Parse(TimestampLayout, sqlTimestamp(next))
+		due = append(due, r)
+		nexts = append(nexts, next)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over retry queue rows", "error", err)
+		return nil, nil, err
+	}
+	return due, nexts, nil
+}
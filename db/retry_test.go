@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_ScheduleRetry(t *testing.T) {
+	ctx := context.Background()
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO retry_queue (user_id, priority, attempts, next_attempt_at, last_error) VALUES (?, ?, ?, ?, ?) "+
+		"ON CONFLICT (user_id) DO UPDATE SET priority = excluded.priority, attempts = excluded.attempts, "+
+		"next_attempt_at = excluded.next_attempt_at, last_error = excluded.last_error")).
+		WithArgs(101, 1, 2, "2024-07-24 09:01:00", "sink unavailable").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	store := &DBStore{db: db}
+
+	next := time.Date(2024, 7, 24, 9, 1, 0, 0, time.UTC)
+	if err := store.ScheduleRetry(ctx, Retry{User: User{ID: 101}, Priority: 1, Attempts: 2, NextAttemptAt: next, LastError: "sink unavailable"}); err != nil {
+		t.Fatalf("Error calling ScheduleRetry: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ClaimDueRetries(t *testing.T) {
+	ctx := context.Background()
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id, priority, attempts, CAST(next_attempt_at AS TEXT), last_error FROM retry_queue "+
+		"WHERE next_attempt_at <= ? ORDER BY priority DESC, next_attempt_at, user_id LIMIT ?")).
+		WithArgs("2024-07-24 09:05:00", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "priority", "attempts", "next_attempt_at", "last_error"}).
+			AddRow(101, 1, 2, "2024-07-24 09:01:00", "sink unavailable").
+			AddRow(102, 0, 1, "2024-07-24 09:02:00", "sink unavailable").
+			AddRow(404, 0, 1, "2024-07-24 09:03:00", "sink unavailable"))
+	claim := regexp.QuoteMeta("DELETE FROM retry_queue WHERE user_id = ? AND attempts = ? AND CAST(next_attempt_at AS TEXT) = ?")
+	mock.ExpectExec(claim).WithArgs(101, 2, "2024-07-24 09:01:00").WillReturnResult(sqlmock.NewResult(0, 1))
+	// Claimed by another watcher, or rescheduled, meanwhile.
+	mock.ExpectExec(claim).WithArgs(102, 1, "2024-07-24 09:02:00").WillReturnResult(sqlmock.NewResult(0, 0))
+	// The user has since been deleted.
+	mock.ExpectExec(claim).WithArgs(404, 1, "2024-07-24 09:03:00").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id IN (?, ?)")).
+		WithArgs(101, 404).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db}
+
+	retries, err := store.ClaimDueRetries(ctx, time.Date(2024, 7, 24, 9, 5, 0, 0, time.UTC), 10)
+	if err != nil {
+		t.Fatalf("Error calling ClaimDueRetries: %v", err)
+	}
+
+	expected := []Retry{{
+		User:          User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+		Priority:      1,
+		Attempts:      2,
+		NextAttemptAt: time.Date(2024, 7, 24, 9, 1, 0, 0, time.UTC),
+		LastError:     "sink unavailable",
+	}}
+	if !reflect.DeepEqual(retries, expected) {
+		t.Errorf("Expected retries %v, got %v", expected, retries)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ClaimDueRetries_Returning(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM retry_queue WHERE user_id IN (SELECT user_id FROM retry_queue WHERE next_attempt_at <= $1 "+
+		"ORDER BY priority DESC, next_attempt_at, user_id LIMIT $2 FOR UPDATE SKIP LOCKED) "+
+		"RETURNING user_id, priority, attempts, CAST(next_attempt_at AS TEXT), last_error")).
+		WithArgs("2024-07-24 09:05:00", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "priority", "attempts", "next_attempt_at", "last_error"}).
+			AddRow(102, 0, 1, "2024-07-24 09:02:00", "sink unavailable").
+			AddRow(101, 1, 2, "2024-07-24 09:03:00", "sink unavailable"))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id IN ($1, $2)")).
+		WithArgs(101, 102).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:30:00", nil))
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db, driver: "pgx"}
+
+	retries, err := store.ClaimDueRetries(context.Background(), time.Date(2024, 7, 24, 9, 5, 0, 0, time.UTC), 10)
+	if err != nil {
+		t.Fatalf("Error calling ClaimDueRetries: %v", err)
+	}
+	// Highest priority first, whatever order they were deleted in.
+	if len(retries) != 2 || retries[0].User.ID != 101 || retries[1].User.ID != 102 {
+		t.Errorf("Expected the retries of users 101 and 102, got %+v", retries)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ClaimDueRetries_LookupError(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM retry_queue")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "priority", "attempts", "next_attempt_at", "last_error"}).
+			AddRow(101, 0, 1, "2024-07-24 09:01:00", "sink unavailable"))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM retry_queue")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id IN (?)")).WillReturnError(errors.New("connection reset"))
+	// The claim is rolled back, so the retry stays queued.
+	mock.ExpectRollback()
+
+	store := &DBStore{db: db}
+
+	if _, err := store.ClaimDueRetries(context.Background(), time.Date(2024, 7, 24, 9, 5, 0, 0, time.UTC), 10); err == nil {
+		t.Errorf("Expected an error when the users cannot be read")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_ClaimDueRetries_SQLite(t *testing.T) {
+	store := migratedStore(t)
+	ctx := context.Background()
+	user, err := store.CreateUser(ctx, User{MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com"})
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	due := time.Date(2024, 7, 24, 9, 1, 0, 0, time.UTC)
+	if err := store.ScheduleRetry(ctx, Retry{User: user, Attempts: 1, NextAttemptAt: due, LastError: "sink unavailable"}); err != nil {
+		t.Fatalf("Error scheduling retry: %v", err)
+	}
+
+	retries, err := store.ClaimDueRetries(ctx, due, 10)
+	if err != nil || len(retries) != 1 || retries[0].User.ID != user.ID || !retries[0].NextAttemptAt.Equal(due) {
+		t.Fatalf("Expected the retry of user %d due at %s, got %+v, %v", user.ID, due, retries, err)
+	}
+	if retries, err := store.ClaimDueRetries(ctx, due, 10); err != nil || len(retries) != 0 {
+		t.Errorf("Expected the retry to be claimed once, got %+v, %v", retries, err)
+	}
+}
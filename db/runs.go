@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+)
+
+// RecordRun stores a finished run in the runs table, its annotations as a
+// JSON object, and returns it with its ID.
+func (s *DBStore) RecordRun(ctx context.Context, run Run) (Run, error) {
+	var annotations sql.NullString
+	if len(run.Annotations) > 0 {
+		data, err := json.Marshal(run.Annotations)
+		if err != nil {
+			return Run{}, err
+		}
+		annotations = sql.NullString{String: string(data), Valid: true}
+	}
+
+	query := "INSERT INTO runs (started_at, finished_at, status, annotations) VALUES (?, ?, ?, ?)"
+	id, err := s.insertID(ctx, s.db, query, FormatTimestamp(run.StartedAt), FormatTimestamp(run.FinishedAt), run.Status, annotations)
+	if err != nil {
+		slog.Error("Error recording run", "error", err)
+		return Run{}, err
+	}
+	run.ID = id
+	return run, nil
+}
+
+// RecentRuns returns the n latest runs, newest first.
+func (s *DBStore) RecentRuns(ctx context.Context, n int) ([]Run, error) {
+	query := "SELECT id, started_at, finished_at, status, annotations FROM runs ORDER BY id DESC LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), n)
+	if err != nil {
+		slog.Error("Error querying runs", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		var annotations sql.NullString
+		if err := rows.Scan(&run.ID, scanTime(&run.StartedAt), scanTime(&run.FinishedAt), &run.Status, &annotations); err != nil {
+			slog.Error("Error scanning run row", "error", err)
+			return nil, err
+		}
+		if annotations.Valid {
+			if err := json.Unmarshal([]byte(annotations.String), &run.Annotations); err != nil {
+				slog.Error("Error decoding annotations of run", "run_id", run.ID, "error", err)
+				return nil, err
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// RecordRunFailures stores failures in the run_failures table. A mailbox or
+// user recorded twice keeps its first error.
+func (s *DBStore) RecordRunFailures(ctx context.Context, runID int, failures []RunFailure) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO run_failures (run_id, mailbox_id, user_id, error) VALUES (?, ?, ?, ?) ON CONFLICT (run_id, mailbox_id, user_id) DO NOTHING"
+	stmt, err := tx.PrepareContext(ctx, s.rebind(query))
+	if err != nil {
+		slog.Error("Error recording failures of run", "run_id", runID, "error", err)
+		return err
+	}
+	defer stmt.Close()
+	for _, f := range failures {
+		if _, err := stmt.ExecContext(ctx, runID, f.MailboxID, f.UserID, f.Error); err != nil {
+			slog.Error("Error recording user failure", "user_id", f.UserID, "mailbox_id", f.MailboxID, "run_id", runID, "error", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RunFailures returns the failures of run runID from the run_failures
+// table.
+func (s *DBStore) RunFailures(ctx context.Context, runID int) ([]RunFailure, error) {
+	query := "SELECT mailbox_id, user_id, error FROM run_failures WHERE run_id = ? ORDER BY mailbox_id, user_id"
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), runID)
+	if err != nil {
+		slog.Error("Error querying failures of run", "run_id", runID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []RunFailure
+	for rows.Next() {
+		var f RunFailure
+		if err := rows.Scan(&f.MailboxID, &f.UserID, &f.Error); err != nil {
+			slog.Error("Error scanning run failure row", "error", err)
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_RecordRun(t *testing.T) {
+	query := regexp.QuoteMeta("INSERT INTO runs (started_at, finished_at, status, annotations) VALUES (?, ?, ?, ?) RETURNING id")
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    any
+	}{
+		{
+			name:        "With annotations",
+			annotations: map[string]string{"campaign_id": "spring", "template_version": "3"},
+			expected:    `{"campaign_id":"spring","template_version":"3"}`,
+		},
+		{
+			name:     "Without annotations",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(query).
+				WithArgs("2024-07-23 12:00:00", "2024-07-23 12:05:00", RunSucceeded, tt.expected).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+			run, err := store.RecordRun(context.Background(), Run{
+				StartedAt:   ts("2024-07-23 12:00:00"),
+				FinishedAt:  ts("2024-07-23 12:05:00"),
+				Status:      RunSucceeded,
+				Annotations: tt.annotations,
+			})
+			if err != nil {
+				t.Fatalf("Error recording run: %v", err)
+			}
+			if run.ID != 7 {
+				t.Errorf("Expected run ID 7, got %d", run.ID)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_RecentRuns(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, started_at, finished_at, status, annotations FROM runs ORDER BY id DESC LIMIT ?")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "started_at", "finished_at", "status", "annotations"}).
+			AddRow(8, "2024-07-24 12:00:00", "2024-07-24 12:01:00", RunFailed, nil).
+			AddRow(7, "2024-07-23 12:00:00", "2024-07-23 12:05:00", RunSucceeded, `{"campaign_id":"spring"}`))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	runs, err := store.RecentRuns(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Error reading runs: %v", err)
+	}
+
+	expected := []Run{
+		{ID: 8, StartedAt: ts("2024-07-24 12:00:00"), FinishedAt: ts("2024-07-24 12:01:00"), Status: RunFailed},
+		{ID: 7, StartedAt: ts("2024-07-23 12:00:00"), FinishedAt: ts("2024-07-23 12:05:00"), Status: RunSucceeded,
+			Annotations: map[string]string{"campaign_id": "spring"}},
+	}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, runs)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_RunFailures(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO run_failures (run_id, mailbox_id, user_id, error) VALUES (?, ?, ?, ?) ON CONFLICT (run_id, mailbox_id, user_id) DO NOTHING"))
+	prep.ExpectExec().WithArgs(7, 1, 101, "user 101: boom").WillReturnResult(sqlmock.NewResult(0, 1))
+	prep.ExpectExec().WithArgs(7, 2, 0, "mailbox 2: retrieving users: gone").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, user_id, error FROM run_failures WHERE run_id = ? ORDER BY mailbox_id, user_id")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "user_id", "error"}).
+			AddRow(1, 101, "user 101: boom").
+			AddRow(2, 0, "mailbox 2: retrieving users: gone"))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	failures := []RunFailure{
+		{MailboxID: 1, UserID: 101, Error: "user 101: boom"},
+		{MailboxID: 2, Error: "mailbox 2: retrieving users: gone"},
+	}
+	if err := store.RecordRunFailures(context.Background(), 7, failures); err != nil {
+		t.Fatalf("Error recording run failures: %v", err)
+	}
+	got, err := store.RunFailures(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("Error reading run failures: %v", err)
+	}
+	if !reflect.DeepEqual(got, failures) {
+		t.Errorf("Expected %+v, got %+v", failures, got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
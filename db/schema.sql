@@ -3,7 +3,8 @@ CREATE TABLE mailboxes (
 		id INTEGER PRIMARY KEY,
 		mpi_id VARCHAR(200),
 		token VARCHAR(200),
-		created_at TIMESTAMP
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP
 );
 
 -- Create users table
@@ -13,18 +14,234 @@ CREATE TABLE users (
 		user_name VARCHAR(200),
 		email_address VARCHAR(200),
 		created_at TIMESTAMP,
+		updated_at TIMESTAMP,
+		FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id)
+);
+
+-- Create watermarks table
+CREATE TABLE watermarks (
+		name VARCHAR(200) PRIMARY KEY,
+		created_at TIMESTAMP,
+		user_id INTEGER
+);
+
+-- Create work_queue table
+CREATE TABLE work_queue (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER,
+		enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Create retry_queue table
+CREATE TABLE retry_queue (
+		user_id INTEGER PRIMARY KEY,
+		priority INTEGER,
+		attempts INTEGER,
+		next_attempt_at TIMESTAMP,
+		last_error VARCHAR(500),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Create mailbox_settings table
+CREATE TABLE mailbox_settings (
+		mailbox_id INTEGER,
+		name VARCHAR(200),
+		value VARCHAR(200),
+		PRIMARY KEY (mailbox_id, name),
 		FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id)
 );
 
+-- Create user_merges table
+CREATE TABLE user_merges (
+		user_id INTEGER,
+		merged_into INTEGER,
+		mailbox_id INTEGER,
+		user_name VARCHAR(200),
+		email_address VARCHAR(200),
+		created_at TIMESTAMP,
+		merged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (merged_into) REFERENCES users(id)
+);
+
+-- Create mailbox_moves table
+CREATE TABLE mailbox_moves (
+		user_id INTEGER,
+		from_mailbox_id INTEGER,
+		to_mailbox_id INTEGER,
+		moved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Create idempotency_keys table
+CREATE TABLE idempotency_keys (
+		idempotency_key VARCHAR(300) PRIMARY KEY,
+		fingerprint VARCHAR(64),
+		status INTEGER,
+		content_type VARCHAR(200),
+		headers TEXT,
+		body TEXT,
+		created_at TIMESTAMP
+);
+
+-- Create user_changes table
+CREATE TABLE user_changes (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER,
+		op VARCHAR(10),
+		field VARCHAR(50),
+		old_value VARCHAR(200),
+		new_value VARCHAR(200),
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create processed_users table
+CREATE TABLE processed_users (
+		mailbox_id INTEGER,
+		user_id INTEGER,
+		processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (mailbox_id, user_id)
+);
+
+-- Create mailbox_access table
+CREATE TABLE mailbox_access (
+		mailbox_id INTEGER PRIMARY KEY,
+		hits INTEGER,
+		last_accessed_at TIMESTAMP
+);
+
+-- Create runs table
+CREATE TABLE runs (
+		id INTEGER PRIMARY KEY,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		status VARCHAR(20),
+		annotations TEXT
+);
+
+-- Create checkpoints table. A user_id of 0 marks the whole mailbox done.
+CREATE TABLE checkpoints (
+		mailbox_id INTEGER,
+		user_id INTEGER,
+		PRIMARY KEY (mailbox_id, user_id)
+);
+
+-- Create mailbox_events table
+CREATE TABLE mailbox_events (
+		id INTEGER PRIMARY KEY,
+		mailbox_id INTEGER,
+		kind VARCHAR(20),
+		data TEXT,
+		occurred_at TIMESTAMP
+);
+CREATE INDEX idx_mailbox_events_mailbox_id ON mailbox_events (mailbox_id, id);
+
+-- Create mailbox_tenants table
+CREATE TABLE mailbox_tenants (
+		mailbox_id INTEGER PRIMARY KEY,
+		tenant VARCHAR(100)
+);
+CREATE INDEX idx_mailbox_tenants_tenant ON mailbox_tenants (tenant);
+
+-- Create mailbox_summary table
+CREATE TABLE mailbox_summary (
+		mailbox_id INTEGER PRIMARY KEY,
+		health_score INTEGER,
+		token_valid BOOLEAN,
+		verification VARCHAR(20),
+		failure_rate REAL,
+		last_processed_at TIMESTAMP,
+		reasons TEXT,
+		computed_at TIMESTAMP
+);
+CREATE INDEX idx_mailbox_summary_health_score ON mailbox_summary (health_score, mailbox_id);
+
+-- Create run_leases table
+CREATE TABLE run_leases (
+		name VARCHAR(100) PRIMARY KEY,
+		holder VARCHAR(200),
+		acquired_at TIMESTAMP,
+		expires_at TIMESTAMP
+);
+
+-- Create run_failures table. A user_id of 0 marks the whole mailbox failed.
+CREATE TABLE run_failures (
+		run_id INTEGER,
+		mailbox_id INTEGER,
+		user_id INTEGER,
+		error TEXT,
+		PRIMARY KEY (run_id, mailbox_id, user_id)
+);
+
+-- Create audit_log table
+CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY,
+		actor VARCHAR(200),
+		action VARCHAR(20),
+		entity VARCHAR(20),
+		entity_id INTEGER,
+		before_json TEXT,
+		after_json TEXT,
+		occurred_at TIMESTAMP
+);
+CREATE INDEX idx_audit_log_occurred_at ON audit_log (occurred_at);
+
+-- Create user_provenance table, recording the ingestion path each user
+-- was created through
+CREATE TABLE user_provenance (
+		user_id INTEGER PRIMARY KEY,
+		source VARCHAR(200),
+		recorded_at TIMESTAMP
+);
+
+-- Create processor_state table, holding the active processor configuration
+-- every process switches to
+CREATE TABLE processor_state (
+		name VARCHAR(100) PRIMARY KEY,
+		active VARCHAR(200),
+		previous VARCHAR(200),
+		updated_at TIMESTAMP
+);
+
+-- Create schema_migrations table, recording the migrations this schema
+-- already includes so migrate up skips them
+CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(200),
+		applied_at TIMESTAMP
+);
+
+INSERT INTO schema_migrations (version, name, applied_at)
+VALUES
+		(1, 'initial', CURRENT_TIMESTAMP),
+		(2, 'processed_users', CURRENT_TIMESTAMP),
+		(3, 'mailbox_access', CURRENT_TIMESTAMP),
+		(4, 'updated_at', CURRENT_TIMESTAMP),
+		(5, 'token_text', CURRENT_TIMESTAMP),
+		(6, 'runs', CURRENT_TIMESTAMP),
+		(7, 'checkpoints', CURRENT_TIMESTAMP),
+		(8, 'mailbox_events', CURRENT_TIMESTAMP),
+		(9, 'mailbox_tenants', CURRENT_TIMESTAMP),
+		(10, 'mailbox_summary', CURRENT_TIMESTAMP),
+		(11, 'run_leases', CURRENT_TIMESTAMP),
+		(12, 'run_failures', CURRENT_TIMESTAMP),
+		(13, 'audit_log', CURRENT_TIMESTAMP),
+		(14, 'user_provenance', CURRENT_TIMESTAMP),
+		(15, 'idempotency_headers', CURRENT_TIMESTAMP),
+		(16, 'partition_users', CURRENT_TIMESTAMP),
+		(17, 'setting_value_text', CURRENT_TIMESTAMP),
+		(18, 'processor_state', CURRENT_TIMESTAMP),
+		(19, 'user_ids', CURRENT_TIMESTAMP);
+
 -- Insert sample data into mailboxes table
-INSERT INTO mailboxes (id, mpi_id, token, created_at)
+INSERT INTO mailboxes (id, mpi_id, token, created_at, updated_at)
 VALUES
-		(1, 'mpi123', 'token123', '2024-07-23 12:00:00'),
-		(2, 'mpi456', 'token456', '2024-07-23 13:00:00');
+		(1, 'mpi123', 'token123', '2024-07-23 12:00:00', '2024-07-23 12:00:00'),
+		(2, 'mpi456', 'token456', '2024-07-23 13:00:00', '2024-07-23 13:00:00');
 
 -- Insert sample data into users table
-INSERT INTO users (id, mailbox_id, user_name, email_address, created_at)
+INSERT INTO users (id, mailbox_id, user_name, email_address, created_at, updated_at)
 VALUES
-		(101, 1, 'user1', 'user1@example.com', '2024-07-23 12:30:00'),
-		(102, 1, 'user2', 'user2@example.com', '2024-07-23 12:45:00'),
-		(201, 2, 'user3', 'user3@example.com', '2024-07-23 13:15:00');
+		(101, 1, 'user1', 'user1@example.com', '2024-07-23 12:30:00', '2024-07-23 12:30:00'),
+		(102, 1, 'user2', 'user2@example.com', '2024-07-23 12:45:00', '2024-07-23 12:45:00'),
+		(201, 2, 'user3', 'user3@example.com', '2024-07-23 13:15:00', '2024-07-23 13:15:00');
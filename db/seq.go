@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"iter"
+	"strings"
+)
+
+// MailboxSeq iterates over every mailbox in ID order, or in a context scoped
+// to a tenant over the tenant's.
+func (s *DBStore) MailboxSeq(ctx context.Context) iter.Seq2[Mailbox, error] {
+	query := "SELECT " + mailboxColumns + " FROM mailboxes"
+	cond, args := tenantScope(ctx, "id")
+	if cond != "" {
+		query += " WHERE " + strings.TrimPrefix(cond, " AND ")
+	}
+	query = s.rebind(query + " ORDER BY id")
+	return func(yield func(Mailbox, error) bool) {
+		var mb Mailbox
+		dest := []any{&mb.ID, &mb.MPIID, s.scanToken(&mb.Token), scanTime(&mb.CreatedAt), scanTime(&mb.UpdatedAt)}
+		yieldRows(ctx, s, query, args, dest, func() bool { return yield(mb, nil) }, func(err error) { yield(Mailbox{}, err) })
+	}
+}
+
+// UserSeq iterates over the users of mailboxID in ID order. In a context
+// scoped to a tenant, a mailbox of another tenant has none.
+func (s *DBStore) UserSeq(ctx context.Context, mailboxID int) iter.Seq2[User, error] {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := s.rebind("SELECT " + userColumns + " FROM users WHERE mailbox_id = ?" + cond + " ORDER BY id")
+	args = append([]any{mailboxID}, args...)
+	return func(yield func(User, error) bool) {
+		var user User
+		dest := []any{&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)}
+		yieldRows(ctx, s, query, args, dest, func() bool { return yield(user, nil) }, func(err error) { yield(User{}, err) })
+	}
+}
+
+// yieldRows runs query and scans each row into dest, calling row after
+// each until it returns false. The first error is passed to fail and ends
+// the iteration.
+func yieldRows(ctx context.Context, s *DBStore, query string, args []any, dest []any, row func() bool, fail func(error)) {
+	rows, err := s.queryReads(ctx, query, args...)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			fail(err)
+			return
+		}
+		if !row() {
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fail(err)
+	}
+}
+
+// Mailboxes iterates over the mailboxes of store: lazily if it is a
+// SeqStore, and otherwise by draining AllMailboxes, whose errors past the
+// initial query are only logged.
+func Mailboxes(ctx context.Context, store Store) iter.Seq2[Mailbox, error] {
+	if ss, ok := store.(SeqStore); ok {
+		return ss.MailboxSeq(ctx)
+	}
+	return func(yield func(Mailbox, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		mailboxes, err := store.AllMailboxes(ctx)
+		if err != nil {
+			yield(Mailbox{}, err)
+			return
+		}
+		for mb := range mailboxes {
+			if !yield(mb, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Users iterates over the users of mailboxID in store, as Mailboxes does
+// over mailboxes.
+func Users(ctx context.Context, store Store, mailboxID int) iter.Seq2[User, error] {
+	if ss, ok := store.(SeqStore); ok {
+		return ss.UserSeq(ctx, mailboxID)
+	}
+	return func(yield func(User, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		users, err := store.UsersForMailbox(ctx, mailboxID)
+		if err != nil {
+			yield(User{}, err)
+			return
+		}
+		for user := range users {
+			if !yield(user, nil) {
+				return
+			}
+		}
+	}
+}
+
+// AllUsers iterates over every user of store, as Mailboxes does over
+// mailboxes.
+func AllUsers(ctx context.Context, store FullScanStore) iter.Seq2[User, error] {
+	if ss, ok := store.(FullScanSeqStore); ok {
+		return ss.AllUserSeq(ctx)
+	}
+	return func(yield func(User, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		users, err := store.AllUsers(ctx)
+		if err != nil {
+			yield(User{}, err)
+			return
+		}
+		for user := range users {
+			if !yield(user, nil) {
+				return
+			}
+		}
+	}
+}
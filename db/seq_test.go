@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_MailboxSeq(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	query := regexp.QuoteMeta("SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes ORDER BY id")
+	columns := []string{"id", "mpi_id", "token", "created_at", "updated_at"}
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00", nil).
+		AddRow(2, "mpi456", "token456", "2024-07-23 13:00:00", nil)).RowsWillBeClosed()
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, "mpi123", "token123", "not a time", nil))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+
+	// Breaking out of the loop closes the rows.
+	var mailboxes []Mailbox
+	for mb, err := range store.MailboxSeq(context.Background()) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		mailboxes = append(mailboxes, mb)
+		break
+	}
+	expected := []Mailbox{{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: ts("2024-07-23 12:00:00")}}
+	if !reflect.DeepEqual(mailboxes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, mailboxes)
+	}
+
+	var errs []error
+	for _, err := range store.MailboxSeq(context.Background()) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || errs[0] == nil {
+		t.Errorf("Expected the scan error as the only element, got %v", errs)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_UserSeq(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id = ? ORDER BY id")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", "2024-07-23 12:30:00").
+			AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00", "2024-07-23 12:45:00"))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	var ids []int
+	for user, err := range store.UserSeq(context.Background(), 1) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ids = append(ids, user.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{101, 102}) {
+		t.Errorf("Expected users 101 and 102, got %v", ids)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+// chanStore hides the SeqStore methods of the store it wraps, so that it
+// only streams over channels.
+type chanStore struct {
+	Store
+	err error
+}
+
+func (s chanStore) AllMailboxes(ctx context.Context) (<-chan Mailbox, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.AllMailboxes(ctx)
+}
+
+func TestMailboxes(t *testing.T) {
+	mem := NewMemStore()
+	mem.SeedMailboxes(Mailbox{ID: 1, MPIID: "mpi123"}, Mailbox{ID: 2, MPIID: "mpi456"})
+	mem.SeedUsers(User{ID: 101, MailboxID: 1})
+
+	for name, store := range map[string]Store{"SeqStore": mem, "Store": chanStore{Store: mem}} {
+		t.Run(name, func(t *testing.T) {
+			var ids []int
+			for mb, err := range Mailboxes(context.Background(), store) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				ids = append(ids, mb.ID)
+			}
+			if !reflect.DeepEqual(ids, []int{1, 2}) {
+				t.Errorf("Expected mailboxes 1 and 2, got %v", ids)
+			}
+
+			var users []int
+			for user, err := range Users(context.Background(), store, 1) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				users = append(users, user.ID)
+			}
+			if !reflect.DeepEqual(users, []int{101}) {
+				t.Errorf("Expected user 101, got %v", users)
+			}
+		})
+	}
+
+	failed := errors.New("connection refused")
+	var errs []error
+	for _, err := range Mailboxes(context.Background(), chanStore{Store: mem, err: failed}) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], failed) {
+		t.Errorf("Expected the query error as the only element, got %v", errs)
+	}
+}
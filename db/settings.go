@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// MailboxSettings returns the settings stored for mailboxID in
+// mailbox_settings, by name, opened with the store's keyring. In a context
+// scoped to a tenant, a mailbox of another tenant has none.
+func (s *DBStore) MailboxSettings(ctx context.Context, mailboxID int) (map[string]string, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT name, value FROM mailbox_settings WHERE mailbox_id = ?" + cond
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), append([]any{mailboxID}, args...)...)
+	if err != nil {
+		slog.Error("Error querying settings for mailbox", "mailbox_id", mailboxID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, s.scanToken(&value)); err != nil {
+			slog.Error("Error scanning setting row", "error", err)
+			return nil, err
+		}
+		settings[name] = value
+	}
+	return settings, rows.Err()
+}
+
+// SetMailboxSetting stores value as the setting name of mailboxID,
+// replacing any value it had. Settings hold destinations' credentials, so
+// the value is sealed with the store's keyring as tokens are.
+func (s *DBStore) SetMailboxSetting(ctx context.Context, mailboxID int, name, value string) error {
+	sealed, err := s.tokens.Seal(value)
+	if err != nil {
+		slog.Error("Error sealing setting", "setting", name, "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting settings transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, s.rebind("SELECT id FROM mailboxes WHERE id = ?"), mailboxID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %d", ErrMailboxNotFound, mailboxID)
+	}
+	if err != nil {
+		slog.Error("Error looking up mailbox", "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM mailbox_settings WHERE mailbox_id = ? AND name = ?"), mailboxID, name); err != nil {
+		slog.Error("Error replacing setting", "setting", name, "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	query := "INSERT INTO mailbox_settings (mailbox_id, name, value) VALUES (?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, s.rebind(query), mailboxID, name, sealed); err != nil {
+		slog.Error("Error storing setting", "setting", name, "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing setting", "setting", name, "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteMailboxSetting removes the setting name of mailboxID, if it has
+// one.
+func (s *DBStore) DeleteMailboxSetting(ctx context.Context, mailboxID int, name string) error {
+	if _, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM mailbox_settings WHERE mailbox_id = ? AND name = ?"), mailboxID, name); err != nil {
+		slog.Error("Error deleting setting", "setting", name, "mailbox_id", mailboxID, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_MailboxSettings(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, value FROM mailbox_settings WHERE mailbox_id = ?")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "value"}).AddRow("webhook.url", "https://example.com/hook").AddRow("locale", "en"))
+
+	store := &DBStore{db: db}
+	settings, err := store.MailboxSettings(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Error calling MailboxSettings: %v", err)
+	}
+	expected := map[string]string{"webhook.url": "https://example.com/hook", "locale": "en"}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("Expected %v, got %v", expected, settings)
+	}
+}
+
+func TestDBStore_SetMailboxSetting(t *testing.T) {
+	mailboxQuery := regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = ?")
+
+	t.Run("Set", func(t *testing.T) {
+		db, mock := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(mailboxQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_settings WHERE mailbox_id = ? AND name = ?")).WithArgs(1, "webhook.url").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_settings (mailbox_id, name, value) VALUES (?, ?, ?)")).
+			WithArgs(1, "webhook.url", "https://example.com/hook").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		store := &DBStore{db: db}
+		if err := store.SetMailboxSetting(context.Background(), 1, "webhook.url", "https://example.com/hook"); err != nil {
+			t.Fatalf("Error calling SetMailboxSetting: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Missing mailbox", func(t *testing.T) {
+		db, mock := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(mailboxQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		store := &DBStore{db: db}
+		if err := store.SetMailboxSetting(context.Background(), 9, "webhook.url", "x"); !errors.Is(err, ErrMailboxNotFound) {
+			t.Fatalf("Expected ErrMailboxNotFound, got %v", err)
+		}
+	})
+}
+
+func TestDBStore_SealedSettings(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	sealed, err := testKeyring(t, "old").Seal("Bearer s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = ?")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mailbox_settings WHERE mailbox_id = ? AND name = ?")).WithArgs(1, "webhook.secret").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mailbox_settings (mailbox_id, name, value) VALUES (?, ?, ?)")).
+		WithArgs(1, "webhook.secret", sealedWith("new")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, value FROM mailbox_settings WHERE mailbox_id = ?")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "value"}).AddRow("webhook.authorization", sealed).AddRow("webhook.url", "https://example.com/hook"))
+
+	store := &DBStore{db: db}
+	store.SetTokenKeyring(testKeyring(t, "new"))
+	if err := store.SetMailboxSetting(context.Background(), 1, "webhook.secret", "s3cret"); err != nil {
+		t.Fatalf("Error calling SetMailboxSetting: %v", err)
+	}
+	settings, err := store.MailboxSettings(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Error calling MailboxSettings: %v", err)
+	}
+	// Settings stored before encryption are read as they are.
+	expected := map[string]string{"webhook.authorization": "Bearer s3cret", "webhook.url": "https://example.com/hook"}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("Expected %v, got %v", expected, settings)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"iter"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sqliteBatchSize is how many users the SQLite fast path reads per query.
+const sqliteBatchSize = 1000
+
+// batchUserQuery has SQLite concatenate a whole batch of users into a single
+// text value, fields separated by char(31) and rows by char(30). The driver
+// then crosses into C once per batch instead of once per column per row,
+// which dominated local runs. %s is an optional filter ending in AND.
+const batchUserQuery = "SELECT COALESCE(group_concat(" +
+	"id || char(31) || IFNULL(mailbox_id, '') || char(31) || IFNULL(user_name, '') || char(31) || " +
+	"IFNULL(email_address, '') || char(31) || IFNULL(CAST(created_at AS TEXT), '') || char(31) || " +
+	"IFNULL(CAST(updated_at AS TEXT), ''), char(30) ORDER BY id), '') " +
+	"FROM (SELECT * FROM users WHERE %s id > ? ORDER BY id LIMIT ?)"
+
+const (
+	fieldSeparator  = "\x1f"
+	recordSeparator = "\x1e"
+)
+
+// batchUsers streams the users matching filter in id order, reading them
+// s.batchSize at a time. The first batch is read before returning so query
+// errors surface to the caller, as they do for the row-by-row path.
+func (s *DBStore) batchUsers(ctx context.Context, filter string, args ...any) (<-chan User, error) {
+	users, err := s.userBatch(ctx, filter, args, math.MinInt64)
+	if err != nil {
+		slog.Error("Error querying user batch", "error", err)
+		return nil, err
+	}
+
+	userChannel := make(chan User)
+
+	go func() {
+		defer close(userChannel)
+
+		for {
+			for _, user := range users {
+				select {
+				case userChannel <- user:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if len(users) < s.batchSize {
+				return
+			}
+
+			users, err = s.userBatch(ctx, filter, args, int64(users[len(users)-1].ID))
+			if err != nil {
+				slog.Error("Error querying user batch", "error", err)
+				return
+			}
+		}
+	}()
+
+	return userChannel, nil
+}
+
+// batchUserSeq iterates over the users matching filter as batchUsers
+// streams them, yielding a failed batch query as the last element.
+func (s *DBStore) batchUserSeq(ctx context.Context, filter string, args ...any) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		after := int64(math.MinInt64)
+		for {
+			users, err := s.userBatch(ctx, filter, args, after)
+			if err != nil {
+				yield(User{}, err)
+				return
+			}
+			for _, user := range users {
+				if !yield(user, nil) {
+					return
+				}
+			}
+			if len(users) < s.batchSize {
+				return
+			}
+			after = int64(users[len(users)-1].ID)
+		}
+	}
+}
+
+// userBatch reads the batch of users with ids above after. A batch whose
+// values contain the separator characters cannot be split reliably, so it is
+// read again row by row.
+func (s *DBStore) userBatch(ctx context.Context, filter string, args []any, after int64) ([]User, error) {
+	args = append(append([]any{}, args...), after, s.batchSize)
+
+	var data string
+	query := strings.Replace(batchUserQuery, "%s", filter, 1)
+	if err := s.queryRowReads(ctx, query, args...).Scan(&data); err != nil {
+		return nil, err
+	}
+
+	if users, ok := splitUserBatch(data, s.batchSize); ok {
+		return users, nil
+	}
+
+	query = "SELECT " + userColumns + " FROM users WHERE " + filter + " id > ? ORDER BY id LIMIT ?"
+	rows, err := s.queryReads(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0, s.batchSize)
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)); err != nil {
+			slog.Error("Error scanning user row", "error", err)
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// splitUserBatch parses the output of batchUserQuery. The returned strings
+// share data's memory. It reports false if the batch is malformed.
+func splitUserBatch(data string, sizeHint int) ([]User, bool) {
+	if data == "" {
+		return nil, true
+	}
+
+	users := make([]User, 0, sizeHint)
+	for len(data) > 0 {
+		var record string
+		record, data, _ = strings.Cut(data, recordSeparator)
+
+		fields := [6]string{}
+		for i := 0; i < 5; i++ {
+			var found bool
+			fields[i], record, found = strings.Cut(record, fieldSeparator)
+			if !found {
+				return nil, false
+			}
+		}
+		if strings.Contains(record, fieldSeparator) {
+			return nil, false
+		}
+		fields[5] = record
+
+		var user User
+		var err error
+		if user.ID, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, false
+		}
+		if fields[1] != "" {
+			if user.MailboxID, err = strconv.Atoi(fields[1]); err != nil {
+				return nil, false
+			}
+		}
+		user.UserName = fields[2]
+		user.EmailAddress = fields[3]
+		if user.CreatedAt, err = ParseTimestamp(fields[4]); err != nil {
+			return nil, false
+		}
+		if user.UpdatedAt, err = ParseTimestamp(fields[5]); err != nil {
+			return nil, false
+		}
+
+		users = append(users, user)
+	}
+
+	return users, true
+}
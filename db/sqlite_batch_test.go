@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDBStore_BatchUsers(t *testing.T) {
+	db := setupBenchDB(t, 25)
+	defer db.Close()
+
+	// Separator characters in a value force that batch onto the row path.
+	if _, err := db.Exec("UPDATE users SET user_name = 'odd' || char(31) || 'name' || char(30) WHERE id = 12"); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+
+	store := &DBStore{db: db, driver: "sqlite3", batchSize: 10}
+
+	userChan, err := store.UsersForMailbox(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Error calling UsersForMailbox: %v", err)
+	}
+
+	var receivedUsers []User
+	for user := range userChan {
+		receivedUsers = append(receivedUsers, user)
+	}
+
+	if len(receivedUsers) != 25 {
+		t.Fatalf("Expected 25 users, got %d", len(receivedUsers))
+	}
+	for i, user := range receivedUsers {
+		if user.ID != i {
+			t.Errorf("Expected user %d at position %d, got %d", i, i, user.ID)
+		}
+		if user.MailboxID != 1 || !user.CreatedAt.Equal(ts("2024-07-23 12:30:00")) {
+			t.Errorf("Unexpected user %v", user)
+		}
+	}
+	if name := receivedUsers[12].UserName; name != "odd\x1fname\x1e" {
+		t.Errorf("Expected separator characters preserved, got %q", name)
+	}
+	if email := receivedUsers[3].EmailAddress; email != "user3@example.com" {
+		t.Errorf("Expected user3@example.com, got %q", email)
+	}
+}
+
+func TestDBStore_BatchUsersQueryError(t *testing.T) {
+	db := setupBenchDB(t, 1)
+	defer db.Close()
+
+	if _, err := db.Exec("DROP TABLE users"); err != nil {
+		t.Fatalf("Error dropping users: %v", err)
+	}
+
+	store := &DBStore{db: db, driver: "sqlite3", batchSize: 10}
+
+	if _, err := store.UsersForMailbox(context.Background(), 1); err == nil {
+		t.Errorf("Expected an error querying a missing table")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build cgo && !sqlite_purego
+
+package db
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteDriver names the implementation behind the sqlite3 driver.
+const SQLiteDriver = "mattn/go-sqlite3"
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// SQLiteConfig describes a SQLite database and the PRAGMAs run on every
+// connection to it. Zero values leave a PRAGMA at SQLite's default.
+type SQLiteConfig struct {
+	// Path is the connection string, such as a file name.
+	Path string
+	// JournalMode is DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF. WAL lets
+	// readers go on while one connection writes.
+	JournalMode string
+	// Synchronous is OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+	// BusyTimeout is how long a connection waits for a lock another holds
+	// before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	// CacheSize is the page cache size as PRAGMA cache_size takes it: pages
+	// if positive, KiB if negative.
+	CacheSize int
+	Pool      PoolConfig
+}
+
+var (
+	sqliteJournalModes = map[string]bool{"DELETE": true, "TRUNCATE": true, "PERSIST": true, "MEMORY": true, "WAL": true, "OFF": true}
+	sqliteSynchronous  = map[string]bool{"OFF": true, "NORMAL": true, "FULL": true, "EXTRA": true}
+)
+
+// pragmas returns the statements cfg runs on each connection. busy_timeout
+// comes first, so switching the journal mode waits out other connections.
+func (cfg SQLiteConfig) pragmas() ([]string, error) {
+	var pragmas []string
+	if cfg.BusyTimeout < 0 {
+		return nil, fmt.Errorf("busy_timeout %s is negative", cfg.BusyTimeout)
+	}
+	if cfg.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA busy_timeout = %d", cfg.BusyTimeout.Milliseconds()))
+	}
+	if cfg.JournalMode != "" {
+		mode := strings.ToUpper(cfg.JournalMode)
+		if !sqliteJournalModes[mode] {
+			return nil, fmt.Errorf("unknown journal_mode %q", cfg.JournalMode)
+		}
+		pragmas = append(pragmas, "PRAGMA journal_mode = "+mode)
+	}
+	if cfg.Synchronous != "" {
+		sync := strings.ToUpper(cfg.Synchronous)
+		if !sqliteSynchronous[sync] {
+			return nil, fmt.Errorf("unknown synchronous %q", cfg.Synchronous)
+		}
+		pragmas = append(pragmas, "PRAGMA synchronous = "+sync)
+	}
+	if cfg.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = %d", cfg.CacheSize))
+	}
+	return pragmas, nil
+}
+
+// NewSQLiteStore opens the SQLite database at cfg.Path with dbDriver,
+// sqlite3 or sqlite, running cfg's PRAGMAs on every connection the pool
+// opens. Most of them only last as long as the connection, so setting them
+// once after opening would leave the pool's later connections without.
+func NewSQLiteStore(dbDriver string, cfg SQLiteConfig) (Store, error) {
+	if !sqliteDrivers[dbDriver] {
+		return nil, fmt.Errorf("%s is not a SQLite driver", dbDriver)
+	}
+	pragmas, err := cfg.pragmas()
+	if err != nil {
+		slog.Error("Error reading SQLite pragmas", "error", err)
+		return nil, err
+	}
+
+	// sql.Open does not connect; it is only a way to the registered driver.
+	opened, err := sql.Open(dbDriver, cfg.Path)
+	if err != nil {
+		slog.Error("Error opening database", "driver", dbDriver, "error", err)
+		return nil, err
+	}
+	drv := opened.Driver()
+	opened.Close()
+
+	var connector driver.Connector = dsnConnector{drv: drv, dsn: cfg.Path}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(cfg.Path); err != nil {
+			slog.Error("Error opening database", "driver", dbDriver, "error", err)
+			return nil, err
+		}
+	}
+	db := sql.OpenDB(pragmaConnector{Connector: connector, pragmas: pragmas})
+	cfg.Pool.apply(db)
+	return &DBStore{db: db, driver: dbDriver, batchSize: sqliteBatchSize, stmts: &stmtCache{stmts: map[string]*sql.Stmt{}}}, nil
+}
+
+// dsnConnector is the driver.Connector of a driver without its own.
+type dsnConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.drv.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.drv }
+
+// pragmaConnector runs pragmas on each connection Connector opens, before
+// the pool hands it out.
+type pragmaConnector struct {
+	driver.Connector
+	pragmas []string
+}
+
+func (c pragmaConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, pragma := range c.pragmas {
+		if err := execConn(ctx, conn, pragma); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", pragma, err)
+		}
+	}
+	return conn, nil
+}
+
+// execConn runs query on conn, which the database/sql pool does not manage
+// yet.
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if ec, ok := conn.(driver.ExecerContext); ok {
+		_, err := ec.ExecContext(ctx, query, nil)
+		return err
+	}
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(nil)
+	return err
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewSQLiteStore_Pragmas(t *testing.T) {
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			store, err := NewSQLiteStore(driver, SQLiteConfig{
+				Path:        filepath.Join(t.TempDir(), "mailboxes.db"),
+				JournalMode: "wal",
+				Synchronous: "normal",
+				BusyTimeout: 5 * time.Second,
+				CacheSize:   -4000,
+			})
+			if err != nil {
+				t.Fatalf("Error opening store: %v", err)
+			}
+			db := store.(*DBStore).db
+			defer db.Close()
+
+			// Two connections held at once are two connections from the
+			// pool, and each must have run the pragmas.
+			ctx := context.Background()
+			for range 2 {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					t.Fatalf("Error connecting: %v", err)
+				}
+				defer conn.Close()
+
+				var journalMode string
+				var synchronous, busyTimeout, cacheSize int
+				for pragma, dest := range map[string]any{
+					"journal_mode": &journalMode,
+					"synchronous":  &synchronous,
+					"busy_timeout": &busyTimeout,
+					"cache_size":   &cacheSize,
+				} {
+					if err := conn.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(dest); err != nil {
+						t.Fatalf("Error reading %s: %v", pragma, err)
+					}
+				}
+				if journalMode != "wal" || synchronous != 1 || busyTimeout != 5000 || cacheSize != -4000 {
+					t.Errorf("Expected wal, 1, 5000 and -4000, got %s, %d, %d and %d", journalMode, synchronous, busyTimeout, cacheSize)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSQLiteStore_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		driver   string
+		cfg      SQLiteConfig
+		expected string
+	}{
+		{name: "Journal mode", driver: "sqlite3", cfg: SQLiteConfig{JournalMode: "wal; DROP TABLE users"}, expected: `unknown journal_mode "wal; DROP TABLE users"`},
+		{name: "Synchronous", driver: "sqlite3", cfg: SQLiteConfig{Synchronous: "sometimes"}, expected: `unknown synchronous "sometimes"`},
+		{name: "Busy timeout", driver: "sqlite3", cfg: SQLiteConfig{BusyTimeout: -time.Second}, expected: "busy_timeout -1s is negative"},
+		{name: "Driver", driver: "pgx", expected: "pgx is not a SQLite driver"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSQLiteStore(tt.driver, tt.cfg); err == nil || err.Error() != tt.expected {
+				t.Errorf("Expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
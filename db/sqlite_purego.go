@@ -0,0 +1,19 @@
+//go:build !cgo || sqlite_purego
+
+package db
+
+import (
+	"database/sql"
+
+	"modernc.org/sqlite"
+)
+
+// SQLiteDriver names the implementation behind the sqlite3 driver.
+const SQLiteDriver = "modernc.org/sqlite"
+
+// Without cgo, or built with the sqlite_purego tag, mattn/go-sqlite3 is left
+// out and sqlite3 is served by modernc.org/sqlite as well, so configurations
+// need not change for a static binary.
+func init() {
+	sql.Register("sqlite3", &sqlite.Driver{})
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+
+	"mailboxes/scope"
+)
+
+// largestMailboxesQuery ranks mailboxes by user count on every backend. %s
+// is an optional WHERE clause.
+const largestMailboxesQuery = "SELECT mailbox_id, COUNT(*) FROM users%s GROUP BY mailbox_id ORDER BY COUNT(*) DESC, mailbox_id LIMIT ?"
+
+// postgresTableStatsQuery sums each table's partitions into the table itself.
+// Row counts are the planner's estimates, which are cheap to read and close
+// enough for capacity planning on tables too large to count.
+const postgresTableStatsQuery = "SELECT c.relname, " +
+	"COALESCE(SUM(GREATEST(p.reltuples, 0)), 0)::bigint, " +
+	"COALESCE(SUM(pg_table_size(p.oid)), 0)::bigint, " +
+	"COALESCE(SUM(pg_indexes_size(p.oid)), 0)::bigint " +
+	"FROM pg_class c " +
+	"JOIN pg_namespace n ON n.oid = c.relnamespace " +
+	"JOIN pg_class p ON p.oid = c.oid OR p.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = c.oid) " +
+	"WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p') AND NOT c.relispartition " +
+	"GROUP BY c.relname ORDER BY c.relname"
+
+// Stats reports the size of every table and the top mailboxes by user count.
+// PostgreSQL row counts are estimates; SQLite cannot size individual tables,
+// so only its rows and total database size are reported. In a context scoped
+// to a tenant only the tenant's mailboxes and users are counted, exactly, and
+// no sizes are reported, as they would describe every tenant's rows.
+func (s *DBStore) Stats(ctx context.Context, top int) (Stats, error) {
+	var stats Stats
+	var err error
+	if scope.Tenant(ctx) != "" {
+		stats.Tables, err = s.tenantTableStats(ctx)
+	} else if s.isPostgres() {
+		stats.Tables, err = s.postgresTableStats(ctx)
+		if err == nil {
+			err = s.db.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").Scan(&stats.DatabaseBytes)
+		}
+	} else {
+		stats.Tables, err = s.sqliteTableStats(ctx)
+		if err == nil {
+			err = s.db.QueryRowContext(ctx, "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&stats.DatabaseBytes)
+		}
+	}
+	if err != nil {
+		slog.Error("Error reading table statistics", "error", err)
+		return Stats{}, err
+	}
+
+	cond, args := tenantScope(ctx, "mailbox_id")
+	var where string
+	if cond != "" {
+		where = " WHERE " + strings.TrimPrefix(cond, " AND ")
+	}
+	query := strings.Replace(largestMailboxesQuery, "%s", where, 1)
+	stats.LargestMailboxes, err = largestMailboxes(ctx, s.db, s.rebind(query), top, args...)
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+// tenantTableStats counts the mailboxes and users of the tenant ctx is
+// scoped to.
+func (s *DBStore) tenantTableStats(ctx context.Context) ([]TableStats, error) {
+	tables := []TableStats{{Name: "mailboxes"}, {Name: "users"}}
+	for i, column := range []string{"id", "mailbox_id"} {
+		cond, args := tenantScope(ctx, column)
+		query := "SELECT COUNT(*) FROM " + tables[i].Name + " WHERE " + strings.TrimPrefix(cond, " AND ")
+		if err := s.db.QueryRowContext(ctx, s.rebind(query), args...).Scan(&tables[i].Rows); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+func (s *DBStore) postgresTableStats(ctx context.Context) ([]TableStats, error) {
+	rows, err := s.db.QueryContext(ctx, postgresTableStatsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableStats
+	for rows.Next() {
+		var t TableStats
+		if err := rows.Scan(&t.Name, &t.Rows, &t.TableBytes, &t.IndexBytes); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+func (s *DBStore) sqliteTableStats(ctx context.Context) ([]TableStats, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := make([]TableStats, 0, len(names))
+	for _, name := range names {
+		t := TableStats{Name: name}
+		// Names come from sqlite_master, not from callers.
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+name+`"`).Scan(&t.Rows); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// Stats reports the size of every table in the current database and the top
+// mailboxes by user count. Row counts are InnoDB's estimates.
+func (s *MySQLStore) Stats(ctx context.Context, top int) (Stats, error) {
+	query := "SELECT TABLE_NAME, COALESCE(TABLE_ROWS, 0), COALESCE(DATA_LENGTH, 0), COALESCE(INDEX_LENGTH, 0) " +
+		"FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME"
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		slog.Error("Error reading table statistics", "error", err)
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	var stats Stats
+	for rows.Next() {
+		var t TableStats
+		if err := rows.Scan(&t.Name, &t.Rows, &t.TableBytes, &t.IndexBytes); err != nil {
+			slog.Error("Error scanning table statistics", "error", err)
+			return Stats{}, err
+		}
+		stats.Tables = append(stats.Tables, t)
+		stats.DatabaseBytes += t.TableBytes + t.IndexBytes
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over table statistics", "error", err)
+		return Stats{}, err
+	}
+
+	stats.LargestMailboxes, err = largestMailboxes(ctx, s.db, strings.Replace(largestMailboxesQuery, "%s", "", 1), top)
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+// largestMailboxes runs query, a largestMailboxesQuery, with args and then
+// top.
+func largestMailboxes(ctx context.Context, db *sql.DB, query string, top int, args ...any) ([]MailboxSize, error) {
+	if top <= 0 {
+		return nil, nil
+	}
+
+	rows, err := db.QueryContext(ctx, query, append(args, top)...)
+	if err != nil {
+		slog.Error("Error querying largest mailboxes", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sizes []MailboxSize
+	for rows.Next() {
+		var m MailboxSize
+		if err := rows.Scan(&m.MailboxID, &m.Users); err != nil {
+			slog.Error("Error scanning mailbox size", "error", err)
+			return nil, err
+		}
+		sizes = append(sizes, m)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error iterating over mailbox sizes", "error", err)
+		return nil, err
+	}
+	return sizes, nil
+}
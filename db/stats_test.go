@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_Stats(t *testing.T) {
+	tests := []struct {
+		name      string
+		driver    string
+		mockSetup func(mock sqlmock.Sqlmock)
+		expected  Stats
+	}{
+		{
+			name:   "SQLite",
+			driver: "sqlite3",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("mailboxes").AddRow("users"))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM "mailboxes"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM "users"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()")).
+					WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(57344))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, COUNT(*) FROM users GROUP BY mailbox_id ORDER BY COUNT(*) DESC, mailbox_id LIMIT ?")).
+					WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "count"}).AddRow(1, 2).AddRow(2, 1))
+			},
+			expected: Stats{
+				DatabaseBytes:    57344,
+				Tables:           []TableStats{{Name: "mailboxes", Rows: 2}, {Name: "users", Rows: 3}},
+				LargestMailboxes: []MailboxSize{{MailboxID: 1, Users: 2}, {MailboxID: 2, Users: 1}},
+			},
+		},
+		{
+			name:   "PostgreSQL",
+			driver: "pgx",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(postgresTableStatsQuery)).
+					WillReturnRows(sqlmock.NewRows([]string{"relname", "rows", "table_bytes", "index_bytes"}).AddRow("users", 150000000, 21474836480, 6442450944))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_database_size(current_database())")).
+					WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(30064771072))
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, COUNT(*) FROM users GROUP BY mailbox_id ORDER BY COUNT(*) DESC, mailbox_id LIMIT $1")).
+					WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "count"}).AddRow(7, 2000000))
+			},
+			expected: Stats{
+				DatabaseBytes:    30064771072,
+				Tables:           []TableStats{{Name: "users", Rows: 150000000, TableBytes: 21474836480, IndexBytes: 6442450944}},
+				LargestMailboxes: []MailboxSize{{MailboxID: 7, Users: 2000000}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: tt.driver}
+			stats, err := store.Stats(context.Background(), 2)
+			if err != nil {
+				t.Fatalf("Error calling Stats: %v", err)
+			}
+			if !reflect.DeepEqual(stats, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, stats)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -1,32 +1,118 @@
-
 package db
 
 import (
+	"context"
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"mailboxes/tokencrypt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// mailboxColumns and userColumns select the timestamps as text, which every
+// driver returns the same way and scanTime parses directly; some drivers
+// would otherwise go through their own, slower, parsing for every row.
+const (
+	mailboxColumns = "id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT)"
+	userColumns    = "id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT)"
 )
 
 type DBStore struct {
-	db *sql.DB
-	log *log.Logger
+	db     *sql.DB
+	driver string
+	// batchSize enables the SQLite batched read path when non-zero.
+	batchSize int
+	// partitionedUsers is set when users is partitioned by created_at.
+	partitionedUsers bool
+	// tokens seals and opens mailbox tokens; nil stores them in plaintext.
+	tokens *tokencrypt.Keyring
+	// mailboxEvents is set when mailbox writes are logged to mailbox_events.
+	mailboxEvents bool
+	// auditLog is set when mailbox and user writes are recorded in
+	// audit_log, under auditActor when their context names no actor.
+	auditLog   bool
+	auditActor string
+	// provenance is set when the source of each user created through the
+	// store is recorded in user_provenance, as provenanceSource when their
+	// context names none.
+	provenance       bool
+	provenanceSource string
+	// quotas are the per-tenant limits on mailbox and user creation.
+	quotas Quotas
+	// stmts holds the prepared mailbox and user reads; nil runs them
+	// unprepared.
+	stmts *stmtCache
 }
 
-func NewDBStore(dbDriver, dbSource string) (Store, error) {
+func NewDBStore(dbDriver, dbSource string, pool PoolConfig) (Store, error) {
 	db, err := sql.Open(dbDriver, dbSource)
 	if err != nil {
-		log.Printf("Error opening database: %v", err)
+		slog.Error("Error opening database", "driver", dbDriver, "error", err)
 		return nil, err
 	}
-	return &DBStore{db: db, log: log.Default()}, nil
+	pool.apply(db)
+	store := &DBStore{db: db, driver: dbDriver}
+	store.SetPreparedStatements(true)
+	if sqliteDrivers[dbDriver] {
+		store.batchSize = sqliteBatchSize
+	}
+	return store, nil
+}
+
+// Ping checks that the database can be reached. Opening a store does not
+// connect until the first query.
+func (s *DBStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
-func (s *DBStore) AllMailboxes() (<-chan Mailbox, error) {
-	query := "SELECT id, mpi_id, token, created_at FROM mailboxes"
+// postgresDrivers are the database/sql driver names that speak PostgreSQL.
+var postgresDrivers = map[string]bool{"pgx": true, "postgres": true}
+
+func (s *DBStore) isPostgres() bool {
+	return postgresDrivers[s.driver]
+}
+
+// sqliteDrivers are the database/sql driver names that speak SQLite:
+// sqlite3 is mattn/go-sqlite3 where it is built in, and sqlite is always
+// the pure-Go modernc.org/sqlite.
+var sqliteDrivers = map[string]bool{"sqlite3": true, "sqlite": true}
+
+// rebind rewrites ? placeholders to PostgreSQL's $n form when needed.
+func (s *DBStore) rebind(query string) string {
+	if !s.isPostgres() || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
 
-	rows, err := s.db.Query(query)
+// AllMailboxes streams every mailbox, or in a context scoped to a tenant
+// every mailbox of the tenant. Cancelling ctx aborts the query and closes the
+// channel.
+func (s *DBStore) AllMailboxes(ctx context.Context) (<-chan Mailbox, error) {
+	query := "SELECT " + mailboxColumns + " FROM mailboxes"
+	cond, args := tenantScope(ctx, "id")
+	if cond != "" {
+		query += " WHERE " + strings.TrimPrefix(cond, " AND ")
+	}
+
+	rows, err := s.queryReads(ctx, s.rebind(query), args...)
 	if err != nil {
-		log.Printf("Error querying mailboxes: %v", err)
+		slog.Error("Error querying mailboxes", "error", err)
 		return nil, err
 	}
 
@@ -36,18 +122,22 @@ func (s *DBStore) AllMailboxes() (<-chan Mailbox, error) {
 		defer close(mailboxChannel)
 		defer rows.Close()
 
+		var mb Mailbox
+		dest := []any{&mb.ID, &mb.MPIID, s.scanToken(&mb.Token), scanTime(&mb.CreatedAt), scanTime(&mb.UpdatedAt)}
 		for rows.Next() {
-			var mb Mailbox
-			err := rows.Scan(&mb.ID, &mb.MPIID, &mb.Token, &mb.CreatedAt)
-			if err != nil {
-				log.Printf("Error scanning mailbox row: %v", err)
+			if err := rows.Scan(dest...); err != nil {
+				slog.Error("Error scanning mailbox row", "error", err)
 				continue
 			}
-			mailboxChannel <- mb
+			select {
+			case mailboxChannel <- mb:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		if err := rows.Err(); err != nil {
-			log.Printf("Error iterating over mailbox rows: %v", err)
+			slog.Error("Error iterating over mailbox rows", "error", err)
 			return
 		}
 	}()
@@ -55,36 +145,78 @@ func (s *DBStore) AllMailboxes() (<-chan Mailbox, error) {
 	return mailboxChannel, nil
 }
 
-func (s *DBStore) UsersForMailbox(mailboxID int) (<-chan User, error) {
-	query := "SELECT id, mailbox_id, user_name, email_address, created_at FROM users WHERE mailbox_id = ?"
+// UsersForMailbox streams the users of mailboxID. In a context scoped to a
+// tenant, a mailbox of another tenant has none.
+func (s *DBStore) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan User, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	args = append([]any{mailboxID}, args...)
+	if s.batchSize > 0 {
+		return s.batchUsers(ctx, "mailbox_id = ?"+cond+" AND", args...)
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE mailbox_id = ?" + cond
+
+	rows, err := s.queryReads(ctx, s.rebind(query), args...)
+	if err != nil {
+		slog.Error("Error querying users", "mailbox_id", mailboxID, "error", err)
+		return nil, err
+	}
+
+	return streamUsers(ctx, rows), nil
+}
+
+// UsersForMailboxes streams the users of every mailbox in mailboxIDs with a
+// single query, ordered by mailbox. In a context scoped to a tenant, other
+// tenants' mailboxes are skipped.
+func (s *DBStore) UsersForMailboxes(ctx context.Context, mailboxIDs []int) (<-chan User, error) {
+	placeholders := make([]string, len(mailboxIDs))
+	args := make([]any, len(mailboxIDs))
+	for i, id := range mailboxIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	cond, scopeArgs := tenantScope(ctx, "mailbox_id")
+	args = append(args, scopeArgs...)
+	query := "SELECT " + userColumns + " FROM users WHERE mailbox_id IN (" + strings.Join(placeholders, ", ") + ")" + cond + " ORDER BY mailbox_id, id"
 
-	rows, err := s.db.Query(query, mailboxID)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
 	if err != nil {
-		log.Printf("Error querying users for mailbox %d: %v", mailboxID, err)
+		slog.Error("Error querying users", "mailboxes", len(mailboxIDs), "error", err)
 		return nil, err
 	}
 
+	return streamUsers(ctx, rows), nil
+}
+
+// streamUsers sends each row as a User, scanning every row into the same
+// destinations so the hot loop allocates nothing beyond the column values.
+// It stops early if ctx is cancelled.
+func streamUsers(ctx context.Context, rows *sql.Rows) <-chan User {
 	userChannel := make(chan User)
 
 	go func() {
 		defer close(userChannel)
 		defer rows.Close()
 
+		var user User
+		dest := []any{&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt)}
 		for rows.Next() {
-			var user User
-			err := rows.Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, &user.CreatedAt)
-			if err != nil {
-				log.Printf("Error scanning user row: %v", err)
+			if err := rows.Scan(dest...); err != nil {
+				slog.Error("Error scanning user row", "error", err)
 				continue
 			}
-			userChannel <- user
+			select {
+			case userChannel <- user:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		if err := rows.Err(); err != nil {
-			log.Printf("Error iterating over user rows: %v", err)
+			slog.Error("Error iterating over user rows", "error", err)
 			return
 		}
 	}()
 
-	return userChannel, nil
+	return userChannel
 }
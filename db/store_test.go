@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -18,18 +20,18 @@ func TestDBStore_AllMailboxes(t *testing.T) {
 		{
 			name: "Success with multiple mailboxes",
 			expectedMailboxes: []Mailbox{
-				{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: "2024-07-23 12:00:00"},
-				{ID: 2, MPIID: "mpi456", Token: "token456", CreatedAt: "2024-07-23 13:00:00"},
+				{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: ts("2024-07-23 12:00:00")},
+				{ID: 2, MPIID: "mpi456", Token: "token456", CreatedAt: ts("2024-07-23 13:00:00")},
 			},
-			mockRows: sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at"}).
-			AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00").
-			AddRow(2, "mpi456", "token456", "2024-07-23 13:00:00"),
+			mockRows: sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}).
+			AddRow(1, "mpi123", "token123", "2024-07-23 12:00:00", nil).
+			AddRow(2, "mpi456", "token456", "2024-07-23 13:00:00", nil),
 			expectedError: nil,
 		},
 		{
 			name: "No mailboxes",
 			expectedMailboxes: []Mailbox{},
-			mockRows: sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at"}),
+			mockRows: sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}),
 			expectedError: nil,
 		},
 		{
@@ -47,15 +49,15 @@ func TestDBStore_AllMailboxes(t *testing.T) {
 
 			// Setup mock expectations
 			if tt.expectedError != nil {
-				mock.ExpectQuery("SELECT id, mpi_id, token, created_at FROM mailboxes").WillReturnError(tt.expectedError)
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes")).WillReturnError(tt.expectedError)
 			} else {
-				mock.ExpectQuery("SELECT id, mpi_id, token, created_at FROM mailboxes").WillReturnRows(tt.mockRows)
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mpi_id, token, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM mailboxes")).WillReturnRows(tt.mockRows)
 			}
 
 			store := &DBStore{db: db}
 
 			// Call AllMailboxes method
-			mailboxChan, err := store.AllMailboxes()
+			mailboxChan, err := store.AllMailboxes(context.Background())
 			if err != nil {
 				if tt.expectedError == nil {
 					t.Fatalf("Error calling AllMailboxes: %v", err)
@@ -97,19 +99,19 @@ func TestDBStore_UsersForMailbox(t *testing.T) {
 			name:      "Success with multiple users",
 			mailboxID: 1,
 			expectedUsers: []User{
-				{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: "2024-07-23 12:30:00"},
-				{ID: 102, MailboxID: 1, UserName: "user2", EmailAddress: "user2@example.com", CreatedAt: "2024-07-23 12:45:00"},
+				{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+				{ID: 102, MailboxID: 1, UserName: "user2", EmailAddress: "user2@example.com", CreatedAt: ts("2024-07-23 12:45:00")},
 			},
-			mockRows: sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at"}).
-			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00").
-			AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00"),
+			mockRows: sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00", nil),
 			expectedError: nil,
 		},
 		{
 			name:      "No users",
 			mailboxID: 1,
 			expectedUsers: []User{},
-			mockRows: sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at"}),
+			mockRows: sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}),
 			expectedError: nil,
 		},
 		{
@@ -128,11 +130,11 @@ func TestDBStore_UsersForMailbox(t *testing.T) {
 
 			// Setup mock expectations
 			if tt.expectedError != nil {
-				mock.ExpectQuery("SELECT id, mailbox_id, user_name, email_address, created_at FROM users WHERE mailbox_id = ?").
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id = ?")).
 				WithArgs(tt.mailboxID).
 				WillReturnError(tt.expectedError)
 			} else {
-				mock.ExpectQuery("SELECT id, mailbox_id, user_name, email_address, created_at FROM users WHERE mailbox_id = ?").
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id = ?")).
 				WithArgs(tt.mailboxID).
 				WillReturnRows(tt.mockRows)
 			}
@@ -140,7 +142,7 @@ func TestDBStore_UsersForMailbox(t *testing.T) {
 			store := &DBStore{db: db}
 
 			// Call UsersForMailbox method
-			userChan, err := store.UsersForMailbox(tt.mailboxID)
+			userChan, err := store.UsersForMailbox(context.Background(), tt.mailboxID)
 			if err != nil {
 				if tt.expectedError == nil {
 					t.Fatalf("Error calling UsersForMailbox: %v", err)
@@ -170,6 +172,40 @@ func TestDBStore_UsersForMailbox(t *testing.T) {
 	}
 }
 
+func TestDBStore_UsersForMailboxes(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE mailbox_id IN ($1, $2) ORDER BY mailbox_id, id")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil).
+			AddRow(201, 2, "user3", "user3@example.com", "2024-07-23 13:00:00", nil))
+
+	store := &DBStore{db: db, driver: "pgx"}
+
+	userChan, err := store.UsersForMailboxes(context.Background(), []int{1, 2})
+	if err != nil {
+		t.Fatalf("Error calling UsersForMailboxes: %v", err)
+	}
+
+	var receivedUsers []User
+	for user := range userChan {
+		receivedUsers = append(receivedUsers, user)
+	}
+
+	expectedUsers := []User{
+		{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")},
+		{ID: 201, MailboxID: 2, UserName: "user3", EmailAddress: "user3@example.com", CreatedAt: ts("2024-07-23 13:00:00")},
+	}
+	if !reflect.DeepEqual(receivedUsers, expectedUsers) {
+		t.Errorf("Expected users %v, got %v", expectedUsers, receivedUsers)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
 func setupMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New() // Create a new mock database connection
 	if err != nil {
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"mailboxes/scope"
+)
+
+// tenantScope returns a condition restricting column, a mailbox ID, to the
+// mailboxes of the tenant ctx is scoped to, with its argument, or "" and no
+// arguments when ctx is unscoped.
+func tenantScope(ctx context.Context, column string) (string, []any) {
+	tenant := scope.Tenant(ctx)
+	if tenant == "" {
+		return "", nil
+	}
+	return " AND " + column + " IN (SELECT mailbox_id FROM mailbox_tenants WHERE tenant = ?)", []any{tenant}
+}
+
+// MailboxTenant returns the tenant recorded for mailboxID, or "" if it has
+// none.
+func (s *DBStore) MailboxTenant(ctx context.Context, mailboxID int) (string, error) {
+	return s.mailboxTenant(ctx, s.db, mailboxID)
+}
+
+// MailboxTenants returns the tenant of every mailbox that has one, by
+// mailbox ID.
+func (s *DBStore) MailboxTenants(ctx context.Context) (map[int]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT mailbox_id, tenant FROM mailbox_tenants")
+	if err != nil {
+		slog.Error("Error listing mailbox tenants", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var tenant string
+		if err := rows.Scan(&id, &tenant); err != nil {
+			slog.Error("Error scanning mailbox tenant", "error", err)
+			return nil, err
+		}
+		tenants[id] = tenant
+	}
+	return tenants, rows.Err()
+}
+
+func (s *DBStore) mailboxTenant(ctx context.Context, q execQueryer, mailboxID int) (string, error) {
+	var tenant string
+	err := q.QueryRowContext(ctx, s.rebind("SELECT tenant FROM mailbox_tenants WHERE mailbox_id = ?"), mailboxID).Scan(&tenant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		slog.Error("Error looking up tenant of mailbox", "mailbox_id", mailboxID, "error", err)
+		return "", err
+	}
+	return tenant, nil
+}
+
+func (s *DBStore) assignTenant(ctx context.Context, q execQueryer, mailboxID int, tenant string) error {
+	query := "INSERT INTO mailbox_tenants (mailbox_id, tenant) VALUES (?, ?)"
+	if _, err := q.ExecContext(ctx, s.rebind(query), mailboxID, tenant); err != nil {
+		slog.Error("Error assigning mailbox to tenant", "mailbox_id", mailboxID, "tenant", tenant, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"mailboxes/scope"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_TenantScope(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	inTenant := " AND mailbox_id IN (SELECT mailbox_id FROM mailbox_tenants WHERE tenant = ?)"
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+mailboxColumns+" FROM mailboxes WHERE id = ? AND id IN (SELECT mailbox_id FROM mailbox_tenants WHERE tenant = ?)")).
+		WithArgs(2, "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+userColumns+" FROM users WHERE mailbox_id = ? AND id > ?"+inTenant+" ORDER BY id LIMIT ?")).
+		WithArgs(1, 0, "acme", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", "2024-07-23 12:30:00"))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ctx := scope.WithTenant(context.Background(), "acme")
+
+	if _, err := store.GetMailboxByID(ctx, 2); !errors.Is(err, ErrMailboxNotFound) {
+		t.Errorf("Expected another tenant's mailbox to be not found, got %v", err)
+	}
+	users, err := store.UsersForMailboxAfter(ctx, 1, 0, 10)
+	if err != nil || len(users) != 1 {
+		t.Errorf("Expected the tenant's user, got %v, %v", users, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_MailboxEventActor(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailbox_events (mailbox_id, kind, data, occurred_at) VALUES (?, ?, ?, ?) RETURNING id")).
+		WithArgs(3, MailboxSuspended, `{"actor":"ops","reason":"abuse"}`, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	ctx := scope.WithActor(context.Background(), "ops")
+	e, err := store.AppendMailboxEvent(ctx, MailboxEvent{MailboxID: 3, Kind: MailboxSuspended, Data: map[string]string{"reason": "abuse"}})
+	if err != nil {
+		t.Fatalf("Error appending event: %v", err)
+	}
+	if e.Data["actor"] != "ops" {
+		t.Errorf("Expected the actor in the event data, got %v", e.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_MailboxTenants(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT mailbox_id, tenant FROM mailbox_tenants")).
+		WillReturnRows(sqlmock.NewRows([]string{"mailbox_id", "tenant"}).AddRow(1, "acme").AddRow(2, "globex"))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	tenants, err := store.MailboxTenants(context.Background())
+	if err != nil {
+		t.Fatalf("Error listing mailbox tenants: %v", err)
+	}
+	if len(tenants) != 2 || tenants[1] != "acme" || tenants[2] != "globex" {
+		t.Errorf("Unexpected tenants %v", tenants)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_TenantReads(t *testing.T) {
+	store := migratedStore(t)
+	acme := scope.WithTenant(context.Background(), "acme")
+	globex := scope.WithTenant(context.Background(), "globex")
+
+	var mailboxes []int
+	for _, ctx := range []context.Context{acme, globex} {
+		mb, err := store.CreateMailbox(ctx, Mailbox{MPIID: "mpi-" + scope.Tenant(ctx)})
+		if err != nil {
+			t.Fatalf("Error creating mailbox: %v", err)
+		}
+		if _, err := store.CreateUser(ctx, User{MailboxID: mb.ID, UserName: scope.Tenant(ctx), EmailAddress: scope.Tenant(ctx) + "@example.com"}); err != nil {
+			t.Fatalf("Error creating user: %v", err)
+		}
+		mailboxes = append(mailboxes, mb.ID)
+	}
+	ours, theirs := mailboxes[0], mailboxes[1]
+	if err := store.SaveMailboxSummaries(context.Background(), []MailboxSummary{
+		{MailboxID: ours, HealthScore: 90, ComputedAt: now()},
+		{MailboxID: theirs, HealthScore: 10, ComputedAt: now()},
+	}); err != nil {
+		t.Fatalf("Error saving summaries: %v", err)
+	}
+
+	if got := drain(store.AllMailboxes(acme)); len(got) != 1 || got[0].ID != ours {
+		t.Errorf("AllMailboxes() = %v, want only mailbox %d", got, ours)
+	}
+	for _, batchSize := range []int{0, sqliteBatchSize} {
+		store.batchSize = batchSize
+		if users := drain(store.UsersForMailbox(acme, theirs)); len(users) != 0 {
+			t.Errorf("UsersForMailbox() of another tenant's mailbox = %v, want none (batch size %d)", users, batchSize)
+		}
+		if users := drain(store.AllUsers(acme)); len(users) != 1 || users[0].MailboxID != ours {
+			t.Errorf("AllUsers() = %v, want only the tenant's user (batch size %d)", users, batchSize)
+		}
+	}
+	if users := drain(store.UsersForMailboxes(acme, []int{ours, theirs})); len(users) != 1 || users[0].MailboxID != ours {
+		t.Errorf("UsersForMailboxes() = %v, want only the tenant's user", users)
+	}
+
+	if _, err := store.MailboxSummary(acme, theirs); !errors.Is(err, ErrNoSummary) {
+		t.Errorf("MailboxSummary() of another tenant's mailbox error = %v, want ErrNoSummary", err)
+	}
+	summaries, err := store.MailboxSummaries(acme, 10)
+	if err != nil || len(summaries) != 1 || summaries[0].MailboxID != ours {
+		t.Errorf("MailboxSummaries() = %v, %v, want only mailbox %d", summaries, err, ours)
+	}
+
+	stats, err := store.Stats(acme, 10)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	want := []TableStats{{Name: "mailboxes", Rows: 1}, {Name: "users", Rows: 1}}
+	if !reflect.DeepEqual(stats.Tables, want) || stats.DatabaseBytes != 0 {
+		t.Errorf("Stats() tables = %v, %d bytes, want %v and no size", stats.Tables, stats.DatabaseBytes, want)
+	}
+	if len(stats.LargestMailboxes) != 1 || stats.LargestMailboxes[0].MailboxID != ours {
+		t.Errorf("Stats() largest mailboxes = %v, want only mailbox %d", stats.LargestMailboxes, ours)
+	}
+}
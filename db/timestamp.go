@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timestampLayouts are the forms created_at and updated_at come back in as
+// text: TimestampLayout as written by this package, with fractional seconds
+// and offsets as PostgreSQL prints timestamptz, and RFC 3339 as the SQLite
+// driver writes a time.Time.
+var timestampLayouts = []string{
+	TimestampLayout,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// FormatTimestamp formats t in UTC as TimestampLayout, the form timestamps
+// are written to the database in. SQLite compares them as text, so every
+// write must use the same layout.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampLayout)
+}
+
+// ParseTimestamp parses a timestamp in any of the forms the supported
+// databases return it in. Times without an offset are UTC. The empty string
+// is the zero time.
+func ParseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	// SQLite stores a time.Time written by its driver with a space in place
+	// of the T.
+	if t, err := time.Parse(time.RFC3339Nano, strings.Replace(s, " ", "T", 1)); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// now is the current time at the precision timestamps are stored with, so a
+// value returned from a write compares equal to the same value read back.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// timestamp scans a timestamp column into a time.Time. Drivers return
+// timestamps as time.Time when they parse the column type themselves, as pgx
+// does for timestamptz, and as text otherwise, including when the column is
+// selected with CAST(... AS TEXT). NULL scans as the zero time.
+type timestamp struct {
+	t *time.Time
+}
+
+// scanTime returns a destination for Scan that stores into t.
+func scanTime(t *time.Time) timestamp {
+	return timestamp{t}
+}
+
+func (ts timestamp) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*ts.t = time.Time{}
+	case time.Time:
+		*ts.t = v.UTC()
+	case string:
+		*ts.t, err = ParseTimestamp(v)
+	case []byte:
+		*ts.t, err = ParseTimestamp(string(v))
+	default:
+		err = fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// ts parses a timestamp for test fixtures.
+func ts(s string) time.Time {
+	t, err := ParseTimestamp(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{name: "TimestampLayout", input: "2024-07-23 12:30:00", expected: want},
+		{name: "PostgreSQL timestamptz", input: "2024-07-23 14:30:00+02", expected: want},
+		{name: "PostgreSQL fractional", input: "2024-07-23 12:30:00.000000+00:00", expected: want},
+		{name: "RFC 3339", input: "2024-07-23T12:30:00Z", expected: want},
+		{name: "SQLite driver", input: "2024-07-23 12:30:00+00:00", expected: want},
+		{name: "Date", input: "2024-07-23", expected: time.Date(2024, 7, 23, 0, 0, 0, 0, time.UTC)},
+		{name: "Empty", input: "", expected: time.Time{}},
+		{name: "Invalid", input: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTimestamp(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !got.Equal(tt.expected) || got.Location() != time.UTC {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestScanTime(t *testing.T) {
+	want := time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		src      any
+		expected time.Time
+		wantErr  bool
+	}{
+		{name: "time.Time", src: want.In(time.FixedZone("CEST", 2*60*60)), expected: want},
+		{name: "String", src: "2024-07-23 12:30:00", expected: want},
+		{name: "Bytes", src: []byte("2024-07-23T12:30:00Z"), expected: want},
+		{name: "NULL", src: nil, expected: time.Time{}},
+		{name: "Integer", src: int64(1721737800), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := time.Now()
+			err := scanTime(&got).Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if got := FormatTimestamp(want.In(time.FixedZone("CEST", 2*60*60))); got != "2024-07-23 12:30:00" {
+		t.Errorf("Expected FormatTimestamp in UTC, got %q", got)
+	}
+}
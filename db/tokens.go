@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"mailboxes/tokencrypt"
+)
+
+// rotateBatchSize bounds how many mailboxes RotateTokens re-seals per
+// transaction.
+const rotateBatchSize = 500
+
+// SetTokenKeyring has the store seal mailbox tokens and settings with k when
+// writing them and open them when reading. Plaintext values written before
+// encryption was enabled are still read as they are until RotateTokens seals
+// them.
+func (s *DBStore) SetTokenKeyring(k *tokencrypt.Keyring) {
+	s.tokens = k
+}
+
+// sealedToken scans a token column and opens it with the store's keyring.
+type sealedToken struct {
+	keyring *tokencrypt.Keyring
+	token   *string
+}
+
+// scanToken returns a destination for Scan that opens the token into tok.
+func (s *DBStore) scanToken(tok *string) sealedToken {
+	return sealedToken{s.tokens, tok}
+}
+
+func (st sealedToken) Scan(src any) error {
+	var stored string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a token", src)
+	}
+
+	tok, err := st.keyring.Open(stored)
+	if err != nil {
+		return err
+	}
+	*st.token = tok
+	return nil
+}
+
+// RotateTokens seals every stored token that is plaintext or sealed with an
+// older key with the primary key, a batch of mailboxes per transaction, and
+// then every mailbox setting likewise. With dryRun it only counts them.
+func (s *DBStore) RotateTokens(ctx context.Context, dryRun bool) (TokenRotation, error) {
+	var result TokenRotation
+	if s.tokens == nil {
+		return result, fmt.Errorf("no token encryption keys configured")
+	}
+
+	query := "SELECT id, token FROM mailboxes WHERE id > ? ORDER BY id LIMIT ?"
+	update := "UPDATE mailboxes SET token = ? WHERE id = ? AND token = ?"
+
+	for afterID := 0; ; {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			slog.Error("Error starting rotation transaction", "error", err)
+			return result, err
+		}
+
+		rows, err := tx.QueryContext(ctx, s.rebind(query), afterID, rotateBatchSize)
+		if err != nil {
+			tx.Rollback()
+			slog.Error("Error querying mailbox tokens", "after_id", afterID, "error", err)
+			return result, err
+		}
+		type stored struct {
+			id    int
+			token string
+		}
+		var batch []stored
+		for rows.Next() {
+			var st stored
+			if err := rows.Scan(&st.id, &st.token); err != nil {
+				rows.Close()
+				tx.Rollback()
+				slog.Error("Error scanning mailbox token row", "error", err)
+				return result, err
+			}
+			batch = append(batch, st)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			tx.Rollback()
+			slog.Error("Error iterating over mailbox token rows", "error", err)
+			return result, err
+		}
+
+		for _, st := range batch {
+			afterID = st.id
+			result.Checked++
+			if !s.tokens.NeedsRotation(st.token) {
+				continue
+			}
+			result.Rotated++
+			if dryRun {
+				continue
+			}
+
+			tok, err := s.tokens.Open(st.token)
+			if err != nil {
+				tx.Rollback()
+				return result, fmt.Errorf("mailbox %d: %w", st.id, err)
+			}
+			sealed, err := s.tokens.Seal(tok)
+			if err != nil {
+				tx.Rollback()
+				return result, fmt.Errorf("mailbox %d: %w", st.id, err)
+			}
+			// The token guard leaves mailboxes whose token changed since the
+			// select for the next run.
+			if _, err := tx.ExecContext(ctx, s.rebind(update), sealed, st.id, st.token); err != nil {
+				tx.Rollback()
+				slog.Error("Error re-sealing token of mailbox", "mailbox_id", st.id, "error", err)
+				return result, err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			slog.Error("Error committing rotated tokens", "error", err)
+			return result, err
+		}
+		if len(batch) < rotateBatchSize {
+			return result, s.rotateSettings(ctx, dryRun, &result)
+		}
+	}
+}
+
+// rotateSettings re-seals the mailbox settings that need it as RotateTokens
+// does tokens, counting them in result.
+func (s *DBStore) rotateSettings(ctx context.Context, dryRun bool, result *TokenRotation) error {
+	query := "SELECT mailbox_id, name, value FROM mailbox_settings " +
+		"WHERE mailbox_id > ? OR (mailbox_id = ? AND name > ?) ORDER BY mailbox_id, name LIMIT ?"
+	update := "UPDATE mailbox_settings SET value = ? WHERE mailbox_id = ? AND name = ? AND value = ?"
+
+	type stored struct {
+		mailboxID int
+		name      string
+		value     string
+	}
+	for after := (stored{}); ; {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			slog.Error("Error starting rotation transaction", "error", err)
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, s.rebind(query), after.mailboxID, after.mailboxID, after.name, rotateBatchSize)
+		if err != nil {
+			tx.Rollback()
+			slog.Error("Error querying mailbox settings", "after_mailbox_id", after.mailboxID, "error", err)
+			return err
+		}
+		var batch []stored
+		for rows.Next() {
+			var st stored
+			if err := rows.Scan(&st.mailboxID, &st.name, &st.value); err != nil {
+				rows.Close()
+				tx.Rollback()
+				slog.Error("Error scanning mailbox setting row", "error", err)
+				return err
+			}
+			batch = append(batch, st)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			tx.Rollback()
+			slog.Error("Error iterating over mailbox setting rows", "error", err)
+			return err
+		}
+
+		for _, st := range batch {
+			after = st
+			result.SettingsChecked++
+			if !s.tokens.NeedsRotation(st.value) {
+				continue
+			}
+			result.SettingsRotated++
+			if dryRun {
+				continue
+			}
+
+			value, err := s.tokens.Open(st.value)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("mailbox %d setting %s: %w", st.mailboxID, st.name, err)
+			}
+			sealed, err := s.tokens.Seal(value)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("mailbox %d setting %s: %w", st.mailboxID, st.name, err)
+			}
+			if _, err := tx.ExecContext(ctx, s.rebind(update), sealed, st.mailboxID, st.name, st.value); err != nil {
+				tx.Rollback()
+				slog.Error("Error re-sealing mailbox setting", "setting", st.name, "mailbox_id", st.mailboxID, "error", err)
+				return err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			slog.Error("Error committing rotated settings", "error", err)
+			return err
+		}
+		if len(batch) < rotateBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"mailboxes/tokencrypt"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func testKeyring(t *testing.T, primary string) *tokencrypt.Keyring {
+	t.Helper()
+	k, err := tokencrypt.NewKeyring(primary, map[string][]byte{
+		"old": bytes.Repeat([]byte{1}, 32),
+		"new": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// sealedWith matches a token argument sealed with key id.
+type sealedWith string
+
+func (id sealedWith) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "enc:v1:"+string(id)+":")
+}
+
+func TestDBStore_SealedTokens(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	keyring := testKeyring(t, "new")
+	sealed, err := testKeyring(t, "old").Seal("token123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO mailboxes (mpi_id, token, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id")).
+		WithArgs("mpi789", sealedWith("new"), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + mailboxColumns + " FROM mailboxes WHERE id = ?")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}).
+			AddRow(1, "mpi123", sealed, "2024-07-23 12:00:00", nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + mailboxColumns + " FROM mailboxes WHERE id = ?")).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mpi_id", "token", "created_at", "updated_at"}).
+			AddRow(2, "mpi456", "enc:v1:lost:AAAA", "2024-07-23 13:00:00", nil))
+
+	store := &DBStore{db: db, driver: "sqlite3"}
+	store.SetTokenKeyring(keyring)
+
+	mb, err := store.CreateMailbox(context.Background(), Mailbox{MPIID: "mpi789", Token: "token789"})
+	if err != nil {
+		t.Fatalf("Error creating mailbox: %v", err)
+	}
+	if mb.Token != "token789" {
+		t.Errorf("Expected the plaintext token back, got %q", mb.Token)
+	}
+
+	mb, err = store.GetMailboxByID(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Error reading mailbox: %v", err)
+	}
+	if mb.Token != "token123" {
+		t.Errorf("Expected the token opened with the older key, got %q", mb.Token)
+	}
+
+	if _, err := store.GetMailboxByID(context.Background(), 2); !errors.Is(err, tokencrypt.ErrNoKey) {
+		t.Errorf("Expected ErrNoKey for a token sealed with an unknown key, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_RotateTokens(t *testing.T) {
+	sealedOld, err := testKeyring(t, "old").Seal("token2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedNew, err := testKeyring(t, "new").Seal("token3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectQuery := regexp.QuoteMeta("SELECT id, token FROM mailboxes WHERE id > ? ORDER BY id LIMIT ?")
+	updateQuery := regexp.QuoteMeta("UPDATE mailboxes SET token = ? WHERE id = ? AND token = ?")
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "token"}).
+			AddRow(1, "token1").
+			AddRow(2, sealedOld).
+			AddRow(3, sealedNew).
+			AddRow(4, "")
+	}
+	selectSettings := regexp.QuoteMeta("SELECT mailbox_id, name, value FROM mailbox_settings WHERE mailbox_id > ? OR (mailbox_id = ? AND name > ?) ORDER BY mailbox_id, name LIMIT ?")
+	updateSetting := regexp.QuoteMeta("UPDATE mailbox_settings SET value = ? WHERE mailbox_id = ? AND name = ? AND value = ?")
+	settingRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"mailbox_id", "name", "value"}).
+			AddRow(1, "webhook.secret", "s3cret").
+			AddRow(1, "webhook.url", sealedNew)
+	}
+
+	tests := []struct {
+		name      string
+		dryRun    bool
+		mockSetup func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "Re-seals plaintext and older keys",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(0, rotateBatchSize).WillReturnRows(rows())
+				mock.ExpectExec(updateQuery).WithArgs(sealedWith("new"), 1, "token1").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(updateQuery).WithArgs(sealedWith("new"), 2, sealedOld).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectSettings).WithArgs(0, 0, "", rotateBatchSize).WillReturnRows(settingRows())
+				mock.ExpectExec(updateSetting).WithArgs(sealedWith("new"), 1, "webhook.secret", "s3cret").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "Dry run",
+			dryRun: true,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(0, rotateBatchSize).WillReturnRows(rows())
+				mock.ExpectCommit()
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectSettings).WithArgs(0, 0, "", rotateBatchSize).WillReturnRows(settingRows())
+				mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+			store.SetTokenKeyring(testKeyring(t, "new"))
+
+			result, err := store.RotateTokens(context.Background(), tt.dryRun)
+			if err != nil {
+				t.Fatalf("Error rotating tokens: %v", err)
+			}
+			if result != (TokenRotation{Checked: 4, Rotated: 2, SettingsChecked: 2, SettingsRotated: 1}) {
+				t.Errorf("Expected 2 of 4 tokens and 1 of 2 settings rotated, got %+v", result)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	if _, err := (&DBStore{}).RotateTokens(context.Background(), false); err == nil {
+		t.Error("Expected an error rotating without a keyring")
+	}
+}
@@ -1,21 +1,680 @@
 package db
 
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"time"
+
+	"mailboxes/tokencrypt"
+)
+
+// TimestampLayout is the layout created_at and updated_at values are stored
+// in, in UTC.
+const TimestampLayout = "2006-01-02 15:04:05"
+
+// Mailbox is a mailbox row. UpdatedAt is set by every write through a store
+// and, like CreatedAt, is marshaled to JSON in RFC 3339.
 type Mailbox struct {
-		ID        int
-		MPIID     string
-		Token     string
-		CreatedAt string
+	ID        int       `json:"id"`
+	MPIID     string    `json:"mpi_id"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// User is a user row. UpdatedAt is set by every write through a store.
 type User struct {
-		ID           int
-		MailboxID    int
-		UserName     string
-		EmailAddress string
-		CreatedAt    string
+	ID           int       `json:"id"`
+	MailboxID    int       `json:"mailbox_id"`
+	UserName     string    `json:"user_name"`
+	EmailAddress string    `json:"email_address"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// Store streams mailboxes and users, each in ID order.
 type Store interface {
-		AllMailboxes() (<-chan Mailbox, error)
-		UsersForMailbox(mailboxID int) (<-chan User, error)
+	AllMailboxes(ctx context.Context) (<-chan Mailbox, error)
+	UsersForMailbox(ctx context.Context, mailboxID int) (<-chan User, error)
+}
+
+// SeqStore is implemented by stores that can stream mailboxes and users as
+// iterators, scanning each row only when the loop asks for it. Unlike the
+// channels of Store, an iterator reports a failed query or scan as its last
+// element instead of logging it and stopping early, and breaking out of the
+// loop closes the rows at once. Use Mailboxes and Users to iterate over any
+// Store.
+type SeqStore interface {
+	MailboxSeq(ctx context.Context) iter.Seq2[Mailbox, error]
+	UserSeq(ctx context.Context, mailboxID int) iter.Seq2[User, error]
+}
+
+// Watermark marks the newest user a watcher has already processed. Users
+// are ordered by (CreatedAt, ID) so rows sharing a timestamp are not lost.
+type Watermark struct {
+	CreatedAt string
+	UserID    int
+}
+
+// WatermarkStore is implemented by stores that can stream users created
+// after a watermark and persist named watermarks between runs.
+type WatermarkStore interface {
+	UsersCreatedSince(ctx context.Context, wm Watermark) (<-chan User, error)
+	Watermark(ctx context.Context, name string) (Watermark, error)
+	SaveWatermark(ctx context.Context, name string, wm Watermark) error
+	// Watermarks returns every saved watermark by name.
+	Watermarks(ctx context.Context) (map[string]Watermark, error)
+}
+
+// QueueStore is implemented by stores that hold users manually enqueued for
+// immediate processing, ahead of the regular schedule.
+type QueueStore interface {
+	EnqueueUser(ctx context.Context, userID int) error
+	ClaimQueuedUsers(ctx context.Context, limit int) ([]User, error)
+}
+
+// Retry is a user whose processing failed, waiting for its next attempt.
+// Retries with a higher Priority are claimed first.
+type Retry struct {
+	User          User
+	Priority      int
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+// RetryStore is implemented by stores that hold failed users for scheduled
+// redelivery. A user has at most one scheduled retry.
+type RetryStore interface {
+	ScheduleRetry(ctx context.Context, r Retry) error
+	ClaimDueRetries(ctx context.Context, now time.Time, limit int) ([]Retry, error)
+}
+
+// FullScanStore is implemented by stores that can stream every user in one
+// pass, for exports and other full-table reads.
+type FullScanStore interface {
+	AllUsers(ctx context.Context) (<-chan User, error)
+}
+
+// FullScanSeqStore is implemented by full-scan stores that can also iterate
+// over every user, reporting a read that fails partway through as the last
+// element instead of logging it and ending early. Use AllUsers to iterate
+// over any FullScanStore.
+type FullScanSeqStore interface {
+	AllUserSeq(ctx context.Context) iter.Seq2[User, error]
+}
+
+// BatchUserStore is implemented by stores that can fetch the users of many
+// mailboxes in one round trip.
+type BatchUserStore interface {
+	UsersForMailboxes(ctx context.Context, mailboxIDs []int) (<-chan User, error)
+}
+
+// OnboardStore is implemented by stores that can create mailboxes. The
+// handshake obtains the new mailbox's token and runs inside the creating
+// transaction, so a failed handshake leaves nothing behind.
+type OnboardStore interface {
+	OnboardMailbox(ctx context.Context, mpiID string, settings map[string]string, handshake func(Mailbox) (string, error)) (Mailbox, error)
+}
+
+// MergeStore is implemented by stores that can fold duplicate users into one,
+// keeping a copy of each merged row in user_merges.
+type MergeStore interface {
+	MergeUsers(ctx context.Context, into int, from []int) error
+}
+
+// ReassignStore is implemented by stores that can move users between
+// mailboxes. Users are moved in batches, each recorded in mailbox_moves.
+type ReassignStore interface {
+	// ReassignUsers moves the users of fromMailbox accepted by filter, or all
+	// of them if filter is nil, to toMailbox and returns how many moved.
+	ReassignUsers(ctx context.Context, fromMailbox, toMailbox int, filter func(User) bool) (int, error)
+}
+
+// MailboxUser is a user together with its mailbox.
+type MailboxUser struct {
+	Mailbox Mailbox
+	User    User
+}
+
+// JoinStore is implemented by stores that can stream every user with its
+// mailbox from one query, rather than one query per mailbox.
+type JoinStore interface {
+	UsersWithMailboxes(ctx context.Context) (<-chan MailboxUser, error)
+}
+
+// PageStore is implemented by stores that can read mailboxes and users in
+// bounded pages, either by offset or, for walking large tables, by keyset:
+// the *After methods return rows with IDs above the last one already seen.
+type PageStore interface {
+	AllMailboxesPage(ctx context.Context, limit, offset int) ([]Mailbox, error)
+	MailboxesAfter(ctx context.Context, afterID, limit int) ([]Mailbox, error)
+	UsersForMailboxPage(ctx context.Context, mailboxID, limit, offset int) ([]User, error)
+	UsersForMailboxAfter(ctx context.Context, mailboxID, afterID, limit int) ([]User, error)
+}
+
+// TableStats is the size of one table. TableBytes and IndexBytes are zero
+// where the backend cannot size tables individually.
+type TableStats struct {
+	Name       string `json:"name"`
+	Rows       int64  `json:"rows"`
+	TableBytes int64  `json:"table_bytes"`
+	IndexBytes int64  `json:"index_bytes"`
+}
+
+// MailboxSize is the number of users in a mailbox.
+type MailboxSize struct {
+	MailboxID int   `json:"mailbox_id"`
+	Users     int64 `json:"users"`
+}
+
+// Stats describes how large the database and its tables have grown.
+type Stats struct {
+	DatabaseBytes    int64         `json:"database_bytes"`
+	Tables           []TableStats  `json:"tables"`
+	LargestMailboxes []MailboxSize `json:"largest_mailboxes"`
+}
+
+// StatsStore is implemented by stores that can report table sizes from their
+// backend's catalog, along with the top mailboxes by user count.
+type StatsStore interface {
+	Stats(ctx context.Context, top int) (Stats, error)
+}
+
+// ImportRecord is one user to import, with the MPI ID of the mailbox it
+// belongs to.
+type ImportRecord struct {
+	MPIID        string `json:"mpi_id"`
+	UserName     string `json:"user_name"`
+	EmailAddress string `json:"email_address"`
+}
+
+// ImportResult reports what an import created, or would create on a dry
+// run. Users whose email address is already taken are left alone and listed
+// in Existing.
+type ImportResult struct {
+	Mailboxes []string
+	Users     []ImportRecord
+	Existing  []ImportRecord
+}
+
+// ImportStore is implemented by stores that can bulk-create mailboxes and
+// users in one transaction.
+type ImportStore interface {
+	ImportUsers(ctx context.Context, records []ImportRecord, dryRun bool) (ImportResult, error)
+}
+
+// ProcessedUser is an entry in the ledger of users processed successfully.
+type ProcessedUser struct {
+	MailboxID int
+	UserID    int
+}
+
+// LedgerStore is implemented by stores that keep a ledger of processed
+// users, so incremental runs can skip users already handled.
+type LedgerStore interface {
+	RecordProcessed(ctx context.Context, p ProcessedUser) error
+	WasProcessed(ctx context.Context, p ProcessedUser) (bool, error)
+	// ProcessedUsers streams every ledger entry, for rebuilding indexes.
+	ProcessedUsers(ctx context.Context) (<-chan ProcessedUser, error)
+}
+
+// AccessStore is implemented by stores that keep per-mailbox access counts,
+// so the most requested mailboxes can be cached ahead of demand.
+type AccessStore interface {
+	// RecordMailboxAccess adds hits, keyed by mailbox ID, to the counts.
+	RecordMailboxAccess(ctx context.Context, hits map[int]int) error
+	// HotMailboxes returns the IDs of the n most accessed mailboxes, most
+	// accessed first.
+	HotMailboxes(ctx context.Context, n int) ([]int, error)
+}
+
+// Run statuses.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// Run is the record of one pipeline run. Annotations are the key-value pairs
+// processors and scripts attached to it, such as a campaign ID.
+type Run struct {
+	ID          int               `json:"id"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	Status      string            `json:"status"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// RunStore is implemented by stores that keep a history of pipeline runs.
+type RunStore interface {
+	// RecordRun stores a finished run and returns it with its ID set.
+	RecordRun(ctx context.Context, run Run) (Run, error)
+	// RecentRuns returns the n latest runs, newest first.
+	RecentRuns(ctx context.Context, n int) ([]Run, error)
+}
+
+// RunFailure is a user that failed in a run, or with a UserID of 0 a
+// mailbox that failed as a whole: its users could not be read, or it
+// stopped before they were all handled.
+type RunFailure struct {
+	MailboxID int    `json:"mailbox_id"`
+	UserID    int    `json:"user_id,omitempty"`
+	Error     string `json:"error"`
+}
+
+// RunFailureStore is implemented by stores that keep what failed in each
+// run, so it can be processed again.
+type RunFailureStore interface {
+	// RecordRunFailures stores the failures of the run with ID runID.
+	RecordRunFailures(ctx context.Context, runID int, failures []RunFailure) error
+	// RunFailures returns the failures of the run with ID runID, by
+	// mailbox and user ID.
+	RunFailures(ctx context.Context, runID int) ([]RunFailure, error)
+}
+
+// Lease is a named lock that Holder keeps until ExpiresAt unless it renews
+// it.
+type Lease struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LeaseStore is implemented by stores that hand out leases shared by every
+// instance using the same database.
+type LeaseStore interface {
+	// AcquireLease takes the lease name for holder until ttl from now, if it
+	// is free, already held by holder, or expired more than takeover ago. A
+	// negative takeover never takes over an expired lease. It reports false,
+	// with the current lease, if another holder keeps it.
+	AcquireLease(ctx context.Context, name, holder string, ttl, takeover time.Duration) (Lease, bool, error)
+	// RenewLease extends holder's lease to ttl from now. It returns
+	// ErrLeaseLost if the lease was taken over or released.
+	RenewLease(ctx context.Context, name, holder string, ttl time.Duration) (Lease, error)
+	// ReleaseLease gives up holder's lease. An empty holder releases the
+	// lease whoever holds it.
+	ReleaseLease(ctx context.Context, name, holder string) error
+	// Leases returns every lease, by name.
+	Leases(ctx context.Context) ([]Lease, error)
+}
+
+// AdvisoryLocker is implemented by stores whose database has session-level
+// advisory locks, which are freed when the connection holding them closes.
+type AdvisoryLocker interface {
+	// TryAdvisoryLock takes the lock name without waiting and reports
+	// whether it got it. unlock frees it and the connection holding it.
+	TryAdvisoryLock(ctx context.Context, name string) (unlock func() error, ok bool, err error)
+}
+
+// Checkpoint is the saved progress of an unfinished run: the mailboxes it
+// completed and, in the others, the users it processed.
+type Checkpoint struct {
+	Mailboxes map[int]bool
+	Users     map[ProcessedUser]bool
+}
+
+// MailboxDone reports whether the run completed mailboxID.
+func (c Checkpoint) MailboxDone(mailboxID int) bool { return c.Mailboxes[mailboxID] }
+
+// UserDone reports whether the run processed p.
+func (c Checkpoint) UserDone(p ProcessedUser) bool { return c.Users[p] }
+
+// CheckpointStore is implemented by stores that save a run's progress as it
+// goes, so an interrupted run can resume instead of starting over.
+type CheckpointStore interface {
+	CheckpointUser(ctx context.Context, p ProcessedUser) error
+	// CheckpointMailbox records that every user of mailboxID is done,
+	// replacing its users' entries.
+	CheckpointMailbox(ctx context.Context, mailboxID int) error
+	LoadCheckpoint(ctx context.Context) (Checkpoint, error)
+	// ClearCheckpoint discards the saved progress, for a run that finished
+	// or one starting afresh.
+	ClearCheckpoint(ctx context.Context) error
+	// ReplaceCheckpoint replaces the saved progress with c, for progress
+	// restored from a copy kept elsewhere.
+	ReplaceCheckpoint(ctx context.Context, c Checkpoint) error
+}
+
+// Mailbox event kinds. MailboxSnapshot stands in for the events compacted
+// into it, its data holding the state they left the mailbox in.
+const (
+	MailboxCreated      = "created"
+	MailboxTokenRotated = "token_rotated"
+	MailboxSuspended    = "suspended"
+	MailboxResumed      = "resumed"
+	MailboxArchived     = "archived"
+	MailboxSnapshot     = "snapshot"
+)
+
+// MailboxEvent is one entry in a mailbox's append-only event log. Data
+// carries details of the event, such as the reason for a suspension.
+type MailboxEvent struct {
+	ID         int               `json:"id"`
+	MailboxID  int               `json:"mailbox_id"`
+	Kind       string            `json:"kind"`
+	Data       map[string]string `json:"data,omitempty"`
+	OccurredAt time.Time         `json:"occurred_at"`
+}
+
+// EventStore is implemented by stores that can keep a log of mailbox
+// lifecycle events. Once enabled, the store appends created and
+// token_rotated events itself as mailboxes are written.
+type EventStore interface {
+	SetMailboxEvents(enabled bool)
+	AppendMailboxEvent(ctx context.Context, e MailboxEvent) (MailboxEvent, error)
+	// MailboxEvents returns a mailbox's events, oldest first.
+	MailboxEvents(ctx context.Context, mailboxID int) ([]MailboxEvent, error)
+	// AllMailboxEvents streams every event ordered by mailbox, then age.
+	AllMailboxEvents(ctx context.Context) (<-chan MailboxEvent, error)
+	// CompactMailboxEvents replaces the mailbox's events up to and including
+	// throughID with snapshot.
+	CompactMailboxEvents(ctx context.Context, mailboxID, throughID int, snapshot MailboxEvent) error
+}
+
+// AuditEntry is one mutation recorded in the audit log: who made it, what
+// it did to which mailbox or user, and the entity as JSON before and after.
+// Before is null for a create and After for a delete.
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Entity     string          `json:"entity"`
+	EntityID   int             `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// AuditStore is implemented by stores that can keep an audit log of the
+// writes made through them. Once enabled, the store records every create,
+// update and delete of a mailbox or user itself.
+type AuditStore interface {
+	SetAuditLog(enabled bool, defaultActor string)
+	// AuditLog returns the entries recorded at or after since, oldest
+	// first, at most limit of them unless limit is 0.
+	AuditLog(ctx context.Context, since time.Time, limit int) ([]AuditEntry, error)
+}
+
+// Quota is a set of soft limits on what one tenant may store. Zero means
+// unlimited.
+type Quota struct {
+	MaxMailboxes       int `mapstructure:"max_mailboxes"`
+	MaxUsersPerMailbox int `mapstructure:"max_users_per_mailbox"`
+}
+
+// Quotas are the limits a QuotaStore enforces: Tenants' entries replace
+// Default for the tenants they name. Mailboxes without a tenant are held to
+// Default.
+type Quotas struct {
+	Default Quota
+	Tenants map[string]Quota
+}
+
+// For returns the quota tenant is held to.
+func (q Quotas) For(tenant string) Quota {
+	if quota, ok := q.Tenants[tenant]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+// enforced reports whether any limit is set.
+func (q Quotas) enforced() bool {
+	if q.Default != (Quota{}) {
+		return true
+	}
+	for _, quota := range q.Tenants {
+		if quota != (Quota{}) {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaStore is implemented by stores that assign mailboxes to tenants and
+// enforce per-tenant quotas on writes. Mailboxes created in a context scoped
+// to a tenant with scope.WithTenant are the tenant's, and reads and writes by
+// ID in such a context only see the tenant's mailboxes and their users. The
+// quotas are soft: they are checked in the writing transaction, but
+// concurrent writes may overshoot them by a few.
+type QuotaStore interface {
+	SetQuotas(q Quotas)
+	// MailboxTenant returns the tenant of mailboxID, or "" if it has none.
+	MailboxTenant(ctx context.Context, mailboxID int) (string, error)
+	// MailboxTenants returns the tenant of every mailbox that has one, by
+	// mailbox ID.
+	MailboxTenants(ctx context.Context) (map[int]string, error)
+}
+
+// Migration is one embedded schema change, with the SQL that applies it and
+// the SQL that reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied; AppliedAt is
+// empty while it is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt string
+}
+
+// MigrateStore is implemented by stores that can apply the embedded schema
+// migrations and track them in a schema_migrations table.
+type MigrateStore interface {
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	// MigrateUp applies pending migrations up to version to, or all of them
+	// when to is zero.
+	MigrateUp(ctx context.Context, to int) ([]Migration, error)
+	// MigrateDown reverts the latest steps applied migrations.
+	MigrateDown(ctx context.Context, steps int) ([]Migration, error)
+}
+
+// TokenRotation counts the mailboxes RotateTokens looked at and those whose
+// tokens it re-sealed, or would have with dryRun, and likewise the mailbox
+// settings.
+type TokenRotation struct {
+	Checked         int `json:"checked"`
+	Rotated         int `json:"rotated"`
+	SettingsChecked int `json:"settings_checked"`
+	SettingsRotated int `json:"settings_rotated"`
+}
+
+// TokenStore is implemented by stores that can encrypt mailbox tokens at
+// rest.
+type TokenStore interface {
+	SetTokenKeyring(k *tokencrypt.Keyring)
+	// RotateTokens re-seals every token not sealed with the keyring's
+	// primary key.
+	RotateTokens(ctx context.Context, dryRun bool) (TokenRotation, error)
+}
+
+// PartitionStore is implemented by stores whose users table can be
+// partitioned by month of created_at.
+type PartitionStore interface {
+	SetPartitionedUsers(partitioned bool)
+	// EnsureUserPartitions creates any missing partitions for the months from
+	// from through through and returns the names of those it created.
+	EnsureUserPartitions(ctx context.Context, from, through time.Time) ([]string, error)
+}
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// idempotency key. Status is zero while the request is still in progress.
+// Header holds the response's headers, as in http.Header.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+// IdempotencyStore is implemented by stores that persist responses to
+// requests carrying an idempotency key, so retries can be answered without
+// repeating the request.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims key for a new request and reports true, or
+	// returns the existing record if the key is already claimed. Claims older
+	// than ttl are discarded first, as are claims still in progress after
+	// lease, whose request is taken to have died with its server.
+	ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string][]string, body []byte) error
+	// ReleaseIdempotencyKey drops a claim so the request can be retried.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// MailboxStore is implemented by stores that can create, read, update and
+// delete individual mailboxes.
+type MailboxStore interface {
+	GetMailboxByID(ctx context.Context, id int) (Mailbox, error)
+	CreateMailbox(ctx context.Context, mb Mailbox) (Mailbox, error)
+	// UpdateMailbox writes the given fields of mb, FieldMPIID and
+	// FieldToken, or both if none are given, leaving the others as they are.
+	UpdateMailbox(ctx context.Context, mb Mailbox, fields ...string) error
+	DeleteMailbox(ctx context.Context, id int) error
+}
+
+// UserStore is implemented by stores that can create, read, update and
+// delete individual users.
+type UserStore interface {
+	GetUserByID(ctx context.Context, id int) (User, error)
+	// GetUserByEmail returns the user with the lowest ID among those with
+	// the given email address.
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	CreateUser(ctx context.Context, user User) (User, error)
+	// UpdateUser writes the given fields of user, FieldUserName and
+	// FieldEmailAddress, or both if none are given, leaving the others as
+	// they are.
+	UpdateUser(ctx context.Context, user User, fields ...string) error
+	DeleteUser(ctx context.Context, id int) error
+}
+
+// UserChange is one field of a user changed by a write. Creates and deletes
+// record every field, with Old or New empty respectively.
+type UserChange struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	Op        string `json:"op"`
+	Field     string `json:"field"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	ChangedAt string `json:"changed_at"`
+}
+
+// ChangeFeedStore is implemented by stores that record field-level user
+// changes in an outbox as part of each write, for delivery to sinks.
+type ChangeFeedStore interface {
+	UserChangesAfter(ctx context.Context, afterID, limit int) ([]UserChange, error)
+}
+
+// MailboxActivity are the processing signals of one mailbox: its users, how
+// many of them wait in the retry queue and when the ledger last recorded one
+// processed.
+type MailboxActivity struct {
+	MailboxID       int
+	Users           int
+	FailingUsers    int
+	LastProcessedAt time.Time
+}
+
+// MailboxSummary is the health of a mailbox as last computed, kept in the
+// mailbox_summary table. HealthScore runs from 100, healthy, down to 0.
+type MailboxSummary struct {
+	MailboxID       int       `json:"mailbox_id"`
+	HealthScore     int       `json:"health_score"`
+	TokenValid      bool      `json:"token_valid"`
+	Verification    string    `json:"verification"`
+	FailureRate     float64   `json:"failure_rate"`
+	LastProcessedAt time.Time `json:"last_processed_at"`
+	Reasons         []string  `json:"reasons,omitempty"`
+	ComputedAt      time.Time `json:"computed_at"`
+}
+
+// HealthStore is implemented by stores that can gather mailbox activity and
+// keep the health summaries computed from it.
+type HealthStore interface {
+	// MailboxActivity returns the activity of every mailbox that has users,
+	// by mailbox ID.
+	MailboxActivity(ctx context.Context) (map[int]MailboxActivity, error)
+	// SaveMailboxSummaries replaces the summaries of the mailboxes given.
+	SaveMailboxSummaries(ctx context.Context, summaries []MailboxSummary) error
+	// MailboxSummaries returns up to limit summaries, least healthy first.
+	MailboxSummaries(ctx context.Context, limit int) ([]MailboxSummary, error)
+	// MailboxSummary returns the summary of mailboxID, or ErrNoSummary.
+	MailboxSummary(ctx context.Context, mailboxID int) (MailboxSummary, error)
+}
+
+// PreflightStore is implemented by stores that can check, without changing
+// anything, that the pipeline may write to them and how their clock compares
+// to ours.
+type PreflightStore interface {
+	// CheckWritable checks that the tables the pipeline writes may be
+	// written.
+	CheckWritable(ctx context.Context) error
+	// ServerTime returns the database server's current time.
+	ServerTime(ctx context.Context) (time.Time, error)
+}
+
+// ProvenanceStore is implemented by stores that can record which ingestion
+// path each user was created through, such as the API or an import file.
+// Once enabled, the store records the source the write's context carries,
+// or defaultSource if it carries none, for every user it creates.
+type ProvenanceStore interface {
+	SetProvenance(enabled bool, defaultSource string)
+	// UserSources returns the source recorded at or after since for each
+	// user that has one, by user ID; a zero since returns every source.
+	UserSources(ctx context.Context, since time.Time) (map[int]string, error)
+}
+
+// StatementStore is implemented by stores that can prepare the reads a run
+// repeats for every mailbox once and reuse them.
+type StatementStore interface {
+	SetPreparedStatements(enabled bool)
+}
+
+// MailboxSettingsStore is implemented by stores that keep named settings
+// per mailbox, such as where the webhook sends its users.
+type MailboxSettingsStore interface {
+	// MailboxSettings returns the settings of mailboxID by name.
+	MailboxSettings(ctx context.Context, mailboxID int) (map[string]string, error)
+	// SetMailboxSetting sets one setting, failing with ErrMailboxNotFound
+	// if the mailbox does not exist.
+	SetMailboxSetting(ctx context.Context, mailboxID int, name, value string) error
+	DeleteMailboxSetting(ctx context.Context, mailboxID int, name string) error
+}
+
+// ProcessorState is the processor configuration switched to through the
+// store, shared by every process using the same database.
+type ProcessorState struct {
+	Active    string    `json:"active"`
+	Previous  string    `json:"previous,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProcessorStateStore is implemented by stores that share the active
+// processor configuration between processes, so a switch made through one
+// reaches the runs of every other.
+type ProcessorStateStore interface {
+	// ProcessorState returns the shared state, which is the zero state
+	// until a configuration is switched to.
+	ProcessorState(ctx context.Context) (ProcessorState, error)
+	// UseProcessor makes active the active configuration, recording the
+	// one it replaces, or current if none was switched to yet, as previous.
+	UseProcessor(ctx context.Context, active, current string) (ProcessorState, error)
+	// RollbackProcessor swaps the active and previous configurations. It
+	// reports false if there is no previous configuration.
+	RollbackProcessor(ctx context.Context) (ProcessorState, bool, error)
+}
+
+// BulkUserStore is implemented by stores that can create many users at
+// once, far faster than one CreateUser call each, such as for imports and
+// sync jobs.
+type BulkUserStore interface {
+	// CreateUsersBatch creates every user or none, returning them with
+	// their IDs in the same order.
+	CreateUsersBatch(ctx context.Context, users []User) ([]User, error)
 }
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrUserNotFound is returned when a user does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+func (s *DBStore) getUser(ctx context.Context, filter string, arg any) (User, error) {
+	cond, args := tenantScope(ctx, "mailbox_id")
+	query := "SELECT " + userColumns + " FROM users WHERE " + filter + cond + " ORDER BY id LIMIT 1"
+
+	var user User
+	err := s.db.QueryRowContext(ctx, s.rebind(query), append([]any{arg}, args...)...).Scan(&user.ID, &user.MailboxID, &user.UserName, &user.EmailAddress, scanTime(&user.CreatedAt), scanTime(&user.UpdatedAt))
+	if err == sql.ErrNoRows {
+		return User{}, fmt.Errorf("%w: %v", ErrUserNotFound, arg)
+	}
+	if err != nil {
+		slog.Error("Error querying user", "user", arg, "error", err)
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (s *DBStore) GetUserByID(ctx context.Context, id int) (User, error) {
+	return s.getUser(ctx, "id = ?", id)
+}
+
+func (s *DBStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	return s.getUser(ctx, "email_address = ?", email)
+}
+
+// CreateUser inserts user into an existing mailbox, records it in
+// user_changes and returns it with its new ID. A zero CreatedAt is set to
+// the current time; UpdatedAt always is. It fails with a *QuotaError if the
+// mailbox is full under its tenant's quota.
+func (s *DBStore) CreateUser(ctx context.Context, user User) (User, error) {
+	user.UpdatedAt = now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = user.UpdatedAt
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting create transaction", "error", err)
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	var mailboxID int
+	cond, args := tenantScope(ctx, "id")
+	err = tx.QueryRowContext(ctx, s.rebind("SELECT id FROM mailboxes WHERE id = ?"+cond), append([]any{user.MailboxID}, args...)...).Scan(&mailboxID)
+	if err == sql.ErrNoRows {
+		return User{}, fmt.Errorf("%w: %d", ErrMailboxNotFound, user.MailboxID)
+	}
+	if err != nil {
+		slog.Error("Error looking up mailbox", "mailbox_id", user.MailboxID, "error", err)
+		return User{}, err
+	}
+	if err := s.checkUserQuota(ctx, tx, user.MailboxID); err != nil {
+		return User{}, err
+	}
+
+	query := "INSERT INTO users (mailbox_id, user_name, email_address, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	user.ID, err = s.insertID(ctx, tx, query, user.MailboxID, user.UserName, user.EmailAddress,
+		FormatTimestamp(user.CreatedAt), FormatTimestamp(user.UpdatedAt))
+	if err != nil {
+		slog.Error("Error creating user", "user_name", user.UserName, "error", err)
+		return User{}, err
+	}
+
+	if err := s.recordChanges(ctx, tx, diffUser(nil, &user)); err != nil {
+		return User{}, err
+	}
+	if err := s.logAudit(ctx, tx, ChangeCreate, AuditUser, user.ID, nil, &user); err != nil {
+		return User{}, err
+	}
+	if err := s.recordProvenance(ctx, tx, user.ID); err != nil {
+		return User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing user", "user_name", user.UserName, "error", err)
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser overwrites the name and email address of the user with user.ID,
+// or only the fields given, recording each changed field in user_changes.
+// Use ReassignUsers to move users between mailboxes.
+func (s *DBStore) UpdateUser(ctx context.Context, user User, fields ...string) error {
+	mask, err := fieldMask(fields, FieldUserName, FieldEmailAddress)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting update transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := s.lockedUser(ctx, tx, user.ID)
+	if err != nil {
+		return err
+	}
+	after := before
+	var sets []string
+	var args []any
+	if mask[FieldUserName] {
+		after.UserName = user.UserName
+		sets, args = append(sets, "user_name = ?"), append(args, user.UserName)
+	}
+	if mask[FieldEmailAddress] {
+		after.EmailAddress = user.EmailAddress
+		sets, args = append(sets, "email_address = ?"), append(args, user.EmailAddress)
+	}
+	after.UpdatedAt = now()
+	sets, args = append(sets, "updated_at = ?"), append(args, FormatTimestamp(after.UpdatedAt))
+
+	key, keyArgs := s.userKey(before)
+	query := "UPDATE users SET " + strings.Join(sets, ", ") + " WHERE " + key
+	args = append(args, keyArgs...)
+	if _, err := tx.ExecContext(ctx, s.rebind(query), args...); err != nil {
+		slog.Error("Error updating user", "user_id", user.ID, "error", err)
+		return err
+	}
+
+	if err := s.recordChanges(ctx, tx, diffUser(&before, &after)); err != nil {
+		return err
+	}
+	if err := s.logAudit(ctx, tx, ChangeUpdate, AuditUser, user.ID, &before, &after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing update of user", "user_id", user.ID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user along with any queued work or retry for it,
+// recording the deletion in user_changes.
+func (s *DBStore) DeleteUser(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting delete transaction", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := s.lockedUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"work_queue", "retry_queue"} {
+		if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM "+table+" WHERE user_id = ?"), id); err != nil {
+			slog.Error("Error deleting queued work for user", "user_id", id, "error", err)
+			return err
+		}
+	}
+	if s.provenance {
+		if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM user_provenance WHERE user_id = ?"), id); err != nil {
+			slog.Error("Error deleting provenance of user", "user_id", id, "error", err)
+			return err
+		}
+	}
+
+	key, keyArgs := s.userKey(before)
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM users WHERE "+key), keyArgs...); err != nil {
+		slog.Error("Error deleting user", "user_id", id, "error", err)
+		return err
+	}
+
+	if err := s.recordChanges(ctx, tx, diffUser(&before, nil)); err != nil {
+		return err
+	}
+	if err := s.logAudit(ctx, tx, ChangeDelete, AuditUser, id, &before, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing delete of user", "user_id", id, "error", err)
+		return err
+	}
+
+	return nil
+}
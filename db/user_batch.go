@@ -0,0 +1,222 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"mailboxes/scope"
+)
+
+// userInsertBatch is how many rows one multi-row INSERT writes. At five
+// parameters a row, and three user_changes rows a user, a statement stays
+// well under the parameter limits of SQLite (32766) and PostgreSQL (65535).
+const userInsertBatch = 500
+
+// CreateUsersBatch creates users in one transaction, userInsertBatch rows
+// per INSERT, and returns them with their IDs and timestamps in the same
+// order. Their changes and provenance are recorded the same way; audit_log
+// entries, when on, are still written one by one. A mailbox that does not
+// exist, or a quota the users would exceed, fails the whole batch.
+//
+// Rows go in with multi-row VALUES under IDs reserved for them first, as
+// RETURNING gives the IDs of a multi-row INSERT back in no promised order.
+func (s *DBStore) CreateUsersBatch(ctx context.Context, users []User) ([]User, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	users = slices.Clone(users)
+	createdAt := now()
+	perMailbox := map[int]int{}
+	var mailboxIDs []int
+	for i := range users {
+		users[i].UpdatedAt = createdAt
+		if users[i].CreatedAt.IsZero() {
+			users[i].CreatedAt = createdAt
+		}
+		if perMailbox[users[i].MailboxID] == 0 {
+			mailboxIDs = append(mailboxIDs, users[i].MailboxID)
+		}
+		perMailbox[users[i].MailboxID]++
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting batch create transaction", "error", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cond, args := tenantScope(ctx, "id")
+	for _, mailboxID := range mailboxIDs {
+		var id int
+		err := tx.QueryRowContext(ctx, s.rebind("SELECT id FROM mailboxes WHERE id = ?"+cond), append([]any{mailboxID}, args...)...).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %d", ErrMailboxNotFound, mailboxID)
+		}
+		if err != nil {
+			slog.Error("Error looking up mailbox", "mailbox_id", mailboxID, "error", err)
+			return nil, err
+		}
+		if err := s.checkUserQuotaFor(ctx, tx, mailboxID, perMailbox[mailboxID]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.insertUsers(ctx, tx, users); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("Error committing users", "users", len(users), "error", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// insertUsers inserts users inside tx, setting their IDs, and records their
+// creation in user_changes, the audit_log and user_provenance. Their
+// mailboxes and quotas must have been checked.
+func (s *DBStore) insertUsers(ctx context.Context, tx *sql.Tx, users []User) error {
+	for chunk := range slices.Chunk(users, userInsertBatch) {
+		if err := s.insertUserRows(ctx, tx, chunk); err != nil {
+			return err
+		}
+	}
+
+	var changes [][]any
+	for i := range users {
+		for _, c := range diffUser(nil, &users[i]) {
+			changes = append(changes, []any{c.UserID, c.Op, c.Field, c.Old, c.New})
+		}
+		if err := s.logAudit(ctx, tx, ChangeCreate, AuditUser, users[i].ID, nil, &users[i]); err != nil {
+			return err
+		}
+	}
+	if err := s.insertRows(ctx, tx, "user_changes", []string{"user_id", "op", "field", "old_value", "new_value"}, changes); err != nil {
+		slog.Error("Error recording user changes", "users", len(users), "error", err)
+		return err
+	}
+
+	if !s.provenance {
+		return nil
+	}
+	source := cmp.Or(scope.Source(ctx), s.provenanceSource)
+	recordedAt := FormatTimestamp(now())
+	provenance := make([][]any, len(users))
+	for i, user := range users {
+		provenance[i] = []any{user.ID, source, recordedAt}
+	}
+	if err := s.insertRows(ctx, tx, "user_provenance", []string{"user_id", "source", "recorded_at"}, provenance); err != nil {
+		slog.Error("Error recording user provenance", "users", len(users), "error", err)
+		return err
+	}
+	return nil
+}
+
+// insertUserRows inserts up to userInsertBatch users with one statement,
+// under the IDs reserveUserIDs gives them. A single user, and every user on
+// drivers that cannot reserve IDs, is inserted on its own.
+func (s *DBStore) insertUserRows(ctx context.Context, tx *sql.Tx, users []User) error {
+	const query = "INSERT INTO users (mailbox_id, user_name, email_address, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+	if len(users) == 1 || !s.isPostgres() && !sqliteDrivers[s.driver] {
+		for i, user := range users {
+			id, err := s.insertID(ctx, tx, query, user.MailboxID, user.UserName, user.EmailAddress,
+				FormatTimestamp(user.CreatedAt), FormatTimestamp(user.UpdatedAt))
+			if err != nil {
+				slog.Error("Error creating user", "user_name", user.UserName, "error", err)
+				return err
+			}
+			users[i].ID = id
+		}
+		return nil
+	}
+
+	ids, err := s.reserveUserIDs(ctx, tx, len(users))
+	if err != nil {
+		slog.Error("Error reserving user IDs", "users", len(users), "error", err)
+		return err
+	}
+	args := make([]any, 0, 6*len(users))
+	for i := range users {
+		users[i].ID = ids[i]
+		user := users[i]
+		args = append(args, user.ID, user.MailboxID, user.UserName, user.EmailAddress, FormatTimestamp(user.CreatedAt), FormatTimestamp(user.UpdatedAt))
+	}
+	columns := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+	if _, err := tx.ExecContext(ctx, s.rebind(valuesQuery("users", columns, len(users))), args...); err != nil {
+		slog.Error("Error creating users", "users", len(users), "error", err)
+		return err
+	}
+	return nil
+}
+
+// reserveUserIDs returns n new user IDs for tx to insert users under.
+// PostgreSQL draws them from the users identity sequence. SQLite takes those
+// above the highest in use: it serializes writes, so a writer that gets in
+// first fails tx rather than taking the same IDs.
+func (s *DBStore) reserveUserIDs(ctx context.Context, tx *sql.Tx, n int) ([]int, error) {
+	ids := make([]int, 0, n)
+	if !s.isPostgres() {
+		var last int
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM users").Scan(&last); err != nil {
+			return nil, err
+		}
+		for i := 1; i <= n; i++ {
+			ids = append(ids, last+i)
+		}
+		return ids, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, s.rebind("SELECT nextval(pg_get_serial_sequence('users', 'id')) FROM generate_series(1, ?)"), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) != n {
+		return nil, fmt.Errorf("reserved %d user IDs, want %d", len(ids), n)
+	}
+	return ids, nil
+}
+
+// insertRows inserts rows, each a value for every column, into table with
+// one statement per userInsertBatch rows.
+func (s *DBStore) insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	for chunk := range slices.Chunk(rows, userInsertBatch) {
+		args := make([]any, 0, len(columns)*len(chunk))
+		for _, row := range chunk {
+			args = append(args, row...)
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind(valuesQuery(table, columns, len(chunk))), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valuesQuery returns an INSERT of n rows into table's columns.
+func valuesQuery(table string, columns []string, n int) string {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES ")
+	for i := range n {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(row)
+	}
+	return b.String()
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"mailboxes/scope"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// migratedStore returns a DBStore on a new SQLite file with every
+// migration applied and one mailbox, 1.
+func migratedStore(tb testing.TB) *DBStore {
+	tb.Helper()
+	opened, err := NewSQLiteStore("sqlite3", SQLiteConfig{Path: filepath.Join(tb.TempDir(), "mailboxes.db"), JournalMode: "WAL"})
+	if err != nil {
+		tb.Fatalf("Error opening store: %v", err)
+	}
+	store := opened.(*DBStore)
+	tb.Cleanup(func() { store.db.Close() })
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		tb.Fatalf("Error migrating: %v", err)
+	}
+	if _, err := store.db.Exec("INSERT INTO mailboxes (id, mpi_id, token, created_at, updated_at) VALUES (1, 'mpi123', '', '2024-07-23 12:00:00', '2024-07-23 12:00:00')"); err != nil {
+		tb.Fatalf("Error creating mailbox: %v", err)
+	}
+	return store
+}
+
+func batchOfUsers(n, offset int) []User {
+	users := make([]User, n)
+	for i := range users {
+		name := fmt.Sprintf("user%d", offset+i)
+		users[i] = User{MailboxID: 1, UserName: name, EmailAddress: name + "@example.com"}
+	}
+	return users
+}
+
+func TestDBStore_CreateUsersBatch(t *testing.T) {
+	store := migratedStore(t)
+	store.SetProvenance(true, "cli")
+	ctx := scope.WithSource(context.Background(), "sync")
+
+	// One more than a statement takes, so the batch spans two.
+	users, err := store.CreateUsersBatch(ctx, batchOfUsers(userInsertBatch+1, 0))
+	if err != nil {
+		t.Fatalf("Error creating users: %v", err)
+	}
+	if len(users) != userInsertBatch+1 {
+		t.Fatalf("Expected %d users back, got %d", userInsertBatch+1, len(users))
+	}
+	for _, user := range users {
+		stored, err := store.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Error reading user %d: %v", user.ID, err)
+		}
+		if stored.UserName != user.UserName || stored.EmailAddress != user.EmailAddress {
+			t.Fatalf("Expected user %d to be %s, got %s", user.ID, user.UserName, stored.UserName)
+		}
+	}
+
+	var changes, sources int
+	store.db.QueryRow("SELECT COUNT(*) FROM user_changes").Scan(&changes)
+	store.db.QueryRow("SELECT COUNT(*) FROM user_provenance WHERE source = 'sync'").Scan(&sources)
+	if changes != 3*len(users) || sources != len(users) {
+		t.Errorf("Expected %d changes and %d sources, got %d and %d", 3*len(users), len(users), changes, sources)
+	}
+}
+
+func TestDBStore_CreateUsersBatch_Postgres(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+	store := &DBStore{db: db, driver: "pgx"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = $1")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	// The sequence's values come back in no promised order; each user is
+	// inserted under the one it was given.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT nextval(pg_get_serial_sequence('users', 'id')) FROM generate_series(1, $1)")).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(9).AddRow(8))
+	columns := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+	mock.ExpectExec(regexp.QuoteMeta(store.rebind(valuesQuery("users", columns, 2)))).
+		WithArgs(9, 1, "user0", "user0@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(),
+			8, 1, "user1", "user1@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_changes")).WillReturnResult(sqlmock.NewResult(0, 6))
+	mock.ExpectCommit()
+
+	users, err := store.CreateUsersBatch(context.Background(), batchOfUsers(2, 0))
+	if err != nil {
+		t.Fatalf("Error creating users: %v", err)
+	}
+	if users[0].ID != 9 || users[0].UserName != "user0" || users[1].ID != 8 || users[1].UserName != "user1" {
+		t.Errorf("Expected user0 as 9 and user1 as 8, got %+v", users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_CreateUsersBatch_Rejected(t *testing.T) {
+	store := migratedStore(t)
+	ctx := context.Background()
+
+	users := append(batchOfUsers(2, 0), User{MailboxID: 9, UserName: "lost", EmailAddress: "lost@example.com"})
+	if _, err := store.CreateUsersBatch(ctx, users); !errors.Is(err, ErrMailboxNotFound) {
+		t.Errorf("Expected ErrMailboxNotFound, got %v", err)
+	}
+
+	store.SetQuotas(Quotas{Default: Quota{MaxUsersPerMailbox: 2}})
+	var quotaErr *QuotaError
+	if _, err := store.CreateUsersBatch(ctx, batchOfUsers(3, 0)); !errors.As(err, &quotaErr) {
+		t.Errorf("Expected a QuotaError, got %v", err)
+	}
+
+	var n int
+	store.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	if n != 0 {
+		t.Errorf("Expected a rejected batch to create no users, got %d", n)
+	}
+}
+
+func BenchmarkDBStore_CreateUsersBatch(b *testing.B) {
+	const n = 1000
+	b.Run("CreateUser", func(b *testing.B) {
+		store := migratedStore(b)
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			for _, user := range batchOfUsers(n, i*n) {
+				if _, err := store.CreateUser(ctx, user); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		store := migratedStore(b)
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			if _, err := store.CreateUsersBatch(ctx, batchOfUsers(n, i*n)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_GetUser(t *testing.T) {
+	userRows := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+	user1 := User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: ts("2024-07-23 12:30:00")}
+
+	tests := []struct {
+		name         string
+		get          func(store *DBStore) (User, error)
+		mockSetup    func(mock sqlmock.Sqlmock)
+		expectedUser User
+		expectedErr  error
+	}{
+		{
+			name: "By ID",
+			get:  func(store *DBStore) (User, error) { return store.GetUserByID(context.Background(), 101) },
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE id = ? ORDER BY id LIMIT 1")).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows(userRows).AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+			},
+			expectedUser: user1,
+		},
+		{
+			name: "By email",
+			get: func(store *DBStore) (User, error) {
+				return store.GetUserByEmail(context.Background(), "user1@example.com")
+			},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE email_address = ? ORDER BY id LIMIT 1")).
+					WithArgs("user1@example.com").
+					WillReturnRows(sqlmock.NewRows(userRows).AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+			},
+			expectedUser: user1,
+		},
+		{
+			name: "Not found",
+			get:  func(store *DBStore) (User, error) { return store.GetUserByID(context.Background(), 999) },
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE id = ? ORDER BY id LIMIT 1")).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows(userRows))
+			},
+			expectedErr: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			user, err := tt.get(&DBStore{db: db})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if !reflect.DeepEqual(user, tt.expectedUser) {
+				t.Errorf("Expected user %v, got %v", tt.expectedUser, user)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_CreateUser(t *testing.T) {
+	mailboxQuery := regexp.QuoteMeta("SELECT id FROM mailboxes WHERE id = ?")
+
+	tests := []struct {
+		name        string
+		mockSetup   func(mock sqlmock.Sqlmock)
+		expectedID  int
+		expectedErr error
+	}{
+		{
+			name: "Created",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(mailboxQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (mailbox_id, user_name, email_address, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING id")).
+					WithArgs(1, "user9", "user9@example.com", "2024-07-23 14:00:00", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(109))
+				expectChanges(mock,
+					UserChange{UserID: 109, Op: ChangeCreate, Field: "mailbox_id", New: "1"},
+					UserChange{UserID: 109, Op: ChangeCreate, Field: "user_name", New: "user9"},
+					UserChange{UserID: 109, Op: ChangeCreate, Field: "email_address", New: "user9@example.com"})
+				mock.ExpectCommit()
+			},
+			expectedID: 109,
+		},
+		{
+			name: "Missing mailbox",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(mailboxQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrMailboxNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db, driver: "sqlite3"}
+
+			user, err := store.CreateUser(context.Background(), User{MailboxID: 1, UserName: "user9", EmailAddress: "user9@example.com", CreatedAt: ts("2024-07-23 14:00:00")})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if user.ID != tt.expectedID {
+				t.Errorf("Expected ID %d, got %d", tt.expectedID, user.ID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_UpdateUser(t *testing.T) {
+	userRows := []string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}
+	updateQuery := regexp.QuoteMeta("UPDATE users SET user_name = ?, email_address = ?, updated_at = ? WHERE id = ?")
+
+	tests := []struct {
+		name        string
+		user        User
+		fields      []string
+		mockSetup   func(mock sqlmock.Sqlmock)
+		expectedErr error
+	}{
+		{
+			name: "Records changed fields",
+			user: User{ID: 101, UserName: "user1", EmailAddress: "renamed@example.com"},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockedUserQuery).WithArgs(101).
+					WillReturnRows(sqlmock.NewRows(userRows).AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+				mock.ExpectExec(updateQuery).WithArgs("user1", "renamed@example.com", sqlmock.AnyArg(), 101).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectChanges(mock, UserChange{UserID: 101, Op: ChangeUpdate, Field: "email_address", Old: "user1@example.com", New: "renamed@example.com"})
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "Writes only the fields given",
+			user:   User{ID: 101, EmailAddress: "renamed@example.com"},
+			fields: []string{FieldEmailAddress},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockedUserQuery).WithArgs(101).
+					WillReturnRows(sqlmock.NewRows(userRows).AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET email_address = ?, updated_at = ? WHERE id = ?")).
+					WithArgs("renamed@example.com", sqlmock.AnyArg(), 101).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectChanges(mock, UserChange{UserID: 101, Op: ChangeUpdate, Field: "email_address", Old: "user1@example.com", New: "renamed@example.com"})
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:        "Unknown field",
+			user:        User{ID: 101, MailboxID: 2},
+			fields:      []string{"mailbox_id"},
+			mockSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedErr: ErrUnknownField,
+		},
+		{
+			name: "Not found",
+			user: User{ID: 999, UserName: "renamed", EmailAddress: "renamed@example.com"},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockedUserQuery).WithArgs(999).WillReturnRows(sqlmock.NewRows(userRows))
+				mock.ExpectRollback()
+			},
+			expectedErr: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			store := &DBStore{db: db}
+
+			if err := store.UpdateUser(context.Background(), tt.user, tt.fields...); !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("There were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestDBStore_DeleteUser(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockedUserQuery).WithArgs(101).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(101, 1, "user1", "user1@example.com", "2024-07-23 12:30:00", nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM work_queue WHERE user_id = ?")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM retry_queue WHERE user_id = ?")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = ?")).WithArgs(101).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectChanges(mock,
+		UserChange{UserID: 101, Op: ChangeDelete, Field: "mailbox_id", Old: "1"},
+		UserChange{UserID: 101, Op: ChangeDelete, Field: "user_name", Old: "user1"},
+		UserChange{UserID: 101, Op: ChangeDelete, Field: "email_address", Old: "user1@example.com"})
+	mock.ExpectCommit()
+
+	store := &DBStore{db: db}
+
+	if err := store.DeleteUser(context.Background(), 101); err != nil {
+		t.Fatalf("Error calling DeleteUser: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// sqlTimestamp converts an RFC 3339 timestamp, as returned by drivers that
+// parse TIMESTAMP columns, back into the stored layout so text comparisons
+// against created_at stay correct. Other values are returned unchanged.
+func sqlTimestamp(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.UTC().Format(TimestampLayout)
+}
+
+func (s *DBStore) UsersCreatedSince(ctx context.Context, wm Watermark) (<-chan User, error) {
+	query := "SELECT " + userColumns + " FROM users " +
+		"WHERE created_at > ? OR (created_at = ? AND id > ?) ORDER BY created_at, id"
+
+	createdAt := sqlTimestamp(wm.CreatedAt)
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), createdAt, createdAt, wm.UserID)
+	if err != nil {
+		slog.Error("Error querying new users", "created_after", wm.CreatedAt, "error", err)
+		return nil, err
+	}
+
+	return streamUsers(ctx, rows), nil
+}
+
+// Watermark returns the saved watermark for name, or the zero Watermark if
+// none has been saved yet.
+func (s *DBStore) Watermark(ctx context.Context, name string) (Watermark, error) {
+	query := "SELECT created_at, user_id FROM watermarks WHERE name = ?"
+
+	var wm Watermark
+	err := s.db.QueryRowContext(ctx, s.rebind(query), name).Scan(&wm.CreatedAt, &wm.UserID)
+	if err == sql.ErrNoRows {
+		return Watermark{}, nil
+	}
+	if err != nil {
+		slog.Error("Error querying watermark", "watermark", name, "error", err)
+		return Watermark{}, err
+	}
+
+	return wm, nil
+}
+
+func (s *DBStore) SaveWatermark(ctx context.Context, name string, wm Watermark) error {
+	query := "INSERT INTO watermarks (name, created_at, user_id) VALUES (?, ?, ?) " +
+		"ON CONFLICT (name) DO UPDATE SET created_at = excluded.created_at, user_id = excluded.user_id"
+
+	if _, err := s.db.ExecContext(ctx, s.rebind(query), name, wm.CreatedAt, wm.UserID); err != nil {
+		slog.Error("Error saving watermark", "watermark", name, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// Watermarks returns every saved watermark by name.
+func (s *DBStore) Watermarks(ctx context.Context) (map[string]Watermark, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, created_at, user_id FROM watermarks")
+	if err != nil {
+		slog.Error("Error querying watermarks", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	watermarks := make(map[string]Watermark)
+	for rows.Next() {
+		var name string
+		var wm Watermark
+		if err := rows.Scan(&name, &wm.CreatedAt, &wm.UserID); err != nil {
+			slog.Error("Error scanning watermark row", "error", err)
+			return nil, err
+		}
+		watermarks[name] = wm
+	}
+	return watermarks, rows.Err()
+}
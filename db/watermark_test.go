@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBStore_UsersCreatedSince(t *testing.T) {
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	wm := Watermark{CreatedAt: "2024-07-23 12:30:00", UserID: 101}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, mailbox_id, user_name, email_address, CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE created_at > ? OR (created_at = ? AND id > ?) ORDER BY created_at, id")).
+		WithArgs(wm.CreatedAt, wm.CreatedAt, wm.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mailbox_id", "user_name", "email_address", "created_at", "updated_at"}).
+			AddRow(102, 1, "user2", "user2@example.com", "2024-07-23 12:45:00", nil))
+
+	store := &DBStore{db: db}
+
+	userChan, err := store.UsersCreatedSince(context.Background(), wm)
+	if err != nil {
+		t.Fatalf("Error calling UsersCreatedSince: %v", err)
+	}
+
+	var receivedUsers []User
+	for user := range userChan {
+		receivedUsers = append(receivedUsers, user)
+	}
+
+	expectedUsers := []User{
+		{ID: 102, MailboxID: 1, UserName: "user2", EmailAddress: "user2@example.com", CreatedAt: ts("2024-07-23 12:45:00")},
+	}
+	if !reflect.DeepEqual(receivedUsers, expectedUsers) {
+		t.Errorf("Expected users %v, got %v", expectedUsers, receivedUsers)
+	}
+}
+
+func TestSQLTimestamp(t *testing.T) {
+	tests := map[string]string{
+		"2024-07-23T13:15:00Z":      "2024-07-23 13:15:00",
+		"2024-07-23T15:15:00+02:00": "2024-07-23 13:15:00",
+		"2024-07-23 13:15:00":       "2024-07-23 13:15:00",
+		"":                          "",
+	}
+
+	for input, expected := range tests {
+		if got := sqlTimestamp(input); got != expected {
+			t.Errorf("sqlTimestamp(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestDBStore_Watermark(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name              string
+		mockRows          *sqlmock.Rows
+		mockError         error
+		expectedWatermark Watermark
+		expectedError     error
+	}{
+		{
+			name:              "Saved watermark",
+			mockRows:          sqlmock.NewRows([]string{"created_at", "user_id"}).AddRow("2024-07-23 12:30:00", 101),
+			expectedWatermark: Watermark{CreatedAt: "2024-07-23 12:30:00", UserID: 101},
+		},
+		{
+			name:              "No watermark yet",
+			mockError:         sql.ErrNoRows,
+			expectedWatermark: Watermark{},
+		},
+		{
+			name:          "Error retrieving watermark",
+			mockError:     sql.ErrConnDone,
+			expectedError: sql.ErrConnDone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupMockDB(t)
+			defer db.Close()
+
+			expect := mock.ExpectQuery(regexp.QuoteMeta("SELECT created_at, user_id FROM watermarks WHERE name = ?")).WithArgs("watch")
+			if tt.mockError != nil {
+				expect.WillReturnError(tt.mockError)
+			} else {
+				expect.WillReturnRows(tt.mockRows)
+			}
+
+			store := &DBStore{db: db}
+
+			wm, err := store.Watermark(ctx, "watch")
+			if err != tt.expectedError {
+				t.Fatalf("Expected error %v, got %v", tt.expectedError, err)
+			}
+			if wm != tt.expectedWatermark {
+				t.Errorf("Expected watermark %v, got %v", tt.expectedWatermark, wm)
+			}
+		})
+	}
+}
+
+func TestDBStore_SaveWatermark(t *testing.T) {
+	ctx := context.Background()
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	wm := Watermark{CreatedAt: "2024-07-23 12:45:00", UserID: 102}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO watermarks (name, created_at, user_id) VALUES (?, ?, ?)")).
+		WithArgs("watch", wm.CreatedAt, wm.UserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store := &DBStore{db: db}
+
+	if err := store.SaveWatermark(ctx, "watch", wm); err != nil {
+		t.Fatalf("Error calling SaveWatermark: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStore_Watermarks(t *testing.T) {
+	ctx := context.Background()
+	db, mock := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, created_at, user_id FROM watermarks")).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "created_at", "user_id"}).
+			AddRow("watch", "2024-07-23 12:45:00", 102).
+			AddRow("changes.audit", "2024-07-23 12:30:00", 101))
+
+	store := &DBStore{db: db}
+	watermarks, err := store.Watermarks(ctx)
+	if err != nil {
+		t.Fatalf("Error calling Watermarks: %v", err)
+	}
+	expected := map[string]Watermark{
+		"watch":         {CreatedAt: "2024-07-23 12:45:00", UserID: 102},
+		"changes.audit": {CreatedAt: "2024-07-23 12:30:00", UserID: 101},
+	}
+	if !reflect.DeepEqual(watermarks, expected) {
+		t.Errorf("Expected %v, got %v", expected, watermarks)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package debugbundle records every step the pipeline takes for one user, with
+// inputs and outputs, so single-user failures can be reproduced from a file
+// instead of ad-hoc logging.
+package debugbundle
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the values of redacted fields.
+const Redacted = "[REDACTED]"
+
+// defaultRedactions are field names whose values are always redacted, matched
+// case-insensitively as substrings.
+var defaultRedactions = []string{"token", "secret", "password", "authorization"}
+
+// Interaction is one step taken for the user, such as running the script or
+// handing the user to the processor.
+type Interaction struct {
+	Stage    string        `json:"stage"`
+	At       time.Time     `json:"at"`
+	Duration time.Duration `json:"duration_ns"`
+	Request  any           `json:"request,omitempty"`
+	Response any           `json:"response,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Recorder collects interactions for one user. A nil *Recorder records
+// nothing, so callers can hold one unconditionally.
+type Recorder struct {
+	userID int
+	// fields are the lowercased field names to redact.
+	fields []string
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// New returns a Recorder for userID. Fields named in redact are redacted in
+// addition to tokens, secrets, passwords and authorization headers.
+func New(userID int, redact ...string) *Recorder {
+	fields := append([]string{}, defaultRedactions...)
+	for _, f := range redact {
+		fields = append(fields, strings.ToLower(f))
+	}
+	return &Recorder{userID: userID, fields: fields}
+}
+
+// Wants reports whether interactions for userID are being recorded.
+func (r *Recorder) Wants(userID int) bool {
+	return r != nil && r.userID == userID
+}
+
+// Record adds an interaction that started at start and ended now. Request
+// and response are stored redacted.
+func (r *Recorder) Record(stage string, start time.Time, request, response any, err error) {
+	if r == nil {
+		return
+	}
+
+	in := Interaction{
+		Stage:    stage,
+		At:       start.UTC(),
+		Duration: time.Since(start),
+		Request:  r.redacted(request),
+		Response: r.redacted(response),
+	}
+	if err != nil {
+		in.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, in)
+}
+
+// Len returns how many interactions have been recorded.
+func (r *Recorder) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.interactions)
+}
+
+// WriteFile writes the bundle to path as indented JSON.
+func (r *Recorder) WriteFile(path string) error {
+	r.mu.Lock()
+	bundle := struct {
+		UserID       int           `json:"user_id"`
+		WrittenAt    time.Time     `json:"written_at"`
+		Interactions []Interaction `json:"interactions"`
+	}{r.userID, time.Now().UTC(), r.interactions}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// redacted round-trips v through JSON and replaces the values of redacted
+// fields at any depth. Values that don't marshal are recorded as their
+// error.
+func (r *Recorder) redacted(v any) any {
+	if v == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "unencodable: " + err.Error()
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return "unencodable: " + err.Error()
+	}
+	return r.redact(generic)
+}
+
+func (r *Recorder) redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if r.sensitive(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = r.redact(val)
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = r.redact(val)
+		}
+	}
+	return v
+}
+
+func (r *Recorder) sensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, f := range r.fields {
+		if strings.Contains(field, f) {
+			return true
+		}
+	}
+	return false
+}
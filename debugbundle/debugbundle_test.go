@@ -0,0 +1,84 @@
+package debugbundle
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func TestRecorder_Redaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		redact   []string
+		request  any
+		expected any
+	}{
+		{
+			name:     "Tokens always redacted",
+			request:  db.Mailbox{ID: 1, MPIID: "mpi123", Token: "token123"},
+			expected: map[string]any{"id": 1.0, "mpi_id": "mpi123", "token": Redacted, "created_at": "0001-01-01T00:00:00Z", "updated_at": "0001-01-01T00:00:00Z"},
+		},
+		{
+			name:     "Nested headers",
+			request:  map[string]any{"headers": map[string]any{"Authorization": "Bearer abc", "Accept": "*/*"}},
+			expected: map[string]any{"headers": map[string]any{"Authorization": Redacted, "Accept": "*/*"}},
+		},
+		{
+			name:     "Configured fields",
+			redact:   []string{"Email_Address"},
+			request:  db.User{ID: 101, UserName: "user1", EmailAddress: "user1@example.com"},
+			expected: map[string]any{"id": 101.0, "mailbox_id": 0.0, "user_name": "user1", "email_address": Redacted, "created_at": "0001-01-01T00:00:00Z", "updated_at": "0001-01-01T00:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(101, tt.redact...)
+			r.Record("stage", time.Now(), tt.request, nil, nil)
+
+			if got := r.interactions[0].Request; !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecorder_WriteFile(t *testing.T) {
+	var nilRecorder *Recorder
+	nilRecorder.Record("ignored", time.Now(), nil, nil, nil)
+	if nilRecorder.Wants(101) || nilRecorder.Len() != 0 {
+		t.Errorf("Expected a nil recorder to record nothing")
+	}
+
+	r := New(101)
+	if !r.Wants(101) || r.Wants(102) {
+		t.Errorf("Expected recorder to want only user 101")
+	}
+	r.Record("process", time.Now(), db.User{ID: 101}, nil, errors.New("delivery failed"))
+
+	path := filepath.Join(t.TempDir(), "bundle.json")
+	if err := r.WriteFile(path); err != nil {
+		t.Fatalf("Error writing bundle: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading bundle: %v", err)
+	}
+	var bundle struct {
+		UserID       int           `json:"user_id"`
+		Interactions []Interaction `json:"interactions"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatalf("Error decoding bundle: %v", err)
+	}
+	if bundle.UserID != 101 || len(bundle.Interactions) != 1 || bundle.Interactions[0].Error != "delivery failed" {
+		t.Errorf("Unexpected bundle %+v", bundle)
+	}
+}
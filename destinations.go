@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/processor"
+
+	"github.com/spf13/viper"
+)
+
+// The mailbox_settings names that give a mailbox its own webhook
+// destination. Values may hold ${provider:path#field} secret references.
+const (
+	settingWebhookURL           = "webhook.url"
+	settingWebhookSecret        = "webhook.secret"
+	settingWebhookAuthorization = "webhook.authorization"
+)
+
+// mailboxDestinations reads the webhook destination each mailbox sets in
+// mailbox_settings, keeping it for ttl so a run does not read a mailbox's
+// settings for every user. It is safe for concurrent use, and a nil
+// *mailboxDestinations leaves every mailbox on the webhook's own settings.
+type mailboxDestinations struct {
+	store db.MailboxSettingsStore
+	ttl   time.Duration
+
+	mu     sync.Mutex
+	cached map[int]cachedDestination
+}
+
+type cachedDestination struct {
+	dest processor.Destination
+	read time.Time
+}
+
+// destinations is the per-mailbox webhook destinations of the users the
+// pipeline processes; nil unless processor.mailbox_destinations is set.
+var destinations *mailboxDestinations
+
+// newMailboxDestinations returns the mailboxDestinations of store, or nil
+// if processor.mailbox_destinations is not set.
+func newMailboxDestinations(store db.Store) (*mailboxDestinations, error) {
+	if !viper.GetBool("processor.mailbox_destinations") {
+		return nil, nil
+	}
+	ms, ok := store.(db.MailboxSettingsStore)
+	if !ok {
+		return nil, errors.New("store does not keep mailbox settings")
+	}
+	viper.SetDefault("processor.mailbox_destinations_ttl", time.Minute)
+	return &mailboxDestinations{
+		store:  ms,
+		ttl:    viper.GetDuration("processor.mailbox_destinations_ttl"),
+		cached: map[int]cachedDestination{},
+	}, nil
+}
+
+// context returns ctx carrying the destination of mailboxID, or ctx itself
+// if the mailbox sets none. A mailbox whose settings cannot be read is an
+// error rather than a fall back to the webhook's own URL, which would send
+// its users to the wrong place.
+func (d *mailboxDestinations) context(ctx context.Context, mailboxID int) (context.Context, error) {
+	if d == nil {
+		return ctx, nil
+	}
+	dest, err := d.of(ctx, mailboxID)
+	if err != nil {
+		return ctx, err
+	}
+	if dest == (processor.Destination{}) {
+		return ctx, nil
+	}
+	return processor.WithDestination(ctx, dest), nil
+}
+
+// of returns the destination of mailboxID, reading its settings again once
+// the cached ones are older than ttl.
+func (d *mailboxDestinations) of(ctx context.Context, mailboxID int) (processor.Destination, error) {
+	d.mu.Lock()
+	c, ok := d.cached[mailboxID]
+	d.mu.Unlock()
+	if ok && time.Since(c.read) < d.ttl {
+		return c.dest, nil
+	}
+
+	read := time.Now()
+	settings, err := d.store.MailboxSettings(ctx, mailboxID)
+	if err != nil {
+		return processor.Destination{}, fmt.Errorf("reading the webhook destination of mailbox %d: %w", mailboxID, err)
+	}
+	var dest processor.Destination
+	for name, field := range map[string]*string{
+		settingWebhookURL:           &dest.URL,
+		settingWebhookSecret:        &dest.Secret,
+		settingWebhookAuthorization: &dest.Authorization,
+	} {
+		if *field, err = secretProviders.Expand(ctx, settings[name]); err != nil {
+			return processor.Destination{}, fmt.Errorf("mailbox %d setting %s: %w", mailboxID, name, err)
+		}
+	}
+
+	d.mu.Lock()
+	d.cached[mailboxID] = cachedDestination{dest: dest, read: read}
+	d.mu.Unlock()
+	return dest, nil
+}
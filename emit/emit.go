@@ -0,0 +1,69 @@
+// Package emit publishes an event for every mailbox and user the pipeline
+// processes, so downstream systems can react to its output as it happens
+// instead of polling the database.
+package emit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Kinds of events.
+const (
+	UserProcessed    = "user_processed"
+	MailboxProcessed = "mailbox_processed"
+)
+
+// Event is one processed user or mailbox. Users and Failed count a
+// mailbox's users; Error is set for a mailbox that failed. Source is the
+// ingestion path a user came in through, when provenance is recorded.
+type Event struct {
+	Kind      string    `json:"kind"`
+	MailboxID int       `json:"mailbox_id"`
+	UserID    int       `json:"user_id,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Users     int       `json:"users,omitempty"`
+	Failed    int       `json:"failed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Key is what events are partitioned by: the mailbox ID, so one mailbox's
+// events stay in order.
+func (e Event) Key() string {
+	return strconv.Itoa(e.MailboxID)
+}
+
+// Publisher publishes events. Publish should not hold up the pipeline:
+// publishers buffer events and deliver them in the background, and Close
+// delivers what is left.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Publishers publishes every event to each of its publishers. A nil
+// Publishers publishes nowhere.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(ctx context.Context, e Event) error {
+	var errs []error
+	for _, p := range ps {
+		if err := p.Publish(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ps Publishers) Close() error {
+	var errs []error
+	for _, p := range ps {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,127 @@
+package emit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeWriter struct {
+	msgs   []kafka.Message
+	closed bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestKafka_Publish(t *testing.T) {
+	w := &fakeWriter{}
+	k := &Kafka{w: w}
+	at := time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)
+	e := Event{Kind: UserProcessed, MailboxID: 7, UserID: 701, At: at}
+	if err := k.Publish(context.Background(), e); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	if err := k.Close(); err != nil || !w.closed {
+		t.Errorf("Expected the writer to be closed, got %v", err)
+	}
+
+	if len(w.msgs) != 1 {
+		t.Fatalf("Expected one message, got %d", len(w.msgs))
+	}
+	msg := w.msgs[0]
+	if string(msg.Key) != "7" || !msg.Time.Equal(at) {
+		t.Errorf("Expected key 7 at %s, got %q at %s", at, msg.Key, msg.Time)
+	}
+	var got Event
+	if err := json.Unmarshal(msg.Value, &got); err != nil || !reflect.DeepEqual(got, e) {
+		t.Errorf("Expected %+v, got %+v, %v", e, got, err)
+	}
+}
+
+func TestNewKafka(t *testing.T) {
+	if _, err := NewKafka(KafkaConfig{Topic: "users"}); err == nil {
+		t.Errorf("Expected an error without brokers")
+	}
+	if _, err := NewKafka(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "users", RequiredAcks: "two"}); err == nil {
+		t.Errorf("Expected an error for unknown required_acks")
+	}
+	k, err := NewKafka(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "users", RequiredAcks: "one"})
+	if err != nil {
+		t.Fatalf("Error creating publisher: %v", err)
+	}
+	if w := k.w.(*kafka.Writer); w.RequiredAcks != kafka.RequireOne || w.BatchTimeout != 100*time.Millisecond || !w.Async {
+		t.Errorf("Unexpected writer settings %+v", w)
+	}
+}
+
+type failing struct{ err error }
+
+func (f failing) Publish(ctx context.Context, e Event) error { return f.err }
+func (f failing) Close() error                               { return nil }
+
+func TestPublishers(t *testing.T) {
+	var none Publishers
+	if err := none.Publish(context.Background(), Event{}); err != nil {
+		t.Errorf("Expected nil Publishers to publish nowhere, got %v", err)
+	}
+	boom := errors.New("boom")
+	w := &fakeWriter{}
+	ps := Publishers{failing{boom}, &Kafka{w: w}}
+	if err := ps.Publish(context.Background(), Event{MailboxID: 1}); !errors.Is(err, boom) {
+		t.Errorf("Expected the failing publisher's error, got %v", err)
+	}
+	if len(w.msgs) != 1 {
+		t.Errorf("Expected the other publisher to still get the event")
+	}
+}
+
+type fakeJetStream struct {
+	subjects []string
+	payloads [][]byte
+}
+
+func (js *fakeJetStream) PublishAsync(subject string, payload []byte, opts ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	js.subjects = append(js.subjects, subject)
+	js.payloads = append(js.payloads, payload)
+	return nil, nil
+}
+
+func (js *fakeJetStream) PublishAsyncComplete() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func TestNATS_Publish(t *testing.T) {
+	js := &fakeJetStream{}
+	n := &NATS{js: js, subject: "mailboxes.events"}
+	e := Event{Kind: MailboxProcessed, MailboxID: 7, Users: 3, Failed: 1, Error: "boom"}
+	if err := n.Publish(context.Background(), e); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	if err := n.Close(); err != nil {
+		t.Errorf("Error closing: %v", err)
+	}
+
+	if !reflect.DeepEqual(js.subjects, []string{"mailboxes.events.mailbox_processed"}) {
+		t.Fatalf("Unexpected subjects %v", js.subjects)
+	}
+	var got Event
+	if err := json.Unmarshal(js.payloads[0], &got); err != nil || !reflect.DeepEqual(got, e) {
+		t.Errorf("Expected %+v, got %+v, %v", e, got, err)
+	}
+}
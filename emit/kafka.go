@@ -0,0 +1,86 @@
+package emit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaConfig is the emit.kafka section.
+type KafkaConfig struct {
+	Brokers []string `mapstructure:"brokers"`
+	Topic   string   `mapstructure:"topic"`
+	// BatchTimeout bounds how long an event waits to be sent with others;
+	// the default is 100ms.
+	BatchTimeout time.Duration `mapstructure:"batch_timeout"`
+	// RequiredAcks is all (the default), one or none: how many replicas
+	// must have an event before the broker confirms it.
+	RequiredAcks string `mapstructure:"required_acks"`
+}
+
+// messageWriter is the part of kafka.Writer Kafka uses, replaced in tests.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Kafka publishes events as JSON messages to a topic, keyed by mailbox ID.
+// Messages are sent in batches in the background; ones that cannot be
+// delivered after the writer's retries are logged.
+type Kafka struct {
+	w messageWriter
+}
+
+// NewKafka returns a Kafka publisher for cfg.
+func NewKafka(cfg KafkaConfig) (*Kafka, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("no Kafka brokers configured")
+	}
+	if cfg.Topic == "" {
+		return nil, errors.New("no Kafka topic configured")
+	}
+	acks := kafka.RequireAll
+	switch cfg.RequiredAcks {
+	case "", "all":
+	case "one":
+		acks = kafka.RequireOne
+	case "none":
+		acks = kafka.RequireNone
+	default:
+		return nil, fmt.Errorf("required_acks must be all, one or none, not %q", cfg.RequiredAcks)
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 100 * time.Millisecond
+	}
+
+	return &Kafka{w: &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: cfg.BatchTimeout,
+		RequiredAcks: acks,
+		Async:        true,
+		Completion: func(msgs []kafka.Message, err error) {
+			if err != nil {
+				slog.Error("Error publishing events to Kafka", "topic", cfg.Topic, "events", len(msgs), "error", err)
+			}
+		},
+	}}, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, e Event) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return k.w.WriteMessages(ctx, kafka.Message{Key: []byte(e.Key()), Value: value, Time: e.At})
+}
+
+func (k *Kafka) Close() error {
+	return k.w.Close()
+}
@@ -0,0 +1,95 @@
+package emit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSConfig is the emit.nats section.
+type NATSConfig struct {
+	URL string `mapstructure:"url"`
+	// Subject prefixes the subject of each event, which is followed by the
+	// event's kind: mailboxes.events.user_processed by default.
+	Subject string `mapstructure:"subject"`
+	// Stream, if set, is created or updated to capture Subject.>; otherwise
+	// a stream must already capture it.
+	Stream string `mapstructure:"stream"`
+}
+
+// closeTimeout bounds how long Close waits for the broker to confirm the
+// events still in flight.
+const closeTimeout = 10 * time.Second
+
+// asyncPublisher is the part of jetstream.JetStream NATS uses, replaced in
+// tests.
+type asyncPublisher interface {
+	PublishAsync(subject string, payload []byte, opts ...jetstream.PublishOpt) (jetstream.PubAckFuture, error)
+	PublishAsyncComplete() <-chan struct{}
+}
+
+// NATS publishes events as JSON messages to NATS JetStream. Events are
+// published without waiting for the stream to confirm them; ones it does not
+// are logged.
+type NATS struct {
+	nc      *nats.Conn
+	js      asyncPublisher
+	subject string
+}
+
+// NewNATS connects to the server at cfg.URL and returns a NATS publisher.
+func NewNATS(ctx context.Context, cfg NATSConfig) (*NATS, error) {
+	if cfg.URL == "" {
+		cfg.URL = nats.DefaultURL
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = "mailboxes.events"
+	}
+	nc, err := nats.Connect(cfg.URL, nats.Name("mailboxes"))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc, jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, msg *nats.Msg, err error) {
+		slog.Error("Error publishing event to NATS", "subject", msg.Subject, "error", err)
+	}))
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if cfg.Stream != "" {
+		_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{Name: cfg.Stream, Subjects: []string{cfg.Subject + ".>"}})
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return &NATS{nc: nc, js: js, subject: cfg.Subject}, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = n.js.PublishAsync(n.subject+"."+e.Kind, data)
+	return err
+}
+
+// Close waits up to closeTimeout for the events in flight to be confirmed.
+func (n *NATS) Close() error {
+	var err error
+	select {
+	case <-n.js.PublishAsyncComplete():
+	case <-time.After(closeTimeout):
+		err = errors.New("timed out waiting for NATS to confirm events")
+	}
+	if n.nc != nil {
+		n.nc.Close()
+	}
+	return err
+}
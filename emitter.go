@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/emit"
+
+	"github.com/spf13/viper"
+)
+
+// emitter publishes an event for every user and mailbox the pipeline
+// processes, to the publishers configured under emit; nil publishes nowhere.
+var emitter emit.Publishers
+
+// openEmitter sets up the publishers configured under emit: emit.kafka,
+// when its brokers are set, and emit.nats, when its URL is.
+func openEmitter() error {
+	var ps emit.Publishers
+	if viper.IsSet("emit.kafka.brokers") {
+		cfg := emit.KafkaConfig{
+			Topic:        viper.GetString("emit.kafka.topic"),
+			BatchTimeout: viper.GetDuration("emit.kafka.batch_timeout"),
+			RequiredAcks: viper.GetString("emit.kafka.required_acks"),
+		}
+		// From the environment the brokers are one comma-separated string.
+		for _, s := range viper.GetStringSlice("emit.kafka.brokers") {
+			for _, b := range strings.Split(s, ",") {
+				if b = strings.TrimSpace(b); b != "" {
+					cfg.Brokers = append(cfg.Brokers, b)
+				}
+			}
+		}
+		k, err := emit.NewKafka(cfg)
+		if err != nil {
+			return err
+		}
+		ps = append(ps, k)
+	}
+	if viper.IsSet("emit.nats.url") {
+		n, err := emit.NewNATS(context.Background(), emit.NATSConfig{
+			URL:     viper.GetString("emit.nats.url"),
+			Subject: viper.GetString("emit.nats.subject"),
+			Stream:  viper.GetString("emit.nats.stream"),
+		})
+		if err != nil {
+			return err
+		}
+		ps = append(ps, n)
+	}
+	emitter = ps
+	return nil
+}
+
+// closeEmitter delivers the events still buffered and closes the
+// publishers.
+func closeEmitter() {
+	if err := emitter.Close(); err != nil {
+		slog.Error("Error closing event publishers", "error", err)
+	}
+	emitter = nil
+}
+
+// recordProcessed records that user was processed: in the ledger, the run
+// checkpoint and the emitted events.
+func recordProcessed(ctx context.Context, user db.User) {
+	ledger.record(ctx, user)
+	checkpoint.user(ctx, user)
+	publish(ctx, emit.Event{Kind: emit.UserProcessed, MailboxID: user.MailboxID, UserID: user.ID, Source: sources.of(user.ID)})
+}
+
+// emitMailbox publishes that the mailbox was processed, with how many of
+// its users were processed and failed, and err if it failed.
+func emitMailbox(ctx context.Context, mailboxID, users, failed int, err error) {
+	e := emit.Event{Kind: emit.MailboxProcessed, MailboxID: mailboxID, Users: users, Failed: failed}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	publish(ctx, e)
+}
+
+// publish stamps and publishes e. Events are published even once the run is
+// cancelled, as they describe work already done.
+func publish(ctx context.Context, e emit.Event) {
+	if emitter == nil {
+		return
+	}
+	e.At = time.Now().UTC()
+	if err := emitter.Publish(context.WithoutCancel(ctx), e); err != nil {
+		slog.Error("Error publishing event", "kind", e.Kind, "mailbox_id", e.MailboxID, "user_id", e.UserID, "error", err)
+	}
+}
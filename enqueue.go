@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"mailboxes/db"
+)
+
+// enqueueCommand adds the given user IDs to the work queue so a running
+// watcher processes them right away.
+func enqueueCommand(store db.Store, args []string) {
+	if len(args) == 0 {
+		fatal("Usage: enqueue <user-id>...")
+	}
+
+	queue, ok := store.(db.QueueStore)
+	if !ok {
+		fatal("Store does not support the work queue")
+	}
+
+	for _, arg := range args {
+		userID, err := strconv.Atoi(arg)
+		if err != nil {
+			fatal("Invalid user ID", "user_id", arg, "error", err)
+		}
+
+		if err := queue.EnqueueUser(context.Background(), userID); err != nil {
+			fatal("Error enqueueing user", "user_id", userID, "error", err)
+		}
+		slog.Info("User enqueued", "user_id", userID)
+	}
+}
@@ -0,0 +1,72 @@
+package enrich
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// CSV is a Source read once from a CSV file with a header row. Each row is
+// keyed by its keyColumn, compared case-insensitively, and its other
+// columns are its fields. A key on several rows takes the last.
+type CSV struct {
+	rows map[string]map[string]string
+}
+
+// NewCSV reads the CSV file at path, keyed by keyColumn.
+func NewCSV(path, keyColumn string) (*CSV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCSV(f, keyColumn)
+}
+
+// ReadCSV reads CSV from r, keyed by keyColumn.
+func ReadCSV(r io.Reader, keyColumn string) (*CSV, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("empty CSV: no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := slices.Index(header, keyColumn)
+	if key < 0 {
+		return nil, fmt.Errorf("no %s column in %s", keyColumn, strings.Join(header, ","))
+	}
+
+	c := &CSV{rows: map[string]map[string]string{}}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return c, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string, len(header)-1)
+		for i, name := range header {
+			if i != key {
+				fields[name] = record[i]
+			}
+		}
+		c.rows[strings.ToLower(record[key])] = fields
+	}
+}
+
+// Len returns how many keys the file held.
+func (c *CSV) Len() int {
+	return len(c.rows)
+}
+
+func (c *CSV) Lookup(_ context.Context, key string) (map[string]string, error) {
+	return c.rows[strings.ToLower(key)], nil
+}
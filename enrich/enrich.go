@@ -0,0 +1,67 @@
+// Package enrich joins each user with fields from an external source keyed
+// by one of the user's own fields, such as the CRM account a user's email
+// address belongs to. Sources are a CSV file read at startup, Redis hashes
+// and an HTTP lookup whose answers are cached.
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mailboxes/db"
+)
+
+// Source looks up the fields stored for a key. A key it has nothing for is
+// not an error: Lookup returns no fields.
+type Source interface {
+	Lookup(ctx context.Context, key string) (map[string]string, error)
+}
+
+// KeyFields are the user fields a source can be keyed by, by JSON name.
+var KeyFields = []string{"id", "user_name", "email_address"}
+
+// Enricher looks up each user's fields in a source by the user's key field.
+type Enricher struct {
+	source   Source
+	keyField string
+}
+
+// New returns an Enricher looking users up in source by keyField, one of
+// KeyFields.
+func New(source Source, keyField string) (*Enricher, error) {
+	switch keyField {
+	case "id", "user_name", "email_address":
+	default:
+		return nil, fmt.Errorf("cannot key enrichment by %q; use one of %s", keyField, strings.Join(KeyFields, ", "))
+	}
+	return &Enricher{source: source, keyField: keyField}, nil
+}
+
+// Key returns the key user is looked up by. Email addresses are lowercased,
+// so sources should store them that way.
+func (e *Enricher) Key(user db.User) string {
+	switch e.keyField {
+	case "id":
+		return strconv.Itoa(user.ID)
+	case "user_name":
+		return user.UserName
+	default:
+		return strings.ToLower(strings.TrimSpace(user.EmailAddress))
+	}
+}
+
+// Fields returns the fields the source has for user, or none if it has
+// nothing for the user or the user's key is empty.
+func (e *Enricher) Fields(ctx context.Context, user db.User) (map[string]string, error) {
+	key := e.Key(user)
+	if key == "" {
+		return nil, nil
+	}
+	fields, err := e.source.Lookup(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment lookup of %s %q: %w", e.keyField, key, err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,139 @@
+package enrich
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var user = db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "User1@Example.com"}
+
+func TestEnricher_CSV(t *testing.T) {
+	src, err := ReadCSV(strings.NewReader("email,crm_account_id,tier\nuser1@example.com,acct-7,gold\nuser2@example.com,acct-8,silver\n"), "email")
+	if err != nil {
+		t.Fatalf("Error reading CSV: %v", err)
+	}
+	e, err := New(src, "email_address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := e.Fields(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Error looking up user: %v", err)
+	}
+	if expected := map[string]string{"crm_account_id": "acct-7", "tier": "gold"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+	if fields, _ := e.Fields(context.Background(), db.User{EmailAddress: "nobody@example.com"}); fields != nil {
+		t.Errorf("Expected no fields for an unknown user, got %v", fields)
+	}
+
+	if _, err := ReadCSV(strings.NewReader("id,tier\n"), "email"); err == nil {
+		t.Errorf("Expected an error for a missing key column")
+	}
+	if _, err := New(src, "created_at"); err == nil {
+		t.Errorf("Expected an error for an unknown key field")
+	}
+}
+
+func TestEnricher_Redis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.HSet("crm:user1", "crm_account_id", "acct-7")
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	e, err := New(NewRedis(client, "crm:"), "user_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := e.Fields(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Error looking up user: %v", err)
+	}
+	if expected := map[string]string{"crm_account_id": "acct-7"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+	if fields, _ := e.Fields(context.Background(), db.User{UserName: "user2"}); fields != nil {
+		t.Errorf("Expected no fields for an unknown user, got %v", fields)
+	}
+
+	mr.Close()
+	if _, err := e.Fields(context.Background(), user); err == nil {
+		t.Errorf("Expected an error with Redis down")
+	}
+}
+
+func TestEnricher_HTTP(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("email") {
+		case "user1@example.com":
+			w.Write([]byte(`{"crm_account_id": "acct-7", "seats": 12, "owner": null}`))
+		case "broken@example.com":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := NewHTTP(HTTPConfig{URL: srv.URL + "/?email={key}", Headers: map[string]string{"Authorization": "Bearer t0ken"}, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+	src.now = func() time.Time { return now }
+	e, err := New(src, "email_address")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for range 2 {
+		fields, err := e.Fields(ctx, user)
+		if err != nil {
+			t.Fatalf("Error looking up user: %v", err)
+		}
+		if expected := map[string]string{"crm_account_id": "acct-7", "seats": "12"}; !reflect.DeepEqual(fields, expected) {
+			t.Errorf("Expected %v, got %v", expected, fields)
+		}
+		if fields, err := e.Fields(ctx, db.User{EmailAddress: "nobody@example.com"}); err != nil || fields != nil {
+			t.Errorf("Expected no fields for an unknown user, got %v, %v", fields, err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected the second lookups from the cache, got %d requests", n)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := e.Fields(ctx, user); err != nil || requests.Load() != 3 {
+		t.Errorf("Expected an expired answer to be fetched again, got %d requests, %v", requests.Load(), err)
+	}
+	for range 2 {
+		if _, err := e.Fields(ctx, db.User{EmailAddress: "broken@example.com"}); err == nil {
+			t.Errorf("Expected an error for a failed lookup")
+		}
+	}
+	if n := requests.Load(); n != 5 {
+		t.Errorf("Expected failed lookups not to be cached, got %d requests", n)
+	}
+
+	if _, err := NewHTTP(HTTPConfig{URL: srv.URL}); err == nil {
+		t.Errorf("Expected an error for a URL without {key}")
+	}
+}
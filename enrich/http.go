@@ -0,0 +1,133 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPConfig describes a lookup service answering GET requests.
+type HTTPConfig struct {
+	// URL is requested with {key} replaced by the query-escaped key, such
+	// as https://crm.example.com/accounts?email={key}.
+	URL string
+	// Headers are sent with every request, such as Authorization.
+	Headers map[string]string
+	// Timeout bounds each request; zero means 10s.
+	Timeout time.Duration
+	// CacheTTL is how long answers are kept, including that a key was not
+	// found; zero caches nothing.
+	CacheTTL time.Duration
+	// MaxEntries caps the cached keys; zero means 100000. The cache is
+	// emptied when it fills.
+	MaxEntries int
+}
+
+// HTTP is a Source looking each key up with a GET request. A 200 answer is
+// a JSON object whose values become the fields, strings as they are and
+// anything else but null as JSON; a 404 means nothing is known for the key. Other
+// answers are errors and are not cached. It is safe for concurrent use.
+type HTTP struct {
+	cfg    HTTPConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	cached map[string]cachedFields
+}
+
+type cachedFields struct {
+	fields  map[string]string
+	fetched time.Time
+}
+
+// NewHTTP returns a Source for the service cfg describes.
+func NewHTTP(cfg HTTPConfig) (*HTTP, error) {
+	if !strings.Contains(cfg.URL, "{key}") {
+		return nil, fmt.Errorf("lookup URL %q has no {key}", cfg.URL)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 100000
+	}
+	return &HTTP{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		now:    time.Now,
+		cached: map[string]cachedFields{},
+	}, nil
+}
+
+func (h *HTTP) Lookup(ctx context.Context, key string) (map[string]string, error) {
+	if h.cfg.CacheTTL > 0 {
+		h.mu.Lock()
+		c, ok := h.cached[key]
+		h.mu.Unlock()
+		if ok && h.now().Sub(c.fetched) < h.cfg.CacheTTL {
+			return c.fields, nil
+		}
+	}
+
+	fetched := h.now()
+	fields, err := h.fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if h.cfg.CacheTTL > 0 {
+		h.mu.Lock()
+		if len(h.cached) >= h.cfg.MaxEntries {
+			clear(h.cached)
+		}
+		h.cached[key] = cachedFields{fields: fields, fetched: fetched}
+		h.mu.Unlock()
+	}
+	return fields, nil
+}
+
+func (h *HTTP) fetch(ctx context.Context, key string) (map[string]string, error) {
+	u := strings.ReplaceAll(h.cfg.URL, "{key}", url.QueryEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range h.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("lookup answered %s", resp.Status)
+	}
+	var values map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("decoding lookup answer: %w", err)
+	}
+	fields := make(map[string]string, len(values))
+	for name, raw := range values {
+		var s string
+		switch {
+		case string(raw) == "null":
+		case json.Unmarshal(raw, &s) == nil:
+			fields[name] = s
+		default:
+			fields[name] = string(raw)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,30 @@
+package enrich
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Source reading each key's fields from the Redis hash at
+// prefix followed by the key, as maintained by whatever owns the data.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis returns a Source reading the hashes under prefix from client.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (r *Redis) Lookup(ctx context.Context, key string) (map[string]string, error) {
+	fields, err := r.client.HGetAll(ctx, r.prefix+key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/enrich"
+	"mailboxes/processor"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+// userEnrichment looks up the fields the enrichment source holds for each
+// user before it is processed; nil when enrichment.source is not set.
+type userEnrichment struct {
+	enricher *enrich.Enricher
+	// failOpen processes users whose lookup failed without enrichment
+	// instead of failing them.
+	failOpen bool
+}
+
+// enrichment is the enrichment stage of the pipeline; see userEnrichment.
+var enrichment *userEnrichment
+
+// openEnrichment sets up the source named by enrichment.source: csv, read
+// from enrichment.csv.path keyed by enrichment.csv.key_column; redis,
+// hashes under enrichment.redis.prefix at enrichment.redis.url; or http,
+// a GET of enrichment.http.url with its answers cached for
+// enrichment.http.cache_ttl. Users are looked up by enrichment.key.
+func openEnrichment() (*userEnrichment, error) {
+	kind := viper.GetString("enrichment.source")
+	if kind == "" {
+		return nil, nil
+	}
+	viper.SetDefault("enrichment.key", "email_address")
+	viper.SetDefault("enrichment.on_error", "fail")
+
+	var source enrich.Source
+	switch kind {
+	case "csv":
+		viper.SetDefault("enrichment.csv.key_column", viper.GetString("enrichment.key"))
+		c, err := enrich.NewCSV(viper.GetString("enrichment.csv.path"), viper.GetString("enrichment.csv.key_column"))
+		if err != nil {
+			return nil, fmt.Errorf("enrichment.csv: %w", err)
+		}
+		slog.Info("Loaded enrichment file", "path", viper.GetString("enrichment.csv.path"), "keys", c.Len())
+		source = c
+	case "redis":
+		opts, err := redis.ParseURL(viper.GetString("enrichment.redis.url"))
+		if err != nil {
+			return nil, fmt.Errorf("enrichment.redis.url: %w", err)
+		}
+		source = enrich.NewRedis(redis.NewClient(opts), viper.GetString("enrichment.redis.prefix"))
+	case "http":
+		viper.SetDefault("enrichment.http.cache_ttl", 10*time.Minute)
+		headers := viper.GetStringMapString("enrichment.http.headers")
+		for name, value := range headers {
+			expanded, err := secretProviders.Expand(context.Background(), value)
+			if err != nil {
+				return nil, fmt.Errorf("enrichment.http.headers.%s: %w", name, err)
+			}
+			headers[name] = expanded
+		}
+		h, err := enrich.NewHTTP(enrich.HTTPConfig{
+			URL:        viper.GetString("enrichment.http.url"),
+			Headers:    headers,
+			Timeout:    viper.GetDuration("enrichment.http.timeout"),
+			CacheTTL:   viper.GetDuration("enrichment.http.cache_ttl"),
+			MaxEntries: viper.GetInt("enrichment.http.cache_entries"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("enrichment.http: %w", err)
+		}
+		source = h
+	default:
+		return nil, fmt.Errorf("enrichment.source must be csv, redis or http, not %q", kind)
+	}
+
+	e, err := enrich.New(source, viper.GetString("enrichment.key"))
+	if err != nil {
+		return nil, err
+	}
+	ue := &userEnrichment{enricher: e}
+	switch policy := viper.GetString("enrichment.on_error"); policy {
+	case "fail":
+	case "continue":
+		ue.failOpen = true
+	default:
+		return nil, fmt.Errorf("enrichment.on_error must be fail or continue, not %q", policy)
+	}
+	slog.Info("Enriching users", "source", kind, "key", viper.GetString("enrichment.key"))
+	return ue, nil
+}
+
+// context returns ctx carrying the enrichment of user, or ctx itself if the
+// source has nothing for the user. With enrichment.on_error: continue a
+// failed lookup is logged and the user goes on without enrichment.
+func (e *userEnrichment) context(ctx context.Context, user db.User) (context.Context, error) {
+	if e == nil {
+		return ctx, nil
+	}
+	fields, err := e.enricher.Fields(ctx, user)
+	if err != nil {
+		if !e.failOpen {
+			return ctx, err
+		}
+		slog.Warn("Processing user without enrichment", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		return ctx, nil
+	}
+	if fields == nil {
+		return ctx, nil
+	}
+	return processor.WithEnrichment(ctx, fields), nil
+}
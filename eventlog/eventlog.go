@@ -0,0 +1,133 @@
+// Package eventlog rebuilds the state of a mailbox from its lifecycle event
+// log, and compacts old events into snapshots so the log stays short while
+// still explaining how each mailbox got into its current state.
+package eventlog
+
+import (
+	"strconv"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Mailbox statuses. A mailbox is active until it is suspended or archived.
+const (
+	Active    = "active"
+	Suspended = "suspended"
+	Archived  = "archived"
+)
+
+// State is what a mailbox's events add up to. Events counts every event
+// folded in, including those since compacted into a snapshot.
+type State struct {
+	MailboxID      int       `json:"mailbox_id"`
+	MPIID          string    `json:"mpi_id,omitempty"`
+	Status         string    `json:"status"`
+	Reason         string    `json:"reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	TokenRotations int       `json:"token_rotations"`
+	TokenRotatedAt time.Time `json:"token_rotated_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Events         int       `json:"events"`
+}
+
+// Apply returns s with e applied. Events of unknown kinds are only counted.
+func Apply(s State, e db.MailboxEvent) State {
+	if s.Status == "" {
+		s.Status = Active
+	}
+	s.MailboxID = e.MailboxID
+	s.UpdatedAt = e.OccurredAt
+	s.Events++
+
+	switch e.Kind {
+	case db.MailboxCreated:
+		s.MPIID = e.Data["mpi_id"]
+		s.CreatedAt = e.OccurredAt
+	case db.MailboxTokenRotated:
+		s.TokenRotations++
+		s.TokenRotatedAt = e.OccurredAt
+	case db.MailboxSuspended:
+		s.Status, s.Reason = Suspended, e.Data["reason"]
+	case db.MailboxResumed:
+		s.Status, s.Reason = Active, ""
+	case db.MailboxArchived:
+		s.Status, s.Reason = Archived, e.Data["reason"]
+	case db.MailboxSnapshot:
+		s = fromSnapshot(e)
+	}
+	return s
+}
+
+// Fold applies events, oldest first, to an empty state.
+func Fold(events []db.MailboxEvent) State {
+	var s State
+	for _, e := range events {
+		s = Apply(s, e)
+	}
+	return s
+}
+
+// Snapshot returns a snapshot event recording s, dated at its last update.
+func Snapshot(s State) db.MailboxEvent {
+	data := map[string]string{
+		"status":          s.Status,
+		"token_rotations": strconv.Itoa(s.TokenRotations),
+		"events":          strconv.Itoa(s.Events),
+	}
+	set := func(key, value string) {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	set("mpi_id", s.MPIID)
+	set("reason", s.Reason)
+	set("created_at", formatTime(s.CreatedAt))
+	set("token_rotated_at", formatTime(s.TokenRotatedAt))
+	return db.MailboxEvent{MailboxID: s.MailboxID, Kind: db.MailboxSnapshot, Data: data, OccurredAt: s.UpdatedAt}
+}
+
+func fromSnapshot(e db.MailboxEvent) State {
+	s := State{
+		MailboxID:      e.MailboxID,
+		MPIID:          e.Data["mpi_id"],
+		Status:         e.Data["status"],
+		Reason:         e.Data["reason"],
+		CreatedAt:      parseTime(e.Data["created_at"]),
+		TokenRotatedAt: parseTime(e.Data["token_rotated_at"]),
+		UpdatedAt:      e.OccurredAt,
+	}
+	s.TokenRotations, _ = strconv.Atoi(e.Data["token_rotations"])
+	s.Events, _ = strconv.Atoi(e.Data["events"])
+	if s.Status == "" {
+		s.Status = Active
+	}
+	return s
+}
+
+// Compact folds the events of one mailbox that occurred before cutoff into a
+// snapshot, and returns it with the ID of the last event it replaces. It
+// reports false when there are fewer than two such events, as a snapshot
+// would then save nothing. events must be oldest first.
+func Compact(events []db.MailboxEvent, cutoff time.Time) (db.MailboxEvent, int, bool) {
+	n := 0
+	for n < len(events) && events[n].OccurredAt.Before(cutoff) {
+		n++
+	}
+	if n < 2 {
+		return db.MailboxEvent{}, 0, false
+	}
+	return Snapshot(Fold(events[:n])), events[n-1].ID, true
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
@@ -0,0 +1,66 @@
+package eventlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func at(hour int) time.Time {
+	return time.Date(2024, 7, 23, hour, 0, 0, 0, time.UTC)
+}
+
+var events = []db.MailboxEvent{
+	{ID: 1, MailboxID: 1, Kind: db.MailboxCreated, Data: map[string]string{"mpi_id": "mpi123"}, OccurredAt: at(1)},
+	{ID: 2, MailboxID: 1, Kind: db.MailboxTokenRotated, OccurredAt: at(2)},
+	{ID: 5, MailboxID: 1, Kind: db.MailboxSuspended, Data: map[string]string{"reason": "abuse report"}, OccurredAt: at(3)},
+	{ID: 9, MailboxID: 1, Kind: db.MailboxResumed, OccurredAt: at(4)},
+	{ID: 12, MailboxID: 1, Kind: db.MailboxArchived, Data: map[string]string{"reason": "closed"}, OccurredAt: at(5)},
+}
+
+func TestFold(t *testing.T) {
+	expected := State{
+		MailboxID:      1,
+		MPIID:          "mpi123",
+		Status:         Suspended,
+		Reason:         "abuse report",
+		CreatedAt:      at(1),
+		TokenRotations: 1,
+		TokenRotatedAt: at(2),
+		UpdatedAt:      at(3),
+		Events:         3,
+	}
+	if s := Fold(events[:3]); !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s)
+	}
+
+	if s := Fold(events); s.Status != Archived || s.Reason != "closed" || s.Events != 5 {
+		t.Errorf("Expected an archived mailbox after 5 events, got %+v", s)
+	}
+	if s := Fold([]db.MailboxEvent{{MailboxID: 2, Kind: db.MailboxTokenRotated, OccurredAt: at(1)}}); s.Status != Active {
+		t.Errorf("Expected a mailbox without a created event to be active, got %+v", s)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	snapshot, through, ok := Compact(events, at(4))
+	if !ok || through != 5 {
+		t.Fatalf("Expected events through 5 to be compacted, got %d, %v", through, ok)
+	}
+	if snapshot.Kind != db.MailboxSnapshot || !snapshot.OccurredAt.Equal(at(3)) {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+
+	// The compacted log must add up to the same state as the original.
+	snapshot.ID = through
+	compacted := append([]db.MailboxEvent{snapshot}, events[3:]...)
+	if got, expected := Fold(compacted), Fold(events); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v after compaction, got %+v", expected, got)
+	}
+
+	if _, _, ok := Compact(events, at(2)); ok {
+		t.Errorf("Expected a single event not to be compacted")
+	}
+}
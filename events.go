@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/eventlog"
+	"mailboxes/scope"
+)
+
+const eventsUsage = "Usage: events show <mailbox-id> | suspend|resume|archive <mailbox-id> [--reason text] | compact [--older-than 720h] [--dry-run] | rebuild [--out file]"
+
+// eventsCommand reads and maintains the mailbox_events log: events show
+// lists a mailbox's events and the state they add up to, suspend, resume
+// and archive record those lifecycle events, compact folds old events into
+// snapshots and rebuild writes every mailbox's state, folded from the log,
+// as JSON lines.
+func eventsCommand(store db.Store, args []string) {
+	es, ok := store.(db.EventStore)
+	if !ok {
+		fatal("Store does not keep mailbox events")
+	}
+	if len(args) == 0 {
+		fatal(eventsUsage)
+	}
+
+	ctx := scope.WithActor(context.Background(), cliActor())
+	action, args := args[0], args[1:]
+	switch action {
+	case "show":
+		showEvents(ctx, es, args)
+	case "suspend", "resume", "archive":
+		recordEvent(ctx, es, action, args)
+	case "compact":
+		compactEvents(ctx, es, args)
+	case "rebuild":
+		rebuildEvents(ctx, es, args)
+	default:
+		fatal("Unknown events action; use show, suspend, resume, archive, compact or rebuild", "action", action)
+	}
+}
+
+// cliActor names the user running a command, for the events it records.
+func cliActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return "cli:" + user
+	}
+	return "cli"
+}
+
+func mailboxArg(args []string) int {
+	if len(args) == 0 {
+		fatal(eventsUsage)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fatal("Invalid mailbox ID", "mailbox_id", args[0], "error", err)
+	}
+	return id
+}
+
+func showEvents(ctx context.Context, es db.EventStore, args []string) {
+	mailboxID := mailboxArg(args)
+	events, err := es.MailboxEvents(ctx, mailboxID)
+	if err != nil {
+		fatal("Error reading events of mailbox", "mailbox_id", mailboxID, "error", err)
+	}
+	if len(events) == 0 {
+		fatal("No events logged for mailbox", "mailbox_id", mailboxID)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOCCURRED\tKIND\tDATA")
+	for _, e := range events {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", e.ID, e.OccurredAt.UTC().Format(time.RFC3339), e.Kind, formatEventData(e.Data))
+	}
+	w.Flush()
+
+	data, _ := json.MarshalIndent(eventlog.Fold(events), "", "  ")
+	fmt.Printf("\n%s\n", data)
+}
+
+// formatEventData lists data as key=value pairs in key order.
+func formatEventData(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + strconv.Quote(data[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// eventKinds maps the events actions that record an event to its kind.
+var eventKinds = map[string]string{
+	"suspend": db.MailboxSuspended,
+	"resume":  db.MailboxResumed,
+	"archive": db.MailboxArchived,
+}
+
+func recordEvent(ctx context.Context, es db.EventStore, action string, args []string) {
+	mailboxID := mailboxArg(args)
+	fs := flag.NewFlagSet("events "+action, flag.ExitOnError)
+	reason := fs.String("reason", "", "why, recorded with the event")
+	fs.Parse(args[1:])
+
+	e := db.MailboxEvent{MailboxID: mailboxID, Kind: eventKinds[action]}
+	if *reason != "" {
+		e.Data = map[string]string{"reason": *reason}
+	}
+	e, err := es.AppendMailboxEvent(ctx, e)
+	if err != nil {
+		fatal("Error recording mailbox event", "kind", e.Kind, "mailbox_id", mailboxID, "error", err)
+	}
+	slog.Info("Recorded mailbox event", "event_id", e.ID, "mailbox_id", mailboxID, "kind", e.Kind)
+}
+
+// compactEvents replaces each mailbox's events older than --older-than with
+// a snapshot of the state they produced.
+func compactEvents(ctx context.Context, es db.EventStore, args []string) {
+	fs := flag.NewFlagSet("events compact", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 30*24*time.Hour, "compact events older than this")
+	dryRun := fs.Bool("dry-run", false, "report what would be compacted without changing the log")
+	fs.Parse(args)
+
+	type compaction struct {
+		snapshot db.MailboxEvent
+		through  int
+	}
+	cutoff := time.Now().Add(-*olderThan)
+	var pending []compaction
+	replaced := 0
+	// The log is read in full before any of it is rewritten, so the writes
+	// don't contend with the open read.
+	err := eachMailboxEvents(ctx, es, func(events []db.MailboxEvent) error {
+		snapshot, through, ok := eventlog.Compact(events, cutoff)
+		if !ok {
+			return nil
+		}
+		pending = append(pending, compaction{snapshot, through})
+		for _, e := range events {
+			if e.ID <= through {
+				replaced++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fatal("Error reading mailbox events", "error", err)
+	}
+
+	if *dryRun {
+		slog.Info("Would compact events", "events", replaced, "mailboxes", len(pending), "older_than", cutoff.UTC().Format(time.RFC3339))
+		return
+	}
+	for _, c := range pending {
+		if err := es.CompactMailboxEvents(ctx, c.snapshot.MailboxID, c.through, c.snapshot); err != nil {
+			fatal("Error compacting events of mailbox", "mailbox_id", c.snapshot.MailboxID, "error", err)
+		}
+	}
+	slog.Info("Compacted events", "events", replaced, "mailboxes", len(pending), "older_than", cutoff.UTC().Format(time.RFC3339))
+}
+
+// rebuildEvents writes the state of every mailbox with logged events, folded
+// from the log, as one JSON object per line.
+func rebuildEvents(ctx context.Context, es db.EventStore, args []string) {
+	fs := flag.NewFlagSet("events rebuild", flag.ExitOnError)
+	out := fs.String("out", "", "file to write states to (default stdout)")
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fatal("Error creating file", "path", *out, "error", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	n := 0
+	err := eachMailboxEvents(ctx, es, func(events []db.MailboxEvent) error {
+		n++
+		return enc.Encode(eventlog.Fold(events))
+	})
+	if err != nil {
+		fatal("Error rebuilding mailbox states", "error", err)
+	}
+	slog.Info("Rebuilt mailbox state", "mailboxes", n)
+}
+
+// eachMailboxEvents streams the event log and calls f with each mailbox's
+// events in turn, oldest first.
+func eachMailboxEvents(ctx context.Context, es db.EventStore, f func([]db.MailboxEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	all, err := es.AllMailboxEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	var batch []db.MailboxEvent
+	for e := range all {
+		if len(batch) > 0 && e.MailboxID != batch[0].MailboxID {
+			if err := f(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		batch = append(batch, e)
+	}
+	if len(batch) > 0 {
+		return f(batch)
+	}
+	return nil
+}
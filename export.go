@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"strings"
+
+	"mailboxes/db"
+	"mailboxes/export"
+)
+
+// exportCommand writes every user to --out as JSON lines or CSV, limited to
+// the --fields given and redacted by export.redact. With --shards N the output is split by mailbox into N
+// files written in parallel, and --merge combines them back into --out
+// ordered by user ID. --layout maildir instead writes a Maildir folder per
+// mailbox under --out, for the migration tooling.
+func exportCommand(store db.Store, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "json", "output format: json or csv")
+	fields := flags.String("fields", "", "comma-separated fields to export (default "+strings.Join(export.UserFields, ",")+"; also "+strings.Join(export.MailboxFields, ",")+" and "+export.SourceField+")")
+	out := flags.String("out", "", "output file (default users.jsonl or users.csv)")
+	shards := flags.Int("shards", 1, "number of shard files to write in parallel")
+	merge := flags.Bool("merge", false, "merge the shards into --out ordered by user ID")
+	layout := flags.String("layout", "flat", "output layout: flat, or maildir for a folder per mailbox")
+	flags.Parse(args)
+
+	source, ok := store.(db.FullScanStore)
+	if !ok {
+		fatal("Store does not support full exports")
+	}
+
+	var names []string
+	if *fields != "" {
+		for _, name := range strings.Split(*fields, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	ctx := context.Background()
+
+	cols, err := export.NewColumns(names)
+	if err != nil {
+		fatal("Invalid --fields", "error", err)
+	}
+	if err := cols.Redact(redactPolicy("export.redact")); err != nil {
+		fatal("Invalid export.redact", "error", err)
+	}
+	if cols.NeedsMailboxes() {
+		mailboxes, err := loadMailboxes(ctx, store)
+		if err != nil {
+			fatal("Error retrieving mailboxes", "error", err)
+		}
+		cols.SetMailboxes(mailboxes)
+	}
+	if cols.NeedsSources() {
+		s := newUserSources(store)
+		if s == nil {
+			fatal("The source field requires provenance.enabled")
+		}
+		if err := s.refresh(ctx); err != nil {
+			fatal("Error retrieving user provenance", "error", err)
+		}
+		cols.SetSources(s.of)
+	}
+	// Merging decodes each shard back into users, ordered by ID, and looks up
+	// mailbox fields again by mailbox ID.
+	if *merge && !cols.Has("id") {
+		fatal("--merge requires the id field, unmasked")
+	}
+	if *merge && cols.NeedsMailboxes() && !cols.Has("mailbox_id") {
+		fatal("--merge with mailbox fields requires the mailbox_id field, unmasked")
+	}
+
+	enc, err := export.NewFormat(*format, cols)
+	if err != nil {
+		fatal("Invalid --format", "error", err)
+	}
+
+	switch *layout {
+	case "flat":
+	case "maildir":
+		if *shards > 1 || *merge {
+			fatal("--layout maildir does not support --shards or --merge")
+		}
+		if *out == "" {
+			*out = "maildir"
+		}
+		exportMaildir(ctx, store, *out, enc)
+		return
+	default:
+		fatal("Invalid --layout; must be flat or maildir", "layout", *layout)
+	}
+
+	if *out == "" {
+		*out = "users." + enc.Ext()
+	}
+
+	paths := []string{*out}
+	if *shards > 1 {
+		paths = export.ShardPaths(*out, *shards)
+	}
+
+	// Read through the iterator so a scan that fails partway through fails
+	// the export instead of leaving it short.
+	users := make(chan db.User)
+	var readErr error
+	go func() {
+		defer close(users)
+		for user, err := range db.AllUsers(ctx, source) {
+			if err != nil {
+				readErr = err
+				return
+			}
+			users <- user
+		}
+	}()
+
+	count, err := export.WriteSharded(users, paths, enc)
+	if readErr != nil {
+		fatal("Error retrieving users", "error", readErr)
+	}
+	if err != nil {
+		fatal("Error exporting users", "error", err)
+	}
+	slog.Info("Users exported", "users", count, "files", len(paths))
+
+	if !*merge || len(paths) == 1 {
+		return
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fatal("Error creating file", "path", *out, "error", err)
+	}
+	if _, err := export.Merge(f, paths, enc); err != nil {
+		f.Close()
+		fatal("Error merging shards", "error", err)
+	}
+	if err := f.Close(); err != nil {
+		fatal("Error writing file", "path", *out, "error", err)
+	}
+
+	for _, path := range paths {
+		os.Remove(path)
+	}
+	slog.Info("Shards merged", "path", *out)
+}
+
+// exportMaildir writes each mailbox as a Maildir folder under root, with its
+// users encoded by enc.
+func exportMaildir(ctx context.Context, store db.Store, root string, enc export.Format) {
+	mailboxChan, err := store.AllMailboxes(ctx)
+	if err != nil {
+		fatal("Error retrieving mailboxes", "error", err)
+	}
+
+	mailboxes, users := 0, 0
+	for mb := range mailboxChan {
+		userChan, err := store.UsersForMailbox(ctx, mb.ID)
+		if err != nil {
+			fatal("Error retrieving users for mailbox", "mailbox_id", mb.ID, "error", err)
+		}
+		n, err := export.WriteMaildir(root, mb, userChan, enc)
+		if err != nil {
+			fatal("Error exporting mailbox", "mailbox_id", mb.ID, "error", err)
+		}
+		mailboxes++
+		users += n
+	}
+	slog.Info("Mailboxes exported", "mailboxes", mailboxes, "users", users, "path", root)
+}
+
+// loadMailboxes reads every mailbox into a map by ID.
+func loadMailboxes(ctx context.Context, store db.Store) (map[int]db.Mailbox, error) {
+	mailboxChan, err := store.AllMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mailboxes := make(map[int]db.Mailbox)
+	for mb := range mailboxChan {
+		mailboxes[mb.ID] = mb
+	}
+	return mailboxes, nil
+}
@@ -0,0 +1,64 @@
+// Package export writes users to files for audits and offline processing,
+// optionally split into shards written in parallel.
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"mailboxes/db"
+)
+
+// Encoder writes users to an underlying stream in one format.
+type Encoder interface {
+	Encode(user db.User) error
+	// Flush writes any buffered data; the Encoder must not be used after.
+	Flush() error
+}
+
+// Decoder reads back what the matching Encoder wrote. Decode returns io.EOF
+// once the stream is exhausted.
+type Decoder interface {
+	Decode() (db.User, error)
+}
+
+type Format interface {
+	Ext() string
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// JSONLines writes one JSON object per user per line.
+var JSONLines Format = jsonLines{}
+
+type jsonLines struct{}
+
+func (jsonLines) Ext() string { return "jsonl" }
+
+func (jsonLines) NewEncoder(w io.Writer) Encoder {
+	bw := bufio.NewWriter(w)
+	return &jsonLinesEncoder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (jsonLines) NewDecoder(r io.Reader) Decoder {
+	return &jsonLinesDecoder{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+type jsonLinesEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonLinesEncoder) Encode(user db.User) error { return e.enc.Encode(user) }
+func (e *jsonLinesEncoder) Flush() error              { return e.w.Flush() }
+
+type jsonLinesDecoder struct {
+	dec *json.Decoder
+}
+
+func (d *jsonLinesDecoder) Decode() (db.User, error) {
+	var user db.User
+	err := d.dec.Decode(&user)
+	return user, err
+}
@@ -0,0 +1,302 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/redact"
+)
+
+// UserFields are the user columns exported by default, in order.
+var UserFields = []string{"id", "mailbox_id", "user_name", "email_address", "created_at"}
+
+// MailboxFields are the columns describing a user's mailbox that may also be
+// selected. Mailbox tokens are never exported.
+var MailboxFields = []string{"mailbox_mpi_id", "mailbox_created_at"}
+
+// SourceField selects the ingestion path each user was created through.
+const SourceField = "source"
+
+// Columns is a selection of fields to export. Mailbox fields are looked up
+// in the mailboxes map by each user's mailbox ID, and the source with the
+// function given to SetSources.
+type Columns struct {
+	names     []string
+	mailboxes map[int]db.Mailbox
+	source    func(userID int) string
+	policy    redact.Policy
+}
+
+// NewColumns validates a field selection. An empty selection exports
+// UserFields.
+func NewColumns(names []string) (*Columns, error) {
+	if len(names) == 0 {
+		names = UserFields
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !isUserField(name) && !isMailboxField(name) && name != SourceField {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("field %q selected twice", name)
+		}
+		seen[name] = true
+	}
+	return &Columns{names: names}, nil
+}
+
+// SetMailboxes provides the mailboxes that mailbox fields are read from.
+func (c *Columns) SetMailboxes(mailboxes map[int]db.Mailbox) {
+	c.mailboxes = mailboxes
+}
+
+// SetSources provides the function the source field is read from.
+func (c *Columns) SetSources(source func(userID int) string) {
+	c.source = source
+}
+
+// NeedsSources reports whether the source field is selected.
+func (c *Columns) NeedsSources() bool {
+	return contains(c.names, SourceField)
+}
+
+// Names returns the selected fields in order.
+func (c *Columns) Names() []string {
+	return c.names
+}
+
+// Redact drops the fields p excludes and masks the values of those it
+// masks. It fails if no field is left to export.
+func (c *Columns) Redact(p redact.Policy) error {
+	var names []string
+	for _, name := range c.names {
+		if p.Keep(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("every selected field is redacted")
+	}
+	c.names = names
+	c.policy = p
+	return nil
+}
+
+// NeedsMailboxes reports whether any mailbox field is selected.
+func (c *Columns) NeedsMailboxes() bool {
+	for _, name := range c.names {
+		if isMailboxField(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether name is selected and exported unmasked, so it can be
+// read back.
+func (c *Columns) Has(name string) bool {
+	return contains(c.names, name) && !c.policy.Masked(name)
+}
+
+func (c *Columns) value(user db.User, name string) any {
+	if c.policy.Masked(name) {
+		return redact.MaskValue(c.rawValue(user, name))
+	}
+	return c.rawValue(user, name)
+}
+
+func (c *Columns) rawValue(user db.User, name string) any {
+	switch name {
+	case "id":
+		return user.ID
+	case "mailbox_id":
+		return user.MailboxID
+	case "user_name":
+		return user.UserName
+	case "email_address":
+		return user.EmailAddress
+	case "created_at":
+		return formatTime(user.CreatedAt)
+	case "mailbox_mpi_id":
+		return c.mailboxes[user.MailboxID].MPIID
+	case "mailbox_created_at":
+		return formatTime(c.mailboxes[user.MailboxID].CreatedAt)
+	case SourceField:
+		if c.source == nil {
+			return ""
+		}
+		return c.source(user.ID)
+	}
+	return nil
+}
+
+// setUserField sets a user field from its exported text, for decoding.
+// Mailbox fields and the source are ignored.
+func setUserField(user *db.User, name, value string) error {
+	var err error
+	switch name {
+	case "id":
+		user.ID, err = strconv.Atoi(value)
+	case "mailbox_id":
+		user.MailboxID, err = strconv.Atoi(value)
+	case "user_name":
+		user.UserName = value
+	case "email_address":
+		user.EmailAddress = value
+	case "created_at":
+		user.CreatedAt, err = db.ParseTimestamp(value)
+	}
+	return err
+}
+
+// formatTime exports t as RFC 3339 in UTC; the zero time exports empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func isUserField(name string) bool    { return contains(UserFields, name) }
+func isMailboxField(name string) bool { return contains(MailboxFields, name) }
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewFormat returns the named format ("json" or "csv") writing cols. JSON
+// with the default columns is JSONLines.
+func NewFormat(name string, cols *Columns) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "json", "jsonl":
+		if cols == nil || (cols.policy.Empty() && strings.Join(cols.names, ",") == strings.Join(UserFields, ",")) {
+			return JSONLines, nil
+		}
+		return jsonFields{cols: cols}, nil
+	case "csv":
+		if cols == nil {
+			cols = &Columns{names: UserFields}
+		}
+		return csvFormat{cols: cols}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", name)
+}
+
+// jsonFields writes one JSON object per user per line with only the
+// selected fields.
+type jsonFields struct {
+	cols *Columns
+}
+
+func (jsonFields) Ext() string { return "jsonl" }
+
+func (f jsonFields) NewEncoder(w io.Writer) Encoder {
+	bw := bufio.NewWriter(w)
+	return &jsonFieldsEncoder{cols: f.cols, w: bw, enc: json.NewEncoder(bw)}
+}
+
+// NewDecoder reads back the user fields present; the rest stay zero.
+func (jsonFields) NewDecoder(r io.Reader) Decoder {
+	return JSONLines.NewDecoder(r)
+}
+
+type jsonFieldsEncoder struct {
+	cols *Columns
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+func (e *jsonFieldsEncoder) Encode(user db.User) error {
+	obj := make(map[string]any, len(e.cols.names))
+	for _, name := range e.cols.names {
+		obj[name] = e.cols.value(user, name)
+	}
+	return e.enc.Encode(obj)
+}
+
+func (e *jsonFieldsEncoder) Flush() error { return e.w.Flush() }
+
+// csvFormat writes a header row naming the selected fields, then one row per
+// user.
+type csvFormat struct {
+	cols *Columns
+}
+
+func (csvFormat) Ext() string { return "csv" }
+
+func (f csvFormat) NewEncoder(w io.Writer) Encoder {
+	return &csvEncoder{cols: f.cols, w: csv.NewWriter(w)}
+}
+
+func (csvFormat) NewDecoder(r io.Reader) Decoder {
+	return &csvDecoder{r: csv.NewReader(bufio.NewReader(r))}
+}
+
+type csvEncoder struct {
+	cols        *Columns
+	w           *csv.Writer
+	wroteHeader bool
+	record      []string
+}
+
+func (e *csvEncoder) Encode(user db.User) error {
+	if !e.wroteHeader {
+		if err := e.w.Write(e.cols.names); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+
+	e.record = e.record[:0]
+	for _, name := range e.cols.names {
+		e.record = append(e.record, fmt.Sprint(e.cols.value(user, name)))
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r      *csv.Reader
+	header []string
+}
+
+func (d *csvDecoder) Decode() (db.User, error) {
+	if d.header == nil {
+		header, err := d.r.Read()
+		if err != nil {
+			return db.User{}, err
+		}
+		d.header = header
+	}
+
+	record, err := d.r.Read()
+	if err != nil {
+		return db.User{}, err
+	}
+
+	var user db.User
+	for i, name := range d.header {
+		if err := setUserField(&user, name, record[i]); err != nil {
+			return db.User{}, fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return user, nil
+}
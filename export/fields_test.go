@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/redact"
+)
+
+func TestNewColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		want    []string
+		wantErr bool
+	}{
+		{"default", nil, UserFields, false},
+		{"selected", []string{"email_address", "mailbox_mpi_id"}, []string{"email_address", "mailbox_mpi_id"}, false},
+		{"unknown", []string{"token"}, nil, true},
+		{"duplicate", []string{"id", "id"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, err := NewColumns(tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && !reflect.DeepEqual(cols.Names(), tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, cols.Names())
+			}
+		})
+	}
+}
+
+func TestCSVWriteShardedAndMerge(t *testing.T) {
+	users := testUsers(50)
+	paths := ShardPaths(filepath.Join(t.TempDir(), "users.csv"), 3)
+
+	format, err := NewFormat("csv", nil)
+	if err != nil {
+		t.Fatalf("Error creating format: %v", err)
+	}
+	if _, err := WriteSharded(stream(users), paths, format); err != nil {
+		t.Fatalf("Error writing shards: %v", err)
+	}
+
+	var merged bytes.Buffer
+	if _, err := Merge(&merged, paths, format); err != nil {
+		t.Fatalf("Error merging shards: %v", err)
+	}
+
+	dec := format.NewDecoder(&merged)
+	var got []db.User
+	for {
+		user, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error decoding merged output: %v", err)
+		}
+		got = append(got, user)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("Expected merged output to match the ordered input")
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	user := db.User{ID: 1, MailboxID: 2, UserName: "a, b", EmailAddress: "a@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)}
+	mailboxes := map[int]db.Mailbox{2: {ID: 2, MPIID: "mpi-2", Token: "secret", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "id,user_name,mailbox_mpi_id,source\n1,\"a, b\",mpi-2,import:users.csv\n"},
+		{"json", `{"id":1,"mailbox_mpi_id":"mpi-2","source":"import:users.csv","user_name":"a, b"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			cols, err := NewColumns([]string{"id", "user_name", "mailbox_mpi_id", SourceField})
+			if err != nil {
+				t.Fatalf("Error selecting columns: %v", err)
+			}
+			cols.SetMailboxes(mailboxes)
+			cols.SetSources(func(int) string { return "import:users.csv" })
+
+			format, err := NewFormat(tt.format, cols)
+			if err != nil {
+				t.Fatalf("Error creating format: %v", err)
+			}
+
+			var buf bytes.Buffer
+			enc := format.NewEncoder(&buf)
+			if err := enc.Encode(user); err != nil {
+				t.Fatalf("Error encoding: %v", err)
+			}
+			if err := enc.Flush(); err != nil {
+				t.Fatalf("Error flushing: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestColumns_Redact(t *testing.T) {
+	cols, err := NewColumns(nil)
+	if err != nil {
+		t.Fatalf("Error selecting columns: %v", err)
+	}
+	if err := cols.Redact(redact.Policy{Exclude: []string{"created_at", "user_name"}, Mask: []string{"email_address"}}); err != nil {
+		t.Fatalf("Error redacting columns: %v", err)
+	}
+	if cols.Has("email_address") {
+		t.Errorf("Expected a masked field not to be readable")
+	}
+
+	format, err := NewFormat("csv", cols)
+	if err != nil {
+		t.Fatalf("Error creating format: %v", err)
+	}
+	var buf bytes.Buffer
+	enc := format.NewEncoder(&buf)
+	enc.Encode(db.User{ID: 1, MailboxID: 2, UserName: "user1", EmailAddress: "user1@example.com"})
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if want := "id,mailbox_id,email_address\n1,2,u***@example.com\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+
+	if err := cols.Redact(redact.Policy{Include: []string{"token"}}); err == nil {
+		t.Errorf("Expected an error when every field is redacted")
+	}
+}
+
+func TestNewFormatUnknown(t *testing.T) {
+	if _, err := NewFormat("xml", nil); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
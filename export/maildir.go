@@ -0,0 +1,104 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+)
+
+// MaildirMetadata is the mailbox.json stub written into each mailbox's
+// Maildir folder.
+type MaildirMetadata struct {
+	ID        int       `json:"id"`
+	MPIID     string    `json:"mpi_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Users     int       `json:"users"`
+	UsersFile string    `json:"users_file"`
+	TokenFile string    `json:"token_file"`
+}
+
+// maildirTokenFile is the placeholder written where a mailbox's token goes.
+// Tokens are never exported; the migration tooling fills it in.
+const maildirTokenFile = "token"
+
+// MaildirName returns the folder name of the mailbox with mpiID: the MPI ID
+// escaped so that it is a single path element and not hidden.
+func MaildirName(mpiID string) string {
+	name := url.PathEscape(mpiID)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// WriteMaildir writes mb as a Maildir folder under root, in the layout our
+// migration tooling imports: the empty cur, new and tmp directories, a
+// mailbox.json metadata stub, an empty token placeholder and the mailbox's
+// users, encoded with format. It returns how many users were written. users
+// is always drained.
+func WriteMaildir(root string, mb db.Mailbox, users <-chan db.User, format Format) (int, error) {
+	dir := filepath.Join(root, MaildirName(mb.MPIID))
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			drain(users)
+			return 0, err
+		}
+	}
+
+	token, err := os.OpenFile(filepath.Join(dir, maildirTokenFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		drain(users)
+		return 0, err
+	}
+	if err := token.Close(); err != nil {
+		drain(users)
+		return 0, err
+	}
+
+	usersFile := "users." + format.Ext()
+	f, err := os.Create(filepath.Join(dir, usersFile))
+	if err != nil {
+		drain(users)
+		return 0, err
+	}
+	count := 0
+	counted := make(chan db.User)
+	go func() {
+		defer close(counted)
+		for user := range users {
+			count++
+			counted <- user
+		}
+	}()
+	if err := writeShard(f, counted, format); err != nil {
+		return 0, err
+	}
+
+	meta, err := json.MarshalIndent(MaildirMetadata{
+		ID:        mb.ID,
+		MPIID:     mb.MPIID,
+		CreatedAt: mb.CreatedAt,
+		Users:     count,
+		UsersFile: usersFile,
+		TokenFile: maildirTokenFile,
+	}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mailbox.json"), append(meta, '\n'), 0o644); err != nil {
+		return 0, fmt.Errorf("writing metadata of mailbox %s: %w", mb.MPIID, err)
+	}
+	return count, nil
+}
+
+// drain discards what is left on users, so the producer never blocks.
+func drain(users <-chan db.User) {
+	for range users {
+	}
+}
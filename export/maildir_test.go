@@ -0,0 +1,72 @@
+package export
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func TestMaildirName(t *testing.T) {
+	for mpiID, expected := range map[string]string{
+		"mpi123":  "mpi123",
+		"a/b":     "a%2Fb",
+		"..":      "%2E.",
+		".hidden": "%2Ehidden",
+	} {
+		if got := MaildirName(mpiID); got != expected {
+			t.Errorf("MaildirName(%q): expected %q, got %q", mpiID, expected, got)
+		}
+	}
+}
+
+func TestWriteMaildir(t *testing.T) {
+	root := t.TempDir()
+	mb := db.Mailbox{ID: 1, MPIID: "mpi123", Token: "token123", CreatedAt: time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)}
+
+	count, err := WriteMaildir(root, mb, stream(testUsers(3)), JSONLines)
+	if err != nil {
+		t.Fatalf("Error writing Maildir: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 users written, got %d", count)
+	}
+
+	dir := filepath.Join(root, "mpi123")
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			t.Errorf("Expected directory %s: %v", sub, err)
+		}
+	}
+	if token, err := os.ReadFile(filepath.Join(dir, "token")); err != nil || len(token) != 0 {
+		t.Errorf("Expected an empty token placeholder, got %q (%v)", token, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "mailbox.json"))
+	if err != nil {
+		t.Fatalf("Error reading metadata: %v", err)
+	}
+	var meta MaildirMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Error decoding metadata: %v", err)
+	}
+	expected := MaildirMetadata{ID: 1, MPIID: "mpi123", CreatedAt: mb.CreatedAt, Users: 3, UsersFile: "users.jsonl", TokenFile: "token"}
+	if meta != expected {
+		t.Errorf("Expected metadata %+v, got %+v", expected, meta)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "users.jsonl"))
+	if err != nil {
+		t.Fatalf("Error opening users: %v", err)
+	}
+	defer f.Close()
+	dec := JSONLines.NewDecoder(f)
+	for i := 0; i < 3; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatalf("Error decoding user %d: %v", i, err)
+		}
+	}
+}
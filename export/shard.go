@@ -0,0 +1,191 @@
+package export
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mailboxes/db"
+)
+
+// ShardPaths returns the file names for n shards of path, for example
+// users-00001-of-00004.jsonl for the second of four shards of users.jsonl.
+func ShardPaths(path string, n int) []string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s-%05d-of-%05d%s", base, i, n, ext)
+	}
+	return paths
+}
+
+// ShardFor returns which of shards a mailbox's users are written to.
+func ShardFor(mailboxID, shards int) int {
+	h := fnv.New32a()
+	binary.Write(h, binary.BigEndian, int64(mailboxID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// shardBuffer is how many users may queue for each shard writer.
+const shardBuffer = 256
+
+// WriteSharded writes users to paths in parallel, one writer per path, and
+// returns how many users were written. All users of a mailbox go to the same
+// shard, and within a shard users keep the order they arrived in.
+func WriteSharded(users <-chan db.User, paths []string, format Format) (int, error) {
+	queues := make([]chan db.User, len(paths))
+	results := make(chan error, len(paths))
+
+	for i, path := range paths {
+		f, err := os.Create(path)
+		if err != nil {
+			for _, q := range queues[:i] {
+				close(q)
+			}
+			for range queues[:i] {
+				<-results
+			}
+			return 0, err
+		}
+
+		queues[i] = make(chan db.User, shardBuffer)
+		go func(f *os.File, queue <-chan db.User) {
+			results <- writeShard(f, queue, format)
+		}(f, queues[i])
+	}
+
+	count := 0
+	for user := range users {
+		queues[ShardFor(user.MailboxID, len(paths))] <- user
+		count++
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+
+	var errs []error
+	for range queues {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return count, errors.Join(errs...)
+}
+
+// writeShard encodes everything received on queue into f. After a write
+// error it keeps draining queue so the dispatcher never blocks.
+func writeShard(f *os.File, queue <-chan db.User, format Format) error {
+	enc := format.NewEncoder(f)
+
+	var err error
+	for user := range queue {
+		if err == nil {
+			err = enc.Encode(user)
+		}
+	}
+
+	if err == nil {
+		err = enc.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// Merge combines shard files that are each ordered by user ID into a single
+// stream ordered by user ID, and returns how many users were written.
+func Merge(w io.Writer, paths []string, format Format) (int, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	h := &mergeHeap{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+
+		c := &mergeCursor{dec: format.NewDecoder(f), path: path}
+		ok, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			*h = append(*h, c)
+		}
+	}
+	heap.Init(h)
+
+	enc := format.NewEncoder(w)
+	count := 0
+	for h.Len() > 0 {
+		c := (*h)[0]
+		if err := enc.Encode(c.user); err != nil {
+			return count, err
+		}
+		count++
+
+		ok, err := c.next()
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return count, enc.Flush()
+}
+
+type mergeCursor struct {
+	dec  Decoder
+	path string
+	user db.User
+}
+
+func (c *mergeCursor) next() (bool, error) {
+	user, err := c.dec.Decode()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", c.path, err)
+	}
+	c.user = user
+	return true, nil
+}
+
+// mergeHeap orders cursors by their current user's ID.
+type mergeHeap []*mergeCursor
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].user.ID < h[j].user.ID }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(*mergeCursor)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func testUsers(n int) []db.User {
+	users := make([]db.User, n)
+	for i := range users {
+		users[i] = db.User{ID: i + 1, MailboxID: i%7 + 1, UserName: "user", EmailAddress: "user@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)}
+	}
+	return users
+}
+
+func stream(users []db.User) <-chan db.User {
+	ch := make(chan db.User)
+	go func() {
+		defer close(ch)
+		for _, user := range users {
+			ch <- user
+		}
+	}()
+	return ch
+}
+
+func TestShardPaths(t *testing.T) {
+	expected := []string{"out/users-00000-of-00002.jsonl", "out/users-00001-of-00002.jsonl"}
+	if got := ShardPaths("out/users.jsonl", 2); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestWriteShardedAndMerge(t *testing.T) {
+	users := testUsers(500)
+	paths := ShardPaths(filepath.Join(t.TempDir(), "users.jsonl"), 4)
+
+	count, err := WriteSharded(stream(users), paths, JSONLines)
+	if err != nil {
+		t.Fatalf("Error writing shards: %v", err)
+	}
+	if count != len(users) {
+		t.Errorf("Expected %d users written, got %d", len(users), count)
+	}
+
+	// Every mailbox must land in exactly one shard.
+	shardOf := map[int]string{}
+	total := 0
+	for _, path := range paths {
+		for _, user := range readAll(t, path) {
+			if other, ok := shardOf[user.MailboxID]; ok && other != path {
+				t.Errorf("Mailbox %d split across %s and %s", user.MailboxID, other, path)
+			}
+			shardOf[user.MailboxID] = path
+			total++
+		}
+	}
+	if total != len(users) {
+		t.Errorf("Expected %d users across shards, got %d", len(users), total)
+	}
+
+	var merged bytes.Buffer
+	if _, err := Merge(&merged, paths, JSONLines); err != nil {
+		t.Fatalf("Error merging shards: %v", err)
+	}
+
+	dec := JSONLines.NewDecoder(&merged)
+	var got []db.User
+	for {
+		user, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error decoding merged output: %v", err)
+		}
+		got = append(got, user)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("Expected merged output to match the ordered input")
+	}
+}
+
+func readAll(t *testing.T, path string) []db.User {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening %s: %v", path, err)
+	}
+	defer f.Close()
+
+	dec := JSONLines.NewDecoder(f)
+	var users []db.User
+	for {
+		user, err := dec.Decode()
+		if err == io.EOF {
+			return users
+		}
+		if err != nil {
+			t.Fatalf("Error decoding %s: %v", path, err)
+		}
+		users = append(users, user)
+	}
+}
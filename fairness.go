@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"mailboxes/db"
+	"mailboxes/fairshare"
+)
+
+// tenantFairness, when pipeline.fairness.enabled is set, divides Pipeline's
+// workers between tenants by weight, so that one tenant's giant mailboxes
+// don't hold every worker while other tenants' mailboxes wait.
+var tenantFairness *fairnessConfig
+
+// fairnessConfig is the pipeline.fairness section.
+type fairnessConfig struct {
+	// Weights maps tenants to their weight; others have a weight of 1, as do
+	// mailboxes without a tenant, which share one.
+	Weights map[string]float64 `mapstructure:"weights"`
+	// Lookahead is how many mailboxes are read ahead of the workers to
+	// choose the next one from.
+	Lookahead int `mapstructure:"lookahead"`
+}
+
+// tenantDispatch hands Pipeline's mailboxes to workers by tenant share.
+type tenantDispatch struct {
+	queue     *fairshare.Queue[db.Mailbox]
+	tenants   map[int]string
+	lookahead int
+}
+
+// newTenantDispatch returns the dispatcher for a run, or nil if fairness is
+// off.
+func newTenantDispatch(ctx context.Context, store db.Store) (*tenantDispatch, error) {
+	if tenantFairness == nil {
+		return nil, nil
+	}
+	qs, ok := store.(db.QuotaStore)
+	if !ok {
+		slog.Warn("Store does not assign mailboxes to tenants; dispatching mailboxes in order")
+		return nil, nil
+	}
+	tenants, err := qs.MailboxTenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving mailbox tenants: %w", err)
+	}
+
+	// Configuration keys are lower-cased, so weights are matched to tenants
+	// without regard to case.
+	weights := make(map[string]float64)
+	for _, tenant := range tenants {
+		if w, ok := tenantFairness.Weights[strings.ToLower(tenant)]; ok {
+			weights[tenant] = w
+		}
+	}
+	return &tenantDispatch{
+		queue:     fairshare.New[db.Mailbox](weights),
+		tenants:   tenants,
+		lookahead: tenantFairness.Lookahead,
+	}, nil
+}
+
+// dispatch reads mailboxes, keeping those accept passes, and sends them on
+// by tenant share. Before each one it calls wait, which returns once a
+// worker is free, so the choice is made when the mailbox can start. It
+// stops sending once ctx is done, but reads mailboxes until they run out.
+func (d *tenantDispatch) dispatch(ctx context.Context, mailboxes <-chan db.Mailbox, accept func(*db.Mailbox) bool, wait func(), send func(db.Mailbox)) {
+	open := true
+	for {
+		wait()
+	fill:
+		for open && d.queue.Len() < d.lookahead {
+			var mb db.Mailbox
+			if d.queue.Len() == 0 {
+				mb, open = <-mailboxes
+			} else {
+				select {
+				case mb, open = <-mailboxes:
+				default:
+					break fill
+				}
+			}
+			if open && accept(&mb) {
+				d.queue.Push(d.tenants[mb.ID], mb)
+			}
+		}
+		if ctx.Err() != nil {
+			for range mailboxes {
+			}
+			return
+		}
+
+		_, mb, ok := d.queue.Pop()
+		if !ok {
+			return
+		}
+		send(mb)
+	}
+}
+
+// done records that a worker has finished mb.
+func (d *tenantDispatch) done(mb db.Mailbox) {
+	if d != nil {
+		d.queue.Done(d.tenants[mb.ID])
+	}
+}
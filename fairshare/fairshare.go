@@ -0,0 +1,109 @@
+// Package fairshare divides workers between tenants by weight, so that one
+// tenant with many or giant mailboxes cannot hold every worker while other
+// tenants' mailboxes wait.
+package fairshare
+
+import (
+	"sync"
+)
+
+// Queue holds pending items by tenant. Pop hands out the next item of the
+// tenant using the least of its share: the fewest running items for its
+// weight. Tenants at the same share take turns. It is safe for concurrent
+// use.
+type Queue[T any] struct {
+	weights map[string]float64
+
+	mu      sync.Mutex
+	pending map[string][]T
+	running map[string]int
+	// order is the tenants with pending items, in the order they take turns.
+	order []string
+	n     int
+}
+
+// New returns an empty Queue. Tenants missing from weights, and those with
+// a weight that is not positive, have a weight of 1.
+func New[T any](weights map[string]float64) *Queue[T] {
+	return &Queue[T]{weights: weights, pending: make(map[string][]T), running: make(map[string]int)}
+}
+
+// Push adds item to tenant's pending items.
+func (q *Queue[T]) Push(tenant string, item T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending[tenant]) == 0 {
+		q.order = append(q.order, tenant)
+	}
+	q.pending[tenant] = append(q.pending[tenant], item)
+	q.n++
+}
+
+// Len returns the number of pending items.
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Pop removes the next item and counts it as running for its tenant until
+// Done is called. It reports false if nothing is pending.
+func (q *Queue[T]) Pop() (string, T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var zero T
+	if q.n == 0 {
+		return "", zero, false
+	}
+
+	best := 0
+	for i, tenant := range q.order[1:] {
+		if q.share(tenant) < q.share(q.order[best]) {
+			best = i + 1
+		}
+	}
+	tenant := q.order[best]
+	item := q.pending[tenant][0]
+	q.pending[tenant] = q.pending[tenant][1:]
+	q.n--
+	q.running[tenant]++
+
+	// The tenant goes to the back of the turn order, or leaves it if it has
+	// nothing left.
+	q.order = append(q.order[:best], q.order[best+1:]...)
+	if len(q.pending[tenant]) > 0 {
+		q.order = append(q.order, tenant)
+	} else {
+		delete(q.pending, tenant)
+	}
+	return tenant, item, true
+}
+
+// Done records that an item of tenant returned by Pop has finished.
+func (q *Queue[T]) Done(tenant string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running[tenant]--; q.running[tenant] <= 0 {
+		delete(q.running, tenant)
+	}
+}
+
+// Running returns the number of running items of each tenant.
+func (q *Queue[T]) Running() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	running := make(map[string]int, len(q.running))
+	for tenant, n := range q.running {
+		running[tenant] = n
+	}
+	return running
+}
+
+func (q *Queue[T]) share(tenant string) float64 {
+	w := q.weights[tenant]
+	if w <= 0 {
+		w = 1
+	}
+	return float64(q.running[tenant]) / w
+}
@@ -0,0 +1,60 @@
+package fairshare
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueue_Pop(t *testing.T) {
+	q := New[int](map[string]float64{"acme": 3})
+	// acme has a giant backlog queued first; globex and initech follow.
+	for i := 1; i <= 10; i++ {
+		q.Push("acme", i)
+	}
+	q.Push("globex", 100)
+	q.Push("globex", 101)
+	q.Push("initech", 200)
+
+	// Six workers are split 3:2:1 rather than all going to acme; globex,
+	// with the last free slot, is level with acme and next in turn.
+	var tenants []string
+	for i := 0; i < 6; i++ {
+		tenant, _, ok := q.Pop()
+		if !ok {
+			t.Fatalf("Expected pending items")
+		}
+		tenants = append(tenants, tenant)
+	}
+	expected := []string{"acme", "globex", "initech", "acme", "acme", "globex"}
+	if !reflect.DeepEqual(tenants, expected) {
+		t.Errorf("Expected %v, got %v", expected, tenants)
+	}
+	if running := q.Running(); !reflect.DeepEqual(running, map[string]int{"acme": 3, "globex": 2, "initech": 1}) {
+		t.Errorf("Unexpected running counts %v", running)
+	}
+
+	// Finished globex items free slots for acme, the only tenant left.
+	q.Done("globex")
+	q.Done("globex")
+	if tenant, item, _ := q.Pop(); tenant != "acme" || item != 4 {
+		t.Errorf("Expected acme's item 4, got %s's %d", tenant, item)
+	}
+	if q.Len() != 6 {
+		t.Errorf("Expected 6 pending items, got %d", q.Len())
+	}
+}
+
+func TestQueue_Empty(t *testing.T) {
+	q := New[int](nil)
+	if _, _, ok := q.Pop(); ok {
+		t.Errorf("Expected nothing to pop from an empty queue")
+	}
+	q.Push("", 1)
+	if tenant, item, ok := q.Pop(); !ok || tenant != "" || item != 1 {
+		t.Errorf("Expected the untenanted item, got %q %d %v", tenant, item, ok)
+	}
+	q.Done("")
+	if running := q.Running(); len(running) != 0 {
+		t.Errorf("Expected nothing running, got %v", running)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+
+	"mailboxes/db"
+	"mailboxes/filter"
+	"mailboxes/skip"
+)
+
+// pipelineFilter, set with pipeline.filter or run --filter, limits runs to
+// the mailboxes and users it matches; nil processes everyone.
+var pipelineFilter *filter.Filter
+
+// filterMailbox reports whether any user of mb may pass pipelineFilter, so
+// that mailboxes none can are passed over without reading their users.
+func filterMailbox(mb db.Mailbox) bool {
+	if pipelineFilter == nil || pipelineFilter.Mailbox(mb) {
+		return true
+	}
+	slog.Debug("Skipping mailbox", "component", "pipeline", "mailbox_id", mb.ID, "reason", skip.PipelineFilter)
+	return false
+}
+
+// filterUser reports whether user, of mb, passes pipelineFilter, and
+// records it as skipped if not.
+func filterUser(mb db.Mailbox, user db.User) bool {
+	if pipelineFilter == nil || pipelineFilter.User(mb, user) {
+		return true
+	}
+	skipped(user, skip.PipelineFilter)
+	return false
+}
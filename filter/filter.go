@@ -0,0 +1,267 @@
+// Package filter compiles expressions that select which mailboxes and users
+// a run processes, such as
+//
+//	mailbox.created_at > "2024-01-01" && user.email endsWith "@example.com"
+//
+// An expression compares fields with literals and combines the comparisons
+// with &&, || and !, grouped with parentheses. The fields are mailbox.id,
+// mailbox.mpi_id, mailbox.created_at, mailbox.updated_at, user.id,
+// user.mailbox_id, user.name, user.email, user.created_at and
+// user.updated_at; user.user_name and user.email_address are accepted for
+// the last two string fields too. Every field takes ==, !=, <, <=, >, >= and
+// in [a, b, ...]; string fields also take contains, startsWith, endsWith and
+// matches, a regular expression. IDs compare with integers, strings and
+// timestamps with double-quoted strings, timestamps in any form
+// db.ParseTimestamp accepts, such as "2024-01-01".
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Filter is a compiled expression. It is safe for concurrent use.
+type Filter struct {
+	src      string
+	root     node
+	usesUser bool
+}
+
+// Compile parses and type-checks src.
+func Compile(src string) (*Filter, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("filter: unexpected %s at offset %d", t, t.pos)
+	}
+	return &Filter{src: src, root: root, usesUser: p.usesUser}, nil
+}
+
+// String returns the expression the filter was compiled from.
+func (f *Filter) String() string {
+	return f.src
+}
+
+// UsesUser reports whether the expression refers to any user field. If it
+// does not, Mailbox decides for every user of the mailbox.
+func (f *Filter) UsesUser() bool {
+	return f.usesUser
+}
+
+// Mailbox reports whether any user of mb may match: false only if the
+// expression is false whatever the user, so the mailbox can be passed over
+// without reading its users.
+func (f *Filter) Mailbox(mb db.Mailbox) bool {
+	return f.root.eval(&mb, nil) != no
+}
+
+// User reports whether user, of mailbox mb, matches.
+func (f *Filter) User(mb db.Mailbox, user db.User) bool {
+	return f.root.eval(&mb, &user) == yes
+}
+
+// truth is the value of an expression, which is unknown when it depends on
+// a user field and there is no user yet.
+type truth int8
+
+const (
+	no truth = iota
+	yes
+	unknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return yes
+	}
+	return no
+}
+
+type node interface {
+	eval(mb *db.Mailbox, user *db.User) truth
+}
+
+type andNode struct{ l, r node }
+
+func (n andNode) eval(mb *db.Mailbox, user *db.User) truth {
+	l := n.l.eval(mb, user)
+	if l == no {
+		return no
+	}
+	r := n.r.eval(mb, user)
+	if r == no {
+		return no
+	}
+	if l == yes && r == yes {
+		return yes
+	}
+	return unknown
+}
+
+type orNode struct{ l, r node }
+
+func (n orNode) eval(mb *db.Mailbox, user *db.User) truth {
+	l := n.l.eval(mb, user)
+	if l == yes {
+		return yes
+	}
+	r := n.r.eval(mb, user)
+	if r == yes {
+		return yes
+	}
+	if l == no && r == no {
+		return no
+	}
+	return unknown
+}
+
+type notNode struct{ n node }
+
+func (n notNode) eval(mb *db.Mailbox, user *db.User) truth {
+	switch n.n.eval(mb, user) {
+	case yes:
+		return no
+	case no:
+		return yes
+	}
+	return unknown
+}
+
+// compareNode tests one field with a test built for its type.
+type compareNode struct {
+	field field
+	test  func(v any) bool
+}
+
+func (n compareNode) eval(mb *db.Mailbox, user *db.User) truth {
+	if n.field.user && user == nil {
+		return unknown
+	}
+	return truthOf(n.test(n.field.get(mb, user)))
+}
+
+// kind is the type of a field's values: int, string or time.Time.
+type kind int
+
+const (
+	kindInt kind = iota
+	kindString
+	kindTime
+)
+
+type field struct {
+	kind kind
+	user bool
+	get  func(mb *db.Mailbox, user *db.User) any
+}
+
+var fields = map[string]field{
+	"mailbox.id":         {kind: kindInt, get: func(mb *db.Mailbox, _ *db.User) any { return mb.ID }},
+	"mailbox.mpi_id":     {kind: kindString, get: func(mb *db.Mailbox, _ *db.User) any { return mb.MPIID }},
+	"mailbox.created_at": {kind: kindTime, get: func(mb *db.Mailbox, _ *db.User) any { return mb.CreatedAt }},
+	"mailbox.updated_at": {kind: kindTime, get: func(mb *db.Mailbox, _ *db.User) any { return mb.UpdatedAt }},
+
+	"user.id":            {kind: kindInt, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.ID }},
+	"user.mailbox_id":    {kind: kindInt, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.MailboxID }},
+	"user.name":          {kind: kindString, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.UserName }},
+	"user.user_name":     {kind: kindString, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.UserName }},
+	"user.email":         {kind: kindString, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.EmailAddress }},
+	"user.email_address": {kind: kindString, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.EmailAddress }},
+	"user.created_at":    {kind: kindTime, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.CreatedAt }},
+	"user.updated_at":    {kind: kindTime, user: true, get: func(_ *db.Mailbox, u *db.User) any { return u.UpdatedAt }},
+}
+
+// literal converts the literal token t to a value of kind k.
+func literal(k kind, name string, t token) (any, error) {
+	switch {
+	case k == kindInt && t.kind == tokNumber:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("filter: %s: %w", name, err)
+		}
+		return n, nil
+	case k == kindString && t.kind == tokString:
+		return t.text, nil
+	case k == kindTime && t.kind == tokString:
+		ts, err := db.ParseTimestamp(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("filter: %s: %w", name, err)
+		}
+		return ts, nil
+	}
+	want := map[kind]string{kindInt: "an integer", kindString: "a string", kindTime: "a timestamp string"}[k]
+	return nil, fmt.Errorf("filter: %s compares with %s, not %s at offset %d", name, want, t, t.pos)
+}
+
+// order compares a and b, values of the same kind, as -1, 0 or +1.
+func order(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		return compareInts(a, b.(int))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparison builds the test for name op value.
+func comparison(f field, name, op string, value any) (func(v any) bool, error) {
+	switch op {
+	case "==":
+		return func(v any) bool { return order(v, value) == 0 }, nil
+	case "!=":
+		return func(v any) bool { return order(v, value) != 0 }, nil
+	case "<":
+		return func(v any) bool { return order(v, value) < 0 }, nil
+	case "<=":
+		return func(v any) bool { return order(v, value) <= 0 }, nil
+	case ">":
+		return func(v any) bool { return order(v, value) > 0 }, nil
+	case ">=":
+		return func(v any) bool { return order(v, value) >= 0 }, nil
+	}
+
+	if f.kind != kindString {
+		return nil, fmt.Errorf("filter: %s only applies to strings, not %s", op, name)
+	}
+	s := value.(string)
+	switch op {
+	case "contains":
+		return func(v any) bool { return strings.Contains(v.(string), s) }, nil
+	case "startsWith":
+		return func(v any) bool { return strings.HasPrefix(v.(string), s) }, nil
+	case "endsWith":
+		return func(v any) bool { return strings.HasSuffix(v.(string), s) }, nil
+	case "matches":
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("filter: %s matches: %w", name, err)
+		}
+		return func(v any) bool { return re.MatchString(v.(string)) }, nil
+	}
+	return nil, fmt.Errorf("filter: unknown operator %q", op)
+}
@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+var (
+	mailbox = db.Mailbox{ID: 7, MPIID: "mpi-7", CreatedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	user    = db.User{ID: 101, MailboxID: 7, UserName: "alice", EmailAddress: "alice@example.com", CreatedAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+)
+
+func TestFilter_User(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`mailbox.created_at > "2024-01-01" && user.email endsWith "@example.com"`, true},
+		{`mailbox.created_at > "2024-06-01"`, false},
+		{`user.created_at >= "2024-06-01T12:00:00Z"`, true},
+		{`mailbox.id == 7`, true},
+		{`mailbox.id != 7`, false},
+		{`user.id in [100, 101, 102]`, true},
+		{`user.name in ["bob", "carol"]`, false},
+		{`user.name startsWith "al" && user.email contains "@"`, true},
+		{`user.email matches "^[a-z]+@example\\.(com|org)$"`, true},
+		{`!(user.id < 100) || mailbox.mpi_id == "x"`, true},
+		{`mailbox.mpi_id == "x" || user.user_name == "bob"`, false},
+		{`user.email_address endsWith ".org"`, false},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Error compiling %s: %v", tt.expr, err)
+			continue
+		}
+		if got := f.User(mailbox, user); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestFilter_Mailbox(t *testing.T) {
+	tests := []struct {
+		expr     string
+		want     bool
+		usesUser bool
+	}{
+		{`mailbox.id == 7`, true, false},
+		{`mailbox.id == 8`, false, false},
+		// The user decides, so the mailbox must be read.
+		{`mailbox.id == 7 && user.id == 1`, true, true},
+		{`mailbox.id == 8 && user.id == 101`, false, true},
+		{`mailbox.id == 8 || user.id == 101`, true, true},
+		{`!(mailbox.id == 7 || user.id == 101)`, false, true},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Error compiling %s: %v", tt.expr, err)
+			continue
+		}
+		if got := f.Mailbox(mailbox); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.expr, tt.want, got)
+		}
+		if f.UsesUser() != tt.usesUser {
+			t.Errorf("%s: expected UsesUser %v", tt.expr, tt.usesUser)
+		}
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "expected a field"},
+		{`user.phone == "1"`, `unknown field "user.phone"`},
+		{`user.id == "101"`, "compares with an integer"},
+		{`user.created_at > "yesterday"`, "unrecognized timestamp"},
+		{`user.id endsWith 1`, "only applies to strings"},
+		{`user.email matches "("`, "matches"},
+		{`user.id == 1 &&`, "expected a field"},
+		{`(user.id == 1`, `expected ")"`},
+		{`user.id == 1 user.id == 2`, "unexpected"},
+		{`user.name == "bob`, "unterminated string"},
+		{`user.id in [1, 2`, `expected "]"`},
+		{`user.id = 1`, "unexpected"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.expr, tt.want, err)
+		}
+	}
+}
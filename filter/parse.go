@@ -0,0 +1,249 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// symbols are the operators and punctuation, longest first so that <= is
+// not read as <.
+var symbols = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// wordOps are the operators spelled as words.
+var wordOps = map[string]bool{"contains": true, "startsWith": true, "endsWith": true, "matches": true, "in": true}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("filter: unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("filter: string at offset %d: %w", i, err)
+			}
+			toks = append(toks, token{kind: tokString, text: s, pos: i})
+			i = end + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(src) && src[end] >= '0' && src[end] <= '9' {
+				end++
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[i:end], pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(src) && (src[end] == '_' || src[end] == '.' || unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end]))) {
+				end++
+			}
+			word := src[i:end]
+			kind := tokIdent
+			if wordOps[word] {
+				kind = tokOp
+			}
+			toks = append(toks, token{kind: kind, text: word, pos: i})
+			i = end
+		default:
+			matched := false
+			for _, sym := range symbols {
+				if strings.HasPrefix(src[i:], sym) {
+					toks = append(toks, token{kind: tokOp, text: sym, pos: i})
+					i += len(sym)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("filter: unexpected %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// parser is a recursive descent parser over:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | compare
+//	compare = field op literal | field "in" "[" literal { "," literal } "]"
+type parser struct {
+	toks     []token
+	i        int
+	usesUser bool
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the operator op if it is next.
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("filter: expected %q, found %s at offset %d", op, t, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("!") {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	if p.accept("(") {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	return p.compare()
+}
+
+func (p *parser) compare() (node, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("filter: expected a field, found %s at offset %d", t, t.pos)
+	}
+	f, ok := fields[t.text]
+	if !ok {
+		return nil, fmt.Errorf("filter: unknown field %q at offset %d", t.text, t.pos)
+	}
+	p.usesUser = p.usesUser || f.user
+	name := t.text
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("filter: expected an operator after %s, found %s at offset %d", name, op, op.pos)
+	}
+	if op.text == "in" {
+		return p.in(f, name)
+	}
+
+	value, err := literal(f.kind, name, p.next())
+	if err != nil {
+		return nil, err
+	}
+	test, err := comparison(f, name, op.text, value)
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{field: f, test: test}, nil
+}
+
+// in parses the list after field in, and tests for any of its values.
+func (p *parser) in(f field, name string) (node, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	var values []any
+	for {
+		v, err := literal(f.kind, name, p.next())
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	test := func(v any) bool {
+		for _, want := range values {
+			if order(v, want) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return compareNode{field: f, test: test}, nil
+}
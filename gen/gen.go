@@ -0,0 +1,151 @@
+// Package gen generates synthetic mailboxes and users compatible with the
+// db package, for seeding local databases and building test fixtures.
+// Generation is deterministic for a given Config, including its Seed.
+package gen
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Range is an inclusive integer range values are drawn uniformly from.
+type Range struct {
+	Min, Max int
+}
+
+// Domain is an email domain and its relative weight among all domains.
+type Domain struct {
+	Name   string
+	Weight int
+}
+
+type Config struct {
+	Mailboxes       int
+	UsersPerMailbox Range
+	Domains         []Domain
+	// Mailboxes are created uniformly between Start and End; each user is
+	// created after its mailbox and no later than End.
+	Start, End time.Time
+	// FirstMailboxID and FirstUserID let fixtures avoid colliding with rows
+	// already present in a database. Both default to 1.
+	FirstMailboxID int
+	FirstUserID    int
+	Seed           int64
+}
+
+// DefaultConfig returns a small configuration resembling the sample data.
+func DefaultConfig() Config {
+	return Config{
+		Mailboxes:       10,
+		UsersPerMailbox: Range{Min: 1, Max: 5},
+		Domains:         []Domain{{Name: "example.com", Weight: 1}},
+		Start:           time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		End:             time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+		Seed:            1,
+	}
+}
+
+type Generator struct {
+	cfg         Config
+	rng         *rand.Rand
+	totalWeight int
+	mailboxID   int
+	userID      int
+}
+
+func New(cfg Config) (*Generator, error) {
+	if cfg.UsersPerMailbox.Min < 0 || cfg.UsersPerMailbox.Max < cfg.UsersPerMailbox.Min {
+		return nil, fmt.Errorf("invalid users per mailbox range %d-%d", cfg.UsersPerMailbox.Min, cfg.UsersPerMailbox.Max)
+	}
+	if !cfg.End.After(cfg.Start) {
+		return nil, fmt.Errorf("end %s is not after start %s", cfg.End, cfg.Start)
+	}
+
+	g := &Generator{
+		cfg:       cfg,
+		rng:       rand.New(rand.NewSource(cfg.Seed)),
+		mailboxID: max(cfg.FirstMailboxID, 1),
+		userID:    max(cfg.FirstUserID, 1),
+	}
+	for _, d := range cfg.Domains {
+		if d.Weight < 0 {
+			return nil, fmt.Errorf("negative weight for domain %s", d.Name)
+		}
+		g.totalWeight += d.Weight
+	}
+	if g.totalWeight == 0 {
+		return nil, fmt.Errorf("no email domains with positive weight")
+	}
+
+	return g, nil
+}
+
+// Mailbox returns the next mailbox.
+func (g *Generator) Mailbox() db.Mailbox {
+	mb := db.Mailbox{
+		ID:        g.mailboxID,
+		MPIID:     fmt.Sprintf("mpi%06d", g.mailboxID),
+		Token:     fmt.Sprintf("token%016x", g.rng.Uint64()),
+		CreatedAt: g.timestamp(g.cfg.Start),
+	}
+	g.mailboxID++
+	return mb
+}
+
+// Users returns a randomly sized set of users belonging to mb.
+func (g *Generator) Users(mb db.Mailbox) []db.User {
+	start := mb.CreatedAt
+	if start.IsZero() {
+		start = g.cfg.Start
+	}
+
+	n := g.cfg.UsersPerMailbox.Min + g.rng.Intn(g.cfg.UsersPerMailbox.Max-g.cfg.UsersPerMailbox.Min+1)
+	users := make([]db.User, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("user%d", g.userID)
+		users = append(users, db.User{
+			ID:           g.userID,
+			MailboxID:    mb.ID,
+			UserName:     name,
+			EmailAddress: name + "@" + g.domain(),
+			CreatedAt:    g.timestamp(start),
+		})
+		g.userID++
+	}
+	return users
+}
+
+// Generate returns cfg.Mailboxes mailboxes and all of their users.
+func (g *Generator) Generate() ([]db.Mailbox, []db.User) {
+	mailboxes := make([]db.Mailbox, 0, g.cfg.Mailboxes)
+	var users []db.User
+	for i := 0; i < g.cfg.Mailboxes; i++ {
+		mb := g.Mailbox()
+		mailboxes = append(mailboxes, mb)
+		users = append(users, g.Users(mb)...)
+	}
+	return mailboxes, users
+}
+
+// timestamp returns a second-precision time uniformly between from and End.
+func (g *Generator) timestamp(from time.Time) time.Time {
+	span := int64(g.cfg.End.Sub(from) / time.Second)
+	if span <= 0 {
+		return from.Truncate(time.Second)
+	}
+	return from.Add(time.Duration(g.rng.Int63n(span)) * time.Second).Truncate(time.Second)
+}
+
+func (g *Generator) domain() string {
+	n := g.rng.Intn(g.totalWeight)
+	for _, d := range g.cfg.Domains {
+		if n < d.Weight {
+			return d.Name
+		}
+		n -= d.Weight
+	}
+	return g.cfg.Domains[len(g.cfg.Domains)-1].Name
+}
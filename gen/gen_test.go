@@ -0,0 +1,131 @@
+package gen
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+func TestGenerator_Generate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Mailboxes = 20
+	cfg.UsersPerMailbox = Range{Min: 2, Max: 4}
+	cfg.Domains = []Domain{{Name: "a.example", Weight: 1}, {Name: "b.example", Weight: 3}}
+	cfg.FirstMailboxID = 100
+
+	g, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Error creating generator: %v", err)
+	}
+	mailboxes, users := g.Generate()
+
+	if len(mailboxes) != cfg.Mailboxes {
+		t.Fatalf("Expected %d mailboxes, got %d", cfg.Mailboxes, len(mailboxes))
+	}
+	if mailboxes[0].ID != 100 {
+		t.Errorf("Expected first mailbox ID 100, got %d", mailboxes[0].ID)
+	}
+
+	created := map[int]time.Time{}
+	perMailbox := map[int]int{}
+	for _, mb := range mailboxes {
+		created[mb.ID] = mb.CreatedAt
+	}
+	for _, user := range users {
+		perMailbox[user.MailboxID]++
+
+		if !strings.HasSuffix(user.EmailAddress, "@a.example") && !strings.HasSuffix(user.EmailAddress, "@b.example") {
+			t.Errorf("Unexpected email domain in %s", user.EmailAddress)
+		}
+		if user.CreatedAt.Before(created[user.MailboxID]) {
+			t.Errorf("User %d created %s before its mailbox %s", user.ID, user.CreatedAt, created[user.MailboxID])
+		}
+		if user.CreatedAt.Before(cfg.Start) || user.CreatedAt.After(cfg.End) {
+			t.Errorf("User %d created %s outside %s-%s", user.ID, user.CreatedAt, cfg.Start, cfg.End)
+		}
+	}
+	for _, mb := range mailboxes {
+		if n := perMailbox[mb.ID]; n < 2 || n > 4 {
+			t.Errorf("Expected 2-4 users for mailbox %d, got %d", mb.ID, n)
+		}
+	}
+}
+
+func TestGenerator_Deterministic(t *testing.T) {
+	generate := func() ([]db.Mailbox, []db.User) {
+		g, err := New(DefaultConfig())
+		if err != nil {
+			t.Fatalf("Error creating generator: %v", err)
+		}
+		return g.Generate()
+	}
+
+	mailboxes1, users1 := generate()
+	mailboxes2, users2 := generate()
+	if !reflect.DeepEqual(mailboxes1, mailboxes2) || !reflect.DeepEqual(users1, users2) {
+		t.Errorf("Expected identical output for the same seed")
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Domains = nil
+	if _, err := New(cfg); err == nil {
+		t.Errorf("Expected an error without email domains")
+	}
+
+	cfg = DefaultConfig()
+	cfg.UsersPerMailbox = Range{Min: 5, Max: 1}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("Expected an error for an inverted users range")
+	}
+}
+
+func TestStore_ReleasesUsers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Mailboxes = 3
+
+	store, err := NewStore(cfg)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+
+	for run := 0; run < 2; run++ {
+		mailboxChan, err := store.AllMailboxes(context.Background())
+		if err != nil {
+			t.Fatalf("Error calling AllMailboxes: %v", err)
+		}
+
+		mailboxes := 0
+		for mb := range mailboxChan {
+			userChan, err := store.UsersForMailbox(context.Background(), mb.ID)
+			if err != nil {
+				t.Fatalf("Error calling UsersForMailbox: %v", err)
+			}
+
+			users := 0
+			for user := range userChan {
+				if user.MailboxID != mb.ID {
+					t.Errorf("User %d belongs to mailbox %d, not %d", user.ID, user.MailboxID, mb.ID)
+				}
+				users++
+			}
+			if users == 0 {
+				t.Errorf("Expected users for mailbox %d", mb.ID)
+			}
+			mailboxes++
+		}
+
+		if mailboxes != cfg.Mailboxes {
+			t.Errorf("Expected %d mailboxes, got %d", cfg.Mailboxes, mailboxes)
+		}
+	}
+
+	if len(store.pending) != 0 {
+		t.Errorf("Expected no pending users, got %d mailboxes", len(store.pending))
+	}
+}
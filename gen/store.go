@@ -0,0 +1,74 @@
+package gen
+
+import (
+	"context"
+	"sync"
+
+	"mailboxes/db"
+)
+
+// Store is an in-memory db.Store serving synthetic data. Every call to
+// AllMailboxes streams a fresh batch of Config.Mailboxes mailboxes; users are
+// generated alongside their mailbox and released once UsersForMailbox has
+// been called for it, so memory stays bounded across repeated runs.
+type Store struct {
+	mailboxes int
+
+	mu      sync.Mutex
+	g       *Generator
+	pending map[int][]db.User
+}
+
+func NewStore(cfg Config) (*Store, error) {
+	g, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{mailboxes: cfg.Mailboxes, g: g, pending: map[int][]db.User{}}, nil
+}
+
+func (s *Store) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	mailboxChannel := make(chan db.Mailbox)
+
+	go func() {
+		defer close(mailboxChannel)
+
+		for i := 0; i < s.mailboxes; i++ {
+			s.mu.Lock()
+			mb := s.g.Mailbox()
+			s.pending[mb.ID] = s.g.Users(mb)
+			s.mu.Unlock()
+
+			select {
+			case mailboxChannel <- mb:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return mailboxChannel, nil
+}
+
+func (s *Store) UsersForMailbox(ctx context.Context, mailboxID int) (<-chan db.User, error) {
+	s.mu.Lock()
+	users := s.pending[mailboxID]
+	delete(s.pending, mailboxID)
+	s.mu.Unlock()
+
+	userChannel := make(chan db.User)
+
+	go func() {
+		defer close(userChannel)
+
+		for _, user := range users {
+			select {
+			case userChannel <- user:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return userChannel, nil
+}
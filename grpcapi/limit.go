@@ -0,0 +1,67 @@
+package grpcapi
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	"mailboxes/api"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryLimit rejects unary calls over their client's limits in l, the same
+// Limiter the REST API uses, with RESOURCE_EXHAUSTED and a retry-after
+// header. Clients are identified by their x-api-key metadata as REST callers
+// are by X-API-Key, falling back to their address.
+func UnaryLimit(l *api.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := allow(ctx, l, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) }); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamLimit limits streaming calls as UnaryLimit does unary ones. A stream
+// counts once, when it is opened.
+func StreamLimit(l *api.Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := allow(ss.Context(), l, ss.SetHeader); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// allow records the call ctx belongs to with l and returns the error to fail
+// it with if it is over its limits, after setting the retry-after header
+// with setHeader.
+func allow(ctx context.Context, l *api.Limiter, setHeader func(metadata.MD) error) error {
+	client := l.Client(first(metadata.ValueFromIncomingContext(ctx, "x-api-key")), peerHost(ctx))
+	ok, wait, reason := l.Allow(client)
+	if ok {
+		return nil
+	}
+	setHeader(metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+	return status.Error(codes.ResourceExhausted, reason)
+}
+
+// peerHost is the host part of the caller's address, or "" if unknown.
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,67 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+
+	"mailboxes/api"
+	"mailboxes/db"
+	"mailboxes/grpcapi/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimit(t *testing.T) {
+	store := db.NewMemStore()
+	store.SeedMailboxes(db.Mailbox{ID: 1, MPIID: "mpi123"})
+	limiter := api.NewLimiter(api.Limits{DailyQuota: 1}, map[string]api.Limits{"key": {DailyQuota: 2}})
+	client := testClient(t, store, nil,
+		grpc.ChainUnaryInterceptor(UnaryLimit(limiter)),
+		grpc.ChainStreamInterceptor(StreamLimit(limiter)))
+
+	tests := []struct {
+		name     string
+		apiKey   string
+		stream   bool
+		expected codes.Code
+	}{
+		{name: "First call", expected: codes.OK},
+		{name: "Over the quota", expected: codes.ResourceExhausted},
+		{name: "Stream over the quota", stream: true, expected: codes.ResourceExhausted},
+		{name: "Unknown key shares the address quota", apiKey: "made-up", expected: codes.ResourceExhausted},
+		{name: "Configured key", apiKey: "key", expected: codes.OK},
+		{name: "Configured key stream", apiKey: "key", stream: true, expected: codes.OK},
+		{name: "Configured key over its quota", apiKey: "key", expected: codes.ResourceExhausted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.apiKey != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", tt.apiKey)
+			}
+
+			var header metadata.MD
+			var err error
+			if tt.stream {
+				var stream grpc.ServerStreamingClient[pb.Mailbox]
+				if stream, err = client.ListMailboxes(ctx, &pb.ListMailboxesRequest{}); err == nil {
+					_, err = stream.Recv()
+					header, _ = stream.Header()
+				}
+			} else {
+				_, err = client.GetMailbox(ctx, &pb.GetMailboxRequest{Id: "1"}, grpc.Header(&header))
+			}
+
+			if code := status.Code(err); code != tt.expected {
+				t.Fatalf("Expected %s, got %v", tt.expected, err)
+			}
+			if tt.expected == codes.ResourceExhausted && len(header.Get("retry-after")) == 0 {
+				t.Errorf("Expected a retry-after header, got %v", header)
+			}
+		})
+	}
+}
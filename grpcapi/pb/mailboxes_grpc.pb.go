@@ -0,0 +1,519 @@
+// The Mailboxes service streams mailboxes and users to high-throughput
+// consumers and exposes the same CRUD operations as the REST API. IDs are
+// strings, obfuscated with api.id_secret like the REST API's, and mailbox
+// tokens are never returned.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.1
+// source: mailboxes.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Mailboxes_ListMailboxes_FullMethodName   = "/mailboxes.v1.Mailboxes/ListMailboxes"
+	Mailboxes_UsersForMailbox_FullMethodName = "/mailboxes.v1.Mailboxes/UsersForMailbox"
+	Mailboxes_GetMailbox_FullMethodName      = "/mailboxes.v1.Mailboxes/GetMailbox"
+	Mailboxes_CreateMailbox_FullMethodName   = "/mailboxes.v1.Mailboxes/CreateMailbox"
+	Mailboxes_UpdateMailbox_FullMethodName   = "/mailboxes.v1.Mailboxes/UpdateMailbox"
+	Mailboxes_DeleteMailbox_FullMethodName   = "/mailboxes.v1.Mailboxes/DeleteMailbox"
+	Mailboxes_GetUser_FullMethodName         = "/mailboxes.v1.Mailboxes/GetUser"
+	Mailboxes_CreateUser_FullMethodName      = "/mailboxes.v1.Mailboxes/CreateUser"
+	Mailboxes_UpdateUser_FullMethodName      = "/mailboxes.v1.Mailboxes/UpdateUser"
+	Mailboxes_DeleteUser_FullMethodName      = "/mailboxes.v1.Mailboxes/DeleteUser"
+)
+
+// MailboxesClient is the client API for Mailboxes service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MailboxesClient interface {
+	// ListMailboxes streams every mailbox in ID order, starting after the
+	// given ID so a consumer can resume an interrupted stream.
+	ListMailboxes(ctx context.Context, in *ListMailboxesRequest, opts ...grpc.CallOption) (Mailboxes_ListMailboxesClient, error)
+	// UsersForMailbox streams a mailbox's users in ID order.
+	UsersForMailbox(ctx context.Context, in *UsersForMailboxRequest, opts ...grpc.CallOption) (Mailboxes_UsersForMailboxClient, error)
+	GetMailbox(ctx context.Context, in *GetMailboxRequest, opts ...grpc.CallOption) (*Mailbox, error)
+	CreateMailbox(ctx context.Context, in *CreateMailboxRequest, opts ...grpc.CallOption) (*Mailbox, error)
+	UpdateMailbox(ctx context.Context, in *UpdateMailboxRequest, opts ...grpc.CallOption) (*Mailbox, error)
+	DeleteMailbox(ctx context.Context, in *DeleteMailboxRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+	CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error)
+	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*User, error)
+	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type mailboxesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMailboxesClient(cc grpc.ClientConnInterface) MailboxesClient {
+	return &mailboxesClient{cc}
+}
+
+func (c *mailboxesClient) ListMailboxes(ctx context.Context, in *ListMailboxesRequest, opts ...grpc.CallOption) (Mailboxes_ListMailboxesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Mailboxes_ServiceDesc.Streams[0], Mailboxes_ListMailboxes_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &mailboxesListMailboxesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Mailboxes_ListMailboxesClient interface {
+	Recv() (*Mailbox, error)
+	grpc.ClientStream
+}
+
+type mailboxesListMailboxesClient struct {
+	grpc.ClientStream
+}
+
+func (x *mailboxesListMailboxesClient) Recv() (*Mailbox, error) {
+	m := new(Mailbox)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *mailboxesClient) UsersForMailbox(ctx context.Context, in *UsersForMailboxRequest, opts ...grpc.CallOption) (Mailboxes_UsersForMailboxClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Mailboxes_ServiceDesc.Streams[1], Mailboxes_UsersForMailbox_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &mailboxesUsersForMailboxClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Mailboxes_UsersForMailboxClient interface {
+	Recv() (*User, error)
+	grpc.ClientStream
+}
+
+type mailboxesUsersForMailboxClient struct {
+	grpc.ClientStream
+}
+
+func (x *mailboxesUsersForMailboxClient) Recv() (*User, error) {
+	m := new(User)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *mailboxesClient) GetMailbox(ctx context.Context, in *GetMailboxRequest, opts ...grpc.CallOption) (*Mailbox, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mailbox)
+	err := c.cc.Invoke(ctx, Mailboxes_GetMailbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) CreateMailbox(ctx context.Context, in *CreateMailboxRequest, opts ...grpc.CallOption) (*Mailbox, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mailbox)
+	err := c.cc.Invoke(ctx, Mailboxes_CreateMailbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) UpdateMailbox(ctx context.Context, in *UpdateMailboxRequest, opts ...grpc.CallOption) (*Mailbox, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mailbox)
+	err := c.cc.Invoke(ctx, Mailboxes_UpdateMailbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) DeleteMailbox(ctx context.Context, in *DeleteMailboxRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Mailboxes_DeleteMailbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Mailboxes_GetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Mailboxes_CreateUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Mailboxes_UpdateUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailboxesClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Mailboxes_DeleteUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MailboxesServer is the server API for Mailboxes service.
+// All implementations must embed UnimplementedMailboxesServer
+// for forward compatibility
+type MailboxesServer interface {
+	// ListMailboxes streams every mailbox in ID order, starting after the
+	// given ID so a consumer can resume an interrupted stream.
+	ListMailboxes(*ListMailboxesRequest, Mailboxes_ListMailboxesServer) error
+	// UsersForMailbox streams a mailbox's users in ID order.
+	UsersForMailbox(*UsersForMailboxRequest, Mailboxes_UsersForMailboxServer) error
+	GetMailbox(context.Context, *GetMailboxRequest) (*Mailbox, error)
+	CreateMailbox(context.Context, *CreateMailboxRequest) (*Mailbox, error)
+	UpdateMailbox(context.Context, *UpdateMailboxRequest) (*Mailbox, error)
+	DeleteMailbox(context.Context, *DeleteMailboxRequest) (*emptypb.Empty, error)
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	CreateUser(context.Context, *CreateUserRequest) (*User, error)
+	UpdateUser(context.Context, *UpdateUserRequest) (*User, error)
+	DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error)
+	mustEmbedUnimplementedMailboxesServer()
+}
+
+// UnimplementedMailboxesServer must be embedded to have forward compatible implementations.
+type UnimplementedMailboxesServer struct {
+}
+
+func (UnimplementedMailboxesServer) ListMailboxes(*ListMailboxesRequest, Mailboxes_ListMailboxesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListMailboxes not implemented")
+}
+func (UnimplementedMailboxesServer) UsersForMailbox(*UsersForMailboxRequest, Mailboxes_UsersForMailboxServer) error {
+	return status.Errorf(codes.Unimplemented, "method UsersForMailbox not implemented")
+}
+func (UnimplementedMailboxesServer) GetMailbox(context.Context, *GetMailboxRequest) (*Mailbox, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMailbox not implemented")
+}
+func (UnimplementedMailboxesServer) CreateMailbox(context.Context, *CreateMailboxRequest) (*Mailbox, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateMailbox not implemented")
+}
+func (UnimplementedMailboxesServer) UpdateMailbox(context.Context, *UpdateMailboxRequest) (*Mailbox, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateMailbox not implemented")
+}
+func (UnimplementedMailboxesServer) DeleteMailbox(context.Context, *DeleteMailboxRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMailbox not implemented")
+}
+func (UnimplementedMailboxesServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedMailboxesServer) CreateUser(context.Context, *CreateUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
+}
+func (UnimplementedMailboxesServer) UpdateUser(context.Context, *UpdateUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateUser not implemented")
+}
+func (UnimplementedMailboxesServer) DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (UnimplementedMailboxesServer) mustEmbedUnimplementedMailboxesServer() {}
+
+// UnsafeMailboxesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MailboxesServer will
+// result in compilation errors.
+type UnsafeMailboxesServer interface {
+	mustEmbedUnimplementedMailboxesServer()
+}
+
+func RegisterMailboxesServer(s grpc.ServiceRegistrar, srv MailboxesServer) {
+	s.RegisterService(&Mailboxes_ServiceDesc, srv)
+}
+
+func _Mailboxes_ListMailboxes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListMailboxesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MailboxesServer).ListMailboxes(m, &mailboxesListMailboxesServer{ServerStream: stream})
+}
+
+type Mailboxes_ListMailboxesServer interface {
+	Send(*Mailbox) error
+	grpc.ServerStream
+}
+
+type mailboxesListMailboxesServer struct {
+	grpc.ServerStream
+}
+
+func (x *mailboxesListMailboxesServer) Send(m *Mailbox) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Mailboxes_UsersForMailbox_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UsersForMailboxRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MailboxesServer).UsersForMailbox(m, &mailboxesUsersForMailboxServer{ServerStream: stream})
+}
+
+type Mailboxes_UsersForMailboxServer interface {
+	Send(*User) error
+	grpc.ServerStream
+}
+
+type mailboxesUsersForMailboxServer struct {
+	grpc.ServerStream
+}
+
+func (x *mailboxesUsersForMailboxServer) Send(m *User) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Mailboxes_GetMailbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMailboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).GetMailbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_GetMailbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).GetMailbox(ctx, req.(*GetMailboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_CreateMailbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateMailboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).CreateMailbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_CreateMailbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).CreateMailbox(ctx, req.(*CreateMailboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_UpdateMailbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMailboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).UpdateMailbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_UpdateMailbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).UpdateMailbox(ctx, req.(*UpdateMailboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_DeleteMailbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMailboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).DeleteMailbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_DeleteMailbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).DeleteMailbox(ctx, req.(*DeleteMailboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_GetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_CreateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).CreateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_CreateUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).CreateUser(ctx, req.(*CreateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).UpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_UpdateUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).UpdateUser(ctx, req.(*UpdateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailboxes_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailboxesServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Mailboxes_DeleteUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailboxesServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Mailboxes_ServiceDesc is the grpc.ServiceDesc for Mailboxes service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Mailboxes_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mailboxes.v1.Mailboxes",
+	HandlerType: (*MailboxesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetMailbox",
+			Handler:    _Mailboxes_GetMailbox_Handler,
+		},
+		{
+			MethodName: "CreateMailbox",
+			Handler:    _Mailboxes_CreateMailbox_Handler,
+		},
+		{
+			MethodName: "UpdateMailbox",
+			Handler:    _Mailboxes_UpdateMailbox_Handler,
+		},
+		{
+			MethodName: "DeleteMailbox",
+			Handler:    _Mailboxes_DeleteMailbox_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _Mailboxes_GetUser_Handler,
+		},
+		{
+			MethodName: "CreateUser",
+			Handler:    _Mailboxes_CreateUser_Handler,
+		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    _Mailboxes_UpdateUser_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _Mailboxes_DeleteUser_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListMailboxes",
+			Handler:       _Mailboxes_ListMailboxes_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UsersForMailbox",
+			Handler:       _Mailboxes_UsersForMailbox_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mailboxes.proto",
+}
@@ -0,0 +1,75 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"mailboxes/api"
+	"mailboxes/scope"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// defaultActor is the actor of calls that do not name one.
+const defaultActor = "grpc"
+
+// grpcSource is the provenance recorded for users created through gRPC.
+const grpcSource = "grpc"
+
+// scoped returns ctx scoped to the tenant the call's x-api-key metadata is
+// bound to, carrying the actor in its x-actor metadata, or "grpc", and the
+// source "grpc". x-tenant metadata must name the key's own tenant.
+func scoped(ctx context.Context, keys *api.Keys) (context.Context, error) {
+	tenant, err := keys.Tenant(first(metadata.ValueFromIncomingContext(ctx, "x-api-key")), first(metadata.ValueFromIncomingContext(ctx, "x-tenant")))
+	if errors.Is(err, api.ErrWrongTenant) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	actor := first(metadata.ValueFromIncomingContext(ctx, "x-actor"))
+	if actor == "" {
+		actor = defaultActor
+	}
+	ctx = scope.WithActor(scope.WithTenant(ctx, tenant), actor)
+	return scope.WithSource(ctx, grpcSource), nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// UnaryScope scopes each unary call as the REST API's Scope does requests,
+// failing calls keys rejects with UNAUTHENTICATED or PERMISSION_DENIED.
+func UnaryScope(keys *api.Keys) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := scoped(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamScope scopes each streaming call as UnaryScope does unary ones.
+func StreamScope(keys *api.Keys) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := scoped(ss.Context(), keys)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &scopedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type scopedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *scopedStream) Context() context.Context { return s.ctx }
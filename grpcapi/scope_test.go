@@ -0,0 +1,59 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+
+	"mailboxes/api"
+	"mailboxes/db"
+	"mailboxes/grpcapi/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestScope(t *testing.T) {
+	store := db.NewMemStore()
+	store.SeedMailboxes(db.Mailbox{ID: 1, MPIID: "mpi123"})
+	keys := api.NewKeys([]api.Key{{Key: "acme-key", Tenant: "acme"}})
+	client := testClient(t, store, nil,
+		grpc.ChainUnaryInterceptor(UnaryScope(keys)),
+		grpc.ChainStreamInterceptor(StreamScope(keys)))
+
+	tests := []struct {
+		name     string
+		md       []string
+		stream   bool
+		expected codes.Code
+	}{
+		{name: "Key bound to a tenant", md: []string{"x-api-key", "acme-key"}, expected: codes.OK},
+		{name: "Key naming its own tenant", md: []string{"x-api-key", "acme-key", "x-tenant", "acme"}, expected: codes.OK},
+		{name: "No key", expected: codes.Unauthenticated},
+		{name: "No key naming a tenant", md: []string{"x-tenant", "acme"}, expected: codes.Unauthenticated},
+		{name: "Unknown key", md: []string{"x-api-key", "made-up"}, expected: codes.Unauthenticated},
+		{name: "Key naming another tenant", md: []string{"x-api-key", "acme-key", "x-tenant", "globex"}, expected: codes.PermissionDenied},
+		{name: "Stream without a key", stream: true, expected: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), tt.md...)
+
+			var err error
+			if tt.stream {
+				var stream grpc.ServerStreamingClient[pb.Mailbox]
+				if stream, err = client.ListMailboxes(ctx, &pb.ListMailboxesRequest{}); err == nil {
+					_, err = stream.Recv()
+				}
+			} else {
+				_, err = client.GetMailbox(ctx, &pb.GetMailboxRequest{Id: "1"})
+			}
+
+			if code := status.Code(err); code != tt.expected {
+				t.Fatalf("Expected %s, got %v", tt.expected, err)
+			}
+		})
+	}
+}
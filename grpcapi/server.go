@@ -0,0 +1,379 @@
+// Package grpcapi serves mailboxes and users over gRPC, as defined in
+// pb/mailboxes.proto. Mailboxes and users are streamed in pages, so
+// consumers can read every row without holding the whole table in memory
+// on either side.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/grpcapi/pb"
+	"mailboxes/publicid"
+	"mailboxes/scope"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errOutOfOrder is logged when a store that does not page yields a row whose
+// ID is not above the last one sent, which would break resuming with After.
+var errOutOfOrder = errors.New("store yielded rows out of ID order")
+
+// streamPageSize is how many rows a stream reads from a paging store at a
+// time.
+const streamPageSize = 500
+
+// Server implements pb.MailboxesServer from a Store. IDs are encoded with
+// ids, as in the REST API. The CRUD RPCs need a store implementing
+// db.MailboxStore or db.UserStore and answer Unimplemented otherwise.
+type Server struct {
+	pb.UnimplementedMailboxesServer
+
+	store db.Store
+	ids   *publicid.Codec
+}
+
+func NewServer(store db.Store, ids *publicid.Codec) *Server {
+	return &Server{store: store, ids: ids}
+}
+
+func (s *Server) mailbox(mb db.Mailbox) *pb.Mailbox {
+	return &pb.Mailbox{
+		Id:        s.ids.Encode(mb.ID),
+		MpiId:     mb.MPIID,
+		CreatedAt: timestamp(mb.CreatedAt),
+		UpdatedAt: timestamp(mb.UpdatedAt),
+	}
+}
+
+func (s *Server) user(u db.User) *pb.User {
+	return &pb.User{
+		Id:           s.ids.Encode(u.ID),
+		MailboxId:    s.ids.Encode(u.MailboxID),
+		UserName:     u.UserName,
+		EmailAddress: u.EmailAddress,
+		CreatedAt:    timestamp(u.CreatedAt),
+		UpdatedAt:    timestamp(u.UpdatedAt),
+	}
+}
+
+// decode returns the ID encoded in publicID. The empty string is zero when
+// optional; an invalid ID is reported as the resource not being found, as
+// in the REST API.
+func (s *Server) decode(publicID, what string, optional bool) (int, error) {
+	if publicID == "" && optional {
+		return 0, nil
+	}
+	id, err := s.ids.Decode(publicID)
+	if err != nil {
+		return 0, status.Errorf(codes.NotFound, "%s not found", what)
+	}
+	return id, nil
+}
+
+func (s *Server) ListMailboxes(req *pb.ListMailboxesRequest, stream pb.Mailboxes_ListMailboxesServer) error {
+	afterID, err := s.decode(req.After, "mailbox", true)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+
+	if ps, ok := s.store.(db.PageStore); ok {
+		for {
+			page, err := ps.MailboxesAfter(ctx, afterID, streamPageSize)
+			if err != nil {
+				return internal("retrieving mailboxes", err)
+			}
+			for _, mb := range page {
+				if err := stream.Send(s.mailbox(mb)); err != nil {
+					return err
+				}
+				afterID = mb.ID
+			}
+			if len(page) < streamPageSize {
+				return nil
+			}
+		}
+	}
+
+	// Other stores yield rows in ID order, so they are forwarded as they
+	// arrive rather than collected and sorted.
+	lastID := afterID
+	for mb, err := range db.Mailboxes(ctx, s.store) {
+		if err != nil {
+			return internal("retrieving mailboxes", err)
+		}
+		if mb.ID <= afterID {
+			continue
+		}
+		if mb.ID <= lastID {
+			return internal("retrieving mailboxes", errOutOfOrder)
+		}
+		if err := stream.Send(s.mailbox(mb)); err != nil {
+			return err
+		}
+		lastID = mb.ID
+	}
+	return nil
+}
+
+func (s *Server) UsersForMailbox(req *pb.UsersForMailboxRequest, stream pb.Mailboxes_UsersForMailboxServer) error {
+	mailboxID, err := s.decode(req.MailboxId, "mailbox", false)
+	if err != nil {
+		return err
+	}
+	afterID, err := s.decode(req.After, "user", true)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+
+	if ps, ok := s.store.(db.PageStore); ok {
+		for {
+			page, err := ps.UsersForMailboxAfter(ctx, mailboxID, afterID, streamPageSize)
+			if err != nil {
+				return internal("retrieving users", err)
+			}
+			for _, u := range page {
+				if err := stream.Send(s.user(u)); err != nil {
+					return err
+				}
+				afterID = u.ID
+			}
+			if len(page) < streamPageSize {
+				return nil
+			}
+		}
+	}
+
+	lastID := afterID
+	for u, err := range db.Users(ctx, s.store, mailboxID) {
+		if err != nil {
+			return internal("retrieving users", err)
+		}
+		if u.ID <= afterID {
+			continue
+		}
+		if u.ID <= lastID {
+			return internal("retrieving users", errOutOfOrder)
+		}
+		if err := stream.Send(s.user(u)); err != nil {
+			return err
+		}
+		lastID = u.ID
+	}
+	return nil
+}
+
+func (s *Server) mailboxStore() (db.MailboxStore, error) {
+	ms, ok := s.store.(db.MailboxStore)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "mailbox CRUD is not supported by this store")
+	}
+	return ms, nil
+}
+
+func (s *Server) userStore() (db.UserStore, error) {
+	us, ok := s.store.(db.UserStore)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "user CRUD is not supported by this store")
+	}
+	return us, nil
+}
+
+func (s *Server) GetMailbox(ctx context.Context, req *pb.GetMailboxRequest) (*pb.Mailbox, error) {
+	ms, err := s.mailboxStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.decode(req.Id, "mailbox", false)
+	if err != nil {
+		return nil, err
+	}
+	mb, err := ms.GetMailboxByID(ctx, id)
+	if err != nil {
+		return nil, storeError("retrieving mailbox", err)
+	}
+	return s.mailbox(mb), nil
+}
+
+// CreateMailbox creates a mailbox, for the tenant the call is scoped to if
+// there is one.
+func (s *Server) CreateMailbox(ctx context.Context, req *pb.CreateMailboxRequest) (*pb.Mailbox, error) {
+	ms, err := s.mailboxStore()
+	if err != nil {
+		return nil, err
+	}
+	if req.MpiId == "" {
+		return nil, status.Error(codes.InvalidArgument, "mpi_id is required")
+	}
+	if _, ok := s.store.(db.QuotaStore); !ok && scope.Tenant(ctx) != "" {
+		return nil, status.Error(codes.Unimplemented, "tenants are not supported by this store")
+	}
+	mb, err := ms.CreateMailbox(ctx, db.Mailbox{MPIID: req.MpiId, Token: req.Token})
+	if err != nil {
+		return nil, storeError("creating mailbox", err)
+	}
+	return s.mailbox(mb), nil
+}
+
+func (s *Server) UpdateMailbox(ctx context.Context, req *pb.UpdateMailboxRequest) (*pb.Mailbox, error) {
+	ms, err := s.mailboxStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.decode(req.Id, "mailbox", false)
+	if err != nil {
+		return nil, err
+	}
+	// Only the fields set are written, so concurrent changes to the others
+	// are kept.
+	mb := db.Mailbox{ID: id}
+	var fields []string
+	if req.MpiId != nil {
+		mb.MPIID = *req.MpiId
+		fields = append(fields, db.FieldMPIID)
+	}
+	if req.Token != nil {
+		mb.Token = *req.Token
+		fields = append(fields, db.FieldToken)
+	}
+	if len(fields) > 0 {
+		if err := ms.UpdateMailbox(ctx, mb, fields...); err != nil {
+			return nil, storeError("updating mailbox", err)
+		}
+	}
+	mb, err = ms.GetMailboxByID(ctx, id)
+	if err != nil {
+		return nil, storeError("retrieving mailbox", err)
+	}
+	return s.mailbox(mb), nil
+}
+
+func (s *Server) DeleteMailbox(ctx context.Context, req *pb.DeleteMailboxRequest) (*emptypb.Empty, error) {
+	ms, err := s.mailboxStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.decode(req.Id, "mailbox", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := ms.DeleteMailbox(ctx, id); err != nil {
+		return nil, storeError("deleting mailbox", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	us, err := s.userStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.decode(req.Id, "user", false)
+	if err != nil {
+		return nil, err
+	}
+	u, err := us.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, storeError("retrieving user", err)
+	}
+	return s.user(u), nil
+}
+
+func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.User, error) {
+	us, err := s.userStore()
+	if err != nil {
+		return nil, err
+	}
+	mailboxID, err := s.decode(req.MailboxId, "mailbox", false)
+	if err != nil {
+		return nil, err
+	}
+	u, err := us.CreateUser(ctx, db.User{MailboxID: mailboxID, UserName: req.UserName, EmailAddress: req.EmailAddress})
+	if err != nil {
+		return nil, storeError("creating user", err)
+	}
+	return s.user(u), nil
+}
+
+func (s *Server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	us, err := s.userStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.decode(req.Id, "user", false)
+	if err != nil {
+		return nil, err
+	}
+	u := db.User{ID: id}
+	var fields []string
+	if req.UserName != nil {
+		u.UserName = *req.UserName
+		fields = append(fields, db.FieldUserName)
+	}
+	if req.EmailAddress != nil {
+		u.EmailAddress = *req.EmailAddress
+		fields = append(fields, db.FieldEmailAddress)
+	}
+	if len(fields) > 0 {
+		if err := us.UpdateUser(ctx, u, fields...); err != nil {
+			return nil, storeError("updating user", err)
+		}
+	}
+	u, err = us.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, storeError("retrieving user", err)
+	}
+	return s.user(u), nil
+}
+
+func (s *Server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*emptypb.Empty, error) {
+	us, err := s.userStore()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.decode(req.Id, "user", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.DeleteUser(ctx, id); err != nil {
+		return nil, storeError("deleting user", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// timestamp converts t, leaving zero times unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// storeError maps the store's not-found errors to NotFound, quota errors to
+// ResourceExhausted and anything else to Internal.
+func storeError(action string, err error) error {
+	if errors.Is(err, db.ErrMailboxNotFound) {
+		return status.Error(codes.NotFound, "mailbox not found")
+	}
+	if errors.Is(err, db.ErrUserNotFound) {
+		return status.Error(codes.NotFound, "user not found")
+	}
+	if errors.Is(err, db.ErrQuotaExceeded) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return internal(action, err)
+}
+
+// internal logs err and returns an Internal status that does not leak it.
+func internal(action string, err error) error {
+	slog.Error("Error "+action, "error", err)
+	return status.Error(codes.Internal, "error "+action)
+}
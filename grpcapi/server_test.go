@@ -0,0 +1,189 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"mailboxes/db"
+	"mailboxes/grpcapi/pb"
+	"mailboxes/publicid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// testClient serves store over an in-memory connection, with opts, and
+// returns a client for it.
+func testClient(t *testing.T, store db.Store, ids *publicid.Codec, opts ...grpc.ServerOption) pb.MailboxesClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	pb.RegisterMailboxesServer(srv, NewServer(store, ids))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMailboxesClient(conn)
+}
+
+// recvAll reads a stream to its end.
+func recvAll[T any](t *testing.T, stream interface{ Recv() (T, error) }) []T {
+	t.Helper()
+	var items []T
+	for {
+		item, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return items
+		}
+		if err != nil {
+			t.Fatalf("Error receiving: %v", err)
+		}
+		items = append(items, item)
+	}
+}
+
+func TestServer_Streams(t *testing.T) {
+	store := db.NewMemStore()
+	for id := 1; id <= streamPageSize+2; id++ {
+		store.SeedMailboxes(db.Mailbox{ID: id, MPIID: "mpi"})
+	}
+	store.SeedUsers(db.User{ID: 102, MailboxID: 1}, db.User{ID: 101, MailboxID: 1}, db.User{ID: 201, MailboxID: 2})
+	ids := publicid.New("secret")
+	client := testClient(t, store, ids)
+	ctx := context.Background()
+
+	mbStream, err := client.ListMailboxes(ctx, &pb.ListMailboxesRequest{After: ids.Encode(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailboxes := recvAll[*pb.Mailbox](t, mbStream)
+	if len(mailboxes) != streamPageSize+1 || mailboxes[0].Id != ids.Encode(2) || mailboxes[len(mailboxes)-1].Id != ids.Encode(streamPageSize+2) {
+		t.Errorf("Expected mailboxes 2 to %d across pages, got %d", streamPageSize+2, len(mailboxes))
+	}
+
+	userStream, err := client.UsersForMailbox(ctx, &pb.UsersForMailboxRequest{MailboxId: ids.Encode(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := recvAll[*pb.User](t, userStream)
+	if len(users) != 2 || users[0].Id != ids.Encode(101) || users[1].Id != ids.Encode(102) {
+		t.Errorf("Expected users 101 and 102 in order, got %v", users)
+	}
+}
+
+// orderedStore is a Store that does not page, yielding mailboxes in the
+// given order.
+type orderedStore struct {
+	db.Store
+	mailboxes []db.Mailbox
+}
+
+func (s orderedStore) AllMailboxes(ctx context.Context) (<-chan db.Mailbox, error) {
+	ch := make(chan db.Mailbox)
+	go func() {
+		defer close(ch)
+		for _, mb := range s.mailboxes {
+			select {
+			case ch <- mb:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func TestServer_StreamsWithoutPaging(t *testing.T) {
+	mem := db.NewMemStore()
+	mem.SeedUsers(db.User{ID: 101, MailboxID: 1}, db.User{ID: 102, MailboxID: 1}, db.User{ID: 103, MailboxID: 1})
+	store := orderedStore{Store: mem, mailboxes: []db.Mailbox{{ID: 1}, {ID: 2}, {ID: 3}}}
+	ids := publicid.New("secret")
+	client := testClient(t, store, ids)
+	ctx := context.Background()
+
+	mbStream, err := client.ListMailboxes(ctx, &pb.ListMailboxesRequest{After: ids.Encode(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailboxes := recvAll[*pb.Mailbox](t, mbStream)
+	if len(mailboxes) != 2 || mailboxes[0].Id != ids.Encode(2) || mailboxes[1].Id != ids.Encode(3) {
+		t.Errorf("Expected mailboxes 2 and 3, got %v", mailboxes)
+	}
+
+	userStream, err := client.UsersForMailbox(ctx, &pb.UsersForMailboxRequest{MailboxId: ids.Encode(1), After: ids.Encode(101)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := recvAll[*pb.User](t, userStream)
+	if len(users) != 2 || users[0].Id != ids.Encode(102) || users[1].Id != ids.Encode(103) {
+		t.Errorf("Expected users 102 and 103, got %v", users)
+	}
+
+	store.mailboxes = []db.Mailbox{{ID: 2}, {ID: 3}, {ID: 1}}
+	client = testClient(t, store, ids)
+	mbStream, err = client.ListMailboxes(ctx, &pb.ListMailboxesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = mbStream.Recv()
+		if err != nil {
+			break
+		}
+	}
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected Internal for rows out of order, got %v", err)
+	}
+}
+
+func TestServer_CRUD(t *testing.T) {
+	client := testClient(t, db.NewMemStore(), nil)
+	ctx := context.Background()
+
+	mb, err := client.CreateMailbox(ctx, &pb.CreateMailboxRequest{MpiId: "mpi123", Token: "token123"})
+	if err != nil {
+		t.Fatalf("Error creating mailbox: %v", err)
+	}
+	if mb, err = client.UpdateMailbox(ctx, &pb.UpdateMailboxRequest{Id: mb.Id, MpiId: proto.String("mpi456")}); err != nil || mb.MpiId != "mpi456" {
+		t.Fatalf("Expected the MPI ID to be updated, got %v, %v", mb, err)
+	}
+
+	u, err := client.CreateUser(ctx, &pb.CreateUserRequest{MailboxId: mb.Id, UserName: "user1", EmailAddress: "user1@example.com"})
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	u, err = client.UpdateUser(ctx, &pb.UpdateUserRequest{Id: u.Id, EmailAddress: proto.String("user1@example.org")})
+	if err != nil || u.UserName != "user1" || u.EmailAddress != "user1@example.org" {
+		t.Fatalf("Expected only the email address to change, got %v, %v", u, err)
+	}
+	if got, err := client.GetUser(ctx, &pb.GetUserRequest{Id: u.Id}); err != nil || !proto.Equal(got, u) {
+		t.Errorf("Expected %v, got %v, %v", u, got, err)
+	}
+
+	if _, err := client.DeleteUser(ctx, &pb.DeleteUserRequest{Id: u.Id}); err != nil {
+		t.Fatalf("Error deleting user: %v", err)
+	}
+	if _, err := client.GetUser(ctx, &pb.GetUserRequest{Id: u.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for a deleted user, got %v", err)
+	}
+	if _, err := client.CreateMailbox(ctx, &pb.CreateMailboxRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument without mpi_id, got %v", err)
+	}
+	if _, err := client.GetMailbox(ctx, &pb.GetMailboxRequest{Id: "not-an-id"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for an invalid ID, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/health"
+	"mailboxes/provider"
+
+	"github.com/spf13/viper"
+)
+
+const healthUsage = "Usage: health compute [--verify] | show [<mailbox-id>] [--limit 20]"
+
+// healthCommand scores mailboxes so operators know which to fix first:
+// health compute scores every mailbox with users on its token, failing
+// users and last processing, and saves the scores in mailbox_summary;
+// health show lists the least healthy mailboxes, or one mailbox's score.
+func healthCommand(store db.Store, p provider.Provider, args []string) {
+	hs, ok := store.(db.HealthStore)
+	if !ok {
+		fatal("Store does not keep mailbox health")
+	}
+	if len(args) == 0 {
+		fatal(healthUsage)
+	}
+
+	ctx := context.Background()
+	action, args := args[0], args[1:]
+	switch action {
+	case "compute":
+		computeHealth(ctx, store, hs, p, args)
+	case "show":
+		showHealth(ctx, hs, args)
+	default:
+		fatal("Unknown health action; use compute or show", "action", action)
+	}
+}
+
+// computeHealth scores every mailbox that has users. With --verify each
+// token is also checked with the provider, one request per mailbox.
+func computeHealth(ctx context.Context, store db.Store, hs db.HealthStore, p provider.Provider, args []string) {
+	viper.SetDefault("health.stale_after", 7*24*time.Hour)
+
+	fs := flag.NewFlagSet("health compute", flag.ExitOnError)
+	verify := fs.Bool("verify", false, "verify each token with the provider")
+	fs.Parse(args)
+
+	if *verify && p == nil {
+		fatal("No provider configured; set provider.token_url to use --verify")
+	}
+
+	activity, err := hs.MailboxActivity(ctx)
+	if err != nil {
+		fatal("Error reading mailbox activity", "error", err)
+	}
+	rules := tokenRules()
+	staleAfter := viper.GetDuration("health.stale_after")
+	now := time.Now()
+
+	var summaries []db.MailboxSummary
+	unhealthy := 0
+	for mb, err := range db.Mailboxes(ctx, store) {
+		if err != nil {
+			fatal("Error retrieving mailboxes", "error", err)
+		}
+		a, ok := activity[mb.ID]
+		if !ok {
+			continue
+		}
+
+		signals := health.Signals{
+			TokenProblems:   rules.Check(mb.Token, now),
+			Verification:    health.Unverified,
+			Users:           a.Users,
+			FailingUsers:    a.FailingUsers,
+			LastProcessedAt: a.LastProcessedAt,
+		}
+		if *verify {
+			signals.Verification = verification(p, mb.Token)
+		}
+
+		result := health.Score(signals, now, staleAfter)
+		if result.Score < 100 {
+			unhealthy++
+		}
+		summaries = append(summaries, db.MailboxSummary{
+			MailboxID:       mb.ID,
+			HealthScore:     result.Score,
+			TokenValid:      len(signals.TokenProblems) == 0,
+			Verification:    signals.Verification,
+			FailureRate:     result.FailureRate,
+			LastProcessedAt: a.LastProcessedAt,
+			Reasons:         result.Reasons,
+			ComputedAt:      now,
+		})
+	}
+
+	if err := hs.SaveMailboxSummaries(ctx, summaries); err != nil {
+		fatal("Error saving mailbox summaries", "error", err)
+	}
+	slog.Info("Scored mailboxes", "mailboxes", len(summaries), "below_full_health", unhealthy)
+}
+
+// verification checks tok with the provider. A provider that cannot be
+// reached leaves the token unverified rather than rejected.
+func verification(p provider.Provider, tok string) string {
+	err := p.Verify(tok)
+	switch {
+	case err == nil:
+		return health.Verified
+	case errors.Is(err, provider.ErrUnavailable):
+		return health.Unverified
+	default:
+		return health.Rejected
+	}
+}
+
+func showHealth(ctx context.Context, hs db.HealthStore, args []string) {
+	fs := flag.NewFlagSet("health show", flag.ExitOnError)
+	limit := fs.Int("limit", 20, "number of mailboxes to list, least healthy first")
+
+	var summaries []db.MailboxSummary
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		mailboxID, err := strconv.Atoi(args[0])
+		if err != nil {
+			fatal("Invalid mailbox ID", "mailbox_id", args[0], "error", err)
+		}
+		sum, err := hs.MailboxSummary(ctx, mailboxID)
+		if err != nil {
+			fatal("Error reading health of mailbox", "mailbox_id", mailboxID, "error", err)
+		}
+		summaries = append(summaries, sum)
+	} else {
+		fs.Parse(args)
+		var err error
+		if summaries, err = hs.MailboxSummaries(ctx, *limit); err != nil {
+			fatal("Error reading mailbox health", "error", err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAILBOX\tSCORE\tTOKEN\tVERIFIED\tFAILING\tLAST PROCESSED\tREASONS")
+	for _, sum := range summaries {
+		token := "valid"
+		if !sum.TokenValid {
+			token = "invalid"
+		}
+		last := "never"
+		if !sum.LastProcessedAt.IsZero() {
+			last = sum.LastProcessedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%.0f%%\t%s\t%s\n", sum.MailboxID, sum.HealthScore, token, sum.Verification,
+			sum.FailureRate*100, last, strings.Join(sum.Reasons, "; "))
+	}
+	w.Flush()
+}
@@ -0,0 +1,79 @@
+// Package health scores mailboxes from the signals operators check when
+// deciding what to fix first: whether the token passes the format rules and
+// the provider, how many users are failing and how recently the mailbox was
+// processed. Scores run from 100, healthy, down to 0.
+package health
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Verification outcomes of a mailbox's token with the provider.
+const (
+	Verified   = "verified"
+	Rejected   = "rejected"
+	Unverified = "unknown"
+)
+
+// Penalties taken off the score of 100. The failure penalty is scaled by the
+// share of the mailbox's users that are failing.
+const (
+	invalidTokenPenalty  = 40
+	rejectedTokenPenalty = 30
+	failurePenalty       = 20
+	stalePenalty         = 10
+)
+
+// Signals are what a mailbox is scored on.
+type Signals struct {
+	// TokenProblems are the token's violations of the format rules.
+	TokenProblems []string
+	// Verification is Verified, Rejected or Unverified.
+	Verification string
+	Users        int
+	// FailingUsers are the users waiting in the retry queue.
+	FailingUsers    int
+	LastProcessedAt time.Time
+}
+
+// Result is a mailbox's score and the reasons it lost points, worst first.
+type Result struct {
+	Score       int
+	FailureRate float64
+	Reasons     []string
+}
+
+// Score scores s at now. A mailbox with users that has not been processed
+// within staleAfter, or ever, is stale.
+func Score(s Signals, now time.Time, staleAfter time.Duration) Result {
+	r := Result{Score: 100}
+
+	if len(s.TokenProblems) > 0 {
+		r.Score -= invalidTokenPenalty
+		r.Reasons = append(r.Reasons, "invalid token: "+strings.Join(s.TokenProblems, "; "))
+	}
+	if s.Verification == Rejected {
+		r.Score -= rejectedTokenPenalty
+		r.Reasons = append(r.Reasons, "token rejected by the provider")
+	}
+	if s.Users > 0 && s.FailingUsers > 0 {
+		r.FailureRate = min(float64(s.FailingUsers)/float64(s.Users), 1)
+		r.Score -= int(r.FailureRate*failurePenalty + 0.5)
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%d of %d users failing", s.FailingUsers, s.Users))
+	}
+	if s.Users > 0 {
+		switch {
+		case s.LastProcessedAt.IsZero():
+			r.Score -= stalePenalty
+			r.Reasons = append(r.Reasons, "never processed")
+		case now.Sub(s.LastProcessedAt) > staleAfter:
+			r.Score -= stalePenalty
+			r.Reasons = append(r.Reasons, "last processed "+s.LastProcessedAt.UTC().Format(time.RFC3339))
+		}
+	}
+
+	r.Score = max(r.Score, 0)
+	return r
+}
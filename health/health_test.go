@@ -0,0 +1,71 @@
+package health
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScore(t *testing.T) {
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		signals  Signals
+		expected Result
+	}{
+		{
+			name:     "Healthy",
+			signals:  Signals{Verification: Verified, Users: 10, LastProcessedAt: recent},
+			expected: Result{Score: 100},
+		},
+		{
+			name:     "Empty mailbox is never stale",
+			signals:  Signals{Verification: Unverified},
+			expected: Result{Score: 100},
+		},
+		{
+			name:    "Invalid and rejected token",
+			signals: Signals{TokenProblems: []string{"too short"}, Verification: Rejected, Users: 1, LastProcessedAt: recent},
+			expected: Result{Score: 30, Reasons: []string{
+				"invalid token: too short",
+				"token rejected by the provider",
+			}},
+		},
+		{
+			name:     "Failing users",
+			signals:  Signals{Users: 4, FailingUsers: 1, LastProcessedAt: recent},
+			expected: Result{Score: 95, FailureRate: 0.25, Reasons: []string{"1 of 4 users failing"}},
+		},
+		{
+			name:     "Never processed",
+			signals:  Signals{Users: 2},
+			expected: Result{Score: 90, Reasons: []string{"never processed"}},
+		},
+		{
+			name:     "Stale",
+			signals:  Signals{Users: 2, LastProcessedAt: now.Add(-48 * time.Hour)},
+			expected: Result{Score: 90, Reasons: []string{"last processed 2024-07-21T12:00:00Z"}},
+		},
+		{
+			name: "Everything wrong",
+			signals: Signals{TokenProblems: []string{"expired"}, Verification: Rejected, Users: 2, FailingUsers: 2,
+				LastProcessedAt: now.Add(-48 * time.Hour)},
+			expected: Result{Score: 0, FailureRate: 1, Reasons: []string{
+				"invalid token: expired",
+				"token rejected by the provider",
+				"2 of 2 users failing",
+				"last processed 2024-07-21T12:00:00Z",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Score(tt.signals, now, 24*time.Hour); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"mailboxes/db"
+	"mailboxes/importer"
+	"mailboxes/scope"
+)
+
+// importCommand bulk-creates mailboxes and users from --file. Invalid rows
+// are reported and skipped; mailboxes are matched on MPI ID and users on
+// email address, so importing the same file twice creates nothing new. With
+// --dry-run the import is rolled back and only reported.
+func importCommand(store db.Store, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "", "CSV or JSON file of users to import")
+	format := flags.String("format", "", "file format: csv or json (default from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing anything")
+	flags.Parse(args)
+
+	if *file == "" {
+		fatal("Usage: import --file <users.csv> [--format csv|json] [--dry-run]")
+	}
+	if *format == "" {
+		*format = importer.FormatOf(*file)
+	}
+
+	importStore, ok := store.(db.ImportStore)
+	if !ok {
+		fatal("Store does not support imports")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fatal("Error opening file", "path", *file, "error", err)
+	}
+	records, err := importer.Read(f, *format)
+	f.Close()
+	if err != nil {
+		fatal("Error reading file", "path", *file, "error", err)
+	}
+
+	valid, problems := importer.Validate(records)
+	for _, p := range problems {
+		slog.Warn("Skipping invalid row", "problem", p)
+	}
+
+	ctx := scope.WithSource(context.Background(), "import:"+filepath.Base(*file))
+	result, err := importStore.ImportUsers(ctx, valid, *dryRun)
+	if err != nil {
+		fatal("Error importing file", "path", *file, "error", err)
+	}
+	if !*dryRun {
+		clearCache()
+	}
+
+	verb := "Created"
+	if *dryRun {
+		verb = "Would create"
+		for _, mpiID := range result.Mailboxes {
+			slog.Info("Would create mailbox", "mpi_id", mpiID)
+		}
+		for _, r := range result.Users {
+			slog.Info("Would create user", "email_address", r.EmailAddress, "mpi_id", r.MPIID)
+		}
+	}
+	for _, r := range result.Existing {
+		slog.Info("User already exists", "email_address", r.EmailAddress)
+	}
+	slog.Info(verb+" mailboxes and users", "mailboxes", len(result.Mailboxes), "users", len(result.Users), "existing", len(result.Existing), "invalid", len(problems))
+}
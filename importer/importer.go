@@ -0,0 +1,182 @@
+// Package importer reads the users to bulk-create from CSV or JSON files and
+// checks them before anything is written.
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"path/filepath"
+	"strings"
+
+	"mailboxes/db"
+)
+
+// FormatOf returns the format implied by path's extension: "csv" for .csv
+// files and "json" otherwise.
+func FormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// Read decodes records in format "csv", with a header row naming the
+// mpi_id, user_name and email_address columns, or "json", either an array
+// of objects or one object per line.
+func Read(r io.Reader, format string) ([]db.ImportRecord, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		return readCSV(r)
+	case "json", "jsonl":
+		return readJSON(r)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+func readCSV(r io.Reader) ([]db.ImportRecord, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"mpi_id", "user_name", "email_address"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+
+	var records []db.ImportRecord
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, db.ImportRecord{
+			MPIID:        row[columns["mpi_id"]],
+			UserName:     row[columns["user_name"]],
+			EmailAddress: row[columns["email_address"]],
+		})
+	}
+}
+
+func readJSON(r io.Reader) ([]db.ImportRecord, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(br)
+	dec.DisallowUnknownFields()
+
+	if first == '[' {
+		var records []db.ImportRecord
+		return records, dec.Decode(&records)
+	}
+
+	var records []db.ImportRecord
+	for {
+		var record db.ImportRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// Problem is a record that cannot be imported. Row counts records from 1.
+type Problem struct {
+	Row    int
+	Record db.ImportRecord
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("row %d (%s): %s", p.Row, p.Record.EmailAddress, p.Reason)
+}
+
+// Validate trims every record and returns those that can be imported, in
+// order, and the problems with the rest: a missing MPI ID or user name, an
+// invalid email address, or an email address already seen earlier in the
+// file.
+func Validate(records []db.ImportRecord) ([]db.ImportRecord, []Problem) {
+	var valid []db.ImportRecord
+	var problems []Problem
+	seen := map[string]int{}
+
+	for i, r := range records {
+		r.MPIID = strings.TrimSpace(r.MPIID)
+		r.UserName = strings.TrimSpace(r.UserName)
+		r.EmailAddress = strings.TrimSpace(r.EmailAddress)
+
+		reason := ""
+		switch {
+		case r.MPIID == "":
+			reason = "missing mpi_id"
+		case r.UserName == "":
+			reason = "missing user_name"
+		case !validEmail(r.EmailAddress):
+			reason = "invalid email address"
+		}
+		if reason == "" {
+			key := strings.ToLower(r.EmailAddress)
+			if row, ok := seen[key]; ok {
+				reason = fmt.Sprintf("duplicate of row %d", row)
+			} else {
+				seen[key] = i + 1
+			}
+		}
+
+		if reason != "" {
+			problems = append(problems, Problem{Row: i + 1, Record: r, Reason: reason})
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid, problems
+}
+
+// validEmail accepts a bare address, without a display name or angle
+// brackets.
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && addr.Name == ""
+}
@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"mailboxes/db"
+)
+
+func TestRead(t *testing.T) {
+	expected := []db.ImportRecord{
+		{MPIID: "mpi1", UserName: "user1", EmailAddress: "user1@example.com"},
+		{MPIID: "mpi2", UserName: "user2", EmailAddress: "user2@example.com"},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"csv", "csv", "email_address,mpi_id,user_name\nuser1@example.com,mpi1,user1\nuser2@example.com, mpi2,user2\n"},
+		{"json array", "json", `[{"mpi_id":"mpi1","user_name":"user1","email_address":"user1@example.com"},
+			{"mpi_id":"mpi2","user_name":"user2","email_address":"user2@example.com"}]`},
+		{"json lines", "json", `{"mpi_id":"mpi1","user_name":"user1","email_address":"user1@example.com"}
+{"mpi_id":"mpi2","user_name":"user2","email_address":"user2@example.com"}
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := Read(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("Error reading: %v", err)
+			}
+			if !reflect.DeepEqual(records, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, records)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"missing column", "csv", "mpi_id,user_name\nmpi1,user1\n"},
+		{"unknown field", "json", `{"mpi_id":"mpi1","token":"secret"}`},
+		{"unknown format", "xml", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(strings.NewReader(tt.input), tt.format); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	records := []db.ImportRecord{
+		{MPIID: " mpi1 ", UserName: "user1", EmailAddress: "user1@example.com "},
+		{MPIID: "", UserName: "user2", EmailAddress: "user2@example.com"},
+		{MPIID: "mpi1", UserName: "user3", EmailAddress: "not an address"},
+		{MPIID: "mpi1", UserName: "user4", EmailAddress: "User Four <user4@example.com>"},
+		{MPIID: "mpi2", UserName: "again", EmailAddress: "USER1@example.com"},
+		{MPIID: "mpi2", UserName: "", EmailAddress: "user6@example.com"},
+	}
+
+	valid, problems := Validate(records)
+
+	expectedValid := []db.ImportRecord{{MPIID: "mpi1", UserName: "user1", EmailAddress: "user1@example.com"}}
+	if !reflect.DeepEqual(valid, expectedValid) {
+		t.Errorf("Expected valid %+v, got %+v", expectedValid, valid)
+	}
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	expectedProblems := []string{
+		"row 2 (user2@example.com): missing mpi_id",
+		"row 3 (not an address): invalid email address",
+		"row 4 (User Four <user4@example.com>): invalid email address",
+		"row 5 (USER1@example.com): duplicate of row 1",
+		"row 6 (user6@example.com): missing user_name",
+	}
+	if !reflect.DeepEqual(got, expectedProblems) {
+		t.Errorf("Expected problems %q, got %q", expectedProblems, got)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	if FormatOf("users.CSV") != "csv" || FormatOf("users.jsonl") != "json" {
+		t.Errorf("Unexpected formats for users.CSV and users.jsonl")
+	}
+}
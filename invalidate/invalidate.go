@@ -0,0 +1,177 @@
+// Package invalidate tells caches which of their entries writes have made
+// stale. Writers publish to a Bus, which passes each invalidation on to
+// every cache subscribed in the process; a Follower publishes the writes
+// other processes record in the user_changes outbox, such as imports and
+// other API replicas, so a cache does not serve them stale until its TTL.
+package invalidate
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Cache is a cache that can drop entries.
+type Cache interface {
+	// Invalidate drops a mailbox's cached users.
+	Invalidate(mailboxID int)
+	// InvalidateMailboxes drops the cached mailboxes.
+	InvalidateMailboxes()
+}
+
+// Bus passes invalidations on to the caches subscribed to it. It is safe
+// for concurrent use, and a nil *Bus passes on nothing.
+type Bus struct {
+	mu     sync.RWMutex
+	caches []Cache
+}
+
+// Subscribe has c receive every invalidation published from now on.
+func (b *Bus) Subscribe(c Cache) {
+	b.mu.Lock()
+	b.caches = append(b.caches, c)
+	b.mu.Unlock()
+}
+
+// Users invalidates the cached users of the given mailboxes.
+func (b *Bus) Users(mailboxIDs ...int) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, c := range b.caches {
+		for _, id := range mailboxIDs {
+			c.Invalidate(id)
+		}
+	}
+}
+
+// Mailboxes invalidates the cached mailboxes.
+func (b *Bus) Mailboxes() {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, c := range b.caches {
+		c.InvalidateMailboxes()
+	}
+}
+
+// followBatch is how many changes a Follower reads at a time.
+const followBatch = 1000
+
+// Follower publishes to a Bus the mailboxes whose users changed, as read
+// from the user_changes outbox. A change that moves a user names both its
+// mailboxes; one that only renames a user is traced to the user's mailbox
+// through users, if set, and otherwise not published.
+type Follower struct {
+	changes db.ChangeFeedStore
+	users   db.UserStore
+	bus     *Bus
+	afterID int
+}
+
+// NewFollower returns a Follower of changes publishing to bus, starting
+// after the change with ID afterID.
+func NewFollower(changes db.ChangeFeedStore, users db.UserStore, bus *Bus, afterID int) *Follower {
+	return &Follower{changes: changes, users: users, bus: bus, afterID: afterID}
+}
+
+// Skip moves past every change recorded so far without publishing them,
+// for a follower of caches that start empty.
+func (f *Follower) Skip(ctx context.Context) error {
+	for {
+		changes, err := f.changes.UserChangesAfter(ctx, f.afterID, followBatch)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			f.afterID = changes[len(changes)-1].ID
+		}
+		if len(changes) < followBatch {
+			return nil
+		}
+	}
+}
+
+// Poll publishes the mailboxes of every change recorded since the last
+// poll and returns how many changes it read. A change whose mailbox cannot
+// be looked up stops the poll before it, to be tried again next time.
+func (f *Follower) Poll(ctx context.Context) (int, error) {
+	read := 0
+	for {
+		changes, err := f.changes.UserChangesAfter(ctx, f.afterID, followBatch)
+		if err != nil {
+			return read, err
+		}
+		mailboxIDs := map[int]bool{}
+		for _, c := range changes {
+			if err := f.mailboxesOf(ctx, c, mailboxIDs); err != nil {
+				f.publish(mailboxIDs)
+				return read, err
+			}
+			f.afterID = c.ID
+			read++
+		}
+		f.publish(mailboxIDs)
+		if len(changes) < followBatch {
+			return read, nil
+		}
+	}
+}
+
+// Run polls every interval until ctx is done, logging failed polls.
+func (f *Follower) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := f.Poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Error following user changes for cache invalidation", "after_id", f.afterID, "error", err)
+		}
+	}
+}
+
+// mailboxesOf adds the mailboxes change affects to mailboxIDs.
+func (f *Follower) mailboxesOf(ctx context.Context, c db.UserChange, mailboxIDs map[int]bool) error {
+	if c.Field == "mailbox_id" {
+		for _, v := range []string{c.Old, c.New} {
+			if id, err := strconv.Atoi(v); err == nil {
+				mailboxIDs[id] = true
+			}
+		}
+		return nil
+	}
+	// Creates and deletes record mailbox_id too, so only updates need the
+	// user looked up.
+	if c.Op != db.ChangeUpdate || f.users == nil {
+		return nil
+	}
+	user, err := f.users.GetUserByID(ctx, c.UserID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		// Deleted since; its delete names the mailbox.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mailboxIDs[user.MailboxID] = true
+	return nil
+}
+
+func (f *Follower) publish(mailboxIDs map[int]bool) {
+	for id := range mailboxIDs {
+		f.bus.Users(id)
+	}
+}
@@ -0,0 +1,100 @@
+package invalidate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"mailboxes/db"
+)
+
+// recordingCache records what it was told to drop.
+type recordingCache struct {
+	users     []int
+	mailboxes int
+}
+
+func (c *recordingCache) Invalidate(mailboxID int) { c.users = append(c.users, mailboxID) }
+func (c *recordingCache) InvalidateMailboxes()     { c.mailboxes++ }
+
+// outbox is a db.ChangeFeedStore over a slice of changes.
+type outbox struct {
+	changes []db.UserChange
+	err     error
+}
+
+func (o *outbox) UserChangesAfter(ctx context.Context, afterID, limit int) ([]db.UserChange, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	var after []db.UserChange
+	for _, c := range o.changes {
+		if c.ID > afterID && len(after) < limit {
+			after = append(after, c)
+		}
+	}
+	return after, nil
+}
+
+func TestBus(t *testing.T) {
+	var bus Bus
+	a, b := &recordingCache{}, &recordingCache{}
+	bus.Subscribe(a)
+	bus.Subscribe(b)
+	bus.Users(1, 2)
+	bus.Mailboxes()
+	for _, c := range []*recordingCache{a, b} {
+		if !reflect.DeepEqual(c.users, []int{1, 2}) || c.mailboxes != 1 {
+			t.Errorf("Expected mailboxes 1 and 2 and the mailboxes dropped, got %v and %d", c.users, c.mailboxes)
+		}
+	}
+
+	var none *Bus
+	none.Users(1)
+	none.Mailboxes()
+}
+
+func TestFollower_Poll(t *testing.T) {
+	users := db.NewMemStore()
+	users.SeedMailboxes(db.Mailbox{ID: 1}, db.Mailbox{ID: 2}, db.Mailbox{ID: 3}, db.Mailbox{ID: 4})
+	users.SeedUsers(db.User{ID: 101, MailboxID: 3, UserName: "renamed"})
+
+	changes := &outbox{changes: []db.UserChange{
+		{ID: 1, UserID: 100, Op: db.ChangeCreate, Field: "mailbox_id", New: "4"},
+	}}
+	var bus Bus
+	cache := &recordingCache{}
+	bus.Subscribe(cache)
+	f := NewFollower(changes, users, &bus, 0)
+	if err := f.Skip(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	changes.changes = append(changes.changes,
+		db.UserChange{ID: 2, UserID: 100, Op: db.ChangeCreate, Field: "mailbox_id", New: "1"},
+		db.UserChange{ID: 3, UserID: 100, Op: db.ChangeCreate, Field: "user_name", New: "new"},
+		db.UserChange{ID: 4, UserID: 102, Op: db.ChangeUpdate, Field: "mailbox_id", Old: "1", New: "2"},
+		db.UserChange{ID: 5, UserID: 101, Op: db.ChangeUpdate, Field: "user_name", Old: "old", New: "renamed"},
+		db.UserChange{ID: 6, UserID: 999, Op: db.ChangeUpdate, Field: "user_name", Old: "gone", New: "deleted"},
+	)
+	n, err := f.Poll(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("Expected 5 changes read, got %d, %v", n, err)
+	}
+	slices.Sort(cache.users)
+	if !reflect.DeepEqual(cache.users, []int{1, 2, 3}) {
+		t.Errorf("Expected mailboxes 1, 2 and 3 dropped and not the skipped 4, got %v", cache.users)
+	}
+
+	cache.users = nil
+	if n, err := f.Poll(context.Background()); err != nil || n != 0 || cache.users != nil {
+		t.Errorf("Expected nothing new, got %d changes, %v dropped, %v", n, cache.users, err)
+	}
+
+	changes.err = errors.New("connection refused")
+	if _, err := f.Poll(context.Background()); err == nil {
+		t.Errorf("Expected the outbox's error")
+	}
+}
@@ -0,0 +1,247 @@
+// Package jobqueue hands mailbox-processing jobs to workers through a NATS
+// JetStream work-queue stream, so that several instances can share the
+// mailboxes to process without each scanning the whole table. Each job is
+// delivered to one worker at a time and stays in the stream until a worker
+// acknowledges it.
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config is the worker section.
+type Config struct {
+	URL string `mapstructure:"url"`
+	// Stream is the work-queue stream, created if missing, capturing
+	// Subject. The defaults are MAILBOX_JOBS and mailboxes.jobs.
+	Stream  string `mapstructure:"stream"`
+	Subject string `mapstructure:"subject"`
+	// Consumer is the durable consumer every worker shares; the default is
+	// mailboxes-worker.
+	Consumer string `mapstructure:"consumer"`
+	// AckWait is how long a worker may go silent on a job before it is
+	// delivered to another; workers report progress every half of it. The
+	// default is 1m.
+	AckWait time.Duration `mapstructure:"ack_wait"`
+	// MaxDeliver is how many times a job is attempted before it is dropped;
+	// the default is 5.
+	MaxDeliver int `mapstructure:"max_deliver"`
+}
+
+func (c *Config) setDefaults() {
+	if c.URL == "" {
+		c.URL = nats.DefaultURL
+	}
+	if c.Stream == "" {
+		c.Stream = "MAILBOX_JOBS"
+	}
+	if c.Subject == "" {
+		c.Subject = "mailboxes.jobs"
+	}
+	if c.Consumer == "" {
+		c.Consumer = "mailboxes-worker"
+	}
+	if c.AckWait <= 0 {
+		c.AckWait = time.Minute
+	}
+	if c.MaxDeliver <= 0 {
+		c.MaxDeliver = 5
+	}
+}
+
+// Job is one mailbox to process.
+type Job struct {
+	MailboxID int `json:"mailbox_id"`
+}
+
+// parseJob decodes a job message.
+func parseJob(data []byte) (Job, error) {
+	var j Job
+	if err := json.Unmarshal(data, &j); err != nil {
+		return j, fmt.Errorf("decoding job: %w", err)
+	}
+	if j.MailboxID <= 0 {
+		return j, fmt.Errorf("job has no mailbox ID: %s", data)
+	}
+	return j, nil
+}
+
+// PermanentError wraps the error of a job that will not succeed however
+// often it is retried, such as one for a mailbox that no longer exists. Such
+// a job is dropped instead of redelivered.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// redeliveryDelay is how long a job that failed its attempt'th delivery
+// waits before the next: 5s, doubling each time up to 5m.
+func redeliveryDelay(attempt uint64) time.Duration {
+	d := 5 * time.Second
+	for i := uint64(1); i < attempt && d < 5*time.Minute; i++ {
+		d *= 2
+	}
+	return min(d, 5*time.Minute)
+}
+
+// Queue is a connection to the job stream.
+type Queue struct {
+	cfg    Config
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	stream jetstream.Stream
+}
+
+// Open connects to the server at cfg.URL and creates or updates the job
+// stream.
+func Open(ctx context.Context, cfg Config) (*Queue, error) {
+	cfg.setDefaults()
+	nc, err := nats.Connect(cfg.URL, nats.Name("mailboxes"))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      cfg.Stream,
+		Subjects:  []string{cfg.Subject},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("creating stream %s: %w", cfg.Stream, err)
+	}
+	return &Queue{cfg: cfg, nc: nc, js: js, stream: stream}, nil
+}
+
+// Enqueue adds a job for the mailbox, once the stream has stored it.
+func (q *Queue) Enqueue(ctx context.Context, mailboxID int) error {
+	data, err := json.Marshal(Job{MailboxID: mailboxID})
+	if err != nil {
+		return err
+	}
+	_, err = q.js.Publish(ctx, q.cfg.Subject, data)
+	return err
+}
+
+// Pending returns how many jobs are waiting in the stream.
+func (q *Queue) Pending(ctx context.Context) (uint64, error) {
+	info, err := q.stream.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.State.Msgs, nil
+}
+
+// Consume runs handle on up to workers jobs at a time until ctx is done,
+// and then waits for the jobs in hand. A job is acknowledged if handle
+// returns nil, dropped if it returns a *PermanentError or fails its last
+// delivery, and otherwise redelivered after redeliveryDelay.
+func (q *Queue) Consume(ctx context.Context, workers int, handle func(ctx context.Context, job Job) error) error {
+	cons, err := q.js.CreateOrUpdateConsumer(ctx, q.cfg.Stream, jetstream.ConsumerConfig{
+		Durable:       q.cfg.Consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       q.cfg.AckWait,
+		MaxDeliver:    q.cfg.MaxDeliver,
+		FilterSubject: q.cfg.Subject,
+	})
+	if err != nil {
+		return fmt.Errorf("creating consumer %s: %w", q.cfg.Consumer, err)
+	}
+	// Fetch no more than the workers can start on, so that jobs are not
+	// held back from other instances.
+	msgs, err := cons.Messages(jetstream.PullMaxMessages(workers))
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, msgs.Stop)
+	defer stop()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	defer wg.Wait()
+	for {
+		msg, err := msgs.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return nil
+		}
+		if err != nil {
+			slog.Warn("Error receiving jobs", "stream", q.cfg.Stream, "error", err)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			q.run(ctx, msg, handle)
+		}()
+	}
+}
+
+// run handles one job message, reporting progress while it runs.
+func (q *Queue) run(ctx context.Context, msg jetstream.Msg, handle func(ctx context.Context, job Job) error) {
+	job, err := parseJob(msg.Data())
+	if err != nil {
+		slog.Error("Dropping malformed job", "error", err)
+		msg.Term()
+		return
+	}
+	var attempt uint64 = 1
+	if meta, err := msg.Metadata(); err == nil {
+		attempt = meta.NumDelivered
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.cfg.AckWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				msg.InProgress()
+			}
+		}
+	}()
+	err = handle(ctx, job)
+	close(done)
+
+	var permanent *PermanentError
+	switch {
+	case err == nil:
+		err = msg.Ack()
+	case errors.As(err, &permanent):
+		slog.Error("Dropping job; it cannot succeed", "mailbox_id", job.MailboxID, "error", err)
+		err = msg.Term()
+	case attempt >= uint64(q.cfg.MaxDeliver):
+		slog.Error("Dropping job after its last attempt", "mailbox_id", job.MailboxID, "attempts", attempt, "error", err)
+		err = msg.Term()
+	default:
+		delay := redeliveryDelay(attempt)
+		slog.Warn("Job failed; it will be redelivered", "mailbox_id", job.MailboxID, "attempt", attempt, "delay", delay, "error", err)
+		err = msg.NakWithDelay(delay)
+	}
+	if err != nil {
+		slog.Error("Error settling job", "mailbox_id", job.MailboxID, "error", err)
+	}
+}
+
+// Close closes the connection.
+func (q *Queue) Close() {
+	q.nc.Close()
+}
@@ -0,0 +1,44 @@
+package jobqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseJob(t *testing.T) {
+	job, err := parseJob([]byte(`{"mailbox_id": 42}`))
+	if err != nil || job.MailboxID != 42 {
+		t.Errorf("Expected mailbox 42, got %+v, %v", job, err)
+	}
+	for _, data := range []string{`{}`, `{"mailbox_id": -1}`, `42`, `not json`} {
+		if _, err := parseJob([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}
+
+func TestRedeliveryDelay(t *testing.T) {
+	tests := []struct {
+		attempt uint64
+		want    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{7, 5 * time.Minute},
+		{50, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := redeliveryDelay(tt.attempt); got != tt.want {
+			t.Errorf("redeliveryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := Config{Subject: "jobs"}
+	cfg.setDefaults()
+	if cfg.Stream != "MAILBOX_JOBS" || cfg.Subject != "jobs" || cfg.Consumer != "mailboxes-worker" || cfg.AckWait != time.Minute || cfg.MaxDeliver != 5 {
+		t.Errorf("Unexpected defaults %+v", cfg)
+	}
+}
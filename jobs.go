@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"mailboxes/api"
+	"mailboxes/db"
+	"mailboxes/publicid"
+)
+
+// registerJobs adds the bulk job kinds the store supports. IDs in job
+// parameters are public IDs, as elsewhere in the API.
+func registerJobs(jobs *api.Jobs, store db.Store, ids *publicid.Codec) {
+	decode := func(publicIDs []string) ([]int, error) {
+		out := make([]int, len(publicIDs))
+		for i, s := range publicIDs {
+			id, err := ids.Decode(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ID %q", s)
+			}
+			out[i] = id
+		}
+		return out, nil
+	}
+
+	// process runs the given mailboxes through the pipeline's user handling.
+	jobs.Register("process", func(ctx context.Context, params json.RawMessage, progress *api.Progress) error {
+		var p struct {
+			MailboxIDs []string `json:"mailbox_ids"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return err
+		}
+		mailboxIDs, err := decode(p.MailboxIDs)
+		if err != nil {
+			return err
+		}
+
+		progress.SetTotal(len(mailboxIDs))
+		for _, mailboxID := range mailboxIDs {
+			userChan, err := store.UsersForMailbox(ctx, mailboxID)
+			if err != nil {
+				progress.Fail()
+				continue
+			}
+			for user := range userChan {
+				handleUser(ctx, user)
+			}
+			progress.Done()
+		}
+		return ctx.Err()
+	})
+
+	if queue, ok := store.(db.QueueStore); ok {
+		// enqueue puts users on the work queue for a running watcher.
+		jobs.Register("enqueue", func(ctx context.Context, params json.RawMessage, progress *api.Progress) error {
+			var p struct {
+				UserIDs []string `json:"user_ids"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return err
+			}
+			userIDs, err := decode(p.UserIDs)
+			if err != nil {
+				return err
+			}
+
+			progress.SetTotal(len(userIDs))
+			for _, userID := range userIDs {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err := queue.EnqueueUser(ctx, userID); err != nil {
+					progress.Fail()
+					continue
+				}
+				progress.Done()
+			}
+			return nil
+		})
+	}
+
+	if reassigner, ok := store.(db.ReassignStore); ok {
+		// move reassigns users between mailboxes, like the move command.
+		jobs.Register("move", func(ctx context.Context, params json.RawMessage, progress *api.Progress) error {
+			var p struct {
+				From    string   `json:"from"`
+				To      string   `json:"to"`
+				UserIDs []string `json:"user_ids"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return err
+			}
+			mailboxIDs, err := decode([]string{p.From, p.To})
+			if err != nil {
+				return err
+			}
+			userIDs, err := decode(p.UserIDs)
+			if err != nil {
+				return err
+			}
+
+			var filter func(db.User) bool
+			if len(userIDs) > 0 {
+				wanted := map[int]bool{}
+				for _, id := range userIDs {
+					wanted[id] = true
+				}
+				filter = func(user db.User) bool { return wanted[user.ID] }
+			}
+
+			moved, err := reassigner.ReassignUsers(ctx, mailboxIDs[0], mailboxIDs[1], filter)
+			invalidateUsers(mailboxIDs...)
+			progress.SetTotal(moved)
+			for i := 0; i < moved; i++ {
+				progress.Done()
+			}
+			return err
+		})
+	}
+}
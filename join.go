@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mailboxes/budget"
+	"mailboxes/db"
+	"mailboxes/timing"
+)
+
+// mailboxPass tracks one mailbox's users through the join pipeline.
+type mailboxPass struct {
+	mb        db.Mailbox
+	started   time.Time
+	ctx       context.Context // cancelled when the mailbox times out
+	cancel    context.CancelFunc
+	acks      *ackGroup
+	pending   sync.WaitGroup
+	processed atomic.Int64
+	handled   int // users handed to the workers; only the reader writes it
+	stages    timing.Breakdown
+
+	// failed counts the users that failed and firstErr holds the first of
+	// their errors; mu guards firstErr.
+	failed   atomic.Int64
+	mu       sync.Mutex
+	firstErr error
+}
+
+// fail records that one of the mailbox's users failed with err.
+func (p *mailboxPass) fail(err error) {
+	p.failed.Add(1)
+	p.mu.Lock()
+	if p.firstErr == nil {
+		p.firstErr = err
+	}
+	p.mu.Unlock()
+}
+
+type joinWork struct {
+	user db.User
+	pass *mailboxPass
+}
+
+// pipelineJoin is Pipeline for join mode. One query streams every user with
+// its mailbox; each mailbox's token is checked when its first user arrives
+// and its users are handed to the pipelineWorkers workers one at a time. A
+// mailbox is reported once the stream has moved past it and its last user is
+// done. Mailboxes without users are not visited at all. Failed mailboxes are
+// reported as by Pipeline.
+func pipelineJoin(ctx context.Context, js db.JoinStore) error {
+	started := time.Now()
+	expiredTokens := 0
+	timings := &timing.Recorder{}
+
+	ctx, failures := newFailures(ctx)
+	pairs, err := js.UsersWithMailboxes(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving users with mailboxes: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	work := make(chan joinWork)
+	for i := 0; i < pipelineWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range work {
+				if mailboxTimedOut(w.pass.ctx) || pipelineBreaker.Wait(w.pass.ctx) != nil {
+					w.pass.pending.Done()
+					continue
+				}
+				processed, err := handleUser(timing.NewContext(w.pass.ctx, &w.pass.stages), w.user)
+				pipelineBreaker.Record(err != nil)
+				budget.FromContext(ctx).Record(err != nil)
+				if processed {
+					w.pass.processed.Add(1)
+				}
+				if err != nil {
+					w.pass.fail(err)
+				}
+				w.pass.pending.Done()
+			}
+		}()
+	}
+
+	var passes sync.WaitGroup
+	finish := func(p *mailboxPass) {
+		if p == nil {
+			return
+		}
+		passes.Add(1)
+		go func() {
+			defer passes.Done()
+			p.pending.Wait()
+			defer p.cancel()
+			if n, err := p.acks.wait(); n > 0 {
+				p.processed.Add(-int64(n))
+				p.failed.Add(int64(n))
+				if p.firstErr == nil {
+					p.firstErr = err
+				}
+			}
+			users, failed := int(p.processed.Load()), int(p.failed.Load())
+			sloTracker.Done(p.mb.ID, users, time.Now(), ctx.Err() == nil && failed == 0)
+			timings.Done(p.mb.ID, &p.stages)
+			slog.Info("Processed mailbox", append([]any{"component", "pipeline", "mailbox_id", p.mb.ID, "users", users, "failed", failed, "duration", time.Since(p.started)}, p.stages.Attrs()...)...)
+			timedOut := timeoutError(p.ctx, p.mb.ID, p.handled)
+			if timedOut != nil {
+				runFailures.mailbox(p.mb.ID, timedOut)
+			}
+			err := errors.Join(timedOut, mailboxError(p.mb.ID, failed, p.handled, p.firstErr))
+			runReport.Done(p.mb.ID, users, failed, time.Since(p.started), err)
+			emitMailbox(ctx, p.mb.ID, users, failed, err)
+			if err != nil {
+				failures.add(err)
+			} else if ctx.Err() == nil {
+				checkpoint.mailbox(ctx, p.mb.ID)
+			}
+		}()
+	}
+
+	// current is the mailbox being streamed, or nil while skipping the users
+	// of a mailbox whose token is unusable.
+	var current *mailboxPass
+	lastID := 0
+	// Time spent waiting on the stream is charged to the mailbox of the user
+	// it delivers.
+	wait := time.Now()
+	for pair := range pairs {
+		read := time.Since(wait)
+		if pair.Mailbox.ID != lastID {
+			finish(current)
+			current, lastID = nil, pair.Mailbox.ID
+			refreshProcessor(ctx)
+
+			mb := pair.Mailbox
+			if checkpoint.mailboxDone(mb.ID) || !reprocessing.wantsMailbox(mb.ID) || !filterMailbox(mb) {
+				wait = time.Now()
+				continue
+			}
+			if !usableMailbox(&mb) {
+				expiredTokens++
+				wait = time.Now()
+				continue
+			}
+			slog.Info("Processing mailbox", "component", "pipeline", "mailbox_id", mb.ID)
+			current = &mailboxPass{mb: mb, started: time.Now()}
+			current.ctx, current.cancel = mailboxContext(ctx)
+			current.ctx, current.acks = withAckGroup(current.ctx)
+		}
+		if current == nil || !reprocessing.wantsUser(pair.User) || !filterUser(current.mb, pair.User) {
+			wait = time.Now()
+			continue
+		}
+
+		current.stages.Add(timing.Read, read)
+		if debugUser.Wants(pair.User.ID) {
+			debugUser.Record("read", wait, current.mb.ID, pair.User, nil)
+		}
+		current.handled++
+		current.pending.Add(1)
+		work <- joinWork{user: pair.User, pass: current}
+		wait = time.Now()
+	}
+	finish(current)
+	close(work)
+
+	wg.Wait()
+	passes.Wait()
+	pipelineFinished(ctx, started, expiredTokens, timings)
+	return failures.err()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"sync"
+	"time"
+
+	"mailboxes/bloom"
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// ledger, when ledger.enabled is set for run and watch, skips users already
+// processed by an earlier pass and records those processed now; nil
+// otherwise.
+var ledger *ledgerIndex
+
+// ledgerIndex fronts the processed_users ledger with a Bloom filter kept on
+// disk. Users the filter has never seen, which in steady state is nearly
+// every user an incremental pass reads, are known to be new without a
+// ledger lookup.
+type ledgerIndex struct {
+	store db.LedgerStore
+	path  string
+
+	mu      sync.Mutex
+	filter  *bloom.Filter
+	checks  int
+	lookups int
+}
+
+// setupLedger enables the ledger for store if ledger.enabled is set,
+// loading the filter from ledger.bloom.file or rebuilding it from the
+// ledger when it is missing or older than ledger.bloom.rebuild_interval.
+func setupLedger(ctx context.Context, store db.Store) error {
+	if !viper.GetBool("ledger.enabled") {
+		return nil
+	}
+	ls, ok := store.(db.LedgerStore)
+	if !ok {
+		slog.Warn("Store does not keep a ledger of processed users; ledger.enabled ignored")
+		return nil
+	}
+
+	viper.SetDefault("ledger.bloom.file", "ledger.bloom")
+	viper.SetDefault("ledger.bloom.rebuild_interval", 24*time.Hour)
+	viper.SetDefault("ledger.bloom.capacity", 1000000)
+	viper.SetDefault("ledger.bloom.false_positive_rate", 0.01)
+
+	l := &ledgerIndex{store: ls, path: viper.GetString("ledger.bloom.file")}
+
+	capacity := viper.GetInt("ledger.bloom.capacity")
+	filter, err := bloom.Load(l.path)
+	switch {
+	case err != nil:
+		slog.Info("Rebuilding ledger filter", "file", l.path, "reason", err)
+	case time.Since(filter.Built()) > viper.GetDuration("ledger.bloom.rebuild_interval"):
+		slog.Info("Rebuilding ledger filter", "file", l.path, "built", filter.Built().UTC().Format(time.RFC3339))
+		// Leave room for the ledger to keep growing until the next rebuild.
+		capacity = max(capacity, 2*filter.Count())
+	default:
+		l.filter = filter
+	}
+
+	if l.filter == nil {
+		if l.filter, err = l.rebuild(ctx, capacity); err != nil {
+			return err
+		}
+	}
+
+	ledger = l
+	return nil
+}
+
+func (l *ledgerIndex) rebuild(ctx context.Context, capacity int) (*bloom.Filter, error) {
+	entries, err := l.store.ProcessedUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bloom.New(capacity, viper.GetFloat64("ledger.bloom.false_positive_rate"))
+	for p := range entries {
+		filter.Add(ledgerKey(p))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := filter.Save(l.path); err != nil {
+		slog.Warn("Error saving ledger filter", "file", l.path, "error", err)
+	}
+	slog.Info("Ledger filter rebuilt", "entries", filter.Count())
+	return filter, nil
+}
+
+func ledgerKey(p db.ProcessedUser) []byte {
+	key := binary.BigEndian.AppendUint64(nil, uint64(p.MailboxID))
+	return binary.BigEndian.AppendUint64(key, uint64(p.UserID))
+}
+
+// seen reports whether user was processed before. If the ledger cannot be
+// read the user is treated as new, since processing twice is safer than
+// never.
+func (l *ledgerIndex) seen(ctx context.Context, user db.User) bool {
+	if l == nil {
+		return false
+	}
+
+	p := db.ProcessedUser{MailboxID: user.MailboxID, UserID: user.ID}
+	l.mu.Lock()
+	maybe := l.filter.Test(ledgerKey(p))
+	l.checks++
+	if maybe {
+		l.lookups++
+	}
+	l.mu.Unlock()
+
+	if !maybe {
+		return false
+	}
+	processed, err := l.store.WasProcessed(ctx, p)
+	if err != nil {
+		slog.Error("Error checking ledger", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		return false
+	}
+	return processed
+}
+
+// record adds user to the ledger and the filter.
+func (l *ledgerIndex) record(ctx context.Context, user db.User) {
+	if l == nil {
+		return
+	}
+
+	p := db.ProcessedUser{MailboxID: user.MailboxID, UserID: user.ID}
+	if err := l.store.RecordProcessed(ctx, p); err != nil {
+		slog.Error("Error recording processed user", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	l.filter.Add(ledgerKey(p))
+	l.mu.Unlock()
+}
+
+// save writes the filter back to disk and logs how many ledger lookups it
+// saved since the last save.
+func (l *ledgerIndex) save() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.checks > 0 {
+		slog.Info("Ledger checks", "users", l.checks, "lookups", l.lookups, "avoided", l.checks-l.lookups)
+		l.checks, l.lookups = 0, 0
+	}
+	if err := l.filter.Save(l.path); err != nil {
+		slog.Warn("Error saving ledger filter", "file", l.path, "error", err)
+	}
+}
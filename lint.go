@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/token"
+
+	"github.com/spf13/viper"
+)
+
+// tokenRules reads the token format rules from the tokens config section.
+func tokenRules() token.Rules {
+	return token.Rules{
+		MinLength:     viper.GetInt("tokens.min_length"),
+		MaxLength:     viper.GetInt("tokens.max_length"),
+		Prefix:        viper.GetString("tokens.prefix"),
+		Format:        viper.GetString("tokens.format"),
+		RequireExpiry: viper.GetBool("tokens.require_expiry"),
+	}
+}
+
+// lintTokensCommand checks every mailbox token against the configured rules,
+// reports the invalid ones without printing the tokens themselves, and exits
+// non-zero if any were found.
+func lintTokensCommand(store db.Store) {
+	rules := tokenRules()
+	now := time.Now()
+
+	checked, invalid := 0, 0
+	for mb, err := range db.Mailboxes(context.Background(), store) {
+		if err != nil {
+			fatal("Error retrieving mailboxes", "error", err)
+		}
+		checked++
+		if problems := rules.Check(mb.Token, now); len(problems) > 0 {
+			invalid++
+			slog.Warn("Invalid mailbox token", "mailbox_id", mb.ID, "mpi_id", mb.MPIID, "problems", strings.Join(problems, "; "))
+		}
+	}
+
+	slog.Info("Linted mailbox tokens", "invalid", invalid, "checked", checked)
+	if invalid > 0 {
+		os.Exit(1)
+	}
+}
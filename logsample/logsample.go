@@ -0,0 +1,165 @@
+// Package logsample thins out high-volume log lines, such as the ones
+// written for every user, so that runs over millions of users don't flood
+// the log pipeline. A slog.Handler wrapper keeps 1 in N records per level,
+// and per component for lines that carry a component attribute. Errors are
+// always kept.
+package logsample
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Config sets how many records are kept. A rate of N keeps the first record
+// and then 1 in every N; rates of 0 and 1 keep every record.
+type Config struct {
+	// Levels maps level names (debug, info, warn) to rates.
+	Levels map[string]int `mapstructure:"levels"`
+	// Components maps a component to rates by level that override Levels
+	// for records whose component attribute has that value.
+	Components map[string]map[string]int `mapstructure:"components"`
+	// DropUsers discards every record below error level that carries a
+	// user_id attribute.
+	DropUsers bool `mapstructure:"-"`
+}
+
+// ComponentKey is the attribute records are grouped by for component rates.
+const ComponentKey = "component"
+
+// userKey marks the per-user records DropUsers discards.
+const userKey = "user_id"
+
+// Handler samples records before passing them to another handler. Kept
+// records that were sampled carry a sample_rate attribute, so counts can be
+// scaled back up.
+type Handler struct {
+	next      slog.Handler
+	s         *sampler
+	component string
+}
+
+type sampler struct {
+	levels     map[slog.Level]int
+	components map[string]map[slog.Level]int
+	dropUsers  bool
+
+	mu     sync.Mutex
+	counts map[key]*atomic.Uint64
+}
+
+type key struct {
+	component string
+	level     slog.Level
+}
+
+// New returns a Handler that samples records for next as cfg says. It fails
+// on unknown level names, on negative rates and on rates for error level,
+// which is never sampled.
+func New(next slog.Handler, cfg Config) (*Handler, error) {
+	s := &sampler{components: make(map[string]map[slog.Level]int), dropUsers: cfg.DropUsers, counts: make(map[key]*atomic.Uint64)}
+
+	var err error
+	if s.levels, err = parseRates(cfg.Levels); err != nil {
+		return nil, err
+	}
+	for component, rates := range cfg.Components {
+		if s.components[component], err = parseRates(rates); err != nil {
+			return nil, fmt.Errorf("component %s: %w", component, err)
+		}
+	}
+	return &Handler{next: next, s: s}, nil
+}
+
+func parseRates(rates map[string]int) (map[slog.Level]int, error) {
+	parsed := make(map[slog.Level]int, len(rates))
+	for name, n := range rates {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, err
+		}
+		if level >= slog.LevelError {
+			return nil, fmt.Errorf("%s records are never sampled", strings.ToLower(level.String()))
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("sample rate for %s must not be negative, not %d", name, n)
+		}
+		parsed[level] = n
+	}
+	return parsed, nil
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		return h.next.Handle(ctx, r)
+	}
+
+	component, perUser := h.component, false
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case ComponentKey:
+			component = a.Value.String()
+		case userKey:
+			perUser = true
+		}
+		return true
+	})
+	if perUser && h.s.dropUsers {
+		return nil
+	}
+
+	n := h.s.rate(component, r.Level)
+	if n <= 1 {
+		return h.next.Handle(ctx, r)
+	}
+	if !h.s.keep(key{component, r.Level}, n) {
+		return nil
+	}
+	r = r.Clone()
+	r.AddAttrs(slog.Int("sample_rate", n))
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := h.component
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			component = a.Value.String()
+		}
+	}
+	return &Handler{next: h.next.WithAttrs(attrs), s: h.s, component: component}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), s: h.s, component: h.component}
+}
+
+// rate returns the rate for records of component at level: the component's
+// own, if it sets one for level, and otherwise the level's.
+func (s *sampler) rate(component string, level slog.Level) int {
+	if rates, ok := s.components[component]; ok {
+		if n, ok := rates[level]; ok {
+			return n
+		}
+	}
+	return s.levels[level]
+}
+
+// keep reports whether the next record counted under k is 1 in n.
+func (s *sampler) keep(k key, n int) bool {
+	s.mu.Lock()
+	c, ok := s.counts[k]
+	if !ok {
+		c = new(atomic.Uint64)
+		s.counts[k] = c
+	}
+	s.mu.Unlock()
+	return (c.Add(1)-1)%uint64(n) == 0
+}
@@ -0,0 +1,83 @@
+package logsample
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func testLogger(t *testing.T, cfg Config) (*slog.Logger, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	h, err := New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), cfg)
+	if err != nil {
+		t.Fatalf("Error creating handler: %v", err)
+	}
+	return slog.New(h), &buf
+}
+
+func TestHandler_Sampling(t *testing.T) {
+	logger, buf := testLogger(t, Config{
+		Levels:     map[string]int{"info": 10},
+		Components: map[string]map[string]int{"processor": {"info": 100}, "pipeline": {"info": 1}},
+	})
+
+	for i := 0; i < 1000; i++ {
+		logger.Info("Processing user", "user_id", i)
+		logger.Info("Processing user", ComponentKey, "processor", "user_id", i)
+		logger.Info("Processed mailbox", ComponentKey, "pipeline", "mailbox_id", i)
+		logger.Error("Error processing user", ComponentKey, "processor", "user_id", i)
+	}
+	logger.With(ComponentKey, "processor").Info("Processing user", "user_id", 1000)
+
+	counts := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		switch {
+		case strings.Contains(line, "level=ERROR"):
+			counts["error"]++
+		case strings.Contains(line, "component=processor") && strings.Contains(line, "sample_rate=100"):
+			counts["processor"]++
+		case strings.Contains(line, "component=pipeline"):
+			counts["pipeline"]++
+		case strings.Contains(line, "sample_rate=10"):
+			counts["info"]++
+		}
+	}
+	expected := map[string]int{"error": 1000, "processor": 11, "pipeline": 1000, "info": 100}
+	for k, n := range expected {
+		if counts[k] != n {
+			t.Errorf("Expected %d %s lines, got %d", n, k, counts[k])
+		}
+	}
+}
+
+func TestHandler_DropUsers(t *testing.T) {
+	logger, buf := testLogger(t, Config{DropUsers: true})
+
+	logger.Info("Processing user", "user_id", 1)
+	logger.Warn("Scheduled retry", "user_id", 1)
+	logger.Error("Error processing user", "user_id", 1)
+	logger.Info("Processed mailbox", "mailbox_id", 1)
+
+	out := buf.String()
+	if strings.Contains(out, "Processing user") || strings.Contains(out, "Scheduled retry") {
+		t.Errorf("Expected per-user lines below error to be dropped, got %q", out)
+	}
+	if !strings.Contains(out, "Error processing user") || !strings.Contains(out, "Processed mailbox") {
+		t.Errorf("Expected errors and mailbox lines to be kept, got %q", out)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Levels: map[string]int{"loud": 10}},
+		{Levels: map[string]int{"error": 10}},
+		{Levels: map[string]int{"info": -1}},
+		{Components: map[string]map[string]int{"processor": {"verbose": 10}}},
+	} {
+		if _, err := New(slog.NewTextHandler(&bytes.Buffer{}, nil), cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
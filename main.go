@@ -1,72 +1,1069 @@
 package main
 
 import (
-	"log"
-	"path/filepath"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"sync"
+	"time"
 
+	"mailboxes/annotations"
+	"mailboxes/breaker"
+	"mailboxes/budget"
+	"mailboxes/chaos"
 	"mailboxes/db"
+	"mailboxes/debugbundle"
+	"mailboxes/filter"
+	"mailboxes/logsample"
+	"mailboxes/memlimit"
+	"mailboxes/processor"
+	"mailboxes/redact"
+	"mailboxes/runreport"
+	"mailboxes/script"
+	"mailboxes/shadow"
+	"mailboxes/sink"
+	"mailboxes/skip"
+	"mailboxes/slo"
+	"mailboxes/timing"
+	"mailboxes/token"
 
 	"github.com/spf13/viper"
 )
 
-// processUser is a fictional function to process each user
-func processUser(user db.User) {
-	log.Printf("Processing user: User Name - %s, Mailbox Token - %s", user.UserName, "<fake_token>")
+// process is the processor every handled user is passed to, built from
+// processor.kind. Commands such as soak replace it before running the
+// pipeline. Users it fails are scheduled for retry.
+var process processor.Processor = processor.Log{}
+
+// processors, when processor.configs is set, is the blue/green switch
+// process delegates to. The serve API changes its active configuration
+// through the store, and runs pick the change up between mailboxes.
+var processors *processor.Switch
+
+// userScript, when configured, filters and transforms users before they are
+// processed.
+var userScript *script.Script
+
+// monkey injects faults when chaos mode is enabled; nil otherwise.
+var monkey *chaos.Monkey
+
+// memory adapts batch sizes and worker count to memory pressure; nil when
+// neither GOMEMLIMIT nor pipeline.memory_budget is set.
+var memory *memlimit.Limiter
+
+// pipelineWorkers is how many mailboxes Pipeline processes at once, each on
+// its own worker with one users query open.
+var pipelineWorkers = 8
+
+// pipelineMode is how Pipeline reads users: "mailbox" (the default) queries
+// each mailbox's users separately, "join" streams every user with its
+// mailbox from one query.
+var pipelineMode = "mailbox"
+
+// pipelineFailFast stops a run at the first failed mailbox instead of
+// continuing with the rest; set with pipeline.on_error: fail_fast.
+var pipelineFailFast bool
+
+// pipelineMailboxTimeout, when set, bounds the time spent on each mailbox.
+// A mailbox that takes longer is cancelled and fails; the run goes on.
+var pipelineMailboxTimeout time.Duration
+
+// pipelineBreaker pauses the pipeline while too many users are failing; nil
+// unless pipeline.breaker.threshold is set.
+var pipelineBreaker *breaker.Breaker
+
+// pipelineBudget is when a run is aborted for failing too many users, set
+// with pipeline.max_failure_rate and pipeline.max_consecutive_failures.
+var pipelineBudget budget.Config
+
+// debugUser records every step taken for the user chosen with
+// run --debug-user; nil otherwise. debugMailboxID is that user's mailbox.
+var (
+	debugUser      *debugbundle.Recorder
+	debugMailboxID int
+)
+
+// shadowRun, when pipeline.shadow.script is set, runs that candidate script
+// next to the current one and records where their outputs differ. Only the
+// current script's output is processed.
+var shadowRun *shadow.Shadow
+
+// sloTracker, when slo.target is set, records whether each mailbox of a run
+// met the processing objective.
+var sloTracker *slo.Tracker
+
+// sloSink is the alerting sink named by slo.sink, or nil if none is set. It
+// discards reports if the sink could not be reached at startup.
+var sloSink sink.Sink
+
+// runReport, when report.dir, report.sink or run --report asks for one,
+// records each mailbox of a run for the summary written when it ends.
+var runReport *runreport.Recorder
+
+// reportSink is the sink named by report.sink, which run summaries are sent
+// to, or nil if none is set.
+var reportSink sink.Sink
+
+// handleUser runs the configured script against user, enriches it and
+// processes it unless the script filters it out. It reports whether the user
+// was processed, and the error if the script, enrichment or the processor
+// failed; skipped users are not errors. Time spent in the script and
+// enrichment, and in the processor, is added to the timing.Breakdown ctx
+// carries, if any. With pipelineAcks, a user whose acknowledgment the
+// processor deferred is reported as processed and recorded once
+// acknowledged; see deferAck.
+func handleUser(ctx context.Context, user db.User) (bool, error) {
+	return handleAttempt(ctx, user, db.Retry{})
 }
 
-// Pipeline function to process mailboxes, retrieve users, and process each user
-func Pipeline(store db.Store) {
-	var wg sync.WaitGroup
+// handleAttempt is handleUser for a user's next attempt after prev; the
+// first attempt has a zero prev. If processing fails the user is scheduled
+// for another attempt.
+func handleAttempt(ctx context.Context, user db.User, prev db.Retry) (bool, error) {
+	debug := debugUser.Wants(user.ID)
+
+	// Users enqueued by hand are processed again on purpose.
+	if prev.Priority != retryPriorityQueued && ledger.seen(ctx, user) {
+		skipped(user, skip.AlreadyProcessed)
+		return false, nil
+	}
+	if checkpoint.userDone(user) {
+		return false, nil
+	}
+
+	in := user
+	var reason skip.Reason
+	var err error
+	stages := timing.FromContext(ctx)
+	if userScript != nil {
+		start := time.Now()
+		user, reason, err = userScript.ApplyContext(ctx, in)
+		stages.Since(timing.Transform, start)
+		if debug {
+			debugUser.Record("script", start, in, map[string]any{"user": user, "skip_reason": reason}, err)
+		}
+	}
+	shadowRun.Run(in, shadow.NewOutput(user, reason, err))
+
+	if err != nil {
+		slog.Error("Error running script", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		skipped(user, skip.ScriptError)
+		runFailures.user(user, err)
+		return false, fmt.Errorf("user %d: script: %w", user.ID, err)
+	}
+	if reason != "" {
+		skipped(user, reason)
+		return false, nil
+	}
+
+	start := time.Now()
+	ectx, err := enrichment.context(ctx, user)
+	stages.Since(timing.Transform, start)
+	if debug && enrichment != nil {
+		debugUser.Record("enrich", start, user, processor.EnrichmentFrom(ectx), err)
+	}
+	if err != nil {
+		slog.Error("Error enriching user", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		scheduleRetry(ctx, user, prev, err)
+		runFailures.user(user, err)
+		return false, fmt.Errorf("user %d: %w", user.ID, err)
+	}
 
-	mailboxChan, err := store.AllMailboxes()
+	monkey.Crash()
+	dctx, err := destinations.context(ectx, user.MailboxID)
 	if err != nil {
-		log.Fatalf("Error retrieving mailboxes: %v", err)
+		slog.Error("Error finding webhook destination", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		scheduleRetry(ctx, user, prev, err)
+		runFailures.user(user, err)
+		return false, fmt.Errorf("user %d: %w", user.ID, err)
+	}
+	start = time.Now()
+	pctx, ack := newAck(dctx)
+	err = process.Process(pctx, user)
+	stages.Since(timing.Sink, start)
+	if debug {
+		debugUser.Record("process", start, user, nil, err)
+	}
+	if err != nil {
+		slog.Error("Error processing user", "user_id", user.ID, "mailbox_id", user.MailboxID, "attempt", prev.Attempts+1, "error", err)
+		scheduleRetry(ctx, user, prev, err)
+		runFailures.user(user, err)
+		return false, fmt.Errorf("user %d: %w", user.ID, err)
+	}
+	if ack != nil && ack.Deferred() {
+		return deferAck(ctx, user, prev, ack)
+	}
+	recordProcessed(ctx, user)
+	return true, nil
+}
+
+// skips counts users skipped since the last time they were reported, by
+// reason.
+var skips skip.Counts
+
+// skipped records that user was not processed, and why.
+func skipped(user db.User, reason skip.Reason) {
+	skips.Add(reason)
+	slog.Debug("Skipping user", "component", "pipeline", "user_id", user.ID, "mailbox_id", user.MailboxID, "reason", reason)
+}
+
+// reportSkips logs how many users were skipped for each reason since the
+// last report, if any were.
+func reportSkips() {
+	if counts := skips.Flush(); len(counts) > 0 {
+		slog.Info("Users skipped", skip.Attrs(counts)...)
+	}
+}
+
+// tokenRefresher replaces expired mailbox tokens before processing. When it
+// is nil, mailboxes with expired tokens are skipped and reported.
+var tokenRefresher token.Refresher
+
+// tokenExpirySkew treats tokens about to expire as already expired, so they
+// don't lapse partway through a mailbox.
+var tokenExpirySkew = time.Minute
+
+// checkToken makes sure mb's token has not expired, refreshing it when
+// possible. It reports false if the mailbox should be skipped.
+func checkToken(mb *db.Mailbox) bool {
+	exp, expired := token.Expired(mb.Token, time.Now(), tokenExpirySkew)
+	if !expired {
+		return true
+	}
+
+	if tokenRefresher == nil {
+		slog.Warn("Skipping mailbox: token expired", "mailbox_id", mb.ID, "expired_at", exp.UTC().Format(time.RFC3339))
+		return false
 	}
 
-	for mb := range mailboxChan {
+	tok, err := tokenRefresher.Refresh(*mb)
+	if err != nil {
+		slog.Warn("Skipping mailbox: token expired and could not be refreshed", "mailbox_id", mb.ID, "expired_at", exp.UTC().Format(time.RFC3339), "error", err)
+		return false
+	}
+
+	mb.Token = tok
+	slog.Info("Refreshed expired token", "mailbox_id", mb.ID)
+	return true
+}
+
+// Pipeline function to process mailboxes, retrieve users, and process each user.
+// Mailboxes are handed to a pool of pipelineWorkers workers. Cancelling ctx
+// stops the store queries and the dispatch of further mailboxes; mailboxes
+// already started stop before their next user. In join mode, stores that can join users with their mailboxes
+// are read with pipelineJoin instead. Mailboxes and users the checkpoint
+// says an interrupted run completed are skipped. With tenantFairness set,
+// mailboxes are dispatched by tenant share rather than in order.
+//
+// The returned error joins one error per failed mailbox: one whose users
+// could not be read, or with any user the script or processor failed. With
+// pipelineFailFast the first failure stops the run.
+func Pipeline(ctx context.Context, store db.Store) error {
+	if pipelineMode == "join" {
+		if js, ok := store.(db.JoinStore); ok {
+			if tenantFairness != nil {
+				slog.Warn("Tenant fairness does not apply in join mode; mailboxes are processed in order")
+			}
+			return pipelineJoin(ctx, js)
+		}
+		slog.Warn("Store cannot join users with mailboxes; querying each mailbox instead")
+	}
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	expiredTokens := 0
+	timings := &timing.Recorder{}
+
+	ctx, failures := newFailures(ctx)
+	reads := cachedReads(store)
+	mailboxChan, err := reads.AllMailboxes(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving mailboxes: %w", err)
+	}
+	fair, err := newTenantDispatch(ctx, store)
+	if err != nil {
+		return err
+	}
+	// busy holds one slot per mailbox handed to a worker and not yet
+	// finished, so a mailbox is only chosen once a worker is free.
+	work := make(chan db.Mailbox)
+	busy := make(chan struct{}, pipelineWorkers)
+	for i := 0; i < pipelineWorkers; i++ {
 		wg.Add(1)
-		log.Printf("Processing %d mailbox", mb.ID)
+		go func() {
+			defer wg.Done()
+			for mb := range work {
+				failures.add(processMailbox(ctx, reads, mb, timings))
+				fair.done(mb)
+				<-busy
+			}
+		}()
+	}
 
-		userChan, err := store.UsersForMailbox(mb.ID)
+	accept := func(mb *db.Mailbox) bool {
+		if checkpoint.mailboxDone(mb.ID) || !reprocessing.wantsMailbox(mb.ID) || !filterMailbox(*mb) {
+			return false
+		}
+		if !usableMailbox(mb) {
+			expiredTokens++
+			return false
+		}
+		return true
+	}
+	wait := func() {
+		busy <- struct{}{}
+	}
+	send := func(mb db.Mailbox) {
+		work <- mb
+	}
+	if fair != nil {
+		fair.dispatch(ctx, mailboxChan, accept, wait, send)
+	} else {
+		for mb := range mailboxChan {
+			if !accept(&mb) {
+				continue
+			}
+			wait()
+			if ctx.Err() != nil {
+				// Reading on lets the store's query finish.
+				for range mailboxChan {
+				}
+				break
+			}
+			send(mb)
+		}
+	}
+	close(work)
+
+	wg.Wait()
+	pipelineFinished(ctx, started, expiredTokens, timings)
+	return failures.err()
+}
+
+// errFailFast is why a fail-fast run was stopped.
+var errFailFast = errors.New("stopped after a mailbox failed")
+
+// failures collects the errors of failed mailboxes. It is safe for
+// concurrent use.
+type failures struct {
+	mu     sync.Mutex
+	errs   []error
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// newFailures returns a failures and a context derived from ctx that is
+// cancelled at the first failure when pipelineFailFast is set, or once the
+// run's users exceed pipelineBudget. The context carries the run's
+// budget.Budget.
+func newFailures(ctx context.Context) (context.Context, *failures) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	b := budget.New(pipelineBudget, func(err error) {
+		slog.Error("Aborting run", "reason", err)
+		cancel(err)
+	})
+	ctx = budget.NewContext(ctx, b)
+	return ctx, &failures{ctx: ctx, cancel: cancel}
+}
+
+func (f *failures) add(err error) {
+	if err == nil {
+		return
+	}
+	f.mu.Lock()
+	f.errs = append(f.errs, err)
+	f.mu.Unlock()
+	if pipelineFailFast {
+		f.cancel(errFailFast)
+	}
+}
+
+// err joins the errors collected, and why the run was aborted if its
+// failure budget was exceeded, or returns nil if there were none.
+func (f *failures) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cause := context.Cause(f.ctx); errors.Is(cause, budget.ErrExceeded) {
+		return errors.Join(append([]error{cause}, f.errs...)...)
+	}
+	return errors.Join(f.errs...)
+}
+
+// mailboxError describes the users of a mailbox that failed: how many of
+// those handled, and the first error. It returns nil if none failed.
+func mailboxError(mailboxID int, failed, handled int, first error) error {
+	if first == nil {
+		return nil
+	}
+	return fmt.Errorf("mailbox %d: %d of %d users failed: %w", mailboxID, failed, handled, first)
+}
+
+// usableMailbox checks mb's token, refreshing it if needed, and reports
+// whether the mailbox can be processed. Skipped mailboxes count against the
+// SLO.
+func usableMailbox(mb *db.Mailbox) bool {
+	start, before := time.Now(), *mb
+	usable := checkToken(mb)
+	if debugUser != nil && mb.ID == debugMailboxID {
+		debugUser.Record("token", start, before, map[string]any{"mailbox": *mb, "usable": usable}, nil)
+	}
+	if !usable {
+		sloTracker.Skipped(mb.ID, string(skip.TokenExpired))
+		runReport.Skipped(mb.ID, string(skip.TokenExpired))
+	}
+	return usable
+}
+
+func pipelineFinished(ctx context.Context, started time.Time, expiredTokens int, timings *timing.Recorder) {
+	if err := ctx.Err(); err != nil {
+		slog.Warn("Pipeline stopped early", "error", context.Cause(ctx))
+	}
+	if expiredTokens > 0 {
+		slog.Warn("Mailboxes skipped with expired tokens", "mailboxes", expiredTokens, "reason", skip.TokenExpired)
+	}
+	reportSkips()
+	ledger.save()
+	r := timings.Report(viper.GetInt("timing.top"))
+	r.Annotations = annotations.FromContext(ctx).Map()
+	reportTimings(r)
+	slog.Info("Pipeline finished", "duration", time.Since(started))
+}
+
+// reportTimings logs how long the run spent in each stage and on the slowest
+// mailboxes, and writes the report to timing.report_file if set.
+func reportTimings(r timing.Report) {
+	if r.Mailboxes == 0 {
+		return
+	}
+	slog.Info("Stage timing", "mailboxes", r.Mailboxes, "read_seconds", r.Total.Read,
+		"transform_seconds", r.Total.Transform, "sink_seconds", r.Total.Sink)
+	for _, m := range r.Slowest {
+		slog.Debug("Slow mailbox", "mailbox_id", m.MailboxID, "read_seconds", m.Read,
+			"transform_seconds", m.Transform, "sink_seconds", m.Sink)
+	}
+
+	if path := viper.GetString("timing.report_file"); path != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0o644)
+		}
 		if err != nil {
-			log.Printf("Error retrieving users for mailbox %d: %v", mb.ID, err)
-			wg.Done()
-			continue
+			slog.Error("Error writing timing report", "path", path, "error", err)
 		}
+	}
+}
 
-		go func(mb db.Mailbox) {
-			defer wg.Done()
+// processMailbox handles every user of mb and returns an error if the users
+// could not be read, any of them failed or the mailbox ran past
+// pipelineMailboxTimeout. With pipelineFailFast it stops at the first failed
+// user.
+func processMailbox(ctx context.Context, store db.Store, mb db.Mailbox, timings *timing.Recorder) error {
+	started := time.Now()
+	slog.Info("Processing mailbox", "component", "pipeline", "mailbox_id", mb.ID)
+	refreshProcessor(ctx)
+
+	ctx, cancel := mailboxContext(ctx)
+	defer cancel()
+	ctx, acks := withAckGroup(ctx)
+	stages := &timing.Breakdown{}
+	ctx = timing.NewContext(ctx, stages)
+	defer timings.Done(mb.ID, stages)
+
+	userChan, err := store.UsersForMailbox(ctx, mb.ID)
+	stages.Since(timing.Read, started)
+	if err != nil {
+		slog.Error("Error retrieving users", "mailbox_id", mb.ID, "error", err)
+		sloTracker.Done(mb.ID, 0, time.Now(), false)
+		if mailboxTimedOut(ctx) {
+			err = timeoutError(ctx, mb.ID, 0)
+		} else {
+			err = fmt.Errorf("mailbox %d: retrieving users: %w", mb.ID, err)
+		}
+		runReport.Done(mb.ID, 0, 0, time.Since(started), err)
+		runFailures.mailbox(mb.ID, err)
+		emitMailbox(ctx, mb.ID, 0, 0, err)
+		return err
+	}
 
-			userCount := 0
-			for user := range userChan {
-				processUser(user)
-				userCount++
+	userCount, handled, failed := 0, 0, 0
+	var firstErr error
+	stopped := false
+	// Time spent waiting on the channel is time the store took to deliver
+	// the next user.
+	wait := time.Now()
+	for user := range userChan {
+		stages.Since(timing.Read, wait)
+		if !reprocessing.wantsUser(user) || !filterUser(mb, user) {
+			wait = time.Now()
+			continue
+		}
+		if ctx.Err() != nil || pipelineBreaker.Wait(ctx) != nil {
+			stopped = true
+			break
+		}
+		if debugUser.Wants(user.ID) {
+			debugUser.Record("read", wait, mb.ID, user, nil)
+		}
+		handled++
+		processed, err := handleUser(ctx, user)
+		pipelineBreaker.Record(err != nil)
+		budget.FromContext(ctx).Record(err != nil)
+		if processed {
+			userCount++
+		}
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
 			}
+			if pipelineFailFast {
+				stopped = true
+				break
+			}
+		}
+		wait = time.Now()
+	}
+	stages.Since(timing.Read, wait)
+	if n, err := acks.wait(); n > 0 {
+		userCount -= n
+		failed += n
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
 
-			log.Printf("%d users processed for mailbox %d", userCount, mb.ID)
-		}(mb)
+	sloTracker.Done(mb.ID, userCount, time.Now(), ctx.Err() == nil && failed == 0)
+	slog.Info("Processed mailbox", append([]any{"component", "pipeline", "mailbox_id", mb.ID, "users", userCount, "failed", failed, "duration", time.Since(started)}, stages.Attrs()...)...)
+	if firstErr == nil && ctx.Err() == nil {
+		checkpoint.mailbox(ctx, mb.ID)
+	}
+	err = errors.Join(timeoutError(ctx, mb.ID, handled), mailboxError(mb.ID, failed, handled, firstErr))
+	if stopped {
+		runFailures.mailbox(mb.ID, cmp.Or(err, context.Cause(ctx)))
 	}
+	runReport.Done(mb.ID, userCount, failed, time.Since(started), err)
+	emitMailbox(ctx, mb.ID, userCount, failed, err)
+	return err
+}
 
-	wg.Wait()
+// errMailboxTimeout is why a mailbox is cancelled once it runs past
+// pipelineMailboxTimeout.
+var errMailboxTimeout = errors.New("mailbox timed out")
+
+// mailboxContext returns a context for processing one mailbox, cancelled
+// with errMailboxTimeout once pipelineMailboxTimeout passes, if set.
+func mailboxContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if pipelineMailboxTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, pipelineMailboxTimeout, errMailboxTimeout)
 }
 
+// mailboxTimedOut reports whether the mailbox ctx was made for ran past
+// pipelineMailboxTimeout.
+func mailboxTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errMailboxTimeout)
+}
+
+// timeoutError returns the error of a mailbox that timed out after handled
+// users, or nil if it did not.
+func timeoutError(ctx context.Context, mailboxID, handled int) error {
+	if !mailboxTimedOut(ctx) {
+		return nil
+	}
+	slog.Warn("Mailbox timed out", "mailbox_id", mailboxID, "timeout", pipelineMailboxTimeout, "users_handled", handled)
+	return fmt.Errorf("mailbox %d: %w after %s with %d users handled", mailboxID, errMailboxTimeout, pipelineMailboxTimeout, handled)
+}
+
+// setupLogging installs the default slog logger, writing text or JSON to
+// stderr at level and above, and appending to file as well if set. The
+// standard log package is routed through it.
+func setupLogging(format, level, file string) error {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return err
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var out io.Writer = os.Stderr
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		out = io.MultiWriter(os.Stderr, f)
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// setupLogSampling wraps the default logger to keep only 1 in N records as
+// log.sampling sets per level and component, and to drop the lines written
+// for each user when log.per_user is false. Errors are always logged.
+func setupLogSampling() error {
+	viper.SetDefault("log.per_user", true)
+
+	var cfg logsample.Config
+	if err := viper.UnmarshalKey("log.sampling", &cfg); err != nil {
+		return err
+	}
+	cfg.DropUsers = !viper.GetBool("log.per_user")
+	if len(cfg.Levels) == 0 && len(cfg.Components) == 0 && !cfg.DropUsers {
+		return nil
+	}
+
+	h, err := logsample.New(slog.Default().Handler(), cfg)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// mysqlCommands are the commands supported on MySQL, which the store only
+// reads: the others need tables or writes it does not have.
+var mysqlCommands = map[string]bool{"run": true, "daemon": true, "snapshot": true, "stats": true, "support-bundle": true, "serve": true, "grpc-serve": true}
+
+// openStore connects to the configured database. MySQL and MariaDB get their
+// own store, and flat opens a read-only flat store file written by snapshot
+// --store; every other driver goes through DBStore, with the
+// database.sqlite PRAGMAs run on each connection on SQLite. Every database
+// pool is tuned by database.pool.
+func openStore(driver, path string) (db.Store, error) {
+	if driver == "flat" {
+		return db.OpenFlatStore(path)
+	}
+	pool := db.PoolConfig{
+		MaxOpenConns:    viper.GetInt("database.pool.max_open_conns"),
+		MaxIdleConns:    viper.GetInt("database.pool.max_idle_conns"),
+		ConnMaxLifetime: viper.GetDuration("database.pool.conn_max_lifetime"),
+		ConnMaxIdleTime: viper.GetDuration("database.pool.conn_max_idle_time"),
+	}
+	if driver == "mysql" {
+		return db.NewMySQLStore(db.MySQLConfig{
+			DSN:        path,
+			TLS:        viper.GetString("database.tls.mode"),
+			CAFile:     viper.GetString("database.tls.ca_file"),
+			CertFile:   viper.GetString("database.tls.cert_file"),
+			KeyFile:    viper.GetString("database.tls.key_file"),
+			ServerName: viper.GetString("database.tls.server_name"),
+			Pool:       pool,
+		})
+	}
+	if driver == "sqlite3" || driver == "sqlite" {
+		viper.SetDefault("database.sqlite.journal_mode", "WAL")
+		viper.SetDefault("database.sqlite.synchronous", "NORMAL")
+		viper.SetDefault("database.sqlite.busy_timeout", 5*time.Second)
+		return db.NewSQLiteStore(driver, db.SQLiteConfig{
+			Path:        path,
+			JournalMode: viper.GetString("database.sqlite.journal_mode"),
+			Synchronous: viper.GetString("database.sqlite.synchronous"),
+			BusyTimeout: viper.GetDuration("database.sqlite.busy_timeout"),
+			CacheSize:   viper.GetInt("database.sqlite.cache_size"),
+			Pool:        pool,
+		})
+	}
+	return db.NewDBStore(driver, path, pool)
+}
+
+// openSink returns the sink configured under sinks.<name>: POSTed to
+// sinks.<name>.url, or written to stdout when no URL is set.
+func openSink(name string) (sink.Sink, error) {
+	return sink.New(sink.Config{
+		URL:     viper.GetString("sinks." + name + ".url"),
+		Codec:   viper.GetString("sinks." + name + ".codec"),
+		Timeout: viper.GetDuration("sinks." + name + ".timeout"),
+		Redact:  redactPolicy("sinks." + name + ".redact"),
+	}, os.Stdout)
+}
+
+// redactPolicy reads the include, exclude and mask field lists configured
+// under key.
+func redactPolicy(key string) redact.Policy {
+	return redact.Policy{
+		Include: viper.GetStringSlice(key + ".include"),
+		Exclude: viper.GetStringSlice(key + ".exclude"),
+		Mask:    viper.GetStringSlice(key + ".mask"),
+	}
+}
+
+// newProcessor builds the processor configured under processor.kind, or a
+// switch over the named configurations under processor.configs with
+// processor.active selected.
+func newProcessor() (processor.Processor, error) {
+	configs := viper.GetStringMap("processor.configs")
+	if len(configs) == 0 {
+		return processor.New(viper.GetString("processor.kind"), viper.GetStringMapString("processor.settings"))
+	}
+
+	procs := map[string]processor.Processor{}
+	for name := range configs {
+		key := "processor.configs." + name
+		p, err := processor.New(viper.GetString(key+".kind"), viper.GetStringMapString(key+".settings"))
+		if err != nil {
+			return nil, fmt.Errorf("configuration %s: %w", name, err)
+		}
+		procs[name] = p
+	}
+	sw, err := processor.NewSwitch(procs, viper.GetString("processor.active"))
+	if err != nil {
+		return nil, err
+	}
+	processors = sw
+	slog.Info("Processor configurations loaded", "configurations", sw.Names(), "active", sw.Active())
+	return sw, nil
+}
+
+// refreshProcessor picks up the processor configuration another process
+// switched to, if processor.configs is set. Runs call it between mailboxes
+// and watch between batches of users.
+func refreshProcessor(ctx context.Context) {
+	if processors != nil {
+		processors.Refresh(ctx)
+	}
+}
+
+// compileScript compiles the Starlark script src configured under key, with
+// each user's run limited to pipeline.script_max_steps steps.
+func compileScript(key, src string) (*script.Script, error) {
+	viper.SetDefault("pipeline.script_max_steps", script.DefaultMaxSteps)
+	return script.Compile(key, src, viper.GetUint64("pipeline.script_max_steps"))
+}
 
 func main() {
-	configPath := filepath.Join(".", "config/database.yaml")
-	viper.SetConfigFile(configPath)
-	err := viper.ReadInConfig()
+	cmdArgs, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Error reading config file: %v", err)
+		fatal("Error reading config", "error", err)
+	}
+
+	if err := setupLogging(viper.GetString("log.format"), viper.GetString("log.level"), viper.GetString("log.file")); err != nil {
+		fatal("Error setting up logging", "error", err)
+	}
+	if err := setupLogSampling(); err != nil {
+		fatal("Error setting up log sampling", "error", err)
+	}
+
+	if len(cmdArgs) > 0 && cmdArgs[0] == "preflight" {
+		preflightCommand(cmdArgs[1:])
+		return
 	}
 
+	if secretProviders, err = openSecrets(); err != nil {
+		fatal("Error setting up secrets providers", "error", err)
+	}
 	dbDriver := viper.GetString("database.driver")
-	dbPath := viper.GetString("database.path")
+	dbPath, err := secretProviders.Expand(context.Background(), viper.GetString("database.path"))
+	if err != nil {
+		fatal("Error resolving database.path", "error", err)
+	}
+
+	if src := viper.GetString("pipeline.script"); src != "" {
+		userScript, err = compileScript("pipeline.script", src)
+		if err != nil {
+			fatal("Error compiling pipeline script", "error", err)
+		}
+	}
 
-	store, err := db.NewDBStore(dbDriver, dbPath)
+	process, err = newProcessor()
 	if err != nil {
-		log.Fatalf("Error setting up store: %v", err)
+		fatal("Error setting up processor", "error", err)
+	}
+	if limit := viper.GetFloat64("pipeline.rate_limit"); limit > 0 {
+		process = processor.NewRateLimited(process, limit, viper.GetInt("pipeline.burst"))
+		slog.Info("Rate limiting user processing", "per_second", limit, "burst", max(viper.GetInt("pipeline.burst"), 1))
+	}
+
+	if src := viper.GetString("pipeline.shadow.script"); src != "" {
+		candidate, err := compileScript("pipeline.shadow.script", src)
+		if err != nil {
+			fatal("Error compiling shadow script", "error", err)
+		}
+		viper.SetDefault("pipeline.shadow.diff_file", "shadow-diffs.jsonl")
+		path := viper.GetString("pipeline.shadow.diff_file")
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			fatal("Error opening shadow diff file", "path", path, "error", err)
+		}
+		defer f.Close()
+		shadowRun = shadow.New(candidate.Apply, f)
+		slog.Info("Shadow mode enabled", "diff_file", path)
+	}
+
+	if viper.GetBool("chaos.enabled") {
+		slog.Warn("Chaos mode enabled: store queries and workers will fail on purpose")
+		monkey = chaos.New(chaos.Config{
+			ErrorRate:   viper.GetFloat64("chaos.error_rate"),
+			LatencyRate: viper.GetFloat64("chaos.latency_rate"),
+			MaxLatency:  viper.GetDuration("chaos.max_latency"),
+			CrashRate:   viper.GetFloat64("chaos.crash_rate"),
+			Seed:        viper.GetInt64("chaos.seed"),
+		})
 	}
 
-	Pipeline(store)
+	memory = memlimit.New(uint64(viper.GetSizeInBytes("pipeline.memory_budget")))
+	if memory != nil {
+		slog.Info("Adapting batch sizes to memory budget", "budget_mib", memory.Budget()>>20)
+	}
+	switch {
+	case viper.IsSet("pipeline.workers"):
+		pipelineWorkers = viper.GetInt("pipeline.workers")
+	case viper.IsSet("pipeline.prefetch"):
+		slog.Warn("pipeline.prefetch is deprecated; use pipeline.workers")
+		pipelineWorkers = viper.GetInt("pipeline.prefetch")
+	}
+	if pipelineWorkers < 1 {
+		fatal("pipeline.workers must be at least 1", "workers", pipelineWorkers)
+	}
+	pipelineMailboxTimeout = viper.GetDuration("pipeline.mailbox_timeout")
+	pipelineAcks = viper.GetBool("pipeline.acks.enabled")
+	if viper.IsSet("pipeline.acks.timeout") {
+		pipelineAckTimeout = viper.GetDuration("pipeline.acks.timeout")
+	}
+	pipelineBreaker = breaker.New(breaker.Config{
+		Threshold:  viper.GetFloat64("pipeline.breaker.threshold"),
+		Window:     viper.GetInt("pipeline.breaker.window"),
+		MinSamples: viper.GetInt("pipeline.breaker.min_samples"),
+		Pause:      viper.GetDuration("pipeline.breaker.pause"),
+	})
+	pipelineBudget = budget.Config{
+		MaxRate:        viper.GetFloat64("pipeline.max_failure_rate"),
+		MinUsers:       viper.GetInt("pipeline.failure_rate_min_users"),
+		MaxConsecutive: viper.GetInt("pipeline.max_consecutive_failures"),
+	}
+	if pipelineBudget.MaxRate < 0 || pipelineBudget.MaxRate > 1 {
+		fatal("pipeline.max_failure_rate must be between 0 and 1", "max_failure_rate", pipelineBudget.MaxRate)
+	}
+	if viper.GetBool("pipeline.fairness.enabled") {
+		viper.SetDefault("pipeline.fairness.lookahead", 1000)
+		tenantFairness = &fairnessConfig{}
+		if err := viper.UnmarshalKey("pipeline.fairness", tenantFairness); err != nil {
+			fatal("Error reading pipeline.fairness", "error", err)
+		}
+		if tenantFairness.Lookahead < 1 {
+			fatal("pipeline.fairness.lookahead must be at least 1", "lookahead", tenantFairness.Lookahead)
+		}
+	}
+	switch policy := viper.GetString("pipeline.on_error"); policy {
+	case "", "continue":
+	case "fail_fast":
+		pipelineFailFast = true
+	default:
+		fatal("pipeline.on_error must be continue or fail_fast", "on_error", policy)
+	}
+	viper.SetDefault("timing.top", 10)
+	if viper.IsSet("pipeline.mode") {
+		pipelineMode = viper.GetString("pipeline.mode")
+		if pipelineMode != "mailbox" && pipelineMode != "join" {
+			fatal("pipeline.mode must be mailbox or join", "mode", pipelineMode)
+		}
+	}
+	if expr := viper.GetString("pipeline.filter"); expr != "" {
+		if pipelineFilter, err = filter.Compile(expr); err != nil {
+			fatal("Error compiling pipeline.filter", "error", err)
+		}
+	}
+	if viper.IsSet("tokens.expiry_skew") {
+		tokenExpirySkew = viper.GetDuration("tokens.expiry_skew")
+	}
+
+	prov, err := newProvider()
+	if err != nil {
+		fatal("Error setting up provider", "error", err)
+	}
+	if prov != nil {
+		tokenRefresher = prov
+	}
+
+	if name := viper.GetString("slo.sink"); name != "" {
+		if sloSink, err = openSink(name); err != nil {
+			fatal("Error opening SLO sink", "sink", name, "error", err)
+		}
+	}
+	if name := viper.GetString("report.sink"); name != "" {
+		if reportSink, err = openSink(name); err != nil {
+			fatal("Error opening report sink", "sink", name, "error", err)
+		}
+	}
+
+	command, args := "run", cmdArgs
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	if dbDriver == "mysql" && !mysqlCommands[command] {
+		fatal("Command is not supported on MySQL, which is read-only", "command", command)
+	}
+	store, err := openStore(dbDriver, dbPath)
+	if err != nil {
+		fatal("Error setting up store", "error", err)
+	}
+	if ps, ok := store.(db.PartitionStore); ok {
+		ps.SetPartitionedUsers(viper.GetBool("database.partitions.enabled"))
+	}
+	if ss, ok := store.(db.StatementStore); ok {
+		viper.SetDefault("database.prepared_statements", true)
+		ss.SetPreparedStatements(viper.GetBool("database.prepared_statements"))
+	}
+	if es, ok := store.(db.EventStore); ok {
+		es.SetMailboxEvents(viper.GetBool("events.enabled"))
+	}
+	if as, ok := store.(db.AuditStore); ok {
+		as.SetAuditLog(viper.GetBool("audit.enabled"), cliActor())
+	} else if viper.GetBool("audit.enabled") {
+		fatal("Store does not keep an audit log", "driver", dbDriver)
+	}
+	if ps, ok := store.(db.ProvenanceStore); ok {
+		ps.SetProvenance(viper.GetBool("provenance.enabled"), cliSource)
+	} else if viper.GetBool("provenance.enabled") {
+		fatal("Store does not record user provenance", "driver", dbDriver)
+	}
+	if qs, ok := store.(db.QuotaStore); ok {
+		var quotas db.Quotas
+		if err := viper.UnmarshalKey("quotas.default", &quotas.Default); err != nil {
+			fatal("Error reading quotas.default", "error", err)
+		}
+		if err := viper.UnmarshalKey("quotas.tenants", &quotas.Tenants); err != nil {
+			fatal("Error reading quotas.tenants", "error", err)
+		}
+		qs.SetQuotas(quotas)
+	}
+	keyring, err := tokenKeyring(context.Background())
+	if err != nil {
+		fatal("Error loading token encryption keys", "error", err)
+	}
+	if keyring != nil {
+		ts, ok := store.(db.TokenStore)
+		if !ok {
+			fatal("Store does not support token encryption", "driver", dbDriver)
+		}
+		ts.SetTokenKeyring(keyring)
+	}
+	if processors != nil {
+		if ps, ok := store.(db.ProcessorStateStore); ok {
+			viper.SetDefault("processor.sync_interval", time.Second)
+			if err := processors.Share(context.Background(), ps, viper.GetDuration("processor.sync_interval")); err != nil {
+				fatal("Error reading the processor configuration", "error", err)
+			}
+		} else {
+			slog.Warn("Store cannot share the processor configuration; switching it reaches only serve's bulk jobs", "driver", dbDriver)
+		}
+	}
+	if rs, ok := store.(db.RetryStore); ok {
+		retries = rs
+		retryPolicy = newRetryPolicy()
+	}
+	if redisCache, err = openRedisCache(store); err != nil {
+		fatal("Error opening the Redis cache", "error", err)
+	}
+	if redisCache != nil {
+		invalidations.Subscribe(redisCache)
+	}
+	if pipelineCommands[command] {
+		if err := startSubsystems(store, prov); err != nil {
+			fatal("Required subsystem unavailable", "error", err)
+		}
+		if standby, err = openStandby(store); err != nil {
+			fatal("Error opening standby storage", "error", err)
+		}
+		if err := openEmitter(); err != nil {
+			fatal("Error setting up event publishing", "error", err)
+		}
+		if emitter != nil {
+			sources = newUserSources(store)
+		}
+		if destinations, err = newMailboxDestinations(store); err != nil {
+			fatal("Error setting up mailbox destinations", "error", err)
+		}
+		if enrichment, err = openEnrichment(); err != nil {
+			fatal("Error setting up enrichment", "error", err)
+		}
+	}
+
+	switch command {
+	case "run":
+		runCommand(store, args)
+	case "watch":
+		watchCommand(store, args)
+	case "daemon":
+		daemonCommand(store, args)
+	case "lock":
+		lockCommand(store, args)
+	case "standby":
+		standbyCommand(store, args)
+	case "worker":
+		workerCommand(store, args)
+	case "enqueue":
+		enqueueCommand(store, args)
+	case "seed":
+		seedCommand(args)
+	case "soak":
+		soakCommand(args)
+	case "export":
+		exportCommand(store, args)
+	case "import":
+		importCommand(store, args)
+	case "lint-tokens":
+		lintTokensCommand(store)
+	case "onboard":
+		onboardCommand(store, prov, args)
+	case "users":
+		usersCommand(store, args)
+	case "move":
+		moveCommand(store, args)
+	case "serve":
+		serveCommand(store, args)
+	case "grpc-serve":
+		grpcServeCommand(store, args)
+	case "changes":
+		changesCommand(store, args)
+	case "replay":
+		replayCommand(store, args)
+	case "reprocess":
+		reprocessCommand(store, args)
+	case "audit":
+		auditCommand(store, args)
+	case "snapshot":
+		snapshotCommand(store, keyring, args)
+	case "support-bundle":
+		supportBundleCommand(store, args)
+	case "partitions":
+		partitionsCommand(store, args)
+	case "stats":
+		statsCommand(store, args)
+	case "settings":
+		settingsCommand(store, args)
+	case "migrate":
+		migrateCommand(store, args)
+	case "rotate-key":
+		rotateKeyCommand(store, args)
+	case "events":
+		eventsCommand(store, args)
+	case "health":
+		healthCommand(store, prov, args)
+	default:
+		fatal("Unknown command", "command", command)
+	}
 }
@@ -0,0 +1,79 @@
+// Package memlimit adapts work sizes to memory pressure so the process backs
+// off as it approaches its memory budget instead of being OOM-killed.
+package memlimit
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+)
+
+const (
+	// Below lowWater of the budget work sizes are left alone; above
+	// highWater they drop to their minimum. In between they shrink linearly.
+	lowWater  = 0.5
+	highWater = 0.9
+)
+
+// Limiter reports memory pressure relative to a budget. A nil *Limiter
+// reports no pressure, so callers can hold one unconditionally.
+type Limiter struct {
+	budget uint64
+	used   func() uint64
+}
+
+// New returns a Limiter for budget bytes, also installing it as the runtime
+// soft memory limit so the GC works towards it. With a zero budget the
+// existing GOMEMLIMIT is used; if that is unset New returns nil.
+func New(budget uint64) *Limiter {
+	if budget > 0 {
+		debug.SetMemoryLimit(int64(min(budget, math.MaxInt64)))
+	} else {
+		limit := debug.SetMemoryLimit(-1)
+		if limit == math.MaxInt64 {
+			return nil
+		}
+		budget = uint64(limit)
+	}
+
+	return &Limiter{budget: budget, used: runtimeMemory}
+}
+
+// runtimeMemory returns the memory counted against GOMEMLIMIT: everything
+// the runtime has mapped, minus heap memory returned to the OS.
+func runtimeMemory() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+func (l *Limiter) Budget() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.budget
+}
+
+// Pressure returns memory use as a fraction of the budget.
+func (l *Limiter) Pressure() float64 {
+	if l == nil {
+		return 0
+	}
+	return float64(l.used()) / float64(l.budget)
+}
+
+// Scale shrinks n towards min as memory pressure rises.
+func (l *Limiter) Scale(n, min int) int {
+	p := l.Pressure()
+	switch {
+	case p <= lowWater:
+		return n
+	case p >= highWater:
+		return min
+	}
+
+	return max(int(float64(n)*(highWater-p)/(highWater-lowWater)), min)
+}
@@ -0,0 +1,45 @@
+package memlimit
+
+import (
+	"testing"
+)
+
+func TestLimiter_Scale(t *testing.T) {
+	tests := []struct {
+		name     string
+		used     uint64
+		expected int
+	}{
+		{name: "No pressure", used: 100, expected: 100},
+		{name: "At low water", used: 500, expected: 100},
+		{name: "Between marks", used: 700, expected: 50},
+		{name: "At high water", used: 900, expected: 10},
+		{name: "Over budget", used: 1200, expected: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Limiter{budget: 1000, used: func() uint64 { return tt.used }}
+
+			if got := l.Scale(100, 10); got != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	var l *Limiter
+	if got := l.Scale(100, 10); got != 100 {
+		t.Errorf("Expected nil Limiter not to scale, got %d", got)
+	}
+	if l.Pressure() != 0 {
+		t.Errorf("Expected nil Limiter to report no pressure")
+	}
+}
+
+func TestRuntimeMemory(t *testing.T) {
+	if runtimeMemory() == 0 {
+		t.Errorf("Expected runtime memory use to be reported")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"mailboxes/db"
+)
+
+// migrateCommand applies, reverts or lists the embedded schema migrations:
+// migrate up [--to N], migrate down [--steps N] or migrate status.
+func migrateCommand(store db.Store, args []string) {
+	ms, ok := store.(db.MigrateStore)
+	if !ok {
+		fatal("Store does not support migrations")
+	}
+	if len(args) == 0 {
+		fatal("Usage: migrate up [--to N] | down [--steps N] | status")
+	}
+
+	ctx := context.Background()
+	action, args := args[0], args[1:]
+	switch action {
+	case "up":
+		fs := flag.NewFlagSet("migrate up", flag.ExitOnError)
+		to := fs.Int("to", 0, "apply migrations up to this version (default all)")
+		fs.Parse(args)
+
+		applied, err := ms.MigrateUp(ctx, *to)
+		for _, m := range applied {
+			slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			fatal("Error applying migrations", "error", err)
+		}
+		if len(applied) == 0 {
+			slog.Info("Schema is up to date")
+		}
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		fs.Parse(args)
+
+		reverted, err := ms.MigrateDown(ctx, *steps)
+		for _, m := range reverted {
+			slog.Info("Reverted migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			fatal("Error reverting migrations", "error", err)
+		}
+	case "status":
+		status, err := ms.MigrationStatus(ctx)
+		if err != nil {
+			fatal("Error reading migration status", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range status {
+			applied := m.AppliedAt
+			if applied == "" {
+				applied = "pending"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		w.Flush()
+	default:
+		fatal("Unknown migrate action; use up, down or status", "action", action)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"mailboxes/db"
+)
+
+// moveCommand reassigns users from one mailbox to another, optionally only
+// those in a set of IDs or on an email domain. Interrupting it stops after
+// the current batch.
+func moveCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("move", flag.ExitOnError)
+	from := fs.Int("from", 0, "mailbox to move users out of")
+	to := fs.Int("to", 0, "mailbox to move users into")
+	users := fs.String("users", "", "comma-separated user IDs to move (default all)")
+	domain := fs.String("email-domain", "", "only move users with email addresses on this domain")
+	fs.Parse(args)
+
+	if *from == 0 || *to == 0 {
+		fatal("Usage: move --from <mailbox-id> --to <mailbox-id> [--users <id,...>] [--email-domain <domain>]")
+	}
+
+	reassigner, ok := store.(db.ReassignStore)
+	if !ok {
+		fatal("Store does not support moving users")
+	}
+
+	ids := map[int]bool{}
+	if *users != "" {
+		for _, s := range strings.Split(*users, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				fatal("Invalid user ID", "user_id", s, "error", err)
+			}
+			ids[id] = true
+		}
+	}
+
+	var filter func(db.User) bool
+	if len(ids) > 0 || *domain != "" {
+		suffix := "@" + strings.ToLower(*domain)
+		filter = func(user db.User) bool {
+			if len(ids) > 0 && !ids[user.ID] {
+				return false
+			}
+			return *domain == "" || strings.HasSuffix(strings.ToLower(user.EmailAddress), suffix)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	moved, err := reassigner.ReassignUsers(ctx, *from, *to, filter)
+	invalidateUsers(*from, *to)
+	slog.Info("Users moved", "users", moved, "from_mailbox_id", *from, "to_mailbox_id", *to)
+	if err != nil {
+		fatal("Error moving users", "error", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package objstore reads and writes whole objects in S3, in Google Cloud
+// Storage through its S3-compatible XML API, or in a local directory. It
+// covers what is needed to keep small state files off the instance's disk
+// and nothing more: no listing, multipart uploads or retries.
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mailboxes/awssig"
+)
+
+// ErrNotExist is returned by Get for an object that does not exist.
+var ErrNotExist = errors.New("object does not exist")
+
+// Bucket stores objects by key.
+type Bucket interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Options configure the buckets Open returns for s3:// and gs:// URLs.
+type Options struct {
+	// Endpoint replaces the service's own, for S3-compatible stores such as
+	// MinIO. Buckets are addressed by path under it.
+	Endpoint string
+	// Region defaults to us-east-1 for S3; GCS ignores it.
+	Region string
+	// AccessKeyID and SecretAccessKey are AWS access keys, or GCS HMAC
+	// keys. SessionToken is set for temporary AWS credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Client defaults to one with a 30 second timeout.
+	Client *http.Client
+}
+
+// Open returns the bucket for rawURL: s3://bucket/prefix,
+// gs://bucket/prefix or file:///dir. Keys are stored under the prefix.
+func Open(rawURL string, opts Options) (Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "file":
+		return Dir(filepath.FromSlash(u.Path)), nil
+	case "s3", "gs":
+	default:
+		return nil, fmt.Errorf("unsupported object storage URL %q: want s3://, gs:// or file://", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("object storage URL %q has no bucket", rawURL)
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("object storage needs an access key ID and secret")
+	}
+
+	b := &S3{Bucket: u.Host, Prefix: prefix, Options: opts}
+	if b.Client == nil {
+		b.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if u.Scheme == "gs" {
+		// GCS accepts S3 requests signed with HMAC keys; its region is
+		// always "auto".
+		b.Region = "auto"
+		if b.Endpoint == "" {
+			b.Endpoint = "https://storage.googleapis.com"
+		}
+	}
+	if b.Region == "" {
+		b.Region = "us-east-1"
+	}
+	if b.Endpoint == "" {
+		b.Endpoint = "https://s3." + b.Region + ".amazonaws.com"
+	}
+	return b, nil
+}
+
+// Dir is a Bucket of files in a local directory, such as a network mount.
+type Dir string
+
+// Put writes the object to a temporary file first and renames it into
+// place, so a reader never sees half of it.
+func (d Dir) Put(ctx context.Context, key string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d Dir) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return data, err
+}
+
+// S3 is a Bucket in S3 or an S3-compatible store, with requests signed with
+// AWS Signature Version 4.
+type S3 struct {
+	Bucket string
+	Prefix string
+	Options
+}
+
+func (b *S3) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := b.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (b *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (b *S3) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	if b.Prefix != "" {
+		key = b.Prefix + "/" + key
+	}
+	path := "/" + b.Bucket + "/" + key
+	u, err := url.Parse(strings.TrimSuffix(b.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = awssig.EncodePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	b.sign(req, body, time.Now())
+	return b.Client.Do(req)
+}
+
+// sign adds the Signature Version 4 Authorization header to req.
+func (b *S3) sign(req *http.Request, body []byte, t time.Time) {
+	creds := awssig.Credentials{AccessKeyID: b.AccessKeyID, SecretAccessKey: b.SecretAccessKey, SessionToken: b.SessionToken}
+	awssig.Sign(req, body, t, creds, b.Region, "s3")
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("object storage returned %s: %s", resp.Status, bytes.TrimSpace(body))
+}
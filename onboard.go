@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"mailboxes/db"
+	"mailboxes/provider"
+
+	"github.com/spf13/viper"
+)
+
+// newProvider builds the mail provider client from the provider config
+// section, or returns nil if none is configured. With provider.regions the
+// client fails over between regions, and with provider.health_interval set
+// their health endpoints are probed in the background.
+func newProvider() (provider.Provider, error) {
+	if viper.IsSet("provider.regions") {
+		var regions []provider.Region
+		if err := viper.UnmarshalKey("provider.regions", &regions); err != nil {
+			return nil, err
+		}
+		f, err := provider.NewFailover(provider.FailoverConfig{
+			Regions:      regions,
+			Pin:          viper.GetString("provider.region"),
+			Strict:       viper.GetBool("provider.strict_region"),
+			ClientID:     viper.GetString("provider.client_id"),
+			ClientSecret: viper.GetString("provider.client_secret"),
+			Timeout:      viper.GetDuration("provider.timeout"),
+			Cooldown:     viper.GetDuration("provider.cooldown"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if interval := viper.GetDuration("provider.health_interval"); interval > 0 {
+			go f.Watch(context.Background(), interval)
+		}
+		return f, nil
+	}
+
+	if !viper.IsSet("provider.token_url") {
+		return nil, nil
+	}
+	return provider.NewHTTP(provider.Config{
+		TokenURL:     viper.GetString("provider.token_url"),
+		VerifyURL:    viper.GetString("provider.verify_url"),
+		ClientID:     viper.GetString("provider.client_id"),
+		ClientSecret: viper.GetString("provider.client_secret"),
+		Timeout:      viper.GetDuration("provider.timeout"),
+	})
+}
+
+// onboardCommand creates a mailbox, exchanges its MPI ID for a token with the
+// provider, checks the token works and seeds the default settings from
+// onboard.settings. Nothing is stored unless every step succeeds.
+func onboardCommand(store db.Store, p provider.Provider, args []string) {
+	fs := flag.NewFlagSet("onboard", flag.ExitOnError)
+	mpiID := fs.String("mpi-id", "", "MPI ID of the mailbox to onboard")
+	fs.Parse(args)
+
+	if *mpiID == "" {
+		fatal("Usage: onboard --mpi-id <id>")
+	}
+	if p == nil {
+		fatal("No provider configured; set provider.token_url")
+	}
+
+	onboarder, ok := store.(db.OnboardStore)
+	if !ok {
+		fatal("Store does not support onboarding")
+	}
+
+	handshake := func(mb db.Mailbox) (string, error) {
+		tok, err := p.Exchange(mb.MPIID)
+		if err != nil {
+			return "", fmt.Errorf("token exchange: %w", err)
+		}
+		if err := p.Verify(tok); err != nil {
+			return "", fmt.Errorf("verifying connectivity: %w", err)
+		}
+		return tok, nil
+	}
+
+	mb, err := onboarder.OnboardMailbox(context.Background(), *mpiID, viper.GetStringMapString("onboard.settings"), handshake)
+	if err != nil {
+		fatal("Error onboarding", "mpi_id", *mpiID, "error", err)
+	}
+	invalidateMailboxes()
+	slog.Info("Mailbox onboarded", "mailbox_id", mb.ID, "mpi_id", mb.MPIID)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"time"
+
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// partitionsCommand creates the monthly users partitions for the current
+// month and the next --ahead months. It is safe to run repeatedly, e.g. from
+// cron, and does nothing for partitions that already exist.
+func partitionsCommand(store db.Store, args []string) {
+	viper.SetDefault("database.partitions.ahead", 3)
+
+	fs := flag.NewFlagSet("partitions", flag.ExitOnError)
+	ahead := fs.Int("ahead", viper.GetInt("database.partitions.ahead"), "how many months past the current one to create")
+	fs.Parse(args)
+
+	ps, ok := store.(db.PartitionStore)
+	if !ok {
+		fatal("Store does not support partitioning")
+	}
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	created, err := ps.EnsureUserPartitions(context.Background(), month, month.AddDate(0, *ahead, 0))
+	if err != nil {
+		fatal("Error creating partitions", "error", err)
+	}
+	for _, name := range created {
+		slog.Info("Created partition", "partition", name)
+	}
+	slog.Info("Partitions created", "partitions", len(created))
+}
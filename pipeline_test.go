@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mailboxes/annotations"
+	"mailboxes/breaker"
+	"mailboxes/budget"
+	"mailboxes/db"
+	"mailboxes/processor"
+)
+
+// setGlobal sets *v to val for the rest of the test.
+func setGlobal[T any](t *testing.T, v *T, val T) {
+	t.Helper()
+	old := *v
+	*v = val
+	t.Cleanup(func() { *v = old })
+}
+
+// seedPipeline returns a MemStore with n mailboxes of one user each.
+func seedPipeline(n int) *db.MemStore {
+	store := db.NewMemStore()
+	for i := 1; i <= n; i++ {
+		store.SeedMailboxes(db.Mailbox{ID: i})
+		store.SeedUsers(db.User{ID: 100 + i, MailboxID: i})
+	}
+	return store
+}
+
+// concurrency records the users a processor is given and the most it was
+// given at once.
+type concurrency struct {
+	mu        sync.Mutex
+	active    int
+	max       int
+	processed map[int]int
+}
+
+func (c *concurrency) process(delay time.Duration) processor.Func {
+	return func(ctx context.Context, user db.User) error {
+		c.mu.Lock()
+		c.active++
+		c.max = max(c.max, c.active)
+		if c.processed == nil {
+			c.processed = make(map[int]int)
+		}
+		c.processed[user.ID]++
+		c.mu.Unlock()
+
+		time.Sleep(delay)
+
+		c.mu.Lock()
+		c.active--
+		c.mu.Unlock()
+		return nil
+	}
+}
+
+func TestPipeline_Workers(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		mailboxes int
+	}{
+		{name: "One worker", workers: 1, mailboxes: 5},
+		{name: "Fewer mailboxes than workers", workers: 8, mailboxes: 3},
+		{name: "More mailboxes than workers", workers: 3, mailboxes: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c concurrency
+			setGlobal(t, &pipelineWorkers, tt.workers)
+			setGlobal[processor.Processor](t, &process, c.process(5*time.Millisecond))
+
+			if err := Pipeline(context.Background(), seedPipeline(tt.mailboxes)); err != nil {
+				t.Fatalf("Pipeline() error = %v", err)
+			}
+
+			if c.max > tt.workers {
+				t.Errorf("processed %d mailboxes at once, want at most %d", c.max, tt.workers)
+			}
+			if want := min(tt.workers, tt.mailboxes); want > 1 && c.max < 2 {
+				t.Errorf("processed %d mailboxes at once, want up to %d", c.max, want)
+			}
+			if len(c.processed) != tt.mailboxes {
+				t.Errorf("processed %d users, want %d", len(c.processed), tt.mailboxes)
+			}
+			for id, n := range c.processed {
+				if n != 1 {
+					t.Errorf("user %d processed %d times, want once", id, n)
+				}
+			}
+		})
+	}
+}
+
+var errRejected = errors.New("rejected")
+
+// failMailboxes returns a processor that fails every user of the given
+// mailboxes, and records the mailboxes whose users it was given.
+func failMailboxes(seen *sync.Map, fail ...int) processor.Func {
+	return func(ctx context.Context, user db.User) error {
+		seen.Store(user.MailboxID, true)
+		if slices.Contains(fail, user.MailboxID) {
+			return fmt.Errorf("user %d: %w", user.ID, errRejected)
+		}
+		return nil
+	}
+}
+
+func TestPipeline_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     []int
+		failFast bool
+		budget   budget.Config
+		// wantErrs are the mailboxes the returned error must name.
+		wantErrs []int
+		// wantExceeded is whether the run must fail with the budget.
+		wantExceeded bool
+		// maxSeen is how many mailboxes may be handed to the processor.
+		maxSeen int
+	}{
+		{name: "No failures", maxSeen: 6},
+		{name: "Continue on error", fail: []int{2, 4}, wantErrs: []int{2, 4}, maxSeen: 6},
+		{name: "Fail fast", fail: []int{2}, failFast: true, wantErrs: []int{2}, maxSeen: 2},
+		{name: "Budget exceeded", fail: []int{1, 2, 3, 4, 5, 6}, budget: budget.Config{MaxConsecutive: 3}, wantErrs: []int{1, 2, 3}, wantExceeded: true, maxSeen: 3},
+		{name: "Budget not exceeded", fail: []int{1, 3, 5}, budget: budget.Config{MaxConsecutive: 2}, wantErrs: []int{1, 3, 5}, maxSeen: 6},
+		{name: "Failure rate exceeded", fail: []int{2, 3, 4, 5, 6}, budget: budget.Config{MaxRate: 0.5, MinUsers: 4}, wantErrs: []int{2, 3, 4}, wantExceeded: true, maxSeen: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen sync.Map
+			// One worker takes the mailboxes in order, so the failures are
+			// met in a known order.
+			setGlobal(t, &pipelineWorkers, 1)
+			setGlobal(t, &pipelineFailFast, tt.failFast)
+			setGlobal(t, &pipelineBudget, tt.budget)
+			setGlobal[processor.Processor](t, &process, failMailboxes(&seen, tt.fail...))
+
+			err := Pipeline(context.Background(), seedPipeline(6))
+
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Pipeline() error = %v, want nil", err)
+				}
+			} else if !errors.Is(err, errRejected) {
+				t.Fatalf("Pipeline() error = %v, want the processor's error", err)
+			}
+			for _, id := range tt.wantErrs {
+				if want := fmt.Sprintf("mailbox %d:", id); !strings.Contains(fmt.Sprint(err), want) {
+					t.Errorf("Pipeline() error = %v, want it to name mailbox %d", err, id)
+				}
+			}
+			if got := errors.Is(err, budget.ErrExceeded); got != tt.wantExceeded {
+				t.Errorf("errors.Is(err, budget.ErrExceeded) = %v, want %v", got, tt.wantExceeded)
+			}
+
+			n := 0
+			seen.Range(func(any, any) bool { n++; return true })
+			if n > tt.maxSeen {
+				t.Errorf("processed users of %d mailboxes, want at most %d", n, tt.maxSeen)
+			}
+		})
+	}
+}
+
+func TestPipeline_ResumeFromCheckpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		resume bool
+		// expected are the users the second run processes.
+		expected []int
+	}{
+		// Mailboxes 1 and 2 were completed and user 103 of mailbox 3 was
+		// processed before the first run was interrupted.
+		{name: "Resume", resume: true, expected: []int{104, 105, 106, 107}},
+		// The daemon starts every run afresh.
+		{name: "Start afresh", resume: false, expected: []int{101, 102, 103, 104, 105, 106, 107}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &pipelineWorkers, 1)
+			setGlobal(t, &checkpoint, nil)
+			setGlobal(t, &runFailures, nil)
+			store := seedPipeline(6)
+			store.SeedUsers(db.User{ID: 107, MailboxID: 3})
+
+			// The first run is interrupted once the first user of mailbox
+			// 3 is processed.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			setGlobal[processor.Processor](t, &process, processor.Func(func(ctx context.Context, user db.User) error {
+				if user.ID == 103 {
+					cancel()
+				}
+				return nil
+			}))
+			if err := setupCheckpoint(ctx, store, false); err != nil {
+				t.Fatalf("setupCheckpoint() error = %v", err)
+			}
+			runPipeline(ctx, store, &annotations.Set{})
+
+			var mu sync.Mutex
+			var processed []int
+			process = processor.Func(func(ctx context.Context, user db.User) error {
+				mu.Lock()
+				processed = append(processed, user.ID)
+				mu.Unlock()
+				return nil
+			})
+			if err := setupCheckpoint(context.Background(), store, tt.resume); err != nil {
+				t.Fatalf("setupCheckpoint() error = %v", err)
+			}
+			if err := runPipeline(context.Background(), store, &annotations.Set{}); err != nil {
+				t.Fatalf("runPipeline() error = %v", err)
+			}
+
+			slices.Sort(processed)
+			if !slices.Equal(processed, tt.expected) {
+				t.Errorf("processed users %v, want %v", processed, tt.expected)
+			}
+			done, err := store.LoadCheckpoint(context.Background())
+			if err != nil {
+				t.Fatalf("LoadCheckpoint() error = %v", err)
+			}
+			if len(done.Mailboxes) != 0 || len(done.Users) != 0 {
+				t.Errorf("checkpoint = %+v after a completed run, want it cleared", done)
+			}
+		})
+	}
+}
+
+func TestPipeline_MailboxTimeout(t *testing.T) {
+	setGlobal(t, &pipelineWorkers, 2)
+	setGlobal(t, &pipelineMailboxTimeout, 50*time.Millisecond)
+	store := seedPipeline(4)
+	store.SeedUsers(db.User{ID: 105, MailboxID: 2})
+
+	// The first user of mailbox 2 outlasts the timeout, as a sink that
+	// ignores cancellation would.
+	var seen sync.Map
+	setGlobal[processor.Processor](t, &process, processor.Func(func(ctx context.Context, user db.User) error {
+		seen.Store(user.ID, true)
+		if user.ID == 102 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return nil
+	}))
+
+	err := Pipeline(context.Background(), store)
+
+	if !errors.Is(err, errMailboxTimeout) || !strings.Contains(err.Error(), "mailbox 2:") {
+		t.Fatalf("Pipeline() error = %v, want mailbox 2 timed out", err)
+	}
+	if strings.Contains(err.Error(), "\n") {
+		t.Errorf("Pipeline() error = %v, want only mailbox 2 to fail", err)
+	}
+	for _, id := range []int{101, 102, 103, 104} {
+		if _, ok := seen.Load(id); !ok {
+			t.Errorf("user %d was not processed; the run should go on past the slow mailbox", id)
+		}
+	}
+	if _, ok := seen.Load(105); ok {
+		t.Errorf("user 105 was processed after its mailbox timed out")
+	}
+}
+
+func TestPipeline_Breaker(t *testing.T) {
+	const pause = 50 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		fail          []int
+		expectedTrips int
+	}{
+		{name: "Below the threshold", fail: []int{1, 5}, expectedTrips: 0},
+		{name: "At the threshold", fail: []int{1, 2, 3, 4}, expectedTrips: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := breaker.New(breaker.Config{Threshold: 0.5, Window: 4, MinSamples: 4, Pause: pause})
+			setGlobal(t, &pipelineWorkers, 1)
+			setGlobal(t, &pipelineBreaker, b)
+
+			var mu sync.Mutex
+			at := make(map[int]time.Time)
+			setGlobal[processor.Processor](t, &process, processor.Func(func(ctx context.Context, user db.User) error {
+				mu.Lock()
+				at[user.MailboxID] = time.Now()
+				mu.Unlock()
+				if slices.Contains(tt.fail, user.MailboxID) {
+					return errRejected
+				}
+				return nil
+			}))
+
+			Pipeline(context.Background(), seedPipeline(8))
+
+			if got := b.Trips(); got != tt.expectedTrips {
+				t.Errorf("Trips() = %d, want %d", got, tt.expectedTrips)
+			}
+			if len(at) != 8 {
+				t.Errorf("processed users of %d mailboxes, want every one of 8", len(at))
+			}
+			// Mailbox 5 is the first after four outcomes.
+			paused := at[5].Sub(at[4]) >= pause
+			if paused != (tt.expectedTrips > 0) {
+				t.Errorf("mailbox 5 started %s after mailbox 4, want a pause of %s only if the breaker tripped", at[5].Sub(at[4]), pause)
+			}
+		})
+	}
+}
+
+func TestPipeline_ProcessorSwitch(t *testing.T) {
+	opened, err := db.NewSQLiteStore("sqlite3", db.SQLiteConfig{Path: filepath.Join(t.TempDir(), "mailboxes.db")})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	shared := opened.(*db.DBStore)
+	ctx := context.Background()
+	if _, err := shared.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+
+	// serve is another process's switch; the run's switches to green once
+	// serve does, at its next mailbox.
+	var used []string
+	var serve *processor.Switch
+	procs := map[string]processor.Processor{
+		"blue": processor.Func(func(ctx context.Context, user db.User) error {
+			used = append(used, "blue")
+			return serve.Use(ctx, "green")
+		}),
+		"green": processor.Func(func(ctx context.Context, user db.User) error {
+			used = append(used, "green")
+			return nil
+		}),
+	}
+	serve, _ = processor.NewSwitch(procs, "blue")
+	run, _ := processor.NewSwitch(procs, "blue")
+	for _, sw := range []*processor.Switch{serve, run} {
+		if err := sw.Share(ctx, shared, 0); err != nil {
+			t.Fatalf("Error sharing switch: %v", err)
+		}
+	}
+	setGlobal(t, &pipelineWorkers, 1)
+	setGlobal(t, &processors, run)
+	setGlobal[processor.Processor](t, &process, run)
+
+	if err := Pipeline(ctx, seedPipeline(3)); err != nil {
+		t.Fatalf("Pipeline() = %v", err)
+	}
+	if !slices.Equal(used, []string{"blue", "green", "green"}) {
+		t.Errorf("Expected green from the second mailbox on, got %v", used)
+	}
+}
+
+func TestDrainEvery(t *testing.T) {
+	store := seedPipeline(3)
+	ctx := context.Background()
+	store.ScheduleRetry(ctx, db.Retry{User: db.User{ID: 101}, Attempts: 1, NextAttemptAt: time.Now().Add(-time.Second)})
+	store.ScheduleRetry(ctx, db.Retry{User: db.User{ID: 102}, Attempts: 1, NextAttemptAt: time.Now().Add(time.Hour)})
+	store.EnqueueUser(ctx, 103)
+
+	handled := make(chan int, 3)
+	setGlobal[processor.Processor](t, &process, processor.Func(func(ctx context.Context, user db.User) error {
+		handled <- user.ID
+		return nil
+	}))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		drainEvery(ctx, store, store, 10*time.Millisecond, 10*time.Millisecond, stop)
+	}()
+
+	var got []int
+	for len(got) < 2 {
+		select {
+		case id := <-handled:
+			got = append(got, id)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the queued user and the due retry to be handled, got %v", got)
+		}
+	}
+	close(stop)
+	<-done
+
+	slices.Sort(got)
+	if !slices.Equal(got, []int{101, 103}) {
+		t.Errorf("Expected users 101 and 103, got %v", got)
+	}
+	select {
+	case id := <-handled:
+		t.Errorf("Expected only the queued user and the due retry, got user %d too", id)
+	default:
+	}
+}
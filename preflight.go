@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/filter"
+	"mailboxes/processor"
+	"mailboxes/subsystem"
+
+	"github.com/spf13/viper"
+)
+
+// preflightReport is what preflight prints: whether every check passed, and
+// the outcome of each.
+type preflightReport struct {
+	Passed bool             `json:"passed"`
+	Checks []preflightCheck `json:"checks"`
+}
+
+// preflightCheck is the outcome of one check: pass, fail or skip, with what
+// was found or why it failed or did not apply.
+type preflightCheck struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Detail   string  `json:"detail,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// notApplicable is returned by a check that does not apply to the
+// configuration, with the reason.
+type notApplicable string
+
+func (n notApplicable) Error() string { return string(n) }
+
+// preflight runs checks one after another, each within timeout, and
+// collects their outcomes.
+type preflight struct {
+	timeout time.Duration
+	report  preflightReport
+}
+
+// check runs one check and records its outcome. It reports whether the
+// check passed, so that the checks depending on it can be skipped.
+func (p *preflight) check(name string, f func(ctx context.Context) (string, error)) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	started := time.Now()
+	detail, err := f(ctx)
+	c := preflightCheck{Name: name, Status: "pass", Detail: detail, Duration: time.Since(started).Seconds()}
+	var na notApplicable
+	switch {
+	case errors.As(err, &na):
+		c.Status, c.Detail = "skip", na.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		c.Status, c.Detail = "fail", fmt.Sprintf("no answer within %s", p.timeout)
+	case err != nil:
+		c.Status, c.Detail = "fail", err.Error()
+	}
+	p.report.Checks = append(p.report.Checks, c)
+	return err == nil
+}
+
+// skip records a check that could not run because one it depends on
+// failed.
+func (p *preflight) skip(name, reason string) {
+	p.report.Checks = append(p.report.Checks, preflightCheck{Name: name, Status: "skip", Detail: reason})
+}
+
+// preflightCommand checks that everything a run needs is in place, without
+// processing anyone: the configuration, the secrets it references, the
+// database and its schema, the clocks and the endpoints users are sent to.
+// It prints a JSON report and exits non-zero if any check failed, to gate
+// deployments such as Kubernetes init containers.
+func preflightCommand(args []string) {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 5*time.Second, "how long each check may take")
+	maxSkew := fs.Duration("max-clock-skew", 2*time.Second, "how far the database clock may be from this host's")
+	fs.Parse(args)
+
+	p := &preflight{timeout: *timeout}
+	var proc processor.Processor
+	configOK := p.check("config", func(ctx context.Context) (string, error) {
+		var err error
+		proc, err = checkConfig()
+		if err != nil {
+			return "", err
+		}
+		if file := viper.ConfigFileUsed(); file != "" {
+			if _, err := os.Stat(file); err == nil {
+				return file, nil
+			}
+		}
+		return "no config file; environment and flags only", nil
+	})
+
+	driver := viper.GetString("database.driver")
+	var path string
+	secretsOK := p.check("secrets", func(ctx context.Context) (string, error) {
+		var err error
+		if secretProviders, err = openSecrets(); err != nil {
+			return "", err
+		}
+		if path, err = secretProviders.Expand(ctx, viper.GetString("database.path")); err != nil {
+			return "", fmt.Errorf("database.path: %w", err)
+		}
+		keyring, err := tokenKeyring(ctx)
+		if err != nil {
+			return "", fmt.Errorf("tokens.encryption: %w", err)
+		}
+		names := make([]string, 0, len(secretProviders))
+		for name := range secretProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		detail := "providers: " + strings.Join(names, ", ")
+		if len(names) == 0 {
+			detail = "no providers configured"
+		}
+		if keyring != nil {
+			detail += "; token keys loaded"
+		}
+		return detail, nil
+	})
+
+	var store db.Store
+	databaseOK := secretsOK && p.check("database", func(ctx context.Context) (string, error) {
+		var err error
+		if store, err = openStore(driver, path); err != nil {
+			return "", err
+		}
+		if pinger, ok := store.(subsystem.Pinger); ok {
+			if err := pinger.Ping(ctx); err != nil {
+				return "", err
+			}
+		}
+		return "driver " + driver, nil
+	})
+	if databaseOK {
+		ps, _ := store.(db.PreflightStore)
+		p.check("database_write", func(ctx context.Context) (string, error) {
+			if ps == nil {
+				return "", notApplicable("store cannot check its permissions")
+			}
+			return "", ps.CheckWritable(ctx)
+		})
+		p.check("schema", func(ctx context.Context) (string, error) {
+			return checkSchema(ctx, store)
+		})
+		p.check("clock_skew", func(ctx context.Context) (string, error) {
+			if driver == "sqlite3" || driver == "sqlite" {
+				return "", notApplicable("SQLite uses this host's clock")
+			}
+			if ps == nil {
+				return "", notApplicable("store cannot report its time")
+			}
+			return checkClockSkew(ctx, ps, *maxSkew)
+		})
+	} else if secretsOK {
+		for _, name := range []string{"database_write", "schema", "clock_skew"} {
+			p.skip(name, "database unavailable")
+		}
+	} else {
+		for _, name := range []string{"database", "database_write", "schema", "clock_skew"} {
+			p.skip(name, "database.path unresolved")
+		}
+	}
+
+	if configOK {
+		p.check("processor", func(ctx context.Context) (string, error) {
+			pinger, ok := proc.(subsystem.Pinger)
+			if !ok {
+				return "", notApplicable("processor has no endpoint to check")
+			}
+			return "", pinger.Ping(ctx)
+		})
+	} else {
+		p.skip("processor", "configuration invalid")
+	}
+	var names []string
+	for name := range viper.GetStringMap("sinks") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.check("sink:"+name, func(ctx context.Context) (string, error) {
+			s, err := openSink(name)
+			if err != nil {
+				return "", err
+			}
+			pinger, ok := s.(subsystem.Pinger)
+			if !ok {
+				return "", notApplicable("sink writes to stdout")
+			}
+			return "", pinger.Ping(ctx)
+		})
+	}
+
+	p.report.Passed = true
+	for _, c := range p.report.Checks {
+		if c.Status == "fail" {
+			p.report.Passed = false
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(p.report)
+	if !p.report.Passed {
+		os.Exit(1)
+	}
+}
+
+// checkConfig validates the settings a run would otherwise stop on, and
+// returns the processor configured.
+func checkConfig() (processor.Processor, error) {
+	if viper.GetString("database.driver") == "" {
+		return nil, errors.New("database.driver is not set")
+	}
+	for _, key := range []string{"pipeline.script", "pipeline.shadow.script"} {
+		if src := viper.GetString(key); src != "" {
+			if _, err := compileScript(key, src); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if expr := viper.GetString("pipeline.filter"); expr != "" {
+		if _, err := filter.Compile(expr); err != nil {
+			return nil, err
+		}
+	}
+	if viper.IsSet("pipeline.workers") && viper.GetInt("pipeline.workers") < 1 {
+		return nil, errors.New("pipeline.workers must be at least 1")
+	}
+	if rate := viper.GetFloat64("pipeline.max_failure_rate"); rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("pipeline.max_failure_rate must be between 0 and 1, not %g", rate)
+	}
+	if policy := viper.GetString("pipeline.on_error"); policy != "" && policy != "continue" && policy != "fail_fast" {
+		return nil, fmt.Errorf("pipeline.on_error must be continue or fail_fast, not %q", policy)
+	}
+	if mode := viper.GetString("pipeline.mode"); mode != "" && mode != "mailbox" && mode != "join" {
+		return nil, fmt.Errorf("pipeline.mode must be mailbox or join, not %q", mode)
+	}
+	if _, err := newProvider(); err != nil {
+		return nil, fmt.Errorf("provider: %w", err)
+	}
+	proc, err := newProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("processor: %w", err)
+	}
+	return proc, nil
+}
+
+// checkSchema fails if any migration is pending.
+func checkSchema(ctx context.Context, store db.Store) (string, error) {
+	ms, ok := store.(db.MigrateStore)
+	if !ok {
+		return "", notApplicable("store has no migrations")
+	}
+	status, err := ms.MigrationStatus(ctx)
+	if err != nil {
+		return "", err
+	}
+	version := 0
+	var pending []string
+	for _, m := range status {
+		if m.AppliedAt == "" {
+			pending = append(pending, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		} else {
+			version = max(version, m.Version)
+		}
+	}
+	if len(pending) > 0 {
+		return "", fmt.Errorf("migrations pending, run migrate up: %s", strings.Join(pending, ", "))
+	}
+	return fmt.Sprintf("version %d", version), nil
+}
+
+// checkClockSkew fails if the database clock is more than maxSkew from this
+// host's, measured against the middle of the round trip.
+func checkClockSkew(ctx context.Context, ps db.PreflightStore, maxSkew time.Duration) (string, error) {
+	sent := time.Now()
+	server, err := ps.ServerTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	rtt := time.Since(sent)
+	skew := server.Sub(sent.Add(rtt / 2))
+	detail := fmt.Sprintf("database clock %s from this host's", skew.Round(time.Millisecond))
+	if skew.Abs() > maxSkew {
+		return "", fmt.Errorf("%s, more than %s", detail, maxSkew)
+	}
+	return detail, nil
+}
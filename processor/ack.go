@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Ack is the acknowledgment of one user handed to a processor. Returning
+// nil from Process acknowledges the user, unless the processor deferred the
+// acknowledgment with Defer: then the user only counts as received once the
+// processor confirms that downstream has it, and the pipeline holds back its
+// checkpoint until then.
+type Ack struct {
+	deferred atomic.Bool
+	once     sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// NewAck returns an Ack for one user.
+func NewAck() *Ack {
+	return &Ack{done: make(chan struct{})}
+}
+
+type ackKey struct{}
+
+// WithAck returns a context carrying a, for processing the user a is for.
+func WithAck(ctx context.Context, a *Ack) context.Context {
+	return context.WithValue(ctx, ackKey{}, a)
+}
+
+// Defer defers the acknowledgment of the user being processed under ctx.
+// The processor must call confirm exactly once, with nil once downstream
+// has durably received the user or with the reason it never will. Defer
+// reports false if ctx carries no Ack; the processor must then wait for
+// downstream before returning from Process.
+func Defer(ctx context.Context) (confirm func(err error), ok bool) {
+	a, _ := ctx.Value(ackKey{}).(*Ack)
+	if a == nil {
+		return nil, false
+	}
+	a.deferred.Store(true)
+	return a.confirm, true
+}
+
+func (a *Ack) confirm(err error) {
+	a.once.Do(func() {
+		a.err = err
+		close(a.done)
+	})
+}
+
+// Deferred reports whether the processor deferred the acknowledgment.
+func (a *Ack) Deferred() bool {
+	return a.deferred.Load()
+}
+
+// Wait blocks until the processor confirms the user, and returns the error
+// it confirmed with, or ctx's error if ctx is done first.
+func (a *Ack) Wait(ctx context.Context) error {
+	select {
+	case <-a.done:
+		return a.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
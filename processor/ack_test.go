@@ -0,0 +1,36 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAck(t *testing.T) {
+	if _, ok := Defer(context.Background()); ok {
+		t.Errorf("Expected no deferral without an Ack")
+	}
+
+	a := NewAck()
+	confirm, ok := Defer(WithAck(context.Background(), a))
+	if !ok || !a.Deferred() {
+		t.Fatalf("Expected the acknowledgment to be deferred")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected to time out before confirmation, got %v", err)
+	}
+
+	boom := errors.New("broker down")
+	go confirm(boom)
+	if err := a.Wait(context.Background()); !errors.Is(err, boom) {
+		t.Errorf("Expected the confirmed error, got %v", err)
+	}
+	confirm(nil)
+	if err := a.Wait(context.Background()); !errors.Is(err, boom) {
+		t.Errorf("Expected the first confirmation to stand, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package processor
+
+import "context"
+
+// Destination overrides where the webhook sends the users of one mailbox
+// and how it authenticates to it, for customers who receive users at their
+// own endpoint. Empty fields keep the webhook's own settings.
+type Destination struct {
+	URL string
+	// Secret signs requests in place of the webhook's secret.
+	Secret string
+	// Authorization is sent as the Authorization header.
+	Authorization string
+}
+
+type destinationKey struct{}
+
+// WithDestination returns a context carrying d, for processing a user of
+// the mailbox d is for.
+func WithDestination(ctx context.Context, d Destination) context.Context {
+	return context.WithValue(ctx, destinationKey{}, d)
+}
+
+// DestinationFrom returns the Destination ctx carries, if any.
+func DestinationFrom(ctx context.Context) (Destination, bool) {
+	d, ok := ctx.Value(destinationKey{}).(Destination)
+	return d, ok
+}
@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"context"
+
+	"mailboxes/db"
+)
+
+// Enriched is a user together with the fields an enrichment source holds
+// for it. It serializes as the user's own fields plus enrichment, so
+// receivers that ignore unknown fields see the user as before.
+type Enriched struct {
+	db.User
+	Enrichment map[string]string `json:"enrichment,omitempty"`
+}
+
+type enrichmentKey struct{}
+
+// WithEnrichment returns a context carrying fields, the enrichment of the
+// user about to be processed.
+func WithEnrichment(ctx context.Context, fields map[string]string) context.Context {
+	return context.WithValue(ctx, enrichmentKey{}, fields)
+}
+
+// EnrichmentFrom returns the enrichment fields ctx carries, if any.
+func EnrichmentFrom(ctx context.Context) map[string]string {
+	fields, _ := ctx.Value(enrichmentKey{}).(map[string]string)
+	return fields
+}
+
+// enriched returns user with the enrichment ctx carries, for processors to
+// send or render in place of the bare user.
+func enriched(ctx context.Context, user db.User) Enriched {
+	return Enriched{User: user, Enrichment: EnrichmentFrom(ctx)}
+}
@@ -0,0 +1,115 @@
+// Package processor holds the strategies users can be processed with. The
+// pipeline builds the processor registered under processor.kind, so a
+// deployment can switch from logging users to calling a webhook or sending
+// mail without code changes.
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"mailboxes/db"
+)
+
+// Processor handles one user. Users it fails are retried, so a processor
+// may see a user more than once. A processor can attach annotations to the
+// run with annotations.Annotate(ctx, key, value).
+type Processor interface {
+	Process(ctx context.Context, user db.User) error
+}
+
+// PermanentError marks a failure that would recur on every attempt, such as
+// a receiver rejecting the user as invalid. Users failed with one are not
+// retried.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// IsPermanent reports whether err is or wraps a PermanentError.
+func IsPermanent(err error) bool {
+	var perm *PermanentError
+	return errors.As(err, &perm)
+}
+
+// Func adapts an ordinary function to a Processor.
+type Func func(ctx context.Context, user db.User) error
+
+func (f Func) Process(ctx context.Context, user db.User) error { return f(ctx, user) }
+
+// Settings are the string values configured under processor.settings.
+type Settings map[string]string
+
+// Factory builds a processor from its settings.
+type Factory func(settings Settings) (Processor, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+func init() {
+	Register("log", func(Settings) (Processor, error) { return Log{}, nil })
+	Register("webhook", NewWebhook)
+	Register("smtp", NewSMTP)
+}
+
+// Register makes a processor available by name, replacing any factory
+// previously registered under the same name.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = f
+}
+
+// New builds the processor registered under name. An empty name selects Log.
+func New(name string, settings Settings) (Processor, error) {
+	if name == "" {
+		name = "log"
+	}
+
+	mu.RLock()
+	f, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown processor %q", name)
+	}
+
+	p, err := f(settings)
+	if err != nil {
+		return nil, fmt.Errorf("processor %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// Names returns the registered processor names in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Log only logs each user. Mailbox tokens are never logged.
+type Log struct{}
+
+func (Log) Process(ctx context.Context, user db.User) error {
+	args := []any{"component", "processor", "user_id", user.ID, "mailbox_id", user.MailboxID, "user_name", user.UserName, "mailbox_token", "<fake_token>"}
+	if fields := EnrichmentFrom(ctx); fields != nil {
+		args = append(args, "enrichment", fields)
+	}
+	slog.InfoContext(ctx, "Processing user", args...)
+	return nil
+}
@@ -0,0 +1,420 @@
+package processor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+)
+
+var user = db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com"}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		kind        string
+		settings    Settings
+		expectedErr string
+	}{
+		{name: "Default", kind: ""},
+		{name: "Log", kind: "log"},
+		{name: "Webhook", kind: "webhook", settings: Settings{"url": "http://example.com/hook"}},
+		{name: "Webhook without URL", kind: "webhook", expectedErr: "processor webhook: url is not configured"},
+		{name: "SMTP without from", kind: "smtp", settings: Settings{"addr": "localhost:25"}, expectedErr: "processor smtp: from is not configured"},
+		{name: "Unknown", kind: "carrier-pigeon", expectedErr: `unknown processor "carrier-pigeon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.kind, tt.settings)
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Fatalf("Expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil || p == nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	if names := Names(); !reflect.DeepEqual(names, []string{"log", "smtp", "webhook"}) {
+		t.Errorf("Unexpected names %v", names)
+	}
+}
+
+func TestWebhook_Process(t *testing.T) {
+	var received db.User
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Error decoding payload: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": srv.URL, "timeout": "1s"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	if err := p.Process(context.Background(), user); err != nil {
+		t.Fatalf("Error processing user: %v", err)
+	}
+	if !reflect.DeepEqual(received, user) {
+		t.Errorf("Expected %+v, got %+v", user, received)
+	}
+}
+
+func TestWebhook_ProcessRedacted(t *testing.T) {
+	var received map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Error decoding payload: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": srv.URL, "exclude": "created_at, updated_at, user_name", "mask": "email_address"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	if err := p.Process(context.Background(), user); err != nil {
+		t.Fatalf("Error processing user: %v", err)
+	}
+	expected := map[string]any{"id": float64(user.ID), "mailbox_id": float64(user.MailboxID), "email_address": "u***@example.com"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, got %v", expected, received)
+	}
+}
+
+func TestWebhook_ProcessSigned(t *testing.T) {
+	var timestamp, signature string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, signature = r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": srv.URL, "secret": "s3cret"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	p.(*Webhook).now = func() time.Time { return time.Unix(1700000000, 0) }
+	if err := p.Process(context.Background(), user); err != nil {
+		t.Fatalf("Error processing user: %v", err)
+	}
+	if timestamp != "1700000000" {
+		t.Errorf("Expected the send time, got %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != expected {
+		t.Errorf("Expected signature %s, got %s", expected, signature)
+	}
+}
+
+func TestWebhook_ProcessDestination(t *testing.T) {
+	global := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the user to be sent to the mailbox's destination")
+	}))
+	defer global.Close()
+	var authorization, signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, signature = r.Header.Get("Authorization"), r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": global.URL, "secret": "s3cret"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	p.(*Webhook).now = func() time.Time { return time.Unix(1700000000, 0) }
+	ctx := WithDestination(context.Background(), Destination{URL: srv.URL, Secret: "customer", Authorization: "Bearer t0ken"})
+	if err := p.Process(ctx, user); err != nil {
+		t.Fatalf("Error processing user: %v", err)
+	}
+	if authorization != "Bearer t0ken" {
+		t.Errorf("Expected the destination's authorization, got %q", authorization)
+	}
+	body, _ := json.Marshal(user)
+	if expected := Sign([]byte("customer"), "1700000000", body); signature != expected {
+		t.Errorf("Expected a signature with the destination's secret %s, got %s", expected, signature)
+	}
+}
+
+func TestWebhook_ProcessEnriched(t *testing.T) {
+	var received map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Error decoding payload: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": srv.URL, "include": "id, enrichment"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	ctx := WithEnrichment(context.Background(), map[string]string{"crm_account_id": "acct-7"})
+	if err := p.Process(ctx, user); err != nil {
+		t.Fatalf("Error processing user: %v", err)
+	}
+	expected := map[string]any{"id": float64(user.ID), "enrichment": map[string]any{"crm_account_id": "acct-7"}}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, got %v", expected, received)
+	}
+}
+
+func TestWebhook_ProcessRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		expectedCalls int
+		permanent     bool
+		fails         bool
+	}{
+		{name: "Retried 5xx", statuses: []int{503, 502, 200}, expectedCalls: 3},
+		{name: "Retried 429", statuses: []int{429, 200}, expectedCalls: 2},
+		{name: "Retries used up", statuses: []int{500, 500, 500, 200}, expectedCalls: 3, fails: true},
+		{name: "Rejected 4xx", statuses: []int{422, 200}, expectedCalls: 1, fails: true, permanent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls.Add(1)-1])
+			}))
+			defer srv.Close()
+
+			p, err := New("webhook", Settings{"url": srv.URL, "retries": "2", "backoff": "1ms"})
+			if err != nil {
+				t.Fatalf("Error creating processor: %v", err)
+			}
+			err = p.Process(context.Background(), user)
+			if (err != nil) != tt.fails {
+				t.Errorf("Expected failure %v, got %v", tt.fails, err)
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("Expected permanent %v, got %v", tt.permanent, err)
+			}
+			if n := int(calls.Load()); n != tt.expectedCalls {
+				t.Errorf("Expected %d requests, got %d", tt.expectedCalls, n)
+			}
+		})
+	}
+}
+
+func TestWebhook_ProcessConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": srv.URL, "concurrency": "2"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Process(context.Background(), user); err != nil {
+				t.Errorf("Error processing user: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := peak.Load(); n != 2 {
+		t.Errorf("Expected at most 2 requests in flight, got %d", n)
+	}
+}
+
+func TestWebhook_Ping(t *testing.T) {
+	status := http.StatusMethodNotAllowed
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("Expected HEAD, got %s", r.Method)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p, err := New("webhook", Settings{"url": srv.URL})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+	w := p.(*Webhook)
+	if err := w.Ping(context.Background()); err != nil {
+		t.Errorf("Expected a 4xx to count as reachable, got %v", err)
+	}
+	status = http.StatusBadGateway
+	if err := w.Ping(context.Background()); err == nil {
+		t.Errorf("Expected an error for a 5xx")
+	}
+}
+
+func TestSMTP_Process(t *testing.T) {
+	p, err := NewSMTP(Settings{"addr": "mail.example.com:587", "from": "noreply@example.com", "subject": "Hi", "body": "Hello {{.UserName}}", "username": "u", "password": "p"})
+	if err != nil {
+		t.Fatalf("Error creating processor: %v", err)
+	}
+
+	var to []string
+	var msg string
+	s := p.(*SMTP)
+	s.send = func(addr string, a smtp.Auth, from string, rcpt []string, data []byte) error {
+		if addr != "mail.example.com:587" || a == nil || from != "noreply@example.com" {
+			t.Errorf("Unexpected send to %s from %s", addr, from)
+		}
+		to, msg = rcpt, string(data)
+		return nil
+	}
+
+	if err := s.Process(context.Background(), user); err != nil {
+		t.Fatalf("Error processing user: %v", err)
+	}
+	if !reflect.DeepEqual(to, []string{"user1@example.com"}) {
+		t.Errorf("Unexpected recipients %v", to)
+	}
+	if !strings.Contains(msg, "Subject: Hi\r\n") || !strings.HasSuffix(msg, "\r\n\r\nHello user1") {
+		t.Errorf("Unexpected message %q", msg)
+	}
+
+	if err := s.Process(context.Background(), db.User{ID: 102}); err == nil {
+		t.Errorf("Expected an error for a user without an email address")
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	var got []string
+	named := func(name string) Processor {
+		return Func(func(ctx context.Context, user db.User) error {
+			got = append(got, name)
+			return nil
+		})
+	}
+
+	if _, err := NewSwitch(map[string]Processor{"blue": named("blue")}, "green"); err == nil {
+		t.Fatalf("Expected an error for an unknown active configuration")
+	}
+	sw, err := NewSwitch(map[string]Processor{"blue": named("blue"), "green": named("green")}, "blue")
+	if err != nil {
+		t.Fatalf("Error creating switch: %v", err)
+	}
+
+	sw.Process(context.Background(), user)
+	if err := sw.Use(context.Background(), "green"); err != nil {
+		t.Fatalf("Error switching: %v", err)
+	}
+	sw.Process(context.Background(), user)
+	if name, err := sw.Rollback(context.Background()); err != nil || name != "blue" {
+		t.Fatalf("Expected rollback to blue, got %q, %v", name, err)
+	}
+	sw.Process(context.Background(), user)
+
+	if !reflect.DeepEqual(got, []string{"blue", "green", "blue"}) {
+		t.Errorf("Unexpected processors used %v", got)
+	}
+	if err := sw.Use(context.Background(), "red"); err == nil || sw.Active() != "blue" {
+		t.Errorf("Expected an error and blue to stay active, got %v and %s", err, sw.Active())
+	}
+}
+
+func TestSwitch_Shared(t *testing.T) {
+	opened, err := db.NewSQLiteStore("sqlite3", db.SQLiteConfig{Path: filepath.Join(t.TempDir(), "mailboxes.db")})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	store := opened.(*db.DBStore)
+	ctx := context.Background()
+	if _, err := store.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+
+	// serve and run each have a switch over the same configurations.
+	procs := map[string]Processor{"blue": Func(nil), "green": Func(nil), "red": Func(nil)}
+	serve, _ := NewSwitch(procs, "blue")
+	run, _ := NewSwitch(procs, "blue")
+	for _, sw := range []*Switch{serve, run} {
+		if err := sw.Share(ctx, store, 0); err != nil {
+			t.Fatalf("Error sharing switch: %v", err)
+		}
+	}
+
+	if _, err := serve.Rollback(ctx); err == nil {
+		t.Errorf("Expected no configuration to roll back to among three")
+	}
+	if err := serve.Use(ctx, "green"); err != nil {
+		t.Fatalf("Error switching: %v", err)
+	}
+	run.Refresh(ctx)
+	if run.Active() != "green" {
+		t.Errorf("Expected the other switch to pick up green, got %s", run.Active())
+	}
+
+	if name, err := serve.Rollback(ctx); err != nil || name != "blue" {
+		t.Fatalf("Expected rollback to blue, got %q, %v", name, err)
+	}
+	run.Refresh(ctx)
+	if run.Active() != "blue" {
+		t.Errorf("Expected the other switch to pick up the rollback, got %s", run.Active())
+	}
+
+	// A process started after the switch starts with it.
+	if err := run.Use(ctx, "red"); err != nil {
+		t.Fatalf("Error switching: %v", err)
+	}
+	late, _ := NewSwitch(procs, "blue")
+	if err := late.Share(ctx, store, time.Hour); err != nil || late.Active() != "red" {
+		t.Errorf("Expected a new switch to start with red, got %s, %v", late.Active(), err)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	processed := 0
+	p := NewRateLimited(Func(func(ctx context.Context, user db.User) error {
+		processed++
+		return nil
+	}), 1, 2)
+
+	// The burst goes through at once; the next user has to wait a second.
+	for i := 0; i < 2; i++ {
+		if err := p.Process(context.Background(), user); err != nil {
+			t.Fatalf("Error processing user %d of the burst: %v", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Process(ctx, user); err == nil {
+		t.Errorf("Expected the user after the burst to be held back")
+	}
+	if processed != 2 {
+		t.Errorf("Expected 2 users processed, got %d", processed)
+	}
+}
@@ -0,0 +1,32 @@
+package processor
+
+import (
+	"context"
+
+	"mailboxes/db"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimited passes users to a processor no faster than a token bucket
+// allows, so a large mailbox doesn't flood the system the processor calls.
+// It is safe for concurrent use; all callers share the one bucket.
+type RateLimited struct {
+	next    Processor
+	limiter *rate.Limiter
+}
+
+// NewRateLimited returns a RateLimited allowing perSecond users a second on
+// average through to next, in bursts of up to burst users. A burst below one
+// is taken as one.
+func NewRateLimited(next Processor, perSecond float64, burst int) *RateLimited {
+	return &RateLimited{next: next, limiter: rate.NewLimiter(rate.Limit(perSecond), max(burst, 1))}
+}
+
+// Process waits for a token, or until ctx is done, and then processes user.
+func (r *RateLimited) Process(ctx context.Context, user db.User) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.next.Process(ctx, user)
+}
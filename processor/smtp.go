@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"text/template"
+
+	"mailboxes/db"
+)
+
+// defaultBody is sent when no body template is configured.
+const defaultBody = "Hello {{.UserName}},\n\nYour mailbox has been processed.\n"
+
+// SMTP mails each user at their email address. Settings are addr
+// (host:port, required), from (required), subject, body (a text/template
+// executed with the db.User, whose enrichment fields are under
+// .Enrichment) and username/password for PLAIN auth.
+type SMTP struct {
+	addr    string
+	from    string
+	subject string
+	body    *template.Template
+	auth    smtp.Auth
+
+	// send is smtp.SendMail, replaced in tests.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTP(settings Settings) (Processor, error) {
+	if settings["addr"] == "" {
+		return nil, errors.New("addr is not configured")
+	}
+	if settings["from"] == "" {
+		return nil, errors.New("from is not configured")
+	}
+
+	src := settings["body"]
+	if src == "" {
+		src = defaultBody
+	}
+	body, err := template.New("body").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &SMTP{addr: settings["addr"], from: settings["from"], subject: settings["subject"], body: body, send: smtp.SendMail}
+	if p.subject == "" {
+		p.subject = "Your mailbox"
+	}
+	if settings["username"] != "" {
+		host, _, err := net.SplitHostPort(p.addr)
+		if err != nil {
+			return nil, err
+		}
+		p.auth = smtp.PlainAuth("", settings["username"], settings["password"], host)
+	}
+	return p, nil
+}
+
+func (p *SMTP) Process(ctx context.Context, user db.User) error {
+	if user.EmailAddress == "" {
+		return fmt.Errorf("user %d has no email address", user.ID)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n", p.from, user.EmailAddress, p.subject)
+	if err := p.body.Execute(&msg, enriched(ctx, user)); err != nil {
+		return err
+	}
+	return p.send(p.addr, p.auth, p.from, []string{user.EmailAddress}, msg.Bytes())
+}
+
+// Ping checks that the SMTP server accepts connections.
+func (p *SMTP) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,207 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Switch holds several named processor configurations, such as blue and
+// green, and passes each user to the active one. The active configuration
+// can be changed while users are being processed, so a bad change can be
+// rolled back without restarting. Shared through a store, a change made in
+// one process reaches every other process's Switch on its next Refresh. It
+// is safe for concurrent use.
+type Switch struct {
+	mu       sync.RWMutex
+	procs    map[string]Processor
+	active   string
+	previous string
+
+	store    db.ProcessorStateStore
+	interval time.Duration
+	synced   time.Time
+}
+
+// NewSwitch returns a Switch over procs with active selected.
+func NewSwitch(procs map[string]Processor, active string) (*Switch, error) {
+	if _, ok := procs[active]; !ok {
+		return nil, fmt.Errorf("unknown processor configuration %q", active)
+	}
+	return &Switch{procs: procs, active: active}, nil
+}
+
+// Share keeps the active configuration in store, so that Use and Rollback
+// switch every process sharing it, and adopts the configuration switched
+// to there, if any. Refresh then reads it again at most once per interval.
+func (s *Switch) Share(ctx context.Context, store db.ProcessorStateStore, interval time.Duration) error {
+	s.mu.Lock()
+	s.store, s.interval = store, interval
+	s.mu.Unlock()
+	return s.sync(ctx)
+}
+
+// Refresh adopts the configuration switched to through the shared store, if
+// interval has passed since it was last read. Runs call it between
+// mailboxes. Errors are logged and keep the current configuration.
+func (s *Switch) Refresh(ctx context.Context) {
+	s.mu.RLock()
+	due := s.store != nil && time.Since(s.synced) >= s.interval
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+	if err := s.sync(ctx); err != nil {
+		slog.Error("Error reading processor configuration; keeping the current one", "active", s.Active(), "error", err)
+	}
+}
+
+// sync reads the shared state and adopts it.
+func (s *Switch) sync(ctx context.Context) error {
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+	state, err := store.ProcessorState(ctx)
+	if err != nil {
+		return err
+	}
+	s.adopt(state)
+	return nil
+}
+
+// adopt makes state's configuration active, unless nothing was switched to
+// yet or it is not one of s's.
+func (s *Switch) adopt(state db.ProcessorState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = time.Now()
+	if state.Active == "" {
+		return
+	}
+	if _, ok := s.procs[state.Active]; !ok {
+		slog.Warn("Unknown processor configuration switched to; keeping the current one", "switched_to", state.Active, "active", s.active)
+		return
+	}
+	if state.Active != s.active {
+		slog.Info("Switched processor configuration", "active", state.Active, "previous", s.active)
+	}
+	s.active, s.previous = state.Active, state.Previous
+}
+
+func (s *Switch) Process(ctx context.Context, user db.User) error {
+	s.mu.RLock()
+	p := s.procs[s.active]
+	s.mu.RUnlock()
+	return p.Process(ctx, user)
+}
+
+// Ping checks that the active configuration's endpoint answers, if it has
+// one to check.
+func (s *Switch) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	p := s.procs[s.active]
+	s.mu.RUnlock()
+	if pinger, ok := p.(interface{ Ping(context.Context) error }); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Active returns the name of the active configuration.
+func (s *Switch) Active() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// Names returns the configuration names in sorted order.
+func (s *Switch) Names() []string {
+	names := make([]string, 0, len(s.procs))
+	for name := range s.procs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Use makes name the active configuration. Users already being processed
+// finish with the configuration they started with.
+func (s *Switch) Use(ctx context.Context, name string) error {
+	if _, ok := s.procs[name]; !ok {
+		return fmt.Errorf("unknown processor configuration %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store != nil {
+		state, err := s.store.UseProcessor(ctx, name, s.active)
+		if err != nil {
+			return err
+		}
+		s.active, s.previous, s.synced = state.Active, state.Previous, time.Now()
+		return nil
+	}
+	if name != s.active {
+		s.previous, s.active = s.active, name
+	}
+	return nil
+}
+
+// Rollback makes the configuration that was active before the last change
+// active again, and returns its name. With only two configurations this
+// toggles between them, even if nothing was switched yet.
+func (s *Switch) Rollback(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.store != nil {
+		state, ok, err := s.store.RollbackProcessor(ctx)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			other := s.other()
+			if other == "" {
+				return "", errors.New("no processor configuration to roll back to")
+			}
+			if state, err = s.store.UseProcessor(ctx, other, s.active); err != nil {
+				return "", err
+			}
+		}
+		s.active, s.previous, s.synced = state.Active, state.Previous, time.Now()
+		return s.active, nil
+	}
+
+	prev := s.previous
+	if prev == "" {
+		prev = s.other()
+	}
+	if prev == "" {
+		return "", errors.New("no processor configuration to roll back to")
+	}
+	s.previous, s.active = s.active, prev
+	return prev, nil
+}
+
+// other returns the configuration that is not active when there are only
+// two. The caller holds s.mu.
+func (s *Switch) other() string {
+	if len(s.procs) != 2 {
+		return ""
+	}
+	for name := range s.procs {
+		if name != s.active {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailboxes/codec"
+	"mailboxes/db"
+	"mailboxes/redact"
+)
+
+// Headers the webhook sets when a secret is configured. The signature is
+// "sha256=" and the hex HMAC-SHA256, keyed with the secret, of the
+// timestamp, a dot and the body, so receivers can reject replays of old
+// deliveries as well as forged ones.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+// Webhook POSTs each user to a URL. Settings are url (required), codec
+// (default json), timeout (default 10s) and include, exclude and mask, each
+// a comma-separated list of fields to redact from the payload. With secret
+// set, requests are signed. concurrency caps the requests in flight across
+// all workers (default unlimited).
+//
+// A request that fails with a network error, a 5xx, 408 or 429 is retried up
+// to retries times (default 2), waiting backoff (default 500ms) and then
+// twice as long each time, up to max_backoff (default 30s), or as long as a
+// Retry-After header asks within that. Any other 4xx, or other status short
+// of a 2xx, means the receiver rejected the user, so it fails with a
+// PermanentError and is not retried.
+//
+// A Destination carried by the context replaces the URL and secret, and
+// adds an Authorization header, for that user. Enrichment fields the
+// context carries are sent under enrichment.
+type Webhook struct {
+	url        string
+	c          codec.Codec
+	client     *http.Client
+	secret     []byte
+	sem        chan struct{}
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+func NewWebhook(settings Settings) (Processor, error) {
+	if settings["url"] == "" {
+		return nil, errors.New("url is not configured")
+	}
+	c, err := codec.Lookup(settings["codec"])
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		url:        settings["url"],
+		secret:     []byte(settings["secret"]),
+		retries:    2,
+		backoff:    500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		now:        time.Now,
+	}
+
+	timeout := 10 * time.Second
+	for name, d := range map[string]*time.Duration{"timeout": &timeout, "backoff": &w.backoff, "max_backoff": &w.maxBackoff} {
+		if s := settings[name]; s != "" {
+			if *d, err = time.ParseDuration(s); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	if s := settings["retries"]; s != "" {
+		if w.retries, err = strconv.Atoi(s); err != nil || w.retries < 0 {
+			return nil, fmt.Errorf("retries must be a count, not %q", s)
+		}
+	}
+	if s := settings["concurrency"]; s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("concurrency must be at least 1, not %q", s)
+		}
+		w.sem = make(chan struct{}, n)
+	}
+
+	w.c = redact.Codec(c, redact.Policy{
+		Include: fieldList(settings["include"]),
+		Exclude: fieldList(settings["exclude"]),
+		Mask:    fieldList(settings["mask"]),
+	})
+	w.client = &http.Client{Timeout: timeout}
+	return w, nil
+}
+
+// fieldList splits a comma-separated setting, ignoring blanks.
+func fieldList(s string) []string {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func (w *Webhook) Process(ctx context.Context, user db.User) error {
+	body, err := w.c.Marshal(enriched(ctx, user))
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		wait, err := w.post(ctx, body)
+		if err == nil || IsPermanent(err) || attempt >= w.retries {
+			return err
+		}
+
+		delay := w.backoff
+		for i := 0; i < attempt && delay < w.maxBackoff; i++ {
+			delay *= 2
+		}
+		delay = min(max(delay, wait), w.maxBackoff)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// post sends one request. For a response worth retrying it also returns how
+// long the receiver asked to wait, if it did.
+func (w *Webhook) post(ctx context.Context, body []byte) (time.Duration, error) {
+	if w.sem != nil {
+		select {
+		case w.sem <- struct{}{}:
+			defer func() { <-w.sem }()
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	url, secret := w.url, w.secret
+	dest, _ := DestinationFrom(ctx)
+	if dest.URL != "" {
+		url = dest.URL
+	}
+	if dest.Secret != "" {
+		secret = []byte(dest.Secret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, &PermanentError{Err: err}
+	}
+	req.Header.Set("Content-Type", w.c.ContentType())
+	if dest.Authorization != "" {
+		req.Header.Set("Authorization", dest.Authorization)
+	}
+	if len(secret) > 0 {
+		ts := strconv.FormatInt(w.now().Unix(), 10)
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(SignatureHeader, Sign(secret, ts, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code <= 299:
+		return 0, nil
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(wait) * time.Second, fmt.Errorf("webhook %s answered %s", url, resp.Status)
+	default:
+		return 0, &PermanentError{Err: fmt.Errorf("webhook %s rejected the user: %s", url, resp.Status)}
+	}
+}
+
+// Ping checks that the webhook's URL answers. Any response short of a 5xx
+// counts, since the receiver need not accept requests without a user.
+func (w *Webhook) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, w.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("webhook %s answered %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body sent at timestamp, a
+// Unix time in seconds, so receivers can check deliveries.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// cliSource is the provenance recorded for users created from the command
+// line, such as with users create.
+const cliSource = "cli"
+
+// userSources tells which ingestion path each user came in through: the
+// source recorded in user_provenance or, for users created straight in the
+// database rather than through this tool, db:<provenance.shard>, or db if
+// no shard is named. It is safe for concurrent use, and a nil *userSources
+// knows no sources.
+type userSources struct {
+	store    db.ProvenanceStore
+	fallback string
+
+	mu       sync.RWMutex
+	recorded map[int]string
+	loaded   time.Time
+}
+
+// sources is the provenance of the users the pipeline processes, put on
+// the events it emits; nil unless provenance.enabled is set and events are
+// published.
+var sources *userSources
+
+// newUserSources returns the userSources of store, or nil if the store does
+// not record provenance or provenance.enabled is not set.
+func newUserSources(store db.Store) *userSources {
+	ps, ok := store.(db.ProvenanceStore)
+	if !ok || !viper.GetBool("provenance.enabled") {
+		return nil
+	}
+	fallback := "db"
+	if shard := viper.GetString("provenance.shard"); shard != "" {
+		fallback += ":" + shard
+	}
+	return &userSources{store: ps, fallback: fallback, recorded: map[int]string{}}
+}
+
+// refresh reads the sources recorded since the last refresh, or every
+// source the first time.
+func (s *userSources) refresh(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	// Sources recorded while the last refresh ran are read again rather
+	// than missed.
+	since := s.loaded.Add(-time.Minute)
+	if s.loaded.IsZero() {
+		since = time.Time{}
+	}
+	started := time.Now()
+	recorded, err := s.store.UserSources(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, source := range recorded {
+		s.recorded[id] = source
+	}
+	s.loaded = started
+	return nil
+}
+
+// of returns the source of the user with userID.
+func (s *userSources) of(userID int) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if source, ok := s.recorded[userID]; ok {
+		return source
+	}
+	return s.fallback
+}
@@ -0,0 +1,236 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Region is one regional deployment of the provider's endpoints. HealthURL
+// is optional; without it a region's health is judged from real requests
+// only.
+type Region struct {
+	Name      string `mapstructure:"name"`
+	TokenURL  string `mapstructure:"token_url"`
+	VerifyURL string `mapstructure:"verify_url"`
+	HealthURL string `mapstructure:"health_url"`
+}
+
+// FailoverConfig describes a provider deployed in several regions. Requests
+// go to the Pin region first, if set, and then to the others in order.
+// With Strict, requests never leave the Pin region. A region that fails is
+// skipped for Cooldown, after which one request is let through to probe it.
+type FailoverConfig struct {
+	Regions      []Region
+	Pin          string
+	Strict       bool
+	ClientID     string
+	ClientSecret string
+	Timeout      time.Duration
+	Cooldown     time.Duration
+}
+
+// Failover is a Provider that sends each request to the first healthy
+// region and moves on to the next when a region is unavailable. Rejections,
+// such as an invalid token, are returned without trying another region.
+type Failover struct {
+	regions  []*region
+	cooldown time.Duration
+	client   *http.Client
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+type region struct {
+	name      string
+	healthURL string
+	p         *HTTP
+
+	down    bool
+	retryAt time.Time
+}
+
+// NewFailover returns a Failover provider for cfg. A zero Cooldown defaults
+// to thirty seconds.
+func NewFailover(cfg FailoverConfig) (*Failover, error) {
+	if len(cfg.Regions) == 0 {
+		return nil, errors.New("no provider regions configured")
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+
+	f := &Failover{cooldown: cfg.Cooldown, now: time.Now}
+	pinned := false
+	for _, r := range cfg.Regions {
+		if r.Name == "" {
+			return nil, errors.New("provider region has no name")
+		}
+		p, err := NewHTTP(Config{
+			TokenURL:     r.TokenURL,
+			VerifyURL:    r.VerifyURL,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Timeout:      cfg.Timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", r.Name, err)
+		}
+		f.client = p.client
+
+		reg := &region{name: r.Name, healthURL: r.HealthURL, p: p}
+		switch {
+		case r.Name == cfg.Pin:
+			pinned = true
+			f.regions = append([]*region{reg}, f.regions...)
+		case cfg.Strict && cfg.Pin != "":
+			// Never used: requests must stay in the pinned region.
+		default:
+			f.regions = append(f.regions, reg)
+		}
+	}
+	if cfg.Pin != "" && !pinned {
+		return nil, fmt.Errorf("pinned provider region %q is not configured", cfg.Pin)
+	}
+	return f, nil
+}
+
+// Regions returns the names of the regions requests may go to, in the order
+// they are tried.
+func (f *Failover) Regions() []string {
+	names := make([]string, len(f.regions))
+	for i, r := range f.regions {
+		names[i] = r.name
+	}
+	return names
+}
+
+func (f *Failover) Exchange(mpiID string) (string, error) {
+	var tok string
+	err := f.do(func(p *HTTP) (err error) {
+		tok, err = p.Exchange(mpiID)
+		return err
+	})
+	return tok, err
+}
+
+func (f *Failover) Refresh(mb db.Mailbox) (string, error) {
+	var tok string
+	err := f.do(func(p *HTTP) (err error) {
+		tok, err = p.Refresh(mb)
+		return err
+	})
+	return tok, err
+}
+
+func (f *Failover) Verify(tok string) error {
+	return f.do(func(p *HTTP) error { return p.Verify(tok) })
+}
+
+// Ping succeeds if any region the provider may use answers; see HTTP.Ping.
+// Regions that do not are marked down.
+func (f *Failover) Ping(ctx context.Context) error {
+	return f.do(func(p *HTTP) error { return p.Ping(ctx) })
+}
+
+// do runs call against each usable region in turn until one answers.
+func (f *Failover) do(call func(p *HTTP) error) error {
+	var errs []error
+	for _, r := range f.usable() {
+		err := call(r.p)
+		if err == nil || !errors.Is(err, ErrUnavailable) {
+			f.mark(r, true)
+			return err
+		}
+		f.mark(r, false)
+		errs = append(errs, fmt.Errorf("region %s: %w", r.name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// usable returns the regions that are up or due a probe. If every region is
+// down they are all returned, since trying is better than failing outright.
+func (f *Failover) usable() []*region {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	var usable []*region
+	for _, r := range f.regions {
+		if !r.down || !now.Before(r.retryAt) {
+			usable = append(usable, r)
+		}
+	}
+	if len(usable) == 0 {
+		return f.regions
+	}
+	return usable
+}
+
+// mark records whether r is up. A region that stays down is skipped for
+// another cooldown.
+func (f *Failover) mark(r *region, up bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case up && r.down:
+		slog.Info("Provider region recovered", "region", r.name)
+		r.down = false
+	case !up:
+		if !r.down {
+			slog.Warn("Provider region unavailable", "region", r.name, "cooldown", f.cooldown)
+		}
+		r.down = true
+		r.retryAt = f.now().Add(f.cooldown)
+	}
+}
+
+// CheckHealth probes the HealthURL of every region that has one and
+// records the result; any 2xx response counts as healthy.
+func (f *Failover) CheckHealth(ctx context.Context) {
+	for _, r := range f.regions {
+		if r.healthURL == "" {
+			continue
+		}
+		f.mark(r, f.healthy(ctx, r.healthURL))
+	}
+}
+
+func (f *Failover) healthy(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode >= 200 && resp.StatusCode <= 299
+}
+
+// Watch runs CheckHealth every interval until ctx is done.
+func (f *Failover) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	f.CheckHealth(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.CheckHealth(ctx)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// regionServer serves the test provider, failing every request with
+// *status while it is non-zero.
+func regionServer(t *testing.T, status *int) *httptest.Server {
+	t.Helper()
+
+	mux := testMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *status != 0 {
+			w.WriteHeader(*status)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testRegion(name string, srv *httptest.Server) Region {
+	return Region{Name: name, TokenURL: srv.URL + "/token", VerifyURL: srv.URL + "/verify", HealthURL: srv.URL + "/health"}
+}
+
+func TestFailover(t *testing.T) {
+	var eastStatus, westStatus int
+	east, west := regionServer(t, &eastStatus), regionServer(t, &westStatus)
+
+	f, err := NewFailover(FailoverConfig{
+		Regions:      []Region{testRegion("east", east), testRegion("west", west)},
+		Pin:          "west",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Cooldown:     time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	if got := f.Regions(); !reflect.DeepEqual(got, []string{"west", "east"}) {
+		t.Errorf("Expected the pinned region first, got %v", got)
+	}
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	// The pinned region is down, so east answers.
+	westStatus = http.StatusServiceUnavailable
+	tok, err := f.Exchange("mpi123")
+	if err != nil || tok != "tok-mpi123" {
+		t.Fatalf("Expected failover to east, got %q, %v", tok, err)
+	}
+
+	// West stays skipped during its cooldown even once it recovers.
+	westStatus = 0
+	eastStatus = http.StatusBadGateway
+	if _, err := f.Exchange("mpi123"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected every usable region to be unavailable, got %v", err)
+	}
+
+	// A rejection is an answer, not an outage.
+	eastStatus = 0
+	now = now.Add(2 * time.Minute)
+	if err := f.Verify("tok-other"); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected the token to be rejected, got %v", err)
+	}
+}
+
+func TestFailover_CheckHealth(t *testing.T) {
+	var eastStatus, westStatus int
+	east, west := regionServer(t, &eastStatus), regionServer(t, &westStatus)
+
+	f, err := NewFailover(FailoverConfig{
+		Regions:      []Region{testRegion("east", east), testRegion("west", west)},
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	eastStatus = http.StatusServiceUnavailable
+	f.CheckHealth(context.Background())
+	if usable := f.usable(); len(usable) != 1 || usable[0].name != "west" {
+		t.Errorf("Expected only west to be usable")
+	}
+
+	eastStatus = 0
+	f.CheckHealth(context.Background())
+	if usable := f.usable(); len(usable) != 2 {
+		t.Errorf("Expected both regions to be usable after recovery")
+	}
+}
+
+func TestNewFailover_Strict(t *testing.T) {
+	regions := []Region{{Name: "east", TokenURL: "http://east/token"}, {Name: "west", TokenURL: "http://west/token"}}
+
+	f, err := NewFailover(FailoverConfig{Regions: regions, Pin: "east", Strict: true})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	if got := f.Regions(); !reflect.DeepEqual(got, []string{"east"}) {
+		t.Errorf("Expected only the pinned region, got %v", got)
+	}
+
+	if _, err := NewFailover(FailoverConfig{Regions: regions, Pin: "north"}); err == nil {
+		t.Errorf("Expected an error for an unknown pinned region")
+	}
+}
@@ -0,0 +1,164 @@
+// Package provider talks to the mail provider on a mailbox's behalf: it
+// exchanges MPI IDs for access tokens, refreshes them and checks that they
+// work.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+)
+
+// Provider performs the token handshake with a mail provider. It satisfies
+// token.Refresher.
+type Provider interface {
+	// Exchange obtains an access token for the mailbox identified by mpiID.
+	Exchange(mpiID string) (string, error)
+	// Verify checks that tok is accepted by the provider.
+	Verify(tok string) error
+	// Refresh obtains a replacement for mb's token.
+	Refresh(mb db.Mailbox) (string, error)
+}
+
+// ErrUnavailable wraps errors that suggest the provider, rather than the
+// request, is at fault: network failures and 5xx or 429 responses. Another
+// region may succeed where such a request failed.
+var ErrUnavailable = errors.New("provider unavailable")
+
+// unavailable reports whether a response with status code means the
+// provider is unavailable.
+func unavailable(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// Config describes how to reach a provider's token endpoints.
+type Config struct {
+	TokenURL     string
+	VerifyURL    string
+	ClientID     string
+	ClientSecret string
+	Timeout      time.Duration
+}
+
+// HTTP is a Provider backed by an OAuth-style token endpoint. Tokens are
+// requested with a form POST to TokenURL and verified with a bearer GET to
+// VerifyURL.
+type HTTP struct {
+	cfg    Config
+	client *http.Client
+}
+
+// NewHTTP returns an HTTP provider. A zero Timeout defaults to ten seconds.
+func NewHTTP(cfg Config) (*HTTP, error) {
+	if cfg.TokenURL == "" {
+		return nil, errors.New("provider token URL is not configured")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &HTTP{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (p *HTTP) Exchange(mpiID string) (string, error) {
+	return p.requestToken(url.Values{
+		"grant_type": {"client_credentials"},
+		"mpi_id":     {mpiID},
+	})
+}
+
+func (p *HTTP) Refresh(mb db.Mailbox) (string, error) {
+	return p.requestToken(url.Values{
+		"grant_type":    {"refresh_token"},
+		"mpi_id":        {mb.MPIID},
+		"refresh_token": {mb.Token},
+	})
+}
+
+// Verify succeeds if VerifyURL accepts tok, or if no VerifyURL is configured.
+func (p *HTTP) Verify(tok string) error {
+	if p.cfg.VerifyURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.cfg.VerifyURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if unavailable(resp.StatusCode) {
+		return fmt.Errorf("%w: verify request failed: %s", ErrUnavailable, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("provider rejected token: %s", resp.Status)
+	}
+	return nil
+}
+
+// Ping checks that the token endpoint answers. Any response short of a 5xx
+// or 429 counts, since the request carries no credentials; otherwise the
+// error wraps ErrUnavailable.
+func (p *HTTP) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.cfg.TokenURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	resp.Body.Close()
+	if unavailable(resp.StatusCode) {
+		return fmt.Errorf("%w: token endpoint answered %s", ErrUnavailable, resp.Status)
+	}
+	return nil
+}
+
+func (p *HTTP) requestToken(form url.Values) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, p.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.cfg.ClientID, p.cfg.ClientSecret)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		if unavailable(resp.StatusCode) {
+			return "", fmt.Errorf("%w: token request failed: %s", ErrUnavailable, resp.Status)
+		}
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mailboxes/db"
+)
+
+func testServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(testMux())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testMux serves a provider that issues tok-<mpi_id> and accepts only
+// tok-mpi123.
+func testMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		switch r.FormValue("grant_type") {
+		case "client_credentials":
+			fmt.Fprintf(w, `{"access_token":"tok-%s"}`, r.FormValue("mpi_id"))
+		case "refresh_token":
+			fmt.Fprintf(w, `{"access_token":"%s-refreshed"}`, r.FormValue("refresh_token"))
+		default:
+			http.Error(w, "bad grant", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-mpi123" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+		}
+	})
+
+	return mux
+}
+
+func TestHTTP(t *testing.T) {
+	srv := testServer(t)
+
+	p, err := NewHTTP(Config{TokenURL: srv.URL + "/token", VerifyURL: srv.URL + "/verify", ClientID: "client", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	tok, err := p.Exchange("mpi123")
+	if err != nil {
+		t.Fatalf("Error exchanging token: %v", err)
+	}
+	if tok != "tok-mpi123" {
+		t.Errorf("Expected token tok-mpi123, got %q", tok)
+	}
+
+	if err := p.Verify(tok); err != nil {
+		t.Errorf("Expected token to verify, got %v", err)
+	}
+	if err := p.Verify("tok-other"); err == nil {
+		t.Errorf("Expected unknown token to be rejected")
+	}
+
+	tok, err = p.Refresh(db.Mailbox{MPIID: "mpi123", Token: "tok-mpi123"})
+	if err != nil {
+		t.Fatalf("Error refreshing token: %v", err)
+	}
+	if tok != "tok-mpi123-refreshed" {
+		t.Errorf("Expected token tok-mpi123-refreshed, got %q", tok)
+	}
+}
+
+func TestHTTP_BadCredentials(t *testing.T) {
+	srv := testServer(t)
+
+	p, err := NewHTTP(Config{TokenURL: srv.URL + "/token", ClientID: "client", ClientSecret: "wrong"})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	if _, err := p.Exchange("mpi123"); err == nil {
+		t.Errorf("Expected exchange with bad credentials to fail")
+	}
+}
+
+func TestHTTP_Ping(t *testing.T) {
+	srv := testServer(t)
+
+	// The token endpoint rejects the unauthenticated probe, but answers.
+	p, err := NewHTTP(Config{TokenURL: srv.URL + "/token"})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	if err := p.Ping(context.Background()); err != nil {
+		t.Errorf("Expected the provider to be reachable, got %v", err)
+	}
+
+	srv.Close()
+	if err := p.Ping(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable for a closed server, got %v", err)
+	}
+}
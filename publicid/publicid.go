@@ -0,0 +1,144 @@
+// Package publicid converts integer IDs to opaque strings for public API
+// responses, so consumers can't walk the ID space, and back again.
+//
+// IDs are permuted with a small Feistel network keyed by a secret and then
+// written in base 62 over an alphabet shuffled by the same secret. This is
+// obfuscation, not encryption: it hides ordering and density, not the
+// existence of a resource.
+package publicid
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+const baseAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const rounds = 4
+
+// MaxID is the largest ID that can be encoded. The unused high bits make
+// almost every guessed string decode as invalid rather than as some other ID.
+const MaxID = 1<<48 - 1
+
+var ErrInvalid = errors.New("invalid ID")
+
+// Codec encodes and decodes IDs for one secret. A nil Codec passes IDs
+// through as plain decimal, so obfuscation can be switched off in config.
+type Codec struct {
+	alphabet [len(baseAlphabet)]byte
+	index    [256]int8
+	keys     [rounds]uint32
+}
+
+// New returns a Codec keyed by secret, or nil if secret is empty.
+func New(secret string) *Codec {
+	if secret == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	c := &Codec{}
+	for i := range c.keys {
+		c.keys[i] = binary.BigEndian.Uint32(sum[i*4:])
+	}
+
+	// Shuffle the alphabet with a generator seeded from the rest of the hash.
+	copy(c.alphabet[:], baseAlphabet)
+	state := binary.BigEndian.Uint64(sum[16:])
+	for i := len(c.alphabet) - 1; i > 0; i-- {
+		state = splitmix(state)
+		j := int(state % uint64(i+1))
+		c.alphabet[i], c.alphabet[j] = c.alphabet[j], c.alphabet[i]
+	}
+
+	for i := range c.index {
+		c.index[i] = -1
+	}
+	for i, ch := range c.alphabet {
+		c.index[ch] = int8(i)
+	}
+	return c
+}
+
+// Encode returns the public form of id, which must be between 0 and MaxID.
+func (c *Codec) Encode(id int) string {
+	if c == nil {
+		return strconv.Itoa(id)
+	}
+
+	v := c.permute(uint64(id))
+	var buf [11]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = c.alphabet[v%uint64(len(c.alphabet))]
+		v /= uint64(len(c.alphabet))
+		if v == 0 {
+			break
+		}
+	}
+	return string(buf[i:])
+}
+
+// Decode returns the ID whose public form is s.
+func (c *Codec) Decode(s string) (int, error) {
+	if c == nil {
+		id, err := strconv.Atoi(s)
+		if err != nil || id < 0 {
+			return 0, ErrInvalid
+		}
+		return id, nil
+	}
+
+	if s == "" || len(s) > 11 {
+		return 0, ErrInvalid
+	}
+
+	var v uint64
+	for i := 0; i < len(s); i++ {
+		d := c.index[s[i]]
+		if d < 0 {
+			return 0, ErrInvalid
+		}
+		if v > (math.MaxUint64-uint64(d))/uint64(len(c.alphabet)) {
+			return 0, ErrInvalid
+		}
+		v = v*uint64(len(c.alphabet)) + uint64(d)
+	}
+
+	id := c.unpermute(v)
+	if id > MaxID || c.Encode(int(id)) != s {
+		return 0, ErrInvalid
+	}
+	return int(id), nil
+}
+
+func (c *Codec) permute(v uint64) uint64 {
+	l, r := uint32(v>>32), uint32(v)
+	for _, k := range c.keys {
+		l, r = r, l^round(r, k)
+	}
+	return uint64(l)<<32 | uint64(r)
+}
+
+func (c *Codec) unpermute(v uint64) uint64 {
+	l, r := uint32(v>>32), uint32(v)
+	for i := len(c.keys) - 1; i >= 0; i-- {
+		l, r = r^round(l, c.keys[i]), l
+	}
+	return uint64(l)<<32 | uint64(r)
+}
+
+func round(x, k uint32) uint32 {
+	return uint32(splitmix(uint64(x)<<32 | uint64(k)))
+}
+
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
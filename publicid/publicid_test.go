@@ -0,0 +1,57 @@
+package publicid
+
+import (
+	"testing"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	c := New("secret")
+
+	seen := map[string]bool{}
+	for _, id := range []int{0, 1, 2, 3, 101, 102, 201, 1 << 31, MaxID} {
+		s := c.Encode(id)
+		if seen[s] {
+			t.Errorf("Encoding of %d collides: %s", id, s)
+		}
+		seen[s] = true
+
+		got, err := c.Decode(s)
+		if err != nil {
+			t.Fatalf("Error decoding %s: %v", s, err)
+		}
+		if got != id {
+			t.Errorf("Expected %s to decode to %d, got %d", s, id, got)
+		}
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	c := New("secret")
+
+	tests := []struct {
+		name    string
+		codec   *Codec
+		input   string
+		wantErr bool
+	}{
+		{name: "Plain decimal when disabled", input: "101"},
+		{name: "Rejects non-numeric when disabled", input: "abc", wantErr: true},
+		{name: "Obfuscated", codec: c, input: c.Encode(101)},
+		{name: "Rejects non-canonical padding", codec: c, input: string(c.alphabet[0]) + c.Encode(101), wantErr: true},
+		{name: "Rejects raw ID", codec: c, input: "101", wantErr: true},
+		{name: "Rejects unknown characters", codec: c, input: "a-b", wantErr: true},
+		{name: "Rejects overlong", codec: c, input: "zzzzzzzzzzzz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := tt.codec.Decode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && id != 101 {
+				t.Errorf("Expected ID 101, got %d", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package redact removes or masks payload fields before they leave the
+// process. A Policy is configured per sink and for exports, and is applied
+// when the payload is serialized, so no caller can forget it.
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+
+	"mailboxes/codec"
+)
+
+// Policy selects the fields of a payload by their JSON names. With Include
+// set only those fields are kept; Exclude then drops fields and Mask
+// replaces the values of the remaining ones.
+type Policy struct {
+	Include []string
+	Exclude []string
+	Mask    []string
+}
+
+// Empty reports whether p leaves payloads unchanged.
+func (p Policy) Empty() bool {
+	return len(p.Include) == 0 && len(p.Exclude) == 0 && len(p.Mask) == 0
+}
+
+// Keep reports whether field survives the policy.
+func (p Policy) Keep(field string) bool {
+	if len(p.Include) > 0 && !contains(p.Include, field) {
+		return false
+	}
+	return !contains(p.Exclude, field)
+}
+
+// Masked reports whether field's value is masked.
+func (p Policy) Masked(field string) bool {
+	return contains(p.Mask, field)
+}
+
+// Apply returns payload with the policy applied. Objects, and objects inside
+// a top-level array, are redacted; any other payload is returned unchanged.
+func (p Policy) Apply(payload any) (any, error) {
+	if p.Empty() {
+		return payload, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		p.object(v)
+	case []any:
+		for _, elem := range v {
+			if obj, ok := elem.(map[string]any); ok {
+				p.object(obj)
+			}
+		}
+	}
+	return v, nil
+}
+
+func (p Policy) object(obj map[string]any) {
+	for field, value := range obj {
+		switch {
+		case !p.Keep(field):
+			delete(obj, field)
+		case p.Masked(field):
+			obj[field] = MaskValue(value)
+		}
+	}
+}
+
+// MaskValue hides a value while leaving a hint for whoever reads it: the
+// first character of strings and, for email addresses, the domain. Other
+// values are replaced entirely.
+func MaskValue(value any) string {
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return "***"
+	}
+	if at := strings.LastIndexByte(s, '@'); at > 0 {
+		return s[:1] + "***" + s[at:]
+	}
+	return s[:1] + "***"
+}
+
+// Codec returns c with p applied to every payload it marshals. An empty
+// policy returns c itself.
+func Codec(c codec.Codec, p Policy) codec.Codec {
+	if p.Empty() {
+		return c
+	}
+	return redacting{Codec: c, policy: p}
+}
+
+type redacting struct {
+	codec.Codec
+	policy Policy
+}
+
+func (r redacting) Marshal(v any) ([]byte, error) {
+	v, err := r.policy.Apply(v)
+	if err != nil {
+		return nil, err
+	}
+	return r.Codec.Marshal(v)
+}
+
+func contains(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
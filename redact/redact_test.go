@@ -0,0 +1,110 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/codec"
+	"mailboxes/db"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestPolicy_Apply(t *testing.T) {
+	user := db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		payload any
+		want    any
+	}{
+		{
+			name:    "empty policy",
+			payload: user,
+			want:    user,
+		},
+		{
+			name:    "include",
+			policy:  Policy{Include: []string{"id", "email_address"}},
+			payload: user,
+			want:    map[string]any{"id": float64(101), "email_address": "user1@example.com"},
+		},
+		{
+			name:    "exclude and mask",
+			policy:  Policy{Exclude: []string{"created_at", "updated_at", "user_name"}, Mask: []string{"email_address", "mailbox_id"}},
+			payload: user,
+			want:    map[string]any{"id": float64(101), "mailbox_id": "***", "email_address": "u***@example.com"},
+		},
+		{
+			name:    "array of objects",
+			policy:  Policy{Exclude: []string{"token"}},
+			payload: []db.Mailbox{{ID: 1, MPIID: "mpi1", Token: "secret"}},
+			want:    []any{map[string]any{"id": float64(1), "mpi_id": "mpi1", "created_at": "0001-01-01T00:00:00Z", "updated_at": "0001-01-01T00:00:00Z"}},
+		},
+		{
+			name:    "scalar",
+			policy:  Policy{Mask: []string{"id"}},
+			payload: "plain",
+			want:    "plain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Apply(tt.payload)
+			if err != nil {
+				t.Fatalf("Error applying policy: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCodec(t *testing.T) {
+	c := Codec(codec.JSON{}, Policy{Exclude: []string{"token"}})
+	data, err := c.Marshal(db.Mailbox{ID: 1, MPIID: "mpi1", Token: "secret"})
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+	if want := `{"created_at":"0001-01-01T00:00:00Z","id":1,"mpi_id":"mpi1","updated_at":"0001-01-01T00:00:00Z"}`; string(data) != want {
+		t.Errorf("Expected %s, got %s", want, data)
+	}
+	if c.Name() != "json" {
+		t.Errorf("Expected the wrapped codec's name, got %q", c.Name())
+	}
+
+	if Codec(codec.JSON{}, Policy{}) != (codec.JSON{}) {
+		t.Errorf("Expected an empty policy to return the codec unchanged")
+	}
+}
+
+func TestCodec_MsgPackSchema(t *testing.T) {
+	user := db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)}
+	decode := func(c codec.Codec) map[string]any {
+		t.Helper()
+		data, err := c.Marshal(user)
+		if err != nil {
+			t.Fatalf("Error marshaling: %v", err)
+		}
+		var v map[string]any
+		if err := msgpack.Unmarshal(data, &v); err != nil {
+			t.Fatalf("Error decoding: %v", err)
+		}
+		return v
+	}
+
+	plain := decode(codec.MsgPack{})
+	redacted := decode(Codec(codec.MsgPack{}, Policy{Exclude: []string{"updated_at"}}))
+	delete(plain, "updated_at")
+	// The same keys and value types with or without a policy.
+	if !reflect.DeepEqual(redacted, plain) {
+		t.Errorf("Expected the redacted payload %v to match %v", redacted, plain)
+	}
+	if plain["user_name"] != "user1" {
+		t.Errorf("Expected JSON field names, got %v", plain)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"mailboxes/cache"
+	"mailboxes/db"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+// redisCache, when cache.redis.url is set, caches mailboxes and their users
+// in Redis for pipeline runs and the API; nil otherwise.
+var redisCache *cache.Redis
+
+// openRedisCache returns the cache at cache.redis.url in front of store, or
+// nil if it is not set. Entries last cache.redis.ttl.
+func openRedisCache(store db.Store) (*cache.Redis, error) {
+	rawURL := viper.GetString("cache.redis.url")
+	if rawURL == "" {
+		return nil, nil
+	}
+	viper.SetDefault("cache.redis.ttl", 5*time.Minute)
+	viper.SetDefault("cache.redis.prefix", "mailboxes:")
+
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	ttl := viper.GetDuration("cache.redis.ttl")
+	if ttl <= 0 {
+		return nil, fmt.Errorf("cache.redis.ttl must be positive, not %s", ttl)
+	}
+	return cache.NewRedis(store, redis.NewClient(opts), ttl, viper.GetString("cache.redis.prefix")), nil
+}
+
+// cachedReads returns store with its reads of mailboxes and users going
+// through redisCache, if it is set. Only db.Store's methods are left, so
+// use it just for those reads.
+func cachedReads(store db.Store) db.Store {
+	if redisCache == nil {
+		return store
+	}
+	return redisCache
+}
+
+// invalidateUsers drops the cached users of the given mailboxes.
+func invalidateUsers(mailboxIDs ...int) {
+	invalidations.Users(mailboxIDs...)
+}
+
+// invalidateMailboxes drops the cached mailboxes.
+func invalidateMailboxes() {
+	invalidations.Mailboxes()
+}
+
+// clearCache drops everything cached.
+func clearCache() {
+	if redisCache != nil {
+		redisCache.Clear()
+	}
+}
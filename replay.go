@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"mailboxes/db"
+	"mailboxes/processor"
+	"mailboxes/snapshot"
+
+	"github.com/spf13/viper"
+)
+
+// replayCommand re-reads one mailbox and runs its users, in ID order, through
+// the configured script into an alternate sink instead of the real
+// processor, so fixes can be checked against real data safely. With
+// --snapshot the users are read from a snapshot file instead of the store.
+func replayCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	mailboxID := fs.Int("mailbox", 0, "ID of the mailbox to replay")
+	against := fs.String("against", "", "sink to send output to, configured under sinks.<name>")
+	snapshotPath := fs.String("snapshot", "", "read the mailbox's users from this snapshot instead of the store")
+	fs.Parse(args)
+
+	if *mailboxID == 0 || *against == "" {
+		fatal("Usage: replay --mailbox <id> --against <sink>")
+	}
+
+	// Falling back to stdout for a mistyped name would hide the mistake.
+	if !viper.IsSet("sinks." + *against) {
+		fatal("Sink is not configured under sinks", "sink", *against)
+	}
+	replaySink, err := openSink(*against)
+	if err != nil {
+		fatal("Error configuring sink", "sink", *against, "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var users []db.User
+	if *snapshotPath != "" {
+		users, err = snapshotUsers(*snapshotPath, *mailboxID)
+	} else {
+		users, err = storeUsers(ctx, store, *mailboxID)
+	}
+	if err != nil {
+		fatal("Error reading mailbox", "mailbox_id", *mailboxID, "error", err)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	failed := 0
+	// A replay must not schedule retries of its own failures.
+	retries = nil
+	process = processor.Func(func(ctx context.Context, user db.User) error {
+		if err := replaySink.Send(ctx, user); err != nil {
+			failed++
+			return err
+		}
+		return nil
+	})
+
+	sent := 0
+	for _, user := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		if ok, _ := handleUser(ctx, user); ok {
+			sent++
+		}
+	}
+
+	slog.Info("Replayed mailbox", "mailbox_id", *mailboxID, "sent", sent, "users", len(users), "sink", *against, "failed", failed)
+	reportSkips()
+	if err := ctx.Err(); err != nil {
+		fatal("Replay stopped early", "error", err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// storeUsers reads the users of mailbox id from the store.
+func storeUsers(ctx context.Context, store db.Store, id int) ([]db.User, error) {
+	mb, err := findMailbox(ctx, store, id)
+	if err != nil {
+		return nil, err
+	}
+	userChan, err := store.UsersForMailbox(ctx, mb.ID)
+	if err != nil {
+		return nil, err
+	}
+	var users []db.User
+	for user := range userChan {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// snapshotUsers reads the users of mailbox id from the snapshot at path.
+func snapshotUsers(path string, id int) ([]db.User, error) {
+	r, err := snapshot.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.Users(id)
+}
+
+// findMailbox looks up a mailbox directly when the store supports it, and
+// otherwise scans every mailbox.
+func findMailbox(ctx context.Context, store db.Store, id int) (db.Mailbox, error) {
+	if ms, ok := store.(db.MailboxStore); ok {
+		return ms.GetMailboxByID(ctx, id)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mailboxChan, err := store.AllMailboxes(ctx)
+	if err != nil {
+		return db.Mailbox{}, err
+	}
+	for mb := range mailboxChan {
+		if mb.ID == id {
+			return mb, nil
+		}
+	}
+	return db.Mailbox{}, errors.New("mailbox not found")
+}
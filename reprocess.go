@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"mailboxes/annotations"
+	"mailboxes/db"
+)
+
+// runFailures collects what fails during the current run, for recordRun to
+// keep with the run so that reprocess can pick it up; nil outside runs.
+var runFailures *failureLog
+
+// failureLog collects the users and mailboxes that failed in a run. It is
+// safe for concurrent use, and a nil *failureLog records nothing.
+type failureLog struct {
+	mu       sync.Mutex
+	failures []db.RunFailure
+}
+
+// user records that user failed with err.
+func (l *failureLog) user(user db.User, err error) {
+	l.add(db.RunFailure{MailboxID: user.MailboxID, UserID: user.ID, Error: err.Error()})
+}
+
+// mailbox records that the mailbox failed as a whole with err: its users
+// could not be read, or it stopped before they were all handled.
+func (l *failureLog) mailbox(mailboxID int, err error) {
+	l.add(db.RunFailure{MailboxID: mailboxID, Error: err.Error()})
+}
+
+func (l *failureLog) add(f db.RunFailure) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures = append(l.failures, f)
+}
+
+// list returns the failures recorded so far.
+func (l *failureLog) list() []db.RunFailure {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]db.RunFailure(nil), l.failures...)
+}
+
+// reprocessing, while reprocess runs, limits the pipeline to what failed in
+// the run being reprocessed; nil otherwise.
+var reprocessing *reprocessScope
+
+// reprocessScope is the mailboxes and users a reprocess run handles. A nil
+// *reprocessScope handles everything.
+type reprocessScope struct {
+	// mailboxes are processed in full; users only have the failed ones
+	// processed again.
+	mailboxes map[int]bool
+	users     map[db.ProcessedUser]bool
+}
+
+// newReprocessScope returns the scope reprocessing failures covers: every
+// mailbox with a failure in full, or with onlyFailed just the failed users
+// and the mailboxes that failed as a whole.
+func newReprocessScope(failures []db.RunFailure, onlyFailed bool) *reprocessScope {
+	s := &reprocessScope{mailboxes: map[int]bool{}, users: map[db.ProcessedUser]bool{}}
+	for _, f := range failures {
+		if !onlyFailed || f.UserID == 0 {
+			s.mailboxes[f.MailboxID] = true
+		} else {
+			s.users[db.ProcessedUser{MailboxID: f.MailboxID, UserID: f.UserID}] = true
+		}
+	}
+	return s
+}
+
+// wantsMailbox reports whether any user of the mailbox is to be processed.
+func (s *reprocessScope) wantsMailbox(mailboxID int) bool {
+	if s == nil || s.mailboxes[mailboxID] {
+		return true
+	}
+	for p := range s.users {
+		if p.MailboxID == mailboxID {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsUser reports whether user is to be processed.
+func (s *reprocessScope) wantsUser(user db.User) bool {
+	return s == nil || s.mailboxes[user.MailboxID] || s.users[db.ProcessedUser{MailboxID: user.MailboxID, UserID: user.ID}]
+}
+
+const reprocessUsage = "Usage: reprocess --run <id> [--only-failed]"
+
+// reprocessCommand runs the pipeline again over what failed in an earlier
+// run, as recorded with it: every mailbox that had a failure, or with
+// --only-failed just the users that failed and the mailboxes that failed as
+// a whole. It runs with the current configuration, so settings can be
+// overridden with the global --key=value flags. The new run is recorded,
+// annotated with the run it reprocessed, and exits non-zero if anything
+// failed again; its own failures can be reprocessed in turn.
+func reprocessCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	runID := fs.Int("run", 0, "ID of the run whose failures to reprocess")
+	onlyFailed := fs.Bool("only-failed", false, "process only the failed users, not the rest of their mailboxes")
+	fs.Parse(args)
+	if *runID <= 0 {
+		fatal(reprocessUsage)
+	}
+
+	rfs, ok := store.(db.RunFailureStore)
+	if !ok {
+		fatal("Store does not keep the failures of runs")
+	}
+	failures, err := rfs.RunFailures(context.Background(), *runID)
+	if err != nil {
+		fatal("Error reading failures of run", "run_id", *runID, "error", err)
+	}
+	if len(failures) == 0 {
+		slog.Info("Run has no recorded failures", "run_id", *runID)
+		return
+	}
+	reprocessing = newReprocessScope(failures, *onlyFailed)
+	slog.Info("Reprocessing run", "run_id", *runID, "failures", len(failures), "only_failed", *onlyFailed)
+
+	if err := setupLedger(context.Background(), store); err != nil {
+		fatal("Error loading ledger", "error", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, unlock, err := acquireRunLock(ctx, store)
+	if errors.Is(err, errRunLocked) {
+		slog.Warn("Not reprocessing", "reason", err)
+		return
+	}
+	if err != nil {
+		fatal("Error taking run lock", "error", err)
+	}
+
+	notes := &annotations.Set{}
+	notes.Add("reprocess_of", strconv.Itoa(*runID))
+	if *onlyFailed {
+		notes.Add("only_failed", "true")
+	}
+	pipelineErr := runPipeline(ctx, store, notes)
+	closeEmitter()
+	unlock()
+
+	if pipelineErr != nil {
+		fatal("Reprocessing failed", "run_id", *runID, "error", pipelineErr)
+	}
+}
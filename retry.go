@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/processor"
+
+	"github.com/spf13/viper"
+)
+
+// retries holds users whose processing failed until their next attempt; nil
+// when the store cannot schedule retries, in which case failures are only
+// logged.
+var retries db.RetryStore
+
+// backoff spaces out attempts exponentially: the nth retry waits
+// baseDelay * 2^(n-1), capped at maxDelay. A user is given up on after
+// maxAttempts failed attempts.
+type backoff struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var retryPolicy backoff
+
+func newRetryPolicy() backoff {
+	viper.SetDefault("retry.max_attempts", 8)
+	viper.SetDefault("retry.base_delay", 30*time.Second)
+	viper.SetDefault("retry.max_delay", time.Hour)
+
+	return backoff{
+		maxAttempts: viper.GetInt("retry.max_attempts"),
+		baseDelay:   viper.GetDuration("retry.base_delay"),
+		maxDelay:    viper.GetDuration("retry.max_delay"),
+	}
+}
+
+// delay returns how long to wait before the attempt after attempts failures.
+func (b backoff) delay(attempts int) time.Duration {
+	delay := b.baseDelay
+	for i := 1; i < attempts && delay < b.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.maxDelay {
+		delay = b.maxDelay
+	}
+	return delay
+}
+
+// Priorities for scheduled retries. Users someone enqueued by hand are
+// retried ahead of users that failed during a regular pass.
+const (
+	retryPriorityNormal = 0
+	retryPriorityQueued = 1
+)
+
+// scheduleRetry records that user failed with err on the attempt after prev
+// and schedules the next one, unless the policy's attempts are used up or
+// the processor reported the failure as permanent.
+func scheduleRetry(ctx context.Context, user db.User, prev db.Retry, err error) {
+	if retries == nil {
+		return
+	}
+	if processor.IsPermanent(err) {
+		slog.Error("Not retrying user; the failure is permanent", "user_id", user.ID, "mailbox_id", user.MailboxID, "error", err)
+		return
+	}
+
+	next := db.Retry{User: user, Priority: prev.Priority, Attempts: prev.Attempts + 1, LastError: err.Error()}
+	if next.Attempts >= retryPolicy.maxAttempts {
+		slog.Error("Giving up on user", "user_id", user.ID, "mailbox_id", user.MailboxID, "attempts", next.Attempts, "error", err)
+		return
+	}
+	next.NextAttemptAt = time.Now().Add(retryPolicy.delay(next.Attempts))
+
+	if err := retries.ScheduleRetry(ctx, next); err != nil {
+		slog.Error("Error scheduling retry", "user_id", user.ID, "error", err)
+		return
+	}
+	slog.Info("Scheduled retry", "component", "retry", "user_id", user.ID, "mailbox_id", user.MailboxID, "attempt", next.Attempts+1, "at", next.NextAttemptAt.UTC().Format(time.RFC3339))
+}
+
+// retryBatchSize bounds how many due retries are claimed at once.
+const retryBatchSize = 100
+
+// drainRetries attempts every retry that is due, in priority order.
+func drainRetries(ctx context.Context, store db.RetryStore) error {
+	for {
+		due, err := store.ClaimDueRetries(ctx, time.Now(), retryBatchSize)
+		if err != nil {
+			return err
+		}
+		refreshProcessor(ctx)
+
+		succeeded := 0
+		for _, r := range due {
+			if ok, _ := handleAttempt(ctx, r.User, r); ok {
+				succeeded++
+			}
+		}
+
+		if len(due) > 0 {
+			slog.Info("Retried users", "users", len(due), "succeeded", succeeded)
+		}
+		if len(due) < retryBatchSize {
+			return nil
+		}
+	}
+}
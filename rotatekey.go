@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"mailboxes/db"
+	"mailboxes/tokencrypt"
+
+	"github.com/spf13/viper"
+)
+
+// rotateKeyCommand re-seals every mailbox token and setting that is still
+// plaintext or sealed with an older key with tokens.encryption.primary. Run it after
+// adding a key and making it primary; the older key can be removed once it
+// reports nothing left to rotate.
+func rotateKeyCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only count the tokens that need re-sealing")
+	fs.Parse(args)
+
+	ts, ok := store.(db.TokenStore)
+	if !ok {
+		fatal("Store does not support token encryption")
+	}
+
+	result, err := ts.RotateTokens(context.Background(), *dryRun)
+	if err != nil {
+		fatal("Error rotating tokens", "rotated", result.Rotated, "error", err)
+	}
+	verb := "Re-sealed"
+	if *dryRun {
+		verb = "Would re-seal"
+	}
+	slog.Info(verb+" mailbox tokens and settings", "rotated", result.Rotated, "checked", result.Checked,
+		"settings_rotated", result.SettingsRotated, "settings_checked", result.SettingsChecked, "key", viper.GetString("tokens.encryption.primary"))
+}
+
+// tokenKeyring loads the keys configured under tokens.encryption: a map of
+// key IDs to key sources in keys, and a single key source in key with the ID
+// "default", which suits setting it from MAILBOXES_TOKENS_ENCRYPTION_KEY.
+// Secret references in a source are resolved before the key is loaded. It
+// returns nil when no key is configured.
+func tokenKeyring(ctx context.Context) (*tokencrypt.Keyring, error) {
+	sources := viper.GetStringMapString("tokens.encryption.keys")
+	if source := viper.GetString("tokens.encryption.key"); source != "" {
+		sources["default"] = source
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(sources))
+	for id := range sources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) == 1 {
+		viper.SetDefault("tokens.encryption.primary", ids[0])
+	}
+	primary := viper.GetString("tokens.encryption.primary")
+	if primary == "" {
+		return nil, fmt.Errorf("tokens.encryption.primary must name one of %v", ids)
+	}
+
+	keys := make(map[string][]byte, len(sources))
+	for _, id := range ids {
+		source, err := secretProviders.Expand(ctx, sources[id])
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		key, err := tokencrypt.LoadKey(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return tokencrypt.NewKeyring(primary, keys)
+}
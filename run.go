@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"mailboxes/annotations"
+	"mailboxes/chaos"
+	"mailboxes/db"
+	"mailboxes/debugbundle"
+	"mailboxes/filter"
+	"mailboxes/runreport"
+	"mailboxes/slo"
+
+	"github.com/spf13/viper"
+)
+
+// runCommand processes every mailbox once. With --debug-user, every step
+// taken for that user is written to a debug bundle when the run ends. The
+// command exits non-zero if any mailbox failed. Progress is checkpointed as
+// the run goes, and --resume continues a run that was interrupted or
+// failed, skipping the mailboxes and users it completed. With lock.enabled,
+// the command does nothing while another instance holds the run lock. With
+// standby.url set, the checkpoint is also pushed to object storage, and
+// --resume on a store without one restores it from there. --report prints a
+// summary of the run when it ends; see reportRun.
+func runCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	debugID := fs.Int("debug-user", 0, "record every processor interaction for this user ID")
+	bundlePath := fs.String("debug-bundle", "", "where to write the --debug-user bundle (default debug-user-<id>.json)")
+	resume := fs.Bool("resume", false, "continue the last interrupted run instead of starting over")
+	expr := fs.String("filter", "", "process only the mailboxes and users matching this expression, in place of pipeline.filter")
+	fs.BoolVar(&printRunReport, "report", false, "print a summary table of the run when it ends")
+	fs.Parse(args)
+
+	if *expr != "" {
+		var err error
+		if pipelineFilter, err = filter.Compile(*expr); err != nil {
+			fatal("Error compiling --filter", "error", err)
+		}
+	}
+
+	if *debugID != 0 {
+		debugUser = debugbundle.New(*debugID, viper.GetStringSlice("debug.redact_fields")...)
+		if us, ok := store.(db.UserStore); ok {
+			user, err := us.GetUserByID(context.Background(), *debugID)
+			if err != nil {
+				fatal("Error looking up debug user", "user_id", *debugID, "error", err)
+			}
+			debugMailboxID = user.MailboxID
+		} else {
+			slog.Warn("Store cannot look up users; token checks will not be recorded", "user_id", *debugID)
+		}
+		if *bundlePath == "" {
+			*bundlePath = fmt.Sprintf("debug-user-%d.json", *debugID)
+		}
+	}
+
+	if err := setupLedger(context.Background(), store); err != nil {
+		fatal("Error loading ledger", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, unlock, err := acquireRunLock(ctx, store)
+	if errors.Is(err, errRunLocked) {
+		slog.Warn("Not running", "reason", err)
+		return
+	}
+	if err != nil {
+		fatal("Error taking run lock", "error", err)
+	}
+	if *resume {
+		if err := standby.restore(ctx, false); err != nil {
+			slog.Error("Error restoring standby state; resuming from the store's own", "error", err)
+		}
+	}
+	if err := setupCheckpoint(ctx, store, *resume); err != nil {
+		unlock()
+		fatal("Error loading checkpoint", "error", err)
+	}
+	standby.startPushing()
+
+	if monkey != nil {
+		store = chaos.NewStore(store, monkey)
+	}
+	pipelineErr := runPipeline(ctx, store, &annotations.Set{})
+	standby.stopPushing()
+	closeEmitter()
+	unlock()
+
+	if debugUser != nil {
+		if err := debugUser.WriteFile(*bundlePath); err != nil {
+			fatal("Error writing debug bundle", "error", err)
+		}
+		slog.Info("Wrote debug bundle", "interactions", debugUser.Len(), "user_id", *debugID, "path", *bundlePath)
+	}
+
+	if pipelineErr != nil {
+		fatal("Pipeline failed", "error", pipelineErr)
+	}
+}
+
+// printRunReport is set by run --report to print each run's summary table
+// to stdout.
+var printRunReport bool
+
+// runPipeline processes every mailbox once, within pipeline.timeout, and
+// reports on the run: it finishes the checkpoint, records the run with the
+// annotations collected in notes, logs the shadow comparison and SLO report
+// and writes the run summary.
+func runPipeline(ctx context.Context, store db.Store, notes *annotations.Set) error {
+	if viper.IsSet("slo.target") {
+		viper.SetDefault("slo.objective", 0.99)
+		sloTracker = slo.New(time.Now(), viper.GetDuration("slo.target"), viper.GetFloat64("slo.objective"))
+	}
+	runReport = nil
+	if printRunReport || viper.GetString("report.dir") != "" || reportSink != nil {
+		runReport = runreport.New(time.Now())
+	}
+
+	if timeout := viper.GetDuration("pipeline.timeout"); timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, timeout)
+		defer stop()
+	}
+	if pipelineFilter != nil {
+		slog.Info("Filtering run", "filter", pipelineFilter.String())
+		notes.Add("filter", pipelineFilter.String())
+	}
+	ctx = annotations.NewContext(ctx, notes)
+	started := time.Now()
+	runFailures = &failureLog{}
+	if err := sources.refresh(ctx); err != nil {
+		slog.Error("Error loading user provenance; events carry the last sources loaded", "error", err)
+	}
+	pipelineErr := Pipeline(ctx, store)
+	checkpoint.finish(pipelineErr == nil && ctx.Err() == nil)
+	recordRun(store, started, notes.Map(), pipelineErr)
+
+	if compared, differed, err := shadowRun.Stats(); compared > 0 || err != nil {
+		slog.Info("Shadow comparison finished", "compared", compared, "differed", differed, "diff_file", viper.GetString("pipeline.shadow.diff_file"))
+		if err != nil {
+			slog.Error("Error writing shadow diffs", "error", err)
+		}
+	}
+
+	if sloTracker != nil {
+		r := sloTracker.Report()
+		r.Annotations = notes.Map()
+		reportSLO(ctx, r)
+	}
+
+	if runReport != nil {
+		viper.SetDefault("report.top", 10)
+		r := runReport.Report(time.Now(), pipelineErr, viper.GetInt("report.top"))
+		r.Annotations = notes.Map()
+		reportRun(r)
+	}
+	return pipelineErr
+}
+
+// recordRun adds the run to the store's run history, if it keeps one, with
+// the users and mailboxes that failed in it.
+func recordRun(store db.Store, started time.Time, notes map[string]string, pipelineErr error) {
+	rs, ok := store.(db.RunStore)
+	if !ok {
+		return
+	}
+	run := db.Run{StartedAt: started, FinishedAt: time.Now(), Status: db.RunSucceeded, Annotations: notes}
+	if pipelineErr != nil {
+		run.Status = db.RunFailed
+	}
+	// Like the SLO alert, the record matters most when the run was cancelled.
+	run, err := rs.RecordRun(context.Background(), run)
+	if err != nil {
+		slog.Error("Error recording run", "error", err)
+		return
+	}
+	slog.Info("Recorded run", "run_id", run.ID, "status", run.Status, "annotations", run.Annotations)
+
+	failures := runFailures.list()
+	if rfs, ok := store.(db.RunFailureStore); ok && len(failures) > 0 {
+		if err := rfs.RecordRunFailures(context.Background(), run.ID, failures); err != nil {
+			slog.Error("Error recording run failures", "run_id", run.ID, "error", err)
+			return
+		}
+		slog.Info("Recorded run failures", "run_id", run.ID, "failures", len(failures))
+	}
+}
+
+// reportSLO logs how the run did against the objective, writes the report to
+// slo.report_file if set, and sends it to sloSink when any mailbox violated
+// the objective.
+func reportSLO(ctx context.Context, r slo.Report) {
+	level := slog.LevelInfo
+	if r.BurnRate > 1 {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "SLO report", "target", r.Target, "objective", r.Objective, "mailboxes", r.Mailboxes,
+		"violations", len(r.Violations), "compliance", r.Compliance, "burn_rate", r.BurnRate)
+	for _, v := range r.Violations {
+		slog.Warn("Mailbox violated SLO", "mailbox_id", v.MailboxID, "reason", v.Reason, "detail", v.Detail, "elapsed_seconds", v.Elapsed)
+	}
+
+	if path := viper.GetString("slo.report_file"); path != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			slog.Error("Error writing SLO report", "path", path, "error", err)
+		}
+	}
+
+	if sloSink == nil || len(r.Violations) == 0 {
+		return
+	}
+	// The run's own context may already be cancelled or timed out, which is
+	// when an alert matters most.
+	if err := sloSink.Send(context.Background(), r); err != nil {
+		slog.Error("Error sending SLO report", "sink", viper.GetString("slo.sink"), "error", err)
+	}
+}
+
+// reportRun writes the run summary as JSON and as a table to report.dir, if
+// set, as run-<start>.json and run-<start>.txt; sends it to reportSink; and
+// prints the table with run --report.
+func reportRun(r runreport.Report) {
+	if printRunReport {
+		r.WriteTable(os.Stdout)
+	}
+
+	if dir := viper.GetString("report.dir"); dir != "" {
+		base := filepath.Join(dir, "run-"+r.RunStart.Format("20060102T150405Z"))
+		err := os.MkdirAll(dir, 0o755)
+		if err == nil {
+			var data []byte
+			if data, err = json.MarshalIndent(r, "", "  "); err == nil {
+				err = os.WriteFile(base+".json", append(data, '\n'), 0o644)
+			}
+		}
+		if err == nil {
+			var table bytes.Buffer
+			r.WriteTable(&table)
+			err = os.WriteFile(base+".txt", table.Bytes(), 0o644)
+		}
+		if err != nil {
+			slog.Error("Error writing run report", "dir", dir, "error", err)
+		} else {
+			slog.Info("Wrote run report", "path", base+".json")
+		}
+	}
+
+	if reportSink == nil {
+		return
+	}
+	// Like the SLO alert, the summary matters most when the run was
+	// cancelled or timed out.
+	if err := reportSink.Send(context.Background(), r); err != nil {
+		slog.Error("Error sending run report", "sink", viper.GetString("report.sink"), "error", err)
+	}
+}
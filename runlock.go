@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// runLockName is the lease, or advisory lock, that instances sharing a
+// database take before running the pipeline.
+const runLockName = "pipeline"
+
+// errRunLocked is returned by acquireRunLock when another instance holds the
+// run lock.
+var errRunLocked = errors.New("another instance is running the pipeline")
+
+// errLeaseLost is why a run is cancelled when its lease is taken over.
+var errLeaseLost = errors.New("run lock lost")
+
+// lockHolder names this instance in the leases it takes.
+func lockHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// acquireRunLock takes the run lock when lock.enabled is set, so that only
+// one instance sharing the database runs the pipeline at a time, and returns
+// a context for the run and the function that releases the lock. It returns
+// errRunLocked if another instance has the lock.
+//
+// With lock.mode lease, the default, the lock is a row in run_leases that
+// expires after lock.ttl unless renewed, which is done every third of it;
+// the run is cancelled if the lease is lost. An instance that dies leaves
+// its lease to be taken over once it has been expired for
+// lock.takeover_after, or never with lock.takeover false. With lock.mode
+// advisory the lock is a PostgreSQL advisory lock, freed by the database if
+// the instance dies.
+func acquireRunLock(ctx context.Context, store db.Store) (context.Context, func(), error) {
+	if !viper.GetBool("lock.enabled") {
+		return ctx, func() {}, nil
+	}
+	viper.SetDefault("lock.mode", "lease")
+	viper.SetDefault("lock.ttl", time.Minute)
+	viper.SetDefault("lock.takeover", true)
+
+	switch mode := viper.GetString("lock.mode"); mode {
+	case "lease":
+		return acquireLease(ctx, store)
+	case "advisory":
+		al, ok := store.(db.AdvisoryLocker)
+		if !ok {
+			return nil, nil, errors.New("store has no advisory locks")
+		}
+		unlock, ok, err := al.TryAdvisoryLock(ctx, runLockName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, errRunLocked
+		}
+		slog.Info("Took run lock", "mode", mode)
+		return ctx, func() {
+			if err := unlock(); err != nil {
+				slog.Error("Error releasing run lock", "error", err)
+			}
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("lock.mode must be lease or advisory, not %q", mode)
+	}
+}
+
+func acquireLease(ctx context.Context, store db.Store) (context.Context, func(), error) {
+	ls, ok := store.(db.LeaseStore)
+	if !ok {
+		return nil, nil, errors.New("store does not keep leases")
+	}
+	ttl := viper.GetDuration("lock.ttl")
+	if ttl <= 0 {
+		return nil, nil, fmt.Errorf("lock.ttl must be positive, not %s", ttl)
+	}
+	takeover := viper.GetDuration("lock.takeover_after")
+	if !viper.GetBool("lock.takeover") {
+		takeover = -1
+	}
+
+	holder := lockHolder()
+	lease, ok, err := ls.AcquireLease(ctx, runLockName, holder, ttl, takeover)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		expires := lease.ExpiresAt.UTC().Format(time.RFC3339)
+		if time.Now().After(lease.ExpiresAt) {
+			return nil, nil, fmt.Errorf("%w: the lease %s let expire at %s cannot be taken over yet", errRunLocked, lease.Holder, expires)
+		}
+		return nil, nil, fmt.Errorf("%w: %s holds the lease until %s", errRunLocked, lease.Holder, expires)
+	}
+	slog.Info("Took run lock", "mode", "lease", "holder", holder, "expires_at", lease.ExpiresAt)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		renewLease(ctx, ls, holder, ttl, lease.ExpiresAt, done, cancel)
+	}()
+
+	release := func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+		// The run's own context may already be cancelled.
+		if err := ls.ReleaseLease(context.Background(), runLockName, holder); err != nil {
+			slog.Error("Error releasing run lock", "error", err)
+		}
+	}
+	return ctx, release, nil
+}
+
+// renewLease renews holder's lease every third of ttl until done is closed.
+// It cancels the run if the lease is taken over, or if it expires before a
+// renewal succeeds.
+func renewLease(ctx context.Context, ls db.LeaseStore, holder string, ttl time.Duration, expires time.Time, done <-chan struct{}, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		lease, err := ls.RenewLease(ctx, runLockName, holder, ttl)
+		switch {
+		case err == nil:
+			expires = lease.ExpiresAt
+		case errors.Is(err, db.ErrLeaseLost):
+			slog.Error("Run lock taken over; stopping the run", "error", err)
+			cancel(errLeaseLost)
+			return
+		case !time.Now().Before(expires):
+			slog.Error("Run lock expired before it could be renewed; stopping the run", "error", err)
+			cancel(errLeaseLost)
+			return
+		default:
+			slog.Warn("Error renewing run lock", "expires_at", expires, "error", err)
+		}
+	}
+}
+
+const lockUsage = "Usage: lock status | release [--force]"
+
+// lockCommand shows who holds the run lease, and releases a lease left by
+// an instance that died when takeover is turned off.
+func lockCommand(store db.Store, args []string) {
+	ls, ok := store.(db.LeaseStore)
+	if !ok {
+		fatal("Store does not keep leases")
+	}
+	if len(args) == 0 {
+		fatal(lockUsage)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		leases, err := ls.Leases(ctx)
+		if err != nil {
+			fatal("Error reading leases", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tHOLDER\tACQUIRED\tEXPIRES")
+		for _, l := range leases {
+			expires := l.ExpiresAt.UTC().Format(time.RFC3339)
+			if time.Now().After(l.ExpiresAt) {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", l.Name, l.Holder, l.AcquiredAt.UTC().Format(time.RFC3339), expires)
+		}
+		w.Flush()
+	case "release":
+		if len(args) < 2 || args[1] != "--force" {
+			fatal("Releasing the run lock lets another instance start while the holder may still be running; confirm with release --force")
+		}
+		if err := ls.ReleaseLease(ctx, runLockName, ""); err != nil {
+			fatal("Error releasing run lock", "error", err)
+		}
+		slog.Info("Released run lock")
+	default:
+		fatal(lockUsage)
+	}
+}
@@ -0,0 +1,174 @@
+// Package runreport summarizes a pipeline run once it ends: how many users
+// each mailbox had processed and failed, how long each took, which were
+// skipped and which were slowest. The summary is written as JSON for
+// machines and as a table for people.
+package runreport
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Mailbox is the outcome of one mailbox in a run.
+type Mailbox struct {
+	MailboxID int     `json:"mailbox_id"`
+	Users     int     `json:"users"`
+	Failed    int     `json:"failed"`
+	Duration  float64 `json:"duration_seconds"`
+	Error     string  `json:"error,omitempty"`
+	// Skipped is why the mailbox was not processed at all, if it was not.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Report summarizes a run. Users and Failed are totals over every mailbox;
+// FailedMailboxes counts the mailboxes that ended with an error.
+type Report struct {
+	RunStart         time.Time `json:"run_start"`
+	RunEnd           time.Time `json:"run_end"`
+	Duration         float64   `json:"duration_seconds"`
+	Status           string    `json:"status"`
+	Error            string    `json:"error,omitempty"`
+	Mailboxes        int       `json:"mailboxes"`
+	FailedMailboxes  int       `json:"failed_mailboxes"`
+	SkippedMailboxes int       `json:"skipped_mailboxes"`
+	Users            int       `json:"users"`
+	Failed           int       `json:"failed"`
+	// Slowest are the mailboxes that took longest, slowest first.
+	Slowest []Mailbox `json:"slowest"`
+	// PerMailbox lists every mailbox by ID.
+	PerMailbox []Mailbox `json:"per_mailbox"`
+	// Annotations are the run's annotations, set by the caller.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Recorder collects the outcome of every mailbox in a run. It is safe for
+// concurrent use, and a nil *Recorder records nothing, so callers can hold
+// one unconditionally.
+type Recorder struct {
+	start time.Time
+
+	mu        sync.Mutex
+	mailboxes []Mailbox
+}
+
+// New returns a Recorder for a run started at start.
+func New(start time.Time) *Recorder {
+	return &Recorder{start: start}
+}
+
+// Done records that a mailbox's pass took d, processed users and failed
+// failed of them, ending with err if it failed.
+func (r *Recorder) Done(mailboxID, users, failed int, d time.Duration, err error) {
+	if r == nil {
+		return
+	}
+	m := Mailbox{MailboxID: mailboxID, Users: users, Failed: failed, Duration: d.Seconds()}
+	if err != nil {
+		m.Error = err.Error()
+	}
+	r.add(m)
+}
+
+// Skipped records that a mailbox was not processed at all, for the given
+// reason.
+func (r *Recorder) Skipped(mailboxID int, reason string) {
+	if r == nil {
+		return
+	}
+	r.add(Mailbox{MailboxID: mailboxID, Skipped: reason})
+}
+
+func (r *Recorder) add(m Mailbox) {
+	r.mu.Lock()
+	r.mailboxes = append(r.mailboxes, m)
+	r.mu.Unlock()
+}
+
+// Report summarizes the mailboxes recorded so far for a run that ended at
+// end with runErr, listing the top slowest. A nil Recorder reports nothing.
+func (r *Recorder) Report(end time.Time, runErr error, top int) Report {
+	if r == nil {
+		return Report{}
+	}
+	r.mu.Lock()
+	mailboxes := append([]Mailbox{}, r.mailboxes...)
+	r.mu.Unlock()
+
+	rep := Report{
+		RunStart:  r.start.UTC(),
+		RunEnd:    end.UTC(),
+		Duration:  end.Sub(r.start).Seconds(),
+		Status:    "succeeded",
+		Mailboxes: len(mailboxes),
+	}
+	if runErr != nil {
+		rep.Status, rep.Error = "failed", runErr.Error()
+	}
+	for _, m := range mailboxes {
+		rep.Users += m.Users
+		rep.Failed += m.Failed
+		switch {
+		case m.Skipped != "":
+			rep.SkippedMailboxes++
+		case m.Error != "":
+			rep.FailedMailboxes++
+		}
+	}
+
+	sort.Slice(mailboxes, func(i, j int) bool {
+		if mailboxes[i].Duration != mailboxes[j].Duration {
+			return mailboxes[i].Duration > mailboxes[j].Duration
+		}
+		return mailboxes[i].MailboxID < mailboxes[j].MailboxID
+	})
+	rep.Slowest = append([]Mailbox{}, mailboxes[:min(top, len(mailboxes))]...)
+	sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].MailboxID < mailboxes[j].MailboxID })
+	rep.PerMailbox = mailboxes
+	return rep
+}
+
+// WriteTable writes the report to w as aligned text: the totals, then the
+// slowest mailboxes and every mailbox that failed or was skipped.
+func (rep Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Run\t%s\t%s to %s (%s)\n", rep.Status,
+		rep.RunStart.Format(time.RFC3339), rep.RunEnd.Format(time.RFC3339), seconds(rep.Duration))
+	fmt.Fprintf(tw, "Mailboxes\t%d\t%d failed, %d skipped\n", rep.Mailboxes, rep.FailedMailboxes, rep.SkippedMailboxes)
+	fmt.Fprintf(tw, "Users\t%d\t%d failed\n", rep.Users, rep.Failed)
+	if rep.Error != "" {
+		fmt.Fprintf(tw, "Error\t%s\t\n", rep.Error)
+	}
+
+	if len(rep.Slowest) > 0 {
+		fmt.Fprintln(tw, "\nSlowest\tMAILBOX\tUSERS\tFAILED\tDURATION")
+		for _, m := range rep.Slowest {
+			fmt.Fprintf(tw, "\t%d\t%d\t%d\t%s\n", m.MailboxID, m.Users, m.Failed, seconds(m.Duration))
+		}
+	}
+
+	header := false
+	for _, m := range rep.PerMailbox {
+		if m.Error == "" && m.Skipped == "" {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(tw, "\nProblems\tMAILBOX\tUSERS\tFAILED\tDETAIL")
+			header = true
+		}
+		detail := m.Error
+		if m.Skipped != "" {
+			detail = "skipped: " + m.Skipped
+		}
+		fmt.Fprintf(tw, "\t%d\t%d\t%d\t%s\n", m.MailboxID, m.Users, m.Failed, detail)
+	}
+	return tw.Flush()
+}
+
+// seconds formats s seconds as a duration rounded to the millisecond.
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
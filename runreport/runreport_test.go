@@ -0,0 +1,59 @@
+package runreport
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Report(t *testing.T) {
+	start := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+	r := New(start)
+	r.Done(3, 10, 0, 2*time.Second, nil)
+	r.Done(1, 5, 2, 4*time.Second, errors.New("mailbox 1: 2 of 7 users failed"))
+	r.Skipped(4, "token_expired")
+	r.Done(2, 7, 0, time.Second, nil)
+
+	rep := r.Report(start.Add(time.Minute), errors.New("mailbox 1 failed"), 2)
+	if rep.Status != "failed" || rep.Duration != 60 {
+		t.Errorf("Expected a failed run of 60s, got %s of %vs", rep.Status, rep.Duration)
+	}
+	if rep.Mailboxes != 4 || rep.FailedMailboxes != 1 || rep.SkippedMailboxes != 1 || rep.Users != 22 || rep.Failed != 2 {
+		t.Errorf("Unexpected totals %+v", rep)
+	}
+	expected := []Mailbox{
+		{MailboxID: 1, Users: 5, Failed: 2, Duration: 4, Error: "mailbox 1: 2 of 7 users failed"},
+		{MailboxID: 3, Users: 10, Duration: 2},
+	}
+	if !reflect.DeepEqual(rep.Slowest, expected) {
+		t.Errorf("Expected slowest %+v, got %+v", expected, rep.Slowest)
+	}
+	var ids []int
+	for _, m := range rep.PerMailbox {
+		ids = append(ids, m.MailboxID)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3, 4}) {
+		t.Errorf("Expected every mailbox by ID, got %v", ids)
+	}
+
+	var b strings.Builder
+	if err := rep.WriteTable(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Run", "failed", "Users", "22", "Slowest", "Problems", "skipped: token_expired", "2 of 7 users failed"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected the table to contain %q, got:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var r *Recorder
+	r.Done(1, 1, 0, time.Second, nil)
+	r.Skipped(2, "token_expired")
+	if rep := r.Report(time.Now(), nil, 10); rep.Mailboxes != 0 {
+		t.Errorf("Expected a nil Recorder to record nothing, got %+v", rep)
+	}
+}
@@ -0,0 +1,280 @@
+// Package scheduler runs named run specs on their own cron schedules inside
+// the process, so the pipeline doesn't need an external cron. Each spec has
+// a cron expression, a timezone, a random start delay to spread runs out and
+// a maximum runtime, and either skips or queues times that come due while
+// its previous run is still going. Reconcile applies a new set of specs while running:
+// added specs are scheduled, removed ones stopped and changed ones
+// rescheduled, without touching the rest.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Spec describes when one run is started.
+type Spec struct {
+	Name string `mapstructure:"name" json:"name"`
+	// Cron is a cron expression as accepted by ParseCron.
+	Cron string `mapstructure:"cron" json:"cron"`
+	// Timezone is the IANA name of the zone Cron is read in; UTC if empty.
+	Timezone string `mapstructure:"timezone" json:"timezone,omitempty"`
+	// Jitter delays each run by a random duration up to Jitter.
+	Jitter time.Duration `mapstructure:"jitter" json:"jitter,omitempty"`
+	// MaxRuntime, if set, cancels a run that takes longer.
+	MaxRuntime time.Duration `mapstructure:"max_runtime" json:"max_runtime,omitempty"`
+	// Overlap is OverlapSkip, the default, or OverlapQueue.
+	Overlap string `mapstructure:"overlap" json:"overlap,omitempty"`
+}
+
+// What happens to a run that comes due while the spec's previous run is
+// still going: it is skipped, or started as soon as the previous run
+// returns. Any number of such times queue a single run.
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+)
+
+// schedule parses the spec's cron expression and timezone, and checks its
+// overlap policy.
+func (sp Spec) schedule() (Cron, *time.Location, error) {
+	if sp.Overlap != "" && sp.Overlap != OverlapSkip && sp.Overlap != OverlapQueue {
+		return Cron{}, nil, fmt.Errorf("spec %s: overlap must be %s or %s, not %q", sp.Name, OverlapSkip, OverlapQueue, sp.Overlap)
+	}
+	c, err := ParseCron(sp.Cron)
+	if err != nil {
+		return Cron{}, nil, fmt.Errorf("spec %s: %w", sp.Name, err)
+	}
+	loc, err := time.LoadLocation(sp.Timezone)
+	if err != nil {
+		return Cron{}, nil, fmt.Errorf("spec %s: %w", sp.Name, err)
+	}
+	return c, loc, nil
+}
+
+// Func runs a spec. ctx is cancelled when the spec's MaxRuntime passes or
+// the scheduler stops.
+type Func func(ctx context.Context, spec Spec) error
+
+// Scheduler starts runs as their specs' schedules come due. A spec's runs
+// never overlap, even after the spec was changed: times that come due while
+// its previous run is still going are skipped or queued.
+type Scheduler struct {
+	ctx context.Context
+	run Func
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	running map[string]chan struct{}
+	wg      sync.WaitGroup
+
+	// now and jitter are replaced in tests.
+	now    func() time.Time
+	jitter func(max time.Duration) time.Duration
+}
+
+type entry struct {
+	spec Spec
+	stop chan struct{}
+}
+
+// New returns a Scheduler with no specs that starts runs with run until ctx
+// is done.
+func New(ctx context.Context, run Func) *Scheduler {
+	return &Scheduler{
+		ctx:     ctx,
+		run:     run,
+		entries: make(map[string]*entry),
+		running: make(map[string]chan struct{}),
+		now:     time.Now,
+		jitter: func(max time.Duration) time.Duration {
+			if max <= 0 {
+				return 0
+			}
+			return time.Duration(rand.Int63n(int64(max)))
+		},
+	}
+}
+
+// Reconcile makes specs the scheduled set. Specs are validated first, so an
+// invalid set leaves the schedule unchanged. A run in progress for a spec
+// that is changed or removed is left to finish.
+func (s *Scheduler) Reconcile(specs []Spec) error {
+	seen := make(map[string]bool, len(specs))
+	for _, sp := range specs {
+		if sp.Name == "" {
+			return errors.New("spec without a name")
+		}
+		if seen[sp.Name] {
+			return fmt.Errorf("spec %s is defined twice", sp.Name)
+		}
+		seen[sp.Name] = true
+		if _, _, err := sp.schedule(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, e := range s.entries {
+		if !seen[name] {
+			close(e.stop)
+			delete(s.entries, name)
+			slog.Info("Unscheduled run spec", "spec", name)
+		}
+	}
+	for _, sp := range specs {
+		if e, ok := s.entries[sp.Name]; ok {
+			if e.spec == sp {
+				continue
+			}
+			close(e.stop)
+		}
+		e := &entry{spec: sp, stop: make(chan struct{})}
+		s.entries[sp.Name] = e
+		s.wg.Add(1)
+		go s.loop(e)
+		slog.Info("Scheduled run spec", "spec", sp.Name, "cron", sp.Cron, "timezone", sp.Timezone, "next", s.next(sp))
+	}
+	return nil
+}
+
+// Specs returns the scheduled specs.
+func (s *Scheduler) Specs() []Spec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	specs := make([]Spec, 0, len(s.entries))
+	for _, e := range s.entries {
+		specs = append(specs, e.spec)
+	}
+	return specs
+}
+
+// Wait blocks until the scheduler's context is done and every run has
+// returned.
+func (s *Scheduler) Wait() {
+	<-s.ctx.Done()
+	s.wg.Wait()
+}
+
+// Stop unschedules every spec and blocks until the runs in progress return.
+// Unlike cancelling the scheduler's context, it lets them finish; queued
+// runs are dropped.
+func (s *Scheduler) Stop() {
+	s.Reconcile(nil)
+	s.wg.Wait()
+}
+
+// next returns when sp is next due after now, before jitter, or the zero
+// time if never.
+func (s *Scheduler) next(sp Spec) time.Time {
+	return s.nextAfter(sp, s.now())
+}
+
+// nextAfter returns when sp is next due after t, or the zero time if never.
+func (s *Scheduler) nextAfter(sp Spec, t time.Time) time.Time {
+	c, loc, _ := sp.schedule()
+	return c.Next(t.In(loc))
+}
+
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	queued := false
+	for {
+		select {
+		case <-e.stop:
+			return
+		default:
+		}
+
+		due := s.next(e.spec)
+		if due.IsZero() {
+			slog.Warn("Run spec never comes due", "spec", e.spec.Name, "cron", e.spec.Cron)
+			return
+		}
+		wait := due.Sub(s.now()) + s.jitter(e.spec.Jitter)
+		if queued {
+			due, wait = s.now(), 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-e.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.start(e.spec, e.stop)
+
+		missed := s.nextAfter(e.spec, due)
+		overran := !missed.IsZero() && !missed.After(s.now())
+		queued = overran && e.spec.Overlap == OverlapQueue
+		switch {
+		case queued:
+			slog.Info("Starting queued run; it came due while the previous one was running", "spec", e.spec.Name, "due", missed)
+		case overran:
+			slog.Warn("Skipped scheduled run; it came due while the previous one was running", "spec", e.spec.Name, "due", missed)
+		}
+	}
+}
+
+// start runs sp once, within its MaxRuntime. If sp is already running, the
+// run is skipped, or with OverlapQueue started once the other returns,
+// unless stop is closed first.
+func (s *Scheduler) start(sp Spec, stop <-chan struct{}) {
+	for {
+		s.mu.Lock()
+		other, busy := s.running[sp.Name]
+		if !busy {
+			s.running[sp.Name] = make(chan struct{})
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+
+		if sp.Overlap != OverlapQueue {
+			slog.Warn("Skipping scheduled run; the previous one is still running", "spec", sp.Name)
+			return
+		}
+		slog.Info("Queueing scheduled run until the previous one returns", "spec", sp.Name)
+		select {
+		case <-other:
+		case <-stop:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+	defer func() {
+		s.mu.Lock()
+		close(s.running[sp.Name])
+		delete(s.running, sp.Name)
+		s.mu.Unlock()
+	}()
+
+	ctx := s.ctx
+	if sp.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sp.MaxRuntime)
+		defer cancel()
+	}
+
+	started := time.Now()
+	slog.Info("Starting scheduled run", "spec", sp.Name)
+	err := s.run(ctx, sp)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Warn("Scheduled run exceeded its max runtime", "spec", sp.Name, "max_runtime", sp.MaxRuntime)
+	}
+	if err != nil {
+		slog.Error("Scheduled run failed", "spec", sp.Name, "duration", time.Since(started), "error", err)
+		return
+	}
+	slog.Info("Finished scheduled run", "spec", sp.Name, "duration", time.Since(started))
+}
@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCron_Next(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No timezone data: %v", err)
+	}
+	from := time.Date(2024, 7, 23, 12, 7, 30, 0, time.UTC) // a Tuesday
+
+	tests := []struct {
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		{expr: "* * * * *", from: from, expected: time.Date(2024, 7, 23, 12, 8, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", from: from, expected: time.Date(2024, 7, 23, 12, 15, 0, 0, time.UTC)},
+		{expr: "0 9-17/4 * * *", from: from, expected: time.Date(2024, 7, 23, 13, 0, 0, 0, time.UTC)},
+		{expr: "30 2 * * mon-fri", from: from, expected: time.Date(2024, 7, 24, 2, 30, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", from: from, expected: time.Date(2024, 7, 28, 0, 0, 0, 0, time.UTC)},
+		{expr: "@monthly", from: from, expected: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1,15 feb *", from: from, expected: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// With both day fields restricted either one matches.
+		{expr: "0 0 13 * fri", from: from, expected: time.Date(2024, 7, 26, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", from: from, expected: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *", from: from, expected: time.Time{}},
+		{expr: "0 9 * * *", from: from.In(ny), expected: time.Date(2024, 7, 23, 9, 0, 0, 0, ny)},
+		// 2:30 does not exist on the day clocks go forward.
+		{expr: "30 2 * * *", from: time.Date(2024, 3, 10, 0, 0, 0, 0, ny), expected: time.Date(2024, 3, 11, 2, 30, 0, 0, ny)},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", tt.expr, err)
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.expected) {
+			t.Errorf("%q after %s: expected %s, got %s", tt.expr, tt.from, tt.expected, got)
+		}
+	}
+}
+
+func TestParseCron_Errors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("Expected an error parsing %q", expr)
+		}
+	}
+}
+
+// testScheduler returns a scheduler whose clock stands a millisecond before
+// a minute, so every spec comes due at once and again after each run.
+func testScheduler(ctx context.Context, run Func) *Scheduler {
+	s := New(ctx, run)
+	s.now = func() time.Time { return time.Date(2024, 7, 23, 12, 7, 59, 999e6, time.UTC) }
+	return s
+}
+
+func TestScheduler_Reconcile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan string, 100)
+	s := testScheduler(ctx, func(ctx context.Context, sp Spec) error {
+		select {
+		case ran <- sp.Name:
+		default:
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+
+	if err := s.Reconcile([]Spec{{Name: "nightly", Cron: "* * * * *", Timezone: "UTC"}}); err != nil {
+		t.Fatalf("Error reconciling: %v", err)
+	}
+	if name := <-ran; name != "nightly" {
+		t.Errorf("Expected nightly to run, got %s", name)
+	}
+
+	invalid := [][]Spec{
+		{{Name: "bad", Cron: "* * *"}},
+		{{Name: "bad", Cron: "* * * * *", Timezone: "Nowhere/Special"}},
+		{{Cron: "* * * * *"}},
+		{{Name: "twice", Cron: "* * * * *"}, {Name: "twice", Cron: "@daily"}},
+		{{Name: "bad", Cron: "* * * * *", Overlap: "wait"}},
+	}
+	for _, specs := range invalid {
+		if err := s.Reconcile(specs); err == nil {
+			t.Errorf("Expected an error reconciling %+v", specs)
+		}
+	}
+	if specs := s.Specs(); len(specs) != 1 || specs[0].Name != "nightly" {
+		t.Errorf("Expected an invalid set to leave the schedule unchanged, got %+v", specs)
+	}
+
+	if err := s.Reconcile([]Spec{{Name: "hourly", Cron: "* * * * *"}}); err != nil {
+		t.Fatalf("Error reconciling: %v", err)
+	}
+	if specs := s.Specs(); len(specs) != 1 || specs[0].Name != "hourly" {
+		t.Errorf("Expected only hourly to be scheduled, got %+v", specs)
+	}
+	for name := range ran {
+		if name == "hourly" {
+			break
+		}
+	}
+
+	cancel()
+	s.Wait()
+}
+
+func TestScheduler_MaxRuntime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	s := testScheduler(ctx, func(ctx context.Context, sp Spec) error {
+		<-ctx.Done()
+		select {
+		case result <- ctx.Err():
+		default:
+		}
+		return ctx.Err()
+	})
+
+	s.start(Spec{Name: "slow", Cron: "* * * * *", MaxRuntime: 10 * time.Millisecond}, nil)
+	if err := <-result; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the run to be cancelled after its max runtime, got %v", err)
+	}
+}
+
+func TestScheduler_NoOverlap(t *testing.T) {
+	release := make(chan struct{})
+	runs := make(chan struct{}, 2)
+	s := testScheduler(context.Background(), func(ctx context.Context, sp Spec) error {
+		runs <- struct{}{}
+		<-release
+		return nil
+	})
+
+	sp := Spec{Name: "long", Cron: "* * * * *"}
+	done := make(chan struct{})
+	go func() {
+		s.start(sp, nil)
+		close(done)
+	}()
+	<-runs
+	s.start(sp, nil)
+	close(release)
+	<-done
+	if len(runs) != 0 {
+		t.Error("Expected the second run to be skipped while the first was running")
+	}
+}
+
+func TestScheduler_QueueOverlap(t *testing.T) {
+	release := make(chan struct{})
+	runs := make(chan struct{}, 2)
+	s := testScheduler(context.Background(), func(ctx context.Context, sp Spec) error {
+		runs <- struct{}{}
+		<-release
+		return nil
+	})
+
+	sp := Spec{Name: "long", Cron: "* * * * *", Overlap: OverlapQueue}
+	done := make(chan struct{})
+	go func() {
+		s.start(sp, nil)
+		close(done)
+	}()
+	<-runs
+	go s.start(sp, nil)
+	close(release)
+	<-done
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		t.Error("Expected the second run to start once the first returned")
+	}
+}
+
+func TestScheduler_Stop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{}, 1)
+	finished := make(chan error, 1)
+	s := testScheduler(ctx, func(ctx context.Context, sp Spec) error {
+		select {
+		case started <- struct{}{}:
+		default:
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+		finished <- ctx.Err()
+		return nil
+	})
+
+	if err := s.Reconcile([]Spec{{Name: "nightly", Cron: "* * * * *"}}); err != nil {
+		t.Fatalf("Error reconciling: %v", err)
+	}
+	<-started
+	s.Stop()
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Errorf("Expected the run to finish uncancelled, got %v", err)
+		}
+	default:
+		t.Error("Expected Stop to wait for the run in progress")
+	}
+	if specs := s.Specs(); len(specs) != 0 {
+		t.Errorf("Expected no specs after Stop, got %+v", specs)
+	}
+}
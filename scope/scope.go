@@ -0,0 +1,58 @@
+// Package scope carries who a request is made by and for through a
+// context: the tenant whose data it may touch, the actor making it and the
+// source it came in through. The API servers set them as requests come in,
+// the store restricts its reads and writes to the tenant, the mailbox event
+// log records the actor and user_provenance the source, so call sites in
+// between pass only the context along.
+package scope
+
+import "context"
+
+type tenantKey struct{}
+
+type actorKey struct{}
+type sourceKey struct{}
+
+// WithTenant returns ctx scoped to tenant. An empty tenant leaves ctx
+// unscoped.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// Tenant returns the tenant ctx is scoped to, or "" if it is unscoped.
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// WithActor returns ctx carrying actor as who is making the request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	if actor == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor ctx carries, or "".
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithSource returns ctx carrying source as the ingestion path the request
+// came in through, such as api or import:users.csv.
+func WithSource(ctx context.Context, source string) context.Context {
+	if source == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// Source returns the source ctx carries, or "".
+func Source(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
@@ -0,0 +1,25 @@
+package scope
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	ctx := context.Background()
+	if Tenant(ctx) != "" || Actor(ctx) != "" {
+		t.Fatalf("Expected an unscoped context")
+	}
+
+	ctx = WithActor(WithTenant(ctx, "acme"), "ops")
+	if Tenant(ctx) != "acme" || Actor(ctx) != "ops" {
+		t.Errorf("Expected tenant acme and actor ops, got %q and %q", Tenant(ctx), Actor(ctx))
+	}
+
+	if Tenant(WithTenant(ctx, "")) != "acme" {
+		t.Errorf("Expected an empty tenant to keep the existing scope")
+	}
+	if Source(ctx) != "" || Source(WithSource(ctx, "api")) != "api" {
+		t.Errorf("Expected the source set with WithSource only")
+	}
+}
@@ -0,0 +1,199 @@
+// Package script runs small Starlark programs from config against each user
+// before it is processed, so simple business rules don't need a rebuild.
+//
+// A script may define either or both of:
+//
+//	def filter(user):     # return False, or a reason code, to skip the user
+//	def transform(user):  # return a dict of fields to overwrite
+//
+// A filter that returns a string skips the user with that reason code (see
+// package skip), such as "opt_out"; False skips it with skip.Filter. Any
+// other true value keeps the user.
+//
+// user is a dict with the keys id, mailbox_id, user_name, email_address and
+// created_at, the last formatted as db.TimestampLayout in UTC. Only user_name and email_address may be overwritten.
+//
+// Both may call annotate(key, value) to attach an annotation to the current
+// run (see package annotations), e.g. annotate("template_version", "3").
+//
+// The top level of the script, and the filter and transform run for each
+// user, may take at most the number of Starlark steps given to Compile, so
+// a script that loops forever fails the user instead of hanging its worker.
+package script
+
+import (
+	"context"
+	"fmt"
+
+	"mailboxes/annotations"
+	"mailboxes/db"
+	"mailboxes/skip"
+
+	"go.starlark.net/starlark"
+)
+
+// DefaultMaxSteps is the step limit used where none is configured.
+const DefaultMaxSteps = 1_000_000
+
+type Script struct {
+	name      string
+	maxSteps  uint64
+	filter    starlark.Callable
+	transform starlark.Callable
+}
+
+// Compile executes src once to define its functions. The resulting globals
+// are frozen, so the Script is safe for concurrent use. Executions are
+// stopped after maxSteps Starlark steps; 0 means no limit.
+func Compile(name, src string, maxSteps uint64) (*Script, error) {
+	s := &Script{name: name, maxSteps: maxSteps}
+	globals, err := starlark.ExecFile(s.thread(), name, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+
+	if s.filter, err = lookupFunc(globals, "filter"); err != nil {
+		return nil, err
+	}
+	if s.transform, err = lookupFunc(globals, "transform"); err != nil {
+		return nil, err
+	}
+	if s.filter == nil && s.transform == nil {
+		return nil, fmt.Errorf("script %s defines neither filter nor transform", name)
+	}
+
+	return s, nil
+}
+
+// thread returns a thread to run the script on, limited to s.maxSteps.
+func (s *Script) thread() *starlark.Thread {
+	thread := &starlark.Thread{Name: s.name}
+	thread.SetMaxExecutionSteps(s.maxSteps)
+	return thread
+}
+
+func lookupFunc(globals starlark.StringDict, name string) (starlark.Callable, error) {
+	v, ok := globals[name]
+	if !ok {
+		return nil, nil
+	}
+	fn, ok := v.(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s, not a function", name, v.Type())
+	}
+	return fn, nil
+}
+
+// predeclared are the builtins scripts may call besides Starlark's own.
+var predeclared = starlark.StringDict{
+	"annotate": starlark.NewBuiltin("annotate", annotate),
+}
+
+// annotationsLocal is the thread-local key of the annotations.Set annotate
+// adds to.
+const annotationsLocal = "annotations"
+
+func annotate(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, value string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	set, _ := thread.Local(annotationsLocal).(*annotations.Set)
+	set.Add(key, value)
+	return starlark.None, nil
+}
+
+// Apply runs the script's filter and transform against user. It returns the
+// possibly modified user and, if it should not be processed, the reason.
+func (s *Script) Apply(user db.User) (db.User, skip.Reason, error) {
+	return s.ApplyContext(context.Background(), user)
+}
+
+// ApplyContext is Apply with annotate adding to the annotations.Set ctx
+// carries, if any.
+func (s *Script) ApplyContext(ctx context.Context, user db.User) (db.User, skip.Reason, error) {
+	thread := s.thread()
+	thread.SetLocal(annotationsLocal, annotations.FromContext(ctx))
+
+	if s.filter != nil {
+		result, err := starlark.Call(thread, s.filter, starlark.Tuple{userDict(user)}, nil)
+		if err != nil {
+			return user, "", err
+		}
+		if reason, err := filterReason(result); reason != "" || err != nil {
+			return user, reason, err
+		}
+	}
+
+	if s.transform != nil {
+		result, err := starlark.Call(thread, s.transform, starlark.Tuple{userDict(user)}, nil)
+		if err != nil {
+			return user, "", err
+		}
+		if user, err = applyChanges(user, result); err != nil {
+			return user, "", err
+		}
+	}
+
+	return user, "", nil
+}
+
+// filterReason interprets what filter returned: the reason to skip the user,
+// or "" to keep it.
+func filterReason(result starlark.Value) (skip.Reason, error) {
+	if s, ok := result.(starlark.String); ok {
+		reason := skip.Reason(s)
+		if !skip.Valid(reason) {
+			return "", fmt.Errorf("filter returned invalid reason code %q", string(s))
+		}
+		return reason, nil
+	}
+	if !result.Truth() {
+		return skip.Filter, nil
+	}
+	return "", nil
+}
+
+func userDict(user db.User) *starlark.Dict {
+	d := starlark.NewDict(5)
+	d.SetKey(starlark.String("id"), starlark.MakeInt(user.ID))
+	d.SetKey(starlark.String("mailbox_id"), starlark.MakeInt(user.MailboxID))
+	d.SetKey(starlark.String("user_name"), starlark.String(user.UserName))
+	d.SetKey(starlark.String("email_address"), starlark.String(user.EmailAddress))
+	d.SetKey(starlark.String("created_at"), starlark.String(db.FormatTimestamp(user.CreatedAt)))
+	return d
+}
+
+func applyChanges(user db.User, result starlark.Value) (db.User, error) {
+	if result == starlark.None {
+		return user, nil
+	}
+
+	changes, ok := result.(*starlark.Dict)
+	if !ok {
+		return user, fmt.Errorf("transform returned %s, expected dict", result.Type())
+	}
+
+	for _, item := range changes.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return user, fmt.Errorf("transform returned non-string key %s", item[0])
+		}
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			return user, fmt.Errorf("transform returned non-string value for %s", key)
+		}
+
+		switch key {
+		case "user_name":
+			user.UserName = value
+		case "email_address":
+			user.EmailAddress = value
+		default:
+			return user, fmt.Errorf("transform cannot set %s", key)
+		}
+	}
+
+	return user, nil
+}
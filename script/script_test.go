@@ -0,0 +1,125 @@
+package script
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailboxes/annotations"
+	"mailboxes/db"
+	"mailboxes/skip"
+)
+
+func TestScript_Apply(t *testing.T) {
+	user := db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "User1@Example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name          string
+		src           string
+		expectedUser  db.User
+		expectedSkip  skip.Reason
+		expectedError bool
+	}{
+		{
+			name:         "Filter keeps user",
+			src:          "def filter(user):\n  return user['mailbox_id'] == 1\n",
+			expectedUser: user,
+		},
+		{
+			name:         "Filter skips user",
+			src:          "def filter(user):\n  return user['email_address'].endswith('@other.com')\n",
+			expectedUser: user,
+			expectedSkip: skip.Filter,
+		},
+		{
+			name: "Transform overwrites fields",
+			src:  "def transform(user):\n  return {'email_address': user['email_address'].lower()}\n",
+			expectedUser: db.User{
+				ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com", CreatedAt: time.Date(2024, 7, 23, 12, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:         "Filter skips user with reason",
+			src:          "def filter(user):\n  return 'opt_out' if user['user_name'] == 'user1' else True\n",
+			expectedUser: user,
+			expectedSkip: skip.OptOut,
+		},
+		{
+			name:          "Filter returns invalid reason",
+			src:           "def filter(user):\n  return 'Opted Out'\n",
+			expectedError: true,
+		},
+		{
+			name:          "Transform sets read-only field",
+			src:           "def transform(user):\n  return {'id': '7'}\n",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile("test.star", tt.src, DefaultMaxSteps)
+			if err != nil {
+				t.Fatalf("Error compiling script: %v", err)
+			}
+
+			got, reason, err := s.Apply(user)
+			if tt.expectedError {
+				if err == nil {
+					t.Fatalf("Expected an error applying script")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error applying script: %v", err)
+			}
+			if reason != tt.expectedSkip {
+				t.Errorf("Expected skip reason %q, got %q", tt.expectedSkip, reason)
+			}
+			if reason == "" && got != tt.expectedUser {
+				t.Errorf("Expected user %v, got %v", tt.expectedUser, got)
+			}
+		})
+	}
+}
+
+func TestScript_Annotate(t *testing.T) {
+	s, err := Compile("test.star", "def filter(user):\n  annotate('template_version', '3')\n  return True\n", DefaultMaxSteps)
+	if err != nil {
+		t.Fatalf("Error compiling: %v", err)
+	}
+
+	set := &annotations.Set{}
+	if _, _, err := s.ApplyContext(annotations.NewContext(context.Background(), set), db.User{ID: 101}); err != nil {
+		t.Fatalf("Error applying: %v", err)
+	}
+	if got := set.Map(); !reflect.DeepEqual(got, map[string]string{"template_version": "3"}) {
+		t.Errorf("Expected the script's annotation, got %v", got)
+	}
+
+	if _, _, err := s.Apply(db.User{ID: 101}); err != nil {
+		t.Errorf("Expected annotate outside a run to do nothing, got %v", err)
+	}
+}
+
+func TestCompile_RequiresFunction(t *testing.T) {
+	if _, err := Compile("empty.star", "x = 1\n", DefaultMaxSteps); err == nil {
+		t.Errorf("Expected an error compiling a script without filter or transform")
+	}
+}
+
+func TestScript_MaxSteps(t *testing.T) {
+	s, err := Compile("loop.star", "def filter(user):\n  for i in range(1 << 62):\n    pass\n  return True\n", 10000)
+	if err != nil {
+		t.Fatalf("Error compiling: %v", err)
+	}
+	if _, _, err := s.Apply(db.User{ID: 101}); err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("Expected the filter stopped after too many steps, got %v", err)
+	}
+
+	if _, err := Compile("loop.star", "for i in range(1 << 62):\n  pass\n", 10000); err == nil {
+		t.Errorf("Expected the top level stopped after too many steps")
+	}
+}
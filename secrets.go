@@ -0,0 +1,57 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+
+	"mailboxes/awssig"
+	"mailboxes/secrets"
+
+	"github.com/spf13/viper"
+)
+
+// secretProviders resolves the ${provider:path#field} references that
+// database.path and the token encryption key sources may hold in place of
+// credentials and keys; nil resolves none.
+var secretProviders secrets.Providers
+
+// openSecrets sets up the secrets providers configured under secrets: vault,
+// when secrets.vault.address is set, and awssm for AWS Secrets Manager, when
+// secrets.aws.region is. Credentials not configured are taken from
+// VAULT_TOKEN and the AWS_ environment variables.
+func openSecrets() (secrets.Providers, error) {
+	ps := secrets.Providers{}
+	if addr := viper.GetString("secrets.vault.address"); addr != "" {
+		v, err := secrets.NewVault(secrets.VaultConfig{
+			Address:   addr,
+			Token:     cmp.Or(viper.GetString("secrets.vault.token"), os.Getenv("VAULT_TOKEN")),
+			TokenFile: viper.GetString("secrets.vault.token_file"),
+			Namespace: viper.GetString("secrets.vault.namespace"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vault: %w", err)
+		}
+		ps["vault"] = v
+	}
+	if region := viper.GetString("secrets.aws.region"); region != "" {
+		creds := awssig.Credentials{
+			AccessKeyID:     viper.GetString("secrets.aws.access_key_id"),
+			SecretAccessKey: viper.GetString("secrets.aws.secret_access_key"),
+		}
+		if creds.AccessKeyID == "" && creds.SecretAccessKey == "" {
+			creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+			creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+		a, err := secrets.NewAWS(secrets.AWSConfig{Region: region, Endpoint: viper.GetString("secrets.aws.endpoint"), Credentials: creds})
+		if err != nil {
+			return nil, fmt.Errorf("awssm: %w", err)
+		}
+		ps["awssm"] = a
+	}
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	return ps, nil
+}
@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"mailboxes/awssig"
+)
+
+// AWSConfig describes access to AWS Secrets Manager.
+type AWSConfig struct {
+	Region string
+	// Endpoint replaces the regional one, for VPC endpoints and testing.
+	Endpoint    string
+	Credentials awssig.Credentials
+	// Client defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+// AWS reads secrets from AWS Secrets Manager. The path of a reference is
+// the secret's name or ARN. Without a field the whole secret string is
+// returned, or a binary secret in base64; with one, the secret string must
+// be a JSON object and the field is read from it.
+type AWS struct {
+	cfg AWSConfig
+}
+
+func NewAWS(cfg AWSConfig) (*AWS, error) {
+	if cfg.Region == "" {
+		return nil, errors.New("AWS region is not configured")
+	}
+	if cfg.Credentials.AccessKeyID == "" || cfg.Credentials.SecretAccessKey == "" {
+		return nil, errors.New("AWS Secrets Manager needs an access key ID and secret")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://secretsmanager." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &AWS{cfg: cfg}, nil
+}
+
+func (a *AWS) Secret(ctx context.Context, path, field string) (string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": path})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(a.cfg.Endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	awssig.Sign(req, body, time.Now(), a.cfg.Credentials, a.cfg.Region, "secretsmanager")
+
+	resp, err := a.cfg.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &apiErr)
+		if strings.HasSuffix(apiErr.Type, "ResourceNotFoundException") {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secrets manager returned %s: %s %s", resp.Status, apiErr.Type, cmp.Or(apiErr.Message, strings.TrimSpace(string(data))))
+	}
+
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decoding secrets manager response: %w", err)
+	}
+	if secret.SecretString == nil {
+		if field != "" {
+			return "", fmt.Errorf("field %s of a binary secret: %w", field, ErrNotFound)
+		}
+		return secret.SecretBinary, nil
+	}
+	if field == "" {
+		return *secret.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(*secret.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, so has no field %s", field)
+	}
+	return fieldString(fields, field)
+}
@@ -0,0 +1,67 @@
+// Package secrets resolves references to secrets kept in a secret store,
+// such as HashiCorp Vault or AWS Secrets Manager, so that credentials and
+// keys need not be written into the config file. A reference has the form
+// ${provider:path#field}: the field of the secret at path in the store the
+// provider name stands for. Some providers accept a reference without a
+// field for the whole secret.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned for a secret or field that does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider reads secrets from one secret store.
+type Provider interface {
+	// Secret returns field of the secret at path. An empty field asks for
+	// the whole secret.
+	Secret(ctx context.Context, path, field string) (string, error)
+}
+
+// Providers maps the names references use, such as vault, to providers. A
+// nil Providers resolves no references.
+type Providers map[string]Provider
+
+// Expand returns s with every reference in it replaced by the secret it
+// names. A string without references is returned as it is.
+func (p Providers) Expand(ctx context.Context, s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated secret reference %q", s[start:])
+		}
+		ref := s[start+2 : start+end]
+		value, err := p.resolve(ctx, ref)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+// resolve reads the secret ref, a reference without its ${ and }.
+func (p Providers) resolve(ctx context.Context, ref string) (string, error) {
+	name, rest, ok := strings.Cut(ref, ":")
+	if !ok || rest == "" {
+		return "", errors.New("want provider:path#field")
+	}
+	provider := p[name]
+	if provider == nil {
+		return "", fmt.Errorf("secrets provider %s is not configured", name)
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	return provider.Secret(ctx, path, field)
+}
@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultConfig describes a Vault server.
+type VaultConfig struct {
+	// Address is the server's URL, such as https://vault:8200.
+	Address string
+	// Token authenticates requests. TokenFile, read for every request,
+	// takes its place for tokens a Vault agent keeps renewed on disk.
+	Token     string
+	TokenFile string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+	// Client defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+// Vault reads secrets from HashiCorp Vault over its HTTP API. The path of a
+// reference is the API path after /v1/, such as secret/data/mailboxes for
+// the mailboxes secret of a KV version 2 engine mounted at secret, and the
+// field is required.
+type Vault struct {
+	cfg VaultConfig
+}
+
+func NewVault(cfg VaultConfig) (*Vault, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("vault address is not configured")
+	}
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		return nil, errors.New("vault needs a token or token file")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Vault{cfg: cfg}, nil
+}
+
+func (v *Vault) Secret(ctx context.Context, path, field string) (string, error) {
+	if field == "" {
+		return "", errors.New("vault secrets need a #field")
+	}
+	token := v.cfg.Token
+	if v.cfg.TokenFile != "" {
+		data, err := os.ReadFile(v.cfg.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	url := strings.TrimSuffix(v.cfg.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	resp, err := v.cfg.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("vault returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decoding vault response: %w", err)
+	}
+	data := secret.Data
+	// KV version 2 nests the secret's fields under data, next to its
+	// metadata.
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	return fieldString(data, field)
+}
+
+// fieldString returns field of a decoded JSON secret as a string.
+func fieldString(data map[string]any, field string) (string, error) {
+	switch v := data[field].(type) {
+	case nil:
+		return "", fmt.Errorf("field %s: %w", field, ErrNotFound)
+	case string:
+		return v, nil
+	default:
+		out, err := json.Marshal(v)
+		return string(out), err
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/gen"
+)
+
+// seedCommand writes INSERT statements for synthetic mailboxes and users to
+// stdout, ready to be piped into the database shell:
+//
+//	mailboxes seed --mailboxes 1000 --first-mailbox-id 10 | sqlite3 db/test.db
+func seedCommand(args []string) {
+	cfg := gen.DefaultConfig()
+
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.IntVar(&cfg.Mailboxes, "mailboxes", cfg.Mailboxes, "number of mailboxes to generate")
+	flags.IntVar(&cfg.UsersPerMailbox.Min, "min-users", cfg.UsersPerMailbox.Min, "minimum users per mailbox")
+	flags.IntVar(&cfg.UsersPerMailbox.Max, "max-users", cfg.UsersPerMailbox.Max, "maximum users per mailbox")
+	flags.IntVar(&cfg.FirstMailboxID, "first-mailbox-id", 1, "ID of the first generated mailbox")
+	flags.IntVar(&cfg.FirstUserID, "first-user-id", 1, "ID of the first generated user")
+	flags.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	domains := flags.String("domains", "example.com", "comma-separated email domains, optionally weighted as domain:weight")
+	start := flags.String("start", cfg.Start.Format(time.DateOnly), "earliest creation date")
+	end := flags.String("end", cfg.End.Format(time.DateOnly), "latest creation date")
+	flags.Parse(args)
+
+	var err error
+	if cfg.Domains, err = parseDomains(*domains); err != nil {
+		fatal("Invalid --domains", "error", err)
+	}
+	if cfg.Start, err = time.Parse(time.DateOnly, *start); err != nil {
+		fatal("Invalid --start", "error", err)
+	}
+	if cfg.End, err = time.Parse(time.DateOnly, *end); err != nil {
+		fatal("Invalid --end", "error", err)
+	}
+
+	g, err := gen.New(cfg)
+	if err != nil {
+		fatal("Error creating generator", "error", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "BEGIN;")
+	for i := 0; i < cfg.Mailboxes; i++ {
+		mb := g.Mailbox()
+		createdAt := sqlQuote(db.FormatTimestamp(mb.CreatedAt))
+		fmt.Fprintf(w, "INSERT INTO mailboxes (id, mpi_id, token, created_at, updated_at) VALUES (%d, %s, %s, %s, %s);\n",
+			mb.ID, sqlQuote(mb.MPIID), sqlQuote(mb.Token), createdAt, createdAt)
+		for _, user := range g.Users(mb) {
+			createdAt := sqlQuote(db.FormatTimestamp(user.CreatedAt))
+			fmt.Fprintf(w, "INSERT INTO users (id, mailbox_id, user_name, email_address, created_at, updated_at) VALUES (%d, %d, %s, %s, %s, %s);\n",
+				user.ID, user.MailboxID, sqlQuote(user.UserName), sqlQuote(user.EmailAddress), createdAt, createdAt)
+		}
+	}
+	fmt.Fprintln(w, "COMMIT;")
+}
+
+// parseDomains parses "a.com,b.com:3" into weighted domains; the weight
+// defaults to 1.
+func parseDomains(s string) ([]gen.Domain, error) {
+	var domains []gen.Domain
+	for _, part := range strings.Split(s, ",") {
+		name, weight, found := strings.Cut(strings.TrimSpace(part), ":")
+		d := gen.Domain{Name: name, Weight: 1}
+		if found {
+			w, err := strconv.Atoi(weight)
+			if err != nil {
+				return nil, fmt.Errorf("weight for %s: %w", name, err)
+			}
+			d.Weight = w
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mailboxes/api"
+	"mailboxes/cache"
+	"mailboxes/cursor"
+	"mailboxes/db"
+	"mailboxes/invalidate"
+	"mailboxes/publicid"
+
+	"github.com/spf13/viper"
+)
+
+// serveCommand serves the REST API until interrupted. IDs are obfuscated
+// when api.id_secret is set, api.graphql adds a GraphQL endpoint and
+// api.cache.ttl caches mailboxes' users, warming the busiest mailboxes
+// before the first request, unless cache.redis.url caches them in Redis.
+// Either cache drops the users other processes change; see followChanges.
+// With processor.configs set, admin keys can use the /processor routes to
+// switch the processor of every process sharing the store.
+func serveCommand(store db.Store, args []string) {
+	viper.SetDefault("api.addr", ":8080")
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", viper.GetString("api.addr"), "address to listen on")
+	fs.Parse(args)
+
+	ids := publicid.New(viper.GetString("api.id_secret"))
+	if ids == nil {
+		slog.Warn("api.id_secret is not set; API responses expose raw IDs")
+	}
+
+	limiter := newLimiter()
+	keys := newKeys(store)
+
+	viper.SetDefault("api.job_workers", 2)
+	jobs := api.NewJobs(viper.GetInt("api.job_workers"))
+	registerJobs(jobs, store, ids)
+
+	// Cursors are signed with api.cursor_secret, or else api.id_secret.
+	cursorSecret := viper.GetString("api.cursor_secret")
+	if cursorSecret == "" {
+		cursorSecret = viper.GetString("api.id_secret")
+	}
+	cursors := cursor.New(cursorSecret)
+
+	viper.SetDefault("stats.snapshot_file", "stats-snapshot.json")
+	server := api.NewServer(store, ids, jobs)
+	server.SetCursors(cursors)
+	server.SetStatsSnapshot(viper.GetString("stats.snapshot_file"))
+	if processors != nil {
+		server.SetProcessors(processors)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var userCache *cache.Store
+	if redisCache != nil {
+		if viper.IsSet("api.cache.ttl") {
+			slog.Warn("cache.redis.url is set; api.cache is not used")
+		}
+		server.SetCache(redisCache)
+	} else if userCache = setupCache(ctx, store); userCache != nil {
+		invalidations.Subscribe(userCache)
+		server.SetCache(userCache)
+	}
+	if redisCache != nil || userCache != nil {
+		followChanges(ctx, store)
+	}
+
+	var handler http.Handler = server
+	if viper.GetBool("api.graphql") {
+		gql, err := api.NewGraphQL(store, ids, cursors)
+		if err != nil {
+			fatal("Error creating GraphQL schema", "error", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		mux.Handle("/graphql", gql)
+		handler = mux
+	}
+	if is, ok := store.(db.IdempotencyStore); ok {
+		viper.SetDefault("api.idempotency_ttl", 24*time.Hour)
+		viper.SetDefault("api.idempotency_lease", time.Minute)
+		idempotency := api.NewIdempotency(is, viper.GetDuration("api.idempotency_ttl"), viper.GetDuration("api.idempotency_lease"), limiter)
+		handler = idempotency.Middleware(handler)
+	}
+	handler = limiter.Middleware(api.Scope(keys, handler))
+
+	srv := &http.Server{Addr: *addr, Handler: handler}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		jobs.Run(ctx)
+	}()
+
+	// Shutdown makes ListenAndServe return at once; wait for in-flight
+	// requests and running jobs to finish before exiting.
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		<-jobsDone
+		flushAccess(shutdownCtx, userCache, store)
+	}()
+
+	slog.Info("Serving API", "addr", *addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fatal("Error serving API", "error", err)
+	}
+	<-drained
+}
+
+// newLimiter returns the Limiter for api.rate_limit, which serve applies to
+// REST requests and grpc-serve to gRPC calls.
+func newLimiter() *api.Limiter {
+	var limits api.Limits
+	var clients map[string]api.Limits
+	if err := viper.UnmarshalKey("api.rate_limit", &limits); err != nil {
+		fatal("Error reading api.rate_limit", "error", err)
+	}
+	if err := viper.UnmarshalKey("api.rate_limit.clients", &clients); err != nil {
+		fatal("Error reading api.rate_limit.clients", "error", err)
+	}
+	return api.NewLimiter(limits, clients)
+}
+
+// newKeys returns the API keys under api.keys, which serve and grpc-serve
+// scope requests by. Each entry has a key, expanded like database.path, and
+// the tenant it is bound to. Binding tenants needs a store that records
+// them.
+func newKeys(store db.Store) *api.Keys {
+	var keys []api.Key
+	if err := viper.UnmarshalKey("api.keys", &keys); err != nil {
+		fatal("Error reading api.keys", "error", err)
+	}
+	for i := range keys {
+		key, err := secretProviders.Expand(context.Background(), keys[i].Key)
+		if err != nil {
+			fatal("Error expanding api.keys", "tenant", keys[i].Tenant, "error", err)
+		}
+		keys[i].Key = key
+	}
+	k := api.NewKeys(keys)
+	if _, ok := store.(db.QuotaStore); k.Tenanted() && !ok {
+		fatal("api.keys binds tenants, but the store does not support tenants")
+	}
+	return k
+}
+
+// setupCache returns a cache of mailboxes' users if api.cache.ttl is set,
+// after loading the api.cache.warmup most accessed mailboxes into it. While
+// ctx lasts, access counts are saved every api.cache.flush_interval so the
+// next start knows which mailboxes to warm.
+func setupCache(ctx context.Context, store db.Store) *cache.Store {
+	ttl := viper.GetDuration("api.cache.ttl")
+	if ttl <= 0 {
+		return nil
+	}
+	viper.SetDefault("api.cache.flush_interval", time.Minute)
+
+	c := cache.New(store, ttl, viper.GetInt("api.cache.max_mailboxes"))
+
+	as, ok := store.(db.AccessStore)
+	if !ok {
+		slog.Warn("Store does not record mailbox access; the API cache will not be warmed")
+		return c
+	}
+
+	if n := viper.GetInt("api.cache.warmup"); n > 0 {
+		started := time.Now()
+		loaded := 0
+		ids, err := as.HotMailboxes(ctx, n)
+		if err == nil {
+			loaded, err = c.Warm(ctx, ids)
+		}
+		if err != nil {
+			slog.Error("Error warming the API cache", "error", err)
+		}
+		slog.Info("Warmed the API cache", "mailboxes", loaded, "duration", time.Since(started))
+	}
+
+	go func() {
+		ticker := time.NewTicker(viper.GetDuration("api.cache.flush_interval"))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				flushAccess(ctx, c, store)
+			}
+		}
+	}()
+	return c
+}
+
+// flushAccess saves the mailbox accesses c has counted, if any.
+func flushAccess(ctx context.Context, c *cache.Store, store db.Store) {
+	as, ok := store.(db.AccessStore)
+	if c == nil || !ok {
+		return
+	}
+	if err := c.Flush(ctx, as); err != nil {
+		slog.Error("Error saving mailbox access counts", "error", err)
+	}
+}
+
+// invalidations passes on invalidations to the caches of this process:
+// redisCache, when set, and serve's cache of mailboxes' users.
+var invalidations invalidate.Bus
+
+// followChanges publishes to invalidations the mailboxes whose users other
+// processes change, such as import, grpc-serve and other serve replicas, by
+// following the user_changes outbox every cache.invalidation.interval,
+// until ctx is done. Changes recorded before it starts are skipped.
+func followChanges(ctx context.Context, store db.Store) {
+	viper.SetDefault("cache.invalidation.interval", 2*time.Second)
+	interval := viper.GetDuration("cache.invalidation.interval")
+	if interval <= 0 {
+		return
+	}
+	cs, ok := store.(db.ChangeFeedStore)
+	if !ok {
+		slog.Warn("Store does not record user changes; the cache will not see other processes' writes until entries expire")
+		return
+	}
+	us, _ := store.(db.UserStore)
+	follower := invalidate.NewFollower(cs, us, &invalidations, 0)
+	if err := follower.Skip(ctx); err != nil {
+		slog.Error("Error reading user changes; the cache will not see other processes' writes until entries expire", "error", err)
+		return
+	}
+	go follower.Run(ctx, interval)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mailboxes/db"
+)
+
+const settingsUsage = "Usage: settings list <mailbox-id> | settings set <mailbox-id> <name> <value> | settings unset <mailbox-id> <name>"
+
+// settingsCommand lists and changes a mailbox's settings in mailbox_settings,
+// such as the webhook.url its users are sent to.
+func settingsCommand(store db.Store, args []string) {
+	if len(args) < 2 {
+		fatal(settingsUsage)
+	}
+	ms, ok := store.(db.MailboxSettingsStore)
+	if !ok {
+		fatal("Store does not keep mailbox settings")
+	}
+	mailboxID, err := strconv.Atoi(args[1])
+	if err != nil {
+		fatal("Invalid mailbox ID", "mailbox_id", args[1], "error", err)
+	}
+
+	ctx := context.Background()
+	switch {
+	case args[0] == "list" && len(args) == 2:
+		settings, err := ms.MailboxSettings(ctx, mailboxID)
+		if err != nil {
+			fatal("Error reading settings of mailbox", "mailbox_id", mailboxID, "error", err)
+		}
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Printf("%s\t%s\n", name, settingValue(name, settings[name]))
+		}
+	case args[0] == "set" && len(args) == 4:
+		if err := ms.SetMailboxSetting(ctx, mailboxID, args[2], args[3]); err != nil {
+			fatal("Error setting mailbox setting", "setting", args[2], "mailbox_id", mailboxID, "error", err)
+		}
+		slog.Info("Set mailbox setting", "setting", args[2], "mailbox_id", mailboxID)
+	case args[0] == "unset" && len(args) == 3:
+		if err := ms.DeleteMailboxSetting(ctx, mailboxID, args[2]); err != nil {
+			fatal("Error unsetting mailbox setting", "setting", args[2], "mailbox_id", mailboxID, "error", err)
+		}
+		slog.Info("Unset mailbox setting", "setting", args[2], "mailbox_id", mailboxID)
+	default:
+		fatal(settingsUsage)
+	}
+}
+
+// settingValue returns value as settings list shows it: credentials are
+// hidden unless they are secret references, which are safe to show.
+func settingValue(name, value string) string {
+	if name != settingWebhookSecret && name != settingWebhookAuthorization {
+		return value
+	}
+	if strings.HasPrefix(value, "${") {
+		return value
+	}
+	return "(hidden)"
+}
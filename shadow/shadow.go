@@ -0,0 +1,124 @@
+// Package shadow runs a candidate processor alongside the current one and
+// records where their outputs differ, so a rewrite can be checked against
+// real traffic before it takes over. Only the current processor's output is
+// ever acted on.
+package shadow
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+
+	"mailboxes/db"
+	"mailboxes/skip"
+)
+
+// Output is what a processor decided for one user.
+type Output struct {
+	User       db.User     `json:"user"`
+	Keep       bool        `json:"keep"`
+	SkipReason skip.Reason `json:"skip_reason,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// NewOutput builds an Output from a processor's results. The user is kept
+// unless it was skipped or failed.
+func NewOutput(user db.User, reason skip.Reason, err error) Output {
+	out := Output{User: user, Keep: reason == "" && err == nil, SkipReason: reason}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	return out
+}
+
+// FieldDiff is one field on which the two outputs disagree.
+type FieldDiff struct {
+	Field     string `json:"field"`
+	Current   string `json:"current"`
+	Candidate string `json:"candidate"`
+}
+
+// Diff is recorded for every user whose outputs disagree.
+type Diff struct {
+	UserID    int         `json:"user_id"`
+	MailboxID int         `json:"mailbox_id"`
+	Fields    []FieldDiff `json:"fields"`
+	Current   Output      `json:"current"`
+	Candidate Output      `json:"candidate"`
+}
+
+// Compare lists the fields on which current and candidate disagree. User
+// fields are only compared when both outputs keep the user.
+func Compare(current, candidate Output) []FieldDiff {
+	var diffs []FieldDiff
+	add := func(field, cur, cand string) {
+		if cur != cand {
+			diffs = append(diffs, FieldDiff{Field: field, Current: cur, Candidate: cand})
+		}
+	}
+
+	add("error", current.Error, candidate.Error)
+	add("keep", strconv.FormatBool(current.Keep), strconv.FormatBool(candidate.Keep))
+	add("skip_reason", string(current.SkipReason), string(candidate.SkipReason))
+	if current.Keep && candidate.Keep {
+		add("mailbox_id", strconv.Itoa(current.User.MailboxID), strconv.Itoa(candidate.User.MailboxID))
+		add("user_name", current.User.UserName, candidate.User.UserName)
+		add("email_address", current.User.EmailAddress, candidate.User.EmailAddress)
+	}
+	return diffs
+}
+
+// Candidate is a processor under evaluation.
+type Candidate func(db.User) (db.User, skip.Reason, error)
+
+// Shadow runs a Candidate for every user and writes a Diff as a JSON line for
+// each user whose outputs disagree. A nil *Shadow does nothing, so callers
+// can hold one unconditionally.
+type Shadow struct {
+	candidate Candidate
+
+	mu       sync.Mutex
+	enc      *json.Encoder
+	compared int
+	differed int
+	err      error
+}
+
+func New(candidate Candidate, w io.Writer) *Shadow {
+	return &Shadow{candidate: candidate, enc: json.NewEncoder(w)}
+}
+
+// Run passes in, the user as the current processor received it, to the
+// candidate and records any disagreement with current.
+func (s *Shadow) Run(in db.User, current Output) {
+	if s == nil {
+		return
+	}
+
+	candidate := NewOutput(s.candidate(in))
+	diffs := Compare(current, candidate)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.compared++
+	if len(diffs) == 0 {
+		return
+	}
+	s.differed++
+	if s.err == nil {
+		s.err = s.enc.Encode(Diff{UserID: in.ID, MailboxID: in.MailboxID, Fields: diffs, Current: current, Candidate: candidate})
+	}
+}
+
+// Stats returns how many users were compared and how many differed, along
+// with the first error writing diffs, if any.
+func (s *Shadow) Stats() (compared, differed int, err error) {
+	if s == nil {
+		return 0, 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compared, s.differed, s.err
+}
@@ -0,0 +1,102 @@
+package shadow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mailboxes/db"
+	"mailboxes/skip"
+)
+
+func TestCompare(t *testing.T) {
+	user := db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com"}
+	renamed := user
+	renamed.UserName = "User One"
+
+	tests := []struct {
+		name          string
+		current       Output
+		candidate     Output
+		expectedDiffs []FieldDiff
+	}{
+		{
+			name:      "Same output",
+			current:   Output{User: user, Keep: true},
+			candidate: Output{User: user, Keep: true},
+		},
+		{
+			name:          "Different field",
+			current:       Output{User: user, Keep: true},
+			candidate:     Output{User: renamed, Keep: true},
+			expectedDiffs: []FieldDiff{{Field: "user_name", Current: "user1", Candidate: "User One"}},
+		},
+		{
+			name:          "Candidate drops user",
+			current:       Output{User: user, Keep: true},
+			candidate:     Output{User: renamed, Keep: false},
+			expectedDiffs: []FieldDiff{{Field: "keep", Current: "true", Candidate: "false"}},
+		},
+		{
+			name:          "Different skip reason",
+			current:       NewOutput(user, skip.Filter, nil),
+			candidate:     NewOutput(user, skip.OptOut, nil),
+			expectedDiffs: []FieldDiff{{Field: "skip_reason", Current: "filter", Candidate: "opt_out"}},
+		},
+		{
+			name:      "Both drop user",
+			current:   Output{User: user},
+			candidate: Output{User: renamed},
+		},
+		{
+			name:      "Candidate fails",
+			current:   Output{User: user, Keep: true},
+			candidate: NewOutput(user, "", errors.New("boom")),
+			expectedDiffs: []FieldDiff{
+				{Field: "error", Current: "", Candidate: "boom"},
+				{Field: "keep", Current: "true", Candidate: "false"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diffs := Compare(tt.current, tt.candidate); !reflect.DeepEqual(diffs, tt.expectedDiffs) {
+				t.Errorf("Expected diffs %v, got %v", tt.expectedDiffs, diffs)
+			}
+		})
+	}
+}
+
+func TestShadow_Run(t *testing.T) {
+	var buf bytes.Buffer
+	s := New(func(u db.User) (db.User, skip.Reason, error) {
+		u.EmailAddress = strings.ToUpper(u.EmailAddress)
+		return u, "", nil
+	}, &buf)
+
+	s.Run(db.User{ID: 101, MailboxID: 1, EmailAddress: "user1@example.com"}, Output{User: db.User{ID: 101, MailboxID: 1, EmailAddress: "user1@example.com"}, Keep: true})
+	s.Run(db.User{ID: 102, MailboxID: 1}, Output{User: db.User{ID: 102, MailboxID: 1}, Keep: true})
+
+	compared, differed, err := s.Stats()
+	if compared != 2 || differed != 1 || err != nil {
+		t.Fatalf("Expected 2 compared and 1 differed, got %d, %d, %v", compared, differed, err)
+	}
+
+	var diff Diff
+	if err := json.Unmarshal(buf.Bytes(), &diff); err != nil {
+		t.Fatalf("Error decoding diff: %v", err)
+	}
+	if diff.UserID != 101 || len(diff.Fields) != 1 || diff.Fields[0].Candidate != "USER1@EXAMPLE.COM" {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	var nilShadow *Shadow
+	nilShadow.Run(db.User{}, Output{})
+	if compared, _, _ := nilShadow.Stats(); compared != 0 {
+		t.Errorf("Expected a nil Shadow to compare nothing")
+	}
+}
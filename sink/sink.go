@@ -0,0 +1,127 @@
+// Package sink delivers payloads to the destinations configured under
+// sinks.<name>, encoded with the sink's codec.
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"mailboxes/codec"
+	"mailboxes/redact"
+)
+
+// Sink receives payloads. A payload that fails may be sent again, so sinks
+// must tolerate seeing one more than once.
+type Sink interface {
+	Send(ctx context.Context, payload any) error
+}
+
+// Config describes a sink. Without a URL payloads go to the Writer's
+// destination instead. Redact is applied to every payload as it is encoded.
+type Config struct {
+	URL     string
+	Codec   string
+	Timeout time.Duration
+	Redact  redact.Policy
+}
+
+// New returns an HTTP sink for cfg.URL, or a Writer on w if no URL is set.
+func New(cfg Config, w io.Writer) (Sink, error) {
+	c, err := codec.Lookup(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	c = redact.Codec(c, cfg.Redact)
+	if cfg.URL == "" {
+		return NewWriter(w, c), nil
+	}
+	return NewHTTP(cfg.URL, c, cfg.Timeout), nil
+}
+
+// Writer writes each payload to w, encoded with c and followed by a newline.
+type Writer struct {
+	w io.Writer
+	c codec.Codec
+}
+
+func NewWriter(w io.Writer, c codec.Codec) *Writer {
+	return &Writer{w: w, c: c}
+}
+
+func (s *Writer) Send(ctx context.Context, payload any) error {
+	data, err := s.c.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// Discard drops every payload. It stands in for a sink that could not be
+// reached.
+type Discard struct{}
+
+func (Discard) Send(ctx context.Context, payload any) error { return nil }
+
+// HTTP POSTs each payload to a URL, encoded with c. Any status other than
+// 2xx fails the send.
+type HTTP struct {
+	url    string
+	c      codec.Codec
+	client *http.Client
+}
+
+// NewHTTP returns an HTTP sink. A zero timeout defaults to ten seconds.
+func NewHTTP(url string, c codec.Codec, timeout time.Duration) *HTTP {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTP{url: url, c: c, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTP) Send(ctx context.Context, payload any) error {
+	data, err := s.c.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.c.ContentType())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink %s answered %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// Ping checks that the sink's URL answers. Any response short of a 5xx
+// counts, since a sink need not accept requests without a payload.
+func (s *HTTP) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("sink %s answered %s", s.url, resp.Status)
+	}
+	return nil
+}
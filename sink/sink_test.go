@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"mailboxes/db"
+)
+
+func TestHTTP_Send(t *testing.T) {
+	user := db.User{ID: 101, MailboxID: 1, UserName: "user1", EmailAddress: "user1@example.com"}
+
+	var received db.User
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected JSON content type, got %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Error decoding payload: %v", err)
+		}
+		if received.ID == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{URL: srv.URL}, nil)
+	if err != nil {
+		t.Fatalf("Error creating sink: %v", err)
+	}
+
+	if err := s.Send(context.Background(), user); err != nil {
+		t.Fatalf("Error sending: %v", err)
+	}
+	if !reflect.DeepEqual(received, user) {
+		t.Errorf("Expected %v, got %v", user, received)
+	}
+
+	if err := s.Send(context.Background(), db.User{}); err == nil {
+		t.Errorf("Expected an error for a 502 response")
+	}
+}
+
+func TestHTTP_Ping(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusMethodNotAllowed)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	s := NewHTTP(srv.URL, nil, 0)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Expected a 405 to count as reachable, got %v", err)
+	}
+	status.Store(http.StatusServiceUnavailable)
+	if err := s.Ping(context.Background()); err == nil {
+		t.Errorf("Expected an error for a 503 response")
+	}
+}
+
+func TestWriter_Send(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := New(Config{Codec: "json"}, &buf)
+	if err != nil {
+		t.Fatalf("Error creating sink: %v", err)
+	}
+
+	if err := s.Send(context.Background(), map[string]int{"id": 1}); err != nil {
+		t.Fatalf("Error sending: %v", err)
+	}
+	if got := buf.String(); got != "{\"id\":1}\n" {
+		t.Errorf("Expected one JSON line, got %q", got)
+	}
+
+	if _, err := New(Config{Codec: "xml"}, &buf); err == nil {
+		t.Errorf("Expected an error for an unknown codec")
+	}
+}
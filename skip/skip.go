@@ -0,0 +1,83 @@
+// Package skip defines the machine-readable reason codes recorded whenever a
+// user is not processed, so reports, logs and sinks can say exactly why
+// delivery counts differ from user counts.
+package skip
+
+import (
+	"log/slog"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Reason is a skip reason code: lowercase words joined by underscores.
+type Reason string
+
+// Reasons the pipeline and scripts use. Scripts may return other codes from
+// their filter function as long as they are Valid.
+const (
+	// Filter is recorded when a script's filter returns False.
+	Filter Reason = "filter"
+	// PipelineFilter is recorded when a user does not match pipeline.filter.
+	PipelineFilter Reason = "pipeline_filter"
+	// ScriptError is recorded when a script fails for the user.
+	ScriptError Reason = "script_error"
+	// TokenExpired is recorded for a whole mailbox whose token has expired
+	// and could not be refreshed.
+	TokenExpired Reason = "token_expired"
+	// AlreadyProcessed is recorded when the ledger shows an earlier pass
+	// processed the user.
+	AlreadyProcessed Reason = "already_processed"
+
+	OptOut     Reason = "opt_out"
+	Suppressed Reason = "suppressed"
+	Duplicate  Reason = "duplicate"
+	QuietHours Reason = "quiet_hours"
+)
+
+var validReason = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// Valid reports whether r is a well-formed reason code.
+func Valid(r Reason) bool {
+	return len(r) <= 64 && validReason.MatchString(string(r))
+}
+
+// Counts tallies skipped users by reason. It is safe for concurrent use.
+type Counts struct {
+	mu     sync.Mutex
+	counts map[Reason]int
+}
+
+// Add counts one user skipped for r.
+func (c *Counts) Add(r Reason) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[Reason]int)
+	}
+	c.counts[r]++
+}
+
+// Flush returns the counts so far and starts again from zero.
+func (c *Counts) Flush() map[Reason]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = nil
+	return counts
+}
+
+// Attrs turns counts into log attributes, one per reason in sorted order.
+func Attrs(counts map[Reason]int) []any {
+	reasons := make([]string, 0, len(counts))
+	for r := range counts {
+		reasons = append(reasons, string(r))
+	}
+	sort.Strings(reasons)
+
+	attrs := make([]any, 0, len(reasons))
+	for _, r := range reasons {
+		attrs = append(attrs, slog.Int(r, counts[Reason(r)]))
+	}
+	return attrs
+}
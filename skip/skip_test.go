@@ -0,0 +1,49 @@
+package skip
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		reason   Reason
+		expected bool
+	}{
+		{OptOut, true},
+		{"quiet_hours", true},
+		{"vip2", true},
+		{"", false},
+		{"Opt-Out", false},
+		{"opt__out", false},
+		{"_opt", false},
+		{"opt out", false},
+	}
+
+	for _, tt := range tests {
+		if got := Valid(tt.reason); got != tt.expected {
+			t.Errorf("Valid(%q) = %v, expected %v", tt.reason, got, tt.expected)
+		}
+	}
+}
+
+func TestCounts(t *testing.T) {
+	var c Counts
+	c.Add(Filter)
+	c.Add(OptOut)
+	c.Add(Filter)
+
+	counts := c.Flush()
+	if !reflect.DeepEqual(counts, map[Reason]int{Filter: 2, OptOut: 1}) {
+		t.Errorf("Unexpected counts %v", counts)
+	}
+	if again := c.Flush(); len(again) != 0 {
+		t.Errorf("Expected Flush to reset the counts, got %v", again)
+	}
+
+	expected := []any{slog.Int("filter", 2), slog.Int("opt_out", 1)}
+	if attrs := Attrs(counts); !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("Expected attrs %v, got %v", expected, attrs)
+	}
+}
@@ -0,0 +1,130 @@
+// Package slo tracks whether each mailbox met the run's processing
+// objective, such as having every user processed within two hours of the run
+// starting, and how fast violations are burning the error budget.
+package slo
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Violation reasons.
+const (
+	ReasonLate       = "late"
+	ReasonIncomplete = "incomplete"
+	ReasonSkipped    = "skipped"
+)
+
+// Violation is a mailbox that missed the objective.
+type Violation struct {
+	MailboxID int    `json:"mailbox_id"`
+	Reason    string `json:"reason"`
+	Detail    string `json:"detail,omitempty"`
+	// Elapsed is how long after the run started the mailbox finished, in
+	// seconds; zero for skipped mailboxes.
+	Elapsed float64 `json:"elapsed_seconds,omitempty"`
+	Users   int     `json:"users"`
+}
+
+// Report summarizes a run against the objective. BurnRate is the share of
+// mailboxes that violated it divided by the error budget, 1 - Objective: at
+// 1 the budget is used up exactly, above 1 it is being burned too fast.
+type Report struct {
+	RunStart   time.Time   `json:"run_start"`
+	Target     string      `json:"target"`
+	Objective  float64     `json:"objective"`
+	Mailboxes  int         `json:"mailboxes"`
+	Met        int         `json:"met"`
+	Compliance float64     `json:"compliance"`
+	BurnRate   float64     `json:"burn_rate"`
+	Violations []Violation `json:"violations"`
+	// Annotations are the run's annotations, set by the caller.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Tracker records the outcome of every mailbox in a run. A nil *Tracker
+// records nothing, so callers can hold one unconditionally.
+type Tracker struct {
+	start     time.Time
+	target    time.Duration
+	objective float64
+
+	mu         sync.Mutex
+	mailboxes  int
+	violations []Violation
+}
+
+// New returns a Tracker for a run started at start, whose mailboxes must be
+// fully processed within target. objective is the share of mailboxes
+// expected to meet that, e.g. 0.99.
+func New(start time.Time, target time.Duration, objective float64) *Tracker {
+	return &Tracker{start: start, target: target, objective: objective}
+}
+
+// Done records that a mailbox's pass ended at end after users users. A pass
+// that did not get through every user is a violation however fast it was.
+func (t *Tracker) Done(mailboxID, users int, end time.Time, complete bool) {
+	if t == nil {
+		return
+	}
+
+	elapsed := end.Sub(t.start)
+	v := Violation{MailboxID: mailboxID, Elapsed: elapsed.Seconds(), Users: users}
+	switch {
+	case !complete:
+		v.Reason = ReasonIncomplete
+	case elapsed > t.target:
+		v.Reason = ReasonLate
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mailboxes++
+	if v.Reason != "" {
+		t.violations = append(t.violations, v)
+	}
+}
+
+// Skipped records that a mailbox was not processed at all, for the given
+// reason.
+func (t *Tracker) Skipped(mailboxID int, detail string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mailboxes++
+	t.violations = append(t.violations, Violation{MailboxID: mailboxID, Reason: ReasonSkipped, Detail: detail})
+}
+
+// Report summarizes the mailboxes recorded so far, listing violations by
+// mailbox ID.
+func (t *Tracker) Report() Report {
+	if t == nil {
+		return Report{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := Report{
+		RunStart:   t.start.UTC(),
+		Target:     t.target.String(),
+		Objective:  t.objective,
+		Mailboxes:  t.mailboxes,
+		Met:        t.mailboxes - len(t.violations),
+		Compliance: 1,
+		Violations: append([]Violation{}, t.violations...),
+	}
+	sort.Slice(r.Violations, func(i, j int) bool { return r.Violations[i].MailboxID < r.Violations[j].MailboxID })
+
+	if r.Mailboxes > 0 {
+		r.Compliance = float64(r.Met) / float64(r.Mailboxes)
+		if budget := 1 - t.objective; budget > 0 {
+			r.BurnRate = (1 - r.Compliance) / budget
+		}
+	}
+	return r
+}
@@ -0,0 +1,46 @@
+package slo
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTracker_Report(t *testing.T) {
+	start := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+	tr := New(start, 2*time.Hour, 0.9)
+
+	for id := 1; id <= 7; id++ {
+		tr.Done(id, 10, start.Add(time.Hour), true)
+	}
+	tr.Done(10, 50, start.Add(3*time.Hour), true)
+	tr.Done(9, 20, start.Add(30*time.Minute), false)
+	tr.Skipped(8, "token expired")
+
+	r := tr.Report()
+	if r.Mailboxes != 10 || r.Met != 7 {
+		t.Fatalf("Expected 7 of 10 mailboxes to meet the objective, got %d of %d", r.Met, r.Mailboxes)
+	}
+	if math.Abs(r.Compliance-0.7) > 1e-9 || math.Abs(r.BurnRate-3) > 1e-9 {
+		t.Errorf("Expected compliance 0.7 and burn rate 3, got %v and %v", r.Compliance, r.BurnRate)
+	}
+
+	expected := []Violation{
+		{MailboxID: 8, Reason: ReasonSkipped, Detail: "token expired"},
+		{MailboxID: 9, Reason: ReasonIncomplete, Elapsed: 1800, Users: 20},
+		{MailboxID: 10, Reason: ReasonLate, Elapsed: 10800, Users: 50},
+	}
+	if !reflect.DeepEqual(r.Violations, expected) {
+		t.Errorf("Expected violations %+v, got %+v", expected, r.Violations)
+	}
+}
+
+func TestTracker_Nil(t *testing.T) {
+	var tr *Tracker
+	tr.Done(1, 1, time.Now(), true)
+	tr.Skipped(2, "token expired")
+	if r := tr.Report(); r.Mailboxes != 0 {
+		t.Errorf("Expected a nil Tracker to record nothing, got %+v", r)
+	}
+}
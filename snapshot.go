@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"mailboxes/db"
+	"mailboxes/snapshot"
+	"mailboxes/tokencrypt"
+)
+
+// snapshotCommand writes every mailbox's users to a zstd snapshot that
+// replay --snapshot can read one mailbox at a time, or with --store every
+// mailbox and its users to a read-only flat store file that runs can use in
+// place of the database, with database.driver flat.
+func snapshotCommand(store db.Store, keyring *tokencrypt.Keyring, args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := fs.String("out", "", "file to write (default users"+snapshot.Ext+", or mailboxes"+db.FlatExt+" with --store)")
+	flat := fs.Bool("store", false, "write a flat store file for database.driver flat instead of a snapshot")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	mailboxes, err := loadMailboxes(ctx, store)
+	if err != nil {
+		fatal("Error retrieving mailboxes", "error", err)
+	}
+	ids := make([]int, 0, len(mailboxes))
+	for id := range mailboxes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	if *out == "" {
+		*out = "users" + snapshot.Ext
+		if *flat {
+			*out = "mailboxes" + db.FlatExt
+		}
+	}
+
+	// Write next to the destination and rename, so a failed snapshot never
+	// replaces a good one. Flat store files hold tokens.
+	perm := os.FileMode(0o644)
+	if *flat {
+		perm = 0o600
+	}
+	tmp := *out + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		fatal("Error creating file", "path", tmp, "error", err)
+	}
+	defer os.Remove(tmp)
+
+	var users int
+	if *flat {
+		users, err = writeFlatStore(ctx, f, store, keyring, mailboxes, ids)
+	} else {
+		users, err = writeSnapshot(ctx, f, store, ids)
+	}
+	if err != nil {
+		fatal("Error writing file", "path", tmp, "error", err)
+	}
+	if err := f.Close(); err != nil {
+		fatal("Error writing file", "path", tmp, "error", err)
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		fatal("Error writing file", "path", *out, "error", err)
+	}
+	slog.Info("Snapshot written", "users", users, "mailboxes", len(ids), "path", *out)
+}
+
+// mailboxUsers reads the users of mailboxID in ID order.
+func mailboxUsers(ctx context.Context, store db.Store, mailboxID int) ([]db.User, error) {
+	userChan, err := store.UsersForMailbox(ctx, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+	var users []db.User
+	for user := range userChan {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// writeSnapshot writes the users of the mailboxes ids to a zstd snapshot on
+// w, and returns how many it wrote.
+func writeSnapshot(ctx context.Context, w io.Writer, store db.Store, ids []int) (int, error) {
+	buf := bufio.NewWriter(w)
+	sw, err := snapshot.NewWriter(buf)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for _, id := range ids {
+		users, err := mailboxUsers(ctx, store, id)
+		if err != nil {
+			return written, fmt.Errorf("retrieving users for mailbox %d: %w", id, err)
+		}
+		if err := sw.WriteMailbox(id, users); err != nil {
+			return written, fmt.Errorf("mailbox %d: %w", id, err)
+		}
+		written += len(users)
+	}
+	if err := sw.Close(); err != nil {
+		return written, fmt.Errorf("writing snapshot index: %w", err)
+	}
+	return written, buf.Flush()
+}
+
+// writeFlatStore writes the mailboxes ids and their users to a flat store
+// file on w, and returns how many users it wrote. Tokens are sealed with
+// keyring if one is configured, as they would be in the database.
+func writeFlatStore(ctx context.Context, w io.Writer, store db.Store, keyring *tokencrypt.Keyring, mailboxes map[int]db.Mailbox, ids []int) (int, error) {
+	fw, err := db.NewFlatWriter(w)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for _, id := range ids {
+		users, err := mailboxUsers(ctx, store, id)
+		if err != nil {
+			return written, fmt.Errorf("retrieving users for mailbox %d: %w", id, err)
+		}
+		mb := mailboxes[id]
+		if mb.Token, err = keyring.Seal(mb.Token); err != nil {
+			return written, fmt.Errorf("sealing the token of mailbox %d: %w", id, err)
+		}
+		if err := fw.WriteMailbox(mb, users); err != nil {
+			return written, fmt.Errorf("mailbox %d: %w", id, err)
+		}
+		written += len(users)
+	}
+	return written, fw.Close()
+}
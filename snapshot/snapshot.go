@@ -0,0 +1,239 @@
+// Package snapshot reads and writes point-in-time copies of every user,
+// grouped by mailbox, for replaying without the database.
+//
+// A snapshot is a sequence of zstd frames, one per mailbox, each holding that
+// mailbox's users as JSON lines. An index of where each mailbox's frame
+// starts follows in a zstd skippable frame, and a fixed-size skippable frame
+// at the very end records where the index starts. Reading one mailbox costs
+// two small reads at the end of the file and one frame, however large the
+// snapshot; and since skippable frames are ignored by zstd itself,
+// `zstd -dc` on a snapshot prints every user.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"mailboxes/db"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Ext is the file extension snapshots are written with.
+const Ext = ".snap.zst"
+
+// ErrNoMailbox is returned for a mailbox the snapshot has no users for.
+var ErrNoMailbox = errors.New("mailbox not in snapshot")
+
+const (
+	// skippableMagic starts a zstd skippable frame; the low nibble is free
+	// for applications to choose.
+	skippableMagic = 0x184D2A5E
+
+	// trailerMagic identifies the last frame of a snapshot.
+	trailerMagic = "MBXSNAP1"
+
+	// trailerSize is the length of the final frame: the skippable frame
+	// header, the index offset and trailerMagic.
+	trailerSize = 8 + 8 + len(trailerMagic)
+)
+
+// Entry locates one mailbox's frame.
+type Entry struct {
+	MailboxID int   `json:"mailbox_id"`
+	Offset    int64 `json:"offset"`
+	Length    int64 `json:"length"`
+	Users     int   `json:"users"`
+}
+
+// Writer writes a snapshot. Each mailbox is written at most once, and Close
+// must be called to write the index.
+type Writer struct {
+	w       io.Writer
+	enc     *zstd.Encoder
+	offset  int64
+	index   []Entry
+	written map[int]bool
+	buf     bytes.Buffer
+}
+
+// NewWriter returns a Writer that writes a snapshot to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, enc: enc, written: make(map[int]bool)}, nil
+}
+
+// WriteMailbox writes the users of one mailbox as a single frame. Mailboxes
+// without users are not written.
+func (sw *Writer) WriteMailbox(mailboxID int, users []db.User) error {
+	if sw.written[mailboxID] {
+		return fmt.Errorf("mailbox %d already written", mailboxID)
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	sw.buf.Reset()
+	enc := json.NewEncoder(&sw.buf)
+	for _, user := range users {
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+	}
+
+	frame := sw.enc.EncodeAll(sw.buf.Bytes(), nil)
+	if _, err := sw.w.Write(frame); err != nil {
+		return err
+	}
+	sw.index = append(sw.index, Entry{MailboxID: mailboxID, Offset: sw.offset, Length: int64(len(frame)), Users: len(users)})
+	sw.offset += int64(len(frame))
+	sw.written[mailboxID] = true
+	return nil
+}
+
+// Close writes the index and trailer. It does not close the underlying
+// writer.
+func (sw *Writer) Close() error {
+	defer sw.enc.Close()
+
+	sort.Slice(sw.index, func(i, j int) bool { return sw.index[i].MailboxID < sw.index[j].MailboxID })
+	data, err := json.Marshal(sw.index)
+	if err != nil {
+		return err
+	}
+	indexOffset := sw.offset
+	if err := sw.skippable(data); err != nil {
+		return err
+	}
+
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(indexOffset))
+	return sw.skippable(append(trailer, trailerMagic...))
+}
+
+// skippable writes data as a zstd skippable frame.
+func (sw *Writer) skippable(data []byte) error {
+	header := binary.LittleEndian.AppendUint32(nil, skippableMagic)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(data)))
+	if _, err := sw.w.Write(append(header, data...)); err != nil {
+		return err
+	}
+	sw.offset += int64(len(header) + len(data))
+	return nil
+}
+
+// Reader reads mailboxes from a snapshot without scanning it.
+type Reader struct {
+	r     io.ReaderAt
+	index []Entry
+	dec   *zstd.Decoder
+	close func() error
+}
+
+// Open opens the snapshot at path.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sr, err := NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	sr.close = f.Close
+	return sr, nil
+}
+
+// NewReader reads the index of the size-byte snapshot in r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < int64(trailerSize) {
+		return nil, errors.New("not a snapshot: too short")
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(trailerSize)); err != nil {
+		return nil, err
+	}
+	if string(trailer[16:]) != trailerMagic {
+		return nil, errors.New("not a snapshot: missing trailer")
+	}
+
+	indexOffset := int64(binary.BigEndian.Uint64(trailer[8:16]))
+	indexEnd := size - int64(trailerSize)
+	if indexOffset < 0 || indexOffset+8 > indexEnd {
+		return nil, errors.New("corrupt snapshot: index offset out of range")
+	}
+	frame := make([]byte, indexEnd-indexOffset)
+	if _, err := r.ReadAt(frame, indexOffset); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(frame) != skippableMagic || int(binary.LittleEndian.Uint32(frame[4:])) != len(frame)-8 {
+		return nil, errors.New("corrupt snapshot: bad index frame")
+	}
+
+	var index []Entry
+	if err := json.Unmarshal(frame[8:], &index); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: reading index: %w", err)
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, index: index, dec: dec}, nil
+}
+
+// Mailboxes returns the index, ordered by mailbox ID.
+func (sr *Reader) Mailboxes() []Entry {
+	return sr.index
+}
+
+// Users reads the users of one mailbox, in the order they were written.
+func (sr *Reader) Users(mailboxID int) ([]db.User, error) {
+	i := sort.Search(len(sr.index), func(i int) bool { return sr.index[i].MailboxID >= mailboxID })
+	if i == len(sr.index) || sr.index[i].MailboxID != mailboxID {
+		return nil, ErrNoMailbox
+	}
+	e := sr.index[i]
+
+	frame := make([]byte, e.Length)
+	if _, err := sr.r.ReadAt(frame, e.Offset); err != nil {
+		return nil, err
+	}
+	data, err := sr.dec.DecodeAll(frame, nil)
+	if err != nil {
+		return nil, fmt.Errorf("mailbox %d: %w", mailboxID, err)
+	}
+
+	users := make([]db.User, 0, e.Users)
+	lines := json.NewDecoder(bytes.NewReader(data))
+	for lines.More() {
+		var user db.User
+		if err := lines.Decode(&user); err != nil {
+			return nil, fmt.Errorf("mailbox %d: %w", mailboxID, err)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Close releases the decoder and, for a snapshot from Open, the file.
+func (sr *Reader) Close() error {
+	sr.dec.Close()
+	if sr.close != nil {
+		return sr.close()
+	}
+	return nil
+}
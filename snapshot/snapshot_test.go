@@ -0,0 +1,125 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"mailboxes/db"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func writeSnapshot(t *testing.T, mailboxes map[int][]db.User) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{3, 1, 2} {
+		if err := w.WriteMailbox(id, mailboxes[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadMailbox(t *testing.T) {
+	mailboxes := map[int][]db.User{
+		1: {{ID: 1, MailboxID: 1, UserName: "a", EmailAddress: "a@example.com"}, {ID: 4, MailboxID: 1, UserName: "d"}},
+		2: nil,
+		3: {{ID: 2, MailboxID: 3, UserName: "b", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+	data := writeSnapshot(t, mailboxes)
+
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var ids []int
+	for _, e := range r.Mailboxes() {
+		ids = append(ids, e.MailboxID)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("indexed mailboxes = %v, want [1 3]", ids)
+	}
+
+	for _, id := range []int{1, 3} {
+		users, err := r.Users(id)
+		if err != nil {
+			t.Fatalf("mailbox %d: %v", id, err)
+		}
+		if !reflect.DeepEqual(users, mailboxes[id]) {
+			t.Errorf("mailbox %d = %+v, want %+v", id, users, mailboxes[id])
+		}
+	}
+
+	if _, err := r.Users(2); !errors.Is(err, ErrNoMailbox) {
+		t.Errorf("empty mailbox: err = %v, want ErrNoMailbox", err)
+	}
+	if _, err := r.Users(9); !errors.Is(err, ErrNoMailbox) {
+		t.Errorf("missing mailbox: err = %v, want ErrNoMailbox", err)
+	}
+}
+
+// A snapshot is a valid zstd stream: decoding all of it yields every user.
+func TestPlainZstd(t *testing.T) {
+	data := writeSnapshot(t, map[int][]db.User{
+		1: {{ID: 1, MailboxID: 1}},
+		3: {{ID: 2, MailboxID: 3}},
+	})
+
+	dec, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	out, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames are in the order written, mailbox 3 before mailbox 1.
+	want := `{"id":2,"mailbox_id":3,"user_name":"","email_address":"","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}` + "\n" +
+		`{"id":1,"mailbox_id":1,"user_name":"","email_address":"","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}` + "\n"
+	if string(out) != want {
+		t.Errorf("decoded = %q, want %q", out, want)
+	}
+}
+
+func TestNotASnapshot(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":      nil,
+		"no trailer": bytes.Repeat([]byte{'x'}, 64),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestWriteMailboxTwice(t *testing.T) {
+	w, err := NewWriter(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	users := []db.User{{ID: 1, MailboxID: 1}}
+	if err := w.WriteMailbox(1, users); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMailbox(1, users); err == nil {
+		t.Error("expected an error writing mailbox 1 twice")
+	}
+}
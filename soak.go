@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/gen"
+	"mailboxes/processor"
+)
+
+// soakCommand runs the pipeline repeatedly against a synthetic in-memory
+// store and a no-op sink for --duration, throttled to --rate users, and
+// reports heap and goroutine growth every --report-interval.
+func soakCommand(args []string) {
+	cfg := gen.DefaultConfig()
+	cfg.Mailboxes = 100
+
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	duration := flags.Duration("duration", time.Hour, "how long to run")
+	rate := flags.String("rate", "500/s", "users processed per second (N/s or N/m)")
+	reportInterval := flags.Duration("report-interval", time.Minute, "how often to report resource usage")
+	flags.IntVar(&cfg.Mailboxes, "mailboxes", cfg.Mailboxes, "mailboxes per pipeline pass")
+	flags.IntVar(&cfg.UsersPerMailbox.Max, "max-users", cfg.UsersPerMailbox.Max, "maximum users per mailbox")
+	verbose := flags.Bool("verbose", false, "keep pipeline logging enabled")
+	flags.Parse(args)
+
+	interval, err := parseRate(*rate)
+	if err != nil {
+		fatal("Invalid --rate", "error", err)
+	}
+
+	store, err := gen.NewStore(cfg)
+	if err != nil {
+		fatal("Error creating synthetic store", "error", err)
+	}
+
+	report := slog.New(slog.NewTextHandler(os.Stderr, nil)).With("component", "soak")
+	if !*verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+
+	limiter := time.NewTicker(interval)
+	defer limiter.Stop()
+
+	var processed atomic.Int64
+	process = processor.Func(func(context.Context, db.User) error {
+		<-limiter.C
+		processed.Add(1)
+		return nil
+	})
+
+	baseline := sampleUsage()
+	report.Info("Starting soak", "duration", *duration, "rate", *rate, "usage", baseline)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(*reportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := sampleUsage()
+				report.Info("Soak progress", "users", processed.Load(), "usage", current, "growth", current.growth(baseline))
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(*duration)
+	passes := 0
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	for ctx.Err() == nil {
+		if err := Pipeline(ctx, store); err != nil {
+			report.Error("Soak pass had failures", "pass", passes+1, "error", err)
+		}
+		passes++
+	}
+	close(done)
+
+	final := sampleUsage()
+	report.Info("Finished soak", "passes", passes, "users", processed.Load(), "usage", final, "growth", final.growth(baseline))
+}
+
+type usage struct {
+	heapAlloc   uint64
+	heapObjects uint64
+	goroutines  int
+}
+
+// sampleUsage collects garbage first so the heap figures reflect live memory.
+func sampleUsage() usage {
+	runtime.GC()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return usage{heapAlloc: m.HeapAlloc, heapObjects: m.HeapObjects, goroutines: runtime.NumGoroutine()}
+}
+
+func (u usage) String() string {
+	return fmt.Sprintf("heap %d KiB in %d objects, %d goroutines", u.heapAlloc/1024, u.heapObjects, u.goroutines)
+}
+
+func (u usage) growth(baseline usage) string {
+	return fmt.Sprintf("%+d KiB heap, %+d objects, %+d goroutines",
+		(int64(u.heapAlloc)-int64(baseline.heapAlloc))/1024,
+		int64(u.heapObjects)-int64(baseline.heapObjects),
+		u.goroutines-baseline.goroutines)
+}
+
+// parseRate converts "500/s", "30/m" or a bare "500" (per second) into the
+// interval between two events.
+func parseRate(s string) (time.Duration, error) {
+	count, unit, found := strings.Cut(s, "/")
+	per := time.Second
+	if found {
+		switch unit {
+		case "s":
+		case "m":
+			per = time.Minute
+		default:
+			return 0, fmt.Errorf("unknown rate unit %q", unit)
+		}
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("rate must be positive")
+	}
+	return per / time.Duration(n), nil
+}
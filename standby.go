@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/objstore"
+
+	"github.com/spf13/viper"
+)
+
+// standbyKey is the object the standby state is kept in, under the prefix
+// of standby.url.
+const standbyKey = "state.json"
+
+// standby, when standby.url is set, keeps a copy of the run checkpoint and
+// the watermarks in object storage, so that an instance replacing one whose
+// disk was lost can pick up where it stopped; nil otherwise.
+var standby *standbyCopy
+
+// standbyState is what is kept in object storage.
+type standbyState struct {
+	SavedAt time.Time `json:"saved_at"`
+	Holder  string    `json:"holder"`
+	// MailboxesDone and UsersDone are the run checkpoint; users are
+	// [mailbox ID, user ID] pairs.
+	MailboxesDone []int                       `json:"mailboxes_done"`
+	UsersDone     [][2]int                    `json:"users_done"`
+	Watermarks    map[string]standbyWatermark `json:"watermarks"`
+}
+
+type standbyWatermark struct {
+	CreatedAt string `json:"created_at"`
+	UserID    int    `json:"user_id"`
+}
+
+// standbyCopy pushes the state of a store to a bucket and restores it.
+type standbyCopy struct {
+	bucket   objstore.Bucket
+	cs       db.CheckpointStore
+	ws       db.WatermarkStore
+	interval time.Duration
+
+	mu sync.Mutex
+	// last is the state last pushed, without its time, to skip pushing it
+	// again unchanged.
+	last []byte
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openStandby opens the bucket named by standby.url for store, or returns
+// nil if it is not set. Credentials come from standby.access_key_id and
+// standby.secret_access_key, or else the AWS_ environment variables.
+func openStandby(store db.Store) (*standbyCopy, error) {
+	rawURL := viper.GetString("standby.url")
+	if rawURL == "" {
+		return nil, nil
+	}
+	viper.SetDefault("standby.interval", time.Minute)
+
+	opts := objstore.Options{
+		Endpoint:        viper.GetString("standby.endpoint"),
+		Region:          viper.GetString("standby.region"),
+		AccessKeyID:     viper.GetString("standby.access_key_id"),
+		SecretAccessKey: viper.GetString("standby.secret_access_key"),
+	}
+	if opts.AccessKeyID == "" && opts.SecretAccessKey == "" {
+		opts.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		opts.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		opts.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if opts.Region == "" {
+		opts.Region = os.Getenv("AWS_REGION")
+	}
+	bucket, err := objstore.Open(rawURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &standbyCopy{bucket: bucket, interval: viper.GetDuration("standby.interval")}
+	c.cs, _ = store.(db.CheckpointStore)
+	c.ws, _ = store.(db.WatermarkStore)
+	if c.cs == nil && c.ws == nil {
+		return nil, errors.New("store keeps neither checkpoints nor watermarks")
+	}
+	if c.interval <= 0 {
+		return nil, fmt.Errorf("standby.interval must be positive, not %s", c.interval)
+	}
+	return c, nil
+}
+
+// state reads the store's checkpoint and watermarks.
+func (c *standbyCopy) state(ctx context.Context) (standbyState, error) {
+	s := standbyState{Holder: lockHolder(), MailboxesDone: []int{}, UsersDone: [][2]int{}, Watermarks: map[string]standbyWatermark{}}
+	if c.cs != nil {
+		done, err := c.cs.LoadCheckpoint(ctx)
+		if err != nil {
+			return s, fmt.Errorf("loading checkpoint: %w", err)
+		}
+		for id := range done.Mailboxes {
+			s.MailboxesDone = append(s.MailboxesDone, id)
+		}
+		sort.Ints(s.MailboxesDone)
+		for p := range done.Users {
+			s.UsersDone = append(s.UsersDone, [2]int{p.MailboxID, p.UserID})
+		}
+		sort.Slice(s.UsersDone, func(i, j int) bool {
+			a, b := s.UsersDone[i], s.UsersDone[j]
+			return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+		})
+	}
+	if c.ws != nil {
+		watermarks, err := c.ws.Watermarks(ctx)
+		if err != nil {
+			return s, fmt.Errorf("loading watermarks: %w", err)
+		}
+		for name, wm := range watermarks {
+			s.Watermarks[name] = standbyWatermark{CreatedAt: wm.CreatedAt, UserID: wm.UserID}
+		}
+	}
+	return s, nil
+}
+
+// push writes the store's state to the bucket, unless it is unchanged since
+// the last push. A nil *standbyCopy does nothing.
+func (c *standbyCopy) push(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	s, err := c.state(ctx)
+	if err != nil {
+		return err
+	}
+	unchanged, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytes.Equal(unchanged, c.last) {
+		return nil
+	}
+	s.SavedAt = time.Now().UTC()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := c.bucket.Put(ctx, standbyKey, data); err != nil {
+		return fmt.Errorf("pushing standby state: %w", err)
+	}
+	c.last = unchanged
+	slog.Debug("Pushed standby state", "mailboxes_done", len(s.MailboxesDone), "users_done", len(s.UsersDone), "watermarks", len(s.Watermarks))
+	return nil
+}
+
+// startPushing pushes the state every standby.interval until stopPushing is
+// called. A nil *standbyCopy does nothing.
+func (c *standbyCopy) startPushing() {
+	if c == nil {
+		return
+	}
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+			if err := c.push(context.Background()); err != nil {
+				slog.Error("Error pushing standby state", "error", err)
+			}
+		}
+	}()
+}
+
+// stopPushing stops the pushes started by startPushing and pushes the
+// final state.
+func (c *standbyCopy) stopPushing() {
+	if c == nil || c.stop == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	c.stop = nil
+	if err := c.push(context.Background()); err != nil {
+		slog.Error("Error pushing standby state", "error", err)
+	}
+}
+
+// fetch reads the state kept in the bucket, reporting false if there is
+// none.
+func (c *standbyCopy) fetch(ctx context.Context) (standbyState, bool, error) {
+	var s standbyState
+	data, err := c.bucket.Get(ctx, standbyKey)
+	if errors.Is(err, objstore.ErrNotExist) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, fmt.Errorf("fetching standby state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, false, fmt.Errorf("decoding standby state: %w", err)
+	}
+	return s, true, nil
+}
+
+// restore copies the state kept in the bucket into the store where it
+// cannot lose newer progress: the checkpoint if the store has none, and the
+// watermarks ahead of the store's. With force both are replaced. A nil
+// *standbyCopy does nothing.
+func (c *standbyCopy) restore(ctx context.Context, force bool) error {
+	if c == nil {
+		return nil
+	}
+	s, ok, err := c.fetch(ctx)
+	if err != nil || !ok {
+		return err
+	}
+
+	restored := false
+	if c.cs != nil && (len(s.MailboxesDone) > 0 || len(s.UsersDone) > 0 || force) {
+		local, err := c.cs.LoadCheckpoint(ctx)
+		if err != nil {
+			return fmt.Errorf("loading checkpoint: %w", err)
+		}
+		if force || (len(local.Mailboxes) == 0 && len(local.Users) == 0) {
+			done := db.Checkpoint{Mailboxes: make(map[int]bool), Users: make(map[db.ProcessedUser]bool)}
+			for _, id := range s.MailboxesDone {
+				done.Mailboxes[id] = true
+			}
+			for _, p := range s.UsersDone {
+				done.Users[db.ProcessedUser{MailboxID: p[0], UserID: p[1]}] = true
+			}
+			if err := c.cs.ReplaceCheckpoint(ctx, done); err != nil {
+				return fmt.Errorf("restoring checkpoint: %w", err)
+			}
+			slog.Info("Restored checkpoint from standby state", "mailboxes_done", len(done.Mailboxes), "users_done", len(done.Users),
+				"saved_at", s.SavedAt, "holder", s.Holder)
+			restored = true
+		}
+	}
+	if c.ws != nil {
+		for name, remote := range s.Watermarks {
+			local, err := c.ws.Watermark(ctx, name)
+			if err != nil {
+				return fmt.Errorf("loading watermark %s: %w", name, err)
+			}
+			if !force && !watermarkBefore(local, remote) {
+				continue
+			}
+			if err := c.ws.SaveWatermark(ctx, name, db.Watermark{CreatedAt: remote.CreatedAt, UserID: remote.UserID}); err != nil {
+				return fmt.Errorf("restoring watermark %s: %w", name, err)
+			}
+			slog.Info("Restored watermark from standby state", "watermark", name, "created_at", remote.CreatedAt, "user_id", remote.UserID)
+			restored = true
+		}
+	}
+	if !restored {
+		slog.Debug("Standby state is not ahead of the store's", "saved_at", s.SavedAt)
+	}
+	return nil
+}
+
+// watermarkBefore reports whether wm is behind remote. Drivers return
+// timestamps in different layouts, so they are compared as times.
+func watermarkBefore(wm db.Watermark, remote standbyWatermark) bool {
+	local, err1 := db.ParseTimestamp(wm.CreatedAt)
+	saved, err2 := db.ParseTimestamp(remote.CreatedAt)
+	if err1 != nil || err2 != nil {
+		return wm.CreatedAt < remote.CreatedAt
+	}
+	if !local.Equal(saved) {
+		return local.Before(saved)
+	}
+	return wm.UserID < remote.UserID
+}
+
+const standbyUsage = "Usage: standby push | restore [--force] | show"
+
+// standbyCommand pushes the store's state to object storage, restores it
+// from there, or prints what is kept there.
+func standbyCommand(store db.Store, args []string) {
+	c, err := openStandby(store)
+	if err != nil {
+		fatal("Error opening standby storage", "error", err)
+	}
+	if c == nil {
+		fatal("standby.url is not set")
+	}
+	if len(args) == 0 {
+		fatal(standbyUsage)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "push":
+		if err := c.push(ctx); err != nil {
+			fatal("Error pushing standby state", "error", err)
+		}
+		slog.Info("Pushed standby state")
+	case "restore":
+		force := len(args) > 1 && args[1] == "--force"
+		if err := c.restore(ctx, force); err != nil {
+			fatal("Error restoring standby state", "error", err)
+		}
+	case "show":
+		s, ok, err := c.fetch(ctx)
+		if err != nil {
+			fatal("Error reading standby state", "error", err)
+		}
+		if !ok {
+			fatal("No standby state saved")
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			fatal("Error writing standby state", "error", err)
+		}
+	default:
+		fatal(standbyUsage)
+	}
+}
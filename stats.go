@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mailboxes/capacity"
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// statsCommand prints row counts, table and index sizes and the largest
+// mailboxes, with growth since the previous run, then saves this run as the
+// snapshot the next one is compared with.
+func statsCommand(store db.Store, args []string) {
+	viper.SetDefault("stats.snapshot_file", "stats-snapshot.json")
+
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := fs.Int("top", 10, "how many of the largest mailboxes to list")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	snapshot := fs.String("snapshot", viper.GetString("stats.snapshot_file"), "where the previous run's statistics are kept")
+	fs.Parse(args)
+
+	ss, ok := store.(db.StatsStore)
+	if !ok {
+		fatal("Store does not support statistics")
+	}
+
+	stats, err := ss.Stats(context.Background(), *top)
+	if err != nil {
+		fatal("Error reading statistics", "error", err)
+	}
+	prev, err := capacity.Load(*snapshot)
+	if err != nil {
+		fatal("Error loading snapshot", "snapshot", *snapshot, "error", err)
+	}
+	report := capacity.New(stats, time.Now(), prev)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fatal("Error writing report", "error", err)
+		}
+	} else {
+		printStats(report)
+	}
+
+	if err := capacity.Save(*snapshot, report); err != nil {
+		fatal("Error saving snapshot", "snapshot", *snapshot, "error", err)
+	}
+}
+
+func printStats(r capacity.Report) {
+	growth := make(map[string]capacity.Growth, len(r.Growth))
+	for _, g := range r.Growth {
+		growth[g.Table] = g
+	}
+
+	fmt.Printf("Database size: %d KiB\n", r.DatabaseBytes/1024)
+	if r.Since != nil {
+		fmt.Printf("Growth since %s\n", r.Since.Format(time.RFC3339))
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tROWS\tGROWTH\tTABLE KiB\tINDEX KiB\tGROWTH KiB\t")
+	for _, t := range r.Tables {
+		rows, size := "-", "-"
+		if g, ok := growth[t.Name]; ok {
+			rows = fmt.Sprintf("%+d", g.Rows)
+			size = fmt.Sprintf("%+d", (g.TableBytes+g.IndexBytes)/1024)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%s\t\n", t.Name, t.Rows, rows, t.TableBytes/1024, t.IndexBytes/1024, size)
+	}
+	tw.Flush()
+
+	if len(r.LargestMailboxes) == 0 {
+		return
+	}
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAILBOX\tUSERS\t")
+	for _, m := range r.LargestMailboxes {
+		fmt.Fprintf(tw, "%d\t%d\t\n", m.MailboxID, m.Users)
+	}
+	tw.Flush()
+}
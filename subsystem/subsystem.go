@@ -0,0 +1,110 @@
+// Package subsystem checks the backends the program depends on when it
+// starts. A required subsystem that cannot be reached stops the program; an
+// optional one is replaced by its fallback, usually a no-op, with a warning,
+// so that a notification backend being down doesn't stop users from being
+// processed.
+package subsystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Pinger is implemented by backends that can check they are reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Registry holds the subsystems to check at startup. The zero value is an
+// empty registry.
+type Registry struct {
+	subsystems []subsystem
+	required   map[string]bool
+	degraded   []string
+}
+
+type subsystem struct {
+	name     string
+	required bool
+	check    func(ctx context.Context) error
+	fallback func(err error)
+}
+
+// Required adds a subsystem the program cannot run without.
+func (r *Registry) Required(name string, check func(ctx context.Context) error) {
+	r.subsystems = append(r.subsystems, subsystem{name: name, required: true, check: check})
+}
+
+// Optional adds a subsystem the program can run without. If check fails,
+// fallback is called with the error to put a no-op in its place.
+func (r *Registry) Optional(name string, check func(ctx context.Context) error, fallback func(err error)) {
+	r.subsystems = append(r.subsystems, subsystem{name: name, check: check, fallback: fallback})
+}
+
+// Require makes the named subsystems required even if they were added as
+// optional, for deployments that would rather not start than run without
+// them.
+func (r *Registry) Require(names ...string) {
+	if r.required == nil {
+		r.required = make(map[string]bool)
+	}
+	for _, name := range names {
+		r.required[name] = true
+	}
+}
+
+// Start checks every subsystem in the order added, each within timeout if
+// it is positive. It returns an error for the first required subsystem that
+// fails, and replaces the optional ones that fail with their fallbacks.
+func (r *Registry) Start(ctx context.Context, timeout time.Duration) error {
+	for _, s := range r.subsystems {
+		err := r.check(ctx, s, timeout)
+		switch {
+		case err == nil:
+			slog.Debug("Subsystem available", "subsystem", s.name)
+		case s.required || r.required[s.name]:
+			return fmt.Errorf("subsystem %s: %w", s.name, err)
+		default:
+			slog.Warn("Optional subsystem unavailable; continuing without it", "subsystem", s.name, "error", err)
+			s.fallback(err)
+			r.degraded = append(r.degraded, s.name)
+		}
+	}
+	return nil
+}
+
+func (r *Registry) check(ctx context.Context, s subsystem, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := s.check(ctx)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("no answer within %s: %w", timeout, err)
+	}
+	return err
+}
+
+// Names returns the names of the subsystems added, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.subsystems))
+	for i, s := range r.subsystems {
+		names[i] = s.name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Degraded returns the optional subsystems that Start replaced with their
+// fallbacks, in the order added.
+func (r *Registry) Degraded() []string {
+	return r.degraded
+}
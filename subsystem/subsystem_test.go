@@ -0,0 +1,67 @@
+package subsystem
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Start(t *testing.T) {
+	down := errors.New("connection refused")
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return down }
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	var fellBack []string
+	fallback := func(name string) func(error) {
+		return func(err error) {
+			if !errors.Is(err, down) && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Unexpected fallback error for %s: %v", name, err)
+			}
+			fellBack = append(fellBack, name)
+		}
+	}
+
+	var r Registry
+	r.Required("database", ok)
+	r.Optional("provider", fail, fallback("provider"))
+	r.Optional("alerts", hang, fallback("alerts"))
+	r.Optional("metrics", ok, fallback("metrics"))
+	if err := r.Start(context.Background(), 10*time.Millisecond); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"provider", "alerts"}
+	if !reflect.DeepEqual(fellBack, expected) {
+		t.Errorf("Expected fallbacks for %v, got %v", expected, fellBack)
+	}
+	if !reflect.DeepEqual(r.Degraded(), expected) {
+		t.Errorf("Expected %v degraded, got %v", expected, r.Degraded())
+	}
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"alerts", "database", "metrics", "provider"}) {
+		t.Errorf("Unexpected names %v", names)
+	}
+}
+
+func TestRegistry_StartRequired(t *testing.T) {
+	down := errors.New("connection refused")
+
+	var r Registry
+	r.Required("database", func(ctx context.Context) error { return down })
+	if err := r.Start(context.Background(), 0); !errors.Is(err, down) {
+		t.Errorf("Expected the database error, got %v", err)
+	}
+
+	r = Registry{}
+	r.Optional("provider", func(ctx context.Context) error { return down }, func(error) {
+		t.Error("Expected no fallback for a subsystem made required")
+	})
+	r.Require("provider")
+	if err := r.Start(context.Background(), 0); !errors.Is(err, down) {
+		t.Errorf("Expected the provider error, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/provider"
+	"mailboxes/sink"
+	"mailboxes/subsystem"
+
+	"github.com/spf13/viper"
+)
+
+// pipelineCommands are the commands that process users, and so check the
+// subsystems they use before starting.
+var pipelineCommands = map[string]bool{"run": true, "watch": true, "daemon": true, "worker": true, "reprocess": true}
+
+// startSubsystems checks that the database and the optional backends
+// configured can be reached. The optional ones are the provider that
+// refreshes expired tokens, the Redis cache and the slo.sink alerting sink;
+// one that cannot be reached is replaced by a no-op, unless it is listed in
+// subsystems.required.
+func startSubsystems(store db.Store, prov provider.Provider) error {
+	viper.SetDefault("subsystems.timeout", 5*time.Second)
+
+	var reg subsystem.Registry
+	if p, ok := store.(subsystem.Pinger); ok {
+		reg.Required("database", p.Ping)
+	}
+	if p, ok := prov.(subsystem.Pinger); ok && tokenRefresher != nil {
+		reg.Optional("provider", p.Ping, func(error) {
+			slog.Warn("Expired tokens will not be refreshed; their mailboxes are skipped")
+			tokenRefresher = nil
+		})
+	}
+	if redisCache != nil {
+		reg.Optional("redis_cache", redisCache.Ping, func(error) {
+			slog.Warn("Mailboxes and users will be read from the database", "cache", "redis")
+			redisCache = nil
+		})
+	}
+	if p, ok := sloSink.(subsystem.Pinger); ok {
+		reg.Optional("slo_sink", p.Ping, func(error) {
+			slog.Warn("SLO alerts will not be sent", "sink", viper.GetString("slo.sink"))
+			sloSink = sink.Discard{}
+		})
+	}
+
+	required := viper.GetStringSlice("subsystems.required")
+	for _, name := range required {
+		if !slices.Contains(reg.Names(), name) {
+			slog.Warn("Required subsystem is not configured", "subsystem", name)
+		}
+	}
+	reg.Require(required...)
+	return reg.Start(context.Background(), viper.GetDuration("subsystems.timeout"))
+}
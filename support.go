@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/supportbundle"
+
+	"github.com/spf13/viper"
+)
+
+// supportBundleCommand writes a tarball of diagnostics for attaching to a
+// ticket: the configuration with secrets redacted, the latest SLO, timing
+// and stats reports, the schema and its migrations, build data and the last
+// lines of log.file.
+func supportBundleCommand(store db.Store, args []string) {
+	viper.SetDefault("stats.snapshot_file", "stats-snapshot.json")
+
+	name := "support-bundle-" + time.Now().UTC().Format("20060102-150405")
+	fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	out := fs.String("out", name+".tar.gz", "bundle file to write")
+	logFile := fs.String("log-file", viper.GetString("log.file"), "log file to take recent lines from")
+	logLines := fs.Int("log-lines", 1000, "how many of the most recent log lines to include")
+	fs.Parse(args)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fatal("Error creating file", "path", *out, "error", err)
+	}
+	w := supportbundle.NewWriter(f, strings.TrimSuffix(filepath.Base(*out), ".tar.gz"))
+
+	if err := collectSupportBundle(w, store, *logFile, *logLines); err != nil {
+		f.Close()
+		os.Remove(*out)
+		fatal("Error writing support bundle", "error", err)
+	}
+	if err := w.Close(); err != nil {
+		fatal("Error writing support bundle", "error", err)
+	}
+	if err := f.Close(); err != nil {
+		fatal("Error writing file", "path", *out, "error", err)
+	}
+	slog.Info("Support bundle written", "path", *out)
+}
+
+// collectSupportBundle adds every part of the bundle to w. Parts that cannot
+// be collected are noted in the bundle's manifest; only failures to write
+// the bundle itself are returned.
+func collectSupportBundle(w *supportbundle.Writer, store db.Store, logFile string, logLines int) error {
+	ctx := context.Background()
+
+	config := supportbundle.RedactSettings(viper.AllSettings(), viper.GetStringSlice("debug.redact_fields")...)
+	if err := w.AddJSON("config.json", config); err != nil {
+		return err
+	}
+	if err := w.AddJSON("build.json", buildData()); err != nil {
+		return err
+	}
+
+	if path := viper.GetString("slo.report_file"); path != "" {
+		if err := w.AddFile("reports/slo.json", path); err != nil {
+			return err
+		}
+	}
+	if path := viper.GetString("timing.report_file"); path != "" {
+		if err := w.AddFile("reports/timing.json", path); err != nil {
+			return err
+		}
+	}
+	if err := w.AddFile("reports/stats.json", viper.GetString("stats.snapshot_file")); err != nil {
+		return err
+	}
+
+	if ms, ok := store.(db.MigrateStore); ok {
+		status, err := ms.MigrationStatus(ctx)
+		if err != nil {
+			w.Problem("schema/migrations.json", err)
+		} else if err := w.AddJSON("schema/migrations.json", status); err != nil {
+			return err
+		}
+	}
+	if ss, ok := store.(db.StatsStore); ok {
+		stats, err := ss.Stats(ctx, 10)
+		if err != nil {
+			w.Problem("schema/stats.json", err)
+		} else if err := w.AddJSON("schema/stats.json", stats); err != nil {
+			return err
+		}
+	}
+
+	if logFile == "" {
+		w.Problem("logs/recent.log", fmt.Errorf("log.file is not set"))
+		return nil
+	}
+	lines, err := supportbundle.Tail(logFile, logLines)
+	if err != nil {
+		w.Problem("logs/recent.log", err)
+		return nil
+	}
+	return w.Add("logs/recent.log", lines)
+}
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	GoVersion  string            `json:"go_version"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	Module     string            `json:"module,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Settings   map[string]string `json:"settings,omitempty"`
+	Deps       []string          `json:"deps,omitempty"`
+	ConfigFile string            `json:"config_file,omitempty"`
+	Driver     string            `json:"database_driver"`
+	SQLite     string            `json:"sqlite_driver"`
+}
+
+func buildData() buildInfo {
+	info := buildInfo{
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		ConfigFile: viper.ConfigFileUsed(),
+		Driver:     viper.GetString("database.driver"),
+		SQLite:     db.SQLiteDriver,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Module, info.Version = bi.Main.Path, bi.Main.Version
+	info.Settings = make(map[string]string)
+	for _, s := range bi.Settings {
+		info.Settings[s.Key] = s.Value
+	}
+	for _, dep := range bi.Deps {
+		info.Deps = append(info.Deps, dep.Path+"@"+dep.Version)
+	}
+	return info
+}
@@ -0,0 +1,213 @@
+// Package supportbundle collects diagnostics into a gzipped tarball that can
+// be attached to a ticket: configuration with its secrets redacted, reports
+// from recent runs, schema information, build data and recent log lines.
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the values of redacted settings.
+const Redacted = "[REDACTED]"
+
+// defaultRedactions are setting names whose values are always redacted,
+// matched case-insensitively as substrings.
+var defaultRedactions = []string{"token", "secret", "password", "authorization", "key"}
+
+// Writer writes a bundle. Every file is placed under a directory named after
+// the bundle, so unpacking it does not scatter files. Problems collecting a
+// file are recorded rather than failing the bundle, and listed in the
+// manifest written by Close.
+type Writer struct {
+	dir      string
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	now      time.Time
+	manifest manifest
+}
+
+type manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Files     []string  `json:"files"`
+	Problems  []string  `json:"problems,omitempty"`
+}
+
+// NewWriter returns a Writer that writes a bundle named dir to w.
+func NewWriter(w io.Writer, dir string) *Writer {
+	gz := gzip.NewWriter(w)
+	now := time.Now().UTC()
+	return &Writer{dir: dir, gz: gz, tw: tar.NewWriter(gz), now: now, manifest: manifest{CreatedAt: now}}
+}
+
+// Add writes data to the bundle as name.
+func (w *Writer) Add(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    w.dir + "/" + name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: w.now,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return err
+	}
+	w.manifest.Files = append(w.manifest.Files, name)
+	return nil
+}
+
+// AddJSON writes v to the bundle as indented JSON.
+func (w *Writer) AddJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.Add(name, append(data, '\n'))
+}
+
+// AddFile copies the file at path into the bundle as name. A missing file is
+// recorded as a problem.
+func (w *Writer) AddFile(name, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		w.Problem(name, err)
+		return nil
+	}
+	return w.Add(name, data)
+}
+
+// Problem records that name could not be collected.
+func (w *Writer) Problem(name string, err error) {
+	w.manifest.Problems = append(w.manifest.Problems, name+": "+err.Error())
+}
+
+// Close writes the manifest and finishes the bundle. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.AddJSON("manifest.json", w.manifest); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// RedactSettings returns a copy of settings, as from viper.AllSettings, with
+// the values of sensitive settings replaced at any depth. Settings are
+// sensitive if their name contains one of fields or a token, secret,
+// password, authorization header or key, except for URLs such as
+// provider.token_url, whose passwords alone are redacted wherever they
+// appear.
+func RedactSettings(settings map[string]any, fields ...string) map[string]any {
+	names := append([]string{}, defaultRedactions...)
+	for _, f := range fields {
+		names = append(names, strings.ToLower(f))
+	}
+	return redact(settings, names).(map[string]any)
+}
+
+func redact(v any, names []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			if sensitive(k, names) {
+				out[k] = Redacted
+			} else {
+				out[k] = redact(val, names)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = redact(val, names)
+		}
+		return out
+	case []map[string]any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = redact(val, names)
+		}
+		return out
+	case string:
+		return redactURL(v)
+	}
+	return v
+}
+
+func sensitive(name string, names []string) bool {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, "url") {
+		return false
+	}
+	for _, n := range names {
+		if strings.Contains(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL hides the password of a URL with credentials and returns any
+// other string unchanged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
+
+// tailBlock is how much of a file Tail reads at a time, from the end.
+const tailBlock = 64 << 10
+
+// Tail returns the last n lines of the file at path.
+func Tail(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read blocks backwards until there are more than n line breaks, not
+	// counting one that ends the file.
+	var data []byte
+	offset := info.Size()
+	for offset > 0 && bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) < n {
+		size := min(int64(tailBlock), offset)
+		offset -= size
+		block := make([]byte, size)
+		if _, err := f.ReadAt(block, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		data = append(block, data...)
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, nil), nil
+}
@@ -0,0 +1,153 @@
+// Package timing breaks the time spent on each mailbox down by pipeline
+// stage: reading users from the store, running the script over them and
+// handing them to the processor. It tells a slow database apart from a slow
+// sink.
+package timing
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage is a step of the pipeline.
+type Stage int
+
+const (
+	// Read is time spent waiting on the store for users.
+	Read Stage = iota
+	// Transform is time spent running the pipeline script.
+	Transform
+	// Sink is time spent in the processor.
+	Sink
+
+	numStages
+)
+
+var stageNames = [numStages]string{"read", "transform", "sink"}
+
+func (s Stage) String() string {
+	return stageNames[s]
+}
+
+// Breakdown accumulates time per stage for one mailbox. It is safe for
+// concurrent use, and a nil *Breakdown records nothing, so callers can hold
+// one unconditionally.
+type Breakdown struct {
+	stages [numStages]atomic.Int64
+}
+
+// Add records d spent in stage.
+func (b *Breakdown) Add(stage Stage, d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.stages[stage].Add(int64(d))
+}
+
+// Since records the time since start as spent in stage.
+func (b *Breakdown) Since(stage Stage, start time.Time) {
+	b.Add(stage, time.Since(start))
+}
+
+// Get returns the time recorded for stage.
+func (b *Breakdown) Get(stage Stage) time.Duration {
+	if b == nil {
+		return 0
+	}
+	return time.Duration(b.stages[stage].Load())
+}
+
+// Attrs returns the time per stage as slog attributes, e.g. read=1.2s.
+func (b *Breakdown) Attrs() []any {
+	attrs := make([]any, 0, 2*numStages)
+	for s := Stage(0); s < numStages; s++ {
+		attrs = append(attrs, s.String(), b.Get(s))
+	}
+	return attrs
+}
+
+type breakdownKey struct{}
+
+// NewContext returns ctx carrying b, for the stages run on its behalf.
+func NewContext(ctx context.Context, b *Breakdown) context.Context {
+	return context.WithValue(ctx, breakdownKey{}, b)
+}
+
+// FromContext returns the Breakdown ctx carries, or nil.
+func FromContext(ctx context.Context) *Breakdown {
+	b, _ := ctx.Value(breakdownKey{}).(*Breakdown)
+	return b
+}
+
+// Mailbox is the time one mailbox spent in each stage, in seconds. A report's
+// total has no MailboxID.
+type Mailbox struct {
+	MailboxID int     `json:"mailbox_id,omitempty"`
+	Read      float64 `json:"read_seconds"`
+	Transform float64 `json:"transform_seconds"`
+	Sink      float64 `json:"sink_seconds"`
+}
+
+// Total is the time the mailbox spent in all stages.
+func (m Mailbox) Total() float64 {
+	return m.Read + m.Transform + m.Sink
+}
+
+// Report is the time a run spent in each stage, in total and for the
+// slowest mailboxes.
+type Report struct {
+	Mailboxes int       `json:"mailboxes"`
+	Total     Mailbox   `json:"total"`
+	Slowest   []Mailbox `json:"slowest"`
+	// Annotations are the run's annotations, set by the caller.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Recorder collects the breakdown of every mailbox in a run. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu        sync.Mutex
+	mailboxes []Mailbox
+}
+
+// Done records the breakdown of a finished mailbox.
+func (r *Recorder) Done(mailboxID int, b *Breakdown) {
+	m := Mailbox{
+		MailboxID: mailboxID,
+		Read:      b.Get(Read).Seconds(),
+		Transform: b.Get(Transform).Seconds(),
+		Sink:      b.Get(Sink).Seconds(),
+	}
+	r.mu.Lock()
+	r.mailboxes = append(r.mailboxes, m)
+	r.mu.Unlock()
+}
+
+// Report returns the totals and the top mailboxes with the most time spent
+// across all stages, slowest first.
+func (r *Recorder) Report(top int) Report {
+	r.mu.Lock()
+	mailboxes := append([]Mailbox(nil), r.mailboxes...)
+	r.mu.Unlock()
+
+	rep := Report{Mailboxes: len(mailboxes)}
+	for _, m := range mailboxes {
+		rep.Total.Read += m.Read
+		rep.Total.Transform += m.Transform
+		rep.Total.Sink += m.Sink
+	}
+	sort.Slice(mailboxes, func(i, j int) bool {
+		if mailboxes[i].Total() != mailboxes[j].Total() {
+			return mailboxes[i].Total() > mailboxes[j].Total()
+		}
+		return mailboxes[i].MailboxID < mailboxes[j].MailboxID
+	})
+	if top < len(mailboxes) {
+		mailboxes = mailboxes[:top]
+	}
+	rep.Slowest = mailboxes
+	return rep
+}
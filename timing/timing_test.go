@@ -0,0 +1,64 @@
+package timing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBreakdown(t *testing.T) {
+	var b Breakdown
+	b.Add(Read, time.Second)
+	b.Add(Read, 2*time.Second)
+	b.Add(Sink, time.Millisecond)
+
+	expected := []any{"read", 3 * time.Second, "transform", time.Duration(0), "sink", time.Millisecond}
+	if attrs := b.Attrs(); !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, attrs)
+	}
+
+	var none *Breakdown
+	none.Add(Read, time.Second)
+	if none.Get(Read) != 0 {
+		t.Error("Expected a nil Breakdown to record nothing")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Error("Expected no Breakdown in a bare context")
+	}
+	b := &Breakdown{}
+	FromContext(NewContext(context.Background(), b)).Add(Transform, time.Second)
+	if b.Get(Transform) != time.Second {
+		t.Errorf("Expected 1s recorded through the context, got %s", b.Get(Transform))
+	}
+}
+
+func TestRecorder_Report(t *testing.T) {
+	breakdown := func(read, transform, sink time.Duration) *Breakdown {
+		b := &Breakdown{}
+		b.Add(Read, read)
+		b.Add(Transform, transform)
+		b.Add(Sink, sink)
+		return b
+	}
+
+	var r Recorder
+	r.Done(1, breakdown(time.Second, 0, time.Second))
+	r.Done(2, breakdown(0, 0, 5*time.Second))
+	r.Done(3, breakdown(time.Second, time.Second, 0))
+
+	expected := Report{
+		Mailboxes: 3,
+		Total:     Mailbox{Read: 2, Transform: 1, Sink: 6},
+		Slowest: []Mailbox{
+			{MailboxID: 2, Sink: 5},
+			{MailboxID: 1, Read: 1, Sink: 1},
+		},
+	}
+	if got := r.Report(2); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
@@ -0,0 +1,154 @@
+// Package token checks mailbox tokens against format rules, so malformed or
+// expired tokens are caught before they fail downstream mid-run.
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mailboxes/db"
+)
+
+const (
+	FormatAny    = ""
+	FormatBase64 = "base64"
+	FormatJWT    = "jwt"
+)
+
+// Rules describes what a valid token looks like. Zero values disable the
+// corresponding check.
+type Rules struct {
+	MinLength int
+	MaxLength int
+	Prefix    string
+	// Format is FormatAny, FormatBase64 or FormatJWT. The prefix, if any, is
+	// stripped before the format is checked.
+	Format string
+	// RequireExpiry rejects JWTs without an exp claim.
+	RequireExpiry bool
+}
+
+// Check returns every rule the token breaks, or nil if it is valid.
+func (r Rules) Check(tok string, now time.Time) []string {
+	var problems []string
+
+	if tok == "" {
+		return []string{"token is empty"}
+	}
+	if r.MinLength > 0 && len(tok) < r.MinLength {
+		problems = append(problems, fmt.Sprintf("shorter than %d characters", r.MinLength))
+	}
+	if r.MaxLength > 0 && len(tok) > r.MaxLength {
+		problems = append(problems, fmt.Sprintf("longer than %d characters", r.MaxLength))
+	}
+	if r.Prefix != "" && !strings.HasPrefix(tok, r.Prefix) {
+		problems = append(problems, fmt.Sprintf("missing prefix %q", r.Prefix))
+	}
+
+	body := strings.TrimPrefix(tok, r.Prefix)
+	switch r.Format {
+	case FormatAny:
+	case FormatBase64:
+		if !isBase64(body) {
+			problems = append(problems, "not valid base64")
+		}
+	case FormatJWT:
+		claims, err := ParseClaims(body)
+		if err != nil {
+			problems = append(problems, err.Error())
+			break
+		}
+		exp, ok := claims.Expiry()
+		switch {
+		case !ok && r.RequireExpiry:
+			problems = append(problems, "JWT has no exp claim")
+		case ok && !exp.After(now):
+			problems = append(problems, fmt.Sprintf("JWT expired at %s", exp.UTC().Format(time.RFC3339)))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown format rule %q", r.Format))
+	}
+
+	return problems
+}
+
+func isBase64(s string) bool {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if _, err := enc.DecodeString(s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the decoded payload of a JWT.
+type Claims map[string]any
+
+// Expiry returns the exp claim, if present and numeric.
+func (c Claims) Expiry() (time.Time, bool) {
+	exp, ok := c["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// Expired reports whether tok is a JWT whose exp claim falls before
+// now+skew, along with the expiry. Tokens that aren't JWTs or carry no exp
+// claim are never considered expired.
+func Expired(tok string, now time.Time, skew time.Duration) (time.Time, bool) {
+	claims, err := ParseClaims(tok)
+	if err != nil {
+		return time.Time{}, false
+	}
+	exp, ok := claims.Expiry()
+	if !ok {
+		return time.Time{}, false
+	}
+	return exp, !exp.After(now.Add(skew))
+}
+
+// Refresher obtains a replacement for a mailbox's expired token.
+type Refresher interface {
+	Refresh(mb db.Mailbox) (string, error)
+}
+
+var ErrNotJWT = errors.New("not a JWT")
+
+// ParseClaims decodes the header and payload of a JWT without verifying its
+// signature; it is only meant for linting and expiry checks.
+func ParseClaims(tok string) (Claims, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, ErrNotJWT
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %w", err)
+	}
+	if header.Alg == "" {
+		return nil, fmt.Errorf("JWT header has no alg")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT payload: %w", err)
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,112 @@
+package token
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func makeJWT(payload string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString([]byte(payload)) + "." +
+		enc.EncodeToString([]byte("signature"))
+}
+
+func TestRules_Check(t *testing.T) {
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		rules            Rules
+		token            string
+		expectedProblems []string
+	}{
+		{
+			name:  "Valid plain token",
+			rules: Rules{MinLength: 5, Prefix: "tok"},
+			token: "token123",
+		},
+		{
+			name:             "Empty token",
+			rules:            Rules{},
+			token:            "",
+			expectedProblems: []string{"token is empty"},
+		},
+		{
+			name:             "Too short without prefix",
+			rules:            Rules{MinLength: 10, Prefix: "mbx_"},
+			token:            "token123",
+			expectedProblems: []string{"shorter than 10 characters", `missing prefix "mbx_"`},
+		},
+		{
+			name:  "Valid base64",
+			rules: Rules{Format: FormatBase64},
+			token: "dG9rZW4xMjM=",
+		},
+		{
+			name:             "Invalid base64",
+			rules:            Rules{Format: FormatBase64},
+			token:            "not base64!",
+			expectedProblems: []string{"not valid base64"},
+		},
+		{
+			name:  "Unexpired JWT",
+			rules: Rules{Format: FormatJWT, RequireExpiry: true},
+			token: makeJWT(`{"sub":"mpi123","exp":1721739600}`),
+		},
+		{
+			name:             "Expired JWT",
+			rules:            Rules{Format: FormatJWT},
+			token:            makeJWT(`{"sub":"mpi123","exp":1721728800}`),
+			expectedProblems: []string{"JWT expired at 2024-07-23T10:00:00Z"},
+		},
+		{
+			name:             "JWT without exp",
+			rules:            Rules{Format: FormatJWT, RequireExpiry: true},
+			token:            makeJWT(`{"sub":"mpi123"}`),
+			expectedProblems: []string{"JWT has no exp claim"},
+		},
+		{
+			name:             "Not a JWT",
+			rules:            Rules{Format: FormatJWT},
+			token:            "token123",
+			expectedProblems: []string{"not a JWT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := tt.rules.Check(tt.token, now)
+			if !reflect.DeepEqual(problems, tt.expectedProblems) {
+				t.Errorf("Expected problems %q, got %q", tt.expectedProblems, problems)
+			}
+		})
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2024, 7, 23, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		token           string
+		skew            time.Duration
+		expectedExpired bool
+	}{
+		{name: "Not a JWT", token: "token123"},
+		{name: "No exp claim", token: makeJWT(`{"sub":"mpi123"}`)},
+		{name: "Valid", token: makeJWT(`{"exp":1721739600}`)},
+		{name: "Expired", token: makeJWT(`{"exp":1721728800}`), expectedExpired: true},
+		{name: "Expires within skew", token: makeJWT(`{"exp":1721739600}`), skew: 2 * time.Hour, expectedExpired: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, expired := Expired(tt.token, now, tt.skew); expired != tt.expectedExpired {
+				t.Errorf("Expected expired %v, got %v", tt.expectedExpired, expired)
+			}
+		})
+	}
+}
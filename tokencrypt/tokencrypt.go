@@ -0,0 +1,170 @@
+// Package tokencrypt encrypts mailbox tokens at rest with AES-GCM. Sealed
+// tokens name the key they were sealed with, so keys can be rotated: new
+// writes use the primary key, older keys stay available for reading until
+// every row has been re-sealed.
+package tokencrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// prefix marks a sealed token. Tokens without it are plaintext, as stored
+// before encryption was enabled.
+const prefix = "enc:v1:"
+
+// ErrNoKey is returned when opening a token sealed with a key the keyring
+// does not hold.
+var ErrNoKey = errors.New("token sealed with an unknown key")
+
+// Keyring holds the keys tokens are sealed and opened with. A nil *Keyring
+// leaves tokens as they are, so stores can hold one unconditionally.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring sealing with keys[primary]. Keys must be 16,
+// 24 or 32 bytes, for AES-128, AES-192 or AES-256.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not configured", primary)
+	}
+
+	k := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if k.keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key new tokens are sealed with.
+func (k *Keyring) Primary() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// Seal encrypts token with the primary key. The empty token, used for
+// mailboxes still waiting on one, stays empty.
+func (k *Keyring) Seal(token string) (string, error) {
+	if k == nil || token == "" {
+		return token, nil
+	}
+
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(token), nil)
+	return prefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a token sealed by Seal. Plaintext tokens are returned as
+// they are.
+func (k *Keyring) Open(stored string) (string, error) {
+	id, data, ok := split(stored)
+	if !ok {
+		return stored, nil
+	}
+	if k == nil {
+		return "", fmt.Errorf("%w %q: no keys configured", ErrNoKey, id)
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrNoKey, id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed token sealed with key %q", id)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting token sealed with key %q: %w", id, err)
+	}
+	return string(plain), nil
+}
+
+// NeedsRotation reports whether stored is not yet sealed with the primary
+// key: it is plaintext or sealed with an older key.
+func (k *Keyring) NeedsRotation(stored string) bool {
+	if k == nil || stored == "" {
+		return false
+	}
+	id, _, ok := split(stored)
+	return !ok || id != k.primary
+}
+
+// split returns the key ID and data of a sealed token.
+func split(stored string) (id, data string, ok bool) {
+	rest, ok := strings.CutPrefix(stored, prefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// LoadKey reads a key from source, one of:
+//
+//	env:NAME       the base64 key in environment variable NAME
+//	file:PATH      the base64 key in a file, such as a mounted secret
+//	cmd:COMMAND    the base64 key printed by a shell command, such as a KMS
+//	               client decrypting a wrapped data key
+//	anything else  the base64 key itself
+func LoadKey(ctx context.Context, source string) ([]byte, error) {
+	kind, value, _ := strings.Cut(source, ":")
+	var encoded []byte
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(value)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", value)
+		}
+		encoded = []byte(v)
+	case "file":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		encoded = data
+	case "cmd":
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", value)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("key command: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		encoded = out
+	default:
+		encoded = []byte(source)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,113 @@
+package tokencrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestKeyring_SealOpen(t *testing.T) {
+	old, err := NewKeyring("2023", map[string][]byte{"2023": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewKeyring("2024", map[string][]byte{"2023": oldKey, "2024": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := old.Seal("token123")
+	if err != nil {
+		t.Fatalf("Error sealing: %v", err)
+	}
+	if strings.Contains(sealed, "token123") || !strings.HasPrefix(sealed, "enc:v1:2023:") {
+		t.Errorf("Expected a token sealed with key 2023, got %q", sealed)
+	}
+	if again, _ := old.Seal("token123"); again == sealed {
+		t.Error("Expected a fresh nonce for every seal")
+	}
+
+	tests := []struct {
+		name     string
+		keyring  *Keyring
+		stored   string
+		expected string
+		rotate   bool
+		err      error
+	}{
+		{name: "Same key", keyring: old, stored: sealed, expected: "token123"},
+		{name: "Older key", keyring: rotated, stored: sealed, expected: "token123", rotate: true},
+		{name: "Plaintext", keyring: rotated, stored: "token456", expected: "token456", rotate: true},
+		{name: "Empty", keyring: rotated, stored: "", expected: ""},
+		{name: "Unknown key", keyring: old, stored: "enc:v1:2025:AAAA", err: ErrNoKey},
+		{name: "No keyring", keyring: nil, stored: sealed, err: ErrNoKey},
+		{name: "No keyring, plaintext", keyring: nil, stored: "token456", expected: "token456"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyring.Open(tt.stored)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+			if rotate := tt.keyring.NeedsRotation(tt.stored); tt.err == nil && rotate != tt.rotate {
+				t.Errorf("Expected NeedsRotation %v, got %v", tt.rotate, rotate)
+			}
+		})
+	}
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := old.Open(tampered); err == nil {
+		t.Error("Expected an error opening a tampered token")
+	}
+}
+
+func TestNewKeyring_Errors(t *testing.T) {
+	if _, err := NewKeyring("missing", map[string][]byte{"a": oldKey}); err == nil {
+		t.Error("Expected an error for a missing primary key")
+	}
+	if _, err := NewKeyring("a", map[string][]byte{"a": []byte("short")}); err == nil {
+		t.Error("Expected an error for a key of the wrong size")
+	}
+	if _, err := NewKeyring("a:b", map[string][]byte{"a:b": oldKey}); err == nil {
+		t.Error("Expected an error for a key ID with a colon")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(newKey)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKENCRYPT_TEST_KEY", encoded)
+
+	for _, source := range []string{encoded, "env:TOKENCRYPT_TEST_KEY", "file:" + path, "cmd:echo " + encoded} {
+		key, err := LoadKey(context.Background(), source)
+		if err != nil {
+			t.Fatalf("Error loading %s: %v", source, err)
+		}
+		if !bytes.Equal(key, newKey) {
+			t.Errorf("Expected the key from %s, got %x", source, key)
+		}
+	}
+
+	for _, source := range []string{"env:TOKENCRYPT_TEST_UNSET", "cmd:exit 1", "not base64!"} {
+		if _, err := LoadKey(context.Background(), source); err == nil {
+			t.Errorf("Expected an error loading %s", source)
+		}
+	}
+}
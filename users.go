@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"mailboxes/db"
+)
+
+// usersCommand dispatches the users subcommands.
+func usersCommand(store db.Store, args []string) {
+	if len(args) == 0 {
+		fatal("Usage: users merge --into <id> --from <id,...>")
+	}
+
+	switch args[0] {
+	case "merge":
+		mergeUsersCommand(store, args[1:])
+	default:
+		fatal("Unknown users command", "command", args[0])
+	}
+}
+
+// mergeUsersCommand folds duplicate user rows into one surviving user.
+func mergeUsersCommand(store db.Store, args []string) {
+	fs := flag.NewFlagSet("users merge", flag.ExitOnError)
+	into := fs.Int("into", 0, "ID of the user to keep")
+	from := fs.String("from", "", "comma-separated IDs of the duplicates to merge")
+	fs.Parse(args)
+
+	if *into == 0 || *from == "" {
+		fatal("Usage: users merge --into <id> --from <id,...>")
+	}
+
+	var ids []int
+	for _, s := range strings.Split(*from, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			fatal("Invalid user ID", "user_id", s, "error", err)
+		}
+		ids = append(ids, id)
+	}
+
+	merger, ok := store.(db.MergeStore)
+	if !ok {
+		fatal("Store does not support merging users")
+	}
+
+	if err := merger.MergeUsers(context.Background(), *into, ids); err != nil {
+		fatal("Error merging users", "into_user_id", *into, "error", err)
+	}
+	clearCache()
+	slog.Info("Users merged", "users", len(ids), "into_user_id", *into)
+}
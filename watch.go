@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mailboxes/db"
+
+	"github.com/spf13/viper"
+)
+
+// watchWatermark is the name under which watch mode persists its progress.
+const watchWatermark = "watch"
+
+func watchCommand(store db.Store, args []string) {
+	viper.SetDefault("watch.interval", 30*time.Second)
+	viper.SetDefault("watch.queue_interval", time.Second)
+	viper.SetDefault("watch.retry_interval", 10*time.Second)
+
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", viper.GetDuration("watch.interval"), "how often to poll for new users")
+	queueInterval := flags.Duration("queue-interval", viper.GetDuration("watch.queue_interval"), "how often to check the work queue")
+	retryInterval := flags.Duration("retry-interval", viper.GetDuration("watch.retry_interval"), "how often to attempt due retries")
+	flags.Parse(args)
+
+	ws, ok := store.(db.WatermarkStore)
+	if !ok {
+		fatal("Store does not support watch mode")
+	}
+	if err := setupLedger(context.Background(), store); err != nil {
+		fatal("Error loading ledger", "error", err)
+	}
+	if err := standby.restore(context.Background(), false); err != nil {
+		slog.Error("Error restoring standby state; watching from the store's watermark", "error", err)
+	}
+	standby.startPushing()
+
+	stop := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		close(stop)
+	}()
+
+	err := Watch(ws, *interval, *queueInterval, *retryInterval, stop)
+	standby.stopPushing()
+	closeEmitter()
+	if err != nil {
+		fatal("Error watching users", "error", err)
+	}
+}
+
+// Watch polls for users created after the saved watermark every interval,
+// processing each new user and advancing the watermark, until stop is closed.
+// If the store also implements db.QueueStore, manually enqueued users are
+// processed every queueInterval, independently of the regular poll, and if
+// it implements db.RetryStore, failed users are retried every retryInterval
+// once their next attempt is due.
+func Watch(store db.WatermarkStore, interval, queueInterval, retryInterval time.Duration, stop <-chan struct{}) error {
+	ctx := context.Background()
+	wm, err := store.Watermark(ctx, watchWatermark)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Watching for new users", "after", wm.CreatedAt, "interval", interval)
+
+	queue, _ := store.(db.QueueStore)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	queueTicker := time.NewTicker(queueInterval)
+	defer queueTicker.Stop()
+
+	retryStore, _ := store.(db.RetryStore)
+	retryTicker := time.NewTicker(retryInterval)
+	defer retryTicker.Stop()
+
+	poll := func() {
+		if wm, err = pollUsers(store, wm); err != nil {
+			slog.Error("Error polling for new users", "error", err)
+		}
+	}
+
+	poll()
+	for {
+		select {
+		case <-stop:
+			slog.Info("Watch stopped", "user_id", wm.UserID, "created_at", wm.CreatedAt)
+			return nil
+		case <-queueTicker.C:
+			if queue == nil {
+				continue
+			}
+			if err := drainQueue(ctx, queue); err != nil {
+				slog.Error("Error processing work queue", "error", err)
+			}
+		case <-retryTicker.C:
+			if retryStore == nil {
+				continue
+			}
+			if err := drainRetries(ctx, retryStore); err != nil {
+				slog.Error("Error processing retries", "error", err)
+			}
+		case <-ticker.C:
+			poll()
+		}
+	}
+}
+
+// queueBatchSize bounds how many queued users are claimed at once. The batch
+// shrinks under memory pressure.
+const queueBatchSize = 100
+
+// drainQueue processes queued users until the work queue is empty.
+func drainQueue(ctx context.Context, queue db.QueueStore) error {
+	for {
+		batchSize := memory.Scale(queueBatchSize, 1)
+		users, err := queue.ClaimQueuedUsers(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		refreshProcessor(ctx)
+
+		for _, user := range users {
+			handleAttempt(ctx, user, db.Retry{Priority: retryPriorityQueued})
+		}
+
+		if len(users) > 0 {
+			slog.Info("Queued users processed", "users", len(users))
+			reportSkips()
+		}
+		if len(users) < batchSize {
+			return nil
+		}
+	}
+}
+
+// pollUsers processes every user after wm and returns the advanced watermark.
+func pollUsers(store db.WatermarkStore, wm db.Watermark) (db.Watermark, error) {
+	ctx, acks := withAckGroup(context.Background())
+	if err := sources.refresh(ctx); err != nil {
+		slog.Error("Error loading user provenance", "error", err)
+	}
+	userChan, err := store.UsersCreatedSince(ctx, wm)
+	if err != nil {
+		return wm, err
+	}
+
+	refreshProcessor(ctx)
+	start := wm
+	userCount := 0
+	for user := range userChan {
+		if ok, _ := handleUser(ctx, user); ok {
+			userCount++
+		}
+		wm = db.Watermark{CreatedAt: db.FormatTimestamp(user.CreatedAt), UserID: user.ID}
+	}
+	// Users not acknowledged are scheduled for retry like other failures,
+	// so the watermark moves past them too.
+	if n, err := acks.wait(); n > 0 {
+		userCount -= n
+		slog.Error("New users not acknowledged", "users", n, "error", err)
+	}
+
+	if wm == start {
+		return wm, nil
+	}
+
+	slog.Info("New users processed", "users", userCount)
+	reportSkips()
+	ledger.save()
+	return wm, store.SaveWatermark(ctx, watchWatermark, wm)
+}
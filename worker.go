@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"mailboxes/db"
+	"mailboxes/jobqueue"
+	"mailboxes/timing"
+
+	"github.com/spf13/viper"
+)
+
+const workerUsage = "Usage: worker | worker enqueue --all | <mailbox-id>... | worker pending"
+
+// workerConfig reads the worker section.
+func workerConfig() jobqueue.Config {
+	return jobqueue.Config{
+		URL:        viper.GetString("worker.url"),
+		Stream:     viper.GetString("worker.stream"),
+		Subject:    viper.GetString("worker.subject"),
+		Consumer:   viper.GetString("worker.consumer"),
+		AckWait:    viper.GetDuration("worker.ack_wait"),
+		MaxDeliver: viper.GetInt("worker.max_deliver"),
+	}
+}
+
+// workerCommand processes the mailboxes whose jobs it takes from the NATS
+// JetStream job stream, pipeline.workers at a time, until interrupted.
+// worker enqueue adds jobs, for every mailbox or the ones given, and worker
+// pending prints how many are waiting.
+func workerCommand(store db.Store, args []string) {
+	ms, ok := store.(db.MailboxStore)
+	if !ok {
+		fatal("Store cannot read mailboxes by ID")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	q, err := jobqueue.Open(ctx, workerConfig())
+	if err != nil {
+		fatal("Error opening job stream", "error", err)
+	}
+	defer q.Close()
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "enqueue":
+			enqueueMailboxJobs(ctx, store, q, args[1:])
+		case "pending":
+			n, err := q.Pending(ctx)
+			if err != nil {
+				fatal("Error reading job stream", "error", err)
+			}
+			slog.Info("Jobs pending", "jobs", n)
+		default:
+			fatal(workerUsage)
+		}
+		return
+	}
+
+	slog.Info("Worker started", "workers", pipelineWorkers)
+	err = q.Consume(ctx, pipelineWorkers, func(ctx context.Context, job jobqueue.Job) error {
+		return processJob(ctx, store, ms, job)
+	})
+	ledger.save()
+	reportSkips()
+	closeEmitter()
+	if err != nil {
+		fatal("Error consuming jobs", "error", err)
+	}
+	slog.Info("Worker stopped")
+}
+
+// processJob processes the job's mailbox as a run would. A mailbox that no
+// longer exists fails the job for good; one whose token is unusable is
+// skipped, as a run skips it.
+func processJob(ctx context.Context, store db.Store, ms db.MailboxStore, job jobqueue.Job) error {
+	mb, err := ms.GetMailboxByID(ctx, job.MailboxID)
+	if errors.Is(err, db.ErrMailboxNotFound) {
+		return &jobqueue.PermanentError{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	if !usableMailbox(&mb) {
+		slog.Warn("Skipping mailbox with an expired token", "mailbox_id", mb.ID)
+		return nil
+	}
+	return processMailbox(ctx, cachedReads(store), mb, &timing.Recorder{})
+}
+
+// enqueueMailboxJobs adds a job for each mailbox ID in args, or for every
+// mailbox with --all.
+func enqueueMailboxJobs(ctx context.Context, store db.Store, q *jobqueue.Queue, args []string) {
+	if len(args) == 0 {
+		fatal(workerUsage)
+	}
+	var ids []int
+	if args[0] == "--all" {
+		mailboxes, err := store.AllMailboxes(ctx)
+		if err != nil {
+			fatal("Error retrieving mailboxes", "error", err)
+		}
+		for mb := range mailboxes {
+			ids = append(ids, mb.ID)
+		}
+	} else {
+		for _, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				fatal("Invalid mailbox ID", "mailbox_id", arg, "error", err)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	started := time.Now()
+	for _, id := range ids {
+		if err := q.Enqueue(ctx, id); err != nil {
+			fatal("Error enqueueing mailbox", "mailbox_id", id, "error", err)
+		}
+	}
+	slog.Info("Enqueued mailboxes", "mailboxes", len(ids), "duration", time.Since(started).Round(time.Millisecond))
+}